@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/buzzfeed/sso/internal/auth"
+)
+
+const configSchemaUsage = `usage: sso-auth config-schema
+
+Prints the JSON Schema of the environment variables sso-auth is configured with.
+`
+
+// configSchema runs the config-schema command, returning its exit code.
+func configSchema(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprint(os.Stderr, configSchemaUsage)
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(auth.ConfigurationSchema()); err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding schema: %s\n", err)
+		return 1
+	}
+	return 0
+}
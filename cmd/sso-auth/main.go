@@ -8,6 +8,7 @@ import (
 	"github.com/buzzfeed/sso/internal/auth"
 	"github.com/buzzfeed/sso/internal/pkg/httpserver"
 	"github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/winservice"
 )
 
 func init() {
@@ -15,31 +16,39 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config-schema" {
+		os.Exit(configSchema(os.Args[2:]))
+	}
+	os.Exit(winservice.Run("sso-authenticator", run))
+}
+
+// run runs the authenticator until it's shut down, returning its exit code.
+func run() int {
 	logger := logging.NewLogEntry()
 
 	config, err := auth.LoadConfig()
 	if err != nil {
 		logger.Error(err, "error loading in config from env vars")
-		os.Exit(1)
+		return 1
 	}
 
 	err = config.Validate()
 	if err != nil {
 		logger.Error(err, "error validating config")
-		os.Exit(1)
+		return 1
 	}
 
 	sc := config.MetricsConfig.StatsdConfig
 	statsdClient, err := auth.NewStatsdClient(sc.Host, sc.Port)
 	if err != nil {
 		logger.Error(err, "error creating statsd client")
-		os.Exit(1)
+		return 1
 	}
 
 	authMux, err := auth.NewAuthenticatorMux(config, statsdClient)
 	if err != nil {
 		logger.Error(err, "error creating new AuthenticatorMux")
-		os.Exit(1)
+		return 1
 	}
 	defer authMux.Stop()
 
@@ -57,4 +66,5 @@ func main() {
 	if err := httpserver.Run(s, config.ServerConfig.TimeoutConfig.Shutdown, logger); err != nil {
 		logger.WithError(err).Fatal("error running server")
 	}
+	return 0
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/buzzfeed/sso/internal/pkg/jsonschema"
+	"github.com/buzzfeed/sso/internal/proxy"
+)
+
+const configSchemaUsage = `usage: sso-proxy config-schema <env|upstream-configs>
+
+Prints the JSON Schema of the environment variables sso-proxy is configured with, or
+of the upstream configs file.
+`
+
+// configSchema runs the config-schema command, returning its exit code.
+func configSchema(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprint(os.Stderr, configSchemaUsage)
+		return 2
+	}
+
+	var schema *jsonschema.Schema
+	switch args[0] {
+	case "env":
+		schema = proxy.OptionsSchema()
+	case "upstream-configs":
+		schema = proxy.UpstreamConfigsSchema()
+	default:
+		fmt.Fprint(os.Stderr, configSchemaUsage)
+		return 2
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(schema); err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding schema: %s\n", err)
+		return 1
+	}
+	return 0
+}
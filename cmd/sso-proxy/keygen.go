@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/buzzfeed/sso/internal/proxy"
+)
+
+const keygenUsage = `usage: sso-proxy keygen [-type cookie|rsa|ecdsa|ed25519] [-out <file>]
+
+Generates a cookie secret for COOKIE_SECRET, or a PEM encoded signing key for
+REQUEST_SIGNATURE_KEY, printing it or writing it to a file. The public key of a signing key
+is printed after it, or written to the file with a .pub suffix.
+`
+
+// keygen runs the keygen command, returning its exit code.
+func keygen(args []string) int {
+	flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), keygenUsage)
+		flags.PrintDefaults()
+	}
+	keyType := flags.String("type", proxy.KeyTypeCookie, "the type of key to generate: cookie, rsa, ecdsa, or ed25519")
+	out := flags.String("out", "", "the file to write the key to, instead of printing it")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	key, err := proxy.GenerateKey(*keyType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error generating key: %s\n", err)
+		return 1
+	}
+
+	if *out == "" {
+		if key.PublicKey == nil {
+			fmt.Println(string(key.Secret))
+			return 0
+		}
+		os.Stdout.Write(key.Secret)
+		os.Stdout.Write(key.PublicKey)
+		fmt.Printf("key id: %s\n", key.KeyID)
+		return 0
+	}
+
+	// the secret is only readable by its owner
+	if err := ioutil.WriteFile(*out, key.Secret, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing key: %s\n", err)
+		return 1
+	}
+	if key.PublicKey != nil {
+		if err := ioutil.WriteFile(*out+".pub", key.PublicKey, 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing public key: %s\n", err)
+			return 1
+		}
+		fmt.Printf("key id: %s\n", key.KeyID)
+	}
+	return 0
+}
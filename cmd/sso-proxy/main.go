@@ -2,64 +2,59 @@ package main
 
 import (
 	"fmt"
-	"net/http"
 	"os"
-	"time"
+	"strings"
 
-	"github.com/kelseyhightower/envconfig"
-
-	"github.com/buzzfeed/sso/internal/pkg/httpserver"
 	"github.com/buzzfeed/sso/internal/pkg/logging"
-	"github.com/buzzfeed/sso/internal/proxy"
-	"github.com/buzzfeed/sso/internal/proxy/collector"
 )
 
 func init() {
 	logging.SetServiceName("sso-proxy")
 }
 
-func main() {
-	logger := logging.NewLogEntry()
-
-	opts := proxy.NewOptions()
-	err := envconfig.Process("", opts)
-	if err != nil {
-		logger.Error(err, "error parsing env vars into options")
-		os.Exit(1)
-	}
-
-	err = opts.Validate()
-	if err != nil {
-		logger.Error(err, "error validating options")
-		os.Exit(1)
-	}
-
-	// we setup a runtime collector to emit stats
-	go func() {
-		c := collector.New(opts.StatsdClient, 30*time.Second)
-		c.Run()
-	}()
+const usage = `usage: sso-proxy [command] [flags]
+
+Commands:
+  serve            serve the configured upstreams, the default command
+  validate         check the configuration and upstream configs, without serving them
+  routes           print the routes the upstream configs are compiled to
+  version          print the version and build metadata
+  keygen           generate a cookie secret or a request signing key
+  migrate-config   upgrade an upstream configs file to the latest version
+  config-schema    print the JSON Schema of the configuration
+  load-test        replay synthetic authenticated traffic against a running sso-proxy
+
+sso-proxy is configured by environment variables. Run sso-proxy <command> -h for the flags of
+a command.
+`
+
+// commands are the commands of sso-proxy, run by the name given as its first argument.
+var commands = map[string]func(args []string) int{
+	"serve":          serve,
+	"validate":       validate,
+	"routes":         routes,
+	"version":        version,
+	"keygen":         keygen,
+	"migrate-config": migrateConfig,
+	"config-schema":  configSchema,
+	"load-test":      loadTest,
+}
 
-	ssoProxy, err := proxy.New(opts)
-	if err != nil {
-		logger.Error(err, "error creating sso proxy")
-		os.Exit(1)
+func main() {
+	// without a command, sso-proxy serves its upstreams, as it did before it had commands
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	loggingHandler := proxy.NewLoggingHandler(os.Stdout,
-		ssoProxy,
-		opts.RequestLogging,
-		opts.StatsdClient,
-	)
-
-	s := &http.Server{
-		Addr:         fmt.Sprintf(":%d", opts.Port),
-		ReadTimeout:  opts.TCPReadTimeout,
-		WriteTimeout: opts.TCPWriteTimeout,
-		Handler:      loggingHandler,
+	if name == "help" {
+		fmt.Fprint(os.Stdout, usage)
+		os.Exit(0)
 	}
-
-	if err := httpserver.Run(s, opts.ShutdownTimeout, logger); err != nil {
-		logger.WithError(err).Fatal("error running server")
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
 	}
+	os.Exit(command(args))
 }
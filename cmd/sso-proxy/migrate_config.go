@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/buzzfeed/sso/internal/proxy"
+)
+
+const migrateConfigUsage = `usage: sso-proxy migrate-config [-w] <upstream configs file>
+
+Upgrades an upstream configs file to the latest version, printing the result.
+`
+
+// migrateConfig runs the migrate-config command, returning its exit code.
+func migrateConfig(args []string) int {
+	flags := flag.NewFlagSet("migrate-config", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), migrateConfigUsage)
+		flags.PrintDefaults()
+	}
+	write := flags.Bool("w", false, "write the result to the file instead of printing it")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading upstream configs: %s\n", err)
+		return 1
+	}
+
+	migrated, err := proxy.MigrateUpstreamConfigs(raw)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error migrating upstream configs: %s\n", err)
+		return 1
+	}
+
+	if !*write {
+		os.Stdout.Write(migrated)
+		return 0
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error writing upstream configs: %s\n", err)
+		return 1
+	}
+	if err := ioutil.WriteFile(path, migrated, info.Mode()); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing upstream configs: %s\n", err)
+		return 1
+	}
+	return 0
+}
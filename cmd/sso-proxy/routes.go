@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/buzzfeed/sso/internal/proxy"
+)
+
+const routesUsage = `usage: sso-proxy routes [-format table|json]
+
+Prints the routes the upstream configs sso-proxy is configured with are compiled to, as the
+admin API lists them.
+`
+
+// routes runs the routes command, returning its exit code.
+func routes(args []string) int {
+	flags := flag.NewFlagSet("routes", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), routesUsage)
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", "table", "the format of the routes, table or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 || (*format != "table" && *format != "json") {
+		flags.Usage()
+		return 2
+	}
+
+	opts, err := loadOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := proxy.WriteRouteTable(os.Stdout, opts, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing routes: %s\n", err)
+		return 1
+	}
+	return 0
+}
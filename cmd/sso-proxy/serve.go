@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/buzzfeed/sso/internal/pkg/httpserver"
+	"github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/winservice"
+	"github.com/buzzfeed/sso/internal/proxy"
+	"github.com/buzzfeed/sso/internal/proxy/collector"
+	"github.com/buzzfeed/sso/internal/proxy/extauthz"
+)
+
+// serve runs the serve command, returning its exit code once the server is shut down.
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	return winservice.Run("sso-proxy", runProxy)
+}
+
+// runProxy runs the proxy until it's shut down, returning its exit code.
+func runProxy() int {
+	logger := logging.NewLogEntry()
+
+	opts := proxy.NewOptions()
+	err := envconfig.Process("", opts)
+	if err != nil {
+		logger.Error(err, "error parsing env vars into options")
+		return 1
+	}
+
+	err = opts.Validate()
+	if err != nil {
+		logger.Error(err, "error validating options")
+		return 1
+	}
+
+	// we setup a runtime collector to emit stats
+	go func() {
+		c := collector.New(opts.StatsdClient, 30*time.Second)
+		c.Run()
+	}()
+
+	ssoProxy, err := proxy.New(opts)
+	if err != nil {
+		logger.Error(err, "error creating sso proxy")
+		return 1
+	}
+
+	err = proxy.RunStartupChecks(opts)
+	if err != nil {
+		logger.Error(err, "error running startup checks")
+		return 1
+	}
+
+	loggingHandler := proxy.NewLoggingHandler(os.Stdout,
+		ssoProxy,
+		opts.RequestLogging,
+		opts.StatsdClient,
+	)
+
+	s := &http.Server{
+		Addr:         fmt.Sprintf(":%d", opts.Port),
+		ReadTimeout:  opts.TCPReadTimeout,
+		WriteTimeout: opts.TCPWriteTimeout,
+		Handler:      loggingHandler,
+	}
+	// the admin API keeps serving while the server shuts down, so that the drain status it
+	// reports can be polled until the process exits
+	s.RegisterOnShutdown(func() {
+		ssoProxy.StartDrain(time.Now().Add(opts.ShutdownTimeout))
+	})
+
+	// every socket is bound before the proxy drops its privileges, and before it tells systemd
+	// that it's ready
+	var extAuthzListener, adminListener net.Listener
+	if opts.ExtAuthzPort != 0 {
+		extAuthzListener, err = httpserver.Listen("ext-authz", fmt.Sprintf(":%d", opts.ExtAuthzPort))
+		if err != nil {
+			logger.Error(err, "error listening for external authorization")
+			return 1
+		}
+	}
+	if opts.AdminPort != 0 {
+		adminListener, err = httpserver.Listen("admin", fmt.Sprintf(":%d", opts.AdminPort))
+		if err != nil {
+			logger.Error(err, "error listening for the admin API")
+			return 1
+		}
+	}
+	ln, err := httpserver.ListenServer(s)
+	if err != nil {
+		logger.Error(err, "error listening for requests")
+		return 1
+	}
+
+	if err := proxy.Harden(opts); err != nil {
+		logger.Error(err, "error hardening the proxy")
+		return 1
+	}
+
+	if extAuthzListener != nil {
+		go func() {
+			if err := extauthz.Serve(extAuthzListener, ssoProxy.AuthorizeHandler, logger); err != nil {
+				logger.WithError(err).Fatal("error running external authorization server")
+			}
+		}()
+	}
+
+	if adminListener != nil {
+		go func() {
+			admin := &http.Server{
+				Handler:   ssoProxy.AdminHandler,
+				TLSConfig: ssoProxy.AdminTLSConfig,
+			}
+			var err error
+			if admin.TLSConfig != nil {
+				// the certificate is the proxy's SVID, set by the TLS config
+				err = admin.ServeTLS(adminListener, "", "")
+			} else {
+				err = admin.Serve(adminListener)
+			}
+			if err != nil {
+				logger.WithError(err).Fatal("error running admin server")
+			}
+		}()
+	}
+
+	if err := httpserver.Serve(ln, s, opts.ShutdownTimeout, logger); err != nil {
+		logger.WithError(err).Fatal("error running server")
+	}
+	return 0
+}
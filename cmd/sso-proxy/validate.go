@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kelseyhightower/envconfig"
+
+	"github.com/buzzfeed/sso/internal/proxy"
+)
+
+const validateUsage = `usage: sso-proxy validate
+
+Checks the options sso-proxy is configured with by its environment, and loads its upstream
+configs, without serving them or calling any external service, exiting non-zero if they're
+invalid. Upstream configs read from a git repository are only loaded once it's cloned, when
+the proxy starts.
+`
+
+// validate runs the validate command, returning its exit code.
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), validateUsage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	if _, err := loadOptions(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("configuration is valid")
+	return 0
+}
+
+// loadOptions returns the validated options sso-proxy is configured with by its environment.
+func loadOptions() (*proxy.Options, error) {
+	opts := proxy.NewOptions()
+	if err := envconfig.Process("", opts); err != nil {
+		return nil, fmt.Errorf("error parsing env vars into options: %s", err)
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("error validating options: %s", err)
+	}
+	return opts, nil
+}
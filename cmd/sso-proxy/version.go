@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/buzzfeed/sso/internal/proxy"
+)
+
+// The build metadata of sso-proxy, set by the -X linker flags of the Makefile's builds.
+var (
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// version runs the version command, returning its exit code.
+func version(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "usage: sso-proxy version")
+		return 2
+	}
+	fmt.Printf("sso-proxy %s\n", proxy.VERSION)
+	fmt.Printf("commit: %s\n", commit)
+	fmt.Printf("built: %s\n", buildDate)
+	fmt.Printf("go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
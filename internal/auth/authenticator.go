@@ -36,6 +36,9 @@ type Authenticator struct {
 
 	AuthCodeCipher aead.Cipher
 
+	// groupChangeSecret verifies notifications of group changes, which are refused without it
+	groupChangeSecret []byte
+
 	ProxyClientID     string
 	ProxyClientSecret string
 
@@ -48,10 +51,12 @@ type Authenticator struct {
 }
 
 type redeemResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int64  `json:"expires_in"`
-	Email        string `json:"email"`
+	AccessToken  string                 `json:"access_token"`
+	RefreshToken string                 `json:"refresh_token"`
+	ExpiresIn    int64                  `json:"expires_in"`
+	Email        string                 `json:"email"`
+	Scopes       []string               `json:"scopes,omitempty"`
+	Claims       map[string]interface{} `json:"claims,omitempty"`
 }
 
 type refreshResponse struct {
@@ -114,6 +119,7 @@ func (p *Authenticator) newMux() http.Handler {
 	serviceMux.HandleFunc("/validate", p.withMethods(p.validateClientID(p.validateClientSecret(p.ValidateToken)), "GET"))
 	serviceMux.HandleFunc("/redeem", p.withMethods(p.validateClientID(p.validateClientSecret(p.Redeem)), "POST"))
 	serviceMux.HandleFunc("/refresh", p.withMethods(p.validateClientID(p.validateClientSecret(p.Refresh)), "POST"))
+	serviceMux.HandleFunc("/group_changes", p.withMethods(p.GroupChanges, "POST"))
 
 	return setHeaders(serviceMux)
 }
@@ -257,19 +263,47 @@ func (p *Authenticator) SignIn(rw http.ResponseWriter, req *http.Request) {
 		"action:sign_in",
 		fmt.Sprintf("proxy_host:%s", proxyHost),
 	}
+	// A silent sign in, see https://openid.net/specs/openid-connect-core-1_0.html#AuthRequest,
+	// must not show the user anything, so it is answered with an error wherever the user would
+	// have to interact with the sign in page or the provider
+	silent := req.URL.Query().Get("prompt") == "none"
+
 	session, err := p.authenticate(rw, req)
 	switch err {
 	case nil:
+		// The upstream requires scopes the user hasn't granted yet, so we request consent to
+		// them from the provider before redirecting back to the proxy application
+		if missing := session.MissingScopes(requiredScopes(req.URL)); len(missing) != 0 {
+			if silent {
+				p.silentSignInFailed(rw, req, "consent_required", tags)
+				return
+			}
+			p.StatsdClient.Incr("incremental_consent", tags, 1.0)
+			http.Redirect(rw, req, p.startURL(req).String(), http.StatusFound)
+			return
+		}
 		// User is authenticated, redirect back to the proxy application
 		// with the necessary state
 		p.ProxyOAuthRedirect(rw, req, session, tags)
 	case http.ErrNoCookie:
+		if silent {
+			p.silentSignInFailed(rw, req, "login_required", tags)
+			return
+		}
 		p.SignInPage(rw, req, http.StatusOK)
 	case providers.ErrTokenRevoked:
 		p.sessionStore.ClearSession(rw, req)
+		if silent {
+			p.silentSignInFailed(rw, req, "login_required", tags)
+			return
+		}
 		p.SignInPage(rw, req, http.StatusOK)
 	case sessions.ErrLifetimeExpired, sessions.ErrInvalidSession:
 		p.sessionStore.ClearSession(rw, req)
+		if silent {
+			p.silentSignInFailed(rw, req, "login_required", tags)
+			return
+		}
 		p.SignInPage(rw, req, http.StatusOK)
 	default:
 		tags = append(tags, "error:sign_in_error")
@@ -278,6 +312,46 @@ func (p *Authenticator) SignIn(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// silentSignInFailed redirects a silent sign in back to sso proxy's redirection endpoint with
+// an error, rather than a code, telling it the user must sign in interactively.
+func (p *Authenticator) silentSignInFailed(rw http.ResponseWriter, req *http.Request, errorCode string, tags []string) {
+	p.StatsdClient.Incr("silent_sign_in_failed", append(tags, fmt.Sprintf("error:%s", errorCode)), 1.0)
+
+	redirectURL, err := url.Parse(req.URL.Query().Get("redirect_uri"))
+	if err != nil || redirectURL.Host == "" {
+		p.ErrorResponse(rw, req, "malformed redirect_uri parameter passed", http.StatusBadRequest)
+		return
+	}
+	params := redirectURL.Query()
+	params.Set("error", errorCode)
+	params.Set("state", req.URL.Query().Get("state"))
+	redirectURL.RawQuery = params.Encode()
+	redirectURL.Scheme = p.Scheme
+
+	http.Redirect(rw, req, redirectURL.String(), http.StatusFound)
+}
+
+// requiredScopes returns the additional scopes required by the upstream a sign in request was
+// made for, see the scopes option of sso_proxy's upstreams.
+func requiredScopes(signInURL *url.URL) []string {
+	return strings.Fields(signInURL.Query().Get("required_scopes"))
+}
+
+// startURL returns the URL that starts the oauth flow, which redirects back to the sign in
+// request once the user has authenticated with the provider.
+func (p *Authenticator) startURL(req *http.Request) *url.URL {
+	// as with the sign in page, the URLs are built from the known callback URL rather than
+	// from req.Host
+	signInURL := p.redirectURL.ResolveReference(&url.URL{
+		Path:     strings.TrimPrefix(req.URL.Path, "/"),
+		RawQuery: req.URL.RawQuery,
+	})
+	return p.redirectURL.ResolveReference(&url.URL{
+		Path:     "start",
+		RawQuery: url.Values{"redirect_uri": {signInURL.String()}}.Encode(),
+	})
+}
+
 // ProxyOAuthRedirect redirects the user back to sso proxy's redirection endpoint.
 func (p *Authenticator) ProxyOAuthRedirect(rw http.ResponseWriter, req *http.Request, session *sessions.SessionState, tags []string) {
 	// This workflow corresponds to Section 3.1.2 of the OAuth2 RFC.
@@ -488,7 +562,7 @@ func (p *Authenticator) OAuthStart(rw http.ResponseWriter, req *http.Request) {
 	}
 	redirectURI := p.GetRedirectURI(req.Host)
 	state := base64.URLEncoding.EncodeToString([]byte(fmt.Sprintf("%v:%v", nonce, authRedirectURL.String())))
-	signInURL := p.provider.GetSignInURL(redirectURI, state)
+	signInURL := p.provider.GetSignInURL(redirectURI, state, requiredScopes(authRedirectURL)...)
 	http.Redirect(rw, req, signInURL, http.StatusFound)
 }
 
@@ -578,6 +652,14 @@ func (p *Authenticator) getOAuthCallback(rw http.ResponseWriter, req *http.Reque
 		return "", HTTPError{Code: http.StatusForbidden, Message: "Invalid Redirect URI"}
 	}
 
+	// Providers may only report the scopes they granted when they differ from those requested,
+	// see https://tools.ietf.org/html/rfc6749#section-5.1
+	if len(session.Scopes) == 0 {
+		if redirectURL, err := url.Parse(redirect); err == nil {
+			session.Scopes = append(strings.Fields(p.provider.Data().Scope), requiredScopes(redirectURL)...)
+		}
+	}
+
 	// Set cookie, or deny: The authenticator validates the session email and group
 	// - for p.Validator see validator.go#newValidatorImpl for more info
 	// - for p.provider.ValidateGroup see providers/google.go#ValidateGroup for more info
@@ -676,6 +758,8 @@ func (p *Authenticator) Redeem(rw http.ResponseWriter, req *http.Request) {
 		RefreshToken: session.RefreshToken,
 		ExpiresIn:    int64(session.RefreshDeadline.Sub(time.Now()).Seconds()),
 		Email:        session.Email,
+		Scopes:       session.Scopes,
+		Claims:       session.Claims,
 	}
 
 	jsonBytes, err := json.Marshal(response)
@@ -476,6 +476,138 @@ func TestSignIn(t *testing.T) {
 	}
 }
 
+func TestSignInIncrementalConsent(t *testing.T) {
+	testCases := []struct {
+		name             string
+		scopes           []string
+		expectedLocation string
+	}{
+		{
+			name:             "missing scopes start the oauth flow",
+			expectedLocation: "example.com/start",
+		},
+		{
+			name:             "granted scopes redirect to the proxy",
+			scopes:           []string{"calendar.readonly"},
+			expectedLocation: "foo.example.com",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := &sessions.SessionState{
+				Email:            "email",
+				AccessToken:      "accesstoken",
+				RefreshToken:     "refresh",
+				LifetimeDeadline: time.Now().Add(time.Hour),
+				RefreshDeadline:  time.Now().Add(time.Hour),
+				Scopes:           tc.scopes,
+			}
+			config := testConfiguration(t)
+			auth, err := NewAuthenticator(config,
+				SetValidators([]options.Validator{options.NewMockValidator(true)}),
+				setMockSessionStore(&sessions.MockSessionStore{Session: session}),
+				setMockTempl(),
+				setMockRedirectURL(),
+				setMockAuthCodeCipher(&aead.MockCipher{MarshalString: "abcdefg"}, nil),
+			)
+			testutil.Ok(t, err)
+			provider := providers.NewTestProvider(nil)
+			provider.ValidToken = true
+			auth.provider = provider
+
+			signInURL := "http://example.com/?redirect_uri=http%3A%2F%2Ffoo.example.com&required_scopes=calendar.readonly&state=state"
+			rw := httptest.NewRecorder()
+			auth.SignIn(rw, httptest.NewRequest("GET", signInURL, nil))
+
+			testutil.Equal(t, http.StatusFound, rw.Code)
+			location, err := url.Parse(rw.Header().Get("Location"))
+			testutil.Ok(t, err)
+			testutil.Equal(t, tc.expectedLocation, location.Host+location.Path)
+			if tc.scopes == nil {
+				// the oauth flow redirects back to the sign in request once consent is given
+				testutil.Equal(t, signInURL, location.Query().Get("redirect_uri"))
+			}
+		})
+	}
+}
+
+func TestSignInSilent(t *testing.T) {
+	testCases := []struct {
+		name             string
+		session          *sessions.SessionState
+		loadError        error
+		query            string
+		expectedLocation string
+		expectedError    string
+	}{
+		{
+			name:          "no session requires login",
+			loadError:     http.ErrNoCookie,
+			expectedError: "login_required",
+		},
+		{
+			name: "expired session requires login",
+			session: &sessions.SessionState{
+				Email:            "email",
+				LifetimeDeadline: time.Now().Add(-time.Hour),
+				RefreshDeadline:  time.Now().Add(time.Hour),
+			},
+			expectedError: "login_required",
+		},
+		{
+			name: "missing scopes require consent",
+			session: &sessions.SessionState{
+				Email:            "email",
+				LifetimeDeadline: time.Now().Add(time.Hour),
+				RefreshDeadline:  time.Now().Add(time.Hour),
+			},
+			query:         "&required_scopes=calendar.readonly",
+			expectedError: "consent_required",
+		},
+		{
+			name: "valid session redirects with a code",
+			session: &sessions.SessionState{
+				Email:            "email",
+				LifetimeDeadline: time.Now().Add(time.Hour),
+				RefreshDeadline:  time.Now().Add(time.Hour),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testConfiguration(t)
+			auth, err := NewAuthenticator(config,
+				SetValidators([]options.Validator{options.NewMockValidator(true)}),
+				setMockSessionStore(&sessions.MockSessionStore{Session: tc.session, LoadError: tc.loadError}),
+				setMockTempl(),
+				setMockRedirectURL(),
+				setMockAuthCodeCipher(&aead.MockCipher{MarshalString: "abcdefg"}, nil),
+			)
+			testutil.Ok(t, err)
+			provider := providers.NewTestProvider(nil)
+			provider.ValidToken = true
+			auth.provider = provider
+
+			signInURL := "http://example.com/?prompt=none&redirect_uri=http%3A%2F%2Ffoo.example.com%2Foauth2%2Fcallback&state=state" + tc.query
+			rw := httptest.NewRecorder()
+			auth.SignIn(rw, httptest.NewRequest("GET", signInURL, nil))
+
+			// silent sign ins never show the sign in page, they always redirect back to the proxy
+			testutil.Equal(t, http.StatusFound, rw.Code)
+			location, err := url.Parse(rw.Header().Get("Location"))
+			testutil.Ok(t, err)
+			testutil.Equal(t, "foo.example.com/oauth2/callback", location.Host+location.Path)
+			testutil.Equal(t, "state", location.Query().Get("state"))
+			testutil.Equal(t, tc.expectedError, location.Query().Get("error"))
+			if tc.expectedError == "" {
+				testutil.Equal(t, "abcdefg", location.Query().Get("code"))
+			}
+		})
+	}
+}
+
 func TestSignOutPage(t *testing.T) {
 	testCases := []struct {
 		Name                string
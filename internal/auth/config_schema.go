@@ -0,0 +1,14 @@
+package auth
+
+import (
+	"github.com/buzzfeed/sso/internal/pkg/jsonschema"
+)
+
+// ConfigurationSchema returns the JSON Schema of the environment variables the
+// Configuration is loaded from, with the defaults of DefaultAuthConfig.
+func ConfigurationSchema() *jsonschema.Schema {
+	schema := jsonschema.NestedEnv(DefaultAuthConfig(), "mapstructure")
+	schema.Schema = jsonschema.Draft
+	schema.Title = "sso-authenticator environment"
+	return schema
+}
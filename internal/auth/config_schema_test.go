@@ -0,0 +1,18 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/jsonschema"
+)
+
+func TestConfigurationSchema(t *testing.T) {
+	schema := ConfigurationSchema()
+
+	assertEq(&jsonschema.Schema{Type: "string", Pattern: jsonschema.IntPattern, Default: "8125"}, schema.Properties["METRICS_STATSD_PORT"], t)
+	assertEq(&jsonschema.Schema{Type: "string", Pattern: jsonschema.IntPattern, Default: "4180"}, schema.Properties["SERVER_PORT"], t)
+	assertEq(&jsonschema.Schema{Type: "string", Pattern: jsonschema.DurationPattern, Default: "168h0m0s"},
+		schema.Properties["SESSION_COOKIE_EXPIRE"], t)
+	assertEq(&jsonschema.Schema{Type: "string"}, schema.PatternProperties["^PROVIDER_[A-Z0-9]+_TYPE$"], t)
+	assertEq(&jsonschema.Schema{Type: "string"}, schema.PatternProperties["^CLIENT_[A-Z0-9]+_SECRET$"], t)
+}
@@ -6,6 +6,11 @@ import (
 	"os"
 	"time"
 
+	"github.com/buzzfeed/sso/internal/auth/providers"
+	"github.com/buzzfeed/sso/internal/pkg/alerting"
+	"github.com/buzzfeed/sso/internal/pkg/fips"
+	"github.com/buzzfeed/sso/internal/pkg/groups"
+
 	"github.com/micro/go-micro/config"
 	"github.com/micro/go-micro/config/source/env"
 	"github.com/mitchellh/mapstructure"
@@ -20,6 +25,7 @@ import (
 // SESSION_COOKIE_SECRET
 // SESSION_COOKIE_EXPIRE
 // SESSION_COOKIE_DOMAIN
+// SESSION_COOKIE_DERIVEDOMAIN
 // SESSION_COOKIE_REFRESH
 // SESSION_COOKIE_SECURE
 // SESSION_COOKIE_HTTPONLY
@@ -39,6 +45,7 @@ import (
 // PROVIDER_*_GOOGLE_IMPERSONATE
 // PROVIDER_*_GOOGLE_PROMPT
 // PROVIDER_*_GOOGLE_DOMAIN
+// PROVIDER_*_GOOGLE_GROUPDEPTH
 //
 // PROVIDER_*_OKTA_URL
 // PROVIDER_*_OKTA_SERVER
@@ -49,8 +56,18 @@ import (
 // PROVIDER_*_COGNITO_CREDENTIALS_ID
 // PROVIDER_*_COGNITO_CREDENTIALS_SECRET
 //
+// PROVIDER_*_DRIVER_NAME
+// PROVIDER_*_DRIVER_ADDRESS
+// PROVIDER_*_DRIVER_PLUGIN
+//
 // PROVIDER_*_GROUPCACHE_INTERVAL_REFRESH
 // PROVIDER_*_GROUPCACHE_INTERVAL_PROVIDER
+// PROVIDER_*_GROUPCACHE_WEBHOOKSECRET
+//
+// PROVIDER_*_GROUPS_MAP
+// PROVIDER_*_GROUPS_REWRITE
+// PROVIDER_*_GROUPS_PREFIX
+// PROVIDER_*_GROUPS_LOWERCASE
 //
 // SERVER_SCHEME
 // SERVER_HOST
@@ -69,6 +86,17 @@ import (
 //
 // LOGGING_ENABLE
 // LOGGING_LEVEL
+//
+// ALERT_TYPE
+// ALERT_URL
+// ALERT_ROUTINGKEY
+// ALERT_COOLDOWN
+// ALERT_ERRORRATE_THRESHOLD
+// ALERT_ERRORRATE_WINDOW
+// ALERT_ERRORRATE_MINREQUESTS
+// ALERT_HEALTHCHECK_INTERVAL
+//
+// FIPS_MODE
 
 func DefaultAuthConfig() Configuration {
 	return Configuration{
@@ -111,6 +139,14 @@ func DefaultAuthConfig() Configuration {
 				Host: "localhost",
 			},
 		},
+		AlertConfig: AlertConfig{
+			Cooldown: 15 * time.Minute,
+			ErrorRateConfig: ErrorRateConfig{
+				Threshold:   0.5,
+				Window:      5 * time.Minute,
+				MinRequests: 20,
+			},
+		},
 		// we provide no defaults for these right now
 		AuthorizeConfig: AuthorizeConfig{
 			EmailConfig: EmailConfig{
@@ -141,10 +177,15 @@ var (
 	_ Validator = GoogleProviderConfig{}
 	_ Validator = OktaProviderConfig{}
 	_ Validator = AmazonCognitoProviderConfig{}
+	_ Validator = DriverProviderConfig{}
+	_ Validator = GroupsConfig{}
 	_ Validator = CookieConfig{}
 	_ Validator = TimeoutConfig{}
 	_ Validator = StatsdConfig{}
 	_ Validator = LoggingConfig{}
+	_ Validator = AlertConfig{}
+	_ Validator = ErrorRateConfig{}
+	_ Validator = FIPSConfig{}
 )
 
 // Configuration is the parent struct that holds all the configuration
@@ -155,8 +196,10 @@ type Configuration struct {
 	AuthorizeConfig  AuthorizeConfig           `mapstructure:"authorize"`
 	SessionConfig    SessionConfig             `mapstructure:"session"`
 	ServerConfig     ServerConfig              `mapstructure:"server"`
-	MetricsConfig    MetricsConfig             `mapstructrue:"metrics"`
+	MetricsConfig    MetricsConfig             `mapstructure:"metrics"`
 	LoggingConfig    LoggingConfig             `mapstructure:"logging"`
+	AlertConfig      AlertConfig               `mapstructure:"alert"`
+	FIPSConfig       FIPSConfig                `mapstructure:"fips"`
 }
 
 func (c Configuration) Validate() error {
@@ -188,6 +231,20 @@ func (c Configuration) Validate() error {
 		return xerrors.Errorf("invalid metrics config: %w", err)
 	}
 
+	if err := c.AlertConfig.Validate(); err != nil {
+		return xerrors.Errorf("invalid alert config: %w", err)
+	}
+
+	if c.FIPSConfig.Enabled() {
+		// AES-256-GCM, which takes the place of AES-CMAC-SIV, has 32 byte keys
+		if err := validateFIPSCipherKeyValue(c.SessionConfig.Key); err != nil {
+			return xerrors.Errorf("invalid session.key: %w", err)
+		}
+		if err := validateFIPSCipherKeyValue(c.SessionConfig.CookieConfig.Secret); err != nil {
+			return xerrors.Errorf("invalid session.cookie.secret: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -201,9 +258,13 @@ type ProviderConfig struct {
 	GoogleProviderConfig        GoogleProviderConfig        `mapstructure:"google"`
 	OktaProviderConfig          OktaProviderConfig          `mapstructure:"okta"`
 	AmazonCognitoProviderConfig AmazonCognitoProviderConfig `mapstructure:"cognito"`
+	DriverProviderConfig        DriverProviderConfig        `mapstructure:"driver"`
 
 	// caching
 	GroupCacheConfig GroupCacheConfig `mapstructure:"groupcache"`
+
+	// normalization of group names
+	GroupsConfig GroupsConfig `mapstructure:"groups"`
 }
 
 func (pc ProviderConfig) Validate() error {
@@ -233,6 +294,10 @@ func (pc ProviderConfig) Validate() error {
 		if err := pc.AmazonCognitoProviderConfig.Validate(); err != nil {
 			return xerrors.Errorf("invalid provider.cognito config: %w", err)
 		}
+	case "driver":
+		if err := pc.DriverProviderConfig.Validate(); err != nil {
+			return xerrors.Errorf("invalid provider.driver config: %w", err)
+		}
 	case "test":
 		break
 	default:
@@ -243,6 +308,10 @@ func (pc ProviderConfig) Validate() error {
 		return xerrors.Errorf("invalid provider.groupcache config: %w", err)
 	}
 
+	if err := pc.GroupsConfig.Validate(); err != nil {
+		return xerrors.Errorf("invalid provider.groups config: %w", err)
+	}
+
 	return nil
 }
 
@@ -251,14 +320,22 @@ type GoogleProviderConfig struct {
 	Impersonate    string `mapstructure:"impersonate"`
 	ApprovalPrompt string `mapstructure:"prompt"`
 	HostedDomain   string `mapstructure:"domain"`
+	GroupDepth     int    `mapstructure:"groupdepth"`
 }
 
+// maxGroupDepth limits how deeply nested groups are expanded, as each level may list many groups.
+const maxGroupDepth = 10
+
 func (gpc GoogleProviderConfig) Validate() error {
 	// must specify both credentials and impersonate or neither
 	if (len(gpc.Credentials) > 0) != (len(gpc.Impersonate) > 0) {
 		return xerrors.New("must specify both google.credentials and google.impersonate")
 	}
 
+	if gpc.GroupDepth < 0 || gpc.GroupDepth > maxGroupDepth {
+		return xerrors.Errorf("google.groupdepth must be between 1 and %d but is: %d", maxGroupDepth, gpc.GroupDepth)
+	}
+
 	// verify the credentials file can be opened
 	if gpc.Credentials != "" {
 		r, err := os.Open(gpc.Credentials)
@@ -315,6 +392,37 @@ func (acpc AmazonCognitoProviderConfig) Validate() error {
 	return nil
 }
 
+// DriverProviderConfig configures a provider driven by a provider driver, either served over
+// gRPC at an address, or loaded from a Go plugin.
+type DriverProviderConfig struct {
+	Name    string `mapstructure:"name"`
+	Address string `mapstructure:"address"`
+	Plugin  string `mapstructure:"plugin"`
+}
+
+func (dpc DriverProviderConfig) Validate() error {
+	if (dpc.Address == "") == (dpc.Plugin == "") {
+		return xerrors.New("must specify one of driver.address or driver.plugin")
+	}
+
+	if dpc.Address != "" {
+		if _, _, err := providers.ParseDriverAddress(dpc.Address); err != nil {
+			return xerrors.Errorf("invalid driver.address: %w", err)
+		}
+	}
+
+	// verify the plugin can be opened
+	if dpc.Plugin != "" {
+		r, err := os.Open(dpc.Plugin)
+		if err != nil {
+			return xerrors.Errorf("invalid driver.plugin filepath: %w", err)
+		}
+		r.Close()
+	}
+
+	return nil
+}
+
 type CognitoCredentials struct {
 	ID     string `mapstructure:"id"`
 	Secret string `mapstructure:"secret"`
@@ -332,8 +440,11 @@ func (cc CognitoCredentials) Validate() error {
 	return nil
 }
 
+// GroupCacheConfig configures how long group memberships are cached. When WebhookSecret is
+// set, notifications of group changes signed with it invalidate the cached memberships.
 type GroupCacheConfig struct {
 	CacheIntervalConfig CacheIntervalConfig `mapstructure:"interval"`
+	WebhookSecret       string              `mapstructure:"webhooksecret"`
 }
 
 type CacheIntervalConfig struct {
@@ -345,6 +456,29 @@ func (gcc GroupCacheConfig) Validate() error {
 	return nil
 }
 
+// GroupsConfig configures how the names of a provider's groups are normalized before they're
+// compared to allowed groups and passed to upstreams. Map and Rewrite are semicolon separated
+// lists of "name => normalized name" and "regexp => replacement" entries.
+type GroupsConfig struct {
+	Map       string   `mapstructure:"map"`
+	Rewrite   string   `mapstructure:"rewrite"`
+	Prefix    []string `mapstructure:"prefix"`
+	Lowercase bool     `mapstructure:"lowercase"`
+}
+
+func (gc GroupsConfig) Validate() error {
+	_, err := gc.Mapping()
+	return err
+}
+
+// Mapping returns the group mapping, or nil if group names aren't normalized.
+func (gc GroupsConfig) Mapping() (*groups.Mapping, error) {
+	if gc.Map == "" && gc.Rewrite == "" && len(gc.Prefix) == 0 && !gc.Lowercase {
+		return nil, nil
+	}
+	return groups.NewMapping(gc.Map, gc.Rewrite, gc.Prefix, gc.Lowercase)
+}
+
 type SessionConfig struct {
 	CookieConfig CookieConfig `mapstructure:"cookie"`
 
@@ -375,7 +509,7 @@ func (sc SessionConfig) Validate() error {
 func validateCipherKeyValue(val string) error {
 	s, err := base64.StdEncoding.DecodeString(val)
 	if err != nil {
-		return xerrors.Errorf("expected base64-encoded bytes, as from `openssl rand 32 -base64`: %w", err)
+		return xerrors.Errorf("expected base64-encoded bytes, as from `sso-proxy keygen -type cookie`: %w", err)
 	}
 
 	slen := len(s)
@@ -386,13 +520,28 @@ func validateCipherKeyValue(val string) error {
 	return nil
 }
 
+func validateFIPSCipherKeyValue(val string) error {
+	s, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return xerrors.Errorf("expected base64-encoded bytes, as from `sso-proxy keygen -type cookie`: %w", err)
+	}
+	if len(s) != 32 {
+		return xerrors.Errorf("expected to decode 32 base64-encoded bytes in FIPS mode, but decoded %d", len(s))
+	}
+	return nil
+}
+
 type CookieConfig struct {
-	Name     string        `mapstructure:"name"`
-	Secret   string        `mapstructure:"secret"`
-	Domain   string        `mapstructure:"domain"`
-	Expire   time.Duration `mapstructure:"expire"`
-	Secure   bool          `mapstructure:"secure"`
-	HTTPOnly bool          `mapstructure:"httponly"`
+	Name   string `mapstructure:"name"`
+	Secret string `mapstructure:"secret"`
+	Domain string `mapstructure:"domain"`
+	// DeriveDomain sets cookies on the registrable domain of each request's host, e.g.
+	// example.com for sso-auth.example.com, rather than on a static domain, so that one
+	// deployment can serve several root domains.
+	DeriveDomain bool          `mapstructure:"derivedomain"`
+	Expire       time.Duration `mapstructure:"expire"`
+	Secure       bool          `mapstructure:"secure"`
+	HTTPOnly     bool          `mapstructure:"httponly"`
 }
 
 func (cc CookieConfig) Validate() error {
@@ -414,6 +563,10 @@ func (cc CookieConfig) Validate() error {
 		return xerrors.Errorf("invalid cookie.secret: %w", err)
 	}
 
+	if cc.Domain != "" && cc.DeriveDomain {
+		return xerrors.New("cookie.domain and cookie.derivedomain can't both be configured")
+	}
+
 	return nil
 }
 
@@ -553,6 +706,89 @@ func (sc StatsdConfig) Validate() error {
 	return nil
 }
 
+// AlertConfig configures alerts about provider outages. Alerting is disabled unless a
+// Type is configured.
+type AlertConfig struct {
+	Type       string        `mapstructure:"type"`
+	URL        string        `mapstructure:"url"`
+	RoutingKey string        `mapstructure:"routingkey"`
+	Cooldown   time.Duration `mapstructure:"cooldown"`
+
+	ErrorRateConfig   ErrorRateConfig   `mapstructure:"errorrate"`
+	HealthCheckConfig HealthCheckConfig `mapstructure:"healthcheck"`
+}
+
+func (ac AlertConfig) Validate() error {
+	if ac.Type == "" {
+		return nil
+	}
+
+	switch ac.Type {
+	case alerting.DestinationSlack, alerting.DestinationWebhook:
+		if ac.URL == "" {
+			return xerrors.Errorf("no alert.url configured for %s alerts", ac.Type)
+		}
+	case alerting.DestinationPagerDuty:
+		if ac.RoutingKey == "" {
+			return xerrors.New("no alert.routingkey configured for pagerduty alerts")
+		}
+	default:
+		return xerrors.Errorf("unknown alert.type: %q", ac.Type)
+	}
+
+	if ac.Cooldown < 0 {
+		return xerrors.Errorf("invalid alert.cooldown: %v", ac.Cooldown)
+	}
+
+	if err := ac.ErrorRateConfig.Validate(); err != nil {
+		return xerrors.Errorf("invalid alert.errorrate config: %w", err)
+	}
+
+	return nil
+}
+
+// ErrorRateConfig configures the alert fired when the proportion of failed provider
+// requests within Window exceeds Threshold. Windows with fewer than MinRequests requests
+// are ignored, so that a handful of failures at a quiet time doesn't page anyone.
+type ErrorRateConfig struct {
+	Threshold   float64       `mapstructure:"threshold"`
+	Window      time.Duration `mapstructure:"window"`
+	MinRequests int           `mapstructure:"minrequests"`
+}
+
+func (erc ErrorRateConfig) Validate() error {
+	if erc.Threshold <= 0 || erc.Threshold > 1 {
+		return xerrors.Errorf("errorrate.threshold must be greater than 0 and at most 1 but is: %v", erc.Threshold)
+	}
+
+	if erc.Window <= 0 {
+		return xerrors.Errorf("invalid errorrate.window: %v", erc.Window)
+	}
+
+	return nil
+}
+
+// HealthCheckConfig configures periodic health checks of each provider. Health checks are
+// disabled when Interval is zero.
+// FIPSConfig restricts the ciphers and outbound TLS of sso-auth to FIPS-approved algorithms.
+// Binaries built with the fips build tag are always in FIPS mode.
+type FIPSConfig struct {
+	Mode bool `mapstructure:"mode"`
+}
+
+func (fc FIPSConfig) Validate() error {
+	return nil
+}
+
+// Enabled returns true if sso-auth runs in FIPS mode.
+func (fc FIPSConfig) Enabled() bool {
+	return fc.Mode || fips.Required
+}
+
+type HealthCheckConfig struct {
+	Interval time.Duration `mapstructure:"interval"`
+}
+
 // LoadConfig loads all the configuration from env and defaults
 func LoadConfig() (Configuration, error) {
 	c := DefaultAuthConfig()
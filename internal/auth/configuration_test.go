@@ -130,6 +130,20 @@ func TestEnvironmentOverridesConfiguration(t *testing.T) {
 				assertEq("foo-client-id", foo.ClientConfig.ID, t)
 			},
 		},
+		{
+			Name: "Test Provider Groups",
+			EnvOverrides: map[string]string{
+				"PROVIDER_FOO_GROUPS_MAP":       "CN=Engineering,OU=Groups,DC=example,DC=com => engineering",
+				"PROVIDER_FOO_GROUPS_PREFIX":    "okta-,sso:",
+				"PROVIDER_FOO_GROUPS_LOWERCASE": "true",
+			},
+			CheckFunc: func(c Configuration, t *testing.T) {
+				groups := c.ProviderConfigs["foo"].GroupsConfig
+				assertEq("CN=Engineering,OU=Groups,DC=example,DC=com => engineering", groups.Map, t)
+				assertEq([]string{"okta-", "sso:"}, groups.Prefix, t)
+				assertEq(true, groups.Lowercase, t)
+			},
+		},
 		{
 			Name: "Test Multiple Providers",
 			EnvOverrides: map[string]string{
@@ -172,6 +186,35 @@ func TestEnvironmentOverridesConfiguration(t *testing.T) {
 				assertEq([]string{"proxy.local", "root.local"}, c.AuthorizeConfig.EmailConfig.Domains, t)
 			},
 		},
+		{
+			Name: "Test Alert Overrides",
+			EnvOverrides: map[string]string{
+				"ALERT_TYPE":                 "pagerduty",
+				"ALERT_ROUTINGKEY":           "routing-key",
+				"ALERT_COOLDOWN":             "1h",
+				"ALERT_ERRORRATE_WINDOW":     "10m",
+				"ALERT_HEALTHCHECK_INTERVAL": "30s",
+			},
+			CheckFunc: func(c Configuration, t *testing.T) {
+				assertEq("pagerduty", c.AlertConfig.Type, t)
+				assertEq("routing-key", c.AlertConfig.RoutingKey, t)
+				assertEq(time.Hour, c.AlertConfig.Cooldown, t)
+				assertEq(10*time.Minute, c.AlertConfig.ErrorRateConfig.Window, t)
+				assertEq(0.5, c.AlertConfig.ErrorRateConfig.Threshold, t)
+				assertEq(30*time.Second, c.AlertConfig.HealthCheckConfig.Interval, t)
+			},
+		},
+		{
+			Name: "metrics configuration",
+			EnvOverrides: map[string]string{
+				"METRICS_STATSD_HOST": "statsd.local",
+				"METRICS_STATSD_PORT": "8125",
+			},
+			CheckFunc: func(c Configuration, t *testing.T) {
+				assertEq("statsd.local", c.MetricsConfig.StatsdConfig.Host, t)
+				assertEq(8125, c.MetricsConfig.StatsdConfig.Port, t)
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -264,6 +307,69 @@ func TestConfigValidate(t *testing.T) {
 			},
 			ExpectedErr: xerrors.New("no server.host configured"),
 		},
+		"cookie domain with derived domain configuration": {
+			Validator: CookieConfig{
+				Name:         "_sso_auth",
+				Secret:       "zaPX2fYMyegfOwwMEaMiphwrjgxz0pxoTbxvQiK9zBY=",
+				Domain:       "example.com",
+				DeriveDomain: true,
+			},
+			ExpectedErr: xerrors.New("cookie.domain and cookie.derivedomain can't both be configured"),
+		},
+		"disabled alert configuration": {
+			Validator:   AlertConfig{},
+			ExpectedErr: nil,
+		},
+		"slack alert configuration": {
+			Validator: AlertConfig{
+				Type:            "slack",
+				URL:             "https://hooks.slack.com/services/T0/B0/X",
+				ErrorRateConfig: ErrorRateConfig{Threshold: 0.5, Window: 5 * time.Minute},
+			},
+			ExpectedErr: nil,
+		},
+		"missing alert url configuration": {
+			Validator: AlertConfig{
+				Type:            "slack",
+				ErrorRateConfig: ErrorRateConfig{Threshold: 0.5, Window: 5 * time.Minute},
+			},
+			ExpectedErr: xerrors.New("no alert.url configured for slack alerts"),
+		},
+		"missing alert routing key configuration": {
+			Validator: AlertConfig{
+				Type:            "pagerduty",
+				ErrorRateConfig: ErrorRateConfig{Threshold: 0.5, Window: 5 * time.Minute},
+			},
+			ExpectedErr: xerrors.New("no alert.routingkey configured for pagerduty alerts"),
+		},
+		"unknown alert type configuration": {
+			Validator:   AlertConfig{Type: "email"},
+			ExpectedErr: xerrors.New(`unknown alert.type: "email"`),
+		},
+		"invalid error rate threshold configuration": {
+			Validator:   ErrorRateConfig{Threshold: 1.5, Window: 5 * time.Minute},
+			ExpectedErr: xerrors.New("errorrate.threshold must be greater than 0 and at most 1 but is: 1.5"),
+		},
+		"invalid google group depth configuration": {
+			Validator:   GoogleProviderConfig{GroupDepth: 11},
+			ExpectedErr: xerrors.New("google.groupdepth must be between 1 and 10 but is: 11"),
+		},
+		"driver address configuration": {
+			Validator:   DriverProviderConfig{Address: "unix:///run/sso/driver.sock"},
+			ExpectedErr: nil,
+		},
+		"missing driver configuration": {
+			Validator:   DriverProviderConfig{},
+			ExpectedErr: xerrors.New("must specify one of driver.address or driver.plugin"),
+		},
+		"remote driver address configuration": {
+			Validator:   DriverProviderConfig{Address: "tcp://10.0.0.1:9000"},
+			ExpectedErr: xerrors.New(`invalid driver.address: invalid provider driver address "tcp://10.0.0.1:9000": must be tcp://localhost:port or a loopback ip and port`),
+		},
+		"invalid groups map configuration": {
+			Validator:   GroupsConfig{Map: "engineering"},
+			ExpectedErr: xerrors.New(`invalid group mapping "engineering": expected a name, =>, and the name it's mapped to`),
+		},
 	}
 
 	for testName, tc := range testCases {
@@ -277,3 +383,29 @@ func TestConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateFIPSCipherKeyValue(t *testing.T) {
+	testCases := map[string]struct {
+		value   string
+		wantErr bool
+	}{
+		"32 bytes": {value: "zaPX2fYMyegfOwwMEaMiphwrjgxz0pxoTbxvQiK9zBY="},
+		"64 bytes": {
+			value:   "zaPX2fYMyegfOwwMEaMiphwrjgxz0pxoTbxvQiK9zBbNo9fZ2DLJ6B87DAwRoyKmHCuODHPSnGhNvG9CIr3MFg==",
+			wantErr: true,
+		},
+		"not base64": {value: "not base64!", wantErr: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := validateFIPSCipherKeyValue(tc.value)
+			if tc.wantErr && err == nil {
+				t.Errorf("expected an error")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
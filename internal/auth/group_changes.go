@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/webhook"
+)
+
+const (
+	// groupChangesMaxBodySize is the largest group change notification accepted.
+	groupChangesMaxBodySize = 1 << 20
+	// groupChangesMaxSkew is how far from now the timestamp of a group change notification may
+	// be, which bounds how long a captured notification can be replayed for.
+	groupChangesMaxSkew = 5 * time.Minute
+)
+
+// groupChanges is a notification that the group memberships of users changed, naming the
+// users, the groups, or both.
+type groupChanges struct {
+	Emails []string `json:"emails"`
+	Groups []string `json:"groups"`
+}
+
+// GroupChanges invalidates the cached group memberships of the users and groups a provider or
+// HR system notifies us have changed, so that they take effect the next time a proxy validates
+// a session, rather than once the cache expires. Notifications are signed like the webhooks
+// sso-proxy delivers: the X-SSO-Webhook-Signature header holds the HMAC-SHA256, keyed with the
+// group change secret, of the X-SSO-Webhook-Timestamp header, a period, and the body.
+func (p *Authenticator) GroupChanges(rw http.ResponseWriter, req *http.Request) {
+	logger := log.NewLogEntry()
+	tags := []string{"action:group_changes"}
+
+	if len(p.groupChangeSecret) == 0 {
+		http.NotFound(rw, req)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, req.Body, groupChangesMaxBodySize))
+	if err != nil {
+		p.ErrorResponse(rw, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timestamp := req.Header.Get(webhook.TimestampHeader)
+	if !validGroupChangesTimestamp(timestamp, time.Now()) ||
+		!webhook.Verify(p.groupChangeSecret, timestamp, body, req.Header.Get(webhook.SignatureHeader)) {
+		tags = append(tags, "error:invalid_signature")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		logger.WithRemoteAddress(getRemoteAddr(req)).Info("rejected group changes with an invalid signature")
+		p.ErrorResponse(rw, req, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	changes := groupChanges{}
+	if err := json.Unmarshal(body, &changes); err != nil {
+		p.ErrorResponse(rw, req, "invalid group changes", http.StatusBadRequest)
+		return
+	}
+	if len(changes.Emails) == 0 && len(changes.Groups) == 0 {
+		p.ErrorResponse(rw, req, "no emails or groups changed", http.StatusBadRequest)
+		return
+	}
+
+	p.provider.InvalidateGroups(changes.Emails, changes.Groups)
+	p.StatsdClient.Incr("group_changes", tags, 1.0)
+	logger.WithProvider(p.provider.Data().ProviderSlug).Info(
+		"invalidated group memberships of ", len(changes.Emails), " users and ", len(changes.Groups), " groups")
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// validGroupChangesTimestamp returns true if a timestamp of unix seconds is within
+// groupChangesMaxSkew of now.
+func validGroupChangesTimestamp(timestamp string, now time.Time) bool {
+	i, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(i, 0))
+	return skew <= groupChangesMaxSkew && skew >= -groupChangesMaxSkew
+}
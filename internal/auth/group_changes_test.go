@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/auth/providers"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/pkg/webhook"
+)
+
+func TestGroupChanges(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name               string
+		secret             string
+		body               string
+		timestamp          time.Time
+		signingSecret      string
+		expectedStatusCode int
+		expectedEmails     []string
+		expectedGroups     []string
+	}{
+		{
+			name:               "not served without a secret",
+			body:               `{"emails":["user@example.com"]}`,
+			timestamp:          now,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "invalid signature",
+			secret:             "secret",
+			body:               `{"emails":["user@example.com"]}`,
+			timestamp:          now,
+			signingSecret:      "wrong-secret",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "old timestamp",
+			secret:             "secret",
+			body:               `{"emails":["user@example.com"]}`,
+			timestamp:          now.Add(-10 * time.Minute),
+			signingSecret:      "secret",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "nothing changed",
+			secret:             "secret",
+			body:               `{}`,
+			timestamp:          now,
+			signingSecret:      "secret",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "groups invalidated",
+			secret:             "secret",
+			body:               `{"emails":["user@example.com"],"groups":["admins@example.com"]}`,
+			timestamp:          now,
+			signingSecret:      "secret",
+			expectedStatusCode: http.StatusNoContent,
+			expectedEmails:     []string{"user@example.com"},
+			expectedGroups:     []string{"admins@example.com"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testConfiguration(t)
+			provider := providers.NewTestProvider(nil)
+			p, err := NewAuthenticator(config, SetProvider(provider), SetGroupChangeSecret(tc.secret))
+			testutil.Ok(t, err)
+
+			timestamp := strconv.FormatInt(tc.timestamp.Unix(), 10)
+			req := httptest.NewRequest("POST", "/group_changes", strings.NewReader(tc.body))
+			req.Header.Set(webhook.TimestampHeader, timestamp)
+			req.Header.Set(webhook.SignatureHeader, webhook.Sign([]byte(tc.signingSecret), timestamp, []byte(tc.body)))
+			rw := httptest.NewRecorder()
+			p.ServeMux.ServeHTTP(rw, req)
+
+			testutil.Equal(t, tc.expectedStatusCode, rw.Code)
+			testutil.Equal(t, tc.expectedEmails, provider.InvalidatedEmails)
+			testutil.Equal(t, tc.expectedGroups, provider.InvalidatedGroups)
+		})
+	}
+}
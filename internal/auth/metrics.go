@@ -27,16 +27,17 @@ func NewStatsdClient(host string, port int) (*statsd.Client, error) {
 func GetActionTag(req *http.Request) string {
 	// only log metrics for these paths and actions
 	pathToAction := map[string]string{
-		"/robots.txt": "robots",
-		"/start":      "start",
-		"/sign_in":    "sign_in",
-		"/sign_out":   "sign_out",
-		"/callback":   "callback",
-		"/profile":    "profile",
-		"/validate":   "validate",
-		"/redeem":     "redeem",
-		"/refresh":    "refresh",
-		"/ping":       "ping",
+		"/robots.txt":    "robots",
+		"/start":         "start",
+		"/sign_in":       "sign_in",
+		"/sign_out":      "sign_out",
+		"/callback":      "callback",
+		"/profile":       "profile",
+		"/validate":      "validate",
+		"/redeem":        "redeem",
+		"/refresh":       "refresh",
+		"/ping":          "ping",
+		"/group_changes": "group_changes",
 	}
 	// get the action from the url path
 	path := req.URL.Path
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"net/http"
 
+	"github.com/buzzfeed/sso/internal/auth/providers"
+	"github.com/buzzfeed/sso/internal/pkg/alerting"
+	"github.com/buzzfeed/sso/internal/pkg/fips"
 	"github.com/buzzfeed/sso/internal/pkg/hostmux"
 	log "github.com/buzzfeed/sso/internal/pkg/logging"
 	"github.com/buzzfeed/sso/internal/pkg/options"
@@ -18,6 +21,11 @@ type AuthenticatorMux struct {
 
 func NewAuthenticatorMux(config Configuration, statsdClient *statsd.Client) (*AuthenticatorMux, error) {
 	logger := log.NewLogEntry()
+	if config.FIPSConfig.Enabled() {
+		fips.Enable()
+		providers.SetTLSConfig(fips.TLSConfig(nil))
+	}
+
 	validators := []options.Validator{}
 	if len(config.AuthorizeConfig.EmailConfig.Addresses) != 0 {
 		validators = append(validators, options.NewEmailAddressValidator(config.AuthorizeConfig.EmailConfig.Addresses))
@@ -25,6 +33,12 @@ func NewAuthenticatorMux(config Configuration, statsdClient *statsd.Client) (*Au
 		validators = append(validators, options.NewEmailDomainValidator(config.AuthorizeConfig.EmailConfig.Domains))
 	}
 
+	alerter, err := newAlerter(config.AlertConfig)
+	if err != nil {
+		logger.Error(err, "error creating alerter")
+		return nil, err
+	}
+
 	authenticators := []*Authenticator{}
 	idpMux := http.NewServeMux()
 
@@ -35,6 +49,13 @@ func NewAuthenticatorMux(config Configuration, statsdClient *statsd.Client) (*Au
 			return nil, err
 		}
 
+		if alerter != nil {
+			idp.Data().Alerter = alerter
+			erc := config.AlertConfig.ErrorRateConfig
+			idp = providers.NewAlertingProvider(idp, alerter, erc.Threshold, erc.Window, erc.MinRequests,
+				config.AlertConfig.HealthCheckConfig.Interval)
+		}
+
 		idpSlug := idp.Data().ProviderSlug
 		authenticator, err := NewAuthenticator(config,
 			SetValidators(validators),
@@ -42,6 +63,7 @@ func NewAuthenticatorMux(config Configuration, statsdClient *statsd.Client) (*Au
 			SetCookieStore(config.SessionConfig, idpSlug),
 			SetStatsdClient(statsdClient),
 			SetRedirectURL(config.ServerConfig, idpSlug),
+			SetGroupChangeSecret(providerConfig.GroupCacheConfig.WebhookSecret),
 		)
 		if err != nil {
 			logger.Error(err, "error creating new Authenticator")
@@ -76,6 +98,19 @@ func NewAuthenticatorMux(config Configuration, statsdClient *statsd.Client) (*Au
 	}, nil
 }
 
+// newAlerter returns the alerter configured by ac, or nil if alerting is disabled.
+func newAlerter(ac AlertConfig) (*alerting.Alerter, error) {
+	if ac.Type == "" {
+		return nil, nil
+	}
+
+	destination, err := alerting.NewDestination(ac.Type, ac.URL, ac.RoutingKey)
+	if err != nil {
+		return nil, err
+	}
+	return alerting.NewAlerter(destination, ac.Cooldown), nil
+}
+
 func (a *AuthenticatorMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	a.handler.ServeHTTP(w, r)
 }
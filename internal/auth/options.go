@@ -8,6 +8,7 @@ import (
 
 	"github.com/buzzfeed/sso/internal/auth/providers"
 	"github.com/buzzfeed/sso/internal/pkg/aead"
+	"github.com/buzzfeed/sso/internal/pkg/fips"
 	"github.com/buzzfeed/sso/internal/pkg/groups"
 	"github.com/buzzfeed/sso/internal/pkg/options"
 	"github.com/buzzfeed/sso/internal/pkg/sessions"
@@ -16,12 +17,18 @@ import (
 )
 
 func newProvider(pc ProviderConfig, sc SessionConfig) (providers.Provider, error) {
+	groupMapping, err := pc.GroupsConfig.Mapping()
+	if err != nil {
+		return nil, err
+	}
+
 	p := &providers.ProviderData{
 		ProviderSlug:       pc.ProviderSlug,
 		Scope:              pc.Scope,
 		ClientID:           pc.ClientConfig.ID,
 		ClientSecret:       pc.ClientConfig.Secret,
 		SessionLifetimeTTL: sc.SessionLifetimeTTL,
+		GroupMapping:       groupMapping,
 	}
 
 	var singleFlightProvider providers.Provider
@@ -38,6 +45,10 @@ func newProvider(pc ProviderConfig, sc SessionConfig) (providers.Provider, error
 			return nil, err
 		}
 
+		if gpc.GroupDepth != 0 {
+			googleProvider.GroupDepth = gpc.GroupDepth
+		}
+
 		cache := groups.NewFillCache(googleProvider.PopulateMembers, pc.GroupCacheConfig.CacheIntervalConfig.Refresh)
 		googleProvider.GroupsCache = cache
 		singleFlightProvider = providers.NewSingleFlightProvider(googleProvider)
@@ -70,6 +81,20 @@ func newProvider(pc ProviderConfig, sc SessionConfig) (providers.Provider, error
 		cache := groups.NewFillCache(amazonCognitoProvider.PopulateMembers, pc.GroupCacheConfig.CacheIntervalConfig.Refresh)
 		amazonCognitoProvider.GroupsCache = cache
 		singleFlightProvider = providers.NewSingleFlightProvider(amazonCognitoProvider)
+	case providers.DriverProviderName:
+		dpc := pc.DriverProviderConfig
+		driverProvider, err := providers.NewDriverProvider(p,
+			dpc.Name,
+			dpc.Address,
+			dpc.Plugin,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tags := []string{"provider:driver"}
+		cache := providers.NewGroupCache(driverProvider, pc.GroupCacheConfig.CacheIntervalConfig.Provider, driverProvider.StatsdClient, tags)
+		singleFlightProvider = providers.NewSingleFlightProvider(cache)
 	case "test":
 		return providers.NewTestProvider(nil), nil
 	default:
@@ -101,6 +126,14 @@ func SetStatsdClient(statsdClient *statsd.Client) func(*Authenticator) error {
 	}
 }
 
+// SetGroupChangeSecret sets the secret notifications of group changes are signed with.
+func SetGroupChangeSecret(secret string) func(*Authenticator) error {
+	return func(a *Authenticator) error {
+		a.groupChangeSecret = []byte(secret)
+		return nil
+	}
+}
+
 // SetRedirectURL takes an identity provider slug to construct the
 // url callback using the slug and configured redirect url.
 func SetRedirectURL(serverConfig ServerConfig, slug string) func(*Authenticator) error {
@@ -122,7 +155,16 @@ func SetValidators(validators []options.Validator) func(*Authenticator) error {
 	}
 }
 
-// SetCookieStore sets the cookie store to use a miscreant cipher
+// newCipher returns the cipher of a secret: AES-256-GCM in FIPS mode, and AES-CMAC-SIV otherwise.
+func newCipher(secret []byte) (aead.Cipher, error) {
+	if fips.Enabled() {
+		return aead.NewGCMCipher(secret)
+	}
+	return aead.NewMiscreantCipher(secret)
+}
+
+// SetCookieStore sets the cookie store to use a miscreant cipher, or an AES-256-GCM cipher in
+// FIPS mode
 func SetCookieStore(sessionConfig SessionConfig, providerSlug string) func(*Authenticator) error {
 	return func(a *Authenticator) error {
 		decodedKey, err := base64.StdEncoding.DecodeString(sessionConfig.Key)
@@ -130,7 +172,7 @@ func SetCookieStore(sessionConfig SessionConfig, providerSlug string) func(*Auth
 			return err
 		}
 
-		codeCipher, err := aead.NewMiscreantCipher([]byte(decodedKey))
+		codeCipher, err := newCipher([]byte(decodedKey))
 		if err != nil {
 			return err
 		}
@@ -141,11 +183,24 @@ func SetCookieStore(sessionConfig SessionConfig, providerSlug string) func(*Auth
 			return err
 		}
 
+		cookieCipher := sessions.CreateMiscreantCookieCipher(decodedCookieSecret)
+		if fips.Enabled() {
+			cookieCipher = func(c *sessions.CookieStore) error {
+				cipher, err := newCipher(decodedCookieSecret)
+				if err != nil {
+					return fmt.Errorf("cookie-secret error: %s", err)
+				}
+				c.CookieCipher = cipher
+				return nil
+			}
+		}
+
 		cookieName := fmt.Sprintf("%s_%s", cc.Name, providerSlug)
 		cookieStore, err := sessions.NewCookieStore(cookieName,
-			sessions.CreateMiscreantCookieCipher(decodedCookieSecret),
+			cookieCipher,
 			func(c *sessions.CookieStore) error {
 				c.CookieDomain = cc.Domain
+				c.DeriveCookieDomain = cc.DeriveDomain
 				c.CookieHTTPOnly = cc.HTTPOnly
 				c.CookieExpire = cc.Expire
 				c.CookieSecure = cc.Secure
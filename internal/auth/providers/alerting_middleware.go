@@ -0,0 +1,201 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/alerting"
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+
+	"github.com/datadog/datadog-go/statsd"
+)
+
+var (
+	_ Provider = &AlertingProvider{}
+)
+
+// healthCheckFailures is the number of consecutive failed health checks after which an alert
+// is fired.
+const healthCheckFailures = 3
+
+// AlertingProvider is a middleware provider that fires alerts when the proportion of failed
+// requests to the provider crosses a threshold, and, if health checks are enabled, when the
+// provider stops responding to them.
+type AlertingProvider struct {
+	provider Provider
+	alerter  *alerting.Alerter
+
+	errorRate   *alerting.ErrorRate
+	threshold   float64
+	minRequests int
+	now         func() time.Time
+
+	healthCheckClient *http.Client
+	healthFailures    int
+	stop              chan struct{}
+	stopOnce          sync.Once
+}
+
+// NewAlertingProvider returns a new AlertingProvider, alerting when more than threshold of
+// at least minRequests requests to the provider within window fail. If healthCheckInterval
+// is non-zero, the provider's sign in url is also requested at that interval.
+func NewAlertingProvider(provider Provider, alerter *alerting.Alerter, threshold float64, window time.Duration,
+	minRequests int, healthCheckInterval time.Duration) *AlertingProvider {
+	p := &AlertingProvider{
+		provider:          provider,
+		alerter:           alerter,
+		errorRate:         alerting.NewErrorRate(window),
+		threshold:         threshold,
+		minRequests:       minRequests,
+		now:               time.Now,
+		healthCheckClient: &http.Client{Timeout: 10 * time.Second},
+		stop:              make(chan struct{}),
+	}
+	if healthCheckInterval > 0 {
+		go p.runHealthChecks(healthCheckInterval)
+	}
+	return p
+}
+
+func (p *AlertingProvider) slug() string {
+	return p.provider.Data().ProviderSlug
+}
+
+// record records the outcome of a request to the provider, firing or resolving the error
+// rate alert as needed. Errors caused by the request itself, rather than the provider, are
+// not counted as failures.
+func (p *AlertingProvider) record(err error) {
+	failed := err != nil && err != ErrBadRequest && err != ErrTokenRevoked
+	rate, total := p.errorRate.Record(p.now(), failed)
+	if total < p.minRequests {
+		return
+	}
+
+	key := fmt.Sprintf("error_rate:%s", p.slug())
+	if rate > p.threshold {
+		summary := fmt.Sprintf("%.0f%% of %d requests to the %s provider failed", rate*100, total, p.slug())
+		p.alerter.Fire(key, p.slug(), summary)
+	} else if rate <= p.threshold/2 {
+		// wait for the error rate to recover well below the threshold, so that an error rate
+		// hovering around it doesn't repeatedly fire and resolve the alert
+		p.alerter.Resolve(key, p.slug(), fmt.Sprintf("%s provider error rate", p.slug()))
+	}
+}
+
+func (p *AlertingProvider) runHealthChecks(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.healthCheck()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// healthCheck requests the provider's sign in url, alerting once it has failed to respond
+// successfully several times in a row.
+func (p *AlertingProvider) healthCheck() {
+	err := p.checkSignInURL()
+	key := fmt.Sprintf("health_check:%s", p.slug())
+	if err == nil {
+		p.healthFailures = 0
+		p.alerter.Resolve(key, p.slug(), fmt.Sprintf("%s provider health check", p.slug()))
+		return
+	}
+
+	log.NewLogEntry().WithProvider(p.slug()).Error(err, "provider health check failed")
+	p.healthFailures++
+	if p.healthFailures >= healthCheckFailures {
+		summary := fmt.Sprintf("%s provider health check failing: %s", p.slug(), err)
+		p.alerter.Fire(key, p.slug(), summary)
+	}
+}
+
+func (p *AlertingProvider) checkSignInURL() error {
+	signInURL := p.provider.Data().SignInURL
+	if signInURL == nil {
+		return nil
+	}
+
+	resp, err := p.healthCheckClient.Get(signInURL.String())
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SetStatsdClient calls the provider's SetStatsdClient function.
+func (p *AlertingProvider) SetStatsdClient(statsdClient *statsd.Client) {
+	p.provider.SetStatsdClient(statsdClient)
+}
+
+// Data returns the provider data
+func (p *AlertingProvider) Data() *ProviderData {
+	return p.provider.Data()
+}
+
+// Redeem wraps the provider's Redeem function.
+func (p *AlertingProvider) Redeem(redirectURL, code string) (*sessions.SessionState, error) {
+	session, err := p.provider.Redeem(redirectURL, code)
+	p.record(err)
+	return session, err
+}
+
+// ValidateSessionState calls the provider's ValidateSessionState function.
+func (p *AlertingProvider) ValidateSessionState(s *sessions.SessionState) bool {
+	return p.provider.ValidateSessionState(s)
+}
+
+// GetSignInURL calls the provider's GetSignInURL function.
+func (p *AlertingProvider) GetSignInURL(redirectURI, finalRedirect string, scopes ...string) string {
+	return p.provider.GetSignInURL(redirectURI, finalRedirect, scopes...)
+}
+
+// RefreshSessionIfNeeded wraps the provider's RefreshSessionIfNeeded function.
+func (p *AlertingProvider) RefreshSessionIfNeeded(s *sessions.SessionState) (bool, error) {
+	refreshed, err := p.provider.RefreshSessionIfNeeded(s)
+	p.record(err)
+	return refreshed, err
+}
+
+// ValidateGroupMembership wraps the provider's ValidateGroupMembership function.
+func (p *AlertingProvider) ValidateGroupMembership(email string, allowedGroups []string, accessToken string) ([]string, error) {
+	groups, err := p.provider.ValidateGroupMembership(email, allowedGroups, accessToken)
+	p.record(err)
+	return groups, err
+}
+
+// Revoke wraps the provider's Revoke function.
+func (p *AlertingProvider) Revoke(s *sessions.SessionState) error {
+	err := p.provider.Revoke(s)
+	p.record(err)
+	return err
+}
+
+// RefreshAccessToken wraps the provider's RefreshAccessToken function.
+func (p *AlertingProvider) RefreshAccessToken(refreshToken string) (string, time.Duration, error) {
+	accessToken, expiresIn, err := p.provider.RefreshAccessToken(refreshToken)
+	p.record(err)
+	return accessToken, expiresIn, err
+}
+
+// InvalidateGroups calls the provider's InvalidateGroups function
+func (p *AlertingProvider) InvalidateGroups(emails []string, groups []string) {
+	p.provider.InvalidateGroups(emails, groups)
+}
+
+// Stop stops health checks and calls the provider's stop function
+func (p *AlertingProvider) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+	p.provider.Stop()
+}
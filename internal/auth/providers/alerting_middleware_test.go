@@ -0,0 +1,127 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/auth/circuit"
+	"github.com/buzzfeed/sso/internal/pkg/alerting"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+// testAlerter returns an alerter delivering alerts to a test server, and the channel the
+// alerts it receives are sent to.
+func testAlerter(t *testing.T) (*alerting.Alerter, chan alerting.Alert, func()) {
+	received := make(chan alerting.Alert, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		alert := alerting.Alert{}
+		err := json.NewDecoder(req.Body).Decode(&alert)
+		testutil.Assert(t, err == nil, "unexpected error decoding alert: %s", err)
+		received <- alert
+	}))
+
+	destination, err := alerting.NewDestination(alerting.DestinationWebhook, server.URL, "")
+	testutil.Assert(t, err == nil, "unexpected error creating destination: %s", err)
+	return alerting.NewAlerter(destination, time.Hour), received, server.Close
+}
+
+func receiveAlert(t *testing.T, received chan alerting.Alert) alerting.Alert {
+	select {
+	case alert := <-received:
+		return alert
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for alert")
+	}
+	return alerting.Alert{}
+}
+
+func TestAlertingProviderErrorRate(t *testing.T) {
+	alerter, received, close := testAlerter(t)
+	defer close()
+
+	testProvider := NewTestProvider(nil)
+	p := NewAlertingProvider(testProvider, alerter, 0.5, time.Minute, 4, 0)
+	defer p.Stop()
+
+	// client errors don't count as failures
+	testProvider.RevokeError = ErrTokenRevoked
+	for i := 0; i < 4; i++ {
+		p.Revoke(nil)
+	}
+
+	testProvider.RevokeError = errors.New("internal server error")
+	for i := 0; i < 5; i++ {
+		p.Revoke(nil)
+	}
+
+	alert := receiveAlert(t, received)
+	testutil.Equal(t, "error_rate:test", alert.Key)
+	testutil.Equal(t, "test", alert.Source)
+	testutil.Equal(t, false, alert.Resolved)
+
+	testProvider.RevokeError = nil
+	for i := 0; i < 20; i++ {
+		p.Revoke(nil)
+	}
+
+	alert = receiveAlert(t, received)
+	testutil.Equal(t, "error_rate:test", alert.Key)
+	testutil.Equal(t, true, alert.Resolved)
+}
+
+func TestAlertingProviderHealthCheck(t *testing.T) {
+	alerter, received, close := testAlerter(t)
+	defer close()
+
+	status := int32(http.StatusServiceUnavailable)
+	signIn := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer signIn.Close()
+
+	testProvider := NewTestProvider(nil)
+	testProvider.ProviderData.SignInURL, _ = url.Parse(signIn.URL)
+	p := NewAlertingProvider(testProvider, alerter, 0.5, time.Minute, 20, 0)
+	defer p.Stop()
+
+	for i := 0; i < healthCheckFailures; i++ {
+		p.healthCheck()
+	}
+
+	alert := receiveAlert(t, received)
+	testutil.Equal(t, "health_check:test", alert.Key)
+	testutil.Equal(t, false, alert.Resolved)
+
+	atomic.StoreInt32(&status, http.StatusOK)
+	p.healthCheck()
+
+	alert = receiveAlert(t, received)
+	testutil.Equal(t, "health_check:test", alert.Key)
+	testutil.Equal(t, true, alert.Resolved)
+}
+
+func TestAlertCircuitChange(t *testing.T) {
+	alerter, received, close := testAlerter(t)
+	defer close()
+
+	p := &ProviderData{ProviderName: "Okta", ProviderSlug: "okta", Alerter: alerter}
+	p.alertCircuitChange(circuit.StateHalfOpen)
+	p.alertCircuitChange(circuit.StateOpen)
+
+	alert := receiveAlert(t, received)
+	testutil.Equal(t, "circuit_open:okta", alert.Key)
+	testutil.Equal(t, "Okta provider circuit breaker open", alert.Summary)
+
+	p.alertCircuitChange(circuit.StateClosed)
+	alert = receiveAlert(t, received)
+	testutil.Equal(t, true, alert.Resolved)
+
+	// a provider without an alerter sends nothing
+	(&ProviderData{}).alertCircuitChange(circuit.StateOpen)
+}
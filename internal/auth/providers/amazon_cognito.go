@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/buzzfeed/sso/internal/auth/circuit"
@@ -138,8 +139,8 @@ func (p *AmazonCognitoProvider) ValidateSessionState(s *sessions.SessionState) b
 	return true
 }
 
-// GetSignInURL returns the sign in url with typical oauth parameters
-func (p *AmazonCognitoProvider) GetSignInURL(redirectURI, state string) string {
+// GetSignInURL returns the sign in url with typical oauth parameters, requesting any additional scopes
+func (p *AmazonCognitoProvider) GetSignInURL(redirectURI, state string, scopes ...string) string {
 	var a url.URL
 	a = *p.SignInURL
 
@@ -149,7 +150,7 @@ func (p *AmazonCognitoProvider) GetSignInURL(redirectURI, state string) string {
 	params.Set("redirect_uri", redirectURI)
 	params.Add("state", state)
 	params.Set("identity_provider", "COGNITO")
-	params.Add("scope", p.Scope)
+	params.Add("scope", withScopes(p.Scope, scopes))
 
 	a.RawQuery = params.Encode()
 	return a.String()
@@ -254,6 +255,7 @@ func (p *AmazonCognitoProvider) cbStateChange(from, to circuit.State) {
 
 	p.StatsdClient.Incr("provider.circuit_change", []string{fmt.Sprintf("from_state:%s", from), fmt.Sprintf("to_state:%s", to)}, 1.0)
 	logger.WithCircuitChangeFrom(from).WithCircuitChangeTo(to).Info("circuit breaker trigger")
+	p.alertCircuitChange(to)
 }
 
 // Redeem fulfills the Provider interface.
@@ -283,6 +285,7 @@ func (p *AmazonCognitoProvider) Redeem(redirectURL, code string) (*sessions.Sess
 		RefreshToken string `json:"refresh_token"`
 		ExpiresIn    int64  `json:"expires_in"`
 		IDToken      string `json:"id_token"`
+		Scope        string `json:"scope"`
 	}
 
 	err := p.amazonCognitoRequest("POST", p.RedeemURL.String(), params, []string{"action:redeem"}, nil, true, &response)
@@ -302,6 +305,8 @@ func (p *AmazonCognitoProvider) Redeem(redirectURL, code string) (*sessions.Sess
 		RefreshDeadline:  sessions.ExtendDeadline(time.Duration(response.ExpiresIn) * time.Second),
 		LifetimeDeadline: sessions.ExtendDeadline(p.SessionLifetimeTTL),
 		Email:            email,
+		Scopes:           strings.Fields(response.Scope),
+		Claims:           claimsFromIDToken(response.IDToken),
 	}, nil
 }
 
@@ -469,3 +474,9 @@ func (p *AmazonCognitoProvider) Revoke(session *sessions.SessionState) error {
 	}
 	return nil
 }
+
+// InvalidateGroups updates the cached members of the groups, and of the cached groups any of
+// the emails are members of.
+func (p *AmazonCognitoProvider) InvalidateGroups(emails []string, groups []string) {
+	updateMemberSets(p.GroupsCache, emails, groups)
+}
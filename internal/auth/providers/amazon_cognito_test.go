@@ -199,7 +199,7 @@ func TestAmazonCognitoProviderRedeem(t *testing.T) {
 				t.Errorf("unexpected error %q", err)
 			}
 			if tc.expectedSession == nil && session != nil {
-				t.Errorf("expected session to be nil but it was %q", session)
+				t.Errorf("expected session to be nil but it was %v", session)
 			}
 			if session != nil && tc.expectedSession != nil {
 				if session.Email != tc.expectedSession.Email {
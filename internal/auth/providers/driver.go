@@ -0,0 +1,330 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/pkg/providerdriver"
+	"github.com/datadog/datadog-go/statsd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// driverTimeout bounds how long each call to a provider driver may take.
+const driverTimeout = time.Duration(10) * time.Second
+
+// DriverProvider is an implementation of the Provider interface for identity providers sso-auth
+// doesn't support natively, which it drives through a provider driver served over gRPC or
+// loaded from a Go plugin. See package providerdriver.
+type DriverProvider struct {
+	*ProviderData
+	StatsdClient *statsd.Client
+
+	driver providerdriver.Driver
+	conn   *grpc.ClientConn
+}
+
+// NewDriverProvider returns a new DriverProvider named name, calling the driver served at a
+// unix:// or tcp:// address, or else the driver of the Go plugin at pluginPath.
+func NewDriverProvider(p *ProviderData, name, address, pluginPath string) (*DriverProvider, error) {
+	if (address == "") == (pluginPath == "") {
+		return nil, errors.New("must specify one of driver.address or driver.plugin")
+	}
+
+	p.ProviderName = name
+	if p.ProviderName == "" {
+		p.ProviderName = p.ProviderSlug
+	}
+	driverProvider := &DriverProvider{
+		ProviderData: p,
+	}
+
+	if pluginPath != "" {
+		driver, err := providerdriver.LoadPlugin(pluginPath)
+		if err != nil {
+			return nil, fmt.Errorf("error loading provider driver plugin %q: %s", pluginPath, err)
+		}
+		driverProvider.driver = driver
+		return driverProvider, nil
+	}
+
+	network, addr, err := ParseDriverAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	// drivers are local, so the connection is not encrypted
+	conn, err := grpc.Dial(addr,
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout(network, addr, timeout)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	driverProvider.driver = providerdriver.NewClient(conn)
+	driverProvider.conn = conn
+	return driverProvider, nil
+}
+
+// ParseDriverAddress returns the network and address of a provider driver address, a
+// unix:///path/to/socket URL or a tcp:// URL of a loopback address. Drivers are called without
+// TLS, so they must run on the same host.
+func ParseDriverAddress(address string) (string, string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid provider driver address %q: %s", address, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" || u.Host != "" {
+			return "", "", fmt.Errorf("invalid provider driver address %q: must be unix:///path/to/socket", address)
+		}
+		return "unix", u.Path, nil
+	case "tcp":
+		ip := net.ParseIP(u.Hostname())
+		if (u.Hostname() != "localhost" && (ip == nil || !ip.IsLoopback())) || u.Port() == "" {
+			return "", "", fmt.Errorf("invalid provider driver address %q: must be tcp://localhost:port or a loopback ip and port", address)
+		}
+		return "tcp", u.Host, nil
+	default:
+		return "", "", fmt.Errorf("invalid provider driver address %q: must be a unix:// or tcp:// URL", address)
+	}
+}
+
+// SetStatsdClient sets the providers StatsdClient
+func (p *DriverProvider) SetStatsdClient(statsdClient *statsd.Client) {
+	p.StatsdClient = statsdClient
+}
+
+// call calls the driver for an action, bounded by driverTimeout, recording its metrics and
+// returning its error as a provider error.
+func (p *DriverProvider) call(action string, f func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), driverTimeout)
+	defer cancel()
+
+	tags := []string{"provider:driver", fmt.Sprintf("action:%s", action)}
+	startTS := time.Now()
+	p.StatsdClient.Incr("provider.request", tags, 1.0)
+	err := f(ctx)
+	tags = append(tags, fmt.Sprintf("status_code:%s", status.Code(err)))
+	p.StatsdClient.Timing("provider.latency", time.Now().Sub(startTS), tags, 1.0)
+	p.StatsdClient.Incr("provider.response", tags, 1.0)
+	if err == nil {
+		return nil
+	}
+
+	p.StatsdClient.Incr("provider.error", tags, 1.0)
+	log.NewLogEntry().WithProvider(p.ProviderSlug).Error(err, "error returned from provider driver: ", action)
+	return driverError(err)
+}
+
+// driverError maps the gRPC status of an error returned by a driver to a provider error.
+func driverError(err error) error {
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.InvalidArgument:
+		return ErrBadRequest
+	case codes.Unauthenticated:
+		return ErrTokenRevoked
+	case codes.ResourceExhausted:
+		return ErrRateLimitExceeded
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return ErrServiceUnavailable
+	default:
+		return err
+	}
+}
+
+// GetSignInURL returns the sign in url of the driver, requesting any additional scopes
+func (p *DriverProvider) GetSignInURL(redirectURI, state string, scopes ...string) string {
+	var resp *providerdriver.SignInURLResponse
+	err := p.call("sign_in_url", func(ctx context.Context) (err error) {
+		resp, err = p.driver.SignInURL(ctx, &providerdriver.SignInURLRequest{
+			ClientID:    p.ClientID,
+			RedirectURI: redirectURI,
+			State:       state,
+			Scope:       withScopes(p.Scope, scopes),
+		})
+		return
+	})
+	if err != nil {
+		return ""
+	}
+	return resp.URL
+}
+
+// Redeem redeems an authorization code with the driver for a session.
+func (p *DriverProvider) Redeem(redirectURL, code string) (*sessions.SessionState, error) {
+	if code == "" {
+		return nil, ErrBadRequest
+	}
+	var resp *providerdriver.RedeemResponse
+	err := p.call("redeem", func(ctx context.Context) (err error) {
+		resp, err = p.driver.Redeem(ctx, &providerdriver.RedeemRequest{
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURI:  redirectURL,
+			Code:         code,
+			Scope:        p.Scope,
+		})
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Email == "" {
+		return nil, errors.New("missing email")
+	}
+
+	var claims map[string]interface{}
+	if len(resp.Claims) > 0 {
+		claims = make(map[string]interface{}, len(resp.Claims))
+		for claim, value := range resp.Claims {
+			claims[claim] = value
+		}
+	}
+	return &sessions.SessionState{
+		AccessToken:  resp.AccessToken,
+		RefreshToken: resp.RefreshToken,
+
+		RefreshDeadline:  sessions.ExtendDeadline(time.Duration(resp.ExpiresIn) * time.Second),
+		LifetimeDeadline: sessions.ExtendDeadline(p.SessionLifetimeTTL),
+		Email:            resp.Email,
+		Scopes:           resp.Scopes,
+		Claims:           claims,
+	}, nil
+}
+
+// ValidateSessionState validates the session state's access token with the driver.
+func (p *DriverProvider) ValidateSessionState(s *sessions.SessionState) bool {
+	if s.AccessToken == "" {
+		return false
+	}
+	var resp *providerdriver.ValidateResponse
+	err := p.call("validate", func(ctx context.Context) (err error) {
+		resp, err = p.driver.Validate(ctx, &providerdriver.ValidateRequest{
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			AccessToken:  s.AccessToken,
+			Email:        s.Email,
+		})
+		return
+	})
+	if err != nil {
+		return false
+	}
+	return resp.Valid
+}
+
+// RefreshSessionIfNeeded takes in a SessionState and
+// returns false if the session is not refreshed and true if it is.
+func (p *DriverProvider) RefreshSessionIfNeeded(s *sessions.SessionState) (bool, error) {
+	if s == nil || !s.RefreshPeriodExpired() || s.RefreshToken == "" {
+		return false, nil
+	}
+	newToken, duration, err := p.RefreshAccessToken(s.RefreshToken)
+	if err != nil {
+		return false, err
+	}
+	logger := log.NewLogEntry()
+
+	s.AccessToken = newToken
+
+	s.RefreshDeadline = time.Now().Add(duration).Truncate(time.Second)
+	logger.WithUser(s.Email).WithRefreshDeadline(s.RefreshDeadline).Info("refreshed access token")
+
+	return true, nil
+}
+
+// RefreshAccessToken redeems a refresh token with the driver for a new access token, returning
+// it along with how long until it expires.
+func (p *DriverProvider) RefreshAccessToken(refreshToken string) (string, time.Duration, error) {
+	if refreshToken == "" {
+		return "", 0, ErrBadRequest
+	}
+	var resp *providerdriver.RefreshResponse
+	err := p.call("refresh", func(ctx context.Context) (err error) {
+		resp, err = p.driver.Refresh(ctx, &providerdriver.RefreshRequest{
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RefreshToken: refreshToken,
+		})
+		return
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return resp.AccessToken, time.Duration(resp.ExpiresIn) * time.Second, nil
+}
+
+// ValidateGroupMembership returns which of the allowed groups the driver lists the user as a
+// member of.
+func (p *DriverProvider) ValidateGroupMembership(email string, allowedGroups []string, accessToken string) ([]string, error) {
+	if len(allowedGroups) == 0 {
+		return []string{}, nil
+	}
+	var resp *providerdriver.GroupsResponse
+	err := p.call("groups", func(ctx context.Context) (err error) {
+		resp, err = p.driver.Groups(ctx, &providerdriver.GroupsRequest{
+			ClientID:      p.ClientID,
+			ClientSecret:  p.ClientSecret,
+			Email:         email,
+			AccessToken:   accessToken,
+			AllowedGroups: allowedGroups,
+		})
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// allowed groups are named as normalized by the group mapping, rather than by the driver
+	userGroups := p.GroupMapping.NormalizeAll(resp.Groups)
+
+	matchingGroups := []string{}
+	for _, x := range allowedGroups {
+		for _, y := range userGroups {
+			if x == y {
+				matchingGroups = append(matchingGroups, x)
+				break
+			}
+		}
+	}
+	return matchingGroups, nil
+}
+
+// Revoke revokes the tokens of a session with the driver.
+func (p *DriverProvider) Revoke(s *sessions.SessionState) error {
+	err := p.call("revoke", func(ctx context.Context) error {
+		_, err := p.driver.Revoke(ctx, &providerdriver.RevokeRequest{
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			AccessToken:  s.AccessToken,
+			RefreshToken: s.RefreshToken,
+		})
+		return err
+	})
+	if err != nil && err != ErrTokenRevoked {
+		return err
+	}
+	logger := log.NewLogEntry()
+
+	logger.WithUser(s.Email).Info("revoked refresh and access token")
+	return nil
+}
+
+// Stop closes the connection to the driver.
+func (p *DriverProvider) Stop() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
@@ -0,0 +1,182 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/pkg/providerdriver"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testDriver is a provider driver returning its fields, and recording the requests it's sent.
+type testDriver struct {
+	redeemResponse *providerdriver.RedeemResponse
+	groups         []string
+	err            error
+
+	redeemRequest *providerdriver.RedeemRequest
+	groupsRequest *providerdriver.GroupsRequest
+}
+
+func (d *testDriver) SignInURL(ctx context.Context, in *providerdriver.SignInURLRequest) (*providerdriver.SignInURLResponse, error) {
+	return &providerdriver.SignInURLResponse{URL: "https://idp.example.com/authorize?scope=" + in.Scope}, d.err
+}
+
+func (d *testDriver) Redeem(ctx context.Context, in *providerdriver.RedeemRequest) (*providerdriver.RedeemResponse, error) {
+	d.redeemRequest = in
+	return d.redeemResponse, d.err
+}
+
+func (d *testDriver) Validate(ctx context.Context, in *providerdriver.ValidateRequest) (*providerdriver.ValidateResponse, error) {
+	return &providerdriver.ValidateResponse{Valid: in.AccessToken == "access-token"}, d.err
+}
+
+func (d *testDriver) Refresh(ctx context.Context, in *providerdriver.RefreshRequest) (*providerdriver.RefreshResponse, error) {
+	return &providerdriver.RefreshResponse{AccessToken: "new-access-token", ExpiresIn: 3600}, d.err
+}
+
+func (d *testDriver) Groups(ctx context.Context, in *providerdriver.GroupsRequest) (*providerdriver.GroupsResponse, error) {
+	d.groupsRequest = in
+	return &providerdriver.GroupsResponse{Groups: d.groups}, d.err
+}
+
+func (d *testDriver) Revoke(ctx context.Context, in *providerdriver.RevokeRequest) (*providerdriver.RevokeResponse, error) {
+	return &providerdriver.RevokeResponse{}, d.err
+}
+
+func newTestDriverProvider(d *testDriver) *DriverProvider {
+	return &DriverProvider{
+		ProviderData: &ProviderData{
+			ProviderSlug:       "idp",
+			ClientID:           "client-id",
+			ClientSecret:       "client-secret",
+			Scope:              "openid email",
+			SessionLifetimeTTL: time.Hour,
+		},
+		driver: d,
+	}
+}
+
+func TestDriverProviderRedeem(t *testing.T) {
+	d := &testDriver{
+		redeemResponse: &providerdriver.RedeemResponse{
+			AccessToken:  "access-token",
+			RefreshToken: "refresh-token",
+			ExpiresIn:    3600,
+			Email:        "user@example.com",
+			Scopes:       []string{"openid", "email"},
+			Claims:       map[string]string{"name": "User"},
+		},
+	}
+	p := newTestDriverProvider(d)
+
+	session, err := p.Redeem("https://sso-auth.example.com/idp/callback", "code")
+	testutil.Ok(t, err)
+	testutil.Equal(t, "access-token", session.AccessToken)
+	testutil.Equal(t, "refresh-token", session.RefreshToken)
+	testutil.Equal(t, "user@example.com", session.Email)
+	testutil.Equal(t, []string{"openid", "email"}, session.Scopes)
+	testutil.Equal(t, map[string]interface{}{"name": "User"}, session.Claims)
+	testutil.Assert(t, session.RefreshDeadline.After(time.Now().Add(59*time.Minute)), "expected refresh deadline an hour from now")
+
+	testutil.Equal(t, "client-id", d.redeemRequest.ClientID)
+	testutil.Equal(t, "client-secret", d.redeemRequest.ClientSecret)
+	testutil.Equal(t, "code", d.redeemRequest.Code)
+	testutil.Equal(t, "openid email", d.redeemRequest.Scope)
+
+	d.redeemResponse = &providerdriver.RedeemResponse{AccessToken: "access-token"}
+	_, err = p.Redeem("https://sso-auth.example.com/idp/callback", "code")
+	testutil.Equal(t, errors.New("missing email"), err)
+}
+
+func TestDriverProviderSignInURLRequestsScopes(t *testing.T) {
+	p := newTestDriverProvider(&testDriver{})
+	testutil.Equal(t, "https://idp.example.com/authorize?scope=openid email groups", p.GetSignInURL("", "", "groups"))
+}
+
+func TestDriverProviderSessions(t *testing.T) {
+	p := newTestDriverProvider(&testDriver{})
+
+	testutil.Assert(t, p.ValidateSessionState(&sessions.SessionState{AccessToken: "access-token"}), "expected valid session")
+	testutil.Assert(t, !p.ValidateSessionState(&sessions.SessionState{AccessToken: "other-token"}), "expected invalid session")
+
+	session := &sessions.SessionState{
+		AccessToken:     "access-token",
+		RefreshToken:    "refresh-token",
+		RefreshDeadline: time.Now().Add(-time.Minute),
+	}
+	refreshed, err := p.RefreshSessionIfNeeded(session)
+	testutil.Ok(t, err)
+	testutil.Assert(t, refreshed, "expected session to be refreshed")
+	testutil.Equal(t, "new-access-token", session.AccessToken)
+}
+
+func TestDriverProviderValidateGroupMembership(t *testing.T) {
+	d := &testDriver{
+		groups: []string{"CN=admins,OU=Groups,DC=example,DC=com", "CN=everyone,OU=Groups,DC=example,DC=com"},
+	}
+	p := newTestDriverProvider(d)
+	p.GroupMapping = testGroupMapping(t)
+
+	groups, err := p.ValidateGroupMembership("user@example.com", []string{"admins", "engineers"}, "access-token")
+	testutil.Ok(t, err)
+	testutil.Equal(t, []string{"admins"}, groups)
+	testutil.Equal(t, "user@example.com", d.groupsRequest.Email)
+	testutil.Equal(t, []string{"admins", "engineers"}, d.groupsRequest.AllowedGroups)
+}
+
+func TestDriverProviderErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		err         error
+		expectedErr error
+	}{
+		{"invalid argument", status.Error(codes.InvalidArgument, "invalid code"), ErrBadRequest},
+		{"unauthenticated", status.Error(codes.Unauthenticated, "token revoked"), ErrTokenRevoked},
+		{"rate limited", status.Error(codes.ResourceExhausted, "slow down"), ErrRateLimitExceeded},
+		{"unavailable", status.Error(codes.Unavailable, "down"), ErrServiceUnavailable},
+		{"plugin error", errors.New("plugin error"), errors.New("plugin error")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newTestDriverProvider(&testDriver{err: tc.err})
+			_, err := p.Redeem("https://sso-auth.example.com/idp/callback", "code")
+			testutil.Equal(t, tc.expectedErr, err)
+		})
+	}
+
+	// tokens the driver reports as already revoked are revoked
+	p := newTestDriverProvider(&testDriver{err: status.Error(codes.Unauthenticated, "token revoked")})
+	testutil.Ok(t, p.Revoke(&sessions.SessionState{RefreshToken: "refresh-token"}))
+}
+
+func TestParseDriverAddress(t *testing.T) {
+	testCases := []struct {
+		address         string
+		expectedNetwork string
+		expectedAddr    string
+		expectedErr     bool
+	}{
+		{"unix:///run/sso/driver.sock", "unix", "/run/sso/driver.sock", false},
+		{"tcp://127.0.0.1:9000", "tcp", "127.0.0.1:9000", false},
+		{"tcp://[::1]:9000", "tcp", "[::1]:9000", false},
+		{"tcp://localhost:9000", "tcp", "localhost:9000", false},
+		{"tcp://10.0.0.1:9000", "", "", true},
+		{"tcp://driver.example.com:9000", "", "", true},
+		{"tcp://127.0.0.1", "", "", true},
+		{"127.0.0.1:9000", "", "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.address, func(t *testing.T) {
+			network, addr, err := ParseDriverAddress(tc.address)
+			testutil.Equal(t, tc.expectedErr, err != nil)
+			testutil.Equal(t, tc.expectedNetwork, network)
+			testutil.Equal(t, tc.expectedAddr, addr)
+		})
+	}
+}
@@ -31,8 +31,14 @@ type GoogleProvider struct {
 
 	Prompt       string
 	HostedDomain string
+
+	// GroupDepth is how many levels of nested groups are expanded into the members of a group.
+	GroupDepth int
 }
 
+// DefaultGroupDepth is the default depth nested google groups are expanded to.
+const DefaultGroupDepth = 4
+
 // NewGoogleProvider returns a new GoogleProvider and sets the provider url endpoints.
 func NewGoogleProvider(p *ProviderData, prompt, hd, impersonate, credentials string) (*GoogleProvider, error) {
 	p.ProviderName = "Google"
@@ -66,6 +72,7 @@ func NewGoogleProvider(p *ProviderData, prompt, hd, impersonate, credentials str
 		ProviderData: p,
 		Prompt:       prompt,
 		HostedDomain: hd,
+		GroupDepth:   DefaultGroupDepth,
 	}
 
 	googleProvider.cb = circuit.NewBreaker(&circuit.Options{
@@ -85,10 +92,7 @@ func NewGoogleProvider(p *ProviderData, prompt, hd, impersonate, credentials str
 			return nil, errors.New("could not read google credentials file")
 		}
 
-		googleProvider.AdminService = &GoogleAdminService{
-			adminService: getAdminService(impersonate, credsReader),
-			cb:           googleProvider.cb,
-		}
+		googleProvider.AdminService = newGoogleAdminService(getAdminService(impersonate, credsReader), googleProvider.cb)
 	}
 
 	return googleProvider, nil
@@ -132,8 +136,8 @@ func (p *GoogleProvider) ValidateSessionState(s *sessions.SessionState) bool {
 	return true
 }
 
-// GetSignInURL returns the sign in url with typical oauth parameters
-func (p *GoogleProvider) GetSignInURL(redirectURI, state string) string {
+// GetSignInURL returns the sign in url with typical oauth parameters, requesting any additional scopes
+func (p *GoogleProvider) GetSignInURL(redirectURI, state string, scopes ...string) string {
 	var a url.URL
 	a = *p.SignInURL
 
@@ -141,10 +145,14 @@ func (p *GoogleProvider) GetSignInURL(redirectURI, state string) string {
 	params.Set("client_id", p.ClientID)
 	params.Set("response_type", "code")
 	params.Set("redirect_uri", redirectURI)
-	params.Set("scope", p.Scope)
+	params.Set("scope", withScopes(p.Scope, scopes))
 	params.Set("access_type", "offline")
 	params.Set("state", state)
 	params.Set("prompt", p.Prompt)
+	if len(scopes) != 0 {
+		// https://developers.google.com/identity/protocols/oauth2/web-server#incrementalAuth
+		params.Set("include_granted_scopes", "true")
+	}
 
 	if p.HostedDomain != "" {
 		params.Set("hd", p.HostedDomain)
@@ -283,6 +291,7 @@ func (p *GoogleProvider) cbStateChange(from, to circuit.State) {
 
 	p.StatsdClient.Incr("provider.circuit_change", []string{fmt.Sprintf("from_state:%s", from), fmt.Sprintf("to_state:%s", to)}, 1.0)
 	logger.WithCircuitChangeFrom(from).WithCircuitChangeTo(to).Info("circuit breaker trigger")
+	p.alertCircuitChange(to)
 }
 
 // Redeem fulfills the Provider interface.
@@ -313,6 +322,7 @@ func (p *GoogleProvider) Redeem(redirectURL, code string) (*sessions.SessionStat
 		RefreshToken string `json:"refresh_token"`
 		ExpiresIn    int64  `json:"expires_in"`
 		IDToken      string `json:"id_token"`
+		Scope        string `json:"scope"`
 	}
 
 	err := p.googleRequest("POST", p.RedeemURL.String(), params, []string{"action:redeem"}, &response)
@@ -333,12 +343,14 @@ func (p *GoogleProvider) Redeem(redirectURL, code string) (*sessions.SessionStat
 		RefreshDeadline:  sessions.ExtendDeadline(time.Duration(response.ExpiresIn) * time.Second),
 		LifetimeDeadline: sessions.ExtendDeadline(p.SessionLifetimeTTL),
 		Email:            email,
+		Scopes:           strings.Fields(response.Scope),
+		Claims:           claimsFromIDToken(response.IDToken),
 	}, nil
 }
 
 // PopulateMembers is the fill function for the groups cache
 func (p *GoogleProvider) PopulateMembers(group string) (groups.MemberSet, error) {
-	members, err := p.AdminService.ListMemberships(group, 4)
+	members, err := p.AdminService.ListMemberships(group, p.GroupDepth)
 	if err != nil {
 		return nil, err
 	}
@@ -449,6 +461,12 @@ func (p *GoogleProvider) Revoke(s *sessions.SessionState) error {
 	return nil
 }
 
+// InvalidateGroups updates the cached members of the groups, and of the cached groups any of
+// the emails are members of.
+func (p *GoogleProvider) InvalidateGroups(emails []string, groups []string) {
+	updateMemberSets(p.GroupsCache, emails, groups)
+}
+
 // Stop calls stop on the groups cache
 func (p *GoogleProvider) Stop() {
 	p.GroupsCache.Stop()
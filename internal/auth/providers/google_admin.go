@@ -24,11 +24,25 @@ type AdminService interface {
 	CheckMemberships(groups []string, user string) (inGroups []string, errr error)
 }
 
+// nestedGroupTTL is how long the members of nested groups are cached, so that groups nested in
+// several others are listed about once each time the group caches are refreshed.
+const nestedGroupTTL = 5 * time.Minute
+
 // GoogleAdminService is an AdminService for the google provider
 type GoogleAdminService struct {
 	adminService *admin.Service
 	StatsdClient *statsd.Client
 	cb           *circuit.Breaker
+	nestedGroups *groups.NestedGroups
+}
+
+func newGoogleAdminService(adminService *admin.Service, cb *circuit.Breaker) *GoogleAdminService {
+	gs := &GoogleAdminService{
+		adminService: adminService,
+		cb:           cb,
+	}
+	gs.nestedGroups = groups.NewNestedGroups(gs.listMembers, nestedGroupTTL)
+	return gs
 }
 
 func getAdminService(impersonateUser string, credentialsReader io.Reader) *admin.Service {
@@ -55,15 +69,17 @@ func getAdminService(impersonateUser string, credentialsReader io.Reader) *admin
 	return adminService
 }
 
-// ListMemberships returns a slice of the members of a google group
+// ListMemberships returns a slice of the members of a google group, including the members of
+// the groups nested up to maxDepth levels below it.
 func (gs *GoogleAdminService) ListMemberships(groupName string, maxDepth int) ([]string, error) {
-	return gs.listMemberships(groupName, maxDepth, 0)
+	return gs.nestedGroups.Members(groupName, maxDepth)
 }
 
-func (gs *GoogleAdminService) listMemberships(groupName string, maxDepth, currentDepth int) ([]string, error) {
+// listMembers returns the users and groups that are direct members of a google group.
+func (gs *GoogleAdminService) listMembers(groupName string) ([]string, []string, error) {
 	logger := log.NewLogEntry()
 
-	var members []string
+	var users, nestedGroups []string
 	tags := []string{
 		"provider:google",
 		"action:list_members_resource",
@@ -111,7 +127,7 @@ func (gs *GoogleAdminService) listMemberships(groupName string, maxDepth, curren
 				tags = append(tags, "error:invalid_response")
 				gs.StatsdClient.Incr("provider.internal_error", tags, 1.0)
 			}
-			return nil, err
+			return nil, nil, err
 		}
 
 		r := resp.(*admin.Members)
@@ -123,17 +139,9 @@ func (gs *GoogleAdminService) listMemberships(groupName string, maxDepth, curren
 		for _, member := range r.Members {
 			switch member.Type {
 			case "USER":
-				members = append(members, member.Email)
+				users = append(users, member.Email)
 			case "GROUP":
-				// this is a group, recursively walk down the nested group, up to maxDepth
-				if currentDepth >= maxDepth {
-					continue
-				}
-				groupMembers, err := gs.listMemberships(member.Email, maxDepth, currentDepth+1)
-				if err != nil {
-					return nil, err
-				}
-				members = append(members, groupMembers...)
+				nestedGroups = append(nestedGroups, member.Email)
 			default:
 				err := fmt.Errorf("unknown member type %s", member.Type)
 				logger.WithError(err).Error("not adding member to group list")
@@ -145,7 +153,7 @@ func (gs *GoogleAdminService) listMemberships(groupName string, maxDepth, curren
 		}
 		pageToken = r.NextPageToken
 	}
-	return members, nil
+	return users, nestedGroups, nil
 }
 
 // CheckMemberships given a list of groups and a user email, returns a string slice of the groups the user is a member of.
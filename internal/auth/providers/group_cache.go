@@ -20,6 +20,7 @@ type Cache interface {
 	Get(key groups.CacheKey) (groups.CacheEntry, bool)
 	Set(key groups.CacheKey, val groups.CacheEntry)
 	Purge(key groups.CacheKey)
+	PurgeEmail(email string)
 }
 
 // GroupCache is designed to act as a provider while wrapping subsequent provider's functions,
@@ -61,8 +62,8 @@ func (p *GroupCache) ValidateSessionState(s *sessions.SessionState) bool {
 }
 
 // GetSignInURL wraps the provider's GetSignInURL function.
-func (p *GroupCache) GetSignInURL(redirectURI, finalRedirect string) string {
-	return p.provider.GetSignInURL(redirectURI, finalRedirect)
+func (p *GroupCache) GetSignInURL(redirectURI, finalRedirect string, scopes ...string) string {
+	return p.provider.GetSignInURL(redirectURI, finalRedirect, scopes...)
 }
 
 // RefreshSessionIfNeeded wraps the provider's RefreshSessionIfNeeded function.
@@ -119,6 +120,15 @@ func (p *GroupCache) RefreshAccessToken(refreshToken string) (string, time.Durat
 	return p.provider.RefreshAccessToken(refreshToken)
 }
 
+// InvalidateGroups purges the cached group memberships of the emails, and calls the provider's
+// InvalidateGroups function.
+func (p *GroupCache) InvalidateGroups(emails []string, groups []string) {
+	for _, email := range emails {
+		p.cache.PurgeEmail(email)
+	}
+	p.provider.InvalidateGroups(emails, groups)
+}
+
 // Stop calls the providers stop function.
 func (p *GroupCache) Stop() {
 	p.provider.Stop()
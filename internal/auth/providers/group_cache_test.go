@@ -162,3 +162,30 @@ func TestCachedGroupsAreUsed(t *testing.T) {
 		t.Fatalf("unexpected groups returned")
 	}
 }
+
+func TestInvalidatedGroupsAreNotUsed(t *testing.T) {
+	provider := newTestProvider(nil, t)
+	statsdClient, _ := statsd.New("127.0.0.1:8125")
+	GroupsCache := NewGroupCache(provider, time.Hour, statsdClient, []string{"tags:test"})
+
+	cacheKey := groups.CacheKey{
+		Email:         "email@test.com",
+		AllowedGroups: "allowedGroup1",
+	}
+	otherCacheKey := groups.CacheKey{
+		Email:         "other@test.com",
+		AllowedGroups: "allowedGroup1",
+	}
+	cacheData := groups.CacheEntry{
+		ValidGroups: []string{"allowedGroup1"},
+	}
+	GroupsCache.cache.Set(cacheKey, cacheData)
+	GroupsCache.cache.Set(otherCacheKey, cacheData)
+
+	// Only the cached groups of the invalidated emails should be purged
+	GroupsCache.InvalidateGroups([]string{"email@test.com"}, nil)
+	_, ok := GroupsCache.cache.Get(cacheKey)
+	testutil.Assert(t, !ok, "expected the cached groups of an invalidated email to be purged")
+	_, ok = GroupsCache.cache.Get(otherCacheKey)
+	testutil.Assert(t, ok, "expected the cached groups of other emails to be kept")
+}
@@ -1,11 +1,136 @@
 package providers
 
 import (
+	"encoding/json"
 	"net/url"
+	"sort"
+	"strings"
 
+	"github.com/buzzfeed/sso/internal/pkg/groups"
 	log "github.com/buzzfeed/sso/internal/pkg/logging"
 )
 
+// withScopes returns the space separated scope, followed by any of the additional scopes it
+// doesn't already include.
+func withScopes(scope string, scopes []string) string {
+	fields := strings.Fields(scope)
+	for _, s := range scopes {
+		found := false
+		for _, f := range fields {
+			if f == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fields = append(fields, s)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// updateMemberSets updates the member sets of the groups in a cache, and those of the cached
+// groups any of the emails are members of, which is all that can be known about the groups the
+// emails were removed from. The groups the emails were added to must be listed.
+func updateMemberSets(cache groups.MemberSetCache, emails []string, groupNames []string) {
+	update := make(map[string]struct{})
+	for _, group := range groupNames {
+		update[group] = struct{}{}
+	}
+	for _, group := range cache.Groups() {
+		members, ok := cache.Get(group)
+		if !ok {
+			continue
+		}
+		for _, email := range emails {
+			if _, ok := members[email]; ok {
+				update[group] = struct{}{}
+				break
+			}
+		}
+	}
+
+	logger := log.NewLogEntry()
+	for group := range update {
+		if !cache.Update(group) {
+			logger.WithUserGroup(group).Info("group membership was not updated")
+		}
+	}
+}
+
+// tokenClaims are the claims of an ID token that describe the token rather than the user, which
+// aren't kept in the session.
+var tokenClaims = map[string]struct{}{
+	"aud":       {},
+	"azp":       {},
+	"exp":       {},
+	"iat":       {},
+	"nbf":       {},
+	"jti":       {},
+	"nonce":     {},
+	"at_hash":   {},
+	"c_hash":    {},
+	"auth_time": {},
+}
+
+const (
+	// maxClaimSize is the largest claim kept in the session, encoded as JSON, so that claims such
+	// as long lists of groups or picture urls don't bloat session cookies.
+	maxClaimSize = 256
+	// maxClaimsSize is the most the claims kept in the session add up to, encoded as JSON.
+	maxClaimsSize = 1024
+)
+
+// claimsFromIDToken returns the claims of an ID token that describe the user. The token was
+// received directly from the provider's token endpoint, so its signature isn't verified. Claims
+// are optional, so nil is returned if the token is missing or can't be decoded, and claims too
+// large to be kept in the session are dropped, the largest first.
+func claimsFromIDToken(idToken string) map[string]interface{} {
+	segments := strings.Split(idToken, ".")
+	if len(segments) < 2 {
+		return nil
+	}
+	b, err := jwtDecodeSegment(segments[1])
+	if err != nil {
+		return nil
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil
+	}
+	sizes := make(map[string]int, len(claims))
+	names := make([]string, 0, len(claims))
+	for claim, value := range claims {
+		if _, ok := tokenClaims[claim]; ok {
+			delete(claims, claim)
+			continue
+		}
+		b, _ := json.Marshal(value)
+		sizes[claim] = len(claim) + len(b)
+		names = append(names, claim)
+	}
+
+	// the smallest claims are kept, so that one large claim can't crowd out the others
+	sort.Slice(names, func(i, j int) bool {
+		if sizes[names[i]] != sizes[names[j]] {
+			return sizes[names[i]] < sizes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	total := 0
+	for _, claim := range names {
+		if sizes[claim] > maxClaimSize || total+sizes[claim] > maxClaimsSize {
+			delete(claims, claim)
+			continue
+		}
+		total += sizes[claim]
+	}
+	if len(claims) == 0 {
+		return nil
+	}
+	return claims
+}
+
 // stripToken is a helper function used to obfuscate "access_token" query parameters
 func stripToken(endpoint string) string {
 	return stripParam("access_token", endpoint)
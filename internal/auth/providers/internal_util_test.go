@@ -1,8 +1,16 @@
 package providers
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/buzzfeed/sso/internal/pkg/groups"
 	"github.com/buzzfeed/sso/internal/pkg/testutil"
 )
 
@@ -16,3 +24,74 @@ func TestStripToken(t *testing.T) {
 	expected := "http://local.test/api/test?access_token=dead...&b=1&c=2"
 	testutil.Equal(t, expected, stripToken(test))
 }
+
+func TestWithScopes(t *testing.T) {
+	testutil.Equal(t, "openid email", withScopes("openid email", nil))
+	testutil.Equal(t, "openid email calendar", withScopes("openid email", []string{"email", "calendar"}))
+}
+
+func TestClaimsFromIDToken(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(
+		`{"iss":"https://accounts.example.com","aud":"client","exp":1546304400,"email":"jane@example.com","employee_id":1234}`))
+	claims := claimsFromIDToken("header." + payload + ".signature")
+	testutil.Equal(t, map[string]interface{}{
+		"iss":         "https://accounts.example.com",
+		"email":       "jane@example.com",
+		"employee_id": float64(1234),
+	}, claims)
+
+	testutil.Equal(t, map[string]interface{}(nil), claimsFromIDToken(""))
+	testutil.Equal(t, map[string]interface{}(nil), claimsFromIDToken("header.!!!.signature"))
+
+	// claims too large for the session are dropped
+	groups := make([]string, 0, 40)
+	for i := 0; i < 40; i++ {
+		groups = append(groups, fmt.Sprintf("group-%02d@example.com", i))
+	}
+	large, err := json.Marshal(map[string]interface{}{
+		"email":   "jane@example.com",
+		"picture": "https://example.com/" + strings.Repeat("p", 300),
+		"groups":  groups,
+	})
+	testutil.Ok(t, err)
+	claims = claimsFromIDToken("header." + base64.RawURLEncoding.EncodeToString(large) + ".signature")
+	testutil.Equal(t, map[string]interface{}{"email": "jane@example.com"}, claims)
+
+	// and once the claims add up to the limit, the largest are dropped
+	many := map[string]interface{}{}
+	for i := 0; i < 10; i++ {
+		many[fmt.Sprintf("claim_%d", i)] = strings.Repeat("v", 100+i)
+	}
+	b, err := json.Marshal(many)
+	testutil.Ok(t, err)
+	claims = claimsFromIDToken("header." + base64.RawURLEncoding.EncodeToString(b) + ".signature")
+	testutil.Equal(t, 9, len(claims))
+	_, ok := claims["claim_9"]
+	testutil.Assert(t, !ok, "expected the largest claim to be dropped")
+}
+
+func TestUpdateMemberSets(t *testing.T) {
+	members := map[string]groups.MemberSet{
+		"admins@example.com":  {"admin@example.com": {}},
+		"writers@example.com": {"admin@example.com": {}, "writer@example.com": {}},
+		"readers@example.com": {"reader@example.com": {}},
+	}
+	var mu sync.Mutex
+	filled := []string{}
+	cache := groups.NewFillCache(func(group string) (groups.MemberSet, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		filled = append(filled, group)
+		return members[group], nil
+	}, time.Hour)
+	defer cache.Stop()
+	for group := range members {
+		cache.Update(group)
+	}
+	filled = []string{}
+
+	// the listed groups are updated, along with the cached groups the emails are members of
+	updateMemberSets(cache, []string{"writer@example.com"}, []string{"admins@example.com"})
+	sort.Strings(filled)
+	testutil.Equal(t, []string{"admins@example.com", "writers@example.com"}, filled)
+}
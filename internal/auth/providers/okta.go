@@ -132,14 +132,14 @@ func (p *OktaProvider) ValidateSessionState(s *sessions.SessionState) bool {
 	return true
 }
 
-// GetSignInURL returns the sign in url with typical oauth parameters
-func (p *OktaProvider) GetSignInURL(redirectURI, state string) string {
+// GetSignInURL returns the sign in url with typical oauth parameters, requesting any additional scopes
+func (p *OktaProvider) GetSignInURL(redirectURI, state string, scopes ...string) string {
 	// https://developer.okta.com/docs/api/resources/oidc/#authorize
 	var a url.URL
 	a = *p.SignInURL
 	params, _ := url.ParseQuery(a.RawQuery)
 	params.Set("redirect_uri", redirectURI)
-	params.Add("scope", p.Scope)
+	params.Add("scope", withScopes(p.Scope, scopes))
 	params.Set("client_id", p.ClientID)
 	params.Add("response_mode", "query")
 	params.Set("response_type", "code")
@@ -243,6 +243,7 @@ func (p *OktaProvider) cbStateChange(from, to circuit.State) {
 
 	p.StatsdClient.Incr("provider.circuit_change", []string{fmt.Sprintf("from_state:%s", from), fmt.Sprintf("to_state:%s", to)}, 1.0)
 	logger.WithCircuitChangeFrom(from).WithCircuitChangeTo(to).Info("circuit breaker trigger")
+	p.alertCircuitChange(to)
 }
 
 // Redeem fulfills the Provider interface.
@@ -273,6 +274,7 @@ func (p *OktaProvider) Redeem(redirectURL, code string) (*sessions.SessionState,
 		RefreshToken string `json:"refresh_token"`
 		ExpiresIn    int64  `json:"expires_in"`
 		IDToken      string `json:"id_token"`
+		Scope        string `json:"scope"`
 	}
 	err := p.oktaRequest("POST", p.RedeemURL.String(), params, []string{"action:redeem"}, nil, &response)
 	if err != nil {
@@ -290,6 +292,8 @@ func (p *OktaProvider) Redeem(redirectURL, code string) (*sessions.SessionState,
 		RefreshDeadline:  sessions.ExtendDeadline(time.Duration(response.ExpiresIn) * time.Second),
 		LifetimeDeadline: sessions.ExtendDeadline(p.SessionLifetimeTTL),
 		Email:            email,
+		Scopes:           strings.Fields(response.Scope),
+		Claims:           claimsFromIDToken(response.IDToken),
 	}, nil
 }
 
@@ -335,9 +339,12 @@ func (p *OktaProvider) ValidateGroupMembership(email string, allowedGroups []str
 		return nil, errors.New("no group membership found")
 	}
 
+	// allowed groups are named as normalized by the group mapping, rather than by okta
+	userGroups := p.GroupMapping.NormalizeAll(userinfo.Groups)
+
 	matchingGroups := []string{}
 	for _, x := range allowedGroups {
-		for _, y := range userinfo.Groups {
+		for _, y := range userGroups {
 			if x == y {
 				matchingGroups = append(matchingGroups, x)
 				break
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/buzzfeed/sso/internal/pkg/groups"
 	"github.com/buzzfeed/sso/internal/pkg/sessions"
 	"github.com/buzzfeed/sso/internal/pkg/testutil"
 )
@@ -169,7 +170,7 @@ func TestOktaProviderRedeem(t *testing.T) {
 				t.Errorf("unexpected error %q", err)
 			}
 			if tc.expectedSession == nil && session != nil {
-				t.Errorf("expected session to be nil but it was %q", session)
+				t.Errorf("expected session to be nil but it was %v", session)
 			}
 			if session != nil && tc.expectedSession != nil {
 				if session.Email != tc.expectedSession.Email {
@@ -275,6 +276,7 @@ func TestOktaProviderValidateGroupMembership(t *testing.T) {
 	testCases := []struct {
 		name                string
 		inputAllowedGroups  []string
+		groupMapping        *groups.Mapping
 		expectedGroups      []string
 		expectedErrorString string
 		resp                oktaProviderValidateGroupMembershipResponse
@@ -292,6 +294,15 @@ func TestOktaProviderValidateGroupMembership(t *testing.T) {
 				Groups: []string{"group1", "group3", "group5", "group6"},
 			},
 		},
+		{
+			name:               "groups should be matched by their normalized names",
+			inputAllowedGroups: []string{"engineering", "admins"},
+			groupMapping:       testGroupMapping(t),
+			expectedGroups:     []string{"engineering", "admins"},
+			resp: oktaProviderValidateGroupMembershipResponse{
+				Groups: []string{"CN=Engineering,OU=Groups,DC=example,DC=com", "Admins"},
+			},
+		},
 		{
 			name:                "should error with no group membership found",
 			inputAllowedGroups:  []string{"group1", "group3", "group5"},
@@ -304,6 +315,7 @@ func TestOktaProviderValidateGroupMembership(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 
 			p := newOktaProvider(nil, t)
+			p.GroupMapping = tc.groupMapping
 			body, err := json.Marshal(tc.resp)
 			var server *httptest.Server
 			p.ProfileURL, server = newOktaProviderServer(body, http.StatusOK)
@@ -325,6 +337,14 @@ func TestOktaProviderValidateGroupMembership(t *testing.T) {
 	}
 }
 
+func testGroupMapping(t *testing.T) *groups.Mapping {
+	m, err := groups.NewMapping("", `^CN=([^,]+),OU=Groups,DC=example,DC=com$ => $1`, nil, true)
+	if err != nil {
+		t.Fatalf("unexpected error creating group mapping: %v", err)
+	}
+	return m
+}
+
 type oktaProviderValidateSessionResponse struct {
 	Active bool `json:"active"`
 }
@@ -1,8 +1,13 @@
 package providers
 
 import (
+	"fmt"
 	"net/url"
 	"time"
+
+	"github.com/buzzfeed/sso/internal/auth/circuit"
+	"github.com/buzzfeed/sso/internal/pkg/alerting"
+	"github.com/buzzfeed/sso/internal/pkg/groups"
 )
 
 // ProviderData holds the fields associated with providers
@@ -23,7 +28,26 @@ type ProviderData struct {
 	Scope string
 
 	SessionLifetimeTTL time.Duration
+
+	// GroupMapping, if set, normalizes the names of the groups the provider lists for a user.
+	GroupMapping *groups.Mapping
+
+	// Alerter, if set, is sent alerts when the provider's circuit breaker opens.
+	Alerter *alerting.Alerter
 }
 
 // Data returns a ProviderData.
 func (p *ProviderData) Data() *ProviderData { return p }
+
+// alertCircuitChange fires an alert when the provider's circuit breaker opens, and resolves
+// it once the breaker closes again.
+func (p *ProviderData) alertCircuitChange(to circuit.State) {
+	key := fmt.Sprintf("circuit_open:%s", p.ProviderSlug)
+	summary := fmt.Sprintf("%s provider circuit breaker open", p.ProviderName)
+	switch to {
+	case circuit.StateOpen:
+		p.Alerter.Fire(key, p.ProviderSlug, summary)
+	case circuit.StateClosed:
+		p.Alerter.Resolve(key, p.ProviderSlug, summary)
+	}
+}
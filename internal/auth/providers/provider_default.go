@@ -94,13 +94,13 @@ func (p *ProviderData) Redeem(redirectURL, code string) (s *sessions.SessionStat
 	return
 }
 
-// GetSignInURL returns the sign in url with typical oauth parameters
-func (p *ProviderData) GetSignInURL(redirectURI, state string) string {
+// GetSignInURL returns the sign in url with typical oauth parameters, requesting any additional scopes
+func (p *ProviderData) GetSignInURL(redirectURI, state string, scopes ...string) string {
 	var a url.URL
 	a = *p.SignInURL
 	params, _ := url.ParseQuery(a.RawQuery)
 	params.Set("redirect_uri", redirectURI)
-	params.Add("scope", p.Scope)
+	params.Add("scope", withScopes(p.Scope, scopes))
 	params.Set("client_id", p.ClientID)
 	params.Set("response_type", "code")
 	params.Add("state", state)
@@ -187,6 +187,11 @@ func (p *ProviderData) ValidateGroupMembership(string, []string, string) ([]stri
 	return nil, ErrNotImplemented
 }
 
+// InvalidateGroups fulfills the Provider interface, there being no cached groups to invalidate.
+func (p *ProviderData) InvalidateGroups(emails []string, groups []string) {
+	return
+}
+
 // Stop fulfills the Provider interface
 func (p *ProviderData) Stop() {
 	return
@@ -32,6 +32,8 @@ const (
 	OktaProviderName = "okta"
 	// AmazonCognitoProviderName identities the Okta provider
 	AmazonCognitoProviderName = "cognito"
+	// DriverProviderName identifies providers driven by a provider driver
+	DriverProviderName = "driver"
 )
 
 // Provider is an interface exposing functions necessary to authenticate with a given provider.
@@ -40,10 +42,11 @@ type Provider interface {
 	Data() *ProviderData
 	Redeem(string, string) (*sessions.SessionState, error)
 	ValidateSessionState(*sessions.SessionState) bool
-	GetSignInURL(redirectURI, finalRedirect string) string
+	GetSignInURL(redirectURI, finalRedirect string, scopes ...string) string
 	RefreshSessionIfNeeded(*sessions.SessionState) (bool, error)
 	ValidateGroupMembership(string, []string, string) ([]string, error)
 	Revoke(*sessions.SessionState) error
 	RefreshAccessToken(string) (string, time.Duration, error)
+	InvalidateGroups(emails []string, groups []string)
 	Stop()
 }
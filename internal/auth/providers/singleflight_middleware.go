@@ -94,8 +94,8 @@ func (p *SingleFlightProvider) ValidateSessionState(s *sessions.SessionState) bo
 }
 
 // GetSignInURL calls the provider's GetSignInURL function.
-func (p *SingleFlightProvider) GetSignInURL(redirectURI, finalRedirect string) string {
-	return p.provider.GetSignInURL(redirectURI, finalRedirect)
+func (p *SingleFlightProvider) GetSignInURL(redirectURI, finalRedirect string, scopes ...string) string {
+	return p.provider.GetSignInURL(redirectURI, finalRedirect, scopes...)
 }
 
 // RefreshSessionIfNeeded wraps the provider's RefreshSessionIfNeeded function in a single flight
@@ -173,6 +173,11 @@ func (p *SingleFlightProvider) RefreshAccessToken(refreshToken string) (string,
 	return r.AccessToken, r.ExpiresIn, nil
 }
 
+// InvalidateGroups calls the provider's InvalidateGroups function
+func (p *SingleFlightProvider) InvalidateGroups(emails []string, groups []string) {
+	p.provider.InvalidateGroups(emails, groups)
+}
+
 // Stop calls the provider's stop function
 func (p *SingleFlightProvider) Stop() {
 	p.provider.Stop()
@@ -24,6 +24,9 @@ type TestProvider struct {
 	Groups       []string
 	GroupsError  error
 	GroupsCall   int
+
+	InvalidatedEmails []string
+	InvalidatedGroups []string
 }
 
 // NewTestProvider creates a new mock test provider.
@@ -48,7 +51,7 @@ func (tp *TestProvider) ValidateSessionState(*sessions.SessionState) bool {
 }
 
 // GetSignInURL returns the mock provider's SignInURL field value.
-func (tp *TestProvider) GetSignInURL(redirectURI, finalRedirect string) string {
+func (tp *TestProvider) GetSignInURL(redirectURI, finalRedirect string, scopes ...string) string {
 	return tp.SignInURL
 }
 
@@ -78,6 +81,12 @@ func (tp *TestProvider) Redeem(redirectURI, code string) (*sessions.SessionState
 
 }
 
+// InvalidateGroups records the emails and groups invalidated.
+func (tp *TestProvider) InvalidateGroups(emails []string, groups []string) {
+	tp.InvalidatedEmails = append(tp.InvalidatedEmails, emails...)
+	tp.InvalidatedGroups = append(tp.InvalidatedGroups, groups...)
+}
+
 // Stop fulfills the Provider interface
 func (tp *TestProvider) Stop() {
 	return
@@ -92,6 +92,16 @@ func (c *MiscreantCipher) Decrypt(joined []byte) ([]byte, error) {
 // Marshal marshals the interface state as JSON, encrypts the JSON using the cipher
 // and base64 encodes the binary value as a string and returns the result
 func (c *MiscreantCipher) Marshal(s interface{}) (string, error) {
+	return marshal(c, s)
+}
+
+// Unmarshal takes the marshaled string, base64-decodes into a byte slice, decrypts the
+// byte slice the passed cipher, and unmarshals the resulting JSON into the struct pointer passed
+func (c *MiscreantCipher) Unmarshal(value string, s interface{}) error {
+	return unmarshal(c, value, s)
+}
+
+func marshal(c Cipher, s interface{}) (string, error) {
 	// encode json value
 	plaintext, err := json.Marshal(s)
 	if err != nil {
@@ -115,9 +125,7 @@ func (c *MiscreantCipher) Marshal(s interface{}) (string, error) {
 	return encoded, nil
 }
 
-// Unmarshal takes the marshaled string, base64-decodes into a byte slice, decrypts the
-// byte slice the passed cipher, and unmarshals the resulting JSON into the struct pointer passed
-func (c *MiscreantCipher) Unmarshal(value string, s interface{}) error {
+func unmarshal(c Cipher, value string, s interface{}) error {
 	// convert base64 string value to bytes
 	ciphertext, err := base64.RawURLEncoding.DecodeString(value)
 	if err != nil {
@@ -0,0 +1,67 @@
+package aead
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// GCMCipher provides methods to encrypt and decrypt values with AES-256-GCM, a FIPS-approved
+// AEAD. Values encrypted by a GCMCipher can't be decrypted by a MiscreantCipher of the same
+// secret, and the other way around.
+type GCMCipher struct {
+	aead cipher.AEAD
+}
+
+// NewGCMCipher returns a new AES-256-GCM Cipher for encrypting values, given a 32 byte secret.
+func NewGCMCipher(secret []byte) (*GCMCipher, error) {
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("AES-256-GCM requires a 32 byte secret, but the secret is %d bytes", len(secret))
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &GCMCipher{
+		aead: aead,
+	}, nil
+}
+
+// Encrypt a value using AES-256-GCM, with a random nonce
+func (c *GCMCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext := c.aead.Seal(nil, nonce, plaintext, nil)
+
+	// like the miscreant cipher, we return the nonce as part of the returned value
+	return append(ciphertext, nonce...), nil
+}
+
+// Decrypt a value using AES-256-GCM
+func (c *GCMCipher) Decrypt(joined []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(joined) <= nonceSize {
+		return nil, fmt.Errorf("invalid input size: %d", len(joined))
+	}
+	pivot := len(joined) - nonceSize
+	return c.aead.Open(nil, joined[pivot:], joined[:pivot], nil)
+}
+
+// Marshal marshals the interface state as JSON, encrypts the JSON using the cipher
+// and base64 encodes the binary value as a string and returns the result
+func (c *GCMCipher) Marshal(s interface{}) (string, error) {
+	return marshal(c, s)
+}
+
+// Unmarshal takes the marshaled string, base64-decodes into a byte slice, decrypts the
+// byte slice the passed cipher, and unmarshals the resulting JSON into the struct pointer passed
+func (c *GCMCipher) Unmarshal(value string, s interface{}) error {
+	return unmarshal(c, value, s)
+}
@@ -0,0 +1,64 @@
+package aead
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGCMCipher(t *testing.T) {
+	c, err := NewGCMCipher(GenerateKey())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	plaintext := []byte("my plain text value")
+	ciphertext, err := c.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("unexpected err encrypting: %v", err)
+	}
+	got, err := c.Decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("unexpected err decrypting: %v", err)
+	}
+	if !reflect.DeepEqual(plaintext, got) {
+		t.Errorf("want %q, got %q", plaintext, got)
+	}
+
+	// the nonce is random, so a value never encrypts to the same ciphertext twice
+	again, err := c.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("unexpected err encrypting: %v", err)
+	}
+	if reflect.DeepEqual(ciphertext, again) {
+		t.Errorf("expected different ciphertexts of the same value")
+	}
+
+	// tampered ciphertexts are rejected
+	ciphertext[0] ^= 0xff
+	if _, err := c.Decrypt(ciphertext); err == nil {
+		t.Errorf("expected an error decrypting a tampered ciphertext")
+	}
+	if _, err := c.Decrypt([]byte("short")); err == nil {
+		t.Errorf("expected an error decrypting a short ciphertext")
+	}
+
+	type state struct {
+		Email string
+	}
+	value, err := c.Marshal(&state{Email: "user@example.com"})
+	if err != nil {
+		t.Fatalf("unexpected err marshaling: %v", err)
+	}
+	s := &state{}
+	if err := c.Unmarshal(value, s); err != nil {
+		t.Fatalf("unexpected err unmarshaling: %v", err)
+	}
+	if s.Email != "user@example.com" {
+		t.Errorf("want user@example.com, got %q", s.Email)
+	}
+
+	// AES-256-GCM takes 32 byte secrets only
+	if _, err := NewGCMCipher(append(GenerateKey(), GenerateKey()...)); err == nil {
+		t.Errorf("expected an error creating a cipher with a 64 byte secret")
+	}
+}
@@ -0,0 +1,71 @@
+package aead
+
+import (
+	"sync"
+)
+
+// RotatingCipher is a Cipher whose key can be rotated while it's in use. Values are encrypted
+// with the current cipher, and decrypted with the current or the previous one, so that values
+// encrypted before a rotation can still be read until they're encrypted again.
+type RotatingCipher struct {
+	mux      sync.RWMutex
+	current  Cipher
+	previous Cipher
+}
+
+// NewRotatingCipher returns a RotatingCipher whose current cipher is c.
+func NewRotatingCipher(c Cipher) *RotatingCipher {
+	return &RotatingCipher{current: c}
+}
+
+// Rotate makes c the current cipher, and the current cipher the previous one.
+func (r *RotatingCipher) Rotate(c Cipher) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.previous = r.current
+	r.current = c
+}
+
+func (r *RotatingCipher) ciphers() (Cipher, Cipher) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.current, r.previous
+}
+
+// Encrypt encrypts a value with the current cipher.
+func (r *RotatingCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	current, _ := r.ciphers()
+	return current.Encrypt(plaintext)
+}
+
+// Decrypt decrypts a value with the current cipher, or the previous one if the current one
+// can't decrypt it.
+func (r *RotatingCipher) Decrypt(joined []byte) ([]byte, error) {
+	current, previous := r.ciphers()
+	plaintext, err := current.Decrypt(joined)
+	if err != nil && previous != nil {
+		if plaintext, prevErr := previous.Decrypt(joined); prevErr == nil {
+			return plaintext, nil
+		}
+	}
+	return plaintext, err
+}
+
+// Marshal marshals a value with the current cipher.
+func (r *RotatingCipher) Marshal(s interface{}) (string, error) {
+	current, _ := r.ciphers()
+	return current.Marshal(s)
+}
+
+// Unmarshal unmarshals a value with the current cipher, or the previous one if the current one
+// can't unmarshal it.
+func (r *RotatingCipher) Unmarshal(value string, s interface{}) error {
+	current, previous := r.ciphers()
+	err := current.Unmarshal(value, s)
+	if err != nil && previous != nil {
+		if prevErr := previous.Unmarshal(value, s); prevErr == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+package aead
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRotatingCipher(t *testing.T) {
+	first, err := NewMiscreantCipher(GenerateKey())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	second, err := NewMiscreantCipher(GenerateKey())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	third, err := NewMiscreantCipher(GenerateKey())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	type state struct {
+		Email string
+	}
+	want := &state{Email: "user@example.com"}
+
+	r := NewRotatingCipher(first)
+	beforeRotation, err := r.Marshal(want)
+	if err != nil {
+		t.Fatalf("unexpected err marshaling: %v", err)
+	}
+	beforeRotationBytes, err := r.Encrypt([]byte("plaintext"))
+	if err != nil {
+		t.Fatalf("unexpected err encrypting: %v", err)
+	}
+
+	r.Rotate(second)
+
+	// values encrypted with the previous cipher can still be read
+	got := &state{}
+	if err := r.Unmarshal(beforeRotation, got); err != nil {
+		t.Fatalf("unexpected err unmarshaling a value of the previous cipher: %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	plaintext, err := r.Decrypt(beforeRotationBytes)
+	if err != nil {
+		t.Fatalf("unexpected err decrypting a value of the previous cipher: %v", err)
+	}
+	if string(plaintext) != "plaintext" {
+		t.Errorf("want plaintext, got %q", plaintext)
+	}
+
+	// new values are encrypted with the current cipher
+	afterRotation, err := r.Marshal(want)
+	if err != nil {
+		t.Fatalf("unexpected err marshaling: %v", err)
+	}
+	if err := second.Unmarshal(afterRotation, &state{}); err != nil {
+		t.Errorf("value wasn't encrypted with the current cipher: %v", err)
+	}
+	if err := first.Unmarshal(afterRotation, &state{}); err == nil {
+		t.Errorf("value was encrypted with the previous cipher")
+	}
+
+	// only the previous cipher is kept
+	r.Rotate(third)
+	if err := r.Unmarshal(beforeRotation, &state{}); err == nil {
+		t.Errorf("expected an error unmarshaling a value of a cipher rotated out")
+	}
+	if _, err := r.Decrypt(beforeRotationBytes); err == nil {
+		t.Errorf("expected an error decrypting a value of a cipher rotated out")
+	}
+	if err := r.Unmarshal(afterRotation, &state{}); err != nil {
+		t.Errorf("unexpected err unmarshaling a value of the previous cipher: %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Package alerting sends alerts about outages to Slack, PagerDuty, or a generic webhook, so
+// that teams without a full observability stack can still be paged.
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+// Destination types.
+const (
+	DestinationSlack     = "slack"
+	DestinationPagerDuty = "pagerduty"
+	DestinationWebhook   = "webhook"
+)
+
+// PagerDutyEventsURL is the PagerDuty Events API v2 endpoint alerts are sent to by default.
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+const sendTimeout = 10 * time.Second
+
+// Alert describes an outage, or its end when Resolved is true.
+type Alert struct {
+	// Key identifies the condition being alerted on, e.g. "circuit_open:google". Alerts with
+	// the same key are subject to the cooldown, and are resolved together.
+	Key string `json:"key"`
+	// Summary is a human readable description of the condition.
+	Summary string `json:"summary"`
+	// Source is the component the condition affects, e.g. a provider slug.
+	Source   string    `json:"source"`
+	Resolved bool      `json:"resolved"`
+	Time     time.Time `json:"time"`
+}
+
+// Destination delivers alerts.
+type Destination interface {
+	Send(Alert) error
+}
+
+// NewDestination returns the Destination of the given type, posting to url. routingKey is
+// the integration key of a PagerDuty service, and url defaults to PagerDutyEventsURL for it.
+func NewDestination(destinationType, url, routingKey string) (Destination, error) {
+	client := &http.Client{Timeout: sendTimeout}
+	switch destinationType {
+	case DestinationSlack:
+		if url == "" {
+			return nil, fmt.Errorf("a url is required for %s alerts", destinationType)
+		}
+		return &slackDestination{url: url, client: client}, nil
+	case DestinationPagerDuty:
+		if routingKey == "" {
+			return nil, fmt.Errorf("a routing key is required for %s alerts", destinationType)
+		}
+		if url == "" {
+			url = PagerDutyEventsURL
+		}
+		return &pagerDutyDestination{url: url, routingKey: routingKey, client: client}, nil
+	case DestinationWebhook:
+		if url == "" {
+			return nil, fmt.Errorf("a url is required for %s alerts", destinationType)
+		}
+		return &webhookDestination{url: url, client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown alert destination type: %q", destinationType)
+	}
+}
+
+// slackDestination posts alerts to a Slack incoming webhook.
+type slackDestination struct {
+	url    string
+	client *http.Client
+}
+
+func (d *slackDestination) Send(alert Alert) error {
+	text := fmt.Sprintf(":rotating_light: *%s* (%s)", alert.Summary, alert.Source)
+	if alert.Resolved {
+		text = fmt.Sprintf(":white_check_mark: *Resolved: %s* (%s)", alert.Summary, alert.Source)
+	}
+	return postJSON(d.client, d.url, map[string]string{"text": text})
+}
+
+// pagerDutyDestination triggers and resolves PagerDuty incidents with the Events API v2,
+// deduplicating them by the alert key.
+type pagerDutyDestination struct {
+	url        string
+	routingKey string
+	client     *http.Client
+}
+
+func (d *pagerDutyDestination) Send(alert Alert) error {
+	event := map[string]interface{}{
+		"routing_key":  d.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    alert.Key,
+		"payload": map[string]interface{}{
+			"summary":   alert.Summary,
+			"source":    alert.Source,
+			"severity":  "critical",
+			"timestamp": alert.Time.UTC().Format(time.RFC3339),
+		},
+	}
+	if alert.Resolved {
+		event = map[string]interface{}{
+			"routing_key":  d.routingKey,
+			"event_action": "resolve",
+			"dedup_key":    alert.Key,
+		}
+	}
+	return postJSON(d.client, d.url, event)
+}
+
+// webhookDestination posts alerts as JSON.
+type webhookDestination struct {
+	url    string
+	client *http.Client
+}
+
+func (d *webhookDestination) Send(alert Alert) error {
+	return postJSON(d.client, d.url, alert)
+}
+
+func postJSON(client *http.Client, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected alert response status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Alerter sends alerts to a destination, suppressing repeats of an alert within a cooldown
+// period so that a flapping condition doesn't page anyone over and over. A nil Alerter
+// sends nothing.
+type Alerter struct {
+	destination Destination
+	cooldown    time.Duration
+
+	mu sync.Mutex
+	// firing holds the time each firing alert was last sent.
+	firing map[string]time.Time
+	now    func() time.Time
+	// send delivers an alert in the background, so that callers are never delayed.
+	send func(Alert)
+}
+
+// NewAlerter returns an Alerter sending alerts to destination at most once per cooldown.
+func NewAlerter(destination Destination, cooldown time.Duration) *Alerter {
+	a := &Alerter{
+		destination: destination,
+		cooldown:    cooldown,
+		firing:      make(map[string]time.Time),
+		now:         time.Now,
+	}
+	a.send = func(alert Alert) {
+		go a.deliver(alert)
+	}
+	return a
+}
+
+func (a *Alerter) deliver(alert Alert) {
+	err := a.destination.Send(alert)
+	if err != nil {
+		log.NewLogEntry().Error(err, fmt.Sprintf("error sending alert %q", alert.Key))
+	}
+}
+
+// Fire sends an alert, unless one with the same key was sent within the cooldown, returning
+// whether it was sent.
+func (a *Alerter) Fire(key, source, summary string) bool {
+	if a == nil {
+		return false
+	}
+
+	a.mu.Lock()
+	now := a.now()
+	last, ok := a.firing[key]
+	if ok && now.Sub(last) < a.cooldown {
+		a.mu.Unlock()
+		return false
+	}
+	a.firing[key] = now
+	a.mu.Unlock()
+
+	log.NewLogEntry().Info(fmt.Sprintf("alert firing: %s (%s)", summary, source))
+	a.send(Alert{Key: key, Source: source, Summary: summary, Time: now})
+	return true
+}
+
+// Resolve sends the resolution of a firing alert, returning false if it wasn't firing.
+func (a *Alerter) Resolve(key, source, summary string) bool {
+	if a == nil {
+		return false
+	}
+
+	a.mu.Lock()
+	_, ok := a.firing[key]
+	delete(a.firing, key)
+	now := a.now()
+	a.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	log.NewLogEntry().Info(fmt.Sprintf("alert resolved: %s (%s)", summary, source))
+	a.send(Alert{Key: key, Source: source, Summary: summary, Resolved: true, Time: now})
+	return true
+}
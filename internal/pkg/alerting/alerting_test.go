@@ -0,0 +1,151 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestDestinations(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	alert := Alert{Key: "circuit_open:google", Source: "google", Summary: "circuit breaker open", Time: now}
+	resolved := alert
+	resolved.Resolved = true
+
+	testCases := []struct {
+		name            string
+		destinationType string
+		routingKey      string
+		alert           Alert
+		expected        string
+	}{
+		{
+			name:            "slack",
+			destinationType: DestinationSlack,
+			alert:           alert,
+			expected:        `{"text":":rotating_light: *circuit breaker open* (google)"}`,
+		},
+		{
+			name:            "slack resolved",
+			destinationType: DestinationSlack,
+			alert:           resolved,
+			expected:        `{"text":":white_check_mark: *Resolved: circuit breaker open* (google)"}`,
+		},
+		{
+			name:            "pagerduty",
+			destinationType: DestinationPagerDuty,
+			routingKey:      "routing-key",
+			alert:           alert,
+			expected: `{"dedup_key":"circuit_open:google","event_action":"trigger",` +
+				`"payload":{"severity":"critical","source":"google","summary":"circuit breaker open",` +
+				`"timestamp":"2019-01-01T12:00:00Z"},"routing_key":"routing-key"}`,
+		},
+		{
+			name:            "pagerduty resolved",
+			destinationType: DestinationPagerDuty,
+			routingKey:      "routing-key",
+			alert:           resolved,
+			expected:        `{"dedup_key":"circuit_open:google","event_action":"resolve","routing_key":"routing-key"}`,
+		},
+		{
+			name:            "webhook",
+			destinationType: DestinationWebhook,
+			alert:           alert,
+			expected: `{"key":"circuit_open:google","summary":"circuit breaker open","source":"google",` +
+				`"resolved":false,"time":"2019-01-01T12:00:00Z"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var body json.RawMessage
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				err := json.NewDecoder(req.Body).Decode(&body)
+				testutil.Assert(t, err == nil, "unexpected error decoding alert: %s", err)
+			}))
+			defer server.Close()
+
+			d, err := NewDestination(tc.destinationType, server.URL, tc.routingKey)
+			testutil.Assert(t, err == nil, "unexpected error creating destination: %s", err)
+			err = d.Send(tc.alert)
+			testutil.Assert(t, err == nil, "unexpected error sending alert: %s", err)
+			testutil.Equal(t, tc.expected, string(body))
+		})
+	}
+}
+
+func TestNewDestinationErrors(t *testing.T) {
+	_, err := NewDestination(DestinationSlack, "", "")
+	testutil.NotEqual(t, nil, err)
+	_, err = NewDestination(DestinationPagerDuty, "", "")
+	testutil.NotEqual(t, nil, err)
+	_, err = NewDestination("email", "https://example.com", "")
+	testutil.NotEqual(t, nil, err)
+
+	d, err := NewDestination(DestinationPagerDuty, "", "routing-key")
+	testutil.Assert(t, err == nil, "unexpected error creating destination: %s", err)
+	testutil.Equal(t, PagerDutyEventsURL, d.(*pagerDutyDestination).url)
+}
+
+func TestAlerterCooldown(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	sent := []Alert{}
+
+	a := NewAlerter(nil, 15*time.Minute)
+	a.now = func() time.Time { return now }
+	a.send = func(alert Alert) { sent = append(sent, alert) }
+
+	testutil.Equal(t, false, a.Resolve("circuit_open:google", "google", "circuit breaker open"))
+	testutil.Equal(t, true, a.Fire("circuit_open:google", "google", "circuit breaker open"))
+	testutil.Equal(t, true, a.Fire("circuit_open:okta", "okta", "circuit breaker open"))
+
+	now = now.Add(time.Minute)
+	testutil.Equal(t, false, a.Fire("circuit_open:google", "google", "circuit breaker open"))
+
+	now = now.Add(15 * time.Minute)
+	testutil.Equal(t, true, a.Fire("circuit_open:google", "google", "circuit breaker open"))
+	testutil.Equal(t, true, a.Resolve("circuit_open:google", "google", "circuit breaker open"))
+	testutil.Equal(t, false, a.Resolve("circuit_open:google", "google", "circuit breaker open"))
+
+	// a resolved alert fires again immediately
+	testutil.Equal(t, true, a.Fire("circuit_open:google", "google", "circuit breaker open"))
+
+	testutil.Equal(t, 5, len(sent))
+	testutil.Equal(t, true, sent[3].Resolved)
+	testutil.Equal(t, "okta", sent[1].Source)
+}
+
+func TestNilAlerter(t *testing.T) {
+	var a *Alerter
+	testutil.Equal(t, false, a.Fire("key", "source", "summary"))
+	testutil.Equal(t, false, a.Resolve("key", "source", "summary"))
+}
+
+func TestErrorRate(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := NewErrorRate(time.Minute)
+
+	rate, total := r.Record(now, false)
+	testutil.Equal(t, 0.0, rate)
+	testutil.Equal(t, 1, total)
+
+	r.Record(now.Add(10*time.Second), true)
+	r.Record(now.Add(20*time.Second), true)
+	rate, total = r.Record(now.Add(30*time.Second), false)
+	testutil.Equal(t, 0.5, rate)
+	testutil.Equal(t, 4, total)
+
+	// the first two outcomes have left the window
+	rate, total = r.Record(now.Add(75*time.Second), true)
+	testutil.Equal(t, 2.0/3.0, rate)
+	testutil.Equal(t, 3, total)
+
+	// everything has left the window
+	rate, total = r.Record(now.Add(10*time.Minute), false)
+	testutil.Equal(t, 0.0, rate)
+	testutil.Equal(t, 1, total)
+}
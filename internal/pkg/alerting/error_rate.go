@@ -0,0 +1,60 @@
+package alerting
+
+import (
+	"sync"
+	"time"
+)
+
+// errorRateBuckets is the number of buckets a window is divided into. Outcomes expire from
+// the window one bucket at a time.
+const errorRateBuckets = 10
+
+// ErrorRate tracks the proportion of operations that failed within a sliding window.
+type ErrorRate struct {
+	bucketSize time.Duration
+
+	mu      sync.Mutex
+	buckets [errorRateBuckets]rateBucket
+}
+
+type rateBucket struct {
+	index  int64
+	total  int
+	failed int
+}
+
+// NewErrorRate returns an ErrorRate over the given window.
+func NewErrorRate(window time.Duration) *ErrorRate {
+	bucketSize := window / errorRateBuckets
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	return &ErrorRate{bucketSize: bucketSize}
+}
+
+// Record records the outcome of an operation at time now, returning the error rate and
+// number of operations within the window ending at now.
+func (r *ErrorRate) Record(now time.Time, failed bool) (rate float64, total int) {
+	index := now.UnixNano() / int64(r.bucketSize)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucket := &r.buckets[index%errorRateBuckets]
+	if bucket.index != index {
+		*bucket = rateBucket{index: index}
+	}
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+
+	failures := 0
+	for _, b := range r.buckets {
+		if index-b.index < errorRateBuckets {
+			total += b.total
+			failures += b.failed
+		}
+	}
+	return float64(failures) / float64(total), total
+}
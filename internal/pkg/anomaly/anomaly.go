@@ -0,0 +1,311 @@
+// Package anomaly flags suspicious activity by authenticated users, such as a session that
+// is used from two places too far apart to travel between in the time between requests.
+package anomaly
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/geoip"
+)
+
+// Activity is a request made by an authenticated user.
+type Activity struct {
+	// Email is the email address of the user.
+	Email string
+	// Session identifies the user's session, distinguishing it from their other sessions.
+	Session string
+	// Host is the host the request was made to.
+	Host       string
+	RemoteAddr string
+	UserAgent  string
+	// Location is where the request came from, as far as is known.
+	Location geoip.Location
+	// StatusCode is the status of the response to the request, or zero if it is not known.
+	StatusCode int
+	Time       time.Time
+}
+
+// Anomaly is suspicious activity flagged by a Detector.
+type Anomaly struct {
+	// Detector is the name of the Detector that flagged the activity.
+	Detector string
+	// Reason describes what is suspicious about the activity.
+	Reason   string
+	Activity Activity
+}
+
+// Detector observes each activity in turn, flagging those that are suspicious. Detectors
+// must be safe for concurrent use.
+type Detector interface {
+	// Name returns the name the detector is configured by and reported with.
+	Name() string
+	// Observe records an activity, returning an Anomaly if it is suspicious, and nil otherwise.
+	Observe(activity Activity) *Anomaly
+	// Forget discards everything the detector has recorded about a user.
+	Forget(email string)
+}
+
+// idleTimeout is how long detectors remember a user or session after its last activity.
+const idleTimeout = 24 * time.Hour
+
+// pruneInterval is how often detectors forget users and sessions that have been idle.
+const pruneInterval = time.Hour
+
+// activityLog holds the last activity of each user or session.
+type activityLog struct {
+	activities map[string]Activity
+	pruned     time.Time
+}
+
+func newActivityLog() *activityLog {
+	return &activityLog{activities: make(map[string]Activity)}
+}
+
+// swap records the activity of a key, returning its last activity, if any, within idleTimeout.
+func (l *activityLog) swap(key string, activity Activity) (Activity, bool) {
+	if activity.Time.Sub(l.pruned) > pruneInterval {
+		for k, a := range l.activities {
+			if activity.Time.Sub(a.Time) > idleTimeout {
+				delete(l.activities, k)
+			}
+		}
+		l.pruned = activity.Time
+	}
+
+	last, ok := l.activities[key]
+	l.activities[key] = activity
+	if ok && activity.Time.Sub(last.Time) > idleTimeout {
+		return Activity{}, false
+	}
+	return last, ok
+}
+
+// forget discards the activities of a user.
+func (l *activityLog) forget(email string) {
+	for k, a := range l.activities {
+		if a.Email == email {
+			delete(l.activities, k)
+		}
+	}
+}
+
+// ImpossibleTravelDetector flags a user whose consecutive requests come from places too far
+// apart to have travelled between in the time between them. It requires locations with
+// coordinates, which are only known with a city database.
+type ImpossibleTravelDetector struct {
+	// MaxSpeed is the speed, in km/h, above which travel is impossible.
+	MaxSpeed float64
+	// MinDistance is the distance, in km, below which travel is never flagged, as
+	// the coordinates of addresses are only approximate.
+	MinDistance float64
+
+	mu   sync.Mutex
+	last *activityLog
+}
+
+// NewImpossibleTravelDetector returns an ImpossibleTravelDetector flagging travel faster
+// than maxSpeed km/h.
+func NewImpossibleTravelDetector(maxSpeed float64) *ImpossibleTravelDetector {
+	return &ImpossibleTravelDetector{
+		MaxSpeed:    maxSpeed,
+		MinDistance: 500,
+		last:        newActivityLog(),
+	}
+}
+
+// Name implements Detector.
+func (d *ImpossibleTravelDetector) Name() string {
+	return "impossible_travel"
+}
+
+// Observe implements Detector.
+func (d *ImpossibleTravelDetector) Observe(activity Activity) *Anomaly {
+	if !activity.Location.HasCoordinates {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	last, ok := d.last.swap(activity.Email, activity)
+	if !ok {
+		return nil
+	}
+
+	distance := haversine(last.Location, activity.Location)
+	if distance < d.MinDistance {
+		return nil
+	}
+	// requests in quick succession from far apart are impossible at any speed
+	hours := math.Max(activity.Time.Sub(last.Time).Hours(), time.Minute.Hours())
+	if distance/hours <= d.MaxSpeed {
+		return nil
+	}
+
+	return &Anomaly{
+		Detector: d.Name(),
+		Reason: "travelled " + formatKilometres(distance) + " from " + locationName(last) +
+			" since " + last.Time.UTC().Format(time.RFC3339),
+		Activity: activity,
+	}
+}
+
+// Forget implements Detector.
+func (d *ImpossibleTravelDetector) Forget(email string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last.forget(email)
+}
+
+// UserAgentChangeDetector flags a session whose user agent changes, which may mean its
+// cookie has been copied to another machine.
+type UserAgentChangeDetector struct {
+	mu   sync.Mutex
+	last *activityLog
+}
+
+// NewUserAgentChangeDetector returns a UserAgentChangeDetector.
+func NewUserAgentChangeDetector() *UserAgentChangeDetector {
+	return &UserAgentChangeDetector{
+		last: newActivityLog(),
+	}
+}
+
+// Name implements Detector.
+func (d *UserAgentChangeDetector) Name() string {
+	return "user_agent_change"
+}
+
+// Observe implements Detector.
+func (d *UserAgentChangeDetector) Observe(activity Activity) *Anomaly {
+	if activity.Session == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	last, ok := d.last.swap(activity.Session, activity)
+	if !ok || last.UserAgent == activity.UserAgent {
+		return nil
+	}
+
+	return &Anomaly{
+		Detector: d.Name(),
+		Reason:   "user agent changed from " + last.UserAgent,
+		Activity: activity,
+	}
+}
+
+// Forget implements Detector.
+func (d *UserAgentChangeDetector) Forget(email string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last.forget(email)
+}
+
+// ExcessiveForbiddenDetector flags a user who receives too many forbidden responses in a
+// period of time, which may mean they are probing for resources they can't access.
+type ExcessiveForbiddenDetector struct {
+	// Max is the number of forbidden responses within Window that is excessive.
+	Max    int
+	Window time.Duration
+
+	mu        sync.Mutex
+	forbidden map[string][]time.Time
+	pruned    time.Time
+}
+
+// NewExcessiveForbiddenDetector returns an ExcessiveForbiddenDetector flagging users who
+// receive max forbidden responses within window.
+func NewExcessiveForbiddenDetector(max int, window time.Duration) *ExcessiveForbiddenDetector {
+	return &ExcessiveForbiddenDetector{
+		Max:       max,
+		Window:    window,
+		forbidden: make(map[string][]time.Time),
+	}
+}
+
+// Name implements Detector.
+func (d *ExcessiveForbiddenDetector) Name() string {
+	return "excessive_forbidden"
+}
+
+// Observe implements Detector.
+func (d *ExcessiveForbiddenDetector) Observe(activity Activity) *Anomaly {
+	if activity.StatusCode != http.StatusForbidden {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if activity.Time.Sub(d.pruned) > pruneInterval {
+		for email, times := range d.forbidden {
+			if activity.Time.Sub(times[len(times)-1]) >= d.Window {
+				delete(d.forbidden, email)
+			}
+		}
+		d.pruned = activity.Time
+	}
+
+	times := []time.Time{}
+	for _, t := range d.forbidden[activity.Email] {
+		if activity.Time.Sub(t) < d.Window {
+			times = append(times, t)
+		}
+	}
+	times = append(times, activity.Time)
+
+	if len(times) < d.Max {
+		d.forbidden[activity.Email] = times
+		return nil
+	}
+
+	// start counting afresh, so the user is flagged once for each Max forbidden responses
+	delete(d.forbidden, activity.Email)
+	return &Anomaly{
+		Detector: d.Name(),
+		Reason:   strconv.Itoa(len(times)) + " forbidden responses within " + d.Window.String(),
+		Activity: activity,
+	}
+}
+
+// Forget implements Detector.
+func (d *ExcessiveForbiddenDetector) Forget(email string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.forbidden, email)
+}
+
+// earthRadius is the mean radius of the earth in km.
+const earthRadius = 6371.0
+
+// haversine returns the great-circle distance between two locations in km.
+func haversine(a, b geoip.Location) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat := lat2 - lat1
+	dLon := radians(b.Longitude - a.Longitude)
+
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func formatKilometres(km float64) string {
+	return strconv.FormatFloat(km, 'f', 0, 64) + "km"
+}
+
+func locationName(activity Activity) string {
+	if activity.Location.Country != "" {
+		return activity.Location.Country + " (" + activity.RemoteAddr + ")"
+	}
+	return activity.RemoteAddr
+}
@@ -0,0 +1,126 @@
+package anomaly
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/geoip"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+var (
+	newYork = geoip.Location{Country: "US", Latitude: 40.71, Longitude: -74.01, HasCoordinates: true}
+	boston  = geoip.Location{Country: "US", Latitude: 42.36, Longitude: -71.06, HasCoordinates: true}
+	london  = geoip.Location{Country: "GB", Latitude: 51.51, Longitude: -0.13, HasCoordinates: true}
+)
+
+func TestImpossibleTravelDetector(t *testing.T) {
+	start := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		second   Activity
+		expected bool
+	}{
+		{
+			name:   "same place",
+			second: Activity{Email: "foo@example.com", Location: newYork, Time: start.Add(time.Minute)},
+		},
+		{
+			name:   "nearby places",
+			second: Activity{Email: "foo@example.com", Location: boston, Time: start.Add(time.Minute)},
+		},
+		{
+			name:   "unknown location",
+			second: Activity{Email: "foo@example.com", Location: geoip.Location{Country: "GB"}, Time: start.Add(time.Minute)},
+		},
+		{
+			name:   "another user",
+			second: Activity{Email: "bar@example.com", Location: london, Time: start.Add(time.Minute)},
+		},
+		{
+			name:   "possible travel",
+			second: Activity{Email: "foo@example.com", Location: london, Time: start.Add(8 * time.Hour)},
+		},
+		{
+			name:     "impossible travel",
+			second:   Activity{Email: "foo@example.com", Location: london, Time: start.Add(time.Hour)},
+			expected: true,
+		},
+		{
+			name:     "simultaneous requests",
+			second:   Activity{Email: "foo@example.com", Location: london, Time: start},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewImpossibleTravelDetector(1000)
+			first := Activity{Email: "foo@example.com", Location: newYork, Time: start}
+			testutil.Assert(t, d.Observe(first) == nil, "expected first activity not to be flagged")
+
+			anomaly := d.Observe(tc.second)
+			testutil.Equal(t, tc.expected, anomaly != nil)
+			if anomaly != nil {
+				testutil.Equal(t, "impossible_travel", anomaly.Detector)
+				testutil.Equal(t, tc.second, anomaly.Activity)
+			}
+		})
+	}
+}
+
+func TestUserAgentChangeDetector(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := NewUserAgentChangeDetector()
+
+	activity := Activity{Email: "foo@example.com", Session: "a", UserAgent: "Firefox", Time: now}
+	testutil.Assert(t, d.Observe(activity) == nil, "expected first activity not to be flagged")
+	testutil.Assert(t, d.Observe(activity) == nil, "expected same user agent not to be flagged")
+
+	other := Activity{Email: "foo@example.com", Session: "b", UserAgent: "curl", Time: now}
+	testutil.Assert(t, d.Observe(other) == nil, "expected another session not to be flagged")
+
+	activity.UserAgent = "curl"
+	anomaly := d.Observe(activity)
+	testutil.Assert(t, anomaly != nil, "expected changed user agent to be flagged")
+	testutil.Equal(t, "user agent changed from Firefox", anomaly.Reason)
+
+	activity.UserAgent = "Firefox"
+	activity.Time = now.Add(48 * time.Hour)
+	testutil.Assert(t, d.Observe(activity) == nil, "expected idle session to be forgotten")
+
+	// a forgotten user's sessions are observed afresh
+	d.Forget("foo@example.com")
+	activity.UserAgent = "curl"
+	testutil.Assert(t, d.Observe(activity) == nil, "expected forgotten session not to be flagged")
+}
+
+func TestExcessiveForbiddenDetector(t *testing.T) {
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := NewExcessiveForbiddenDetector(3, time.Minute)
+
+	forbidden := func(email string, at time.Duration) *Anomaly {
+		return d.Observe(Activity{Email: email, StatusCode: http.StatusForbidden, Time: now.Add(at)})
+	}
+
+	testutil.Assert(t, forbidden("foo@example.com", 0) == nil, "expected first response not to be flagged")
+	testutil.Assert(t, d.Observe(Activity{
+		Email: "foo@example.com", StatusCode: http.StatusOK, Time: now.Add(time.Second),
+	}) == nil, "expected ok response not to be flagged")
+	testutil.Assert(t, forbidden("bar@example.com", 2*time.Second) == nil, "expected other user not to be flagged")
+	testutil.Assert(t, forbidden("foo@example.com", 3*time.Second) == nil, "expected second response not to be flagged")
+
+	anomaly := forbidden("foo@example.com", 4*time.Second)
+	testutil.Assert(t, anomaly != nil, "expected third response to be flagged")
+	testutil.Equal(t, "3 forbidden responses within 1m0s", anomaly.Reason)
+
+	testutil.Assert(t, forbidden("foo@example.com", 5*time.Second) == nil, "expected count to start afresh")
+	testutil.Assert(t, forbidden("foo@example.com", 2*time.Minute) == nil, "expected old responses to be forgotten")
+	testutil.Assert(t, forbidden("foo@example.com", 3*time.Minute) == nil, "expected old responses to be forgotten")
+
+	testutil.Assert(t, forbidden("foo@example.com", 3*time.Minute+time.Second) == nil, "expected second response not to be flagged")
+	d.Forget("foo@example.com")
+	testutil.Assert(t, forbidden("foo@example.com", 3*time.Minute+2*time.Second) == nil, "expected forgotten responses not to be counted")
+}
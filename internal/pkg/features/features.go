@@ -0,0 +1,245 @@
+// Package features rolls out risky new behaviors to a percentage of traffic, per upstream, so
+// that they can be tried on a fraction of users and rolled back without a deploy.
+package features
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// Flag is the rollout of a feature: the percentage of traffic it's enabled for, and the
+// percentages of upstreams that override it.
+type Flag struct {
+	Percent   float64            `yaml:"percent"`
+	Upstreams map[string]float64 `yaml:"upstreams"`
+}
+
+// Flags are the feature flags, set from the environment and optionally a file, which is
+// reloaded so that flags can be changed while running. The flags of the file override those
+// of the environment.
+type Flags struct {
+	env  map[string]Flag
+	file string
+
+	mu    sync.RWMutex
+	flags map[string]Flag
+}
+
+// New returns the Flags set by the environment, and by the file if it isn't empty. The flags
+// of the environment are given as a map of the flag name to its percentage, where a name of
+// the form "flag/upstream" sets the percentage of an upstream, e.g.
+// {"new_signer": "10", "new_signer/wiki": "100"}.
+func New(env map[string]string, file string) (*Flags, error) {
+	parsed, err := parseEnv(env)
+	if err != nil {
+		return nil, err
+	}
+	f := &Flags{env: parsed, file: file}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseEnv(env map[string]string) (map[string]Flag, error) {
+	flags := make(map[string]Flag, len(env))
+	// flag percentages are set before those of upstreams, whatever order they're given in
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		percent, err := parsePercent(env[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid feature flag %q: %v", name, err)
+		}
+		parts := strings.SplitN(name, "/", 2)
+		flagName := strings.TrimSpace(parts[0])
+		if flagName == "" {
+			return nil, fmt.Errorf("invalid feature flag %q: missing name", name)
+		}
+		flag := flags[flagName]
+		if len(parts) == 1 {
+			flag.Percent = percent
+		} else {
+			if flag.Upstreams == nil {
+				flag.Upstreams = map[string]float64{}
+			}
+			flag.Upstreams[strings.TrimSpace(parts[1])] = percent
+		}
+		flags[flagName] = flag
+	}
+	return flags, nil
+}
+
+func parsePercent(s string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a percentage", s)
+	}
+	if err := validPercent(percent); err != nil {
+		return 0, err
+	}
+	return percent, nil
+}
+
+func validPercent(percent float64) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("percentage must be between 0 and 100 but is: %v", percent)
+	}
+	return nil
+}
+
+// load reads the flags of the file, and merges them with those of the environment.
+func (f *Flags) load() error {
+	flags := make(map[string]Flag, len(f.env))
+	for name, flag := range f.env {
+		flags[name] = flag
+	}
+
+	if f.file != "" {
+		data, err := ioutil.ReadFile(f.file)
+		if err != nil {
+			return fmt.Errorf("error reading feature flags file: %v", err)
+		}
+		fileFlags := map[string]Flag{}
+		if err := yaml.UnmarshalStrict(data, &fileFlags); err != nil {
+			return fmt.Errorf("error parsing feature flags file: %v", err)
+		}
+		for name, flag := range fileFlags {
+			if err := validPercent(flag.Percent); err != nil {
+				return fmt.Errorf("invalid feature flag %q: %v", name, err)
+			}
+			for upstream, percent := range flag.Upstreams {
+				if err := validPercent(percent); err != nil {
+					return fmt.Errorf("invalid feature flag %q of upstream %s: %v", name, upstream, err)
+				}
+			}
+			flags[name] = flag
+		}
+	}
+
+	f.mu.Lock()
+	f.flags = flags
+	f.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the file at the interval, forever. A file that can't be loaded is logged and
+// the flags are left unchanged, so a bad edit doesn't roll every feature back.
+func (f *Flags) Watch(interval time.Duration) {
+	if f == nil || f.file == "" {
+		return
+	}
+	logger := log.NewLogEntry()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		previous := f.Snapshot()
+		if err := f.load(); err != nil {
+			logger.Error(err, "error reloading feature flags; the flags are unchanged")
+			continue
+		}
+		if !reflect.DeepEqual(previous, f.Snapshot()) {
+			logger.Info(fmt.Sprintf("reloaded feature flags: %s", f))
+		}
+	}
+}
+
+// Snapshot returns a copy of the current flags.
+func (f *Flags) Snapshot() map[string]Flag {
+	if f == nil {
+		return map[string]Flag{}
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	flags := make(map[string]Flag, len(f.flags))
+	for name, flag := range f.flags {
+		flags[name] = flag
+	}
+	return flags
+}
+
+// Enabled returns true if the feature is enabled for the traffic of the upstream identified by
+// the key, e.g. the email of a user, which always gets the same result for a percentage so that
+// its behavior doesn't flap between requests. Without a key, the result is random. Features
+// without a flag, or a nil Flags, are disabled.
+func (f *Flags) Enabled(name, upstream, key string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.RLock()
+	flag, ok := f.flags[name]
+	f.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	percent := flag.Percent
+	if p, ok := flag.Upstreams[upstream]; ok {
+		percent = p
+	}
+	switch {
+	case percent <= 0:
+		return false
+	case percent >= 100:
+		return true
+	case key == "":
+		return rand.Float64()*100 < percent
+	default:
+		return bucket(name, key) < percent
+	}
+}
+
+// bucket places a key in [0, 100) for a feature. The bucket depends on the feature, so that the
+// same keys aren't always the first to get every new feature.
+func bucket(name, key string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return float64(h.Sum32()%10000) / 100
+}
+
+// String implements the fmt.Stringer interface, listing the flags and their percentages.
+func (f *Flags) String() string {
+	flags := f.Snapshot()
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		flag := flags[name]
+		part := fmt.Sprintf("%s=%v%%", name, flag.Percent)
+		upstreams := make([]string, 0, len(flag.Upstreams))
+		for upstream := range flag.Upstreams {
+			upstreams = append(upstreams, upstream)
+		}
+		sort.Strings(upstreams)
+		for _, upstream := range upstreams {
+			part += fmt.Sprintf(" %s/%s=%v%%", name, upstream, flag.Upstreams[upstream])
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
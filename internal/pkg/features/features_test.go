@@ -0,0 +1,143 @@
+package features
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sso-features")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "features.yml")
+	err = ioutil.WriteFile(file, []byte(`
+new_cipher:
+  percent: 5
+  upstreams:
+    wiki: 50
+`), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error writing flags file: %v", err)
+	}
+
+	flags, err := New(map[string]string{
+		"new_signer/wiki": "100",
+		"new_signer":      "10%",
+		"new_cipher":      "100",
+	}, file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]Flag{
+		"new_signer": {Percent: 10, Upstreams: map[string]float64{"wiki": 100}},
+		"new_cipher": {Percent: 5, Upstreams: map[string]float64{"wiki": 50}},
+	}
+	if got := flags.Snapshot(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected flags %#v, got %#v", expected, got)
+	}
+	if want, got := "new_cipher=5% new_cipher/wiki=50%, new_signer=10% new_signer/wiki=100%", flags.String(); want != got {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// the file is reloaded, and overrides the environment
+	err = ioutil.WriteFile(file, []byte("new_signer:\n  percent: 0\n"), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error writing flags file: %v", err)
+	}
+	if err := flags.load(); err != nil {
+		t.Fatalf("unexpected error reloading flags: %v", err)
+	}
+	if flags.Enabled("new_signer", "wiki", "user@example.com") {
+		t.Errorf("expected a flag rolled back by the file to be disabled")
+	}
+	if !flags.Enabled("new_cipher", "wiki", "user@example.com") {
+		t.Errorf("expected a flag of the environment to be enabled")
+	}
+
+	// a file that can't be loaded leaves the flags unchanged
+	err = ioutil.WriteFile(file, []byte("new_signer:\n  percent: 150\n"), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error writing flags file: %v", err)
+	}
+	if err := flags.load(); err == nil {
+		t.Errorf("expected an error loading an invalid percentage")
+	}
+	if flags.Enabled("new_signer", "wiki", "user@example.com") {
+		t.Errorf("expected the flags to be unchanged")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	testCases := []map[string]string{
+		{"new_signer": "all"},
+		{"new_signer": "101"},
+		{"new_signer/wiki": "-1"},
+		{"/wiki": "10"},
+	}
+	for _, env := range testCases {
+		if _, err := New(env, ""); err == nil {
+			t.Errorf("expected an error for %v", env)
+		}
+	}
+	if _, err := New(nil, "/does/not/exist.yml"); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	flags, err := New(map[string]string{
+		"half":         "50",
+		"half/wiki":    "0",
+		"half/docs":    "100",
+		"disabled":     "0",
+		"everyone":     "100",
+		"quarter":      "25",
+		"quarter/wiki": "25",
+	}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	enabled := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user%d@example.com", i)
+		if flags.Enabled("half", "", key) {
+			enabled++
+		}
+		if flags.Enabled("half", "wiki", key) {
+			t.Errorf("expected a flag disabled for an upstream to be disabled")
+		}
+		if !flags.Enabled("half", "docs", key) {
+			t.Errorf("expected a flag enabled for an upstream to be enabled")
+		}
+		if flags.Enabled("half", "", key) != flags.Enabled("half", "", key) {
+			t.Errorf("expected the same key to always get the same result")
+		}
+		if flags.Enabled("quarter", "wiki", key) != flags.Enabled("quarter", "docs", key) {
+			t.Errorf("expected the same percentage to get the same result on every upstream")
+		}
+	}
+	if enabled < 400 || enabled > 600 {
+		t.Errorf("expected about half of the keys to be enabled, got %d of 1000", enabled)
+	}
+
+	if flags.Enabled("disabled", "", "user@example.com") || flags.Enabled("unknown", "", "user@example.com") {
+		t.Errorf("expected disabled and unknown flags to be disabled")
+	}
+	if !flags.Enabled("everyone", "", "") {
+		t.Errorf("expected a flag enabled for everyone to be enabled")
+	}
+
+	var nilFlags *Flags
+	if nilFlags.Enabled("everyone", "", "") {
+		t.Errorf("expected nil flags to be disabled")
+	}
+}
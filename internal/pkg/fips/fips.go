@@ -0,0 +1,132 @@
+// Package fips implements the FIPS mode of sso, which restricts the ciphers, hashes, keys, and
+// TLS settings sso uses to FIPS-approved algorithms. FIPS mode is a property of the process: it's
+// enabled once at startup, and binaries built with the fips build tag always run in FIPS mode.
+//
+// FIPS mode only restricts which algorithms are used. A FIPS 140 validated deployment also needs
+// a Go toolchain whose crypto is backed by a validated module.
+package fips
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+var enabled int32
+
+// CipherSuites are the FIPS-approved TLS 1.2 cipher suites outbound connections may negotiate.
+var CipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// CurvePreferences are the FIPS-approved curves of TLS key exchanges.
+var CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+// Enable turns FIPS mode on for the process, and restricts the TLS connections of the default
+// HTTP transport. It's called at startup, before any outbound connection is made.
+func Enable() {
+	atomic.StoreInt32(&enabled, 1)
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.TLSClientConfig = TLSConfig(t.TLSClientConfig)
+	}
+}
+
+// Enabled returns true if FIPS mode is on, either because it was enabled or because the binary
+// was built with the fips build tag.
+func Enabled() bool {
+	return Required || atomic.LoadInt32(&enabled) == 1
+}
+
+// TLSConfig returns a copy of a TLS config restricted to TLS 1.2 and the FIPS-approved cipher
+// suites and curves if FIPS mode is on, and the config unchanged otherwise. Cipher suites the
+// config already restricts connections to are kept if they're approved. The cipher suites of
+// TLS 1.3 can't be restricted, so it isn't negotiated in FIPS mode. c may be nil.
+func TLSConfig(c *tls.Config) *tls.Config {
+	if !Enabled() {
+		return c
+	}
+	if c == nil {
+		c = &tls.Config{}
+	}
+	restricted := c.Clone()
+	restricted.MinVersion = tls.VersionTLS12
+	restricted.MaxVersion = tls.VersionTLS12
+	restricted.CipherSuites = approvedCipherSuites(c.CipherSuites)
+	restricted.CurvePreferences = CurvePreferences
+	return restricted
+}
+
+// approvedCipherSuites returns the approved cipher suites among those of a config, or every
+// approved cipher suite if none of them are.
+func approvedCipherSuites(suites []uint16) []uint16 {
+	approved := []uint16{}
+	for _, suite := range suites {
+		if CheckCipherSuite(suite) == nil {
+			approved = append(approved, suite)
+		}
+	}
+	if len(approved) == 0 {
+		return CipherSuites
+	}
+	return approved
+}
+
+// CheckCipherSuite returns an error if a TLS cipher suite isn't FIPS-approved.
+func CheckCipherSuite(id uint16) error {
+	for _, suite := range CipherSuites {
+		if suite == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a FIPS-approved cipher suite", tls.CipherSuiteName(id))
+}
+
+// CheckHash returns an error if a hash isn't FIPS-approved for the HMACs and signatures of sso.
+// SHA-1 is refused, even though FIPS still allows it in HMACs.
+func CheckHash(h crypto.Hash) error {
+	switch h {
+	case crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512:
+		return nil
+	default:
+		return fmt.Errorf("%s is not a FIPS-approved hash", hashName(h))
+	}
+}
+
+func hashName(h crypto.Hash) string {
+	switch h {
+	case crypto.MD5:
+		return "MD5"
+	case crypto.SHA1:
+		return "SHA-1"
+	default:
+		return fmt.Sprintf("hash %d", h)
+	}
+}
+
+// CheckPublicKey returns an error if a signing key isn't FIPS-approved: RSA keys must be at least
+// 2048 bits, and ECDSA keys must be on the P-256 or P-384 curves. Ed25519 keys are refused, since
+// many FIPS 140 validated modules don't support them.
+func CheckPublicKey(key crypto.PublicKey) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return fmt.Errorf("%d bit RSA keys are not FIPS-approved, keys must be at least 2048 bits", key.N.BitLen())
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() && key.Curve != elliptic.P384() {
+			return fmt.Errorf("ECDSA keys on the %s curve are not FIPS-approved", key.Curve.Params().Name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%T keys are not FIPS-approved", key)
+	}
+}
@@ -0,0 +1,94 @@
+package fips
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"sync/atomic"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestTLSConfig(t *testing.T) {
+	if Required {
+		t.Skip("FIPS mode can't be turned off in binaries built with the fips build tag")
+	}
+	defer atomic.StoreInt32(&enabled, 0)
+
+	c := &tls.Config{InsecureSkipVerify: true}
+	testutil.Equal(t, c, TLSConfig(c))
+	testutil.Equal(t, (*tls.Config)(nil), TLSConfig(nil))
+
+	atomic.StoreInt32(&enabled, 1)
+	testutil.Equal(t, true, Enabled())
+	restricted := TLSConfig(c)
+	testutil.Equal(t, true, restricted.InsecureSkipVerify)
+	testutil.Equal(t, uint16(tls.VersionTLS12), restricted.MinVersion)
+	testutil.Equal(t, uint16(tls.VersionTLS12), restricted.MaxVersion)
+	testutil.Equal(t, CipherSuites, restricted.CipherSuites)
+	testutil.Equal(t, CurvePreferences, restricted.CurvePreferences)
+	// the config passed in is left unchanged
+	testutil.Equal(t, uint16(0), c.MinVersion)
+	testutil.Equal(t, CipherSuites, TLSConfig(nil).CipherSuites)
+
+	// approved cipher suites of the config are kept, and the others are dropped
+	c.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_RSA_WITH_AES_128_CBC_SHA}
+	testutil.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, TLSConfig(c).CipherSuites)
+	c.CipherSuites = []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}
+	testutil.Equal(t, CipherSuites, TLSConfig(c).CipherSuites)
+}
+
+func TestCheckCipherSuite(t *testing.T) {
+	testutil.Ok(t, CheckCipherSuite(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256))
+	testutil.NotEqual(t, nil, CheckCipherSuite(tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256))
+}
+
+func TestCheckHash(t *testing.T) {
+	for _, h := range []crypto.Hash{crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		testutil.Ok(t, CheckHash(h))
+	}
+	for _, h := range []crypto.Hash{crypto.MD5, crypto.SHA1} {
+		testutil.NotEqual(t, nil, CheckHash(h))
+	}
+}
+
+func TestCheckPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	testutil.Ok(t, err)
+	smallRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	testutil.Ok(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Ok(t, err)
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	testutil.Ok(t, err)
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	testutil.Ok(t, err)
+
+	testCases := []struct {
+		name    string
+		key     crypto.PublicKey
+		wantErr bool
+	}{
+		{name: "2048 bit RSA key", key: &rsaKey.PublicKey},
+		{name: "1024 bit RSA key", key: &smallRSAKey.PublicKey, wantErr: true},
+		{name: "P-256 ECDSA key", key: &p256Key.PublicKey},
+		{name: "P-224 ECDSA key", key: &p224Key.PublicKey, wantErr: true},
+		{name: "Ed25519 key", key: edKey, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckPublicKey(tc.key)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+			} else {
+				testutil.Ok(t, err)
+			}
+		})
+	}
+}
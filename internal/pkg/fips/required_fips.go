@@ -0,0 +1,7 @@
+//go:build fips
+// +build fips
+
+package fips
+
+// Required is true in binaries built with the fips build tag, which always run in FIPS mode.
+const Required = true
@@ -0,0 +1,216 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+)
+
+// Data section field types.
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEndMarker = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// maxDepth bounds the nesting of maps, arrays, and pointers in a corrupt database.
+const maxDepth = 64
+
+// decoder decodes values from the data section of a MaxMind DB file, in which pointers
+// are offsets from the start of buf.
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+// decode decodes the value at offset, returning it with the offset following it.
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxDepth {
+		return nil, 0, ErrInvalidDatabase
+	}
+
+	typeNum, size, offset, err := d.decodeControl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typeNum == typePointer {
+		pointer, next, err := d.decodePointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	switch typeNum {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			var key, value interface{}
+			key, offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrInvalidDatabase
+			}
+			value, offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			var value interface{}
+			value, offset, err = d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, ErrInvalidDatabase
+	}
+	b := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typeNum {
+	case typeString:
+		return string(b), next, nil
+	case typeBytes:
+		return append([]byte(nil), b...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return decodeUint(b), next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return int32(decodeUint(b)), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return new(big.Int).SetBytes(b), next, nil
+	default:
+		// data cache containers and end markers never appear in lookup results
+		return nil, 0, ErrInvalidDatabase
+	}
+}
+
+// decodeControl decodes the control byte, and any extended type and size bytes, at offset.
+func (d *decoder) decodeControl(offset uint) (typeNum, size, next uint, err error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, ErrInvalidDatabase
+	}
+	ctrl := d.buf[offset]
+	offset++
+
+	typeNum = uint(ctrl >> 5)
+	if typeNum == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, ErrInvalidDatabase
+		}
+		typeNum = uint(d.buf[offset]) + 7
+		offset++
+	}
+
+	size = uint(ctrl & 0x1f)
+	if typeNum == typePointer || size < 29 {
+		return typeNum, size, offset, nil
+	}
+
+	extra := size - 28
+	if offset+extra > uint(len(d.buf)) {
+		return 0, 0, 0, ErrInvalidDatabase
+	}
+	n := uint(decodeUint(d.buf[offset : offset+extra]))
+	switch extra {
+	case 1:
+		size = 29 + n
+	case 2:
+		size = 285 + n
+	default:
+		size = 65821 + n
+	}
+	return typeNum, size, offset + extra, nil
+}
+
+// decodePointer decodes a pointer whose control byte had the given size bits.
+func (d *decoder) decodePointer(size, offset uint) (pointer, next uint, err error) {
+	n := ((size >> 3) & 0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, ErrInvalidDatabase
+	}
+	b := d.buf[offset : offset+n]
+
+	switch n {
+	case 1:
+		pointer = (size&0x7)<<8 | uint(b[0])
+	case 2:
+		pointer = ((size&0x7)<<16 | uint(decodeUint(b))) + 2048
+	case 3:
+		pointer = ((size&0x7)<<24 | uint(decodeUint(b))) + 526336
+	default:
+		pointer = uint(decodeUint(b))
+	}
+	return pointer, offset + n, nil
+}
+
+func decodeUint(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+// toUint converts the unsigned integer types returned by decode to a uint64.
+func toUint(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return n, true
+	case *big.Int:
+		if n.IsUint64() {
+			return n.Uint64(), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,102 @@
+package geoip
+
+import (
+	"net"
+	"strings"
+)
+
+// Location is what is known about where a request came from.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code of the country, e.g. "US".
+	Country string
+	// ASN is the autonomous system number of the network.
+	ASN uint64
+	// ASOrganization is the organization that operates the autonomous system.
+	ASOrganization string
+	// Latitude and Longitude are the approximate coordinates of the address, which are
+	// only known with a city database.
+	Latitude  float64
+	Longitude float64
+	// HasCoordinates is true if Latitude and Longitude are known.
+	HasCoordinates bool
+}
+
+// Locator looks up the locations of IP addresses in a country (or city) database and an
+// optional ASN database.
+type Locator struct {
+	country *Reader
+	asn     *Reader
+}
+
+// NewLocator opens the MaxMind DB files at the given paths. Either path may be empty, in
+// which case those fields of a Location are never set.
+func NewLocator(countryPath, asnPath string) (*Locator, error) {
+	l := &Locator{}
+	var err error
+	if countryPath != "" {
+		l.country, err = Open(countryPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		l.asn, err = Open(asnPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Lookup returns the location of an address, which may include a port. Addresses that
+// can't be parsed or aren't in the databases return an empty Location.
+func (l *Locator) Lookup(addr string) Location {
+	location := Location{}
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return location
+	}
+
+	if l.country != nil {
+		record, err := l.country.Lookup(ip)
+		if err == nil {
+			location.Country = countryCode(record)
+			location.Latitude, location.Longitude, location.HasCoordinates = coordinates(record)
+		}
+	}
+	if l.asn != nil {
+		record, err := l.asn.Lookup(ip)
+		if err == nil {
+			fields, _ := record.(map[string]interface{})
+			location.ASN, _ = toUint(fields["autonomous_system_number"])
+			location.ASOrganization, _ = fields["autonomous_system_organization"].(string)
+		}
+	}
+	return location
+}
+
+// countryCode returns the country of a Country or City database record, falling back to
+// the registered country for addresses such as anycast networks with no physical location.
+func countryCode(record interface{}) string {
+	fields, _ := record.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		country, _ := fields[key].(map[string]interface{})
+		if code, ok := country["iso_code"].(string); ok && code != "" {
+			return code
+		}
+	}
+	return ""
+}
+
+// coordinates returns the latitude and longitude of a City database record.
+func coordinates(record interface{}) (latitude, longitude float64, ok bool) {
+	fields, _ := record.(map[string]interface{})
+	location, _ := fields["location"].(map[string]interface{})
+	latitude, latOK := location["latitude"].(float64)
+	longitude, lonOK := location["longitude"].(float64)
+	return latitude, longitude, latOK && lonOK
+}
@@ -0,0 +1,284 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+// testPointer is encoded as a pointer to an offset in the data section.
+type testPointer uint
+
+// testNetwork is a network and the record stored for it in a test database.
+type testNetwork struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+type testNode struct {
+	children [2]*testNode
+	index    int
+	record   int
+}
+
+// buildTestDatabase writes a MaxMind DB file containing the given networks, following
+// https://maxmind.github.io/MaxMind-DB/.
+func buildTestDatabase(t *testing.T, ipVersion, recordSize int, networks []testNetwork) []byte {
+	data := []byte{}
+	root := &testNode{record: -1}
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		testutil.Assert(t, err == nil, "invalid test network %q: %s", network.cidr, err)
+
+		ip := ipNet.IP
+		ones, _ := ipNet.Mask.Size()
+		if ipVersion == 6 && ip.To4() != nil {
+			// ipv4 networks are stored in the ::/96 subnet of ipv6 databases
+			ip = append(make(net.IP, 12), ip.To4()...)
+			ones += 96
+		}
+
+		node := root
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &testNode{record: -1}
+			}
+			node = node.children[bit]
+		}
+		node.record = len(data)
+		data = append(data, encodeTestValue(t, network.record)...)
+	}
+
+	// number the nodes that have children, which make up the search tree
+	nodes := []*testNode{}
+	var number func(*testNode)
+	number = func(n *testNode) {
+		if n == nil || (n.children[0] == nil && n.children[1] == nil) {
+			return
+		}
+		n.index = len(nodes)
+		nodes = append(nodes, n)
+		number(n.children[0])
+		number(n.children[1])
+	}
+	number(root)
+
+	nodeCount := len(nodes)
+	record := func(n *testNode) uint64 {
+		switch {
+		case n == nil:
+			return uint64(nodeCount)
+		case n.children[0] != nil || n.children[1] != nil:
+			return uint64(n.index)
+		case n.record >= 0:
+			return uint64(nodeCount + dataSectionSeparatorSize + n.record)
+		default:
+			return uint64(nodeCount)
+		}
+	}
+
+	buf := []byte{}
+	for _, n := range nodes {
+		left, right := record(n.children[0]), record(n.children[1])
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 28:
+			buf = append(buf, byte(left>>16), byte(left>>8), byte(left),
+				byte((left>>20)&0xF0|(right>>24)&0x0F),
+				byte(right>>16), byte(right>>8), byte(right))
+		case 32:
+			buf = append(buf, byte(left>>24), byte(left>>16), byte(left>>8), byte(left),
+				byte(right>>24), byte(right>>16), byte(right>>8), byte(right))
+		}
+	}
+
+	buf = append(buf, make([]byte, dataSectionSeparatorSize)...)
+	buf = append(buf, data...)
+	buf = append(buf, metadataStartMarker...)
+	buf = append(buf, encodeTestValue(t, map[string]interface{}{
+		"database_type": "Test",
+		"ip_version":    uint64(ipVersion),
+		"node_count":    uint64(nodeCount),
+		"record_size":   uint64(recordSize),
+		"build_epoch":   uint64(1546300800),
+	})...)
+	return buf
+}
+
+func encodeTestValue(t *testing.T, v interface{}) []byte {
+	control := func(typeNum, size int) []byte {
+		testutil.Assert(t, size < 285, "test values must be short")
+		extra := []byte{}
+		if size >= 29 {
+			extra = append(extra, byte(size-29))
+			size = 29
+		}
+		if typeNum > 7 {
+			return append([]byte{byte(size), byte(typeNum - 7)}, extra...)
+		}
+		return append([]byte{byte(typeNum<<5 | size)}, extra...)
+	}
+
+	switch value := v.(type) {
+	case string:
+		return append(control(typeString, len(value)), value...)
+	case float64:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(value))
+		return append(control(typeDouble, len(b)), b...)
+	case uint64:
+		b := []byte{}
+		for n := value; n > 0; n >>= 8 {
+			b = append([]byte{byte(n)}, b...)
+		}
+		return append(control(typeUint64, len(b)), b...)
+	case testPointer:
+		// two byte pointers hold offsets from 2048 up
+		testutil.Assert(t, value < 2048, "test pointers must be short")
+		return []byte{byte(typePointer<<5 | int(value>>8)), byte(value)}
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b := control(typeMap, len(value))
+		for _, key := range keys {
+			b = append(b, encodeTestValue(t, key)...)
+			b = append(b, encodeTestValue(t, value[key])...)
+		}
+		return b
+	}
+	t.Fatalf("unsupported test value %#v", v)
+	return nil
+}
+
+func testNetworks() []testNetwork {
+	return []testNetwork{
+		{
+			cidr: "1.2.3.0/24",
+			record: map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "US"},
+			},
+		},
+		{
+			cidr: "5.6.0.0/16",
+			record: map[string]interface{}{
+				// points at the country map of the first record, which follows
+				// its map control byte and "country" key
+				"country": testPointer(9),
+			},
+		},
+		{
+			cidr: "9.9.9.9/32",
+			record: map[string]interface{}{
+				"registered_country": map[string]interface{}{"iso_code": "CH"},
+			},
+		},
+	}
+}
+
+func TestReaderLookup(t *testing.T) {
+	for _, ipVersion := range []int{4, 6} {
+		for _, recordSize := range []int{24, 28, 32} {
+			t.Run(fmt.Sprintf("ipv%d record size %d", ipVersion, recordSize), func(t *testing.T) {
+				r, err := NewReader(buildTestDatabase(t, ipVersion, recordSize, testNetworks()))
+				testutil.Assert(t, err == nil, "unexpected error reading database: %s", err)
+				testutil.Equal(t, "Test", r.Metadata.DatabaseType)
+				testutil.Equal(t, uint64(recordSize), r.Metadata.RecordSize)
+
+				record, err := r.Lookup(net.ParseIP("1.2.3.4"))
+				testutil.Assert(t, err == nil, "unexpected error looking up address: %s", err)
+				testutil.Equal(t, "US", countryCode(record))
+
+				record, err = r.Lookup(net.ParseIP("5.6.7.8"))
+				testutil.Assert(t, err == nil, "unexpected error looking up address: %s", err)
+				testutil.Equal(t, "US", countryCode(record))
+
+				record, err = r.Lookup(net.ParseIP("9.9.9.9"))
+				testutil.Assert(t, err == nil, "unexpected error looking up address: %s", err)
+				testutil.Equal(t, "CH", countryCode(record))
+
+				record, err = r.Lookup(net.ParseIP("1.2.4.1"))
+				testutil.Assert(t, err == nil, "unexpected error looking up address: %s", err)
+				testutil.Assert(t, record == nil, "expected no record, got %#v", record)
+			})
+		}
+	}
+}
+
+func TestNewReaderInvalid(t *testing.T) {
+	_, err := NewReader([]byte("not a database"))
+	testutil.Equal(t, ErrInvalidDatabase, err)
+}
+
+func TestLocator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip")
+	testutil.Assert(t, err == nil, "unexpected error creating temp dir: %s", err)
+	defer os.RemoveAll(dir)
+
+	countryPath := filepath.Join(dir, "city.mmdb")
+	err = ioutil.WriteFile(countryPath, buildTestDatabase(t, 6, 24, []testNetwork{
+		{
+			cidr: "1.2.3.0/24",
+			record: map[string]interface{}{
+				"country":  map[string]interface{}{"iso_code": "US"},
+				"location": map[string]interface{}{"latitude": 37.751, "longitude": -97.822},
+			},
+		},
+		{
+			cidr: "1.2.5.0/24",
+			record: map[string]interface{}{
+				"country": map[string]interface{}{"iso_code": "CA"},
+			},
+		},
+	}), 0644)
+	testutil.Assert(t, err == nil, "unexpected error writing database: %s", err)
+
+	asnPath := filepath.Join(dir, "asn.mmdb")
+	err = ioutil.WriteFile(asnPath, buildTestDatabase(t, 6, 24, []testNetwork{
+		{
+			cidr: "1.2.0.0/16",
+			record: map[string]interface{}{
+				"autonomous_system_number":       uint64(64512),
+				"autonomous_system_organization": "Example",
+			},
+		},
+	}), 0644)
+	testutil.Assert(t, err == nil, "unexpected error writing database: %s", err)
+
+	locator, err := NewLocator(countryPath, asnPath)
+	testutil.Assert(t, err == nil, "unexpected error opening databases: %s", err)
+
+	testCases := []struct {
+		addr     string
+		expected Location
+	}{
+		{"1.2.3.4:4180", Location{
+			Country:        "US",
+			ASN:            64512,
+			ASOrganization: "Example",
+			Latitude:       37.751,
+			Longitude:      -97.822,
+			HasCoordinates: true,
+		}},
+		{"1.2.5.5", Location{Country: "CA", ASN: 64512, ASOrganization: "Example"}},
+		{"1.2.4.4", Location{ASN: 64512, ASOrganization: "Example"}},
+		{"10.0.0.1", Location{}},
+		{"not an ip", Location{}},
+	}
+	for _, tc := range testCases {
+		testutil.Equal(t, tc.expected, locator.Lookup(tc.addr))
+	}
+}
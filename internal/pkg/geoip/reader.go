@@ -0,0 +1,180 @@
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+)
+
+// metadataStartMarker precedes the metadata section at the end of a MaxMind DB file.
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSectionSeparatorSize is the number of zero bytes between the search tree and the
+// data section.
+const dataSectionSeparatorSize = 16
+
+var (
+	// ErrInvalidDatabase is returned when a file is not a valid MaxMind DB file.
+	ErrInvalidDatabase = errors.New("invalid maxmind database")
+)
+
+// Metadata describes a MaxMind DB file.
+type Metadata struct {
+	DatabaseType string
+	IPVersion    uint64
+	NodeCount    uint64
+	RecordSize   uint64
+	BuildEpoch   uint64
+}
+
+// Reader looks up IP addresses in a MaxMind DB file, such as the GeoLite2 and GeoIP2
+// Country, City, and ASN databases.
+//
+// See https://maxmind.github.io/MaxMind-DB/ for the format specification.
+type Reader struct {
+	Metadata Metadata
+
+	tree          []byte
+	data          []byte
+	nodeByteSize  uint64
+	ipv4StartNode uint64
+}
+
+// Open reads the MaxMind DB file at path into memory.
+func Open(path string) (*Reader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReader(buf)
+}
+
+// NewReader returns a Reader for the contents of a MaxMind DB file.
+func NewReader(buf []byte) (*Reader, error) {
+	i := bytes.LastIndex(buf, metadataStartMarker)
+	if i == -1 {
+		return nil, ErrInvalidDatabase
+	}
+
+	d := &decoder{buf: buf[i+len(metadataStartMarker):]}
+	raw, _, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding database metadata: %s", err)
+	}
+	fields, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+
+	metadata := Metadata{}
+	metadata.DatabaseType, _ = fields["database_type"].(string)
+	metadata.IPVersion, _ = toUint(fields["ip_version"])
+	metadata.NodeCount, _ = toUint(fields["node_count"])
+	metadata.RecordSize, _ = toUint(fields["record_size"])
+	metadata.BuildEpoch, _ = toUint(fields["build_epoch"])
+
+	switch metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", metadata.RecordSize)
+	}
+	if metadata.IPVersion != 4 && metadata.IPVersion != 6 {
+		return nil, fmt.Errorf("unsupported ip version %d", metadata.IPVersion)
+	}
+
+	nodeByteSize := metadata.RecordSize / 4
+	treeSize := metadata.NodeCount * nodeByteSize
+	if treeSize+dataSectionSeparatorSize > uint64(i) {
+		return nil, ErrInvalidDatabase
+	}
+
+	r := &Reader{
+		Metadata:     metadata,
+		tree:         buf[:treeSize],
+		data:         buf[treeSize+dataSectionSeparatorSize : i],
+		nodeByteSize: nodeByteSize,
+	}
+
+	// IPv4 addresses are looked up in IPv6 databases as IPv4-mapped addresses in the
+	// ::/96 subnet, so we find the node at the end of those 96 zero bits once up front.
+	if metadata.IPVersion == 6 {
+		node := uint64(0)
+		for i := 0; i < 96 && node < metadata.NodeCount; i++ {
+			node, err = r.readRecord(node, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		r.ipv4StartNode = node
+	}
+
+	return r, nil
+}
+
+// Lookup returns the data record for an IP address, decoded into maps, slices, strings,
+// and numbers. It returns nil if the database has no record for the address.
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node, bitCount := uint64(0), 128
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+		bitCount = 32
+		node = r.ipv4StartNode
+	} else if r.Metadata.IPVersion == 4 {
+		return nil, fmt.Errorf("cannot look up ipv6 address %s in an ipv4 database", ip)
+	}
+	if len(ip) == 0 {
+		return nil, fmt.Errorf("invalid ip address")
+	}
+
+	nodeCount := r.Metadata.NodeCount
+	for i := 0; i < bitCount && node < nodeCount; i++ {
+		bit := uint64(ip[i>>3]>>(7-uint(i%8))) & 1
+		var err error
+		node, err = r.readRecord(node, bit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if node == nodeCount {
+		// the address is not in the database
+		return nil, nil
+	}
+	if node < nodeCount {
+		return nil, ErrInvalidDatabase
+	}
+
+	offset := node - nodeCount - dataSectionSeparatorSize
+	if offset >= uint64(len(r.data)) {
+		return nil, ErrInvalidDatabase
+	}
+	d := &decoder{buf: r.data}
+	value, _, err := d.decode(uint(offset))
+	return value, err
+}
+
+// readRecord returns the left (bit 0) or right (bit 1) record of a search tree node.
+func (r *Reader) readRecord(node, bit uint64) (uint64, error) {
+	offset := node * r.nodeByteSize
+	if offset+r.nodeByteSize > uint64(len(r.tree)) {
+		return 0, ErrInvalidDatabase
+	}
+	b := r.tree[offset : offset+r.nodeByteSize]
+
+	switch r.Metadata.RecordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+	case 28:
+		// the middle byte holds the most significant nibble of both records
+		if bit == 0 {
+			return uint64(b[3]&0xF0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+		}
+		return uint64(b[3]&0x0F)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6]), nil
+	default:
+		b = b[bit*4:]
+		return uint64(b[0])<<24 | uint64(b[1])<<16 | uint64(b[2])<<8 | uint64(b[3]), nil
+	}
+}
@@ -23,6 +23,8 @@ type MemberSetCache interface {
 	Get(string) (MemberSet, bool)
 	// Update updates the MemberSet of a given key and returns a boolean value indicating whether the value was updated or not.
 	Update(string) bool
+	// Groups returns the keys of the MemberSets in the cache
+	Groups() []string
 	// RefreshLoop starts an update refresh loop for a given key and returns a boolean value indicating whether a refresh loop has been started or not
 	RefreshLoop(string) bool
 	// Stop is a function to stop all goroutines that may have been spun up for the cache.
@@ -71,6 +73,17 @@ func (c *FillCache) Get(group string) (MemberSet, bool) {
 	return val, found
 }
 
+// Groups returns the keys in the cache
+func (c *FillCache) Groups() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	groups := make([]string, 0, len(c.cache))
+	for group := range c.cache {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
 // Update recomputes the value for the given key, unless another goroutine is
 // already computing the value, and returns a bool indicating whether the value
 // was updated
@@ -1,6 +1,7 @@
 package groups
 
 import (
+	"strings"
 	"time"
 
 	"github.com/datadog/datadog-go/statsd"
@@ -90,3 +91,13 @@ func (lc *LocalCache) Purge(key CacheKey) {
 	lc.metrics.Incr("localcache.purge", lc.tags, 1.0)
 	lc.localCacheData.Delete(key)
 }
+
+// PurgeEmail will remove every entry of an email from the local cache map
+func (lc *LocalCache) PurgeEmail(email string) {
+	lc.localCacheData.Range(func(k, _ interface{}) bool {
+		if key := k.(CacheKey); strings.EqualFold(key.Email, email) {
+			lc.Purge(key)
+		}
+		return true
+	})
+}
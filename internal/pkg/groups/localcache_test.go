@@ -68,3 +68,30 @@ func TestNotAvailableAfterPurge(t *testing.T) {
 		t.Fatalf("expected key not to be have been found after purging")
 	}
 }
+
+func TestNotAvailableAfterPurgeEmail(t *testing.T) {
+	statsdClient, _ := statsd.New("127.0.0.1:8125")
+	cache := NewLocalCache(time.Duration(10)*time.Second, statsdClient, []string{"test_case"})
+
+	// Cache the groups of one email for two sets of allowed groups, and of another email
+	keys := []CacheKey{
+		{Email: "email@test.com", AllowedGroups: "testGroup"},
+		{Email: "email@test.com", AllowedGroups: "otherGroup,testGroup"},
+		{Email: "other@test.com", AllowedGroups: "testGroup"},
+	}
+	for _, key := range keys {
+		cache.Set(key, CacheEntry{ValidGroups: []string{"testGroup"}})
+	}
+
+	cache.PurgeEmail("Email@Test.com")
+
+	// PurgeEmail should have removed every entry of the email, and only those
+	for _, key := range keys[:2] {
+		if _, found := cache.get(key); found {
+			t.Fatalf("expected key %+v not to be have been found after purging", key)
+		}
+	}
+	if _, found := cache.get(keys[2]); !found {
+		t.Fatalf("expected key %+v of another email to be found after purging", keys[2])
+	}
+}
@@ -0,0 +1,124 @@
+package groups
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Mapping normalizes the names of a provider's groups, e.g. LDAP distinguished names or GUIDs,
+// into the names used by allowed groups, so that they don't depend on the provider. A group
+// named by an exact mapping is renamed to it; otherwise the first matching prefix is stripped,
+// the first matching rewrite is applied, and the name is lowercased if required.
+type Mapping struct {
+	exact     map[string]string
+	prefixes  []string
+	rewrites  []rewrite
+	lowercase bool
+}
+
+type rewrite struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// NewMapping returns a Mapping from its configuration. Exact mappings and rewrites are lists of
+// entries separated by semicolons, each of which is a group name or regular expression and the
+// name it's mapped to, separated by =>, since group names often contain commas and equal signs,
+// e.g. "CN=Engineering,OU=Groups,DC=example,DC=com => engineering".
+func NewMapping(exact, rewrites string, prefixes []string, lowercase bool) (*Mapping, error) {
+	m := &Mapping{
+		exact:     map[string]string{},
+		lowercase: lowercase,
+	}
+
+	entries, err := parseMappingEntries(exact)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		m.exact[entry[0]] = entry[1]
+	}
+
+	entries, err = parseMappingEntries(rewrites)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		re, err := regexp.Compile(entry[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid group rewrite %q: %v", entry[0], err)
+		}
+		m.rewrites = append(m.rewrites, rewrite{re: re, replacement: entry[1]})
+	}
+
+	for _, prefix := range prefixes {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			m.prefixes = append(m.prefixes, prefix)
+		}
+	}
+	return m, nil
+}
+
+func parseMappingEntries(s string) ([][2]string, error) {
+	var entries [][2]string
+	for _, entry := range strings.Split(s, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=>", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid group mapping %q: expected a name, =>, and the name it's mapped to", entry)
+		}
+		from, to := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if from == "" || to == "" {
+			return nil, fmt.Errorf("invalid group mapping %q: names can't be empty", entry)
+		}
+		entries = append(entries, [2]string{from, to})
+	}
+	return entries, nil
+}
+
+// Normalize returns the normalized name of a group. A nil Mapping returns the name unchanged.
+func (m *Mapping) Normalize(group string) string {
+	if m == nil {
+		return group
+	}
+	if name, ok := m.exact[group]; ok {
+		return name
+	}
+	for _, prefix := range m.prefixes {
+		if strings.HasPrefix(group, prefix) {
+			group = strings.TrimPrefix(group, prefix)
+			break
+		}
+	}
+	for _, r := range m.rewrites {
+		if r.re.MatchString(group) {
+			group = r.re.ReplaceAllString(group, r.replacement)
+			break
+		}
+	}
+	if m.lowercase {
+		group = strings.ToLower(group)
+	}
+	return group
+}
+
+// NormalizeAll returns the normalized names of the groups, without duplicates or empty names.
+func (m *Mapping) NormalizeAll(groups []string) []string {
+	if m == nil {
+		return groups
+	}
+	normalized := make([]string, 0, len(groups))
+	seen := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		name := m.Normalize(group)
+		if _, ok := seen[name]; ok || name == "" {
+			continue
+		}
+		seen[name] = struct{}{}
+		normalized = append(normalized, name)
+	}
+	return normalized
+}
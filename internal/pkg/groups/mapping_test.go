@@ -0,0 +1,61 @@
+package groups
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMappingNormalize(t *testing.T) {
+	m, err := NewMapping(
+		"CN=Site Reliability,OU=Groups,DC=example,DC=com => sre; 00f2c3a4-8e1b-4d2a-9a51-6c7e0b1d2f3e => admins",
+		`^CN=([^,]+),OU=Groups,DC=example,DC=com$ => $1`,
+		[]string{"okta-", "sso:"},
+		true,
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating mapping: %v", err)
+	}
+
+	testCases := map[string]string{
+		"CN=Site Reliability,OU=Groups,DC=example,DC=com": "sre",
+		"00f2c3a4-8e1b-4d2a-9a51-6c7e0b1d2f3e":            "admins",
+		"CN=Engineering,OU=Groups,DC=example,DC=com":      "engineering",
+		"okta-Design":     "design",
+		"sso:okta-nested": "okta-nested",
+		"Everyone":        "everyone",
+	}
+	for group, want := range testCases {
+		if got := m.Normalize(group); got != want {
+			t.Errorf("expected %q to be normalized to %q, got %q", group, want, got)
+		}
+	}
+
+	got := m.NormalizeAll([]string{"okta-Design", "Design", "00f2c3a4-8e1b-4d2a-9a51-6c7e0b1d2f3e"})
+	if want := []string{"design", "admins"}; !reflect.DeepEqual(want, got) {
+		t.Errorf("expected groups %v, got %v", want, got)
+	}
+
+	var nilMapping *Mapping
+	if got := nilMapping.Normalize("Design"); got != "Design" {
+		t.Errorf("expected a nil mapping not to normalize groups, got %q", got)
+	}
+}
+
+func TestNewMappingErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		exact    string
+		rewrites string
+	}{
+		{name: "missing separator", exact: "engineering"},
+		{name: "empty name", exact: "engineering =>"},
+		{name: "invalid regular expression", rewrites: "^(unclosed => $1"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewMapping(tc.exact, tc.rewrites, nil, false); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package groups
 // MockCache is a mock of MemberSetCache that can be used for testing purposes
 type MockCache struct {
 	ListMembershipsFunc func(string) (MemberSet, bool)
+	CachedGroups        []string
 	Exists              bool
 	Updated             bool
 	Refreshed           bool
@@ -13,6 +14,11 @@ func (mc *MockCache) Get(group string) (MemberSet, bool) {
 	return mc.ListMembershipsFunc(group)
 }
 
+// Groups returns the cached groups
+func (mc *MockCache) Groups() []string {
+	return mc.CachedGroups
+}
+
 // Update updates the cache
 func (mc *MockCache) Update(string) bool {
 	return mc.Updated
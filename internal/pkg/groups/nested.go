@@ -0,0 +1,153 @@
+package groups
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+// ListFunc lists the direct members of a group: the users, and the groups nested in it.
+type ListFunc func(group string) (users []string, groups []string, err error)
+
+// NestedGroups expands groups into their users, including the users of the groups nested in
+// them up to a maximum depth. The members of nested groups are cached, since the same groups are
+// often nested in several of the groups that are expanded.
+type NestedGroups struct {
+	list ListFunc
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	cache map[string]nestedListing
+}
+
+type nestedListing struct {
+	users   []string
+	groups  []string
+	expires time.Time
+}
+
+// NewNestedGroups returns a NestedGroups listing groups with the ListFunc, and caching the
+// members of nested groups for the ttl.
+func NewNestedGroups(list ListFunc, ttl time.Duration) *NestedGroups {
+	return &NestedGroups{
+		list:  list,
+		ttl:   ttl,
+		cache: make(map[string]nestedListing),
+	}
+}
+
+// Members returns the users that are members of the group, either directly or through groups
+// nested up to maxDepth levels below it. The members of the group itself are never cached, so
+// that they're up to date when the group's member set is refreshed.
+func (n *NestedGroups) Members(group string, maxDepth int) ([]string, error) {
+	users, groups, err := n.list(group)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &expansion{
+		nested:   n,
+		maxDepth: maxDepth,
+		depths:   map[string]int{group: 0},
+		path:     map[string]bool{group: true},
+		seen:     map[string]struct{}{},
+	}
+	e.add(users)
+	for _, g := range groups {
+		if err := e.expand(g, 1); err != nil {
+			return nil, err
+		}
+	}
+	return e.members, nil
+}
+
+func (n *NestedGroups) cachedList(group string) ([]string, []string, error) {
+	now := time.Now()
+
+	n.mu.Lock()
+	listing, ok := n.cache[group]
+	n.mu.Unlock()
+	if ok && now.Before(listing.expires) {
+		return listing.users, listing.groups, nil
+	}
+
+	users, groups, err := n.list(group)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// expired listings are removed when the cache is written to, so that groups which are no longer
+	// nested anywhere don't stay cached forever
+	for g, l := range n.cache {
+		if !now.Before(l.expires) {
+			delete(n.cache, g)
+		}
+	}
+	n.cache[group] = nestedListing{users: users, groups: groups, expires: now.Add(n.ttl)}
+	return users, groups, nil
+}
+
+// expansion is the state of expanding a single group.
+type expansion struct {
+	nested   *NestedGroups
+	maxDepth int
+
+	// depths are the shallowest depths each group was expanded at, and path the groups being
+	// expanded, which a nested group must not be part of
+	depths map[string]int
+	path   map[string]bool
+
+	seen    map[string]struct{}
+	members []string
+}
+
+func (e *expansion) add(users []string) {
+	for _, user := range users {
+		if _, ok := e.seen[user]; ok {
+			continue
+		}
+		e.seen[user] = struct{}{}
+		e.members = append(e.members, user)
+	}
+}
+
+func (e *expansion) expand(group string, depth int) error {
+	if depth > e.maxDepth {
+		return nil
+	}
+	if e.path[group] {
+		logger := log.NewLogEntry()
+		logger.WithUserGroup(group).Warn("nested groups form a cycle; not expanding group again")
+		return nil
+	}
+	// a group already expanded at the same or a shallower depth can't add any members, while one
+	// expanded deeper may have had its nested groups cut off by the maximum depth
+	if d, ok := e.depths[group]; ok && d <= depth {
+		return nil
+	}
+	e.depths[group] = depth
+
+	users, groups, err := e.nested.cachedList(group)
+	if err == ErrGroupNotFound {
+		logger := log.NewLogEntry()
+		logger.WithUserGroup(group).Warn("nested group not found; not expanding group")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	e.path[group] = true
+	defer delete(e.path, group)
+
+	e.add(users)
+	for _, g := range groups {
+		if err := e.expand(g, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package groups
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type testDirectory map[string][2][]string
+
+func (d testDirectory) list(calls map[string]int) ListFunc {
+	return func(group string) ([]string, []string, error) {
+		calls[group]++
+		members, ok := d[group]
+		if !ok {
+			return nil, nil, ErrGroupNotFound
+		}
+		return members[0], members[1], nil
+	}
+}
+
+func TestNestedGroupsMembers(t *testing.T) {
+	directory := testDirectory{
+		"all":         {{"ceo"}, {"engineering", "design", "deleted"}},
+		"engineering": {{"alice"}, {"sre", "all"}},
+		"design":      {{"bob", "alice"}, {"sre"}},
+		"sre":         {{"carol"}, {"oncall"}},
+		"oncall":      {{"dave"}, nil},
+	}
+
+	testCases := []struct {
+		name     string
+		maxDepth int
+		expected []string
+	}{
+		{
+			name:     "only direct members",
+			maxDepth: 0,
+			expected: []string{"ceo"},
+		},
+		{
+			name:     "one level of nested groups",
+			maxDepth: 1,
+			expected: []string{"alice", "bob", "ceo"},
+		},
+		{
+			name:     "cycles and missing groups are skipped",
+			maxDepth: 4,
+			expected: []string{"alice", "bob", "carol", "ceo", "dave"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := map[string]int{}
+			nested := NewNestedGroups(directory.list(calls), time.Minute)
+
+			members, err := nested.Members("all", tc.maxDepth)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			sort.Strings(members)
+			if !reflect.DeepEqual(tc.expected, members) {
+				t.Errorf("expected members %v, got %v", tc.expected, members)
+			}
+			for group, n := range calls {
+				if n > 1 {
+					t.Errorf("expected group %q to be listed once, was listed %d times", group, n)
+				}
+			}
+		})
+	}
+}
+
+func TestNestedGroupsShallowerPath(t *testing.T) {
+	// sre is first reached through design at the maximum depth, and then directly from all,
+	// which must still expand oncall
+	directory := testDirectory{
+		"all":    {nil, {"design", "sre"}},
+		"design": {nil, {"sre"}},
+		"sre":    {{"carol"}, {"oncall"}},
+		"oncall": {{"dave"}, nil},
+	}
+	nested := NewNestedGroups(directory.list(map[string]int{}), time.Minute)
+
+	members, err := nested.Members("all", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(members)
+	if expected := []string{"carol", "dave"}; !reflect.DeepEqual(expected, members) {
+		t.Errorf("expected members %v, got %v", expected, members)
+	}
+}
+
+func TestNestedGroupsCache(t *testing.T) {
+	directory := testDirectory{
+		"engineering": {{"alice"}, {"sre"}},
+		"oncall":      {{"bob"}, {"sre"}},
+		"sre":         {{"carol"}, nil},
+	}
+	calls := map[string]int{}
+	nested := NewNestedGroups(directory.list(calls), time.Minute)
+
+	for _, group := range []string{"engineering", "oncall", "engineering"} {
+		if _, err := nested.Members(group, 4); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expected := map[string]int{"engineering": 2, "oncall": 1, "sre": 1}
+	if !reflect.DeepEqual(expected, calls) {
+		t.Errorf("expected groups to be listed %v times, got %v", expected, calls)
+	}
+
+	// the members of nested groups are listed again once they expire
+	nested = NewNestedGroups(directory.list(calls), 0)
+	nested.Members("engineering", 4)
+	nested.Members("oncall", 4)
+	if calls["sre"] != 3 {
+		t.Errorf("expected expired nested groups to be listed again, got %d calls", calls["sre"])
+	}
+}
+
+func TestNestedGroupsError(t *testing.T) {
+	nested := NewNestedGroups(func(group string) ([]string, []string, error) {
+		if group == "engineering" {
+			return []string{"alice"}, []string{"sre"}, nil
+		}
+		return nil, nil, fmt.Errorf("rate limit exceeded")
+	}, time.Minute)
+
+	if _, err := nested.Members("engineering", 4); err == nil {
+		t.Errorf("expected an error listing a nested group to be returned")
+	}
+	if _, err := nested.Members("missing", 4); err == nil {
+		t.Errorf("expected an error listing the group to be returned")
+	}
+}
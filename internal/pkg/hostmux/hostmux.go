@@ -21,7 +21,7 @@ func misdirected(rw http.ResponseWriter, req *http.Request) {
 
 // Router is a generic host router that has support for static, regexp, and default routes
 type Router struct {
-	mu sync.Mutex
+	mu sync.RWMutex
 
 	// StaticRoutes have the highest precedence, and match keys to http.Request.Host values exactly.
 	// Added to a router by HandleStatic().
@@ -31,6 +31,10 @@ type Router struct {
 	// Added to a router by HandleRegexp().
 	RegexpRoutes []*RegexpRoute
 
+	// regexpIndex narrows the RegexpRoutes that have to be evaluated for a host down to those
+	// whose required literal suffix the host ends with.
+	regexpIndex *suffixIndex
+
 	// DefaultRoute matches any remaining requests.
 	//Added by HandleDefault()
 	DefaultRoute *DefaultRoute
@@ -77,6 +81,7 @@ func NewRouter() *Router {
 	return &Router{
 		StaticRoutes: make(map[string]*StaticRoute),
 		RegexpRoutes: make([]*RegexpRoute, 0),
+		regexpIndex:  newSuffixIndex(),
 		DefaultRoute: &DefaultRoute{
 			handler: http.HandlerFunc(misdirected),
 		},
@@ -90,17 +95,18 @@ func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 }
 
 // Route returns the route used for the request, consulting first static, regexp, and
-// then the default route
+// then the default route. When more than one regexp route matches, the first registered wins.
 func (r *Router) Route(req *http.Request) Route {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
 	sr, ok := r.StaticRoutes[req.Host]
 	if ok {
 		return sr
 	}
 
-	for _, rr := range r.RegexpRoutes {
+	for _, position := range r.regexpIndex.candidates(req.Host) {
+		rr := r.RegexpRoutes[position]
 		if rr.regexp.MatchString(req.Host) {
 			return rr
 		}
@@ -123,6 +129,7 @@ func (r *Router) HandleStatic(host string, handler http.Handler) {
 // HandleRegexp registers the handler func for the given regexp
 func (r *Router) HandleRegexp(regexp *regexp.Regexp, handler http.Handler) {
 	r.mu.Lock()
+	r.regexpIndex.insert(regexp, len(r.RegexpRoutes))
 	r.RegexpRoutes = append(r.RegexpRoutes, &RegexpRoute{
 		regexp:  regexp,
 		handler: handler,
@@ -101,3 +101,33 @@ func TestHostMux(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkRoute(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		router := NewRouter()
+		for i := 0; i < n; i++ {
+			router.HandleStatic(fmt.Sprintf("static-%d.example.com", i), simpleHandler(http.StatusOK, "static"))
+			router.HandleRegexp(regexp.MustCompile(fmt.Sprintf(`^(.*)\.service-%d\.example\.com$`, i)), simpleHandler(http.StatusOK, "regexp"))
+		}
+
+		benchmarks := []struct {
+			name string
+			host string
+		}{
+			{name: "static", host: fmt.Sprintf("static-%d.example.com", n-1)},
+			{name: "regexp", host: fmt.Sprintf("foo.service-%d.example.com", n-1)},
+			{name: "default", host: "unknown.example.com"},
+		}
+
+		for _, bm := range benchmarks {
+			b.Run(fmt.Sprintf("%s/routes=%d", bm.name, n), func(b *testing.B) {
+				req := httptest.NewRequest("GET", "http://"+bm.host, nil)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					router.Route(req)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package hostmux
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+)
+
+// suffixIndex is a trie of the literal host suffixes required by regexp routes, keyed by the
+// bytes of each suffix in reverse. Looking up a host walks the trie once along the host's
+// bytes, so only the regexps of routes whose suffix the host ends with have to be evaluated,
+// rather than every regexp route in turn.
+type suffixIndex struct {
+	root *suffixNode
+}
+
+type suffixNode struct {
+	children map[byte]*suffixNode
+	// routes are the positions, in registration order, of the routes requiring this suffix.
+	routes []int
+}
+
+func newSuffixIndex() *suffixIndex {
+	return &suffixIndex{root: &suffixNode{}}
+}
+
+// insert indexes the route at the given position by the literal suffix its regexp requires.
+// Routes without one are kept at the root of the trie, and are candidates for every host.
+func (si *suffixIndex) insert(re *regexp.Regexp, position int) {
+	suffix := literalSuffix(re)
+
+	node := si.root
+	for i := len(suffix) - 1; i >= 0; i-- {
+		child, ok := node.children[suffix[i]]
+		if !ok {
+			if node.children == nil {
+				node.children = make(map[byte]*suffixNode)
+			}
+			child = &suffixNode{}
+			node.children[suffix[i]] = child
+		}
+		node = child
+	}
+	node.routes = append(node.routes, position)
+}
+
+// candidates returns, in registration order, the positions of the routes that may match host.
+func (si *suffixIndex) candidates(host string) []int {
+	node := si.root
+	candidates := node.routes
+	merged := false
+	for i := len(host) - 1; i >= 0; i-- {
+		child, ok := node.children[host[i]]
+		if !ok {
+			break
+		}
+		node = child
+		if len(node.routes) == 0 {
+			continue
+		}
+		if len(candidates) == 0 {
+			candidates = node.routes
+			continue
+		}
+		// copy before appending so that the slices held by the trie are never modified
+		if !merged {
+			candidates = append([]int(nil), candidates...)
+			merged = true
+		}
+		candidates = append(candidates, node.routes...)
+	}
+	if merged {
+		sort.Ints(candidates)
+	}
+	return candidates
+}
+
+// literalSuffix returns the literal string every match of re ends with, when re is anchored to
+// the end of the text. Otherwise, or when the suffix is matched case insensitively, it returns
+// an empty string.
+func literalSuffix(re *regexp.Regexp) string {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	parsed = parsed.Simplify()
+	if parsed.Op != syntax.OpConcat || len(parsed.Sub) < 2 {
+		return ""
+	}
+
+	subs := parsed.Sub
+	if subs[len(subs)-1].Op != syntax.OpEndText {
+		return ""
+	}
+
+	suffix := ""
+	for i := len(subs) - 2; i >= 0; i-- {
+		sub := subs[i]
+		if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+			break
+		}
+		suffix = string(sub.Rune) + suffix
+	}
+	return suffix
+}
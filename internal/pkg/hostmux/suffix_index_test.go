@@ -0,0 +1,63 @@
+package hostmux
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestLiteralSuffix(t *testing.T) {
+	testCases := []struct {
+		regexp string
+		suffix string
+	}{
+		{regexp: `^(.*)\.sso\.example\.com$`, suffix: ".sso.example.com"},
+		{regexp: `^foo-[a-z]+\.example\.com$`, suffix: ".example.com"},
+		{regexp: `^foo\.example\.com$`, suffix: "foo.example.com"},
+		{regexp: `(.*)\.example\.com`, suffix: ""},
+		{regexp: `^(.*)\.(foo|bar)\.com$`, suffix: ".com"},
+		{regexp: `^(.*)\.example\.(com|net)$`, suffix: ""},
+		{regexp: `(?i)^(.*)\.example\.com$`, suffix: ""},
+		{regexp: `^(.*)\.example\.com:\d+$`, suffix: ""},
+		{regexp: `.*`, suffix: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.regexp, func(t *testing.T) {
+			testutil.Equal(t, tc.suffix, literalSuffix(regexp.MustCompile(tc.regexp)))
+		})
+	}
+}
+
+func TestSuffixIndexCandidates(t *testing.T) {
+	si := newSuffixIndex()
+	for position, re := range []string{
+		`^(.*)\.foo\.example\.com$`,
+		`^(.*)\.example\.com$`,
+		`^(.*)\.example\.net$`,
+		`(.*)\.example`,
+		`^bar\.foo\.example\.com$`,
+	} {
+		si.insert(regexp.MustCompile(re), position)
+	}
+
+	testutil.Equal(t, []int{0, 1, 3, 4}, si.candidates("bar.foo.example.com"))
+	testutil.Equal(t, []int{1, 3}, si.candidates("baz.example.com"))
+	testutil.Equal(t, []int{2, 3}, si.candidates("baz.example.net"))
+	testutil.Equal(t, []int{3}, si.candidates("example.org"))
+
+	// lookups never modify the routes held by the trie
+	testutil.Equal(t, []int{3}, si.root.routes)
+}
+
+func TestRouteRegexpPrecedence(t *testing.T) {
+	router := NewRouter()
+	router.HandleRegexp(regexp.MustCompile(`^(.*)\.example\.com$`), simpleHandler(200, "first"))
+	router.HandleRegexp(regexp.MustCompile(`^(.*)\.foo\.example\.com$`), simpleHandler(200, "second"))
+
+	// the earlier route wins, even though the later one requires a longer suffix
+	route := router.Route(httptest.NewRequest("GET", "http://bar.foo.example.com", nil))
+	testutil.Assert(t, route == router.RegexpRoutes[0], "expected the first registered route to match")
+}
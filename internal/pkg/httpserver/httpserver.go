@@ -10,9 +10,13 @@ import (
 	"time"
 
 	"github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/systemd"
+	"github.com/buzzfeed/sso/internal/pkg/winservice"
 )
 
-// OS signals that will initiate graceful shutdown of the http server.
+// OS signals that will initiate graceful shutdown of the http server. On Windows,
+// where console processes are only sent these signals, services are shut down
+// gracefully when the service control manager stops them.
 //
 // NOTE: defined in a variable so that they may be overridden by tests.
 var shutdownSignals = []os.Signal{
@@ -20,27 +24,61 @@ var shutdownSignals = []os.Signal{
 	syscall.SIGTERM,
 }
 
+// SocketName is the name of the socket Run serves on when the process is socket activated by
+// systemd, as set by the FileDescriptorName= of its socket unit.
+const SocketName = "http"
+
 // Run runs an http server and ensures that it is shut down gracefully within
 // the given shutdown timeout, allowing all in-flight requests to complete.
 //
+// When the process is socket activated by systemd, the server is served on the
+// socket named SocketName, or on the socket without a name, instead of its
+// address. Systemd is notified once the server is ready and when it's shutting
+// down, and its watchdog while the server is running.
+//
 // Returns an error if a) the server fails to listen on its port or b) the
 // shutdown timeout elapses before all in-flight requests are finished.
 func Run(srv *http.Server, shutdownTimeout time.Duration, logger *logging.LogEntry) error {
+	ln, err := ListenServer(srv)
+	if err != nil {
+		return err
+	}
+	return Serve(ln, srv, shutdownTimeout, logger)
+}
+
+// ListenServer returns the listener Run serves an http server on, so that the
+// server's port can be bound before it's served.
+func ListenServer(srv *http.Server) (net.Listener, error) {
 	// Logic below copied from the stdlib http.Server ListenAndServe() method:
 	// https://github.com/golang/go/blob/release-branch.go1.13/src/net/http/server.go#L2805-L2826
 	addr := srv.Addr
 	if addr == "" {
 		addr = ":http"
 	}
-	ln, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
+	ln, err := systemd.Listener(SocketName)
+	if err != nil || ln != nil {
+		return ln, err
 	}
+	return Listen(systemd.UnnamedSocket, addr)
+}
+
+// Serve runs an http server on a listener as Run does, once it's bound by
+// ListenServer.
+func Serve(ln net.Listener, srv *http.Server, shutdownTimeout time.Duration, logger *logging.LogEntry) error {
 	return runWithListener(ln, srv, shutdownTimeout, logger)
 }
 
-// runWithListener does the heavy lifting for Run() above, and is decoupled
-// only for testing purposes
+// Listen returns the socket with the given name passed by systemd socket
+// activation, or listens on the TCP address if systemd passed none.
+func Listen(name, addr string) (net.Listener, error) {
+	ln, err := systemd.Listener(name)
+	if err != nil || ln != nil {
+		return ln, err
+	}
+	return net.Listen("tcp", addr)
+}
+
+// runWithListener does the heavy lifting for Run() and Serve() above
 func runWithListener(ln net.Listener, srv *http.Server, shutdownTimeout time.Duration, logger *logging.LogEntry) error {
 	var (
 		// shutdownCh triggers graceful shutdown on SIGINT or SIGTERM
@@ -57,9 +95,14 @@ func runWithListener(ln net.Listener, srv *http.Server, shutdownTimeout time.Dur
 	signal.Notify(shutdownCh, shutdownSignals...)
 
 	go func() {
-		sig := <-shutdownCh
-		logger.Info("shutdown started by signal: ", sig)
+		select {
+		case sig := <-shutdownCh:
+			logger.Info("shutdown started by signal: ", sig)
+		case <-winservice.Stopping():
+			logger.Info("shutdown started by the service control manager")
+		}
 		signal.Stop(shutdownCh)
+		notifySystemd("STOPPING=1", logger)
 
 		logger.Info("waiting for server to shut down in ", shutdownTimeout)
 		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
@@ -69,6 +112,10 @@ func runWithListener(ln net.Listener, srv *http.Server, shutdownTimeout time.Dur
 		close(exitCh)
 	}()
 
+	// the listener is bound, so requests are accepted once the server is ready
+	notifySystemd("READY=1", logger)
+	go systemd.Watchdog(exitCh)
+
 	if serveErr := srv.Serve(ln); serveErr != nil && serveErr != http.ErrServerClosed {
 		return serveErr
 	}
@@ -78,3 +125,10 @@ func runWithListener(ln net.Listener, srv *http.Server, shutdownTimeout time.Dur
 
 	return shutdownErr
 }
+
+// notifySystemd notifies systemd of a state of the server, if it supervises the process.
+func notifySystemd(state string, logger *logging.LogEntry) {
+	if _, err := systemd.Notify(state); err != nil {
+		logger.WithError(err).Warn("error notifying systemd: ", state)
+	}
+}
@@ -0,0 +1,281 @@
+// Package jsonschema generates JSON Schemas for configuration structs, so editors and CI
+// tooling can complete and validate configuration files and environments.
+package jsonschema
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Draft is the JSON Schema draft generated schemas conform to.
+const Draft = "http://json-schema.org/draft-07/schema#"
+
+// Patterns of environment variable values, which are always strings.
+const (
+	BoolPattern     = `^(1|0|t|f|T|F|true|false|TRUE|FALSE|True|False)$`
+	IntPattern      = `^-?[0-9]+$`
+	UintPattern     = `^[0-9]+$`
+	FloatPattern    = `^-?[0-9]+(\.[0-9]+)?$`
+	DurationPattern = `^(0|(-?[0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+)$`
+)
+
+// mapKeyPattern matches the name of an entry of a map in an environment variable name,
+// which may not contain the separator.
+const mapKeyPattern = `[A-Z0-9]+`
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Schema is a JSON Schema.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	PatternProperties    map[string]*Schema `json:"patternProperties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+}
+
+// Reflect returns the schema of values of type t decoded from YAML or JSON, using the struct
+// tag with the given key (e.g. "yaml") to name fields. Only tagged fields are included, and
+// fields tagged `,inline` are merged into their parent.
+func Reflect(t reflect.Type, key string) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == durationType:
+		return &Schema{Type: "string", Pattern: DurationPattern}
+	case t.Kind() == reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case isInt(t.Kind()) || isUint(t.Kind()):
+		return &Schema{Type: "integer"}
+	case isFloat(t.Kind()):
+		return &Schema{Type: "number"}
+	case t.Kind() == reflect.String:
+		return &Schema{Type: "string"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return &Schema{Type: "array", Items: Reflect(t.Elem(), key)}
+	case t.Kind() == reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: Reflect(t.Elem(), key)}
+	case t.Kind() == reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+		reflectFields(s, t, key)
+		return s
+	}
+	return &Schema{}
+}
+
+func reflectFields(s *Schema, t reflect.Type, key string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts := parseTag(field.Tag.Get(key))
+		if field.PkgPath != "" || name == "-" || (name == "" && opts == "") {
+			continue
+		}
+
+		if strings.Contains(opts, "inline") {
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			switch fieldType.Kind() {
+			case reflect.Struct:
+				reflectFields(s, fieldType, key)
+			case reflect.Map:
+				s.AdditionalProperties = Reflect(fieldType.Elem(), key)
+			}
+			continue
+		}
+
+		s.Properties[name] = Reflect(field.Type, key)
+	}
+}
+
+// Env returns the schema of an environment decoded into the struct v with
+// github.com/kelseyhightower/envconfig, where each field tagged `envconfig` is a variable,
+// and its `default` and `required` tags are the variable's default and whether it's required.
+func Env(v interface{}) *Schema {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("envconfig")
+		if field.PkgPath != "" || name == "" || name == "-" {
+			continue
+		}
+
+		prop := envValue(field.Type)
+		if def, ok := field.Tag.Lookup("default"); ok {
+			prop.Default = def
+		}
+		if field.Tag.Get("required") == "true" {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = prop
+	}
+	sort.Strings(s.Required)
+	return s
+}
+
+// NestedEnv returns the schema of an environment decoded into the struct v by splitting
+// variable names on underscores into nested keys, which are matched to fields using the
+// struct tag with the given key (e.g. "mapstructure"), or to field names when untagged.
+// Entries of maps are matched by name, and the values of v are the variables' defaults.
+func NestedEnv(v interface{}, key string) *Schema {
+	s := &Schema{
+		Type:              "object",
+		Properties:        map[string]*Schema{},
+		PatternProperties: map[string]*Schema{},
+	}
+	nestedEnv(s, reflect.ValueOf(v), key, nil, false)
+	return s
+}
+
+func nestedEnv(s *Schema, v reflect.Value, key string, path []string, pattern bool) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v = reflect.Zero(v.Type().Elem())
+			break
+		}
+		v = v.Elem()
+	}
+	t := v.Type()
+
+	switch {
+	case t.Kind() == reflect.Struct && t != durationType:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name, _ := parseTag(field.Tag.Get(key))
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			nestedEnv(s, v.Field(i), key, append(path, strings.ToUpper(name)), pattern)
+		}
+	case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+		// entries can be named anything, so are matched by pattern. The entries of v are
+		// not defaults for other names, so the zero value of the entries is used.
+		nestedEnv(s, reflect.Zero(t.Elem()), key, append(path, mapKeyPattern), true)
+	default:
+		prop := envValue(t)
+		if def := envDefault(v); def != "" {
+			prop.Default = def
+		}
+		if pattern {
+			quoted := make([]string, 0, len(path))
+			for _, part := range path {
+				if part == mapKeyPattern {
+					quoted = append(quoted, part)
+					continue
+				}
+				quoted = append(quoted, regexp.QuoteMeta(part))
+			}
+			s.PatternProperties["^"+strings.Join(quoted, "_")+"$"] = prop
+			return
+		}
+		s.Properties[strings.Join(path, "_")] = prop
+	}
+}
+
+// envValue returns the schema of an environment variable decoded into type t.
+func envValue(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	s := &Schema{Type: "string"}
+	switch {
+	case t == durationType:
+		s.Pattern = DurationPattern
+	case t.Kind() == reflect.Bool:
+		s.Pattern = BoolPattern
+	case isInt(t.Kind()):
+		s.Pattern = IntPattern
+	case isUint(t.Kind()):
+		s.Pattern = UintPattern
+	case isFloat(t.Kind()):
+		s.Pattern = FloatPattern
+	case t.Kind() == reflect.Slice:
+		s.Description = "comma separated list"
+	}
+	return s
+}
+
+// envDefault formats a default value the way it would be set in the environment, or returns
+// an empty string for zero values.
+func envDefault(v reflect.Value) string {
+	if !v.IsValid() || isZero(v) {
+		return ""
+	}
+
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Slice:
+		values := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, fmt.Sprint(v.Index(i).Interface()))
+		}
+		return strings.Join(values, ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
+
+func parseTag(tag string) (string, string) {
+	parts := strings.SplitN(tag, ",", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func isInt(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+func isUint(k reflect.Kind) bool {
+	switch k {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
@@ -0,0 +1,114 @@
+package jsonschema
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+type testRoute struct {
+	From    string        `yaml:"from"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type testUpstream struct {
+	Name  string
+	Route testRoute `yaml:",inline"`
+	Tags  []string  `yaml:"tags"`
+	Port  int       `yaml:"port"`
+	Debug *bool     `yaml:"debug"`
+	Skip  string    `yaml:"-"`
+}
+
+type testService struct {
+	Service  string                   `yaml:"service"`
+	Clusters map[string]*testUpstream `yaml:",inline"`
+}
+
+func TestReflect(t *testing.T) {
+	upstream := &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"from":    {Type: "string"},
+			"timeout": {Type: "string", Pattern: DurationPattern},
+			"tags":    {Type: "array", Items: &Schema{Type: "string"}},
+			"port":    {Type: "integer"},
+			"debug":   {Type: "boolean"},
+		},
+		AdditionalProperties: false,
+	}
+	testutil.Equal(t, &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"service": {Type: "string"},
+		},
+		AdditionalProperties: upstream,
+	}, Reflect(reflect.TypeOf(testService{}), "yaml"))
+}
+
+type testOptions struct {
+	Port    int           `envconfig:"PORT" default:"4180"`
+	Secret  string        `envconfig:"SECRET" required:"true"`
+	Domains []string      `envconfig:"DOMAINS"`
+	Expire  time.Duration `envconfig:"EXPIRE" default:"168h"`
+	Secure  bool          `envconfig:"SECURE"`
+	Rate    float64       `envconfig:"RATE"`
+	Client  *struct{}
+	private string
+}
+
+func TestEnv(t *testing.T) {
+	testutil.Equal(t, &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"PORT":    {Type: "string", Pattern: IntPattern, Default: "4180"},
+			"SECRET":  {Type: "string"},
+			"DOMAINS": {Type: "string", Description: "comma separated list"},
+			"EXPIRE":  {Type: "string", Pattern: DurationPattern, Default: "168h"},
+			"SECURE":  {Type: "string", Pattern: BoolPattern},
+			"RATE":    {Type: "string", Pattern: FloatPattern},
+		},
+		Required: []string{"SECRET"},
+	}, Env(&testOptions{}))
+}
+
+type testClient struct {
+	ID     string `mapstructure:"id"`
+	Secret string `mapstructure:"secret"`
+}
+
+type testConfig struct {
+	Clients map[string]testClient `mapstructure:"client"`
+	Server  struct {
+		Port    int           `mapstructure:"port"`
+		Timeout time.Duration `mapstructure:"timeout"`
+		Hosts   []string      `mapstructure:"hosts"`
+	} `mapstructure:"server"`
+	Untagged bool
+	Ignored  string `mapstructure:"-"`
+}
+
+func TestNestedEnv(t *testing.T) {
+	config := testConfig{
+		Clients: map[string]testClient{"proxy": {ID: "default"}},
+	}
+	config.Server.Port = 4180
+	config.Server.Timeout = 30 * time.Second
+	config.Server.Hosts = []string{"a", "b"}
+
+	testutil.Equal(t, &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"SERVER_PORT":    {Type: "string", Pattern: IntPattern, Default: "4180"},
+			"SERVER_TIMEOUT": {Type: "string", Pattern: DurationPattern, Default: "30s"},
+			"SERVER_HOSTS":   {Type: "string", Description: "comma separated list", Default: "a,b"},
+			"UNTAGGED":       {Type: "string", Pattern: BoolPattern},
+		},
+		PatternProperties: map[string]*Schema{
+			"^CLIENT_[A-Z0-9]+_ID$":     {Type: "string"},
+			"^CLIENT_[A-Z0-9]+_SECRET$": {Type: "string"},
+		},
+	}, NestedEnv(config, "mapstructure"))
+}
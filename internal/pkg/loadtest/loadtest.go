@@ -0,0 +1,194 @@
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config configures a load test against a running instance.
+type Config struct {
+	// URL is requested by every worker.
+	URL string
+	// Host, when set, overrides the Host header of requests so that a specific upstream
+	// can be targeted regardless of the address being dialed.
+	Host string
+	// Cookies are sent with every request, e.g. an authenticated session cookie.
+	Cookies []*http.Cookie
+	// Duration is how long requests are sent for.
+	Duration time.Duration
+	// Concurrency is the number of workers sending requests in parallel.
+	Concurrency int
+	// Rate caps the total number of requests sent per second. Zero means no limit.
+	Rate int
+	// Client sends the requests, defaulting to a client that doesn't follow redirects.
+	Client *http.Client
+}
+
+// Result summarizes the requests sent during a load test.
+type Result struct {
+	Requests    int
+	Errors      int
+	StatusCodes map[int]int
+	Elapsed     time.Duration
+	Latencies   []time.Duration
+}
+
+// Throughput returns the number of requests completed per second.
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// Percentile returns the latency below which the given percentage of requests completed.
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(r.Latencies))
+	copy(sorted, r.Latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	// nearest-rank method
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// Report writes a human readable summary of the result.
+func (r *Result) Report(w io.Writer) {
+	fmt.Fprintf(w, "requests:    %d\n", r.Requests)
+	fmt.Fprintf(w, "errors:      %d\n", r.Errors)
+	fmt.Fprintf(w, "elapsed:     %s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "throughput:  %.2f req/s\n", r.Throughput())
+
+	codes := make([]int, 0, len(r.StatusCodes))
+	for code := range r.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "status %d:  %d\n", code, r.StatusCodes[code])
+	}
+
+	for _, p := range []float64{50, 90, 99} {
+		fmt.Fprintf(w, "p%-2.0f latency: %s\n", p, r.Percentile(p))
+	}
+	fmt.Fprintf(w, "max latency: %s\n", r.Percentile(100))
+}
+
+// Run sends requests until the configured duration elapses or the context is cancelled.
+func Run(ctx context.Context, config Config) (*Result, error) {
+	if config.Concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1")
+	}
+	if config.Duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+	if _, err := http.NewRequest("GET", config.URL, nil); err != nil {
+		return nil, err
+	}
+
+	client := config.Client
+	if client == nil {
+		client = &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: config.Concurrency,
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, config.Duration)
+	defer cancel()
+
+	// a nil ticks channel is never ready, so workers aren't rate limited
+	var ticks <-chan time.Time
+	if config.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(config.Rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	result := &Result{StatusCodes: make(map[int]int)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if ticks != nil {
+					select {
+					case <-ticks:
+					case <-ctx.Done():
+						return
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+
+				code, latency, err := send(ctx, client, config)
+				if ctx.Err() != nil {
+					// requests interrupted by the end of the test aren't counted
+					return
+				}
+
+				mu.Lock()
+				result.Requests++
+				result.Latencies = append(result.Latencies, latency)
+				if err != nil {
+					result.Errors++
+				} else {
+					result.StatusCodes[code]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	result.Elapsed = time.Since(start)
+
+	return result, nil
+}
+
+func send(ctx context.Context, client *http.Client, config Config) (int, time.Duration, error) {
+	req, err := http.NewRequest("GET", config.URL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req = req.WithContext(ctx)
+	if config.Host != "" {
+		req.Host = config.Host
+	}
+	for _, cookie := range config.Cookies {
+		req.AddCookie(cookie)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, time.Since(start), err
+}
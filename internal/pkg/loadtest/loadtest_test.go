@@ -0,0 +1,67 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestPercentile(t *testing.T) {
+	result := &Result{}
+	testutil.Equal(t, time.Duration(0), result.Percentile(50))
+
+	for i := 100; i > 0; i-- {
+		result.Latencies = append(result.Latencies, time.Duration(i)*time.Millisecond)
+	}
+	testutil.Equal(t, 50*time.Millisecond, result.Percentile(50))
+	testutil.Equal(t, 90*time.Millisecond, result.Percentile(90))
+	testutil.Equal(t, 99*time.Millisecond, result.Percentile(99))
+	testutil.Equal(t, 100*time.Millisecond, result.Percentile(100))
+	testutil.Equal(t, 1*time.Millisecond, result.Percentile(0))
+}
+
+func TestRun(t *testing.T) {
+	var hosts, cookies []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		hosts = append(hosts, req.Host)
+		cookies = append(cookies, req.Header.Get("Cookie"))
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	result, err := Run(context.Background(), Config{
+		URL:         server.URL,
+		Host:        "upstream.example.com",
+		Cookies:     []*http.Cookie{{Name: "_sso_proxy", Value: "session"}},
+		Duration:    100 * time.Millisecond,
+		Concurrency: 1,
+		Rate:        50,
+	})
+	testutil.Assert(t, err == nil, "unexpected error: %s", err)
+	testutil.Assert(t, result.Requests > 0, "expected requests to be sent")
+	testutil.Assert(t, result.Requests <= 6, "expected requests to be rate limited, got %d", result.Requests)
+	testutil.Equal(t, 0, result.Errors)
+	testutil.Equal(t, map[int]int{http.StatusTeapot: result.Requests}, result.StatusCodes)
+	testutil.Equal(t, result.Requests, len(result.Latencies))
+	testutil.Equal(t, "upstream.example.com", hosts[0])
+	testutil.Equal(t, "_sso_proxy=session", cookies[0])
+
+	out := &bytes.Buffer{}
+	result.Report(out)
+	testutil.Assert(t, strings.Contains(out.String(), "status 418:"), "expected status codes in report: %s", out)
+	testutil.Assert(t, strings.Contains(out.String(), "p99 latency:"), "expected percentiles in report: %s", out)
+}
+
+func TestRunValidation(t *testing.T) {
+	_, err := Run(context.Background(), Config{URL: "http://localhost", Duration: time.Second})
+	testutil.Equal(t, "concurrency must be at least 1", err.Error())
+
+	_, err = Run(context.Background(), Config{URL: "http://localhost", Concurrency: 1})
+	testutil.Equal(t, "duration must be positive", err.Error())
+}
@@ -1,6 +1,10 @@
 package logging
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
 	"net/url"
 	"os"
 	"time"
@@ -10,6 +14,9 @@ import (
 
 var serviceName = "sso"
 
+// pseudonymizationKey, when set, is the key users are pseudonymized with in log entries.
+var pseudonymizationKey []byte
+
 func init() {
 	logrus.SetOutput(os.Stdout)
 	logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: "2006-01-02 15:04:05.000"})
@@ -20,6 +27,30 @@ func SetServiceName(name string) {
 	serviceName = name
 }
 
+// SetPseudonymizationKey configures the key users are pseudonymized with, so that log entries
+// identify users by a keyed hash of their email rather than the email itself. The same user
+// has the same pseudonym in every entry, and on every instance sharing the key, so that their
+// activity can still be followed and attributed by those who hold the key.
+func SetPseudonymizationKey(key []byte) {
+	pseudonymizationKey = key
+}
+
+// Pseudonymizing returns true if users are pseudonymized in log entries.
+func Pseudonymizing() bool {
+	return len(pseudonymizationKey) != 0
+}
+
+// Pseudonymize returns the pseudonym of a user, or the user unchanged if no pseudonymization
+// key is configured.
+func Pseudonymize(user string) string {
+	if len(pseudonymizationKey) == 0 || user == "" {
+		return user
+	}
+	mac := hmac.New(sha256.New, pseudonymizationKey)
+	mac.Write([]byte(user))
+	return "pseudonym:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
 // LogEntry is a wrapper around a logrus entry
 type LogEntry struct {
 	logger *logrus.Entry
@@ -84,6 +115,11 @@ func (l *LogEntry) WithAllowedGroups(groups []string) *LogEntry {
 	return l.withField("allowed_groups", groups)
 }
 
+// WithASN appends an `asn` tag to a LogEntry.
+func (l *LogEntry) WithASN(asn uint64) *LogEntry {
+	return l.withField("asn", asn)
+}
+
 // WithBackoffDuration appends a `backoff_duration` tag to a LogEntry.
 func (l *LogEntry) WithBackoffDuration(resetDuration time.Duration) *LogEntry {
 	return l.withField("backoff_duration", resetDuration)
@@ -109,6 +145,12 @@ func (l *LogEntry) WithClientID(clientID string) *LogEntry {
 	return l.withField("client_id", clientID)
 }
 
+// WithConfigDiff appends a `config_diff` tag to a LogEntry indicating how the configs changed
+// when they were (re)loaded.
+func (l *LogEntry) WithConfigDiff(diff interface{}) *LogEntry {
+	return l.withField("config_diff", diff)
+}
+
 // WithCookieDomain appends a `cookie_domain` tag to a LogEntry.
 func (l *LogEntry) WithCookieDomain(domain string) *LogEntry {
 	return l.withField("cookie_domain", domain)
@@ -139,6 +181,11 @@ func (l *LogEntry) WithCookieSecure(isSecure bool) *LogEntry {
 	return l.withField("cookie_secure", isSecure)
 }
 
+// WithCountry appends a `country` tag to a LogEntry.
+func (l *LogEntry) WithCountry(country string) *LogEntry {
+	return l.withField("country", country)
+}
+
 // WithEndpoint appends an `endpoint` tag to a LogEntry.
 func (l *LogEntry) WithEndpoint(endpoint string) *LogEntry {
 	return l.withField("endpoint", endpoint)
@@ -155,6 +202,12 @@ func (l *LogEntry) WithHTTPStatus(status int) *LogEntry {
 	return l.withField("http_status", status)
 }
 
+// WithImpersonatedUser appends an `impersonated_user` tag to a LogEntry, pseudonymized if a
+// pseudonymization key is configured.
+func (l *LogEntry) WithImpersonatedUser(user string) *LogEntry {
+	return l.withField("impersonated_user", Pseudonymize(user))
+}
+
 // WithInGroups appends an `in_groups` tag to a LogEntry.
 func (l *LogEntry) WithInGroups(groups []string) *LogEntry {
 	return l.withField("in_groups", groups)
@@ -195,6 +248,11 @@ func (l *LogEntry) WithRemoteAddress(address string) *LogEntry {
 	return l.withField("remote_address", address)
 }
 
+// WithRequestBody appends a `request_body` tag to a LogEntry.
+func (l *LogEntry) WithRequestBody(body string) *LogEntry {
+	return l.withField("request_body", body)
+}
+
 // WithRequestDurationMs appends a `request_duration` tag to a LogEntry.
 func (l *LogEntry) WithRequestDurationMs(duration float64) *LogEntry {
 	return l.withField("request_duration", duration)
@@ -205,6 +263,19 @@ func (l *LogEntry) WithRequestHost(host string) *LogEntry {
 	return l.withField("request_host", host)
 }
 
+// WithRequestID appends a `request_id` tag to a LogEntry, if the request has an ID.
+func (l *LogEntry) WithRequestID(id string) *LogEntry {
+	if id == "" {
+		return l
+	}
+	return l.withField("request_id", id)
+}
+
+// WithRequestHeaders appends a `request_headers` tag to a LogEntry.
+func (l *LogEntry) WithRequestHeaders(headers http.Header) *LogEntry {
+	return l.withField("request_headers", headers)
+}
+
 // WithRequestURI appends a `request_uri` tag to a LogEntry.
 func (l *LogEntry) WithRequestURI(uri string) *LogEntry {
 	return l.withField("request_uri", uri)
@@ -220,6 +291,17 @@ func (l *LogEntry) WithResponseBody(body []byte) *LogEntry {
 	return l.withField("response_body", body)
 }
 
+// WithResponseBodyText appends a `response_body` tag to a LogEntry, holding a body that is
+// logged as text rather than bytes.
+func (l *LogEntry) WithResponseBodyText(body string) *LogEntry {
+	return l.withField("response_body", body)
+}
+
+// WithResponseHeaders appends a `response_headers` tag to a LogEntry.
+func (l *LogEntry) WithResponseHeaders(headers http.Header) *LogEntry {
+	return l.withField("response_headers", headers)
+}
+
 // WithRewriteRoute appends a `rewrite_route` tag to a LogEntry.
 func (l *LogEntry) WithRewriteRoute(route interface{}) *LogEntry {
 	return l.withField("rewrite_route", route)
@@ -230,6 +312,12 @@ func (l *LogEntry) WithRefreshDeadline(refresh time.Time) *LogEntry {
 	return l.withField("refresh_deadline", refresh)
 }
 
+// WithServiceName appends a `service_name` tag to a LogEntry, naming the service an upstream
+// belongs to.
+func (l *LogEntry) WithServiceName(name string) *LogEntry {
+	return l.withField("service_name", name)
+}
+
 // WithSessionValid appends a `session_valid` tag to a LogEntry.
 func (l *LogEntry) WithSessionValid(valid time.Time) *LogEntry {
 	return l.withField("session_valid", valid)
@@ -255,14 +343,25 @@ func (l *LogEntry) WithStatsdPort(port int) *LogEntry {
 	return l.withField("statsd_port", port)
 }
 
+// WithTeam appends a `team` tag to a LogEntry.
+func (l *LogEntry) WithTeam(team string) *LogEntry {
+	return l.withField("team", team)
+}
+
+// WithTier appends a `tier` tag to a LogEntry.
+func (l *LogEntry) WithTier(tier string) *LogEntry {
+	return l.withField("tier", tier)
+}
+
 // WithURLParam appends a `url_param` tag to a LogEntry.
 func (l *LogEntry) WithURLParam(param string) *LogEntry {
 	return l.withField("url_param", param)
 }
 
-// WithUser appends a `user` tag to a LogEntry.
+// WithUser appends a `user` tag to a LogEntry, pseudonymized if a pseudonymization key is
+// configured.
 func (l *LogEntry) WithUser(user string) *LogEntry {
-	return l.withField("user", user)
+	return l.withField("user", Pseudonymize(user))
 }
 
 // WithUserAgent appends a `user_agent` tag to a LogEntry.
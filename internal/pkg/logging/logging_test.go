@@ -0,0 +1,32 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestPseudonymize(t *testing.T) {
+	defer SetPseudonymizationKey(nil)
+
+	testutil.Equal(t, false, Pseudonymizing())
+	testutil.Equal(t, "user@example.com", Pseudonymize("user@example.com"))
+	testutil.Equal(t, "user@example.com", NewLogEntry().WithUser("user@example.com").Fields()["user"])
+
+	SetPseudonymizationKey([]byte("pseudonymization-key"))
+	testutil.Equal(t, true, Pseudonymizing())
+	pseudonym := Pseudonymize("user@example.com")
+	testutil.Assert(t, strings.HasPrefix(pseudonym, "pseudonym:"), "unexpected pseudonym %q", pseudonym)
+	testutil.Equal(t, pseudonym, Pseudonymize("user@example.com"))
+	testutil.NotEqual(t, pseudonym, Pseudonymize("other@example.com"))
+	testutil.Equal(t, "", Pseudonymize(""))
+
+	entry := NewLogEntry().WithUser("user@example.com").WithImpersonatedUser("user@example.com")
+	testutil.Equal(t, pseudonym, entry.Fields()["user"])
+	testutil.Equal(t, pseudonym, entry.Fields()["impersonated_user"])
+
+	// pseudonyms depend on the key
+	SetPseudonymizationKey([]byte("other-key"))
+	testutil.NotEqual(t, pseudonym, Pseudonymize("user@example.com"))
+}
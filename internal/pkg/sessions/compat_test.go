@@ -0,0 +1,146 @@
+package sessions
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+)
+
+// sessionFormats are the plaintext sessions of each release that changed the format of
+// sessions, oldest first. A release that changes the format adds its own, and must keep
+// decoding every other.
+var sessionFormats = []string{
+	"testdata/upstream_session.json",
+	"testdata/formats/v2_impersonation.json",
+	"testdata/formats/v3_issued_at.json",
+	"testdata/formats/v4_acknowledgements.json",
+	"testdata/formats/v5_scopes.json",
+	"testdata/formats/v6_claims.json",
+}
+
+func readSessionFormat(t *testing.T, path string) ([]byte, map[string]interface{}) {
+	plaintext, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", path, err)
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(plaintext, &fields); err != nil {
+		t.Fatalf("error decoding %s: %v", path, err)
+	}
+	return plaintext, fields
+}
+
+// compatCiphers returns each cipher sessions can be encrypted with.
+func compatCiphers(t *testing.T) map[string]aead.Cipher {
+	miscreant, err := aead.NewMiscreantCipher(goldenSecret)
+	if err != nil {
+		t.Fatalf("expected to be able to create cipher: %v", err)
+	}
+	gcm, err := aead.NewGCMCipher(goldenSecret)
+	if err != nil {
+		t.Fatalf("expected to be able to create cipher: %v", err)
+	}
+	return map[string]aead.Cipher{"miscreant": miscreant, "gcm": gcm}
+}
+
+// encryptPlaintext encodes a plaintext session as a session cookie, as MarshalSession encodes
+// the JSON encoding of a session.
+func encryptPlaintext(t testing.TB, c aead.Cipher, plaintext []byte) string {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write(plaintext)
+	w.Close()
+	ciphertext, err := c.Encrypt(compressed.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error encrypting session: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(ciphertext)
+}
+
+// decryptPlaintext decodes the JSON encoding of a session from a session cookie, without
+// decoding it into a SessionState, as the releases of other formats would see it.
+func decryptPlaintext(t *testing.T, c aead.Cipher, value string) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if err := c.Unmarshal(value, &fields); err != nil {
+		t.Fatalf("error decoding session cookie: %v", err)
+	}
+	return fields
+}
+
+// checkFieldsKept fails the test if any field of a session format is missing from a session,
+// or has a different value.
+func checkFieldsKept(t *testing.T, format string, want, got map[string]interface{}) {
+	for field, value := range want {
+		if !reflect.DeepEqual(value, got[field]) {
+			t.Errorf("expected field %q of %s to be %v, got %v", field, format, value, got[field])
+		}
+	}
+}
+
+func TestSessionFormatsExtendPrevious(t *testing.T) {
+	// each format must keep every field of the previous one, under the same name
+	_, previous := readSessionFormat(t, sessionFormats[0])
+	for _, format := range sessionFormats[1:] {
+		_, fields := readSessionFormat(t, format)
+		checkFieldsKept(t, format, previous, fields)
+		previous = fields
+	}
+}
+
+func TestSessionFormatsCompatibility(t *testing.T) {
+	for name, c := range compatCiphers(t) {
+		for _, written := range sessionFormats {
+			plaintext, fields := readSessionFormat(t, written)
+			t.Run(name+"/"+written, func(t *testing.T) {
+				// sessions written by any release are read by this one, and by those after it
+				session, err := UnmarshalSession(encryptPlaintext(t, c, plaintext), c)
+				if err != nil {
+					t.Fatalf("expected to decode a session of %s: %v", written, err)
+				}
+
+				// sessions written by this release are read by every release, which ignore the
+				// fields of formats newer than their own
+				value, err := MarshalSession(session, c)
+				if err != nil {
+					t.Fatalf("expected to encode a session of %s: %v", written, err)
+				}
+				checkFieldsKept(t, written, fields, decryptPlaintext(t, c, value))
+			})
+		}
+	}
+}
+
+func TestUnmarshalGCMSessionGolden(t *testing.T) {
+	latest := sessionFormats[len(sessionFormats)-1]
+	plaintext, _ := readSessionFormat(t, latest)
+	want := &SessionState{}
+	if err := json.Unmarshal(plaintext, want); err != nil {
+		t.Fatalf("error decoding %s: %v", latest, err)
+	}
+
+	golden := strings.TrimSuffix(latest, ".json") + ".gcm.golden"
+	cookie, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", golden, err)
+	}
+	c, err := aead.NewGCMCipher(goldenSecret)
+	if err != nil {
+		t.Fatalf("expected to be able to create cipher: %v", err)
+	}
+	got, err := UnmarshalSession(strings.TrimSpace(string(cookie)), c)
+	if err != nil {
+		t.Fatalf("expected to decode the session cookie of %s: %v", golden, err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Logf("want: %#v", want)
+		t.Logf(" got: %#v", got)
+		t.Errorf("decoding the session cookie of %s resulted in unexpected output", golden)
+	}
+}
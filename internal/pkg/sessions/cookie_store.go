@@ -10,6 +10,7 @@ import (
 
 	"github.com/buzzfeed/sso/internal/pkg/aead"
 	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"golang.org/x/net/publicsuffix"
 )
 
 // ErrInvalidSession is an error for invalid sessions.
@@ -29,7 +30,9 @@ type SessionStore interface {
 	SaveSession(http.ResponseWriter, *http.Request, *SessionState) error
 }
 
-// CookieStore represents all the cookie related configurations
+// CookieStore represents all the cookie related configurations. Unless a CookieDomain is set,
+// cookies are set on the host of the request, or on its registrable domain if DeriveCookieDomain
+// is set.
 type CookieStore struct {
 	Name               string
 	CSRFCookieName     string
@@ -38,6 +41,7 @@ type CookieStore struct {
 	CookieSecure       bool
 	CookieHTTPOnly     bool
 	CookieDomain       string
+	DeriveCookieDomain bool
 	CookieCipher       aead.Cipher
 	SessionLifetimeTTL time.Duration
 }
@@ -90,6 +94,8 @@ func (s *CookieStore) makeCookie(req *http.Request, name string, value string, e
 			logger.WithRequestHost(domain).WithCookieDomain(s.CookieDomain).Warn("Warning: Using configured cookie domain.")
 		}
 		domain = s.CookieDomain
+	} else if s.DeriveCookieDomain {
+		domain = registrableDomain(domain)
 	}
 
 	return &http.Cookie{
@@ -103,6 +109,21 @@ func (s *CookieStore) makeCookie(req *http.Request, name string, value string, e
 	}
 }
 
+// registrableDomain returns the domain of a host one label below its public suffix, e.g.
+// example.co.uk for sso.example.co.uk, so that a cookie set on it is shared by every host of the
+// domain. IP addresses, and hosts such as localhost that have no registrable domain, are
+// returned unchanged.
+func registrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
 // makeSessionCookie constructs a session cookie given the request, an expiration time and the current time.
 func (s *CookieStore) makeSessionCookie(req *http.Request, value string, expiration time.Duration, now time.Time) *http.Cookie {
 	return s.makeCookie(req, s.Name, value, expiration, now)
@@ -133,6 +133,24 @@ func TestMakeSessionCookie(t *testing.T) {
 				Expires:  now.Add(expiration),
 			},
 		},
+		{
+			name: "cookie domain derived from the request host",
+			optFuncs: []func(*CookieStore) error{
+				func(s *CookieStore) error {
+					s.DeriveCookieDomain = true
+					return nil
+				},
+			},
+			expectedCookie: &http.Cookie{
+				Name:     cookieName,
+				Value:    cookieValue,
+				Path:     "/",
+				Domain:   "example.com",
+				HttpOnly: true,
+				Secure:   true,
+				Expires:  now.Add(expiration),
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -146,6 +164,28 @@ func TestMakeSessionCookie(t *testing.T) {
 	}
 }
 
+func TestRegistrableDomain(t *testing.T) {
+	testCases := []struct {
+		host     string
+		expected string
+	}{
+		{host: "example.com", expected: "example.com"},
+		{host: "sso.example.com", expected: "example.com"},
+		{host: "sso.auth.example.com", expected: "example.com"},
+		{host: "sso.example.co.uk", expected: "example.co.uk"},
+		{host: "sso.example.com.", expected: "example.com"},
+		{host: "localhost", expected: "localhost"},
+		{host: "127.0.0.1", expected: "127.0.0.1"},
+		{host: "::1", expected: "::1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			testutil.Equal(t, tc.expected, registrableDomain(tc.host))
+		})
+	}
+}
+
 func TestMakeSessionCSRFCookie(t *testing.T) {
 	now := time.Now()
 	cookieValue := "cookieValue"
@@ -346,3 +386,36 @@ func TestLoadCookiedSession(t *testing.T) {
 		})
 	}
 }
+
+func BenchmarkLoadSession(b *testing.B) {
+	store, err := NewCookieStore("cookieName", CreateMiscreantCookieCipher(testEncodedCookieSecret))
+	if err != nil {
+		b.Fatalf("unexpected error creating cookie store: %s", err)
+	}
+
+	now := time.Now()
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://www.example.com", nil)
+	err = store.SaveSession(rw, req, &SessionState{
+		Email:            "jane@example.com",
+		User:             "jane",
+		Groups:           []string{"writers", "readers"},
+		AccessToken:      "access-token",
+		RefreshToken:     "refresh-token",
+		RefreshDeadline:  now.Add(time.Hour),
+		LifetimeDeadline: now.Add(time.Hour),
+		ValidDeadline:    now.Add(time.Minute),
+	})
+	if err != nil {
+		b.Fatalf("unexpected error saving session: %s", err)
+	}
+	req.Header.Set("Cookie", rw.Header().Get("Set-Cookie"))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.LoadSession(req); err != nil {
+			b.Fatalf("unexpected error loading session: %s", err)
+		}
+	}
+}
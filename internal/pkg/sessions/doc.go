@@ -0,0 +1,25 @@
+/*
+Package sessions holds the session state shared by sso-auth and sso-proxy, and the cookie store
+both of them use to save and load it.
+
+The API of the package is stable, as sessions issued by one release must be readable by the next:
+
+  - SessionState is the session of a user, and the deadlines of its refresh, validation and
+    lifetime periods, which are extended with ExtendDeadline.
+  - MarshalSession and UnmarshalSession encode and decode a session with an aead.Cipher.
+  - SessionStore and CSRFStore save, load and clear the session and CSRF cookies, and are
+    implemented by CookieStore, and by MockSessionStore and MockCSRFStore in tests.
+
+A session cookie is the JSON encoding of the session, gzipped, encrypted with AES-CMAC-SIV
+using a random 16 byte nonce that is appended to the ciphertext, and encoded with unpadded URL
+safe base64, which is the format of the cookies of the upstream buzzfeed/sso. Fields may be added
+to SessionState, but the JSON names of the existing fields must not change; the golden files in
+testdata check that cookies of the upstream format can still be decoded.
+
+Releases are rolled out gradually, so sessions must be readable by the releases before and after
+the one that wrote them. A release that adds fields to SessionState adds a plaintext session of
+its format to testdata/formats, and the compatibility tests check that sessions of every format
+are decoded by the current release, with either cipher, and encoded again without losing any of
+the fields older releases read.
+*/
+package sessions
@@ -0,0 +1,50 @@
+//go:build go1.18
+// +build go1.18
+
+package sessions
+
+import (
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+)
+
+// FuzzUnmarshalSession decodes arbitrary session cookies, which clients fully control.
+func FuzzUnmarshalSession(f *testing.F) {
+	_, cookie := readGoldenSession(f)
+	f.Add(cookie)
+	f.Add("")
+	f.Add("not-base64!")
+	f.Add(cookie[:len(cookie)/2])
+
+	c, err := aead.NewMiscreantCipher(goldenSecret)
+	if err != nil {
+		f.Fatalf("expected to be able to create cipher: %v", err)
+	}
+	f.Fuzz(func(t *testing.T, value string) {
+		s, err := UnmarshalSession(value, c)
+		if err != nil {
+			return
+		}
+		if _, err := MarshalSession(s, c); err != nil {
+			t.Errorf("decoded session could not be encoded again: %v", err)
+		}
+	})
+}
+
+// FuzzUnmarshalSessionPlaintext decodes sessions whose compressed JSON is arbitrary, which
+// cookies that can't be decrypted never reach.
+func FuzzUnmarshalSessionPlaintext(f *testing.F) {
+	plaintext, _ := readGoldenSession(f)
+	f.Add(plaintext)
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"groups":null,"refresh_deadline":"not a time"}`))
+
+	c, err := aead.NewMiscreantCipher(goldenSecret)
+	if err != nil {
+		f.Fatalf("expected to be able to create cipher: %v", err)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		UnmarshalSession(encryptPlaintext(t, c, data), c)
+	})
+}
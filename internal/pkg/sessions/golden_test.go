@@ -0,0 +1,88 @@
+package sessions
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+)
+
+// goldenSecret is the cookie secret testdata/upstream_session.golden was encrypted with.
+var goldenSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func readGoldenSession(t testing.TB) ([]byte, string) {
+	plaintext, err := ioutil.ReadFile("testdata/upstream_session.json")
+	if err != nil {
+		t.Fatalf("error reading testdata/upstream_session.json: %v", err)
+	}
+	cookie, err := ioutil.ReadFile("testdata/upstream_session.golden")
+	if err != nil {
+		t.Fatalf("error reading testdata/upstream_session.golden: %v", err)
+	}
+	return plaintext, strings.TrimSpace(string(cookie))
+}
+
+func TestUnmarshalUpstreamSessionGolden(t *testing.T) {
+	_, cookie := readGoldenSession(t)
+	c, err := aead.NewMiscreantCipher(goldenSecret)
+	if err != nil {
+		t.Fatalf("expected to be able to create cipher: %v", err)
+	}
+
+	got, err := UnmarshalSession(cookie, c)
+	if err != nil {
+		t.Fatalf("expected to decode the upstream session cookie: %v", err)
+	}
+
+	want := &SessionState{
+		ProviderSlug: "google",
+		ProviderType: "google",
+
+		AccessToken:  "access-token",
+		RefreshToken: "refresh-token",
+
+		RefreshDeadline:  time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC),
+		LifetimeDeadline: time.Date(2019, 1, 31, 0, 0, 0, 0, time.UTC),
+		ValidDeadline:    time.Date(2019, 1, 1, 0, 1, 0, 0, time.UTC),
+
+		Email:  "jane@example.com",
+		User:   "jane",
+		Groups: []string{"admins", "engineering@example.com"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Logf("want: %#v", want)
+		t.Logf(" got: %#v", got)
+		t.Errorf("decoding the upstream session cookie resulted in unexpected output")
+	}
+}
+
+func TestMarshalSessionUpstreamFields(t *testing.T) {
+	plaintext, _ := readGoldenSession(t)
+
+	// every field of the upstream format must be encoded under the same name and with the same value
+	session := &SessionState{}
+	if err := json.Unmarshal(plaintext, session); err != nil {
+		t.Fatalf("error decoding the upstream session: %v", err)
+	}
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		t.Fatalf("error encoding session: %v", err)
+	}
+
+	var want, got map[string]interface{}
+	if err := json.Unmarshal(plaintext, &want); err != nil {
+		t.Fatalf("error decoding the upstream session: %v", err)
+	}
+	if err := json.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("error decoding the encoded session: %v", err)
+	}
+	for field, value := range want {
+		if !reflect.DeepEqual(value, got[field]) {
+			t.Errorf("expected field %q to be encoded as %v, got %v", field, value, got[field])
+		}
+	}
+}
@@ -20,6 +20,7 @@ type SessionState struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 
+	IssuedAt         time.Time `json:"issued_at"`
 	RefreshDeadline  time.Time `json:"refresh_deadline"`
 	LifetimeDeadline time.Time `json:"lifetime_deadline"`
 	ValidDeadline    time.Time `json:"valid_deadline"`
@@ -28,6 +29,67 @@ type SessionState struct {
 	Email  string   `json:"email"`
 	User   string   `json:"user"`
 	Groups []string `json:"groups"`
+
+	// Scopes are the OAuth scopes granted to the access token, as reported by the provider
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Claims are the claims of the provider's ID token that describe the user, e.g. their name
+	Claims map[string]interface{} `json:"claims,omitempty"`
+
+	Impersonation *Impersonation `json:"impersonation,omitempty"`
+
+	// Acknowledgements records when the user acknowledged the interstitial page of each host.
+	Acknowledgements map[string]time.Time `json:"acknowledgements,omitempty"`
+}
+
+// Impersonation records an administrator acting as another user on a single host.
+type Impersonation struct {
+	Email    string    `json:"email"`
+	Groups   []string  `json:"groups"`
+	Host     string    `json:"host"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// ImpersonationExpired returns true if the session has an impersonation whose deadline has passed
+func (s *SessionState) ImpersonationExpired() bool {
+	return s.Impersonation != nil && isExpired(s.Impersonation.Deadline)
+}
+
+// ImpersonatingOn returns true if the session is impersonating another user on the given host
+func (s *SessionState) ImpersonatingOn(host string) bool {
+	return s.Impersonation != nil && s.Impersonation.Host == host && !isExpired(s.Impersonation.Deadline)
+}
+
+// AcknowledgedOn returns true if the user has acknowledged the interstitial page of the given host
+func (s *SessionState) AcknowledgedOn(host string) bool {
+	_, ok := s.Acknowledgements[host]
+	return ok
+}
+
+// Acknowledge records that the user acknowledged the interstitial page of the given host
+func (s *SessionState) Acknowledge(host string) {
+	if s.Acknowledgements == nil {
+		s.Acknowledgements = make(map[string]time.Time)
+	}
+	s.Acknowledgements[host] = time.Now()
+}
+
+// MissingScopes returns the given scopes that haven't been granted to the session
+func (s *SessionState) MissingScopes(scopes []string) []string {
+	var missing []string
+	for _, scope := range scopes {
+		granted := false
+		for _, g := range s.Scopes {
+			if g == scope {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
 }
 
 // LifetimePeriodExpired returns true if the lifetime has expired
@@ -72,3 +72,57 @@ func TestSessionStateExpirations(t *testing.T) {
 		t.Errorf("expcted lifetime period to be expired")
 	}
 }
+
+func TestSessionStateImpersonatingOn(t *testing.T) {
+	session := &SessionState{Email: "admin@domain.com"}
+	if session.ImpersonatingOn("foo.domain.com") {
+		t.Errorf("expected session without impersonation not to be impersonating")
+	}
+
+	session.Impersonation = &Impersonation{
+		Email:    "user@domain.com",
+		Host:     "foo.domain.com",
+		Deadline: time.Now().Add(time.Hour),
+	}
+	if !session.ImpersonatingOn("foo.domain.com") {
+		t.Errorf("expected session to be impersonating on foo.domain.com")
+	}
+	if session.ImpersonatingOn("bar.domain.com") {
+		t.Errorf("expected session not to be impersonating on bar.domain.com")
+	}
+
+	session.Impersonation.Deadline = time.Now().Add(-1 * time.Minute)
+	if session.ImpersonatingOn("foo.domain.com") {
+		t.Errorf("expected expired impersonation not to be impersonating")
+	}
+	if !session.ImpersonationExpired() {
+		t.Errorf("expected impersonation to be expired")
+	}
+}
+
+func TestSessionStateAcknowledgedOn(t *testing.T) {
+	session := &SessionState{Email: "user@domain.com"}
+	if session.AcknowledgedOn("foo.domain.com") {
+		t.Errorf("expected new session not to have acknowledged foo.domain.com")
+	}
+
+	session.Acknowledge("foo.domain.com")
+	if !session.AcknowledgedOn("foo.domain.com") {
+		t.Errorf("expected session to have acknowledged foo.domain.com")
+	}
+	if session.AcknowledgedOn("bar.domain.com") {
+		t.Errorf("expected session not to have acknowledged bar.domain.com")
+	}
+}
+
+func TestSessionStateMissingScopes(t *testing.T) {
+	session := &SessionState{Email: "user@domain.com", Scopes: []string{"email", "profile"}}
+	if missing := session.MissingScopes([]string{"email"}); len(missing) != 0 {
+		t.Errorf("expected no missing scopes, got %v", missing)
+	}
+
+	missing := session.MissingScopes([]string{"email", "calendar.readonly"})
+	if !reflect.DeepEqual([]string{"calendar.readonly"}, missing) {
+		t.Errorf("expected calendar.readonly to be missing, got %v", missing)
+	}
+}
@@ -0,0 +1,300 @@
+// Package spiffe fetches the X.509 SVIDs of sso from the SPIFFE Workload API, e.g. of a SPIRE
+// agent, so that its certificates for mutual TLS are issued and rotated without managing them.
+package spiffe
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	fetchX509SVIDMethod = "/SpiffeWorkloadAPI/FetchX509SVID"
+
+	// workloadHeader must be set on every request to the Workload API, to keep it from being
+	// called by browsers through server-side request forgery.
+	workloadHeader = "workload.spiffe.io"
+
+	retryInterval = 5 * time.Second
+)
+
+// SVID is an X.509 SPIFFE verifiable identity document, and the bundle of the CAs of its trust
+// domain.
+type SVID struct {
+	ID           string
+	Certificates []*x509.Certificate
+	PrivateKey   crypto.Signer
+	Bundle       *x509.CertPool
+}
+
+// Source is the current SVID of the workload. The Workload API streams a new SVID before the
+// current one expires, and the source keeps watching it for as long as the process runs.
+type Source struct {
+	mu   sync.RWMutex
+	svid *SVID
+}
+
+// NewSource connects to the Workload API at an endpoint, e.g. unix:///run/spire/sockets/agent.sock,
+// and returns the source of its SVIDs once the first one is fetched. Errors watching the API
+// after that are logged and retried, keeping the current SVID.
+func NewSource(ctx context.Context, endpoint string) (*Source, error) {
+	network, addr, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(addr,
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout(network, addr, timeout)
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Source{}
+	updates := make(chan error, 1)
+	go s.watch(conn, updates)
+
+	select {
+	case err := <-updates:
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error fetching SVID from the workload API: %s", err)
+		}
+		return s, nil
+	case <-ctx.Done():
+		conn.Close()
+		return nil, fmt.Errorf("error fetching SVID from the workload API: %s", ctx.Err())
+	}
+}
+
+// ParseEndpoint returns the network and address of a Workload API endpoint, a unix:// or tcp://
+// URL as in SPIFFE_ENDPOINT_SOCKET.
+func ParseEndpoint(endpoint string) (string, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid workload API endpoint %q: %s", endpoint, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" || u.Host != "" {
+			return "", "", fmt.Errorf("invalid workload API endpoint %q: must be unix:///path/to/socket", endpoint)
+		}
+		return "unix", u.Path, nil
+	case "tcp":
+		if net.ParseIP(u.Hostname()) == nil || u.Port() == "" {
+			return "", "", fmt.Errorf("invalid workload API endpoint %q: must be tcp://ip:port", endpoint)
+		}
+		return "tcp", u.Host, nil
+	default:
+		return "", "", fmt.Errorf("invalid workload API endpoint %q: must be a unix:// or tcp:// URL", endpoint)
+	}
+}
+
+// watch streams the SVIDs of the Workload API, forever. The result of the first fetch is sent
+// on first, and the stream is reopened whenever it fails after that.
+func (s *Source) watch(conn *grpc.ClientConn, first chan<- error) {
+	logger := log.NewLogEntry()
+	fetched := func() {
+		if first != nil {
+			first <- nil
+			first = nil
+		}
+	}
+	for {
+		err := s.stream(conn, fetched)
+		if first != nil {
+			// NewSource returns the error of the first fetch, and closes the connection
+			first <- err
+			return
+		}
+		if conn.GetState() == connectivity.Shutdown {
+			// NewSource timed out and closed the connection
+			return
+		}
+		logger.Error(err, "error watching SVIDs of the workload API; the current SVID is unchanged")
+		time.Sleep(retryInterval)
+	}
+}
+
+// stream receives SVIDs until the stream fails, calling fetched after each one.
+func (s *Source) stream(conn *grpc.ClientConn, fetched func()) error {
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(), workloadHeader, "true"))
+	defer cancel()
+
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{StreamName: "FetchX509SVID", ServerStreams: true}, fetchX509SVIDMethod)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&X509SVIDRequest{}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		resp := &X509SVIDResponse{}
+		if err := stream.RecvMsg(resp); err != nil {
+			return err
+		}
+		svid, err := ParseResponse(resp)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.svid = svid
+		s.mu.Unlock()
+		fetched()
+	}
+}
+
+// ParseResponse returns the first, default SVID of a Workload API response.
+func ParseResponse(resp *X509SVIDResponse) (*SVID, error) {
+	if len(resp.SVIDs) == 0 {
+		return nil, errors.New("workload API returned no SVIDs")
+	}
+	svid := resp.SVIDs[0]
+
+	certs, err := x509.ParseCertificates(svid.X509SVID)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SVID certificates: %s", err)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("SVID has no certificates")
+	}
+	id, err := IDFromCertificate(certs[0])
+	if err != nil {
+		return nil, err
+	}
+	if id != svid.SPIFFEID {
+		return nil, fmt.Errorf("SVID certificate is for %s, not %s", id, svid.SPIFFEID)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(svid.X509SVIDKey)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SVID private key: %s", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("SVID private key of type %T can't sign", key)
+	}
+
+	bundle, err := x509.ParseCertificates(svid.Bundle)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing trust bundle: %s", err)
+	}
+	if len(bundle) == 0 {
+		return nil, errors.New("trust bundle has no certificates")
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range bundle {
+		pool.AddCert(cert)
+	}
+
+	return &SVID{
+		ID:           svid.SPIFFEID,
+		Certificates: certs,
+		PrivateKey:   signer,
+		Bundle:       pool,
+	}, nil
+}
+
+// IDFromCertificate returns the SPIFFE ID of an SVID certificate, its only spiffe:// URI SAN.
+func IDFromCertificate(cert *x509.Certificate) (string, error) {
+	ids := []string{}
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			ids = append(ids, uri.String())
+		}
+	}
+	if len(ids) != 1 {
+		return "", fmt.Errorf("certificate must have exactly one SPIFFE ID, but has %d", len(ids))
+	}
+	return ids[0], nil
+}
+
+// SVID returns the current SVID.
+func (s *Source) SVID() *SVID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.svid
+}
+
+// certificate returns the TLS certificate of the current SVID.
+func (s *Source) certificate() *tls.Certificate {
+	svid := s.SVID()
+	cert := &tls.Certificate{
+		PrivateKey: svid.PrivateKey,
+		Leaf:       svid.Certificates[0],
+	}
+	for _, c := range svid.Certificates {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert
+}
+
+// GetClientCertificate presents the current SVID as the client certificate of TLS connections.
+func (s *Source) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return s.certificate(), nil
+}
+
+// GetCertificate presents the current SVID as the server certificate of TLS connections.
+func (s *Source) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.certificate(), nil
+}
+
+// VerifyPeer returns the SPIFFE ID of the SVID a peer presented, the raw certificates of a TLS
+// handshake, after verifying it was issued by a CA of the current trust bundle. SVIDs aren't
+// issued for host names, so it takes the place of the usual verification of a TLS config.
+func (s *Source) VerifyPeer(rawCerts [][]byte) (string, error) {
+	if len(rawCerts) == 0 {
+		return "", errors.New("peer presented no SVID")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return "", err
+		}
+		certs = append(certs, cert)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         s.SVID().Bundle,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error verifying SVID: %s", err)
+	}
+	return IDFromCertificate(certs[0])
+}
+
+// ValidateID returns an error if a string isn't a SPIFFE ID, e.g. spiffe://example.org/sso-proxy.
+func ValidateID(id string) error {
+	u, err := url.Parse(id)
+	if err != nil || u.Scheme != "spiffe" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" || strings.Contains(u.Host, ":") {
+		return fmt.Errorf("%q is not a SPIFFE ID, e.g. spiffe://example.org/service", id)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package spiffe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Ok(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	testutil.Ok(t, err)
+	cert, err := x509.ParseCertificate(der)
+	testutil.Ok(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// svid returns a Workload API SVID of a SPIFFE ID issued by the CA.
+func (ca *testCA) svid(t *testing.T, id string) *X509SVID {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Ok(t, err)
+	uri, err := url.Parse(id)
+	testutil.Ok(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{uri},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	testutil.Ok(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	testutil.Ok(t, err)
+	return &X509SVID{
+		SPIFFEID:    id,
+		X509SVID:    der,
+		X509SVIDKey: keyDER,
+		Bundle:      ca.cert.Raw,
+	}
+}
+
+// serveWorkloadAPI serves a Workload API that streams the responses, on a unix socket.
+func serveWorkloadAPI(t *testing.T, socket string, responses ...*X509SVIDResponse) *grpc.Server {
+	t.Helper()
+	listener, err := net.Listen("unix", socket)
+	testutil.Ok(t, err)
+
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "SpiffeWorkloadAPI",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "FetchX509SVID",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				md, _ := metadata.FromIncomingContext(stream.Context())
+				if len(md.Get(workloadHeader)) == 0 {
+					t.Errorf("expected the %s header", workloadHeader)
+				}
+				if err := stream.RecvMsg(&X509SVIDRequest{}); err != nil {
+					return err
+				}
+				for _, resp := range responses {
+					if err := stream.SendMsg(resp); err != nil {
+						return err
+					}
+				}
+				<-stream.Context().Done()
+				return nil
+			},
+		}},
+	}, struct{}{})
+	go server.Serve(listener)
+	return server
+}
+
+func TestSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiffe")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+
+	ca := newTestCA(t)
+	server := serveWorkloadAPI(t, socket, &X509SVIDResponse{
+		SVIDs: []*X509SVID{ca.svid(t, "spiffe://example.org/sso-proxy")},
+	})
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	source, err := NewSource(ctx, "unix://"+socket)
+	testutil.Ok(t, err)
+	testutil.Equal(t, "spiffe://example.org/sso-proxy", source.SVID().ID)
+
+	cert, err := source.GetClientCertificate(nil)
+	testutil.Ok(t, err)
+	testutil.Equal(t, source.SVID().Certificates[0].Raw, cert.Certificate[0])
+
+	// peers are verified against the trust bundle of the SVID
+	peer := ca.svid(t, "spiffe://example.org/admin")
+	id, err := source.VerifyPeer([][]byte{peer.X509SVID})
+	testutil.Ok(t, err)
+	testutil.Equal(t, "spiffe://example.org/admin", id)
+
+	other := newTestCA(t).svid(t, "spiffe://example.org/admin")
+	_, err = source.VerifyPeer([][]byte{other.X509SVID})
+	testutil.NotEqual(t, nil, err)
+	_, err = source.VerifyPeer(nil)
+	testutil.NotEqual(t, nil, err)
+}
+
+func TestNewSourceError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spiffe")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+
+	// a response without SVIDs fails the first fetch
+	server := serveWorkloadAPI(t, socket, &X509SVIDResponse{})
+	defer server.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = NewSource(ctx, "unix://"+socket)
+	testutil.NotEqual(t, nil, err)
+}
+
+func TestParseResponse(t *testing.T) {
+	ca := newTestCA(t)
+	svid := ca.svid(t, "spiffe://example.org/sso-proxy")
+
+	wrongID := *svid
+	wrongID.SPIFFEID = "spiffe://example.org/other"
+	noBundle := *svid
+	noBundle.Bundle = nil
+	badKey := *svid
+	badKey.X509SVIDKey = []byte("not a key")
+
+	testCases := []struct {
+		name    string
+		resp    *X509SVIDResponse
+		wantErr bool
+	}{
+		{name: "svid", resp: &X509SVIDResponse{SVIDs: []*X509SVID{svid}}},
+		{name: "no svids", resp: &X509SVIDResponse{}, wantErr: true},
+		{name: "wrong id", resp: &X509SVIDResponse{SVIDs: []*X509SVID{&wrongID}}, wantErr: true},
+		{name: "no bundle", resp: &X509SVIDResponse{SVIDs: []*X509SVID{&noBundle}}, wantErr: true},
+		{name: "bad key", resp: &X509SVIDResponse{SVIDs: []*X509SVID{&badKey}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseResponse(tc.resp)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equal(t, "spiffe://example.org/sso-proxy", got.ID)
+		})
+	}
+}
+
+func TestParseEndpoint(t *testing.T) {
+	testCases := []struct {
+		endpoint    string
+		wantNetwork string
+		wantAddr    string
+		wantErr     bool
+	}{
+		{endpoint: "unix:///run/spire/sockets/agent.sock", wantNetwork: "unix", wantAddr: "/run/spire/sockets/agent.sock"},
+		{endpoint: "tcp://127.0.0.1:8081", wantNetwork: "tcp", wantAddr: "127.0.0.1:8081"},
+		{endpoint: "unix://run/agent.sock", wantErr: true},
+		{endpoint: "tcp://spire-agent:8081", wantErr: true},
+		{endpoint: "/run/spire/sockets/agent.sock", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			network, addr, err := ParseEndpoint(tc.endpoint)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equal(t, tc.wantNetwork, network)
+			testutil.Equal(t, tc.wantAddr, addr)
+		})
+	}
+}
+
+func TestValidateID(t *testing.T) {
+	testutil.Ok(t, ValidateID("spiffe://example.org/sso-proxy"))
+	for _, id := range []string{"https://example.org/sso-proxy", "spiffe:///sso-proxy", "spiffe://example.org:8443/sso", "sso-proxy"} {
+		testutil.NotEqual(t, nil, ValidateID(id))
+	}
+}
@@ -0,0 +1,139 @@
+// Package systemd integrates servers with systemd's supervision of services: socket activation,
+// with which systemd binds the sockets of a service and passes them to it, and sd_notify, with
+// which a service tells systemd that it's ready, that it's stopping, and that it's still alive.
+// Every function is a no-op in processes systemd didn't start.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UnnamedSocket is the name of sockets passed without a FileDescriptorName=.
+const UnnamedSocket = "unknown"
+
+// listenFDsStart is the first file descriptor of the sockets systemd passes.
+const listenFDsStart = 3
+
+var (
+	listenersOnce sync.Once
+	listenersMux  sync.Mutex
+	listeners     map[string][]net.Listener
+	listenersErr  error
+)
+
+// Listener returns a listening socket systemd passed to the process with the given name, or
+// nil if it passed none. Each socket is only returned once.
+func Listener(name string) (net.Listener, error) {
+	listenersOnce.Do(func() {
+		listeners, listenersErr = listenersFromEnv(listenFDsStart)
+	})
+	if listenersErr != nil {
+		return nil, listenersErr
+	}
+
+	listenersMux.Lock()
+	defer listenersMux.Unlock()
+	named := listeners[name]
+	if len(named) == 0 {
+		return nil, nil
+	}
+	listeners[name] = named[1:]
+	return named[0], nil
+}
+
+// listenersFromEnv returns the sockets passed to the process by their names, as described by
+// the LISTEN_ environment variables, which are unset so that child processes don't inherit them.
+func listenersFromEnv(start int) (map[string][]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string][]net.Listener, count)
+	for i := 0; i < count; i++ {
+		name := UnnamedSocket
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		// the listener has a duplicate of the file descriptor, so the original is closed
+		f := os.NewFile(uintptr(start+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %s passed by systemd isn't a listening socket: %s", name, err)
+		}
+		listeners[name] = append(listeners[name], ln)
+	}
+	return listeners, nil
+}
+
+// Notify sends a state to systemd, e.g. "READY=1", returning false if the process isn't
+// supervised by systemd.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	// sockets in the abstract namespace, prefixed with @, are translated by the net package
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the interval within which systemd's watchdog expects to be notified
+// that the process is alive, if the watchdog is enabled for it.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// Watchdog notifies systemd's watchdog that the process is alive at half its interval, until
+// stop is closed, if the watchdog is enabled for the process.
+func Watchdog(stop <-chan struct{}) {
+	interval, ok := WatchdogInterval()
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			Notify("WATCHDOG=1")
+		}
+	}
+}
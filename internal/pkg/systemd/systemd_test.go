@@ -0,0 +1,135 @@
+//go:build !windows
+// +build !windows
+
+package systemd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// dupListener returns a duplicate of the file descriptor of a listener.
+func dupListener(t *testing.T, ln net.Listener) int {
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("unexpected error getting listener file: %v", err)
+	}
+	defer f.Close()
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatalf("unexpected error duplicating listener file: %v", err)
+	}
+	return fd
+}
+
+func TestListenersFromEnv(t *testing.T) {
+	http, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on a port: %v", err)
+	}
+	defer http.Close()
+	admin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen on a port: %v", err)
+	}
+	defer admin.Close()
+
+	// systemd passes consecutive file descriptors, which are owned by the process
+	httpFD := dupListener(t, http)
+	adminFD := dupListener(t, admin)
+	if adminFD != httpFD+1 {
+		syscall.Close(httpFD)
+		syscall.Close(adminFD)
+		t.Skipf("listener file descriptors %d and %d aren't consecutive", httpFD, adminFD)
+	}
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_FDNAMES", "http:admin")
+	listeners, err := listenersFromEnv(httpFD)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, want := range map[string]net.Listener{"http": http, "admin": admin} {
+		if len(listeners[name]) != 1 {
+			t.Fatalf("expected a single %s listener, got %v", name, listeners[name])
+		}
+		if got := listeners[name][0].Addr().String(); got != want.Addr().String() {
+			t.Errorf("expected the %s listener to listen on %s, got %s", name, want.Addr(), got)
+		}
+		listeners[name][0].Close()
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("expected LISTEN_FDS to be unset")
+	}
+
+	// sockets passed to another process are ignored
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "2")
+	listeners, err = listenersFromEnv(httpFD)
+	if err != nil || listeners != nil {
+		t.Errorf("expected no listeners, got %v, %v", listeners, err)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if ok, err := Notify("READY=1"); ok || err != nil {
+		t.Errorf("expected no notification without NOTIFY_SOCKET, got %v, %v", ok, err)
+	}
+
+	dir, err := ioutil.TempDir("", "systemd")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unix datagram sockets aren't supported: %v", err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	if ok, err := Notify("READY=1"); !ok || err != nil {
+		t.Fatalf("expected a notification, got %v, %v", ok, err)
+	}
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading notification: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1" {
+		t.Errorf("expected READY=1, got %q", got)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	if _, ok := WatchdogInterval(); ok {
+		t.Errorf("expected the watchdog to be disabled")
+	}
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if interval, ok := WatchdogInterval(); !ok || interval != 30*time.Second {
+		t.Errorf("expected a 30s watchdog interval, got %s, %v", interval, ok)
+	}
+
+	os.Setenv("WATCHDOG_PID", "1")
+	if _, ok := WatchdogInterval(); ok {
+		t.Errorf("expected the watchdog of another process to be ignored")
+	}
+}
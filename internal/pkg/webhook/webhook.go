@@ -0,0 +1,173 @@
+// Package webhook delivers events to an HTTP endpoint as signed JSON, retrying failed
+// deliveries in the background.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+const (
+	// SignatureHeader holds the HMAC-SHA256 signature of a delivery, if a secret is configured.
+	SignatureHeader = "X-SSO-Webhook-Signature"
+	// TimestampHeader holds the unix time a delivery was first attempted, which is signed
+	// along with the body so that receivers can reject replayed deliveries.
+	TimestampHeader = "X-SSO-Webhook-Timestamp"
+	// EventHeader holds the type of the delivered event.
+	EventHeader = "X-SSO-Webhook-Event"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultTimeout     = 5 * time.Second
+	defaultQueueSize   = 1000
+)
+
+// Sender posts events to a webhook URL. Events are queued and delivered one at a time, in
+// order, by a background goroutine, so that sending never blocks the caller.
+type Sender struct {
+	URL    string
+	Secret []byte
+
+	// MaxAttempts is the number of times delivery of an event is attempted.
+	MaxAttempts int
+	// Backoff is the time waited before retrying a failed delivery, which doubles after
+	// each attempt.
+	Backoff time.Duration
+
+	client *http.Client
+	queue  chan *delivery
+	sleep  func(time.Duration)
+}
+
+type delivery struct {
+	event     string
+	body      []byte
+	timestamp string
+}
+
+// NewSender returns a Sender delivering events to url, signed with secret if it is not empty,
+// and starts the goroutine that delivers them.
+func NewSender(url string, secret string, optFuncs ...func(*Sender) error) (*Sender, error) {
+	s := &Sender{
+		URL:         url,
+		MaxAttempts: defaultMaxAttempts,
+		Backoff:     defaultBackoff,
+		client:      &http.Client{Timeout: defaultTimeout},
+		queue:       make(chan *delivery, defaultQueueSize),
+		sleep:       time.Sleep,
+	}
+	if secret != "" {
+		s.Secret = []byte(secret)
+	}
+
+	for _, f := range optFuncs {
+		err := f(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Send queues an event of the given type for delivery. Events are dropped, and an error
+// returned, if the queue is full because the webhook is failing or too slow.
+func (s *Sender) Send(event string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	d := &delivery{
+		event:     event,
+		body:      body,
+		timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	select {
+	case s.queue <- d:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue is full, dropping %s event", event)
+	}
+}
+
+func (s *Sender) run() {
+	for d := range s.queue {
+		s.deliver(d)
+	}
+}
+
+// deliver attempts to post an event until it succeeds or MaxAttempts is reached.
+func (s *Sender) deliver(d *delivery) {
+	logger := log.NewLogEntry()
+
+	backoff := s.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(d)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= s.MaxAttempts {
+			logger.Error(err, fmt.Sprintf("webhook delivery of %s event failed after %d attempts", d.event, attempt))
+			return
+		}
+		s.sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt, returning whether a failed attempt should be retried.
+func (s *Sender) post(d *delivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.event)
+	req.Header.Set(TimestampHeader, d.timestamp)
+	if len(s.Secret) != 0 {
+		req.Header.Set(SignatureHeader, Sign(s.Secret, d.timestamp, d.body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected webhook response status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected webhook response status %d", resp.StatusCode)
+	}
+}
+
+// Sign returns the signature of a delivery, "sha256=" followed by the hex encoded
+// HMAC-SHA256 of its timestamp, a period, and its body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns true if signature is the valid signature of a delivery.
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+type testDelivery struct {
+	header http.Header
+	body   string
+}
+
+func testServer(t *testing.T, statuses ...int) (*httptest.Server, chan testDelivery) {
+	deliveries := make(chan testDelivery, len(statuses)+1)
+	attempt := 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		testutil.Assert(t, err == nil, "unexpected error reading body: %s", err)
+		deliveries <- testDelivery{header: req.Header, body: string(body)}
+
+		status := http.StatusOK
+		if attempt < len(statuses) {
+			status = statuses[attempt]
+		}
+		attempt++
+		rw.WriteHeader(status)
+	}))
+	return server, deliveries
+}
+
+func noSleep(s *Sender) error {
+	s.sleep = func(time.Duration) {}
+	return nil
+}
+
+func receive(t *testing.T, deliveries chan testDelivery) testDelivery {
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for delivery")
+	}
+	return testDelivery{}
+}
+
+func TestSendSigned(t *testing.T) {
+	server, deliveries := testServer(t)
+	defer server.Close()
+
+	s, err := NewSender(server.URL, "secret", noSleep)
+	testutil.Assert(t, err == nil, "unexpected error creating sender: %s", err)
+
+	err = s.Send("sign_in", map[string]string{"email": "foo@example.com"})
+	testutil.Assert(t, err == nil, "unexpected error sending event: %s", err)
+
+	d := receive(t, deliveries)
+	testutil.Equal(t, `{"email":"foo@example.com"}`, d.body)
+	testutil.Equal(t, "sign_in", d.header.Get(EventHeader))
+	testutil.Equal(t, "application/json", d.header.Get("Content-Type"))
+
+	timestamp := d.header.Get(TimestampHeader)
+	signature := d.header.Get(SignatureHeader)
+	testutil.Assert(t, Verify([]byte("secret"), timestamp, []byte(d.body), signature),
+		"expected valid signature, got %q", signature)
+	testutil.Assert(t, !Verify([]byte("other"), timestamp, []byte(d.body), signature),
+		"expected signature to depend on the secret")
+	testutil.Assert(t, !Verify([]byte("secret"), "0", []byte(d.body), signature),
+		"expected signature to depend on the timestamp")
+}
+
+func TestSendUnsigned(t *testing.T) {
+	server, deliveries := testServer(t)
+	defer server.Close()
+
+	s, err := NewSender(server.URL, "", noSleep)
+	testutil.Assert(t, err == nil, "unexpected error creating sender: %s", err)
+	s.Send("sign_out", struct{}{})
+
+	d := receive(t, deliveries)
+	testutil.Equal(t, "", d.header.Get(SignatureHeader))
+}
+
+func TestSendRetries(t *testing.T) {
+	testCases := []struct {
+		name             string
+		statuses         []int
+		maxAttempts      int
+		expectedAttempts int
+	}{
+		{
+			name:             "retries server errors",
+			statuses:         []int{http.StatusInternalServerError, http.StatusTooManyRequests},
+			maxAttempts:      5,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "gives up after max attempts",
+			statuses:         []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			maxAttempts:      2,
+			expectedAttempts: 2,
+		},
+		{
+			name:             "does not retry client errors",
+			statuses:         []int{http.StatusBadRequest},
+			maxAttempts:      5,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, deliveries := testServer(t, tc.statuses...)
+			defer server.Close()
+
+			backoffs := []time.Duration{}
+			s, err := NewSender(server.URL, "secret", func(s *Sender) error {
+				s.MaxAttempts = tc.maxAttempts
+				s.sleep = func(d time.Duration) { backoffs = append(backoffs, d) }
+				return nil
+			})
+			testutil.Assert(t, err == nil, "unexpected error creating sender: %s", err)
+
+			// events are delivered in order, so the marker is only received after every
+			// attempt to deliver the first event
+			s.Send("first", struct{}{})
+			s.Send("marker", struct{}{})
+
+			attempts := 0
+			for {
+				d := receive(t, deliveries)
+				if d.header.Get(EventHeader) == "marker" {
+					break
+				}
+				attempts++
+			}
+			testutil.Equal(t, tc.expectedAttempts, attempts)
+			for i, backoff := range backoffs {
+				testutil.Equal(t, defaultBackoff<<uint(i), backoff)
+			}
+		})
+	}
+}
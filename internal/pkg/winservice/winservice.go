@@ -0,0 +1,24 @@
+// Package winservice runs servers as Windows services, so that the service control manager
+// stops them as gracefully as signals do on other platforms. Processes the service control
+// manager didn't start, and processes on other platforms, run as usual.
+package winservice
+
+import "sync"
+
+var (
+	stopOnce sync.Once
+	stopping = make(chan struct{})
+)
+
+// Stopping returns a channel that's closed once the service control manager asks the service
+// to stop, or the host to shut down. It's never closed on other platforms.
+func Stopping() <-chan struct{} {
+	return stopping
+}
+
+// stop closes the channel returned by Stopping.
+func stop() {
+	stopOnce.Do(func() {
+		close(stopping)
+	})
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package winservice
+
+// Run runs a server, returning its exit code. The service has no control manager on this
+// platform, so the server is run as usual.
+func Run(name string, run func() int) int {
+	return run()
+}
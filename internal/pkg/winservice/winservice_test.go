@@ -0,0 +1,20 @@
+package winservice
+
+import "testing"
+
+func TestStopping(t *testing.T) {
+	select {
+	case <-Stopping():
+		t.Fatalf("expected the service not to be stopping")
+	default:
+	}
+
+	// stopping is idempotent, as the service control manager may ask more than once
+	stop()
+	stop()
+	select {
+	case <-Stopping():
+	default:
+		t.Fatalf("expected the service to be stopping")
+	}
+}
@@ -0,0 +1,67 @@
+//go:build windows
+// +build windows
+
+package winservice
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// Run runs a server as the Windows service with the given name when the service control
+// manager started the process, returning its exit code, or as usual otherwise. The server is
+// asked to stop by closing the channel returned by Stopping, and must return once it has.
+func Run(name string, run func() int) int {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error detecting the service control manager: %v\n", err)
+		return 1
+	}
+	if interactive {
+		return run()
+	}
+
+	h := &handler{run: run}
+	if err := svc.Run(name, h); err != nil {
+		fmt.Fprintf(os.Stderr, "error running the %s service: %v\n", name, err)
+		return 1
+	}
+	return h.exitCode
+}
+
+// handler runs a server as a service, answering the requests of the service control manager.
+type handler struct {
+	run      func() int
+	exitCode int
+}
+
+const accepted = svc.AcceptStop | svc.AcceptShutdown
+
+// Execute runs the server until it returns, asking it to stop when the service control
+// manager does.
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan int, 1)
+	go func() {
+		done <- h.run()
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+
+	for {
+		select {
+		case h.exitCode = <-done:
+			status <- svc.Status{State: svc.StopPending}
+			return false, uint32(h.exitCode)
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				stop()
+			}
+		}
+	}
+}
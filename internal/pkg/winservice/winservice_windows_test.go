@@ -0,0 +1,50 @@
+//go:build windows
+// +build windows
+
+package winservice
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+func TestHandlerExecute(t *testing.T) {
+	// other tests may have stopped the service
+	stopping, stopOnce = make(chan struct{}), sync.Once{}
+
+	h := &handler{run: func() int {
+		<-Stopping()
+		return 3
+	}}
+	requests := make(chan svc.ChangeRequest)
+	status := make(chan svc.Status, 10)
+
+	done := make(chan uint32, 1)
+	go func() {
+		_, code := h.Execute(nil, requests, status)
+		done <- code
+	}()
+
+	if s := <-status; s.State != svc.StartPending {
+		t.Errorf("expected the service to be starting, got state %v", s.State)
+	}
+	if s := <-status; s.State != svc.Running || s.Accepts != accepted {
+		t.Errorf("expected the service to be running and accept stops, got %#v", s)
+	}
+
+	requests <- svc.ChangeRequest{Cmd: svc.Stop}
+	select {
+	case code := <-done:
+		if code != 3 {
+			t.Errorf("expected exit code 3, got %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the service to stop")
+	}
+	if h.exitCode != 3 {
+		t.Errorf("expected the handler to keep exit code 3, got %d", h.exitCode)
+	}
+}
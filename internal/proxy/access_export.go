@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awssession "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// The formats access is exported in.
+const (
+	accessExportCSV  = "csv"
+	accessExportJSON = "json"
+)
+
+// The kinds of principals granted access to upstreams.
+const (
+	principalGroup        = "group"
+	principalEmailDomain  = "email_domain"
+	principalEmailAddress = "email_address"
+	principalUser         = "user"
+)
+
+// accessGrant is a principal with access to an upstream: a group, email domain, or email
+// address allowed by its config, or a user observed using it, with their usage.
+type accessGrant struct {
+	Service    string     `json:"service"`
+	Cluster    string     `json:"cluster"`
+	From       string     `json:"from"`
+	Type       string     `json:"type"`
+	Principal  string     `json:"principal"`
+	Requests   int64      `json:"requests,omitempty"`
+	LastAccess *time.Time `json:"last_access,omitempty"`
+}
+
+// accessExporter periodically exports who has access to each upstream, for access reviews. The
+// exports are written as files named by the time they're made, either to a directory or to an
+// S3 bucket.
+type accessExporter struct {
+	format   string
+	interval time.Duration
+	usage    *usageTracker
+	put      func(name string, body []byte) error
+
+	mu      sync.Mutex
+	configs []*UpstreamConfig
+}
+
+// newAccessExporter returns the accessExporter of the upstream configs loaded when the proxy
+// starts, including the users observed by a usage tracker, which may be nil, or nil if access
+// isn't exported.
+func newAccessExporter(opts *Options, usage *usageTracker) (*accessExporter, error) {
+	if opts.AccessExportDestination == "" {
+		return nil, nil
+	}
+
+	e := &accessExporter{
+		format:   opts.AccessExportFormat,
+		interval: opts.AccessExportInterval,
+		usage:    usage,
+		configs:  opts.upstreamConfigs,
+	}
+	destination, err := url.Parse(opts.AccessExportDestination)
+	if err != nil {
+		return nil, err
+	}
+	if destination.Scheme == "s3" {
+		e.put, err = newS3AccessExportPut(destination)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		dir := opts.AccessExportDestination
+		e.put = func(name string, body []byte) error {
+			return writeAccessExportFile(filepath.Join(dir, name), body)
+		}
+	}
+	return e, nil
+}
+
+// newS3AccessExportPut returns the function putting exports in the bucket and under the prefix
+// of an s3://bucket/prefix?region=region URL.
+func newS3AccessExportPut(destination *url.URL) (func(string, []byte) error, error) {
+	region := destination.Query().Get("region")
+	if region == "" {
+		region = defaultS3Region
+	}
+	sess, err := awssession.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, err
+	}
+	client := s3.New(sess)
+	bucket := destination.Host
+	prefix := path.Clean("/" + destination.Path)[1:]
+
+	return func(name string, body []byte) error {
+		_, err := client.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(path.Join(prefix, name)),
+			Body:   bytes.NewReader(body),
+		})
+		return err
+	}, nil
+}
+
+// writeAccessExportFile writes an export to a file, replacing it whole, so that it's never left
+// half written.
+func writeAccessExportFile(name string, body []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), ".access-export")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// loaded replaces the upstream configs access is exported from, when they're reloaded.
+func (e *accessExporter) loaded(configs []*UpstreamConfig) {
+	e.mu.Lock()
+	e.configs = configs
+	e.mu.Unlock()
+}
+
+// grants returns the principals with access to each upstream, ordered by upstream. The
+// extra routes of a service have the same access as its default route, so each service is
+// listed once per cluster.
+func (e *accessExporter) grants(now time.Time) []accessGrant {
+	e.mu.Lock()
+	configs := e.configs
+	e.mu.Unlock()
+
+	grants := []accessGrant{}
+	listed := make(map[string]bool)
+	for _, config := range configs {
+		key := config.Cluster + "/" + config.Service
+		if listed[key] {
+			continue
+		}
+		listed[key] = true
+
+		entry := compileRouteEntry(config, nil, nil, false)
+		grant := func(kind string, principals []string) {
+			for _, principal := range principals {
+				grants = append(grants, accessGrant{
+					Service:   config.Service,
+					Cluster:   config.Cluster,
+					From:      entry.From,
+					Type:      kind,
+					Principal: principal,
+				})
+			}
+		}
+		grant(principalGroup, entry.Policy.AllowedGroups)
+		grant(principalEmailDomain, entry.Policy.AllowedEmailDomains)
+		grant(principalEmailAddress, entry.Policy.AllowedEmailAddresses)
+
+		if e.usage == nil {
+			continue
+		}
+		for _, record := range e.usage.report(config.Service, "", now) {
+			lastAccess := record.LastAccess
+			grants = append(grants, accessGrant{
+				Service:    config.Service,
+				Cluster:    config.Cluster,
+				From:       entry.From,
+				Type:       principalUser,
+				Principal:  record.Email,
+				Requests:   record.Requests,
+				LastAccess: &lastAccess,
+			})
+		}
+	}
+	sort.SliceStable(grants, func(i, j int) bool {
+		if grants[i].Service != grants[j].Service {
+			return grants[i].Service < grants[j].Service
+		}
+		return grants[i].Cluster < grants[j].Cluster
+	})
+	return grants
+}
+
+// encode encodes the access granted at a given time in the export format.
+func (e *accessExporter) encode(grants []accessGrant, now time.Time) ([]byte, error) {
+	if e.format == accessExportJSON {
+		return json.Marshal(struct {
+			GeneratedAt time.Time     `json:"generated_at"`
+			Grants      []accessGrant `json:"grants"`
+		}{
+			GeneratedAt: now.UTC(),
+			Grants:      grants,
+		})
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write([]string{"service", "cluster", "from", "type", "principal", "requests", "last_access"})
+	for _, g := range grants {
+		requests, lastAccess := "", ""
+		if g.LastAccess != nil {
+			requests = strconv.FormatInt(g.Requests, 10)
+			lastAccess = g.LastAccess.UTC().Format(time.RFC3339)
+		}
+		w.Write([]string{g.Service, g.Cluster, g.From, g.Type, g.Principal, requests, lastAccess})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// export exports the access granted now.
+func (e *accessExporter) export(now time.Time) error {
+	body, err := e.encode(e.grants(now), now)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("sso-proxy-access-%s.%s", now.UTC().Format("20060102T150405Z"), e.format)
+	if err := e.put(name, body); err != nil {
+		return fmt.Errorf("error exporting access to %s: %s", name, err)
+	}
+	log.NewLogEntry().Info("exported access to upstreams to ", name)
+	return nil
+}
+
+// run exports access at the export interval, forever.
+func (e *accessExporter) run() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if err := e.export(now); err != nil {
+			log.NewLogEntry().Error(err, "error exporting access")
+		}
+	}
+}
+
+// validateAccessExportOptions validates the destination, format, and interval of access exports.
+func validateAccessExportOptions(o *Options, msgs []string) []string {
+	if o.AccessExportDestination == "" {
+		return msgs
+	}
+
+	destination, err := url.Parse(o.AccessExportDestination)
+	switch {
+	case err != nil:
+		msgs = append(msgs, fmt.Sprintf("invalid value for access-export-destination: %s", err))
+	case destination.Scheme == "s3":
+		if destination.Host == "" {
+			msgs = append(msgs, "invalid value for access-export-destination: missing s3 bucket")
+		}
+	case !filepath.IsAbs(o.AccessExportDestination):
+		msgs = append(msgs, "invalid value for access-export-destination: must be an absolute path or an s3:// url")
+	case o.HardenedMode && o.StateDir != "" && !withinDir(o.AccessExportDestination, o.StateDir):
+		msgs = append(msgs, "invalid value for access-export-destination: must be within state-dir in hardened mode")
+	}
+
+	if o.AccessExportFormat != accessExportCSV && o.AccessExportFormat != accessExportJSON {
+		msgs = append(msgs, fmt.Sprintf("invalid value for access-export-format: %q, must be csv or json", o.AccessExportFormat))
+	}
+	if o.AccessExportInterval <= 0 {
+		msgs = append(msgs, "invalid value for access-export-interval: must be positive")
+	}
+	return msgs
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func testAccessExportConfigs() []*UpstreamConfig {
+	wikiURL, _ := url.Parse("https://wiki.sso.dev")
+	wikiAPIURL, _ := url.Parse("https://wiki-api.sso.dev")
+	reportsURL, _ := url.Parse("https://reports.sso.dev")
+	return []*UpstreamConfig{
+		{
+			Service:             "wiki",
+			Cluster:             "sso",
+			Route:               &SimpleRoute{FromURL: wikiURL},
+			AllowedGroups:       []string{"writers", "readers"},
+			AllowedEmailDomains: []string{"example.com"},
+		},
+		{
+			// extra routes have the access of their service's default route
+			Service:       "wiki",
+			Cluster:       "sso",
+			Route:         &SimpleRoute{FromURL: wikiAPIURL},
+			AllowedGroups: []string{"writers", "readers"},
+		},
+		{
+			Service:               "reports",
+			Cluster:               "sso",
+			Route:                 &SimpleRoute{FromURL: reportsURL},
+			AllowedEmailAddresses: []string{"cfo@example.com"},
+		},
+	}
+}
+
+func TestAccessExporterGrants(t *testing.T) {
+	usage := &usageTracker{records: make(map[usageKey]*usageRecord)}
+	lastAccess := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	usage.record("jane@example.com", "wiki", lastAccess)
+	usage.record("jane@example.com", "wiki", lastAccess)
+
+	e := &accessExporter{format: accessExportCSV, usage: usage, configs: testAccessExportConfigs()}
+	grants := e.grants(lastAccess)
+	testutil.Equal(t, []accessGrant{
+		{Service: "reports", Cluster: "sso", From: "https://reports.sso.dev", Type: principalEmailAddress, Principal: "cfo@example.com"},
+		{Service: "wiki", Cluster: "sso", From: "https://wiki.sso.dev", Type: principalGroup, Principal: "writers"},
+		{Service: "wiki", Cluster: "sso", From: "https://wiki.sso.dev", Type: principalGroup, Principal: "readers"},
+		{Service: "wiki", Cluster: "sso", From: "https://wiki.sso.dev", Type: principalEmailDomain, Principal: "example.com"},
+		{Service: "wiki", Cluster: "sso", From: "https://wiki.sso.dev", Type: principalUser, Principal: "jane@example.com", Requests: 2, LastAccess: &lastAccess},
+	}, grants)
+
+	body, err := e.encode(grants, lastAccess)
+	testutil.Ok(t, err)
+	testutil.Equal(t, `service,cluster,from,type,principal,requests,last_access
+reports,sso,https://reports.sso.dev,email_address,cfo@example.com,,
+wiki,sso,https://wiki.sso.dev,group,writers,,
+wiki,sso,https://wiki.sso.dev,group,readers,,
+wiki,sso,https://wiki.sso.dev,email_domain,example.com,,
+wiki,sso,https://wiki.sso.dev,user,jane@example.com,2,2019-06-01T12:00:00Z
+`, string(body))
+
+	e.format = accessExportJSON
+	body, err = e.encode(grants[:1], lastAccess)
+	testutil.Ok(t, err)
+	testutil.Equal(t, `{"generated_at":"2019-06-01T12:00:00Z","grants":[{"service":"reports","cluster":"sso","from":"https://reports.sso.dev","type":"email_address","principal":"cfo@example.com"}]}`, string(body))
+
+	// reloaded configs replace those exported
+	e.loaded(testAccessExportConfigs()[2:])
+	e.usage = nil
+	testutil.Equal(t, 1, len(e.grants(lastAccess)))
+}
+
+func TestAccessExporterExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "access-export")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := testOptions()
+	opts.upstreamConfigs = testAccessExportConfigs()
+	opts.AccessExportFormat = accessExportJSON
+
+	e, err := newAccessExporter(opts, nil)
+	testutil.Ok(t, err)
+	testutil.Assert(t, e == nil, "expected no access exporter without a destination")
+
+	opts.AccessExportDestination = dir
+	e, err = newAccessExporter(opts, nil)
+	testutil.Ok(t, err)
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	testutil.Ok(t, e.export(now))
+
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "sso-proxy-access-20190601T120000Z.json"))
+	testutil.Ok(t, err)
+	exported := struct {
+		Grants []accessGrant `json:"grants"`
+	}{}
+	testutil.Ok(t, json.Unmarshal(raw, &exported))
+	testutil.Equal(t, 4, len(exported.Grants))
+
+	e.put = func(string, []byte) error { return os.ErrPermission }
+	testutil.NotEqual(t, nil, e.export(now))
+}
+
+func TestValidateAccessExportOptions(t *testing.T) {
+	testCases := []struct {
+		name        string
+		destination string
+		format      string
+		interval    time.Duration
+		hardened    bool
+		expected    []string
+	}{
+		{"disabled", "", "", 0, false, nil},
+		{"directory", "/var/lib/sso-proxy/access", "csv", time.Hour, false, nil},
+		{"s3", "s3://reviews/sso-proxy?region=eu-west-1", "json", time.Hour, false, nil},
+		{"s3 without bucket", "s3:///sso-proxy", "csv", time.Hour, false,
+			[]string{"invalid value for access-export-destination: missing s3 bucket"}},
+		{"relative directory", "access", "csv", time.Hour, false,
+			[]string{"invalid value for access-export-destination: must be an absolute path or an s3:// url"}},
+		{"hardened outside state dir", "/var/lib/access", "csv", time.Hour, true,
+			[]string{"invalid value for access-export-destination: must be within state-dir in hardened mode"}},
+		{"hardened within state dir", "/var/lib/sso-proxy/access", "csv", time.Hour, true, nil},
+		{"invalid format", "/var/lib/sso-proxy/access", "xlsx", time.Hour, false,
+			[]string{`invalid value for access-export-format: "xlsx", must be csv or json`}},
+		{"invalid interval", "/var/lib/sso-proxy/access", "csv", 0, false,
+			[]string{"invalid value for access-export-interval: must be positive"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			o.AccessExportDestination = tc.destination
+			o.AccessExportFormat = tc.format
+			o.AccessExportInterval = tc.interval
+			o.HardenedMode = tc.hardened
+			o.StateDir = "/var/lib/sso-proxy"
+			testutil.Equal(t, tc.expected, validateAccessExportOptions(o, nil))
+		})
+	}
+}
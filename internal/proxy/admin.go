@@ -0,0 +1,510 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/spiffe"
+)
+
+const (
+	adminTokensPath    = "/admin/tokens"
+	adminTracePath     = "/admin/trace"
+	adminHealthPath    = "/admin/health"
+	adminHARPath       = "/admin/har"
+	adminRoutesPath    = "/admin/routes"
+	adminJWKSPath      = "/admin/jwks"
+	adminSubjectsPath  = "/admin/subjects"
+	adminInflightPath  = "/admin/inflight"
+	adminUsagePath     = "/admin/usage"
+	adminBlueGreenPath = "/admin/blue_green"
+	adminDrainPath     = "/admin/drain"
+)
+
+// adminConfig are the parts of the proxy served by the admin API, any of which may be unset.
+type adminConfig struct {
+	token        string
+	spiffeIDs    []string
+	deviceTokens *deviceTokenStore
+	events       *eventNotifier
+	anomalies    *anomalyMonitor
+	tracer       http.Handler
+	health       []*healthChecker
+	har          *harRecorder
+	routes       []*routeEntry
+	signer       *RequestSigner
+	inflight     *inflightRequests
+	usage        *usageTracker
+	blueGreen    *blueGreenSwitch
+	drain        *drainStatus
+}
+
+// adminHandler serves the admin API, which is meant to be exposed only to operators on a
+// separate port. Every request must carry the configured admin token in an
+// `Authorization: Bearer` header, or be sent by a caller whose SPIFFE ID is authorized, except
+// those for the public keys of the request signer, which upstreams fetch to verify signatures.
+type adminHandler struct {
+	adminConfig
+	authorizedIDs map[string]bool
+	mux           *http.ServeMux
+}
+
+// adminAPI returns true if the admin API is served, which its callers are authenticated to by
+// the admin token or their SVIDs.
+func (o *Options) adminAPI() bool {
+	return o.AdminToken != "" || len(o.AdminSPIFFEIDs) != 0
+}
+
+// newAdminHandler returns the http.Handler serving the admin API. The tracer routes traced
+// requests to the upstream that would serve them, see newTraceRequest.
+func newAdminHandler(config adminConfig) http.Handler {
+	h := &adminHandler{
+		adminConfig:   config,
+		authorizedIDs: map[string]bool{},
+		mux:           http.NewServeMux(),
+	}
+	for _, id := range config.spiffeIDs {
+		h.authorizedIDs[id] = true
+	}
+	h.mux.HandleFunc(adminTokensPath, h.Tokens)
+	h.mux.HandleFunc(adminTokensPath+"/", h.Token)
+	h.mux.HandleFunc(adminTracePath, h.Trace)
+	h.mux.HandleFunc(adminHealthPath, h.Health)
+	h.mux.HandleFunc(adminHARPath, h.HARCaptures)
+	h.mux.HandleFunc(adminHARPath+"/", h.HARCapture)
+	h.mux.HandleFunc(adminRoutesPath, h.Routes)
+	h.mux.HandleFunc(adminJWKSPath, h.JWKS)
+	h.mux.HandleFunc(adminSubjectsPath, h.Subjects)
+	h.mux.HandleFunc(adminInflightPath, h.Inflight)
+	h.mux.HandleFunc(adminUsagePath, h.Usage)
+	h.mux.HandleFunc(adminBlueGreenPath, h.BlueGreen)
+	h.mux.HandleFunc(adminDrainPath, h.Drain)
+	return h
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *adminHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != adminJWKSPath && !h.authorized(req) {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		writeOAuthError(rw, http.StatusUnauthorized, "invalid_token")
+		return
+	}
+	h.mux.ServeHTTP(rw, req)
+}
+
+func (h *adminHandler) authorized(req *http.Request) bool {
+	// the SVIDs of callers are verified against the trust bundle by the TLS config of the admin
+	// API, see newAdminTLSConfig
+	if req.TLS != nil && len(req.TLS.PeerCertificates) != 0 {
+		id, err := spiffe.IDFromCertificate(req.TLS.PeerCertificates[0])
+		if err == nil && h.authorizedIDs[id] {
+			return true
+		}
+	}
+
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if h.token == "" || len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(parts[1])), []byte(h.token)) == 1
+}
+
+// Tokens lists the unexpired bearer tokens issued through the device flow with a GET
+// request, or revokes every token of the user given by the `email` query parameter
+// with a DELETE request.
+func (h *adminHandler) Tokens(rw http.ResponseWriter, req *http.Request) {
+	logger := log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req))
+
+	if h.deviceTokens == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	email := req.URL.Query().Get("email")
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(rw, http.StatusOK, struct {
+			Tokens []*bearerToken `json:"tokens"`
+		}{
+			Tokens: h.deviceTokens.list(email),
+		})
+	case http.MethodDelete:
+		if email == "" {
+			http.Error(rw, "missing email of the user whose tokens to revoke", http.StatusBadRequest)
+			return
+		}
+		revoked := h.deviceTokens.revokeUser(email)
+		logger.WithUser(email).Info("admin api: revoked bearer tokens of user")
+		if revoked != 0 {
+			h.events.notify(lifecycleEvent{
+				Event:  eventSessionRevoked,
+				Email:  email,
+				Reason: "bearer tokens revoked by an administrator",
+			})
+		}
+		writeJSON(rw, http.StatusOK, struct {
+			Revoked int `json:"revoked"`
+		}{
+			Revoked: revoked,
+		})
+	default:
+		rw.Header().Set("Allow", "GET, DELETE")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// Token revokes a single bearer token by its id with a DELETE request.
+func (h *adminHandler) Token(rw http.ResponseWriter, req *http.Request) {
+	logger := log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req))
+
+	if h.deviceTokens == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodDelete {
+		rw.Header().Set("Allow", "DELETE")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(req.URL.Path, adminTokensPath+"/")
+	token, ok := h.deviceTokens.revoke(id)
+	if !ok {
+		http.NotFound(rw, req)
+		return
+	}
+
+	logger.WithUser(token.Email).Info("admin api: revoked bearer token " + id)
+	h.events.notify(lifecycleEvent{
+		Event:  eventSessionRevoked,
+		Email:  token.Email,
+		Host:   token.Host,
+		Reason: "bearer token revoked by an administrator",
+	})
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// Trace reports how a hypothetical request, given as JSON with a POST request, would be
+// routed and authorized, without sending it to the upstream.
+func (h *adminHandler) Trace(rw http.ResponseWriter, req *http.Request) {
+	if h.tracer == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	tr := &traceRequest{}
+	if err := json.NewDecoder(req.Body).Decode(tr); err != nil {
+		http.Error(rw, "invalid trace request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	traceReq, err := newTraceRequest(tr)
+	if err != nil {
+		http.Error(rw, "invalid trace request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.tracer.ServeHTTP(rw, traceReq)
+}
+
+// Health reports the status of every upstream with health checks with a GET request.
+func (h *adminHandler) Health(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	upstreams := make([]healthStatus, 0, len(h.health))
+	for _, checker := range h.health {
+		upstreams = append(upstreams, checker.Status())
+	}
+	writeJSON(rw, http.StatusOK, struct {
+		Upstreams []healthStatus `json:"upstreams"`
+	}{
+		Upstreams: upstreams,
+	})
+}
+
+// HARCaptures lists the captures of upstream requests with a GET request, or starts a capture
+// of the next requests of a service, given as JSON, with a POST request.
+func (h *adminHandler) HARCaptures(rw http.ResponseWriter, req *http.Request) {
+	logger := log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req))
+
+	if h.har == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(rw, http.StatusOK, struct {
+			Captures []harCapture `json:"captures"`
+		}{
+			Captures: h.har.list(),
+		})
+	case http.MethodPost:
+		start := struct {
+			Service string `json:"service"`
+			Count   int    `json:"count"`
+		}{}
+		if err := json.NewDecoder(req.Body).Decode(&start); err != nil {
+			http.Error(rw, "invalid capture request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if start.Count == 0 {
+			start.Count = harDefaultCount
+		}
+		if start.Count < 0 || start.Count > harMaxCount {
+			http.Error(rw, fmt.Sprintf("invalid capture request: count must be between 1 and %d", harMaxCount), http.StatusBadRequest)
+			return
+		}
+
+		capture, ok, err := h.har.start(start.Service, start.Count)
+		if err != nil {
+			logger.Error(err, "error starting capture")
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(rw, "invalid capture request: unknown service "+start.Service, http.StatusNotFound)
+			return
+		}
+		logger.Info(fmt.Sprintf("admin api: started capture %s of the next %d requests of upstream %s",
+			capture.ID, capture.Count, capture.Service))
+		writeJSON(rw, http.StatusCreated, capture)
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// HARCapture downloads a capture as a HAR file with a GET request, or stops and removes it
+// with a DELETE request.
+func (h *adminHandler) HARCapture(rw http.ResponseWriter, req *http.Request) {
+	if h.har == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	id := strings.TrimPrefix(req.URL.Path, adminHARPath+"/")
+	switch req.Method {
+	case http.MethodGet:
+		har, service, ok := h.har.har(id)
+		if !ok {
+			http.NotFound(rw, req)
+			return
+		}
+		rw.Header().Set("Content-Disposition", harFilename(service, id))
+		writeJSON(rw, http.StatusOK, har)
+	case http.MethodDelete:
+		if !h.har.remove(id) {
+			http.NotFound(rw, req)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		rw.Header().Set("Allow", "GET, DELETE")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// JWKS serves the public key of the request signer as a JSON Web Key Set with a GET request.
+// It doesn't require the admin token, since the key is public.
+func (h *adminHandler) JWKS(rw http.ResponseWriter, req *http.Request) {
+	if h.signer == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	jwks, err := h.signer.JWKS()
+	if err != nil {
+		log.NewLogEntry().Error(err, "error encoding request signing key as a JWKS")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, http.StatusOK, jwks)
+}
+
+// Subjects purges everything the proxy holds about the user given by the `email` query
+// parameter with a DELETE request: their bearer tokens, their requests recorded by HAR
+// captures, their usage of services, and the activity recorded by anomaly detectors.
+func (h *adminHandler) Subjects(rw http.ResponseWriter, req *http.Request) {
+	logger := log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req))
+
+	if req.Method != http.MethodDelete {
+		rw.Header().Set("Allow", "DELETE")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := req.URL.Query().Get("email")
+	if email == "" {
+		http.Error(rw, "missing email of the user whose data to purge", http.StatusBadRequest)
+		return
+	}
+
+	purged := struct {
+		BearerTokens int `json:"bearer_tokens"`
+		HAREntries   int `json:"har_entries"`
+		UsageRecords int `json:"usage_records"`
+	}{}
+	if h.deviceTokens != nil {
+		purged.BearerTokens = h.deviceTokens.purgeUser(email)
+	}
+	if h.har != nil {
+		purged.HAREntries = h.har.purgeUser(email)
+	}
+	if h.usage != nil {
+		purged.UsageRecords = h.usage.purgeUser(email)
+	}
+	if h.anomalies != nil {
+		h.anomalies.forget(email)
+	}
+	logger.WithUser(email).Info("admin api: purged data of user")
+
+	if purged.BearerTokens != 0 {
+		h.events.notify(lifecycleEvent{
+			Event:  eventSessionRevoked,
+			Email:  email,
+			Reason: "data purged by an administrator",
+		})
+	}
+	writeJSON(rw, http.StatusOK, purged)
+}
+
+// Inflight lists the requests being served by upstreams with a GET request, oldest first, or
+// only those in flight for at least the number of seconds given by the `older_than` query
+// parameter.
+func (h *adminHandler) Inflight(rw http.ResponseWriter, req *http.Request) {
+	if h.inflight == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var minAge time.Duration
+	if olderThan := req.URL.Query().Get("older_than"); olderThan != "" {
+		seconds, err := strconv.ParseFloat(olderThan, 64)
+		if err != nil || seconds < 0 {
+			http.Error(rw, "invalid older_than, must be a number of seconds", http.StatusBadRequest)
+			return
+		}
+		minAge = time.Duration(seconds * float64(time.Second))
+	}
+
+	writeJSON(rw, http.StatusOK, struct {
+		Requests []inflightRequest `json:"requests"`
+	}{
+		Requests: h.inflight.list(minAge, time.Now()),
+	})
+}
+
+// Drain reports the number of requests in flight on the proxy's server with a GET request, and
+// once it's shutting down, the deadline they have to finish by and whether they have.
+func (h *adminHandler) Drain(rw http.ResponseWriter, req *http.Request) {
+	if h.drain == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, h.drain.state())
+}
+
+// Usage reports the number of requests of each user to each service, and when they first and
+// last accessed it, with a GET request, optionally only of the service or user given by the
+// `service` and `email` query parameters.
+func (h *adminHandler) Usage(rw http.ResponseWriter, req *http.Request) {
+	if h.usage == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	writeJSON(rw, http.StatusOK, struct {
+		Usage []usageRecord `json:"usage"`
+	}{
+		Usage: h.usage.report(query.Get("service"), query.Get("email"), time.Now()),
+	})
+}
+
+// BlueGreen lists the upstreams with blue and green backends, and which are active, with a GET
+// request, or switches the requests of the upstream of a service, given as JSON with its active
+// backends, with a POST request.
+func (h *adminHandler) BlueGreen(rw http.ResponseWriter, req *http.Request) {
+	logger := log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req))
+
+	if h.blueGreen == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(rw, http.StatusOK, struct {
+			Upstreams []blueGreenUpstream `json:"upstreams"`
+		}{
+			Upstreams: h.blueGreen.list(),
+		})
+	case http.MethodPost:
+		flip := struct {
+			Service string `json:"service"`
+			Active  string `json:"active"`
+		}{}
+		if err := json.NewDecoder(req.Body).Decode(&flip); err != nil {
+			http.Error(rw, "invalid switch request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if flip.Active != blue && flip.Active != green {
+			http.Error(rw, "invalid switch request: active must be blue or green", http.StatusBadRequest)
+			return
+		}
+
+		upstream, ok := h.blueGreen.set(flip.Service, flip.Active)
+		if !ok {
+			http.Error(rw, "invalid switch request: unknown service "+flip.Service, http.StatusNotFound)
+			return
+		}
+		logger.WithServiceName(upstream.Service).Info(
+			fmt.Sprintf("admin api: switched upstream %s to its %s backends", upstream.Service, upstream.Active))
+		writeJSON(rw, http.StatusOK, upstream)
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
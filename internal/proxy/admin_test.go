@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/pkg/signature"
+)
+
+func testIssueBearerToken(t *testing.T, store *deviceTokenStore, email string) (string, *bearerToken) {
+	auth, err := store.start("localhost")
+	testutil.Assert(t, err == nil, "unexpected error starting device authorization: %s", err)
+	err = store.approve(auth.UserCode, "localhost", &bearerToken{Email: email})
+	testutil.Assert(t, err == nil, "unexpected error approving device authorization: %s", err)
+	rawToken, token, _ := store.poll(auth.DeviceCode, "localhost")
+	return rawToken, token
+}
+
+func TestAdminHandlerRequiresToken(t *testing.T) {
+	handler := newAdminHandler(adminConfig{token: "admin-token", deviceTokens: newDeviceTokenStore(time.Minute, time.Hour, 0)})
+
+	for _, authorization := range []string{"", "Bearer wrong-token", "Basic admin-token"} {
+		req := httptest.NewRequest("GET", "http://localhost/admin/tokens", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		testutil.Equal(t, http.StatusUnauthorized, rw.Code)
+	}
+}
+
+func TestAdminHandlerTokens(t *testing.T) {
+	store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+	handler := newAdminHandler(adminConfig{token: "admin-token", deviceTokens: store})
+
+	rawToken, token := testIssueBearerToken(t, store, "user@example.com")
+	testIssueBearerToken(t, store, "other@example.com")
+	testIssueBearerToken(t, store, "other@example.com")
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := serve("GET", "http://localhost/admin/tokens?email=user@example.com")
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	listing := struct {
+		Tokens []*bearerToken `json:"tokens"`
+	}{}
+	err := json.Unmarshal(rw.Body.Bytes(), &listing)
+	testutil.Assert(t, err == nil, "could not decode token listing: %s", err)
+	testutil.Equal(t, 1, len(listing.Tokens))
+	testutil.Equal(t, token.ID, listing.Tokens[0].ID)
+
+	rw = serve("DELETE", "http://localhost/admin/tokens/"+token.ID)
+	testutil.Equal(t, http.StatusNoContent, rw.Code)
+	_, ok := store.lookup(rawToken, "localhost")
+	testutil.Assert(t, !ok, "expected token to be revoked")
+
+	rw = serve("DELETE", "http://localhost/admin/tokens/"+token.ID)
+	testutil.Equal(t, http.StatusNotFound, rw.Code)
+
+	rw = serve("DELETE", "http://localhost/admin/tokens?email=other@example.com")
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, "{\"revoked\":2}\n", rw.Body.String())
+	testutil.Equal(t, 0, len(store.list("")))
+}
+
+func TestAdminJWKS(t *testing.T) {
+	privateKey, err := ioutil.ReadFile("testdata/private_key.pem")
+	testutil.Assert(t, err == nil, "error reading private key from testdata")
+	signer, err := NewRequestSigner(string(privateKey))
+	testutil.Assert(t, err == nil, "could not initialize request signer: %s", err)
+
+	// the key set is public, so it's served without the admin token
+	handler := newAdminHandler(adminConfig{token: "admin-token", signer: signer})
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "http://localhost/admin/jwks", nil))
+	testutil.Equal(t, http.StatusOK, rw.Code)
+
+	jwks := &signature.JWKS{}
+	err = json.Unmarshal(rw.Body.Bytes(), jwks)
+	testutil.Ok(t, err)
+	kid, _ := signer.PublicKey()
+	testutil.Equal(t, 1, len(jwks.Keys))
+	testutil.Equal(t, kid, jwks.Keys[0].KeyID)
+	testutil.Equal(t, "RS256", jwks.Keys[0].Algorithm)
+	testutil.Equal(t, "AQAB", jwks.Keys[0].E)
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("POST", "http://localhost/admin/jwks", nil))
+	testutil.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+
+	// without a request signer there's no key set to serve
+	handler = newAdminHandler(adminConfig{token: "admin-token"})
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "http://localhost/admin/jwks", nil))
+	testutil.Equal(t, http.StatusNotFound, rw.Code)
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	affinityIdentity = "identity"
+	affinityCookie   = "cookie"
+
+	// hashRingReplicas is the number of points each backend has on a hash ring, so that the keys
+	// of a backend that's removed are spread evenly across the others.
+	hashRingReplicas = 100
+)
+
+// validateAffinity validates the affinity options of an upstream with backends.
+func validateAffinity(affinity, cookie string) error {
+	switch affinity {
+	case "", affinityIdentity:
+		if cookie != "" {
+			return fmt.Errorf("affinity_cookie is only used with cookie affinity")
+		}
+	case affinityCookie:
+		if cookie == "" {
+			return fmt.Errorf("affinity_cookie is required with cookie affinity")
+		}
+	default:
+		return fmt.Errorf("unknown affinity %q, must be identity or cookie", affinity)
+	}
+	return nil
+}
+
+// hashRing consistently hashes keys to backends, so that only the keys of a backend that's
+// added or removed move to a different backend.
+type hashRing struct {
+	points   []uint32
+	backends map[uint32]*url.URL
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+func newHashRing(backends []*url.URL) *hashRing {
+	r := &hashRing{backends: make(map[uint32]*url.URL)}
+	for _, backend := range backends {
+		for i := 0; i < hashRingReplicas; i++ {
+			point := hashKey(strconv.Itoa(i) + "-" + backend.String())
+			// a point of two backends goes to the one that sorts first, so that it goes to the
+			// same backend whatever order they're listed in
+			if existing, ok := r.backends[point]; ok {
+				if existing.String() < backend.String() {
+					continue
+				}
+			} else {
+				r.points = append(r.points, point)
+			}
+			r.backends[point] = backend
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// get returns the backend of a key, the first whose point follows the key's hash on the ring.
+func (r *hashRing) get(key string) *url.URL {
+	hash := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.backends[r.points[i]]
+}
+
+// backendPicker picks the backend of each request to an upstream with backends: by its
+// affinity key, if it has one, or in turn.
+type backendPicker struct {
+	backends []*url.URL
+	ring     *hashRing
+	affinity string
+	cookie   string
+	next     uint32
+}
+
+func newBackendPicker(backends []*url.URL, affinity, cookie string) *backendPicker {
+	return &backendPicker{
+		backends: backends,
+		ring:     newHashRing(backends),
+		affinity: affinity,
+		cookie:   cookie,
+	}
+}
+
+// key returns the affinity key of a request: the email it was authenticated as, or the value
+// of the affinity cookie.
+func (p *backendPicker) key(req *http.Request) string {
+	switch p.affinity {
+	case affinityIdentity:
+		return req.Header.Get("X-Forwarded-Email")
+	case affinityCookie:
+		if cookie, err := req.Cookie(p.cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (p *backendPicker) pick(req *http.Request) *url.URL {
+	if key := p.key(req); key != "" {
+		return p.ring.get(key)
+	}
+	n := atomic.AddUint32(&p.next, 1)
+	return p.backends[int(n%uint32(len(p.backends)))]
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func testBackends(t *testing.T, n int) []*url.URL {
+	backends := []*url.URL{}
+	for i := 0; i < n; i++ {
+		backend, err := url.Parse(fmt.Sprintf("http://backend-%d.internal", i))
+		testutil.Ok(t, err)
+		backends = append(backends, backend)
+	}
+	return backends
+}
+
+func TestHashRing(t *testing.T) {
+	backends := testBackends(t, 4)
+	ring := newHashRing(backends)
+
+	// every backend gets a share of the keys, and keys keep their backends whatever order the
+	// backends are listed in
+	reversed := newHashRing([]*url.URL{backends[3], backends[2], backends[1], backends[0]})
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user%d@example.com", i)
+		counts[ring.get(key).Host]++
+		testutil.Equal(t, ring.get(key), reversed.get(key))
+	}
+	for _, backend := range backends {
+		testutil.Assert(t, counts[backend.Host] > 100, "expected %s to get a share of keys, got %v", backend.Host, counts)
+	}
+
+	// removing a backend only moves its own keys
+	removed := newHashRing(backends[:3])
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user%d@example.com", i)
+		if backend := ring.get(key); backend != backends[3] {
+			testutil.Equal(t, backend, removed.get(key))
+		}
+	}
+}
+
+func TestBackendPicker(t *testing.T) {
+	backends := testBackends(t, 3)
+
+	newRequest := func(email, cookie string) *http.Request {
+		req := httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+		if email != "" {
+			req.Header.Set("X-Forwarded-Email", email)
+		}
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "tenant", Value: cookie})
+		}
+		return req
+	}
+
+	t.Run("without affinity, requests take turns", func(t *testing.T) {
+		picker := newBackendPicker(backends, "", "")
+		picked := map[string]bool{}
+		for i := 0; i < 3; i++ {
+			picked[picker.pick(newRequest("user@example.com", "")).Host] = true
+		}
+		testutil.Equal(t, 3, len(picked))
+	})
+
+	t.Run("identity affinity", func(t *testing.T) {
+		picker := newBackendPicker(backends, affinityIdentity, "")
+		backend := picker.pick(newRequest("user@example.com", ""))
+		for i := 0; i < 10; i++ {
+			testutil.Equal(t, backend, picker.pick(newRequest("user@example.com", "")))
+		}
+		// requests without an identity take turns
+		picked := map[string]bool{}
+		for i := 0; i < 3; i++ {
+			picked[picker.pick(newRequest("", "")).Host] = true
+		}
+		testutil.Equal(t, 3, len(picked))
+	})
+
+	t.Run("cookie affinity", func(t *testing.T) {
+		picker := newBackendPicker(backends, affinityCookie, "tenant")
+		backend := picker.pick(newRequest("", "acme"))
+		for i := 0; i < 10; i++ {
+			testutil.Equal(t, backend, picker.pick(newRequest(fmt.Sprintf("user%d@example.com", i), "acme")))
+		}
+	})
+}
+
+func TestBackendsReverseProxy(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte(name))
+		}))
+	}
+	to, other := newBackend("to"), newBackend("other")
+	defer to.Close()
+	defer other.Close()
+
+	toURL, _ := url.Parse(to.URL)
+	otherURL, _ := url.Parse(other.URL)
+	config := &UpstreamConfig{
+		Service:            "foo",
+		Route:              &SimpleRoute{ToURL: toURL},
+		Backends:           []*url.URL{otherURL},
+		Affinity:           affinityIdentity,
+		SkipRequestSigning: true,
+	}
+	handler, err := NewUpstreamReverseProxy(config, nil)
+	testutil.Ok(t, err)
+
+	served := map[string]string{}
+	for i := 0; i < 20; i++ {
+		email := fmt.Sprintf("user%d@example.com", i%10)
+		req := httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+		req.Header.Set("X-Forwarded-Email", email)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		testutil.Equal(t, http.StatusOK, rw.Code)
+		if backend, ok := served[email]; ok {
+			testutil.Equal(t, backend, rw.Body.String())
+		}
+		served[email] = rw.Body.String()
+	}
+}
+
+func TestUpstreamConfigBackends(t *testing.T) {
+	upstreamConfigs, err := loadServiceConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.sso.dev
+    to: foo-1.sso.dev
+    options:
+      backends:
+        - foo-2.sso.dev
+        - https://foo-3.sso.dev
+      affinity: cookie
+      affinity_cookie: tenant
+`), "sso", "http", nil, nil)
+	testutil.Ok(t, err)
+	upstreamConfig := upstreamConfigs[0]
+	testutil.Equal(t, 2, len(upstreamConfig.Backends))
+	testutil.Equal(t, "http://foo-2.sso.dev", upstreamConfig.Backends[0].String())
+	testutil.Equal(t, "https://foo-3.sso.dev", upstreamConfig.Backends[1].String())
+	testutil.Equal(t, affinityCookie, upstreamConfig.Affinity)
+	testutil.Equal(t, "tenant", upstreamConfig.AffinityCookie)
+
+	testCases := map[string]string{
+		"affinity without backends": `
+      affinity: identity`,
+		"unknown affinity": `
+      backends: [foo-2.sso.dev]
+      affinity: ip`,
+		"cookie affinity without a cookie": `
+      backends: [foo-2.sso.dev]
+      affinity: cookie`,
+	}
+	for name, options := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadServiceConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.sso.dev
+    to: foo-1.sso.dev
+    options:`+options+`
+`), "sso", "http", nil, nil)
+			testutil.NotEqual(t, nil, err)
+			testutil.Equal(t, "invalid affinity", err.(*ErrParsingConfig).Message)
+		})
+	}
+}
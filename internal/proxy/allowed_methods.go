@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// methodToken matches HTTP method names, which are tokens, e.g. GET or PROPFIND.
+var methodToken = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Z-]+$")
+
+// parseAllowedMethods returns the allowed methods of an upstream in upper case, without
+// duplicates.
+func parseAllowedMethods(methods []string) ([]string, error) {
+	if len(methods) == 0 {
+		return nil, nil
+	}
+	parsed := make([]string, 0, len(methods))
+	seen := map[string]bool{}
+	for _, method := range methods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if !methodToken.MatchString(method) {
+			return nil, fmt.Errorf("%q is not an HTTP method", method)
+		}
+		if !seen[method] {
+			seen[method] = true
+			parsed = append(parsed, method)
+		}
+	}
+	return parsed, nil
+}
+
+// methodAllowed returns true if the request's method is allowed by the upstream. Every method is
+// allowed if the upstream doesn't list its allowed methods. Otherwise, the request is rejected
+// with a 405 error listing the allowed methods, before it's authenticated.
+func (p *OAuthProxy) methodAllowed(rw http.ResponseWriter, req *http.Request, tags []string) bool {
+	methods := p.upstreamConfig.AllowedMethods
+	if len(methods) == 0 {
+		return true
+	}
+	for _, method := range methods {
+		if req.Method == method {
+			return true
+		}
+	}
+
+	p.StatsdClient.Incr("method_not_allowed", tags, 1.0)
+	rw.Header().Set("Allow", strings.Join(methods, ", "))
+	p.ErrorPage(rw, req, http.StatusMethodNotAllowed, "Method Not Allowed", fmt.Sprintf("This service doesn't accept %s requests", req.Method))
+	return false
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestParseAllowedMethods(t *testing.T) {
+	testCases := []struct {
+		name     string
+		methods  []string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name: "no methods",
+		},
+		{
+			name:     "upper cased without duplicates",
+			methods:  []string{"get", " HEAD ", "Get"},
+			expected: []string{"GET", "HEAD"},
+		},
+		{
+			name:     "extension methods",
+			methods:  []string{"PROPFIND"},
+			expected: []string{"PROPFIND"},
+		},
+		{
+			name:    "empty method",
+			methods: []string{""},
+			wantErr: true,
+		},
+		{
+			name:    "several methods in one",
+			methods: []string{"GET,HEAD"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			methods, err := parseAllowedMethods(tc.methods)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equal(t, tc.expected, methods)
+		})
+	}
+}
+
+func TestAllowedMethods(t *testing.T) {
+	testCases := []struct {
+		name           string
+		allowedMethods []string
+		method         string
+		expectedCode   int
+		expectedAllow  string
+	}{
+		{
+			name:         "every method allowed by default",
+			method:       "DELETE",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:           "allowed method",
+			allowedMethods: []string{"GET", "HEAD"},
+			method:         "HEAD",
+			expectedCode:   http.StatusOK,
+		},
+		{
+			name:           "method not allowed",
+			allowedMethods: []string{"GET", "HEAD"},
+			method:         "POST",
+			expectedCode:   http.StatusMethodNotAllowed,
+			expectedAllow:  "GET, HEAD",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t)
+			defer close()
+			proxy.upstreamConfig.AllowedMethods = tc.allowedMethods
+			proxy.handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			})
+			// skip authentication, so that only the method is checked
+			proxy.upstreamConfig.SkipAuthCompiledRegex = []*regexp.Regexp{regexp.MustCompile("^/")}
+
+			rw := httptest.NewRecorder()
+			proxy.Proxy(rw, httptest.NewRequest(tc.method, "https://localhost/", nil))
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+			testutil.Equal(t, tc.expectedAllow, rw.Header().Get("Allow"))
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/anomaly"
+	"github.com/buzzfeed/sso/internal/pkg/geoip"
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/webhook"
+)
+
+// Anomaly detectors and actions, as configured by ANOMALY_DETECTORS and ANOMALY_ACTIONS.
+const (
+	anomalyImpossibleTravel   = "impossible_travel"
+	anomalyUserAgentChange    = "user_agent_change"
+	anomalyExcessiveForbidden = "excessive_forbidden"
+
+	anomalyActionLog            = "log"
+	anomalyActionWebhook        = "webhook"
+	anomalyActionReauthenticate = "reauthenticate"
+)
+
+// ErrReauthenticationRequired is returned when suspicious activity was detected on a session,
+// and the user must sign in again.
+var ErrReauthenticationRequired = errors.New("reauthentication required after suspicious activity")
+
+// anomalyMonitor passes the activity of authenticated users to the configured detectors,
+// and tracks the sessions that must reauthenticate because of what they flagged. It is
+// shared by every upstream, so that activity on one upstream is compared with the others.
+type anomalyMonitor struct {
+	detectors []anomaly.Detector
+
+	log            bool
+	webhook        *webhook.Sender
+	reauthenticate bool
+
+	mu sync.Mutex
+	// flagged holds the lifetime deadlines of sessions that must reauthenticate.
+	flagged map[string]time.Time
+}
+
+func newAnomalyMonitor(opts *Options) (*anomalyMonitor, error) {
+	m := &anomalyMonitor{
+		flagged: make(map[string]time.Time),
+	}
+	for _, detector := range opts.AnomalyDetectors {
+		switch detector {
+		case anomalyImpossibleTravel:
+			m.detectors = append(m.detectors, anomaly.NewImpossibleTravelDetector(opts.AnomalyMaxTravelSpeed))
+		case anomalyUserAgentChange:
+			m.detectors = append(m.detectors, anomaly.NewUserAgentChangeDetector())
+		case anomalyExcessiveForbidden:
+			m.detectors = append(m.detectors,
+				anomaly.NewExcessiveForbiddenDetector(opts.AnomalyMaxForbidden, opts.AnomalyForbiddenWindow))
+		}
+	}
+	for _, action := range opts.AnomalyActions {
+		switch action {
+		case anomalyActionLog:
+			m.log = true
+		case anomalyActionWebhook:
+			sender, err := webhook.NewSender(opts.AnomalyWebhookURL, opts.WebhookSecret, func(s *webhook.Sender) error {
+				s.MaxAttempts = opts.WebhookMaxAttempts
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			m.webhook = sender
+		case anomalyActionReauthenticate:
+			m.reauthenticate = true
+		}
+	}
+	return m, nil
+}
+
+// SetAnomalyMonitor sets the monitor that detects suspicious activity, as a functional option
+func SetAnomalyMonitor(m *anomalyMonitor) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.anomalies = m
+		return nil
+	}
+}
+
+// observe passes an activity to each detector, returning the anomalies they flag. Sessions
+// with anomalies are flagged for reauthentication, if that action is configured.
+func (m *anomalyMonitor) observe(activity anomaly.Activity, lifetimeDeadline time.Time) []*anomaly.Anomaly {
+	anomalies := []*anomaly.Anomaly{}
+	for _, detector := range m.detectors {
+		if a := detector.Observe(activity); a != nil {
+			anomalies = append(anomalies, a)
+		}
+	}
+
+	if len(anomalies) != 0 && m.reauthenticate && activity.Session != "" {
+		m.mu.Lock()
+		for session, deadline := range m.flagged {
+			if activity.Time.After(deadline) {
+				delete(m.flagged, session)
+			}
+		}
+		m.flagged[activity.Session] = lifetimeDeadline
+		m.mu.Unlock()
+	}
+	return anomalies
+}
+
+// reauthenticationRequired returns true, once, for each session flagged for reauthentication.
+func (m *anomalyMonitor) reauthenticationRequired(session *sessions.SessionState) bool {
+	key := anomalySessionKey(session)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.flagged[key]
+	delete(m.flagged, key)
+	return ok
+}
+
+// forget discards everything the detectors have recorded about a user. Flagged sessions are
+// kept, as they are only known by their keys, and must still reauthenticate.
+func (m *anomalyMonitor) forget(email string) {
+	for _, detector := range m.detectors {
+		detector.Forget(email)
+	}
+}
+
+// anomalyWebhookPayload is posted to ANOMALY_WEBHOOK_URL for each anomaly.
+type anomalyWebhookPayload struct {
+	Detector      string    `json:"detector"`
+	Reason        string    `json:"reason"`
+	Email         string    `json:"email"`
+	Host          string    `json:"host"`
+	RemoteAddress string    `json:"remote_address"`
+	UserAgent     string    `json:"user_agent"`
+	Country       string    `json:"country,omitempty"`
+	ASN           uint64    `json:"asn,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// notify queues an anomaly for delivery to the webhook.
+func (m *anomalyMonitor) notify(a *anomaly.Anomaly) error {
+	return m.webhook.Send("anomaly", anomalyWebhookPayload{
+		Detector:      a.Detector,
+		Reason:        a.Reason,
+		Email:         a.Activity.Email,
+		Host:          a.Activity.Host,
+		RemoteAddress: a.Activity.RemoteAddr,
+		UserAgent:     a.Activity.UserAgent,
+		Country:       a.Activity.Location.Country,
+		ASN:           a.Activity.Location.ASN,
+		Time:          a.Activity.Time,
+	})
+}
+
+// observeActivity reports a request of an authenticated session to the anomaly monitor, if
+// there is one, and takes the configured actions on any suspicious activity it detects.
+func (p *OAuthProxy) observeActivity(req *http.Request, session *sessions.SessionState, statusCode int) {
+	if p.anomalies == nil || session == nil {
+		return
+	}
+
+	location, _ := req.Context().Value(geoLocationKey{}).(geoip.Location)
+	activity := anomaly.Activity{
+		Email:      session.Email,
+		Session:    anomalySessionKey(session),
+		Host:       req.Host,
+		RemoteAddr: getRemoteAddr(req),
+		UserAgent:  req.UserAgent(),
+		Location:   location,
+		StatusCode: statusCode,
+		Time:       time.Now(),
+	}
+
+	for _, a := range p.anomalies.observe(activity, session.LifetimeDeadline) {
+		p.StatsdClient.Incr("anomaly_detected", []string{"action:anomaly", "detector:" + a.Detector}, 1.0)
+
+		if p.anomalies.log {
+			requestLogEntry(req).WithUser(session.Email).WithRequestHost(req.Host).WithUserAgent(
+				activity.UserAgent).WithAction("anomaly").Info(
+				"suspicious activity detected by " + a.Detector + ": " + a.Reason)
+		}
+		if p.anomalies.webhook != nil {
+			if err := p.anomalies.notify(a); err != nil {
+				log.NewLogEntry().WithUser(a.Activity.Email).Error(err, "error notifying anomaly webhook")
+			}
+		}
+	}
+}
+
+// anomalySessionKey identifies a session without revealing its tokens.
+func anomalySessionKey(session *sessions.SessionState) string {
+	sum := sha256.Sum256([]byte(session.Email + "\x00" + session.IssuedAt.UTC().Format(time.RFC3339Nano)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// responseStatus returns the status written to rw, if it records it, and zero otherwise.
+func responseStatus(rw http.ResponseWriter) int {
+	if l, ok := rw.(interface{ Status() int }); ok {
+		return l.Status()
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/anomaly"
+	"github.com/buzzfeed/sso/internal/pkg/geoip"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestAnomalyReauthentication(t *testing.T) {
+	opts := NewOptions()
+	opts.AnomalyDetectors = []string{anomalyUserAgentChange}
+	opts.AnomalyActions = []string{anomalyActionLog, anomalyActionReauthenticate}
+
+	anomalies, err := newAnomalyMonitor(opts)
+	testutil.Assert(t, err == nil, "unexpected error creating monitor: %s", err)
+
+	proxy, close := testNewOAuthProxy(t,
+		setSessionStore(&sessions.MockSessionStore{Session: testSession()}),
+		SetAnomalyMonitor(anomalies),
+	)
+	defer close()
+
+	authorize := func(userAgent string) int {
+		req := httptest.NewRequest("GET", "https://localhost/", nil)
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("X-Requested-With", "XMLHttpRequest")
+		rw := httptest.NewRecorder()
+		proxy.Authorize(rw, req)
+		return rw.Code
+	}
+
+	testutil.Equal(t, http.StatusOK, authorize("Firefox"))
+	// the changed user agent is flagged, and the session must reauthenticate on its next request
+	testutil.Equal(t, http.StatusOK, authorize("curl"))
+	testutil.Equal(t, http.StatusUnauthorized, authorize("curl"))
+	testutil.Equal(t, http.StatusOK, authorize("curl"))
+}
+
+func TestAnomalyMonitorWithoutReauthentication(t *testing.T) {
+	opts := NewOptions()
+	opts.AnomalyDetectors = []string{anomalyUserAgentChange}
+	opts.AnomalyActions = []string{anomalyActionLog}
+	m, err := newAnomalyMonitor(opts)
+	testutil.Assert(t, err == nil, "unexpected error creating monitor: %s", err)
+
+	session := testSession()
+	activity := anomaly.Activity{
+		Email:     session.Email,
+		Session:   anomalySessionKey(session),
+		UserAgent: "Firefox",
+		Time:      time.Now(),
+	}
+	testutil.Equal(t, 0, len(m.observe(activity, session.LifetimeDeadline)))
+
+	activity.UserAgent = "curl"
+	testutil.Equal(t, 1, len(m.observe(activity, session.LifetimeDeadline)))
+	testutil.Equal(t, false, m.reauthenticationRequired(session))
+}
+
+func TestAnomalyWebhook(t *testing.T) {
+	payloads := make(chan anomalyWebhookPayload, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		payload := anomalyWebhookPayload{}
+		err := json.NewDecoder(req.Body).Decode(&payload)
+		testutil.Assert(t, err == nil, "unexpected error decoding payload: %s", err)
+		payloads <- payload
+	}))
+	defer webhook.Close()
+
+	opts := NewOptions()
+	opts.AnomalyActions = []string{anomalyActionWebhook}
+	opts.AnomalyWebhookURL = webhook.URL
+	m, err := newAnomalyMonitor(opts)
+	testutil.Assert(t, err == nil, "unexpected error creating monitor: %s", err)
+
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	err = m.notify(&anomaly.Anomaly{
+		Detector: anomalyUserAgentChange,
+		Reason:   "user agent changed from Firefox",
+		Activity: anomaly.Activity{
+			Email:      "foo@example.com",
+			Host:       "foo.sso.dev",
+			RemoteAddr: "1.2.3.4",
+			UserAgent:  "curl",
+			Location:   geoip.Location{Country: "US", ASN: 64512},
+			Time:       now,
+		},
+	})
+	testutil.Assert(t, err == nil, "unexpected error notifying webhook: %s", err)
+	testutil.Equal(t, anomalyWebhookPayload{
+		Detector:      anomalyUserAgentChange,
+		Reason:        "user agent changed from Firefox",
+		Email:         "foo@example.com",
+		Host:          "foo.sso.dev",
+		RemoteAddress: "1.2.3.4",
+		UserAgent:     "curl",
+		Country:       "US",
+		ASN:           64512,
+		Time:          now,
+	}, <-payloads)
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	authResponseNegotiate = "negotiate"
+	authResponseRedirect  = "redirect"
+	authResponseJSON      = "json"
+)
+
+// AuthResponsesConfig maps to the auth_responses yaml config fields of an upstream, which
+// decide whether requests that aren't authenticated, or aren't authorized, are redirected to
+// sign in and shown error pages, as browsers want, or answered with JSON errors, as API clients
+// want. The first rule a request's path matches decides:
+// * json_paths - regexes of paths whose requests are always answered with JSON errors
+// * redirect_paths - regexes of paths whose requests are always redirected and shown error pages
+// * default - how requests matching neither are answered: negotiate (the default), redirect, or json
+//
+// Without auth_responses, only XHR requests are answered with JSON errors.
+type AuthResponsesConfig struct {
+	Default       string   `yaml:"default"`
+	JSONPaths     []string `yaml:"json_paths"`
+	RedirectPaths []string `yaml:"redirect_paths"`
+
+	jsonPaths     []*regexp.Regexp
+	redirectPaths []*regexp.Regexp
+}
+
+func (c *AuthResponsesConfig) validate() error {
+	switch c.Default {
+	case "":
+		c.Default = authResponseNegotiate
+	case authResponseNegotiate, authResponseRedirect, authResponseJSON:
+	default:
+		return fmt.Errorf("unknown default %q: must be negotiate, redirect, or json", c.Default)
+	}
+
+	var err error
+	if c.jsonPaths, err = compilePathRegexps(c.JSONPaths); err != nil {
+		return fmt.Errorf("invalid json_paths: %s", err)
+	}
+	if c.redirectPaths, err = compilePathRegexps(c.RedirectPaths); err != nil {
+		return fmt.Errorf("invalid redirect_paths: %s", err)
+	}
+	return nil
+}
+
+func compilePathRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// wantsJSON returns true if a request should be answered with a JSON error rather than a
+// redirect to sign in or an error page.
+func (p *OAuthProxy) wantsJSON(req *http.Request) bool {
+	config := p.upstreamConfig.AuthResponses
+	if config == nil {
+		return p.isXHR(req)
+	}
+
+	for _, re := range config.jsonPaths {
+		if re.MatchString(req.URL.Path) {
+			return true
+		}
+	}
+	for _, re := range config.redirectPaths {
+		if re.MatchString(req.URL.Path) {
+			return false
+		}
+	}
+
+	switch config.Default {
+	case authResponseJSON:
+		return true
+	case authResponseRedirect:
+		return false
+	default:
+		return p.isXHR(req) || prefersJSON(req.Header.Get("Accept"))
+	}
+}
+
+// prefersJSON returns true if an Accept header accepts JSON, and prefers it to HTML. Wildcards
+// are ignored, so that clients accepting anything, like most command line tools, are treated
+// like browsers.
+func prefersJSON(accept string) bool {
+	var jsonQ, htmlQ float64
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			if q > jsonQ {
+				jsonQ = q
+			}
+		case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestAuthResponsesConfigValidate(t *testing.T) {
+	config := &AuthResponsesConfig{JSONPaths: []string{"^/api/"}}
+	testutil.Ok(t, config.validate())
+	testutil.Equal(t, authResponseNegotiate, config.Default)
+
+	err := (&AuthResponsesConfig{Default: "xml"}).validate()
+	testutil.Equal(t, `unknown default "xml": must be negotiate, redirect, or json`, err.Error())
+	err = (&AuthResponsesConfig{RedirectPaths: []string{"("}}).validate()
+	testutil.Equal(t, "invalid redirect_paths: error parsing regexp: missing closing ): `(`", err.Error())
+
+	upstreamConfig := &UpstreamConfig{
+		RouteConfig: RouteConfig{
+			Options: &OptionsConfig{
+				AuthResponses: &AuthResponsesConfig{JSONPaths: []string{"["}},
+			},
+		},
+	}
+	err = parseOptionsConfig(upstreamConfig, nil)
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, "invalid auth_responses error=invalid json_paths: error parsing regexp: missing closing ]: `[`", err.Error())
+}
+
+func TestPrefersJSON(t *testing.T) {
+	testCases := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"application/problem+json", true},
+		{"application/json, text/plain, */*", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"text/html;q=0.5, application/json", true},
+		{"application/json;q=0.5, text/html", false},
+		{"application/json;q=0", false},
+	}
+	for _, tc := range testCases {
+		testutil.Equal(t, tc.want, prefersJSON(tc.accept))
+	}
+}
+
+func TestAuthResponses(t *testing.T) {
+	testCases := []struct {
+		name       string
+		config     *AuthResponsesConfig
+		path       string
+		header     map[string]string
+		wantStatus int
+	}{
+		{"browser without config", nil, "/", nil, http.StatusFound},
+		{"json accept without config", nil, "/", map[string]string{"Accept": "application/json"}, http.StatusFound},
+		{"xhr without config", nil, "/", map[string]string{"X-Requested-With": "XMLHttpRequest"}, http.StatusUnauthorized},
+		{"negotiated browser", &AuthResponsesConfig{}, "/", map[string]string{"Accept": "text/html"}, http.StatusFound},
+		{"negotiated json", &AuthResponsesConfig{}, "/", map[string]string{"Accept": "application/json"}, http.StatusUnauthorized},
+		{"json path", &AuthResponsesConfig{JSONPaths: []string{"^/api/"}}, "/api/users", nil, http.StatusUnauthorized},
+		{"redirect path", &AuthResponsesConfig{Default: authResponseJSON, RedirectPaths: []string{"^/login"}}, "/login", nil, http.StatusFound},
+		{"json default", &AuthResponsesConfig{Default: authResponseJSON}, "/", nil, http.StatusUnauthorized},
+		{"redirect default", &AuthResponsesConfig{Default: authResponseRedirect}, "/", map[string]string{"X-Requested-With": "XMLHttpRequest"}, http.StatusFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t, setSessionStore(&sessions.MockSessionStore{LoadError: http.ErrNoCookie}))
+			defer close()
+			if tc.config != nil {
+				testutil.Ok(t, tc.config.validate())
+				proxy.upstreamConfig.AuthResponses = tc.config
+			}
+
+			req := httptest.NewRequest("GET", "https://localhost"+tc.path, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			rw := httptest.NewRecorder()
+			proxy.Handler().ServeHTTP(rw, req)
+			testutil.Equal(t, tc.wantStatus, rw.Code)
+
+			if tc.wantStatus == http.StatusUnauthorized {
+				testutil.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+				body := struct {
+					Error  string `json:"error"`
+					Status int    `json:"status"`
+				}{}
+				testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &body))
+				testutil.Equal(t, "cannot continue oauth flow on xhr", body.Error)
+				testutil.Equal(t, http.StatusUnauthorized, body.Status)
+			}
+		})
+	}
+}
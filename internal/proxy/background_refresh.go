@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/proxy/providers"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// Actions taken in the background ahead of a session's deadlines.
+const (
+	backgroundRefresh  = "refresh"
+	backgroundValidate = "validate"
+)
+
+// backgroundRefresher refreshes and revalidates sessions shortly before their deadlines, off
+// the request path. The request that finds a session within the refresh window starts the call
+// to the provider and is served with the session as is; the result is applied to the session
+// on the user's next request, saving the provider round-trip that would otherwise block it.
+//
+// Results are held in memory, so when the next request is served by another instance, or
+// after the result was dropped, the session is refreshed synchronously at its deadline as usual.
+type backgroundRefresher struct {
+	// window is how long before a deadline the session is refreshed.
+	window time.Duration
+	// ttl is how long results are kept waiting for the user's next request.
+	ttl time.Duration
+
+	StatsdClient *statsd.Client
+
+	mu      sync.Mutex
+	results map[string]*backgroundResult
+}
+
+// backgroundResult is the outcome of a background call, along with the fields of the session
+// the provider updated.
+type backgroundResult struct {
+	done    bool
+	ok      bool
+	expires time.Time
+
+	accessToken      string
+	groups           []string
+	refreshDeadline  time.Time
+	validDeadline    time.Time
+	gracePeriodStart time.Time
+}
+
+// newBackgroundRefresher returns a backgroundRefresher whose window is the configured
+// percentage of the session valid TTL, or nil if background refresh is disabled.
+func newBackgroundRefresher(opts *Options) *backgroundRefresher {
+	if opts.BackgroundRefreshPercent <= 0 {
+		return nil
+	}
+	return &backgroundRefresher{
+		window:       time.Duration(float64(opts.SessionValidTTL) * opts.BackgroundRefreshPercent / 100),
+		ttl:          opts.SessionValidTTL,
+		StatsdClient: opts.StatsdClient,
+		results:      make(map[string]*backgroundResult),
+	}
+}
+
+// SetBackgroundRefresher sets the background refresher as a functional option
+func SetBackgroundRefresher(r *backgroundRefresher) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.backgroundRefresh = r
+		return nil
+	}
+}
+
+// backgroundKey identifies a background call for a session by the token it is made with, as
+// the same token is presented by every request until the result is applied.
+func backgroundKey(action string, provider providers.Provider, s *sessions.SessionState, allowedGroups []string) string {
+	token := s.AccessToken
+	if action == backgroundRefresh {
+		token = s.RefreshToken
+	}
+	groups := make([]string, len(allowedGroups))
+	copy(groups, allowedGroups)
+	sort.Strings(groups)
+	return strings.Join([]string{action, provider.Data().ProviderSlug, token, strings.Join(groups, ",")}, "/")
+}
+
+// apply applies the result of a completed background call to the session, returning true if
+// the session was updated and should be saved. Unsuccessful results are discarded, leaving the
+// session to be refreshed synchronously at its deadline, which decides whether it is revoked.
+func (r *backgroundRefresher) apply(provider providers.Provider, s *sessions.SessionState, allowedGroups []string) bool {
+	for _, action := range []string{backgroundRefresh, backgroundValidate} {
+		key := backgroundKey(action, provider, s, allowedGroups)
+
+		r.mu.Lock()
+		result, ok := r.results[key]
+		if ok && result.done {
+			delete(r.results, key)
+		}
+		r.mu.Unlock()
+
+		if !ok || !result.done || !result.ok {
+			continue
+		}
+
+		s.AccessToken = result.accessToken
+		s.Groups = result.groups
+		s.RefreshDeadline = result.refreshDeadline
+		s.ValidDeadline = result.validDeadline
+		s.GracePeriodStart = result.gracePeriodStart
+		r.StatsdClient.Incr("background_refresh", []string{"action:" + action, "result:applied"}, 1.0)
+		return true
+	}
+	return false
+}
+
+// due returns the action a session is due for in the background, if either of its deadlines
+// is within the window.
+func (r *backgroundRefresher) due(s *sessions.SessionState, now time.Time) string {
+	if s.RefreshDeadline.Sub(now) < r.window {
+		return backgroundRefresh
+	}
+	if s.ValidDeadline.Sub(now) < r.window {
+		return backgroundValidate
+	}
+	return ""
+}
+
+// refreshIfDue starts a background call for a session within the window of a deadline, unless
+// one was already started.
+func (r *backgroundRefresher) refreshIfDue(provider providers.Provider, s *sessions.SessionState, allowedGroups []string) {
+	now := time.Now()
+	action := r.due(s, now)
+	if action == "" {
+		return
+	}
+	key := backgroundKey(action, provider, s, allowedGroups)
+
+	r.mu.Lock()
+	if _, ok := r.results[key]; ok {
+		r.mu.Unlock()
+		return
+	}
+	for k, result := range r.results {
+		if now.After(result.expires) {
+			delete(r.results, k)
+		}
+	}
+	result := &backgroundResult{expires: now.Add(r.window + r.ttl)}
+	r.results[key] = result
+	r.mu.Unlock()
+
+	// the provider updates the session it is given, which is still in use by the request
+	session := *s
+	go func() {
+		var ok bool
+		switch action {
+		case backgroundRefresh:
+			refreshed, err := provider.RefreshSession(&session, allowedGroups)
+			ok = refreshed && err == nil
+		case backgroundValidate:
+			ok = provider.ValidateSessionState(&session, allowedGroups)
+		}
+
+		r.mu.Lock()
+		result.done = true
+		result.ok = ok
+		result.accessToken = session.AccessToken
+		result.groups = session.Groups
+		result.refreshDeadline = session.RefreshDeadline
+		result.validDeadline = session.ValidDeadline
+		result.gracePeriodStart = session.GracePeriodStart
+		r.mu.Unlock()
+
+		outcome := "result:succeeded"
+		if !ok {
+			outcome = "result:failed"
+		}
+		r.StatsdClient.Incr("background_refresh", []string{"action:" + action, outcome}, 1.0)
+	}()
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/proxy/providers"
+)
+
+func testBackgroundRefresher() *backgroundRefresher {
+	opts := NewOptions()
+	opts.SessionValidTTL = time.Minute
+	opts.BackgroundRefreshPercent = 50
+	return newBackgroundRefresher(opts)
+}
+
+// waitForBackground waits for every background call started by the refresher to complete.
+func waitForBackground(t *testing.T, r *backgroundRefresher) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		done := true
+		for _, result := range r.results {
+			done = done && result.done
+		}
+		r.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for background calls")
+}
+
+func TestBackgroundRefresherDue(t *testing.T) {
+	r := testBackgroundRefresher()
+	testutil.Equal(t, 30*time.Second, r.window)
+
+	now := time.Now()
+	testCases := []struct {
+		name            string
+		refreshDeadline time.Time
+		validDeadline   time.Time
+		expected        string
+	}{
+		{
+			name:            "deadlines outside the window",
+			refreshDeadline: now.Add(time.Hour),
+			validDeadline:   now.Add(time.Minute),
+		},
+		{
+			name:            "valid deadline within the window",
+			refreshDeadline: now.Add(time.Hour),
+			validDeadline:   now.Add(10 * time.Second),
+			expected:        backgroundValidate,
+		},
+		{
+			name:            "refresh deadline within the window",
+			refreshDeadline: now.Add(10 * time.Second),
+			validDeadline:   now.Add(10 * time.Second),
+			expected:        backgroundRefresh,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &sessions.SessionState{RefreshDeadline: tc.refreshDeadline, ValidDeadline: tc.validDeadline}
+			testutil.Equal(t, tc.expected, r.due(s, now))
+		})
+	}
+
+	testutil.Assert(t, newBackgroundRefresher(NewOptions()) == nil, "expected background refresh to be disabled by default")
+}
+
+func TestBackgroundRefresh(t *testing.T) {
+	now := time.Now()
+	session := testSession()
+	session.ValidDeadline = now.Add(10 * time.Second)
+	store := &sessions.MockSessionStore{Session: session}
+
+	validations := 0
+	extendedDeadline := now.Add(time.Minute).Truncate(time.Second)
+	providerURL, _ := url.Parse("http://localhost/")
+	tp := providers.NewTestProvider(providerURL, "")
+	tp.ValidateSessionFunc = func(s *sessions.SessionState, groups []string) bool {
+		validations++
+		s.ValidDeadline = extendedDeadline
+		return true
+	}
+
+	refresher := testBackgroundRefresher()
+	proxy, close := testNewOAuthProxy(t,
+		SetProvider(tp),
+		setSessionStore(store),
+		SetBackgroundRefresher(refresher),
+	)
+	defer close()
+
+	// the first request is served without waiting for the session to be validated
+	err := proxy.Authenticate(httptest.NewRecorder(), httptest.NewRequest("GET", "https://localhost", nil))
+	testutil.Ok(t, err)
+	testutil.Equal(t, "", store.ResponseSession)
+	waitForBackground(t, refresher)
+	testutil.Equal(t, 1, validations)
+
+	// the next request saves the validated session
+	err = proxy.Authenticate(httptest.NewRecorder(), httptest.NewRequest("GET", "https://localhost", nil))
+	testutil.Ok(t, err)
+	testutil.NotEqual(t, "", store.ResponseSession)
+	testutil.Equal(t, extendedDeadline, session.ValidDeadline)
+	testutil.Equal(t, 1, validations)
+	testutil.Equal(t, 0, len(refresher.results))
+}
+
+func TestBackgroundRefreshFailureDiscarded(t *testing.T) {
+	session := testSession()
+	session.ValidDeadline = time.Now().Add(10 * time.Second)
+	validDeadline := session.ValidDeadline
+	store := &sessions.MockSessionStore{Session: session}
+
+	providerURL, _ := url.Parse("http://localhost/")
+	tp := providers.NewTestProvider(providerURL, "")
+	tp.ValidateSessionFunc = func(s *sessions.SessionState, groups []string) bool {
+		s.ValidDeadline = time.Now().Add(time.Hour)
+		return false
+	}
+
+	refresher := testBackgroundRefresher()
+	proxy, close := testNewOAuthProxy(t,
+		SetProvider(tp),
+		setSessionStore(store),
+		SetBackgroundRefresher(refresher),
+	)
+	defer close()
+
+	testutil.Ok(t, proxy.Authenticate(httptest.NewRecorder(), httptest.NewRequest("GET", "https://localhost", nil)))
+	waitForBackground(t, refresher)
+
+	// the session is left to be validated synchronously at its deadline
+	testutil.Ok(t, proxy.Authenticate(httptest.NewRecorder(), httptest.NewRequest("GET", "https://localhost", nil)))
+	testutil.Equal(t, "", store.ResponseSession)
+	testutil.Equal(t, validDeadline, session.ValidDeadline)
+}
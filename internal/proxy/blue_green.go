@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	blue  = "blue"
+	green = "green"
+)
+
+// BlueGreenConfig maps to the blue_green yaml config fields of an upstream:
+// * blue - the urls of the blue backends, defaults to the route's to and backends
+// * green - the urls of the green backends
+// * active - the backends requests are sent to until the admin API switches them, blue (the default) or green
+// * early_access_groups - groups whose members' requests are always sent to the green backends, which must be allowed groups
+type BlueGreenConfig struct {
+	Blue              []string `yaml:"blue"`
+	Green             []string `yaml:"green"`
+	Active            string   `yaml:"active"`
+	EarlyAccessGroups []string `yaml:"early_access_groups"`
+
+	blue  []*url.URL
+	green []*url.URL
+}
+
+func (c *BlueGreenConfig) validate(config *UpstreamConfig) error {
+	route, ok := config.Route.(*SimpleRoute)
+	if !ok {
+		return fmt.Errorf("only simple routes can have blue and green backends")
+	}
+
+	switch c.Active {
+	case "":
+		c.Active = blue
+	case blue, green:
+	default:
+		return fmt.Errorf("active must be blue or green")
+	}
+
+	if len(c.Green) == 0 {
+		return fmt.Errorf("green is required")
+	}
+	var err error
+	c.green, err = parseBackends(route.ToURL.Scheme, c.Green)
+	if err != nil {
+		return err
+	}
+	c.blue = append([]*url.URL{route.ToURL}, config.Backends...)
+	if len(c.Blue) != 0 {
+		c.blue, err = parseBackends(route.ToURL.Scheme, c.Blue)
+		if err != nil {
+			return err
+		}
+	}
+
+	// only the allowed groups of users are checked, and sent in X-Forwarded-Groups
+	allowed := make(map[string]bool)
+	for _, group := range config.AllowedGroups {
+		allowed[group] = true
+	}
+	for _, group := range c.EarlyAccessGroups {
+		if !allowed[group] {
+			return fmt.Errorf("early access group %s must be one of allowed_groups", group)
+		}
+	}
+	return nil
+}
+
+// parseBackends parses the urls of backends, which have the given scheme if they have none.
+func parseBackends(scheme string, backends []string) ([]*url.URL, error) {
+	urls := make([]*url.URL, 0, len(backends))
+	for _, backend := range backends {
+		backendURL, err := urlParse(scheme, backend)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, backendURL)
+	}
+	return urls, nil
+}
+
+// blueGreenUpstream is the state of an upstream with blue and green backends, as listed by the
+// admin API.
+type blueGreenUpstream struct {
+	Service           string   `json:"service"`
+	Active            string   `json:"active"`
+	Blue              []string `json:"blue"`
+	Green             []string `json:"green"`
+	EarlyAccessGroups []string `json:"early_access_groups"`
+
+	// the active backends of the upstream's config, which override those switched to by the
+	// admin API when a config changes them
+	configured string
+}
+
+// blueGreenSwitch holds which of the backends of each upstream with blue and green backends are
+// active. It's kept when upstream configs are reloaded, so that switches by the admin API last
+// until the proxy restarts or the config itself switches the upstream.
+type blueGreenSwitch struct {
+	mu        sync.RWMutex
+	upstreams map[string]*blueGreenUpstream
+}
+
+func newBlueGreenSwitch() *blueGreenSwitch {
+	return &blueGreenSwitch{upstreams: make(map[string]*blueGreenUpstream)}
+}
+
+// update replaces the upstreams of the switch with those of a version of the upstream configs.
+func (s *blueGreenSwitch) update(configs []*UpstreamConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upstreams := make(map[string]*blueGreenUpstream)
+	for _, config := range configs {
+		if config.BlueGreen == nil {
+			continue
+		}
+		upstream := &blueGreenUpstream{
+			Service:           config.Service,
+			Active:            config.BlueGreen.Active,
+			Blue:              urlStrings(config.BlueGreen.blue),
+			Green:             urlStrings(config.BlueGreen.green),
+			EarlyAccessGroups: config.BlueGreen.EarlyAccessGroups,
+			configured:        config.BlueGreen.Active,
+		}
+		if previous, ok := s.upstreams[config.Service]; ok && previous.configured == upstream.configured {
+			upstream.Active = previous.Active
+		}
+		upstreams[config.Service] = upstream
+	}
+	s.upstreams = upstreams
+}
+
+func urlStrings(urls []*url.URL) []string {
+	strs := make([]string, 0, len(urls))
+	for _, u := range urls {
+		strs = append(strs, u.String())
+	}
+	return strs
+}
+
+// active returns the active backends of the upstream of a service, or those of its config if
+// the switch doesn't have it yet.
+func (s *blueGreenSwitch) active(service, configured string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if upstream, ok := s.upstreams[service]; ok {
+		return upstream.Active
+	}
+	return configured
+}
+
+// set switches the upstream of a service to its blue or green backends, returning false if it
+// has none.
+func (s *blueGreenSwitch) set(service, active string) (blueGreenUpstream, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upstream, ok := s.upstreams[service]
+	if !ok {
+		return blueGreenUpstream{}, false
+	}
+	upstream.Active = active
+	return *upstream, true
+}
+
+// list returns the upstreams with blue and green backends, ordered by service.
+func (s *blueGreenSwitch) list() []blueGreenUpstream {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	upstreams := make([]blueGreenUpstream, 0, len(s.upstreams))
+	for _, upstream := range s.upstreams {
+		upstreams = append(upstreams, *upstream)
+	}
+	sort.Slice(upstreams, func(i, j int) bool { return upstreams[i].Service < upstreams[j].Service })
+	return upstreams
+}
+
+// BlueGreenDirectorFunc directs requests to the active backends of a simple route with blue and
+// green backends, or to its green backends for members of its early access groups.
+func (d *Director) BlueGreenDirectorFunc(route *SimpleRoute) func(*http.Request) {
+	config := d.config.BlueGreen
+	pickers := map[string]*backendPicker{
+		blue:  newBackendPicker(config.blue, d.config.Affinity, d.config.AffinityCookie),
+		green: newBackendPicker(config.green, d.config.Affinity, d.config.AffinityCookie),
+	}
+	earlyAccess := make(map[string]bool)
+	for _, group := range config.EarlyAccessGroups {
+		earlyAccess[group] = true
+	}
+
+	return func(req *http.Request) {
+		active := config.Active
+		if d.config.blueGreenSwitch != nil {
+			active = d.config.blueGreenSwitch.active(d.config.Service, config.Active)
+		}
+		if active == blue && len(earlyAccess) != 0 {
+			for _, group := range strings.Split(req.Header.Get("X-Forwarded-Groups"), ",") {
+				if earlyAccess[group] {
+					active = green
+					break
+				}
+			}
+		}
+		d.DirectorFunc(pickers[active].pick(req))(req)
+	}
+}
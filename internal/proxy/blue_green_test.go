@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestUpstreamConfigBlueGreen(t *testing.T) {
+	upstreamConfigs, err := loadServiceConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.sso.dev
+    to: foo-blue.sso.dev
+    options:
+      allowed_groups: [engineers, beta]
+      blue_green:
+        green: [foo-green.sso.dev]
+        early_access_groups: [beta]
+`), "sso", "http", nil, nil)
+	testutil.Ok(t, err)
+	config := upstreamConfigs[0].BlueGreen
+	testutil.NotEqual(t, nil, config)
+	testutil.Equal(t, blue, config.Active)
+	testutil.Equal(t, []string{"http://foo-blue.sso.dev"}, urlStrings(config.blue))
+	testutil.Equal(t, []string{"http://foo-green.sso.dev"}, urlStrings(config.green))
+
+	testCases := map[string]string{
+		"without green": `
+      blue_green:
+        blue: [foo-blue.sso.dev]`,
+		"unknown active": `
+      blue_green:
+        green: [foo-green.sso.dev]
+        active: red`,
+		"early access group that isn't allowed": `
+      blue_green:
+        green: [foo-green.sso.dev]
+        early_access_groups: [beta]`,
+	}
+	for name, options := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadServiceConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.sso.dev
+    to: foo-blue.sso.dev
+    options:
+      allowed_groups: [engineers]`+options+`
+`), "sso", "http", nil, nil)
+			testutil.NotEqual(t, nil, err)
+			testutil.Equal(t, "invalid blue_green", err.(*ErrParsingConfig).Message)
+		})
+	}
+}
+
+func testBlueGreenConfig(t *testing.T, service, to string, green []string, active string) *UpstreamConfig {
+	toURL, err := url.Parse(to)
+	testutil.Ok(t, err)
+	config := &UpstreamConfig{
+		Service:            service,
+		Route:              &SimpleRoute{ToURL: toURL},
+		AllowedGroups:      []string{"beta"},
+		SkipRequestSigning: true,
+		BlueGreen: &BlueGreenConfig{
+			Green:             green,
+			Active:            active,
+			EarlyAccessGroups: []string{"beta"},
+		},
+	}
+	testutil.Ok(t, config.BlueGreen.validate(config))
+	return config
+}
+
+func TestBlueGreenSwitch(t *testing.T) {
+	s := newBlueGreenSwitch()
+	s.update([]*UpstreamConfig{
+		testBlueGreenConfig(t, "foo", "http://foo-blue.internal", []string{"http://foo-green.internal"}, ""),
+		testBlueGreenConfig(t, "bar", "http://bar-blue.internal", []string{"http://bar-green.internal"}, ""),
+	})
+	testutil.Equal(t, blue, s.active("foo", blue))
+
+	upstream, ok := s.set("foo", green)
+	testutil.Assert(t, ok, "expected foo to be switched")
+	testutil.Equal(t, green, upstream.Active)
+	testutil.Equal(t, green, s.active("foo", blue))
+	_, ok = s.set("baz", green)
+	testutil.Assert(t, !ok, "expected baz not to be switched")
+
+	// a switch lasts across reloads, until the config itself switches the upstream
+	s.update([]*UpstreamConfig{
+		testBlueGreenConfig(t, "foo", "http://foo-blue.internal", []string{"http://foo-green.internal"}, ""),
+		testBlueGreenConfig(t, "bar", "http://bar-blue.internal", []string{"http://bar-green.internal"}, green),
+	})
+	testutil.Equal(t, green, s.active("foo", blue))
+	testutil.Equal(t, green, s.active("bar", green))
+	s.set("bar", blue)
+
+	s.update([]*UpstreamConfig{
+		testBlueGreenConfig(t, "foo", "http://foo-blue.internal", []string{"http://foo-green.internal"}, green),
+	})
+	testutil.Equal(t, green, s.active("foo", green))
+	upstreams := s.list()
+	testutil.Equal(t, 1, len(upstreams))
+	testutil.Equal(t, "foo", upstreams[0].Service)
+}
+
+func TestBlueGreenReverseProxy(t *testing.T) {
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte(name))
+		}))
+	}
+	blueBackend, greenBackend := newBackend(blue), newBackend(green)
+	defer blueBackend.Close()
+	defer greenBackend.Close()
+
+	config := testBlueGreenConfig(t, "foo", blueBackend.URL, []string{greenBackend.URL}, "")
+	config.blueGreenSwitch = newBlueGreenSwitch()
+	config.blueGreenSwitch.update([]*UpstreamConfig{config})
+	handler, err := NewUpstreamReverseProxy(config, nil)
+	testutil.Ok(t, err)
+
+	serve := func(groups string) string {
+		req := httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+		req.Header.Set("X-Forwarded-Groups", groups)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		testutil.Equal(t, http.StatusOK, rw.Code)
+		return rw.Body.String()
+	}
+
+	testutil.Equal(t, blue, serve(""))
+	testutil.Equal(t, green, serve("engineers,beta"))
+
+	config.blueGreenSwitch.set("foo", green)
+	testutil.Equal(t, green, serve(""))
+}
+
+func TestAdminBlueGreen(t *testing.T) {
+	s := newBlueGreenSwitch()
+	s.update([]*UpstreamConfig{
+		testBlueGreenConfig(t, "foo", "http://foo-blue.internal", []string{"http://foo-green.internal"}, ""),
+	})
+	handler := newAdminHandler(adminConfig{token: "admin-token", blueGreen: s})
+
+	serve := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost/admin/blue_green", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := serve("POST", `{"service": "foo", "active": "green"}`)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+
+	rw = serve("GET", "")
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	listing := struct {
+		Upstreams []blueGreenUpstream `json:"upstreams"`
+	}{}
+	err := json.Unmarshal(rw.Body.Bytes(), &listing)
+	testutil.Assert(t, err == nil, "could not decode blue/green listing: %s", err)
+	testutil.Equal(t, 1, len(listing.Upstreams))
+	testutil.Equal(t, green, listing.Upstreams[0].Active)
+	testutil.Equal(t, []string{"http://foo-green.internal"}, listing.Upstreams[0].Green)
+
+	testutil.Equal(t, http.StatusBadRequest, serve("POST", `{"service": "foo", "active": "red"}`).Code)
+	testutil.Equal(t, http.StatusNotFound, serve("POST", `{"service": "bar", "active": "blue"}`).Code)
+	testutil.Equal(t, http.StatusMethodNotAllowed, serve("DELETE", "").Code)
+}
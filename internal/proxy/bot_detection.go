@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	botChallengePath   = "/oauth2/bot_challenge"
+	botChallengeCookie = "_sso_bot_challenge"
+	// botChallengeTTL is how long a client that passed the challenge isn't challenged again.
+	botChallengeTTL = 24 * time.Hour
+
+	botSensitivityLow    = "low"
+	botSensitivityMedium = "medium"
+	botSensitivityHigh   = "high"
+
+	botActionChallenge = "challenge"
+	botActionBlock     = "block"
+)
+
+// botSignInRates are the sign ins a client address may start or complete each minute, by
+// sensitivity, beyond which it is a suspected bot.
+var botSignInRates = map[string]int{
+	botSensitivityLow:    60,
+	botSensitivityMedium: 20,
+	botSensitivityHigh:   10,
+}
+
+// scannerAgents are fragments of the user agents of vulnerability scanners and brute forcing
+// tools, which are suspected bots at every sensitivity.
+var scannerAgents = []string{
+	"acunetix", "arachni", "dirbuster", "feroxbuster", "ffuf", "gobuster", "masscan", "nessus",
+	"netsparker", "nikto", "nmap", "nuclei", "openvas", "skipfish", "sqlmap", "w3af", "wfuzz",
+	"whatweb", "wpscan", "zgrab", "zmeu",
+}
+
+// automationAgents are fragments of the user agents of HTTP libraries and headless browsers,
+// which are suspected bots at high sensitivity.
+var automationAgents = []string{
+	"curl/", "go-http-client", "headlesschrome", "java/", "libwww-perl", "okhttp", "phantomjs",
+	"python-requests", "python-urllib", "scrapy", "wget/",
+}
+
+// oauthParamPattern matches the code and state parameters of callbacks, which are base64
+// encoded by the authenticator. Longer parameters than oauthParamMaxLength are malformed too.
+var oauthParamPattern = regexp.MustCompile(`^[A-Za-z0-9\-_.~+/=]+$`)
+
+const oauthParamMaxLength = 4096
+
+// BotDetectionConfig maps to the bot_detection yaml config fields of an upstream:
+// * sensitivity - low, medium (the default), or high, see newBotDetector
+// * action - what is done with suspected bots: challenge (the default) or block
+type BotDetectionConfig struct {
+	Sensitivity string `yaml:"sensitivity"`
+	Action      string `yaml:"action"`
+}
+
+func (c *BotDetectionConfig) validate() error {
+	if c.Sensitivity == "" {
+		c.Sensitivity = botSensitivityMedium
+	}
+	if _, ok := botSignInRates[c.Sensitivity]; !ok {
+		return fmt.Errorf("unknown sensitivity %q: must be low, medium, or high", c.Sensitivity)
+	}
+
+	if c.Action == "" {
+		c.Action = botActionChallenge
+	}
+	if c.Action != botActionChallenge && c.Action != botActionBlock {
+		return fmt.Errorf("unknown action %q: must be challenge or block", c.Action)
+	}
+	return nil
+}
+
+// botDetector flags the requests to an upstream that are likely made by bots and scanners,
+// rather than people: those from the user agents of scanners, those with malformed OAuth
+// callback parameters, and those from addresses starting sign ins at an absurd rate. Higher
+// sensitivities lower the rate, and also flag requests without a user agent and, unless they
+// carry a bearer token, those from HTTP libraries.
+type botDetector struct {
+	config     *BotDetectionConfig
+	maxSignIns int
+	noAgent    bool
+	automation bool
+
+	mu          sync.Mutex
+	signIns     map[string]int
+	windowStart time.Time
+}
+
+// newBotDetector returns the bot detector of an upstream, or nil if it has none.
+func newBotDetector(config *BotDetectionConfig) *botDetector {
+	if config == nil {
+		return nil
+	}
+	return &botDetector{
+		config:     config,
+		maxSignIns: botSignInRates[config.Sensitivity],
+		noAgent:    config.Sensitivity != botSensitivityLow,
+		automation: config.Sensitivity == botSensitivityHigh,
+		signIns:    make(map[string]int),
+	}
+}
+
+// inspect returns the reason a request is suspected to be made by a bot, or "" if it isn't.
+func (d *botDetector) inspect(req *http.Request) string {
+	agent := strings.ToLower(req.UserAgent())
+	if agent == "" && d.noAgent {
+		return "no_user_agent"
+	}
+	for _, fragment := range scannerAgents {
+		if strings.Contains(agent, fragment) {
+			return "scanner_user_agent"
+		}
+	}
+	if d.automation && !strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		for _, fragment := range automationAgents {
+			if strings.Contains(agent, fragment) {
+				return "automation_user_agent"
+			}
+		}
+	}
+
+	if req.URL.Path == "/oauth2/callback" && malformedCallback(req) {
+		return "malformed_oauth_params"
+	}
+	return ""
+}
+
+// malformedCallback returns true if a callback has neither an error nor a code, or a code or
+// state that the authenticator can't have sent.
+func malformedCallback(req *http.Request) bool {
+	query := req.URL.Query()
+	if query.Get("error") != "" {
+		return false
+	}
+	for _, param := range []string{"code", "state"} {
+		values := query[param]
+		if len(values) != 1 || len(values[0]) > oauthParamMaxLength || !oauthParamPattern.MatchString(values[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// signIn records a sign in started or completed by the client address of a request, returning
+// the reason the address is suspected to be a bot if it has signed in too often this minute.
+func (d *botDetector) signIn(req *http.Request, now time.Time) string {
+	addr := loginAddrKey(req)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.windowStart) >= time.Minute {
+		d.signIns = make(map[string]int)
+		d.windowStart = now
+	}
+	d.signIns[addr]++
+	if d.signIns[addr] > d.maxSignIns {
+		return "sign_in_rate"
+	}
+	return ""
+}
+
+// botChallenge is the token of a client address that passed the challenge, sealed with the
+// cookie cipher so it can't be forged.
+type botChallenge struct {
+	Address string    `json:"address"`
+	Expires time.Time `json:"expires"`
+}
+
+// newBotChallengeToken returns the token the challenge page submits for a client address.
+func (p *OAuthProxy) newBotChallengeToken(req *http.Request) (string, error) {
+	return p.cookieCipher.Marshal(&botChallenge{
+		Address: loginAddrKey(req),
+		Expires: time.Now().Add(botChallengeTTL),
+	})
+}
+
+// validBotChallengeToken returns true if a token was issued to the client address of a
+// request, and hasn't expired.
+func (p *OAuthProxy) validBotChallengeToken(req *http.Request, token string) bool {
+	challenge := &botChallenge{}
+	if token == "" || p.cookieCipher.Unmarshal(token, challenge) != nil {
+		return false
+	}
+	return challenge.Address == loginAddrKey(req) && time.Now().Before(challenge.Expires)
+}
+
+// passedBotChallenge returns true if the client of a request passed the challenge recently.
+func (p *OAuthProxy) passedBotChallenge(req *http.Request) bool {
+	c, err := req.Cookie(botChallengeCookie)
+	return err == nil && p.validBotChallengeToken(req, c.Value)
+}
+
+// detectBots challenges or blocks the requests to the upstream suspected to be made by bots,
+// unless their client has passed the challenge.
+func (p *OAuthProxy) detectBots(handler http.Handler) http.Handler {
+	if p.botDetector == nil {
+		return handler
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == botChallengePath || p.passedBotChallenge(req) {
+			handler.ServeHTTP(rw, req)
+			return
+		}
+
+		reason := p.botDetector.inspect(req)
+		if reason == "" && req.URL.Path == "/oauth2/callback" {
+			reason = p.botDetector.signIn(req, time.Now())
+		}
+		if reason != "" {
+			p.botDetected(rw, req, reason)
+			return
+		}
+		handler.ServeHTTP(rw, req)
+	})
+}
+
+// botSignIn records a sign in started by a client, and challenges or blocks it if it's
+// suspected to be a bot, returning true if it did.
+func (p *OAuthProxy) botSignIn(rw http.ResponseWriter, req *http.Request) bool {
+	if p.botDetector == nil || p.passedBotChallenge(req) {
+		return false
+	}
+	if reason := p.botDetector.signIn(req, time.Now()); reason != "" {
+		p.botDetected(rw, req, reason)
+		return true
+	}
+	return false
+}
+
+// botDetected challenges or blocks a request suspected to be made by a bot. Only browsers can
+// pass the challenge, so other requests are always blocked.
+func (p *OAuthProxy) botDetected(rw http.ResponseWriter, req *http.Request, reason string) {
+	action := p.botDetector.config.Action
+	if req.Method != http.MethodGet || p.wantsJSON(req) {
+		action = botActionBlock
+	}
+
+	tags := []string{"action:bot_detection", "reason:" + reason, "response:" + action}
+	p.StatsdClient.Incr("bot_detected", tags, 1.0)
+	requestLogEntry(req).WithRequestHost(req.Host).WithUserAgent(req.UserAgent()).WithAction(
+		"bot_detection").Info(fmt.Sprintf("suspected bot: %s, responding with %s", reason, action))
+
+	if action == botActionBlock {
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Your request looks automated and was blocked")
+		return
+	}
+
+	token, err := p.newBotChallengeToken(req)
+	if err != nil {
+		p.StatsdClient.Incr("application_error", []string{"action:bot_detection", "error:challenge_token_error"}, 1.0)
+		p.ErrorPage(rw, req, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred")
+		return
+	}
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusForbidden)
+	p.templates.ExecuteTemplate(rw, "bot_challenge.html", struct {
+		Host     string
+		Token    string
+		Redirect string
+	}{
+		Host:     req.Host,
+		Token:    token,
+		Redirect: localRedirect(req.URL.RequestURI()),
+	})
+}
+
+// BotChallenge records that a client passed the challenge with a POST request from the
+// challenge page, so that it isn't challenged again for a day.
+func (p *OAuthProxy) BotChallenge(rw http.ResponseWriter, req *http.Request) {
+	tags := []string{"action:bot_challenge"}
+
+	if p.botDetector == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		p.ErrorPage(rw, req, http.StatusMethodNotAllowed, "Method Not Allowed", "Method Not Allowed")
+		return
+	}
+
+	token := req.PostFormValue("token")
+	if !isSameOrigin(req) || !p.validBotChallengeToken(req, token) {
+		tags = append(tags, "error:invalid_challenge")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Your request looks automated and was blocked")
+		return
+	}
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     botChallengeCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(botChallengeTTL),
+		HttpOnly: true,
+		Secure:   p.cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	p.StatsdClient.Incr("bot_challenge_passed", tags, 1.0)
+	http.Redirect(rw, req, localRedirect(req.PostFormValue("rd")), http.StatusSeeOther)
+}
@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestBotDetectionConfigValidate(t *testing.T) {
+	config := &BotDetectionConfig{}
+	testutil.Ok(t, config.validate())
+	testutil.Equal(t, botSensitivityMedium, config.Sensitivity)
+	testutil.Equal(t, botActionChallenge, config.Action)
+
+	err := (&BotDetectionConfig{Sensitivity: "paranoid"}).validate()
+	testutil.Equal(t, `unknown sensitivity "paranoid": must be low, medium, or high`, err.Error())
+	err = (&BotDetectionConfig{Action: "tarpit"}).validate()
+	testutil.Equal(t, `unknown action "tarpit": must be challenge or block`, err.Error())
+}
+
+func TestParseBotDetectionConfig(t *testing.T) {
+	upstreamConfig := &UpstreamConfig{
+		RouteConfig: RouteConfig{
+			Options: &OptionsConfig{
+				BotDetection: &BotDetectionConfig{Sensitivity: "paranoid"},
+			},
+		},
+	}
+	err := parseOptionsConfig(upstreamConfig, nil)
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, `invalid bot_detection error=unknown sensitivity "paranoid": must be low, medium, or high`, err.Error())
+
+	upstreamConfig.RouteConfig.Options.BotDetection = &BotDetectionConfig{Action: botActionBlock}
+	testutil.Ok(t, parseOptionsConfig(upstreamConfig, nil))
+	testutil.Equal(t, botSensitivityMedium, upstreamConfig.BotDetection.Sensitivity)
+	testutil.Equal(t, botActionBlock, upstreamConfig.BotDetection.Action)
+}
+
+func TestBotDetectorInspect(t *testing.T) {
+	testCases := []struct {
+		name        string
+		sensitivity string
+		target      string
+		userAgent   string
+		bearer      bool
+		want        string
+	}{
+		{"browser", botSensitivityHigh, "/", "Mozilla/5.0", false, ""},
+		{"scanner at low sensitivity", botSensitivityLow, "/", "sqlmap/1.4", false, "scanner_user_agent"},
+		{"no user agent at low sensitivity", botSensitivityLow, "/", "", false, ""},
+		{"no user agent at medium sensitivity", botSensitivityMedium, "/", "", false, "no_user_agent"},
+		{"library at medium sensitivity", botSensitivityMedium, "/", "curl/7.64.1", false, ""},
+		{"library at high sensitivity", botSensitivityHigh, "/", "curl/7.64.1", false, "automation_user_agent"},
+		{"library with a bearer token", botSensitivityHigh, "/", "curl/7.64.1", true, ""},
+		{"callback", botSensitivityMedium, "/oauth2/callback?code=abc123&state=c3RhdGU=", "Mozilla/5.0", false, ""},
+		{"callback with an error", botSensitivityMedium, "/oauth2/callback?error=access_denied", "Mozilla/5.0", false, ""},
+		{"callback without a state", botSensitivityMedium, "/oauth2/callback?code=abc123", "Mozilla/5.0", false, "malformed_oauth_params"},
+		{"callback with an injected code", botSensitivityLow, "/oauth2/callback?code=%27%20OR%201=1&state=abc", "Mozilla/5.0", false, "malformed_oauth_params"},
+		{"callback with repeated codes", botSensitivityLow, "/oauth2/callback?code=a&code=b&state=abc", "Mozilla/5.0", false, "malformed_oauth_params"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			detector := newBotDetector(&BotDetectionConfig{Sensitivity: tc.sensitivity})
+			req := httptest.NewRequest("GET", "https://localhost"+tc.target, nil)
+			req.Header.Set("User-Agent", tc.userAgent)
+			if tc.bearer {
+				req.Header.Set("Authorization", "Bearer token")
+			}
+			testutil.Equal(t, tc.want, detector.inspect(req))
+		})
+	}
+}
+
+func TestBotDetectorSignIn(t *testing.T) {
+	detector := newBotDetector(&BotDetectionConfig{Sensitivity: botSensitivityHigh})
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	newRequest := func(addr string) *http.Request {
+		req := httptest.NewRequest("GET", "https://localhost/", nil)
+		req.RemoteAddr = addr
+		return req
+	}
+
+	for i := 0; i < 10; i++ {
+		testutil.Equal(t, "", detector.signIn(newRequest("192.0.2.1:1234"), now.Add(time.Duration(i)*time.Second)))
+	}
+	testutil.Equal(t, "sign_in_rate", detector.signIn(newRequest("192.0.2.1:5678"), now.Add(10*time.Second)))
+	testutil.Equal(t, "", detector.signIn(newRequest("192.0.2.2:1234"), now.Add(10*time.Second)))
+
+	// the count starts afresh each minute
+	testutil.Equal(t, "", detector.signIn(newRequest("192.0.2.1:1234"), now.Add(time.Minute)))
+}
+
+func TestBotDetection(t *testing.T) {
+	cipher, err := aead.NewMiscreantCipher([]byte(strings.Repeat("k", 32)))
+	testutil.Ok(t, err)
+
+	testCases := []struct {
+		name       string
+		action     string
+		method     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"challenge", botActionChallenge, "GET", http.StatusForbidden, `action="/oauth2/bot_challenge"`},
+		{"block", botActionBlock, "GET", http.StatusForbidden, "was blocked"},
+		{"challenge of a non-GET request", botActionChallenge, "POST", http.StatusForbidden, "was blocked"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t, setCookieCipher(cipher))
+			defer close()
+			proxy.botDetector = newBotDetector(&BotDetectionConfig{Sensitivity: botSensitivityMedium, Action: tc.action})
+
+			req := httptest.NewRequest(tc.method, "https://localhost/foo?bar=baz", nil)
+			req.Header.Set("User-Agent", "nikto/2.1.6")
+			rw := httptest.NewRecorder()
+			proxy.Handler().ServeHTTP(rw, req)
+			testutil.Equal(t, tc.wantStatus, rw.Code)
+			testutil.Assert(t, strings.Contains(rw.Body.String(), tc.wantBody), "expected %q in body: %s", tc.wantBody, rw.Body.String())
+		})
+	}
+}
+
+func TestBotChallenge(t *testing.T) {
+	cipher, err := aead.NewMiscreantCipher([]byte(strings.Repeat("k", 32)))
+	testutil.Ok(t, err)
+	proxy, close := testNewOAuthProxy(t, setCookieCipher(cipher))
+	defer close()
+	proxy.botDetector = newBotDetector(&BotDetectionConfig{Sensitivity: botSensitivityMedium, Action: botActionChallenge})
+	handler := proxy.Handler()
+
+	newRequest := func(method, target string, form url.Values) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://localhost")
+		req.RemoteAddr = "192.0.2.1:1234"
+		return req
+	}
+
+	token, err := proxy.newBotChallengeToken(newRequest("GET", "https://localhost/", nil))
+	testutil.Ok(t, err)
+
+	// the token is bound to the client address, and must come from the challenge page
+	req := newRequest("POST", "https://localhost/oauth2/bot_challenge", url.Values{"token": {token}})
+	req.RemoteAddr = "198.51.100.1:1234"
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusForbidden, rw.Code)
+
+	req = newRequest("POST", "https://localhost/oauth2/bot_challenge", url.Values{"token": {token}})
+	req.Header.Del("Origin")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusForbidden, rw.Code)
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, newRequest("GET", "https://localhost/oauth2/bot_challenge", nil))
+	testutil.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, newRequest("POST", "https://localhost/oauth2/bot_challenge", url.Values{
+		"token": {token},
+		"rd":    {"/foo?bar=baz"},
+	}))
+	testutil.Equal(t, http.StatusSeeOther, rw.Code)
+	testutil.Equal(t, "/foo?bar=baz", rw.Header().Get("Location"))
+	cookies := rw.Result().Cookies()
+	testutil.Equal(t, 1, len(cookies))
+	testutil.Equal(t, botChallengeCookie, cookies[0].Name)
+
+	// once the challenge is passed, requests without a user agent are no longer challenged
+	req = newRequest("GET", "https://localhost/foo", nil)
+	req.Header.Set("User-Agent", "")
+	testutil.Equal(t, "no_user_agent", proxy.botDetector.inspect(req))
+	testutil.Equal(t, false, proxy.passedBotChallenge(req))
+	req.AddCookie(cookies[0])
+	testutil.Equal(t, true, proxy.passedBotChallenge(req))
+}
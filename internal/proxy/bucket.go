@@ -0,0 +1,338 @@
+package proxy
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	awssession "github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+const (
+	bucketProviderS3  = "s3"
+	bucketProviderGCS = "gs"
+
+	defaultS3Region = "us-east-1"
+	// Google Cloud Storage accepts SigV4 signed requests made with HMAC keys through its
+	// XML API, using the "auto" region.
+	gcsRegion   = "auto"
+	gcsEndpoint = "https://storage.googleapis.com"
+)
+
+// Request headers forwarded to the bucket. Every other header, including cookies
+// and authorization, is dropped before the request is signed.
+var bucketRequestHeaders = []string{
+	"If-Match",
+	"If-Modified-Since",
+	"If-None-Match",
+	"If-Unmodified-Since",
+	"Range",
+}
+
+// Response headers returned from the bucket. Provider specific headers, such as
+// x-amz-* and x-goog-* metadata, are never passed on to the client.
+var bucketResponseHeaders = []string{
+	"Accept-Ranges",
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+}
+
+// bucketHandler serves objects from an S3 or GCS bucket for bucket routes, signing
+// every request to the bucket with SigV4.
+type bucketHandler struct {
+	route     *BucketRoute
+	endpoint  *url.URL
+	region    string
+	indexFile string
+
+	signer    *v4.Signer
+	client    *http.Client
+	templates *template.Template
+}
+
+// NewBucketHandler returns an http.Handler serving the bucket of a BucketRoute.
+func NewBucketHandler(route *BucketRoute, config *UpstreamConfig) (http.Handler, error) {
+	region := config.BucketRegion
+	endpoint := config.BucketEndpoint
+	creds := config.BucketCredentials
+
+	switch route.Provider {
+	case bucketProviderS3:
+		if region == "" {
+			region = defaultS3Region
+		}
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+		if creds == nil {
+			// fall back to the default credential chain: the environment, shared
+			// credentials file, and instance or task roles.
+			sess, err := awssession.NewSession(&aws.Config{Region: aws.String(region)})
+			if err != nil {
+				return nil, err
+			}
+			creds = sess.Config.Credentials
+		}
+	case bucketProviderGCS:
+		if region == "" {
+			region = gcsRegion
+		}
+		if endpoint == "" {
+			endpoint = gcsEndpoint
+		}
+		if creds == nil {
+			return nil, fmt.Errorf("missing hmac credentials for gcs bucket %q", route.Bucket)
+		}
+	default:
+		return nil, fmt.Errorf("unknown bucket provider %q", route.Provider)
+	}
+
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	indexFile := config.IndexFile
+	if indexFile == "" {
+		indexFile = defaultIndexFile
+	}
+
+	var handler http.Handler = &bucketHandler{
+		route:     route,
+		endpoint:  endpointURL,
+		region:    region,
+		indexFile: indexFile,
+		signer: v4.NewSigner(creds, func(s *v4.Signer) {
+			// object keys are escaped exactly once when signing S3 requests
+			s.DisableURIPathEscaping = true
+		}),
+		client: &http.Client{
+			Transport: newUpstreamTransport(config),
+			// redirects point at the bucket itself, so they are never followed
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		templates: getTemplates(),
+	}
+
+	if config.Timeout != 0 {
+		handler = newTimeoutHandler(handler, config)
+	}
+
+	return handler, nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *bucketHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, HEAD")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + req.URL.Path)
+	isDir := strings.HasSuffix(req.URL.Path, "/")
+	key := h.route.Prefix + strings.TrimPrefix(name, "/")
+	if isDir && name != "/" {
+		key += "/"
+		name += "/"
+	}
+
+	if !isDir {
+		h.serveObject(rw, req, key)
+		return
+	}
+
+	resp, err := h.do(req, req.Method, key+h.indexFile, nil)
+	if err != nil {
+		h.serveError(rw, req, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	// Buckets respond with a 403 rather than a 404 for missing keys when the
+	// credentials may not list objects; either way, fall back to a listing.
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		h.serveListing(rw, req, name, key)
+		return
+	}
+	copyBucketResponse(rw, resp)
+}
+
+func (h *bucketHandler) serveObject(rw http.ResponseWriter, req *http.Request, key string) {
+	resp, err := h.do(req, req.Method, key, nil)
+	if err != nil {
+		h.serveError(rw, req, err)
+		return
+	}
+	defer resp.Body.Close()
+	copyBucketResponse(rw, resp)
+}
+
+// bucketListing is the subset of a ListObjectsV2 response used to render listings.
+type bucketListing struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	CommonPrefixes        []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// bucketEntry is a single row of a rendered bucket listing.
+type bucketEntry struct {
+	Name         string
+	IsDir        bool
+	Size         int64
+	LastModified string
+}
+
+func (h *bucketHandler) serveListing(rw http.ResponseWriter, req *http.Request, name, prefix string) {
+	entries := []bucketEntry{}
+	query := url.Values{
+		"list-type": []string{"2"},
+		"delimiter": []string{"/"},
+		"prefix":    []string{prefix},
+	}
+	for {
+		resp, err := h.do(req, http.MethodGet, "", query)
+		if err != nil {
+			h.serveError(rw, req, err)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			copyBucketResponse(rw, resp)
+			resp.Body.Close()
+			return
+		}
+
+		listing := &bucketListing{}
+		err = xml.NewDecoder(resp.Body).Decode(listing)
+		resp.Body.Close()
+		if err != nil {
+			h.serveError(rw, req, err)
+			return
+		}
+
+		for _, p := range listing.CommonPrefixes {
+			entries = append(entries, bucketEntry{
+				Name:  strings.TrimPrefix(p.Prefix, prefix),
+				IsDir: true,
+			})
+		}
+		for _, c := range listing.Contents {
+			// skip the placeholder object some tools create for directories
+			if c.Key == prefix {
+				continue
+			}
+			entries = append(entries, bucketEntry{
+				Name:         strings.TrimPrefix(c.Key, prefix),
+				Size:         c.Size,
+				LastModified: c.LastModified.UTC().Format(time.RFC3339),
+			})
+		}
+
+		if !listing.IsTruncated || listing.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", listing.NextContinuationToken)
+	}
+
+	if len(entries) == 0 && name != "/" {
+		http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("Cache-Control", "private, no-cache")
+	if req.Method == http.MethodHead {
+		return
+	}
+	h.templates.ExecuteTemplate(rw, "bucket_listing.html", struct {
+		Path    string
+		Entries []bucketEntry
+	}{
+		Path:    name,
+		Entries: entries,
+	})
+}
+
+// do makes a signed request to the bucket for the given object key, or for the bucket
+// itself if the key is empty.
+func (h *bucketHandler) do(req *http.Request, method, key string, query url.Values) (*http.Response, error) {
+	u := *h.endpoint
+	u.Path = singleJoiningSlash(u.Path, h.route.Bucket)
+	if key != "" {
+		u.Path = singleJoiningSlash(u.Path, key)
+	}
+	u.RawQuery = query.Encode()
+
+	bucketReq, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	bucketReq = bucketReq.WithContext(req.Context())
+	for _, header := range bucketRequestHeaders {
+		if val := req.Header.Get(header); val != "" {
+			bucketReq.Header.Set(header, val)
+		}
+	}
+
+	_, err = h.signer.Sign(bucketReq, nil, "s3", h.region, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return h.client.Do(bucketReq)
+}
+
+func (h *bucketHandler) serveError(rw http.ResponseWriter, req *http.Request, err error) {
+	logger := log.NewLogEntry()
+	logger.WithRequestHost(req.Host).Error(err, "error requesting bucket")
+	http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
+
+func copyBucketResponse(rw http.ResponseWriter, resp *http.Response) {
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 && resp.StatusCode != http.StatusNotModified {
+		// bucket redirects point at the bucket itself, which the client cannot use
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode >= 400 {
+		// never leak the provider's error documents, which may include bucket names
+		// and request ids
+		http.Error(rw, http.StatusText(resp.StatusCode), resp.StatusCode)
+		return
+	}
+
+	for _, header := range bucketResponseHeaders {
+		if vals, ok := resp.Header[header]; ok {
+			rw.Header()[header] = vals
+		}
+	}
+	rw.WriteHeader(resp.StatusCode)
+	io.Copy(rw, resp.Body)
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func testBucketServer(t *testing.T, objects map[string]string) (*httptest.Server, *[]*http.Request) {
+	requests := []*http.Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req)
+		if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if req.URL.Path == "/bucket" && req.URL.Query().Get("list-type") == "2" {
+			prefix := req.URL.Query().Get("prefix")
+			fmt.Fprint(rw, `<ListBucketResult>`)
+			dirs := map[string]bool{}
+			for key := range objects {
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				rest := strings.TrimPrefix(key, prefix)
+				if i := strings.Index(rest, "/"); i >= 0 {
+					dirs[prefix+rest[:i+1]] = true
+					continue
+				}
+				fmt.Fprintf(rw, `<Contents><Key>%s</Key><Size>%d</Size><LastModified>2019-01-01T00:00:00.000Z</LastModified></Contents>`, key, len(objects[key]))
+			}
+			for dir := range dirs {
+				fmt.Fprintf(rw, `<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>`, dir)
+			}
+			fmt.Fprint(rw, `</ListBucketResult>`)
+			return
+		}
+
+		body, ok := objects[strings.TrimPrefix(req.URL.Path, "/bucket/")]
+		if !ok {
+			rw.Header().Set("Content-Type", "application/xml")
+			rw.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(rw, `<Error><Code>NoSuchKey</Code><BucketName>bucket</BucketName></Error>`)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Header().Set("x-amz-meta-owner", "foo")
+		fmt.Fprint(rw, body)
+	}))
+	return server, &requests
+}
+
+func TestBucketHandler(t *testing.T) {
+	server, requests := testBucketServer(t, map[string]string{
+		"docs/index.html":       "<h1>docs</h1>",
+		"docs/guide/intro.txt":  "intro",
+		"docs/builds/1.tar.gz":  "build one",
+		"docs/builds/2.tar.gz":  "build two",
+		"other/secret.txt":      "secret",
+		"docs/builds/README.md": "readme",
+	})
+	defer server.Close()
+
+	handler, err := NewBucketHandler(
+		&BucketRoute{Provider: "s3", Bucket: "bucket", Prefix: "docs/"},
+		&UpstreamConfig{
+			BucketEndpoint:    server.URL,
+			BucketCredentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		},
+	)
+	testutil.Ok(t, err)
+
+	testCases := []struct {
+		name             string
+		method           string
+		path             string
+		expectedStatus   int
+		expectedBody     string
+		expectedContains []string
+		expectedKey      string
+	}{
+		{
+			name:           "serves object under prefix",
+			path:           "/guide/intro.txt",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "intro",
+			expectedKey:    "/bucket/docs/guide/intro.txt",
+		},
+		{
+			name:           "serves index object for directories",
+			path:           "/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<h1>docs</h1>",
+			expectedKey:    "/bucket/docs/index.html",
+		},
+		{
+			name:             "lists directories without an index object",
+			path:             "/builds/",
+			expectedStatus:   http.StatusOK,
+			expectedContains: []string{"Index of /builds/", `href="1.tar.gz"`, `href="README.md"`},
+		},
+		{
+			name:           "does not escape prefix",
+			path:           "/../other/secret.txt",
+			expectedStatus: http.StatusNotFound,
+			expectedKey:    "/bucket/docs/other/secret.txt",
+		},
+		{
+			name:           "hides provider error documents",
+			path:           "/missing.txt",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   "Not Found\n",
+		},
+		{
+			name:           "rejects non-GET methods",
+			method:         "PUT",
+			path:           "/guide/intro.txt",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			*requests = nil
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := httptest.NewRequest(method, "http://docs.example.com"+tc.path, nil)
+			req.Header.Set("Cookie", "_sso_proxy=session")
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+
+			testutil.Equal(t, tc.expectedStatus, rw.Code)
+			if tc.expectedBody != "" {
+				testutil.Equal(t, tc.expectedBody, rw.Body.String())
+			}
+			for _, s := range tc.expectedContains {
+				testutil.Assert(t, strings.Contains(rw.Body.String(), s), "expected body to contain %q, got %q", s, rw.Body.String())
+			}
+			testutil.Equal(t, "", rw.Header().Get("x-amz-meta-owner"))
+			if tc.expectedKey != "" {
+				testutil.Equal(t, tc.expectedKey, (*requests)[0].URL.Path)
+			}
+			for _, r := range *requests {
+				testutil.Equal(t, "", r.Header.Get("Cookie"))
+			}
+		})
+	}
+}
+
+func TestNewBucketHandlerRequiresGCSCredentials(t *testing.T) {
+	_, err := NewBucketHandler(&BucketRoute{Provider: "gs", Bucket: "bucket"}, &UpstreamConfig{})
+	testutil.NotEqual(t, nil, err)
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/fips"
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+const certExpiryDialTimeout = 10 * time.Second
+
+// certExpiryTarget is an HTTPS server the proxy connects to, whose certificate is monitored.
+type certExpiryTarget struct {
+	kind    string
+	service string
+	cluster string
+	addr    string
+	host    string
+}
+
+func (t *certExpiryTarget) tags() []string {
+	tags := []string{"kind:" + t.kind, "target:" + t.host}
+	if t.service != "" {
+		tags = append(tags, "service:"+t.service, "cluster:"+t.cluster)
+	}
+	return tags
+}
+
+// certExpiryChecker periodically connects to the provider and the HTTPS upstreams of the proxy,
+// and records the days until their certificates expire, warning of those that expire soon.
+// Certificates are read rather than verified, so that expired certificates are reported too.
+type certExpiryChecker struct {
+	targets  []*certExpiryTarget
+	interval time.Duration
+	warning  time.Duration
+
+	StatsdClient *statsd.Client
+
+	dial func(target *certExpiryTarget) ([]*x509.Certificate, error)
+
+	stop chan struct{}
+}
+
+// newCertExpiryChecker returns the certExpiryChecker of the provider and upstreams of the options,
+// or nil if certificate expiry checks are disabled.
+func newCertExpiryChecker(opts *Options) *certExpiryChecker {
+	if opts.CertExpiryCheckInterval <= 0 {
+		return nil
+	}
+
+	targets := []*certExpiryTarget{}
+	seen := map[string]bool{}
+	add := func(target *certExpiryTarget) {
+		key := target.service + "/" + target.addr
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		targets = append(targets, target)
+	}
+
+	for _, providerURL := range []string{opts.ProviderURLString, opts.ProviderURLInternalString} {
+		if target := newCertExpiryTarget(providerURL); target != nil {
+			target.kind = "provider"
+			add(target)
+		}
+	}
+	for _, uc := range opts.upstreamConfigs {
+		// the backends of rewrite routes depend on the request, and buckets are the object store's
+		route, ok := uc.Route.(*SimpleRoute)
+		if !ok {
+			continue
+		}
+		if target := newCertExpiryTarget(route.ToURL.String()); target != nil {
+			target.kind = "upstream"
+			target.service = uc.Service
+			target.cluster = uc.Cluster
+			add(target)
+		}
+	}
+
+	return &certExpiryChecker{
+		targets:      targets,
+		interval:     opts.CertExpiryCheckInterval,
+		warning:      time.Duration(opts.CertExpiryWarningDays) * 24 * time.Hour,
+		StatsdClient: opts.StatsdClient,
+		dial:         dialCertificates,
+		stop:         make(chan struct{}),
+	}
+}
+
+// newCertExpiryTarget returns the target of an HTTPS URL, or nil if the URL isn't HTTPS.
+func newCertExpiryTarget(rawURL string) *certExpiryTarget {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return nil
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	return &certExpiryTarget{
+		addr: net.JoinHostPort(u.Hostname(), port),
+		host: u.Hostname(),
+	}
+}
+
+// dialCertificates returns the certificates a target presents in a TLS handshake.
+func dialCertificates(target *certExpiryTarget) ([]*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: certExpiryDialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", target.addr, fips.TLSConfig(&tls.Config{
+		ServerName:         target.host,
+		InsecureSkipVerify: true,
+	}))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates, nil
+}
+
+// run checks the certificates at the configured interval, until the checker is stopped.
+func (c *certExpiryChecker) run() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		for _, target := range c.targets {
+			c.check(target, time.Now())
+		}
+		select {
+		case <-ticker.C:
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Stop stops checking the certificates, once the upstream configs they were found in are replaced.
+func (c *certExpiryChecker) Stop() {
+	close(c.stop)
+}
+
+// check records the days until the first certificate of a target's chain expires, and warns if
+// it expires within the warning period.
+func (c *certExpiryChecker) check(target *certExpiryTarget, now time.Time) {
+	logger := log.NewLogEntry()
+
+	certs, err := c.dial(target)
+	if err == nil && len(certs) == 0 {
+		err = fmt.Errorf("no certificate presented")
+	}
+	if err != nil {
+		c.StatsdClient.Incr("certificate.expiry_check", append(target.tags(), "result:error"), 1.0)
+		logger.Error(err, fmt.Sprintf("error checking the certificate of %s %s", target.kind, target.addr))
+		return
+	}
+	c.StatsdClient.Incr("certificate.expiry_check", append(target.tags(), "result:ok"), 1.0)
+
+	expiring := firstExpiring(certs)
+	remaining := expiring.NotAfter.Sub(now)
+	c.StatsdClient.Gauge("certificate.expiry_days", remaining.Hours()/24, target.tags(), 1.0)
+
+	switch {
+	case remaining <= 0:
+		logger.Warn(fmt.Sprintf("certificate %q of %s %s expired on %s",
+			expiring.Subject.CommonName, target.kind, target.addr, expiring.NotAfter.Format(time.RFC3339)))
+	case remaining <= c.warning:
+		logger.Warn(fmt.Sprintf("certificate %q of %s %s expires in %d days, on %s",
+			expiring.Subject.CommonName, target.kind, target.addr, int(remaining.Hours()/24), expiring.NotAfter.Format(time.RFC3339)))
+	}
+}
+
+// firstExpiring returns the certificate of a chain that expires first, since an expired
+// intermediate breaks connections as surely as an expired leaf.
+func firstExpiring(certs []*x509.Certificate) *x509.Certificate {
+	expiring := certs[0]
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(expiring.NotAfter) {
+			expiring = cert
+		}
+	}
+	return expiring
+}
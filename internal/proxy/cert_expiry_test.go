@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestNewCertExpiryChecker(t *testing.T) {
+	opts := testOptions()
+	testutil.Ok(t, opts.Validate())
+	testutil.Equal(t, (*certExpiryChecker)(nil), newCertExpiryChecker(opts))
+
+	opts.CertExpiryCheckInterval = time.Hour
+	opts.CertExpiryWarningDays = 7
+	opts.ProviderURLString = "https://sso-auth.example.com"
+	opts.ProviderURLInternalString = "https://sso-auth.internal:8443"
+	var err error
+	opts.upstreamConfigs, err = opts.loadUpstreamConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.sso.dev
+    to: https://foo.internal
+- service: bar
+  default:
+    from: bar.sso.dev
+    to: http://bar.internal
+- service: baz
+  default:
+    from: ^baz-(.*).sso.dev$
+    to: https://baz-$1.internal
+    type: rewrite
+`))
+	testutil.Ok(t, err)
+
+	checker := newCertExpiryChecker(opts)
+	testutil.Equal(t, []*certExpiryTarget{
+		{kind: "provider", addr: "sso-auth.example.com:443", host: "sso-auth.example.com"},
+		{kind: "provider", addr: "sso-auth.internal:8443", host: "sso-auth.internal"},
+		{kind: "upstream", service: "foo", cluster: "sso", addr: "foo.internal:443", host: "foo.internal"},
+	}, checker.targets)
+	testutil.Equal(t, 7*24*time.Hour, checker.warning)
+}
+
+func TestCertExpiryCheck(t *testing.T) {
+	now := time.Now()
+	leaf := &x509.Certificate{NotAfter: now.Add(90 * 24 * time.Hour)}
+	intermediate := &x509.Certificate{NotAfter: now.Add(10 * 24 * time.Hour)}
+	root := &x509.Certificate{NotAfter: now.Add(3650 * 24 * time.Hour)}
+	testutil.Equal(t, intermediate, firstExpiring([]*x509.Certificate{leaf, intermediate, root}))
+	testutil.Equal(t, leaf, firstExpiring([]*x509.Certificate{leaf}))
+
+	// checks that fail, or find no certificates, are reported rather than crashing the checker
+	target := &certExpiryTarget{kind: "upstream", service: "foo", cluster: "sso", addr: "foo.internal:443", host: "foo.internal"}
+	for _, dial := range []func(*certExpiryTarget) ([]*x509.Certificate, error){
+		func(*certExpiryTarget) ([]*x509.Certificate, error) { return nil, errors.New("connection refused") },
+		func(*certExpiryTarget) ([]*x509.Certificate, error) { return nil, nil },
+		func(*certExpiryTarget) ([]*x509.Certificate, error) {
+			return []*x509.Certificate{leaf, intermediate}, nil
+		},
+	} {
+		checker := &certExpiryChecker{warning: 14 * 24 * time.Hour, dial: dial}
+		checker.check(target, now)
+	}
+}
+
+func TestDialCertificates(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	// the connection is closed once the certificates are read, which the server logs
+	server.Config.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	target := newCertExpiryTarget(server.URL)
+	certs, err := dialCertificates(target)
+	testutil.Ok(t, err)
+	testutil.Equal(t, server.Certificate().NotAfter, firstExpiring(certs).NotAfter)
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+)
+
+// defaultClaimHeaders are the claims passed to every upstream, which describe the user no more
+// than X-Forwarded-Email does. An upstream can stop a default claim from being passed by mapping
+// it to an empty header.
+var defaultClaimHeaders = map[string]string{
+	"name":        "X-Forwarded-Name",
+	"given_name":  "X-Forwarded-Given-Name",
+	"family_name": "X-Forwarded-Family-Name",
+}
+
+// reservedClaimHeaders are the headers claims can't be mapped to, as they're set by sso_proxy
+// itself or have a meaning to the upstream's HTTP server.
+var reservedClaimHeaders = map[string]struct{}{
+	"Authorization":            {},
+	"Connection":               {},
+	"Content-Length":           {},
+	"Content-Type":             {},
+	"Cookie":                   {},
+	"Host":                     {},
+	"Transfer-Encoding":        {},
+	"X-Forwarded-Access-Token": {},
+	"X-Forwarded-Email":        {},
+	"X-Forwarded-For":          {},
+	"X-Forwarded-Groups":       {},
+	"X-Forwarded-Host":         {},
+	"X-Forwarded-Proto":        {},
+	"X-Forwarded-User":         {},
+	"X-Impersonated-By":        {},
+	HMACSignatureHeader:        {},
+	degradedHeader:             {},
+}
+
+// claimHeader is a claim of the session and the canonical key of the header it's passed in.
+type claimHeader struct {
+	claim string
+	key   string
+}
+
+// parseClaimHeaders validates the claim_headers option of an upstream, and returns the mapping of
+// claims to canonical header keys. Claims mapped to an empty header are kept, as they stop a
+// default claim from being passed.
+func parseClaimHeaders(claimHeaders map[string]string) (map[string]string, error) {
+	if len(claimHeaders) == 0 {
+		return nil, nil
+	}
+
+	parsed := make(map[string]string, len(claimHeaders))
+	keys := make(map[string]string, len(claimHeaders))
+	for claim, header := range claimHeaders {
+		if header == "" {
+			parsed[claim] = ""
+			continue
+		}
+		if !validHeaderKey(header) {
+			return nil, fmt.Errorf("%q is not a valid header name", header)
+		}
+		key := http.CanonicalHeaderKey(header)
+		if _, ok := reservedClaimHeaders[key]; ok {
+			return nil, fmt.Errorf("claims can't be passed in the %s header", key)
+		}
+		if other, ok := keys[key]; ok {
+			return nil, fmt.Errorf("claims %q and %q are both passed in the %s header", other, claim, key)
+		}
+		keys[key] = claim
+		parsed[claim] = key
+	}
+	return parsed, nil
+}
+
+// validHeaderKey returns true if the header name is an RFC 7230 token.
+func validHeaderKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newClaimHeaders returns the default claim headers merged with those of an upstream, sorted by
+// header.
+func newClaimHeaders(claimHeaders map[string]string) []claimHeader {
+	merged := make(map[string]string, len(defaultClaimHeaders)+len(claimHeaders))
+	for claim, key := range defaultClaimHeaders {
+		merged[claim] = key
+	}
+	for claim, key := range claimHeaders {
+		merged[claim] = key
+	}
+
+	headers := make([]claimHeader, 0, len(merged))
+	for claim, key := range merged {
+		if key == "" {
+			continue
+		}
+		headers = append(headers, claimHeader{claim: claim, key: http.CanonicalHeaderKey(key)})
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].key < headers[j].key })
+	return headers
+}
+
+// sessionClaims returns the claims passed in the claim headers of any upstream, which are the
+// only claims of the provider's ID token kept in sessions.
+func sessionClaims(configs []*UpstreamConfig) map[string]struct{} {
+	claims := make(map[string]struct{})
+	for _, config := range configs {
+		for _, h := range newClaimHeaders(config.ClaimHeaders) {
+			claims[h.claim] = struct{}{}
+		}
+	}
+	return claims
+}
+
+// SetSessionClaims sets the claims kept in sessions, as a functional option
+func SetSessionClaims(claims map[string]struct{}) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.sessionClaims = claims
+		return nil
+	}
+}
+
+// minimizeClaims removes the claims of a new session that no upstream passes in a header, so
+// that session cookies only hold the claims that are used.
+func (p *OAuthProxy) minimizeClaims(session *sessions.SessionState) {
+	if p.sessionClaims == nil {
+		return
+	}
+	for claim := range session.Claims {
+		if _, ok := p.sessionClaims[claim]; !ok {
+			delete(session.Claims, claim)
+		}
+	}
+	if len(session.Claims) == 0 {
+		session.Claims = nil
+	}
+}
+
+// claimHeaderKeys returns the canonical keys of the claim headers.
+func claimHeaderKeys(headers []claimHeader) []string {
+	keys := make([]string, 0, len(headers))
+	for _, h := range headers {
+		keys = append(keys, h.key)
+	}
+	return keys
+}
+
+// delClaimHeaders deletes the claim headers, so that they can't be sent by the client.
+func (p *OAuthProxy) delClaimHeaders(req *http.Request) {
+	for _, h := range p.claimHeaders {
+		req.Header.Del(h.key)
+	}
+}
+
+// setClaimHeaders sets the claim headers from the claims of the session. Claims the session
+// doesn't have, or whose values can't be passed in a header, are left unset.
+func (p *OAuthProxy) setClaimHeaders(req *http.Request, session *sessions.SessionState) {
+	for _, h := range p.claimHeaders {
+		if value, ok := claimHeaderValue(session.Claims[h.claim]); ok {
+			req.Header.Set(h.key, value)
+		}
+	}
+}
+
+// claimHeaderValue coerces the value of a claim to a header value: strings are passed as they
+// are, numbers without exponents so that identifiers aren't mangled, booleans as true or false,
+// lists as comma separated values, and objects as JSON.
+func claimHeaderValue(claim interface{}) (string, bool) {
+	var value string
+	switch v := claim.(type) {
+	case nil:
+		return "", false
+	case string:
+		value = v
+	case float64:
+		value = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		value = strconv.FormatBool(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, elem := range v {
+			if s, ok := claimHeaderValue(elem); ok {
+				values = append(values, s)
+			}
+		}
+		value = strings.Join(values, ",")
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		value = string(b)
+	}
+
+	// control characters aren't valid in header values
+	value = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, value)
+	return value, value != ""
+}
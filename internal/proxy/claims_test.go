@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestParseClaimHeaders(t *testing.T) {
+	claimHeaders, err := parseClaimHeaders(map[string]string{"employee_id": "x-employee-id", "name": ""})
+	testutil.Ok(t, err)
+	testutil.Equal(t, map[string]string{"employee_id": "X-Employee-Id", "name": ""}, claimHeaders)
+
+	testCases := []map[string]string{
+		{"employee_id": "X Employee Id"},
+		{"email": "x-forwarded-email"},
+		{"employee_id": "Authorization"},
+		{"employee_id": "X-Employee", "department": "x-employee"},
+	}
+	for _, tc := range testCases {
+		_, err := parseClaimHeaders(tc)
+		testutil.NotEqual(t, nil, err)
+	}
+}
+
+func TestClaimHeaderValue(t *testing.T) {
+	testCases := []struct {
+		claim    interface{}
+		expected string
+		ok       bool
+	}{
+		{claim: nil},
+		{claim: ""},
+		{claim: "Engineering", expected: "Engineering", ok: true},
+		{claim: float64(12345678), expected: "12345678", ok: true},
+		{claim: 1.5, expected: "1.5", ok: true},
+		{claim: true, expected: "true", ok: true},
+		{claim: []interface{}{"a", float64(1), nil}, expected: "a,1", ok: true},
+		{claim: map[string]interface{}{"floor": float64(4)}, expected: `{"floor":4}`, ok: true},
+		{claim: "line\r\nX-Injected: true", expected: "lineX-Injected: true", ok: true},
+	}
+	for _, tc := range testCases {
+		value, ok := claimHeaderValue(tc.claim)
+		testutil.Equal(t, tc.expected, value)
+		testutil.Equal(t, tc.ok, ok)
+	}
+}
+
+func TestAuthenticateClaimHeaders(t *testing.T) {
+	session := testSession()
+	session.Claims = map[string]interface{}{
+		"name":        "Michael Bland",
+		"family_name": "Bland",
+		"employee_id": float64(1234),
+	}
+	store := &sessions.MockSessionStore{Session: session}
+	proxy, close := testNewOAuthProxy(t,
+		setSessionStore(store),
+		SetUpstreamConfig(&UpstreamConfig{
+			ClaimHeaders: map[string]string{"employee_id": "X-Employee-Id", "family_name": ""},
+		}),
+	)
+	defer close()
+
+	req := httptest.NewRequest("GET", "https://localhost/", nil)
+	req.Header.Set("X-Forwarded-Given-Name", "spoofed")
+	req.Header.Set("X-Forwarded-Family-Name", "sent by the client")
+	err := proxy.Authenticate(httptest.NewRecorder(), req)
+	testutil.Ok(t, err)
+
+	testutil.Equal(t, "Michael Bland", req.Header.Get("X-Forwarded-Name"))
+	testutil.Equal(t, "1234", req.Header.Get("X-Employee-Id"))
+	// claims the session doesn't have are never taken from the client
+	testutil.Equal(t, "", req.Header.Get("X-Forwarded-Given-Name"))
+	// unless the upstream doesn't use the default claim
+	testutil.Equal(t, "sent by the client", req.Header.Get("X-Forwarded-Family-Name"))
+}
+
+func TestMinimizeClaims(t *testing.T) {
+	claims := sessionClaims([]*UpstreamConfig{
+		{ClaimHeaders: map[string]string{"employee_id": "X-Employee-Id", "family_name": ""}},
+		{ClaimHeaders: map[string]string{"department": "X-Dept"}},
+	})
+	testutil.Equal(t, map[string]struct{}{
+		"name":        {},
+		"given_name":  {},
+		"family_name": {},
+		"employee_id": {},
+		"department":  {},
+	}, claims)
+
+	p := &OAuthProxy{sessionClaims: claims}
+	session := &sessions.SessionState{Claims: map[string]interface{}{
+		"name":        "Jane Doe",
+		"employee_id": float64(12345),
+		"picture":     "https://example.com/jane.png",
+		"groups":      []interface{}{"engineering"},
+	}}
+	p.minimizeClaims(session)
+	testutil.Equal(t, map[string]interface{}{"name": "Jane Doe", "employee_id": float64(12345)}, session.Claims)
+
+	session = &sessions.SessionState{Claims: map[string]interface{}{"picture": "https://example.com/jane.png"}}
+	p.minimizeClaims(session)
+	testutil.Equal(t, map[string]interface{}(nil), session.Claims)
+}
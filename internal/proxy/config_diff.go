@@ -0,0 +1,193 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// redacted replaces the values of secrets in config diffs.
+const redacted = "[redacted]"
+
+// sensitiveUpstreamFields are the fields of an upstream's config whose values may hold secrets,
+// e.g. a token injected into the requests sent upstream. Their keys are logged, but not their
+// values.
+var sensitiveUpstreamFields = map[string]bool{
+	"headeroverrides":      true,
+	"injectrequestheaders": true,
+	"healthcheck":          true,
+	"mirror":               true,
+}
+
+// upstreamConfigDiff is how the upstream configs changed when they were (re)loaded. Upstreams
+// are named by their cluster, service, and `from` host.
+type upstreamConfigDiff struct {
+	Added   []string          `json:"added,omitempty"`
+	Removed []string          `json:"removed,omitempty"`
+	Changed []*upstreamChange `json:"changed,omitempty"`
+}
+
+// upstreamChange is how the config of an upstream changed.
+type upstreamChange struct {
+	Upstream string         `json:"upstream"`
+	Fields   []*fieldChange `json:"fields"`
+}
+
+// fieldChange is how a field of the config of an upstream changed, with secrets redacted.
+type fieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// diffUpstreamConfigs returns how the current upstream configs differ from the previous ones.
+func diffUpstreamConfigs(previous, current []*UpstreamConfig) *upstreamConfigDiff {
+	before := upstreamFieldsByKey(previous)
+	after := upstreamFieldsByKey(current)
+
+	diff := &upstreamConfigDiff{}
+	for key, fields := range after {
+		old, ok := before[key]
+		if !ok {
+			diff.Added = append(diff.Added, key)
+			continue
+		}
+		if change := diffUpstreamFields(key, old, fields); change != nil {
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			diff.Removed = append(diff.Removed, key)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Upstream < diff.Changed[j].Upstream })
+	return diff
+}
+
+// empty returns true if nothing changed.
+func (d *upstreamConfigDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// upstreamFieldsByKey returns the fields of the snapshot of each upstream, keyed by its cluster,
+// service, and `from` host, see upstreamSnapshot.
+func upstreamFieldsByKey(configs []*UpstreamConfig) map[string]map[string]interface{} {
+	fields := make(map[string]map[string]interface{}, len(configs))
+	for _, uc := range configs {
+		data, err := upstreamSnapshot(uc)
+		if err != nil {
+			continue
+		}
+		snapshot := struct {
+			Upstream          map[string]interface{} `yaml:"upstream"`
+			SkipAuthRegex     []string               `yaml:"skip_auth_regex"`
+			SigningKey        bool                   `yaml:"signing_key"`
+			BucketCredentials bool                   `yaml:"bucket_credentials"`
+		}{}
+		if err := yaml.Unmarshal(data, &snapshot); err != nil {
+			continue
+		}
+		upstream := snapshot.Upstream
+		if upstream == nil {
+			upstream = map[string]interface{}{}
+		}
+		upstream["skip_auth_regex"] = snapshot.SkipAuthRegex
+		upstream["signing_key"] = snapshot.SigningKey
+		upstream["bucket_credentials"] = snapshot.BucketCredentials
+		fields[upstreamKey(uc)] = upstream
+	}
+	return fields
+}
+
+// diffUpstreamFields returns how the fields of an upstream changed, or nil if none did.
+func diffUpstreamFields(key string, before, after map[string]interface{}) *upstreamChange {
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+
+	change := &upstreamChange{Upstream: key}
+	for name := range names {
+		if reflect.DeepEqual(before[name], after[name]) {
+			continue
+		}
+		change.Fields = append(change.Fields, &fieldChange{
+			Field: name,
+			From:  redactValue(before[name], sensitiveUpstreamFields[name]),
+			To:    redactValue(after[name], sensitiveUpstreamFields[name]),
+		})
+	}
+	if len(change.Fields) == 0 {
+		return nil
+	}
+	sort.Slice(change.Fields, func(i, j int) bool { return change.Fields[i].Field < change.Fields[j].Field })
+	return change
+}
+
+// redactValue returns a value that can be logged as json: its maps are keyed by strings, the
+// credentials of urls are redacted, and, if the value is sensitive, every value that isn't a
+// map key is too.
+func redactValue(value interface{}, sensitive bool) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case map[interface{}]interface{}:
+		redactedMap := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			redactedMap[fmt.Sprint(k)] = redactValue(item, sensitive)
+		}
+		return redactedMap
+	case []interface{}:
+		redactedSlice := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			redactedSlice = append(redactedSlice, redactValue(item, sensitive))
+		}
+		return redactedSlice
+	case string:
+		if sensitive {
+			return redacted
+		}
+		return redactURLCredentials(v)
+	default:
+		if sensitive {
+			return redacted
+		}
+		return v
+	}
+}
+
+// redactURLCredentials returns a url with the password of its user info redacted, or any other
+// string unchanged.
+func redactURLCredentials(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "redacted")
+	}
+	return u.String()
+}
+
+// logUpstreamConfigDiff logs how the upstream configs changed when they were (re)loaded.
+func logUpstreamConfigDiff(previous, current []*UpstreamConfig) {
+	diff := diffUpstreamConfigs(previous, current)
+	logger := log.NewLogEntry().WithConfigDiff(diff)
+	if diff.empty() {
+		logger.Info("upstream configs reloaded without changes")
+		return
+	}
+	logger.Info(fmt.Sprintf("upstream configs loaded: %d added, %d removed, %d changed",
+		len(diff.Added), len(diff.Removed), len(diff.Changed)))
+}
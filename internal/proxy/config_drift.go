@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+	"gopkg.in/yaml.v2"
+)
+
+// configDriftDetector periodically re-reads the upstream configs file and compares it to the
+// configs the proxy is running with, for deployments that promote config changes explicitly by
+// restarting the proxy. Drift is reported, but never applied.
+type configDriftDetector struct {
+	opts     *Options
+	interval time.Duration
+
+	// running are the digests of the running upstreams, and unresolved the template variables
+	// the running configs reference without a value
+	running    map[string]string
+	unresolved map[string]bool
+
+	StatsdClient *statsd.Client
+
+	lastReport string
+}
+
+// configDrift is how the upstream configs file differs from the running configs. Upstreams are
+// named by their cluster, service, and `from` host.
+type configDrift struct {
+	Added      []string
+	Removed    []string
+	Changed    []string
+	Unresolved []string
+}
+
+// newConfigDriftDetector returns the configDriftDetector of the running upstream configs, or nil
+// if drift detection is disabled.
+func newConfigDriftDetector(opts *Options) *configDriftDetector {
+	// the changes of upstream configs read from git or fetched from a url are applied, rather
+	// than drifted
+	if opts.ConfigDriftInterval <= 0 || opts.UpstreamConfigsFile == "" || opts.upstreamConfigSource() != nil {
+		return nil
+	}
+	return &configDriftDetector{
+		opts:         opts,
+		interval:     opts.ConfigDriftInterval,
+		running:      upstreamDigests(opts.upstreamConfigs),
+		unresolved:   unresolvedTemplateVars(opts.upstreamConfigsRaw, opts.clusters(), opts.templateVars()),
+		StatsdClient: opts.StatsdClient,
+	}
+}
+
+// run checks for drift at the configured interval, forever.
+func (d *configDriftDetector) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.check()
+	}
+}
+
+// check compares the upstream configs file to the running configs, and reports the result. Drift
+// is logged when it first appears or changes, rather than at every check.
+func (d *configDriftDetector) check() {
+	logger := log.NewLogEntry()
+
+	drift, err := d.detect()
+	if err != nil {
+		d.StatsdClient.Incr("config.drift_check", []string{"result:error"}, 1.0)
+		if report := err.Error(); report != d.lastReport {
+			d.lastReport = report
+			logger.Error(err, "error checking upstream configs for drift; the running configs are unchanged")
+		}
+		return
+	}
+
+	result := "result:in_sync"
+	if drift.drifted() {
+		result = "result:drift"
+	}
+	d.StatsdClient.Incr("config.drift_check", []string{result}, 1.0)
+	d.StatsdClient.Gauge("config.drift", float64(drift.count()), nil, 1.0)
+
+	report := drift.String()
+	if report == d.lastReport {
+		return
+	}
+	d.lastReport = report
+	if drift.drifted() {
+		logger.Warn(fmt.Sprintf("upstream configs file has drifted from the running configs, restart to apply: %s", report))
+	} else {
+		logger.Info("upstream configs file matches the running configs again")
+	}
+}
+
+// detect re-reads and parses the upstream configs file, and compares it to the running configs.
+func (d *configDriftDetector) detect() (*configDrift, error) {
+	raw, err := ioutil.ReadFile(d.opts.UpstreamConfigsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading upstream configs file: %s", err)
+	}
+	configs, err := d.opts.loadUpstreamConfigs(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing upstream configs file %s", err)
+	}
+
+	drift := &configDrift{}
+	current := upstreamDigests(configs)
+	for key, digest := range current {
+		running, ok := d.running[key]
+		switch {
+		case !ok:
+			drift.Added = append(drift.Added, key)
+		case running != digest:
+			drift.Changed = append(drift.Changed, key)
+		}
+	}
+	for key := range d.running {
+		if _, ok := current[key]; !ok {
+			drift.Removed = append(drift.Removed, key)
+		}
+	}
+	for name := range unresolvedTemplateVars(raw, d.opts.clusters(), d.opts.templateVars()) {
+		if !d.unresolved[name] {
+			drift.Unresolved = append(drift.Unresolved, name)
+		}
+	}
+
+	sort.Strings(drift.Added)
+	sort.Strings(drift.Removed)
+	sort.Strings(drift.Changed)
+	sort.Strings(drift.Unresolved)
+	return drift, nil
+}
+
+func (c *configDrift) drifted() bool {
+	return c.count() != 0
+}
+
+func (c *configDrift) count() int {
+	return len(c.Added) + len(c.Removed) + len(c.Changed) + len(c.Unresolved)
+}
+
+// String implements the fmt.Stringer interface.
+func (c *configDrift) String() string {
+	parts := []string{}
+	for _, p := range []struct {
+		name  string
+		items []string
+	}{
+		{"added", c.Added},
+		{"removed", c.Removed},
+		{"changed", c.Changed},
+		{"unresolved template variables", c.Unresolved},
+	} {
+		if len(p.items) != 0 {
+			parts = append(parts, fmt.Sprintf("%s: %s", p.name, strings.Join(p.items, ", ")))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// upstreamDigests returns digests of the configs of each upstream, keyed by its cluster, service,
+// and `from` host, see upstreamSnapshot.
+func upstreamDigests(configs []*UpstreamConfig) map[string]string {
+	digests := make(map[string]string, len(configs))
+	for _, uc := range configs {
+		data, err := upstreamSnapshot(uc)
+		if err != nil {
+			// the configs were unmarshaled from yaml, so they can be marshaled again
+			continue
+		}
+		sum := sha256.Sum256(data)
+		digests[upstreamKey(uc)] = hex.EncodeToString(sum[:])
+	}
+	return digests
+}
+
+// upstreamKey returns the key of an upstream: its cluster, service, and `from` host.
+func upstreamKey(uc *UpstreamConfig) string {
+	return fmt.Sprintf("%s/%s/%s", uc.Cluster, uc.Service, uc.RouteConfig.From)
+}
+
+// upstreamSnapshot returns the yaml of the config of an upstream. It covers the templated route
+// of the upstream and its options as parsed, including the defaults they inherit, and whether
+// it's given a signing key or bucket credentials, without their values.
+func upstreamSnapshot(uc *UpstreamConfig) ([]byte, error) {
+	// parsing clears the route's options, so the parsed fields are snapshotted instead, without
+	// the values generated from them
+	parsed := *uc
+	parsed.Route = nil
+	parsed.HMACAuth = nil
+	parsed.BucketCredentials = nil
+	parsed.SkipAuthCompiledRegex = nil
+	parsed.TLSRootCAs = nil
+	skipAuthRegex := make([]string, 0, len(uc.SkipAuthCompiledRegex))
+	for _, re := range uc.SkipAuthCompiledRegex {
+		skipAuthRegex = append(skipAuthRegex, re.String())
+	}
+
+	return yaml.Marshal(struct {
+		Upstream          UpstreamConfig `yaml:"upstream"`
+		SkipAuthRegex     []string       `yaml:"skip_auth_regex"`
+		SigningKey        bool           `yaml:"signing_key"`
+		BucketCredentials bool           `yaml:"bucket_credentials"`
+	}{
+		Upstream:          parsed,
+		SkipAuthRegex:     skipAuthRegex,
+		SigningKey:        uc.HMACAuth != nil,
+		BucketCredentials: uc.BucketCredentials != nil,
+	})
+}
+
+// unresolvedTemplateVars returns the template variables the upstream configs file references
+// that aren't set for one of the clusters, e.g. a secret whose SSO_CONFIG_ variable is missing.
+func unresolvedTemplateVars(raw []byte, clusters []string, configVars map[string]string) map[string]bool {
+	// a file whose environments can't be parsed fails to load, which is reported instead
+	environments, _ := parseEnvironments(raw)
+
+	unresolved := map[string]bool{}
+	for _, cluster := range clusters {
+		vars := environmentVars(environments[cluster], clusterTemplateVars(configVars, cluster, len(clusters) > 1))
+		for _, name := range templateVarNames(resolveTemplates(raw, vars)) {
+			unresolved[name] = true
+		}
+	}
+	return unresolved
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigDriftDetector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sso-config-drift")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	running := `
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: foo-internal.{{cluster}}.{{root_domain}}
+    options:
+      allowed_groups:
+        - dev
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: bar-internal.{{cluster}}.{{root_domain}}
+`
+	file := filepath.Join(dir, "upstream_configs.yml")
+	writeConfigs := func(configs string) {
+		if err := ioutil.WriteFile(file, []byte(configs), 0600); err != nil {
+			t.Fatalf("unexpected error writing upstream configs: %v", err)
+		}
+	}
+	writeConfigs(running)
+
+	opts := testOptions()
+	opts.UpstreamConfigsFile = file
+	opts.ConfigDriftInterval = time.Minute
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("unexpected error validating options: %v", err)
+	}
+	detector := newConfigDriftDetector(opts)
+	if detector == nil {
+		t.Fatalf("expected a drift detector")
+	}
+
+	testCases := []struct {
+		name     string
+		configs  string
+		expected *configDrift
+		err      bool
+	}{
+		{
+			name:     "unchanged configs",
+			configs:  running,
+			expected: &configDrift{},
+		},
+		{
+			name: "formatting and comments don't drift",
+			configs: `
+# the running services
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to:   bar-internal.{{cluster}}.{{root_domain}}
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: foo-internal.{{cluster}}.{{root_domain}}
+    options:
+      allowed_groups: [dev]
+`,
+			expected: &configDrift{},
+		},
+		{
+			name: "added, removed, and changed upstreams",
+			configs: `
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: foo-internal.{{cluster}}.{{root_domain}}
+    options:
+      allowed_groups:
+        - admins
+- service: baz
+  default:
+    from: baz.{{cluster}}.{{root_domain}}
+    to: baz-internal.{{cluster}}.{{root_domain}}
+    options:
+      header_overrides:
+        X-Api-Key: "{{baz_api_key}}"
+`,
+			expected: &configDrift{
+				Added:      []string{"sso/baz/baz.sso.dev"},
+				Removed:    []string{"sso/bar/bar.sso.dev"},
+				Changed:    []string{"sso/foo/foo.sso.dev"},
+				Unresolved: []string{"baz_api_key"},
+			},
+		},
+		{
+			name:    "invalid configs",
+			configs: "- service: foo\n  default:\n    from: [",
+			err:     true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			writeConfigs(tc.configs)
+			drift, err := detector.detect()
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tc.expected, drift) {
+				t.Errorf("expected drift %#v, got %#v", tc.expected, drift)
+			}
+		})
+	}
+}
+
+func TestConfigDriftDetectorDisabled(t *testing.T) {
+	opts := testOptions()
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("unexpected error validating options: %v", err)
+	}
+	if detector := newConfigDriftDetector(opts); detector != nil {
+		t.Errorf("expected no drift detector without an interval")
+	}
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// upstreamEnvironment maps to the yaml config fields of an environment's overlay of an upstream
+// configs file, which applies when the upstreams of the cluster of the same name are loaded:
+// * vars - template variables of the environment, overridden by SSO_CONFIG_ environment variables
+// * defaults - defaults of the environment, overriding those of the file, see upstreamDefaults
+type upstreamEnvironment struct {
+	Vars     map[string]string `yaml:"vars"`
+	Defaults *upstreamDefaults `yaml:"defaults"`
+}
+
+// parseEnvironments returns the environments of an upstream configs file. They're parsed before
+// the file is templated, since they set template variables, so template variables in them are
+// never substituted.
+func parseEnvironments(raw []byte) (map[string]*upstreamEnvironment, error) {
+	// as when migrating, template variables are replaced with placeholders so that the file is
+	// valid yaml
+	placeholders := templateVar.ReplaceAll(raw, []byte("template"))
+	version, err := upstreamConfigsVersion(placeholders)
+	if err != nil || version == 1 {
+		return nil, err
+	}
+
+	file := struct {
+		Environments map[string]*upstreamEnvironment `yaml:"environments"`
+	}{}
+	if err := yaml.Unmarshal(placeholders, &file); err != nil {
+		return nil, &ErrParsingConfig{
+			Message: "failed to parse yaml",
+			Err:     err,
+		}
+	}
+	return file.Environments, nil
+}
+
+// environmentVars returns the template variables of an environment, overridden by configVars.
+func environmentVars(environment *upstreamEnvironment, configVars map[string]string) map[string]string {
+	if environment == nil || len(environment.Vars) == 0 {
+		return configVars
+	}
+
+	vars := make(map[string]string, len(environment.Vars)+len(configVars))
+	for k, v := range environment.Vars {
+		vars[strings.ToLower(k)] = v
+	}
+	for k, v := range configVars {
+		vars[k] = v
+	}
+	return vars
+}
+
+// applyEnvironmentDefaults overrides the defaults of an upstream configs file with those of the
+// environment of the cluster being loaded.
+func applyEnvironmentDefaults(file *upstreamConfigsFile, cluster string) error {
+	environment, ok := file.Environments[cluster]
+	if !ok || environment == nil || environment.Defaults == nil {
+		return nil
+	}
+
+	if file.Defaults == nil {
+		file.Defaults = &upstreamDefaults{}
+	}
+	options, err := mergeOptionsConfigs(file.Defaults.Options, environment.Defaults.Options)
+	if err != nil {
+		return &ErrParsingConfig{
+			Message: fmt.Sprintf("invalid defaults of environment %s", cluster),
+			Err:     err,
+		}
+	}
+	file.Defaults.Options = options
+	return nil
+}
+
+// validateEnvironments loads the upstream configs of every environment of an upstream configs
+// file, not only those served, so that a route that only resolves in some environments is
+// caught before it's promoted to the others.
+func validateEnvironments(configDir string, raw []byte, scheme string, configVars map[string]string, defaultOpts *OptionsConfig) error {
+	environments, err := parseEnvironments(raw)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(environments))
+	for name := range environments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		vars := clusterTemplateVars(configVars, name, true)
+		unresolved := templateVarNames(resolveTemplates(raw, environmentVars(environments[name], vars)))
+		if len(unresolved) != 0 {
+			return &ErrParsingConfig{
+				Message: fmt.Sprintf("template variables of environment %s are not set: %s",
+					name, strings.Join(unresolved, ", ")),
+			}
+		}
+
+		if _, err := loadServiceConfigsFrom(configDir, raw, name, scheme, vars, defaultOpts); err != nil {
+			return &ErrParsingConfig{
+				Message: fmt.Sprintf("unable to load upstream configs of environment %s", name),
+				Err:     err,
+			}
+		}
+	}
+	return nil
+}
+
+// templateVarNames returns the sorted names of the template variables left in a templated file.
+func templateVarNames(templated []byte) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, match := range templateVar.FindAll(templated, -1) {
+		name := strings.TrimSpace(strings.Trim(string(match), "{}"))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
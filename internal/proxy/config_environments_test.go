@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestUpstreamConfigEnvironments(t *testing.T) {
+	raw := []byte(`
+version: 2
+defaults:
+  options:
+    allowed_groups:
+      - engineering
+environments:
+  dev:
+    vars:
+      root_domain: dev.example.com
+    defaults:
+      options:
+        allowed_groups:
+          - everyone
+  prod:
+    vars:
+      root_domain: example.com
+      replicas: "3"
+services:
+  - service: foo
+    default:
+      from: foo.sso.{{root_domain}}
+      to: foo.{{root_domain}}
+      options:
+        header_overrides:
+          X-Environment: "{{cluster}}"
+`)
+
+	testCases := []struct {
+		name       string
+		cluster    string
+		configVars map[string]string
+		wantTo     string
+		wantGroups []string
+	}{
+		{
+			name:       "dev overlay",
+			cluster:    "dev",
+			configVars: map[string]string{"cluster": "dev"},
+			wantTo:     "foo.dev.example.com",
+			wantGroups: []string{"everyone"},
+		},
+		{
+			name:       "prod overlay",
+			cluster:    "prod",
+			configVars: map[string]string{"cluster": "prod"},
+			wantTo:     "foo.example.com",
+			wantGroups: []string{"engineering"},
+		},
+		{
+			name:       "environment variables override the overlay",
+			cluster:    "prod",
+			configVars: map[string]string{"cluster": "prod", "root_domain": "example.org"},
+			wantTo:     "foo.example.org",
+			wantGroups: []string{"engineering"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configs, err := loadServiceConfigs(raw, tc.cluster, "http", tc.configVars, nil)
+			testutil.Ok(t, err)
+			testutil.Equal(t, 1, len(configs))
+			testutil.Equal(t, tc.wantTo, configs[0].RouteConfig.To)
+			testutil.Equal(t, tc.wantGroups, configs[0].AllowedGroups)
+			testutil.Equal(t, tc.cluster, configs[0].HeaderOverrides["X-Environment"])
+		})
+	}
+
+	testutil.Ok(t, validateEnvironments(".", raw, "http", map[string]string{}, nil))
+}
+
+func TestValidateEnvironments(t *testing.T) {
+	testCases := []struct {
+		name   string
+		raw    string
+		errMsg string
+	}{
+		{
+			name: "without environments",
+			raw: `
+- service: foo
+  default:
+    from: foo.{{root_domain}}
+    to: foo.internal
+`,
+		},
+		{
+			name: "unresolved template variable",
+			raw: `
+version: 2
+environments:
+  dev:
+    vars:
+      root_domain: dev.example.com
+  prod:
+    vars:
+      domain: example.com
+services:
+  - service: foo
+    default:
+      from: foo.{{root_domain}}
+      to: foo.internal
+`,
+			errMsg: "template variables of environment prod are not set: root_domain",
+		},
+		{
+			name: "route that doesn't load",
+			raw: `
+version: 2
+environments:
+  dev:
+    vars:
+      type: simple
+  prod:
+    vars:
+      type: unknown
+services:
+  - service: foo
+    default:
+      from: foo.example.com
+      to: foo.internal
+      type: "{{type}}"
+`,
+			errMsg: `unable to load upstream configs of environment prod error=unknown routing config type "unknown"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateEnvironments(".", []byte(tc.raw), "http", map[string]string{}, nil)
+			if tc.errMsg == "" {
+				testutil.Ok(t, err)
+				return
+			}
+			testutil.NotEqual(t, nil, err)
+			testutil.Equal(t, tc.errMsg, err.Error())
+		})
+	}
+}
+
+func TestUnresolvedTemplateVarsEnvironments(t *testing.T) {
+	raw := []byte(`
+version: 2
+environments:
+  prod:
+    vars:
+      root_domain: example.com
+services:
+  - service: foo
+    default:
+      from: foo.{{root_domain}}
+      to: foo.{{secret_host}}
+`)
+	unresolved := unresolvedTemplateVars(raw, []string{"prod"}, map[string]string{})
+	testutil.Equal(t, map[string]bool{"secret_host": true}, unresolved)
+}
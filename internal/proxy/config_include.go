@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/imdario/mergo"
+)
+
+// upstreamDefaults maps to the defaults yaml config fields of an upstream configs file:
+// * options - options of every route of the file and the files it includes, overridden by each route's own options
+type upstreamDefaults struct {
+	Options *OptionsConfig `yaml:"options"`
+}
+
+// resolveIncludes returns the services of an upstream configs file, followed by those of the
+// files it includes, in order. Include paths are globs relative to dir, the directory of the
+// including file, and included files are templated with the same variables. The defaults of
+// a file apply to its own services and those of the files it includes, whose own defaults
+// override them in turn.
+func resolveIncludes(file *upstreamConfigsFile, dir string, configVars map[string]string, inherited *OptionsConfig, including map[string]bool) ([]*ServiceConfig, error) {
+	defaults := inherited
+	if file.Defaults != nil {
+		var err error
+		defaults, err = mergeOptionsConfigs(inherited, file.Defaults.Options)
+		if err != nil {
+			return nil, &ErrParsingConfig{Message: "invalid defaults", Err: err}
+		}
+	}
+
+	services := make([]*ServiceConfig, 0, len(file.Services))
+	for _, service := range file.Services {
+		service.defaults = defaults
+		services = append(services, service)
+	}
+
+	if dir == "" && len(file.Include) != 0 {
+		return nil, &ErrParsingConfig{Message: "include is only supported by upstream configs files read from disk"}
+	}
+	for _, include := range file.Include {
+		pattern := include
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, &ErrParsingConfig{Message: fmt.Sprintf("invalid include %q", include), Err: err}
+		}
+		if len(paths) == 0 && !hasGlobMeta(include) {
+			return nil, &ErrParsingConfig{Message: fmt.Sprintf("included file %q does not exist", include)}
+		}
+
+		for _, path := range paths {
+			included, err := includeServiceConfigs(path, configVars, defaults, including)
+			if err != nil {
+				return nil, err
+			}
+			services = append(services, included...)
+		}
+	}
+	return services, nil
+}
+
+// includeServiceConfigs returns the services of an included upstream configs file, and of the
+// files it includes in turn.
+func includeServiceConfigs(path string, configVars map[string]string, inherited *OptionsConfig, including map[string]bool) ([]*ServiceConfig, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, &ErrParsingConfig{Message: fmt.Sprintf("unable to include %s", path), Err: err}
+	}
+	if including[abs] {
+		return nil, &ErrParsingConfig{Message: fmt.Sprintf("%s includes itself", path)}
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &ErrParsingConfig{Message: fmt.Sprintf("unable to include %s", path), Err: err}
+	}
+	file, err := parseUpstreamConfigsFile(resolveTemplates(raw, configVars))
+	if err != nil {
+		return nil, &ErrParsingConfig{Message: fmt.Sprintf("unable to include %s", path), Err: err}
+	}
+	if len(file.Environments) != 0 {
+		return nil, &ErrParsingConfig{Message: fmt.Sprintf("%s sets environments, which only the upstream configs file may set", path)}
+	}
+
+	including[abs] = true
+	defer delete(including, abs)
+	return resolveIncludes(file, filepath.Dir(path), configVars, inherited, including)
+}
+
+// mergeOptionsConfigs returns the options of base overridden by those of override, without
+// modifying either.
+func mergeOptionsConfigs(base, override *OptionsConfig) (*OptionsConfig, error) {
+	if override == nil {
+		return base, nil
+	}
+
+	dst := &OptionsConfig{}
+	if base != nil {
+		if err := mergo.Merge(dst, *base, mergo.WithOverride); err != nil {
+			return nil, err
+		}
+	}
+	if err := mergo.Merge(dst, *override, mergo.WithOverride); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+func hasGlobMeta(path string) bool {
+	for _, c := range path {
+		switch c {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestUpstreamConfigIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sso-config-include")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile := func(name, contents string) {
+		path := filepath.Join(dir, name)
+		testutil.Ok(t, os.MkdirAll(filepath.Dir(path), 0700))
+		testutil.Ok(t, ioutil.WriteFile(path, []byte(contents), 0600))
+	}
+	writeFile("services/bar.yml", `
+version: 2
+defaults:
+  options:
+    timeout: 5s
+services:
+  - service: bar
+    default:
+      from: bar.sso.{{root_domain}}
+      to: bar.{{root_domain}}
+`)
+	writeFile("services/baz.yml", `
+- service: baz
+  default:
+    from: baz.sso.{{root_domain}}
+    to: baz.{{root_domain}}
+    options:
+      allowed_groups:
+        - admins
+`)
+
+	raw := []byte(`
+version: 2
+include:
+  - services/*.yml
+defaults:
+  options:
+    allowed_groups:
+      - engineering
+    header_overrides:
+      X-Frame-Options: DENY
+    timeout: 2s
+services:
+  - service: foo
+    default:
+      from: foo.sso.{{root_domain}}
+      to: foo.{{root_domain}}
+      options:
+        header_overrides:
+          Cache-Control: no-store
+      extra_routes:
+        - from: foo-api.sso.{{root_domain}}
+          to: foo-api.{{root_domain}}
+`)
+	defaultOpts := &OptionsConfig{
+		AllowedGroups: []string{"everyone"},
+		Timeout:       time.Second,
+		ResetDeadline: time.Minute,
+	}
+	configs, err := loadClusterServiceConfigs(dir, raw, []string{"prod"}, "http",
+		map[string]string{"root_domain": "example.com"}, defaultOpts)
+	testutil.Ok(t, err)
+
+	byService := map[string]*UpstreamConfig{}
+	for _, config := range configs {
+		if config.Service == "foo" && config.RouteConfig.From != "foo.sso.example.com" {
+			byService["foo-api"] = config
+			continue
+		}
+		byService[config.Service] = config
+	}
+	testutil.Equal(t, 4, len(byService))
+
+	// the defaults of the file override those of the environment, and are overridden by routes
+	foo := byService["foo"]
+	testutil.Equal(t, []string{"engineering"}, foo.AllowedGroups)
+	testutil.Equal(t, 2*time.Second, foo.Timeout)
+	testutil.Equal(t, time.Minute, foo.ResetDeadline)
+	testutil.Equal(t, map[string]string{"X-Frame-Options": "DENY", "Cache-Control": "no-store"}, foo.HeaderOverrides)
+	testutil.Equal(t, []string{"engineering"}, byService["foo-api"].AllowedGroups)
+
+	// included files inherit the defaults of the including file, and may override them
+	bar := byService["bar"]
+	testutil.Equal(t, "bar.example.com", bar.RouteConfig.To)
+	testutil.Equal(t, []string{"engineering"}, bar.AllowedGroups)
+	testutil.Equal(t, 5*time.Second, bar.Timeout)
+	testutil.Equal(t, []string{"admins"}, byService["baz"].AllowedGroups)
+	testutil.Equal(t, 2*time.Second, byService["baz"].Timeout)
+
+	// the environment's defaults are unchanged
+	testutil.Equal(t, []string{"everyone"}, defaultOpts.AllowedGroups)
+	testutil.Equal(t, time.Second, defaultOpts.Timeout)
+}
+
+func TestUpstreamConfigIncludeErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sso-config-include")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(dir, "loop.yml"), []byte(`
+version: 2
+include:
+  - loop.yml
+`), 0600))
+
+	testCases := []struct {
+		name   string
+		raw    string
+		errMsg string
+	}{
+		{
+			name:   "missing file",
+			raw:    "version: 2\ninclude:\n  - missing.yml\n",
+			errMsg: `included file "missing.yml" does not exist`,
+		},
+		{
+			name: "unmatched glob",
+			raw:  "version: 2\ninclude:\n  - services/*.yml\n",
+		},
+		{
+			name:   "include cycle",
+			raw:    "version: 2\ninclude:\n  - loop.yml\n",
+			errMsg: filepath.Join(dir, "loop.yml") + " includes itself",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadServiceConfigsFrom(dir, []byte(tc.raw), "prod", "http", nil, nil)
+			if tc.errMsg == "" {
+				testutil.Ok(t, err)
+				return
+			}
+			testutil.NotEqual(t, nil, err)
+			testutil.Equal(t, tc.errMsg, err.Error())
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// configMetricsInterval is the interval at which the gauges of the upstream configs are emitted.
+const configMetricsInterval = 30 * time.Second
+
+// configMetrics reports when the running upstream configs were loaded, how many routes they
+// have, and whether reloading them succeeds, so that dashboards can alert on stale configs and
+// failed rollouts. Gauges are emitted periodically, since the age of the configs grows between
+// loads. The metrics of a tenant's upstream configs are tagged with the tenant, so that they
+// don't mix with those of the proxy's own.
+type configMetrics struct {
+	StatsdClient *statsd.Client
+	tags         []string
+
+	mu       sync.Mutex
+	loadedAt time.Time
+	routes   int
+
+	now  func() time.Time
+	stop chan struct{}
+}
+
+// newConfigMetrics returns the configMetrics of the upstream configs loaded when the proxy
+// starts.
+func newConfigMetrics(opts *Options) *configMetrics {
+	m := &configMetrics{
+		StatsdClient: opts.StatsdClient,
+		now:          time.Now,
+		stop:         make(chan struct{}),
+	}
+	if opts.tenantName != "" {
+		m.tags = []string{"tenant:" + opts.tenantName}
+	}
+	m.loaded(opts.upstreamConfigs)
+	return m
+}
+
+// loaded records that upstream configs were loaded.
+func (m *configMetrics) loaded(configs []*UpstreamConfig) {
+	m.mu.Lock()
+	m.loadedAt = m.now()
+	m.routes = len(configs)
+	m.mu.Unlock()
+	m.emit()
+}
+
+// reloaded records the result of reloading the upstream configs.
+func (m *configMetrics) reloaded(configs []*UpstreamConfig, err error) {
+	if err != nil {
+		m.StatsdClient.Incr("config.reload", append([]string{"result:failure"}, m.tags...), 1.0)
+		return
+	}
+	m.StatsdClient.Incr("config.reload", append([]string{"result:success"}, m.tags...), 1.0)
+	m.loaded(configs)
+}
+
+// run emits the gauges of the upstream configs at the configured interval, until it's stopped.
+func (m *configMetrics) run() {
+	ticker := time.NewTicker(configMetricsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.emit()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Stop stops emitting the gauges of the upstream configs.
+func (m *configMetrics) Stop() {
+	close(m.stop)
+}
+
+// emit emits the gauges of the upstream configs.
+func (m *configMetrics) emit() {
+	m.mu.Lock()
+	loadedAt, routes := m.loadedAt, m.routes
+	m.mu.Unlock()
+
+	m.StatsdClient.Gauge("config.last_load_timestamp", float64(loadedAt.Unix()), m.tags, 1.0)
+	m.StatsdClient.Gauge("config.age_seconds", m.now().Sub(loadedAt).Seconds(), m.tags, 1.0)
+	m.StatsdClient.Gauge("config.upstream_routes", float64(routes), m.tags, 1.0)
+}
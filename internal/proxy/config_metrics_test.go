@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+func testStatsdPackets(t *testing.T) (*statsd.Client, func(n int) []string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	client, err := statsd.New(pc.LocalAddr().String())
+	testutil.Ok(t, err)
+
+	read := func(n int) []string {
+		packets := []string{}
+		buf := make([]byte, 1024)
+		for i := 0; i < n; i++ {
+			pc.SetReadDeadline(time.Now().Add(time.Second))
+			size, _, err := pc.ReadFrom(buf)
+			testutil.Ok(t, err)
+			packets = append(packets, string(buf[:size]))
+		}
+		return packets
+	}
+	return client, read, func() { pc.Close() }
+}
+
+func TestConfigMetrics(t *testing.T) {
+	client, read, closeStatsd := testStatsdPackets(t)
+	defer closeStatsd()
+
+	now := time.Unix(1500000000, 0)
+	metrics := &configMetrics{
+		StatsdClient: client,
+		now:          func() time.Time { return now },
+	}
+	configs := []*UpstreamConfig{{Service: "foo"}, {Service: "bar"}}
+
+	metrics.loaded(configs)
+	testutil.Equal(t, []string{
+		"config.last_load_timestamp:1500000000.000000|g",
+		"config.age_seconds:0.000000|g",
+		"config.upstream_routes:2.000000|g",
+	}, read(3))
+
+	// the configs age between loads
+	now = now.Add(time.Minute)
+	metrics.emit()
+	testutil.Equal(t, "config.age_seconds:60.000000|g", read(2)[1])
+	read(1)
+
+	// failed reloads keep the running configs
+	metrics.reloaded(nil, errors.New("invalid upstream configs"))
+	testutil.Equal(t, []string{"config.reload:1|c|#result:failure"}, read(1))
+
+	metrics.reloaded(configs[:1], nil)
+	testutil.Equal(t, []string{
+		"config.reload:1|c|#result:success",
+		"config.last_load_timestamp:1500000060.000000|g",
+		"config.age_seconds:0.000000|g",
+		"config.upstream_routes:1.000000|g",
+	}, read(4))
+}
+
+func TestConfigMetricsTenant(t *testing.T) {
+	client, read, closeStatsd := testStatsdPackets(t)
+	defer closeStatsd()
+
+	metrics := newConfigMetrics(&Options{StatsdClient: client, tenantName: "acme"})
+	metrics.now = func() time.Time { return time.Unix(1500000000, 0) }
+	read(3)
+
+	metrics.reloaded([]*UpstreamConfig{{Service: "foo"}}, nil)
+	testutil.Equal(t, []string{
+		"config.reload:1|c|#result:success,tenant:acme",
+		"config.last_load_timestamp:1500000000.000000|g|#tenant:acme",
+		"config.age_seconds:0.000000|g|#tenant:acme",
+		"config.upstream_routes:1.000000|g|#tenant:acme",
+	}, read(4))
+
+	// the gauges stop being emitted once the metrics are stopped
+	done := make(chan struct{})
+	go func() {
+		metrics.run()
+		close(done)
+	}()
+	metrics.Stop()
+	<-done
+}
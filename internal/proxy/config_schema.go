@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"reflect"
+
+	"github.com/buzzfeed/sso/internal/pkg/jsonschema"
+)
+
+// UpstreamConfigsSchema returns the JSON Schema of the current version of the upstream
+// configs file. Template variables are substituted before configs are validated, so values
+// that are templated are not checked against their type.
+func UpstreamConfigsSchema() *jsonschema.Schema {
+	service := jsonschema.Reflect(reflect.TypeOf(ServiceConfig{}), "yaml")
+	service.Required = []string{"service"}
+
+	if upstream, ok := service.AdditionalProperties.(*jsonschema.Schema); ok {
+		upstream.Properties["type"].Enum = []interface{}{simple, rewrite, staticFiles, bucket}
+		upstream.Properties["extra_routes"].Items.Properties["type"].Enum = upstream.Properties["type"].Enum
+
+		degradedModes := []interface{}{degradedAllow, degradedReadOnly, degradedDeny}
+		upstream.Properties["options"].Properties["degraded_mode"].Enum = degradedModes
+		upstream.Properties["extra_routes"].Items.Properties["options"].Properties["degraded_mode"].Enum = degradedModes
+	}
+
+	return &jsonschema.Schema{
+		Schema:      jsonschema.Draft,
+		Title:       "sso-proxy upstream configs",
+		Description: "Upstream configs files of an older version can be upgraded with `sso-proxy migrate-config`.",
+		Type:        "object",
+		Properties: map[string]*jsonschema.Schema{
+			"version":  {Type: "integer", Const: CurrentConfigVersion},
+			"include":  {Type: "array", Items: &jsonschema.Schema{Type: "string"}},
+			"defaults": jsonschema.Reflect(reflect.TypeOf(upstreamDefaults{}), "yaml"),
+			"environments": {
+				Type:                 "object",
+				AdditionalProperties: jsonschema.Reflect(reflect.TypeOf(upstreamEnvironment{}), "yaml"),
+			},
+			"services": {Type: "array", Items: service},
+		},
+		Required:             []string{"version"},
+		AdditionalProperties: false,
+	}
+}
+
+// OptionsSchema returns the JSON Schema of the environment variables Options are loaded from.
+func OptionsSchema() *jsonschema.Schema {
+	schema := jsonschema.Env(&Options{})
+	schema.Schema = jsonschema.Draft
+	schema.Title = "sso-proxy environment"
+	return schema
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/jsonschema"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestUpstreamConfigsSchema(t *testing.T) {
+	schema := UpstreamConfigsSchema()
+	testutil.Equal(t, CurrentConfigVersion, schema.Properties["version"].Const)
+
+	service := schema.Properties["services"].Items
+	testutil.Equal(t, &jsonschema.Schema{Type: "string"}, service.Properties["service"])
+
+	upstream := service.AdditionalProperties.(*jsonschema.Schema)
+	testutil.Equal(t, []interface{}{simple, rewrite, staticFiles, bucket}, upstream.Properties["type"].Enum)
+	testutil.Equal(t, "array", upstream.Properties["extra_routes"].Type)
+
+	options := upstream.Properties["options"]
+	testutil.Equal(t, &jsonschema.Schema{Type: "string", Pattern: jsonschema.DurationPattern}, options.Properties["timeout"])
+	testutil.Equal(t, &jsonschema.Schema{Type: "array", Items: &jsonschema.Schema{Type: "string"}}, options.Properties["allowed_groups"])
+	testutil.Equal(t, options.Properties["timeout"], schema.Properties["defaults"].Properties["options"].Properties["timeout"])
+	_, ok := upstream.Properties["Service"]
+	testutil.Assert(t, !ok, "expected untagged fields to be excluded")
+}
+
+func TestOptionsSchema(t *testing.T) {
+	schema := OptionsSchema()
+	testutil.Equal(t, &jsonschema.Schema{Type: "string", Pattern: jsonschema.IntPattern, Default: "4180"}, schema.Properties["PORT"])
+	testutil.Equal(t, &jsonschema.Schema{Type: "string", Pattern: jsonschema.DurationPattern, Default: "168h"}, schema.Properties["COOKIE_EXPIRE"])
+	_, ok := schema.Properties["StatsdClient"]
+	testutil.Assert(t, !ok, "expected fields without env vars to be excluded")
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// CurrentConfigVersion is the version of the upstream configs file format.
+//
+// Version 1 files are a bare list of services, and predate the version field. They are
+// still accepted, and can be upgraded with MigrateUpstreamConfigs.
+//
+// Version 2 files are a mapping with the `version` of the file, and its `services`.
+const CurrentConfigVersion = 2
+
+// upstreamConfigsFile is the format of versioned upstream configs files.
+type upstreamConfigsFile struct {
+	Version      int                             `yaml:"version"`
+	Include      []string                        `yaml:"include"`
+	Defaults     *upstreamDefaults               `yaml:"defaults"`
+	Environments map[string]*upstreamEnvironment `yaml:"environments"`
+	Services     []*ServiceConfig                `yaml:"services"`
+}
+
+// templateVar matches template variables, which are only substituted when configs are loaded.
+var templateVar = regexp.MustCompile(`{{\s*[\w.-]+\s*}}`)
+
+// upstreamConfigsVersion returns the version of an upstream configs file.
+func upstreamConfigsVersion(data []byte) (int, error) {
+	var document interface{}
+	err := yaml.Unmarshal(data, &document)
+	if err != nil {
+		return 0, &ErrParsingConfig{
+			Message: "failed to parse yaml",
+			Err:     err,
+		}
+	}
+
+	switch document := document.(type) {
+	case nil, []interface{}:
+		return 1, nil
+	case map[interface{}]interface{}:
+		version, ok := document["version"].(int)
+		if !ok {
+			return 0, &ErrParsingConfig{
+				Message: "missing or invalid `version` parameter",
+			}
+		}
+		if version < 2 || version > CurrentConfigVersion {
+			return 0, &ErrParsingConfig{
+				Message: fmt.Sprintf("unsupported upstream configs version %d, the latest version is %d",
+					version, CurrentConfigVersion),
+			}
+		}
+		return version, nil
+	default:
+		return 0, &ErrParsingConfig{
+			Message: "upstream configs must be a list of services or a mapping with a `version`",
+		}
+	}
+}
+
+// parseServiceConfigs parses the service configs of an upstream configs file of any
+// supported version.
+func parseServiceConfigs(data []byte) ([]*ServiceConfig, error) {
+	file, err := parseUpstreamConfigsFile(data)
+	if err != nil {
+		return nil, err
+	}
+	return file.Services, nil
+}
+
+// parseUpstreamConfigsFile parses an upstream configs file of any supported version. Version 1
+// files are parsed as a file of services, without includes or defaults.
+func parseUpstreamConfigsFile(data []byte) (*upstreamConfigsFile, error) {
+	version, err := upstreamConfigsVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &upstreamConfigsFile{Version: version}
+	switch version {
+	case 1:
+		err = yaml.Unmarshal(data, &file.Services)
+	default:
+		err = yaml.Unmarshal(data, file)
+	}
+	if err != nil {
+		return nil, &ErrParsingConfig{
+			Message: "failed to parse yaml",
+			Err:     err,
+		}
+	}
+	if file.Services == nil {
+		file.Services = make([]*ServiceConfig, 0)
+	}
+	for _, service := range file.Services {
+		if service == nil {
+			return nil, &ErrParsingConfig{Message: "empty service config"}
+		}
+	}
+
+	return file, nil
+}
+
+// MigrateUpstreamConfigs upgrades an upstream configs file to CurrentConfigVersion, returning
+// it unchanged if it's already current. Files are rewritten textually, rather than parsed and
+// reformatted, so that comments and template variables are preserved.
+func MigrateUpstreamConfigs(raw []byte) ([]byte, error) {
+	// template variables may not be valid yaml until they are substituted, e.g. in a value
+	// starting with `{{`, so they are replaced with placeholders to detect the version.
+	placeholders := templateVar.ReplaceAll(raw, []byte("template"))
+	version, err := upstreamConfigsVersion(placeholders)
+	if err != nil {
+		return nil, err
+	}
+
+	if version == 1 {
+		raw = migrateV1(raw)
+	}
+
+	if _, err := parseServiceConfigs(templateVar.ReplaceAll(raw, []byte("template"))); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// migrateV1 nests the list of services of a version 1 file under the `services` of a version 2 file.
+func migrateV1(raw []byte) []byte {
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "version: 2\nservices:\n")
+	for _, line := range bytes.Split(bytes.TrimRight(raw, "\n"), []byte("\n")) {
+		// drop document start markers, the services are nested in the new document
+		if bytes.Equal(bytes.TrimSpace(line), []byte("---")) {
+			continue
+		}
+		if len(bytes.TrimSpace(line)) != 0 {
+			out.WriteString("  ")
+		}
+		out.Write(line)
+		out.WriteString("\n")
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestUpstreamConfigsVersion(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      string
+		wantVersion int
+		wantErr     string
+	}{
+		{
+			name:        "empty file",
+			config:      "",
+			wantVersion: 1,
+		},
+		{
+			name:        "list of services",
+			config:      "- service: foo\n",
+			wantVersion: 1,
+		},
+		{
+			name:        "versioned file",
+			config:      "version: 2\nservices:\n- service: foo\n",
+			wantVersion: 2,
+		},
+		{
+			name:    "missing version",
+			config:  "services:\n- service: foo\n",
+			wantErr: "missing or invalid `version` parameter",
+		},
+		{
+			name:    "unsupported version",
+			config:  "version: 3\nservices: []\n",
+			wantErr: "unsupported upstream configs version 3, the latest version is 2",
+		},
+		{
+			name:    "scalar",
+			config:  "foo",
+			wantErr: "upstream configs must be a list of services or a mapping with a `version`",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			version, err := upstreamConfigsVersion([]byte(tc.config))
+			if tc.wantErr != "" {
+				testutil.NotEqual(t, nil, err)
+				testutil.Equal(t, tc.wantErr, err.Error())
+				return
+			}
+			testutil.Assert(t, err == nil, "unexpected error: %s", err)
+			testutil.Equal(t, tc.wantVersion, version)
+		})
+	}
+}
+
+func TestVersionedUpstreamConfigLoading(t *testing.T) {
+	templateVars := map[string]string{
+		"root_domain": "dev",
+		"cluster":     "sso",
+	}
+	upstreamConfigs, err := loadServiceConfigs([]byte(`
+version: 2
+services:
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: foo-internal.{{cluster}}.{{root_domain}}
+`), "sso", "http", templateVars, nil)
+	testutil.Assert(t, err == nil, "unexpected error loading configs: %s", err)
+	testutil.Equal(t, 1, len(upstreamConfigs))
+	testutil.Equal(t, "foo", upstreamConfigs[0].Service)
+	testutil.Equal(t, "http://foo.sso.dev", upstreamConfigs[0].Route.(*SimpleRoute).FromURL.String())
+}
+
+func TestMigrateUpstreamConfigs(t *testing.T) {
+	v1 := `---
+# the foo service
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: {{foo_upstream}}
+    options:
+      interstitial: |
+        Only use this service for testing.
+
+        It is not backed up.
+`
+	v2 := `version: 2
+services:
+  # the foo service
+  - service: foo
+    default:
+      from: foo.{{cluster}}.{{root_domain}}
+      to: {{foo_upstream}}
+      options:
+        interstitial: |
+          Only use this service for testing.
+
+          It is not backed up.
+`
+	migrated, err := MigrateUpstreamConfigs([]byte(v1))
+	testutil.Assert(t, err == nil, "unexpected error migrating configs: %s", err)
+	testutil.Equal(t, v2, string(migrated))
+
+	// current configs are unchanged
+	migrated, err = MigrateUpstreamConfigs([]byte(v2))
+	testutil.Assert(t, err == nil, "unexpected error migrating configs: %s", err)
+	testutil.Equal(t, v2, string(migrated))
+
+	templateVars := map[string]string{
+		"root_domain":  "dev",
+		"cluster":      "sso",
+		"foo_upstream": "foo-internal.sso.dev",
+	}
+	v1Configs, err := loadServiceConfigs([]byte(v1), "sso", "http", templateVars, nil)
+	testutil.Assert(t, err == nil, "unexpected error loading configs: %s", err)
+	v2Configs, err := loadServiceConfigs(migrated, "sso", "http", templateVars, nil)
+	testutil.Assert(t, err == nil, "unexpected error loading configs: %s", err)
+	testutil.Equal(t, v1Configs, v2Configs)
+
+	_, err = MigrateUpstreamConfigs([]byte("version: 3\n"))
+	testutil.NotEqual(t, nil, err)
+}
@@ -0,0 +1,180 @@
+// Package configtest builds canonical upstream configs files, and the template variables they're
+// loaded with, so that tests of config loading and routing describe only the upstreams they're
+// about. Files are rendered deterministically, with options sorted by key and every string
+// quoted, so that the same upstreams always render to the same bytes.
+package configtest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The cluster, root domain, and scheme upstream configs files are loaded with.
+const (
+	Cluster    = "sso"
+	RootDomain = "dev"
+	Scheme     = "http"
+)
+
+// version is the version of the upstream configs files rendered.
+const version = 2
+
+// TemplateVars returns the template variables upstream configs files are loaded with.
+func TemplateVars() map[string]string {
+	return map[string]string{
+		"cluster":     Cluster,
+		"root_domain": RootDomain,
+	}
+}
+
+// Options are the options of a route or the defaults of a file, keyed by their yaml fields.
+// Values may be strings, bools, ints, durations, string slices, string maps, or nested
+// Options.
+type Options map[string]interface{}
+
+// Route is a route of an upstream.
+type Route struct {
+	From        string
+	To          string
+	Type        string
+	Options     Options
+	ExtraRoutes []Route
+}
+
+// Upstream is a service, routed by its default route, which the routes of clusters override.
+type Upstream struct {
+	Service  string
+	Default  *Route
+	Clusters map[string]Route
+}
+
+// File is an upstream configs file.
+type File struct {
+	Defaults  Options
+	Upstreams []Upstream
+}
+
+// Bytes renders the file.
+func (f *File) Bytes() []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "version: %d\n", version)
+	if len(f.Defaults) != 0 {
+		buf.WriteString("defaults:\n  options:\n")
+		writeOptions(buf, 4, f.Defaults)
+	}
+
+	buf.WriteString("services:")
+	if len(f.Upstreams) == 0 {
+		buf.WriteString(" []\n")
+		return buf.Bytes()
+	}
+	buf.WriteString("\n")
+	for _, upstream := range f.Upstreams {
+		fmt.Fprintf(buf, "- service: %s\n", strconv.Quote(upstream.Service))
+		if upstream.Default != nil {
+			buf.WriteString("  default:\n")
+			writeRoute(buf, 4, *upstream.Default)
+		}
+		for _, cluster := range sortedKeys(upstream.Clusters) {
+			fmt.Fprintf(buf, "  %s:\n", cluster)
+			writeRoute(buf, 4, upstream.Clusters[cluster])
+		}
+	}
+	return buf.Bytes()
+}
+
+// Write writes the file to upstream_configs.yml in dir, returning its path.
+func (f *File) Write(t testing.TB, dir string) string {
+	path := filepath.Join(dir, "upstream_configs.yml")
+	if err := ioutil.WriteFile(path, f.Bytes(), 0600); err != nil {
+		t.Fatalf("error writing upstream configs file: %v", err)
+	}
+	return path
+}
+
+func writeRoute(buf *bytes.Buffer, indent int, route Route) {
+	prefix := strings.Repeat(" ", indent)
+	fmt.Fprintf(buf, "%sfrom: %s\n", prefix, strconv.Quote(route.From))
+	fmt.Fprintf(buf, "%sto: %s\n", prefix, strconv.Quote(route.To))
+	if route.Type != "" {
+		fmt.Fprintf(buf, "%stype: %s\n", prefix, strconv.Quote(route.Type))
+	}
+	if len(route.Options) != 0 {
+		fmt.Fprintf(buf, "%soptions:\n", prefix)
+		writeOptions(buf, indent+2, route.Options)
+	}
+	if len(route.ExtraRoutes) != 0 {
+		fmt.Fprintf(buf, "%sextra_routes:\n", prefix)
+		for _, extra := range route.ExtraRoutes {
+			// the first field of each route is indented by the sequence's dash
+			fmt.Fprintf(buf, "%s- ", prefix)
+			route := &bytes.Buffer{}
+			writeRoute(route, indent+2, extra)
+			buf.Write(route.Bytes()[indent+2:])
+		}
+	}
+}
+
+func writeOptions(buf *bytes.Buffer, indent int, options Options) {
+	prefix := strings.Repeat(" ", indent)
+	for _, key := range sortedKeys(options) {
+		switch value := options[key].(type) {
+		case Options:
+			fmt.Fprintf(buf, "%s%s:\n", prefix, key)
+			writeOptions(buf, indent+2, value)
+		case map[string]string:
+			fmt.Fprintf(buf, "%s%s:\n", prefix, key)
+			for _, k := range sortedKeys(value) {
+				fmt.Fprintf(buf, "%s  %s: %s\n", prefix, strconv.Quote(k), strconv.Quote(value[k]))
+			}
+		default:
+			fmt.Fprintf(buf, "%s%s: %s\n", prefix, key, scalar(value))
+		}
+	}
+}
+
+// scalar renders a value in yaml's flow style.
+func scalar(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return strconv.Quote(value)
+	case time.Duration:
+		return strconv.Quote(value.String())
+	case []string:
+		quoted := make([]string, 0, len(value))
+		for _, s := range value {
+			quoted = append(quoted, strconv.Quote(s))
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// sortedKeys returns the keys of a map of strings, sorted.
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch m := m.(type) {
+	case Options:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]string:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]Route:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,68 @@
+package configtest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileBytes(t *testing.T) {
+	file := &File{
+		Defaults: Options{
+			"timeout":               time.Second,
+			"allowed_email_domains": []string{"example.com"},
+		},
+		Upstreams: []Upstream{
+			{
+				Service: "foo",
+				Default: &Route{
+					From: "foo.{{cluster}}.{{root_domain}}",
+					To:   "foo-internal.{{cluster}}.{{root_domain}}",
+					Options: Options{
+						"skip_request_signing":   true,
+						"inject_request_headers": map[string]string{"x-api-key": "secret"},
+						"metadata":               Options{"team": "platform"},
+					},
+					ExtraRoutes: []Route{
+						{From: "foo-api.{{cluster}}.{{root_domain}}", To: "foo-api-internal"},
+					},
+				},
+				Clusters: map[string]Route{
+					"prod": {From: "foo.example.com", To: "foo-internal.prod", Type: "simple"},
+				},
+			},
+		},
+	}
+
+	want := `version: 2
+defaults:
+  options:
+    allowed_email_domains: ["example.com"]
+    timeout: "1s"
+services:
+- service: "foo"
+  default:
+    from: "foo.{{cluster}}.{{root_domain}}"
+    to: "foo-internal.{{cluster}}.{{root_domain}}"
+    options:
+      inject_request_headers:
+        "x-api-key": "secret"
+      metadata:
+        team: "platform"
+      skip_request_signing: true
+    extra_routes:
+    - from: "foo-api.{{cluster}}.{{root_domain}}"
+      to: "foo-api-internal"
+  prod:
+    from: "foo.example.com"
+    to: "foo-internal.prod"
+    type: "simple"
+`
+	if got := string(file.Bytes()); got != want {
+		t.Errorf("unexpected upstream configs file\nwant:\n%s\ngot:\n%s", want, got)
+	}
+
+	empty := &File{}
+	if got := string(empty.Bytes()); got != "version: 2\nservices: []\n" {
+		t.Errorf("unexpected empty upstream configs file %q", got)
+	}
+}
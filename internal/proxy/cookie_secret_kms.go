@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+var gcpKMSCryptoKey = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+// kmsDecrypter unwraps ciphertexts encrypted with a symmetric key that never leaves a KMS.
+type kmsDecrypter interface {
+	decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// kmsCookieSecret is a cookie secret wrapped by a KMS key (envelope encryption), so that the
+// secret itself is never in the environment or a config file. The ciphertext file is re-read at
+// an interval, and when it changes, e.g. because the secret was rotated, the new secret is
+// unwrapped and becomes the current secret of the cipher. Cookies of the previous secret can still
+// be read until the next rotation.
+type kmsCookieSecret struct {
+	decrypter kmsDecrypter
+	file      string
+
+	cipher     *aead.RotatingCipher
+	ciphertext []byte
+}
+
+// loadKMSCookieSecret unwraps the cookie secret of the options with the KMS.
+func loadKMSCookieSecret(opts *Options) (*kmsCookieSecret, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+
+	decrypter, err := newKMSDecrypter(ctx, opts.CookieSecretKMSKey)
+	if err != nil {
+		return nil, err
+	}
+	return newKMSCookieSecret(decrypter, opts.CookieSecretKMSCiphertextFile)
+}
+
+func newKMSCookieSecret(decrypter kmsDecrypter, file string) (*kmsCookieSecret, error) {
+	s := &kmsCookieSecret{
+		decrypter: decrypter,
+		file:      file,
+	}
+	ciphertext, err := s.readCiphertext()
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := s.unwrap(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.cipher = aead.NewRotatingCipher(cipher)
+	s.ciphertext = ciphertext
+	return s, nil
+}
+
+// readCiphertext reads the base64 encoded ciphertext of the cookie secret.
+func (s *kmsCookieSecret) readCiphertext() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cookie secret ciphertext file: %s", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding cookie secret ciphertext file %s: expected base64-encoded bytes", s.file)
+	}
+	return ciphertext, nil
+}
+
+// unwrap decrypts the ciphertext with the KMS, and returns the cipher of the cookie secret.
+func (s *kmsCookieSecret) unwrap(ciphertext []byte) (aead.Cipher, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+
+	plaintext, err := s.decrypter.decrypt(ctx, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping cookie secret: %s", err)
+	}
+	secret, err := unwrappedCookieSecret(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return newCookieCipher(secret)
+}
+
+// unwrappedCookieSecret returns the cookie secret of a plaintext, which is either the raw bytes
+// of the secret or, like COOKIE_SECRET, their base64 encoding.
+func unwrappedCookieSecret(plaintext []byte) ([]byte, error) {
+	if len(plaintext) == 32 || len(plaintext) == 64 {
+		return plaintext, nil
+	}
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(plaintext)))
+	if err != nil || (len(secret) != 32 && len(secret) != 64) {
+		return nil, fmt.Errorf("unwrapped cookie secret must be 32 or 64 bytes, or their base64 encoding")
+	}
+	return secret, nil
+}
+
+// reload re-reads the ciphertext file, and rotates the cookie secret if it changed. It returns
+// true if the secret was rotated.
+func (s *kmsCookieSecret) reload() (bool, error) {
+	ciphertext, err := s.readCiphertext()
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(ciphertext, s.ciphertext) {
+		return false, nil
+	}
+	cipher, err := s.unwrap(ciphertext)
+	if err != nil {
+		return false, err
+	}
+	s.cipher.Rotate(cipher)
+	s.ciphertext = ciphertext
+	return true, nil
+}
+
+// Watch reloads the ciphertext file at the interval, forever. A ciphertext that can't be read or
+// unwrapped is logged and the cookie secret is left unchanged, so that sessions aren't lost to a
+// bad rotation.
+func (s *kmsCookieSecret) Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	logger := log.NewLogEntry()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		rotated, err := s.reload()
+		if err != nil {
+			logger.Error(err, "error reloading cookie secret; the cookie secret is unchanged")
+			continue
+		}
+		if rotated {
+			logger.Info("rotated cookie secret; cookies of the previous secret are still accepted")
+		}
+	}
+}
+
+func newKMSDecrypter(ctx context.Context, keyName string) (kmsDecrypter, error) {
+	if err := validateKMSCookieSecretKey(keyName); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(keyName, "arn:") {
+		svc, err := newAWSKMSService(keyName)
+		if err != nil {
+			return nil, err
+		}
+		return &awsKMSDecrypter{svc: svc, keyID: keyName}, nil
+	}
+	svc, err := newGCPKMSService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &gcpKMSDecrypter{svc: svc, name: keyName}, nil
+}
+
+// validateKMSCookieSecretKey returns an error if a key name can't name a symmetric KMS key,
+// without calling the KMS.
+func validateKMSCookieSecretKey(keyName string) error {
+	if strings.HasPrefix(keyName, "arn:") {
+		_, err := awsKMSRegion(keyName)
+		return err
+	}
+	if !gcpKMSCryptoKey.MatchString(keyName) {
+		return fmt.Errorf("%q is neither an AWS KMS key ARN nor a GCP KMS key", keyName)
+	}
+	return nil
+}
+
+// awsKMSDecrypter unwraps ciphertexts with an AWS KMS key. The ciphertext names the key it was
+// encrypted with, so when the key is named by its ARN rather than an alias, the ciphertext must
+// name the same key.
+type awsKMSDecrypter struct {
+	svc   *kms.KMS
+	keyID string
+}
+
+func (d *awsKMSDecrypter) decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	output, err := d.svc.DecryptWithContext(ctx, &kms.DecryptInput{CiphertextBlob: ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(d.keyID, ":key/") && aws.StringValue(output.KeyId) != d.keyID {
+		return nil, fmt.Errorf("ciphertext was encrypted with %s, not %s", aws.StringValue(output.KeyId), d.keyID)
+	}
+	return output.Plaintext, nil
+}
+
+// gcpKMSDecrypter unwraps ciphertexts with a GCP Cloud KMS key.
+type gcpKMSDecrypter struct {
+	svc  *cloudkms.Service
+	name string
+}
+
+func (d *gcpKMSDecrypter) decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	resp, err := d.svc.Projects.Locations.KeyRings.CryptoKeys.Decrypt(d.name, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
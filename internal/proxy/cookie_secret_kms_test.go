@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+// fakeKMSDecrypter unwraps the ciphertexts it knows the plaintexts of.
+type fakeKMSDecrypter struct {
+	plaintexts map[string][]byte
+	calls      int
+}
+
+func (d *fakeKMSDecrypter) decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	d.calls++
+	plaintext, ok := d.plaintexts[string(ciphertext)]
+	if !ok {
+		return nil, fmt.Errorf("invalid ciphertext")
+	}
+	return plaintext, nil
+}
+
+func writeCiphertext(t *testing.T, file string, ciphertext string) {
+	t.Helper()
+	err := ioutil.WriteFile(file, []byte(base64.StdEncoding.EncodeToString([]byte(ciphertext))+"\n"), 0600)
+	testutil.Ok(t, err)
+}
+
+func TestKMSCookieSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookie-secret")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ciphertext")
+
+	firstSecret := aead.GenerateKey()
+	secondSecret := aead.GenerateKey()
+	decrypter := &fakeKMSDecrypter{plaintexts: map[string][]byte{
+		"first":  firstSecret,
+		"second": []byte(base64.StdEncoding.EncodeToString(secondSecret)),
+	}}
+
+	writeCiphertext(t, file, "first")
+	s, err := newKMSCookieSecret(decrypter, file)
+	testutil.Ok(t, err)
+	testutil.Equal(t, 1, decrypter.calls)
+
+	first, err := aead.NewMiscreantCipher(firstSecret)
+	testutil.Ok(t, err)
+	value, err := first.Marshal("session")
+	testutil.Ok(t, err)
+	var got string
+	testutil.Ok(t, s.cipher.Unmarshal(value, &got))
+	testutil.Equal(t, "session", got)
+
+	// an unchanged ciphertext isn't unwrapped again
+	rotated, err := s.reload()
+	testutil.Ok(t, err)
+	testutil.Equal(t, false, rotated)
+	testutil.Equal(t, 1, decrypter.calls)
+
+	// a rotated secret encrypts new cookies, and cookies of the previous secret are still read
+	writeCiphertext(t, file, "second")
+	rotated, err = s.reload()
+	testutil.Ok(t, err)
+	testutil.Equal(t, true, rotated)
+	testutil.Ok(t, s.cipher.Unmarshal(value, &got))
+
+	second, err := aead.NewMiscreantCipher(secondSecret)
+	testutil.Ok(t, err)
+	value, err = s.cipher.Marshal("session")
+	testutil.Ok(t, err)
+	testutil.Ok(t, second.Unmarshal(value, &got))
+
+	// a ciphertext that can't be unwrapped leaves the secret unchanged
+	writeCiphertext(t, file, "third")
+	rotated, err = s.reload()
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, false, rotated)
+	value, err = s.cipher.Marshal("session")
+	testutil.Ok(t, err)
+	testutil.Ok(t, second.Unmarshal(value, &got))
+}
+
+func TestUnwrappedCookieSecret(t *testing.T) {
+	secret := aead.GenerateKey()
+	testCases := []struct {
+		name      string
+		plaintext []byte
+		want      []byte
+		wantErr   bool
+	}{
+		{name: "raw 32 bytes", plaintext: secret, want: secret},
+		{name: "raw 64 bytes", plaintext: append(secret, secret...), want: append(secret, secret...)},
+		{name: "base64 encoded", plaintext: []byte(base64.StdEncoding.EncodeToString(secret) + "\n"), want: secret},
+		{name: "wrong length", plaintext: secret[:16], wantErr: true},
+		{name: "base64 encoded wrong length", plaintext: []byte(base64.StdEncoding.EncodeToString(secret[:16])), wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := unwrappedCookieSecret(tc.plaintext)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestValidateCookieSecretKMSKey(t *testing.T) {
+	o := testOptions()
+	o.CookieSecretKMSKey = "projects/sso/locations/global/keyRings/proxy/cryptoKeys/cookies/cryptoKeyVersions/1"
+	o.CookieSecretKMSReloadInterval = -1
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"invalid setting: COOKIE_SECRET and COOKIE_SECRET_KMS_KEY can't both be set",
+		`invalid value for cookie-secret-kms-key: "projects/sso/locations/global/keyRings/proxy/cryptoKeys/cookies/cryptoKeyVersions/1" is neither an AWS KMS key ARN nor a GCP KMS key`,
+		"missing setting: COOKIE_SECRET_KMS_CIPHERTEXT_FILE is required by COOKIE_SECRET_KMS_KEY",
+		"invalid value for cookie-secret-kms-reload-interval: must not be negative",
+	}), err.Error())
+
+	o = testOptions()
+	o.CookieSecret = ""
+	o.CookieSecretKMSKey = "arn:aws:kms:us-east-1:111122223333:alias/sso-cookies"
+	o.CookieSecretKMSCiphertextFile = "/sso/cookie_secret"
+	testutil.Ok(t, o.Validate())
+}
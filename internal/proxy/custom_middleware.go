@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/buzzfeed/sso/pkg/middleware"
+)
+
+// MiddlewareConfig maps the names of the custom middleware an upstream enables to their options.
+// Custom middleware are registered with package middleware, at build time or by Go plugins.
+type MiddlewareConfig map[string]map[string]string
+
+// UnmarshalYAML unmarshals a middleware config, giving middleware enabled without options an
+// empty map of them, as middleware without any would be dropped when merged with the defaults.
+func (c *MiddlewareConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var config map[string]map[string]string
+	if err := unmarshal(&config); err != nil {
+		return err
+	}
+	for name, options := range config {
+		if options == nil {
+			config[name] = map[string]string{}
+		}
+	}
+	*c = config
+	return nil
+}
+
+// lookupMiddleware returns the registered middleware an upstream enables, in the order they run.
+func lookupMiddleware(config MiddlewareConfig) ([]middleware.Middleware, error) {
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mws := make([]middleware.Middleware, 0, len(names))
+	unknown := []string{}
+	for _, name := range names {
+		mw, ok := middleware.Lookup(name)
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		mws = append(mws, mw)
+	}
+	if len(unknown) != 0 {
+		return nil, fmt.Errorf("unknown middleware %s, which must be registered at build time or by one of MIDDLEWARE_PLUGINS",
+			strings.Join(unknown, ", "))
+	}
+	middleware.Sort(mws)
+	return mws, nil
+}
+
+// loadMiddlewarePlugins loads the Go plugins of MIDDLEWARE_PLUGINS, registering their middleware.
+func loadMiddlewarePlugins(o *Options, msgs []string) []string {
+	for _, path := range o.MiddlewarePlugins {
+		if err := middleware.LoadPlugin(path); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid value for middleware-plugins: error loading %s: %s", path, err))
+		}
+	}
+	return msgs
+}
+
+// upstreamMiddleware are the custom middleware of an upstream, by the point they run at.
+type upstreamMiddleware map[middleware.Point][]func(http.Handler) http.Handler
+
+// newUpstreamMiddleware creates the custom middleware an upstream enables.
+func newUpstreamMiddleware(config *UpstreamConfig) (upstreamMiddleware, error) {
+	mws := upstreamMiddleware{}
+	for _, mw := range config.Middleware {
+		wrap, err := mw.New(middleware.Upstream{
+			Service: config.Service,
+			Cluster: config.Cluster,
+			Options: config.MiddlewareOptions[mw.Name],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating middleware %s of upstream %s: %s", mw.Name, config.Service, err)
+		}
+		mws[mw.Point] = append(mws[mw.Point], wrap)
+	}
+	return mws, nil
+}
+
+// wrap wraps a handler with the middleware of a point, the first of which runs first.
+func (mws upstreamMiddleware) wrap(point middleware.Point, handler http.Handler) http.Handler {
+	wraps := mws[point]
+	for i := len(wraps) - 1; i >= 0; i-- {
+		handler = wraps[i](handler)
+	}
+	return handler
+}
+
+// withIdentity sets the identity of authenticated requests, as recorded by authenticate or
+// authenticateBearerToken, for the middleware after authentication. Requests that skip
+// authentication have no identity, whatever X-Forwarded-* headers they're sent with.
+func withIdentity(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		identity, ok := forwardedIdentity(req.Context())
+		if !ok {
+			h.ServeHTTP(rw, req)
+			return
+		}
+		h.ServeHTTP(rw, req.WithContext(middleware.NewContext(req.Context(), identity)))
+	})
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/pkg/middleware"
+)
+
+func init() {
+	// each test middleware appends its name, and an option, to the X-Middleware header of
+	// requests, so the order they run in is visible to the upstream
+	for _, point := range middleware.Points {
+		point := point
+		middleware.Register(middleware.Middleware{
+			Name:  "test-" + string(point),
+			Point: point,
+			New: func(upstream middleware.Upstream) (func(http.Handler) http.Handler, error) {
+				return func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+						req.Header.Add("X-Middleware", string(point)+upstream.Options["suffix"])
+						next.ServeHTTP(rw, req)
+					})
+				}, nil
+			},
+		})
+	}
+	middleware.Register(middleware.Middleware{
+		Name:  "test-pre_auth-first",
+		Point: middleware.PreAuth,
+		Order: -1,
+		New: func(upstream middleware.Upstream) (func(http.Handler) http.Handler, error) {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+					req.Header.Add("X-Middleware", "first")
+					next.ServeHTTP(rw, req)
+				})
+			}, nil
+		},
+	})
+	middleware.Register(middleware.Middleware{
+		Name:  "test-failing",
+		Point: middleware.PreProxy,
+		New: func(upstream middleware.Upstream) (func(http.Handler) http.Handler, error) {
+			return nil, errors.New("missing option")
+		},
+	})
+}
+
+func TestUpstreamConfigMiddleware(t *testing.T) {
+	upstreamConfigs, err := loadServiceConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.sso.dev
+    to: foo-internal.sso.dev
+    options:
+      middleware:
+        test-post_auth:
+        test-pre_auth:
+          suffix: "!"
+`), "sso", "http", nil, nil)
+	testutil.Ok(t, err)
+
+	upstreamConfig := upstreamConfigs[0]
+	names := []string{}
+	for _, mw := range upstreamConfig.Middleware {
+		names = append(names, mw.Name)
+	}
+	testutil.Equal(t, []string{"test-pre_auth", "test-post_auth"}, names)
+	testutil.Equal(t, map[string]string{"suffix": "!"}, upstreamConfig.MiddlewareOptions["test-pre_auth"])
+
+	_, err = loadServiceConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.sso.dev
+    to: foo-internal.sso.dev
+    options:
+      middleware:
+        unregistered:
+`), "sso", "http", nil, nil)
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, "invalid middleware", err.(*ErrParsingConfig).Message)
+}
+
+func TestUpstreamMiddlewareOrder(t *testing.T) {
+	mws, err := lookupMiddleware(MiddlewareConfig{
+		"test-post_proxy":     nil,
+		"test-pre_proxy":      nil,
+		"test-post_auth":      nil,
+		"test-pre_auth":       {"suffix": "!"},
+		"test-pre_auth-first": nil,
+	})
+	testutil.Ok(t, err)
+	config := &UpstreamConfig{
+		Service:           "foo",
+		Middleware:        mws,
+		MiddlewareOptions: MiddlewareConfig{"test-pre_auth": {"suffix": "!"}},
+	}
+	upstream, err := newUpstreamMiddleware(config)
+	testutil.Ok(t, err)
+
+	var got []string
+	var identity middleware.Identity
+	var handler http.Handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = req.Header["X-Middleware"]
+		identity, _ = middleware.IdentityFromContext(req.Context())
+	})
+	// as the handlers of an upstream are wrapped by newUpstreamRouting
+	handler = upstream.wrap(middleware.PreProxy, upstream.wrap(middleware.PostProxy, handler))
+	handler = withIdentity(upstream.wrap(middleware.PostAuth, handler))
+	handler = upstream.wrap(middleware.PreAuth, handler)
+
+	req := httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+	req = req.WithContext(NewSessionContext(req.Context()))
+	setForwardedIdentity(req, middleware.Identity{Email: "user@example.com", Groups: []string{"admins", "engineers"}})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	testutil.Equal(t, []string{"first", "pre_auth!", "post_auth", "pre_proxy", "post_proxy"}, got)
+	testutil.Equal(t, middleware.Identity{Email: "user@example.com", Groups: []string{"admins", "engineers"}}, identity)
+
+	// requests that skip authentication have no identity, whatever headers they're sent with
+	identity = middleware.Identity{}
+	req = httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+	req = req.WithContext(NewSessionContext(req.Context()))
+	req.Header.Set("X-Forwarded-Email", "spoofed@example.com")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	testutil.Equal(t, middleware.Identity{}, identity)
+}
+
+func TestUpstreamMiddlewareErrors(t *testing.T) {
+	_, err := lookupMiddleware(MiddlewareConfig{"test-pre_auth": nil, "b": nil, "a": nil})
+	testutil.NotEqual(t, nil, err)
+	testutil.Assert(t, strings.HasPrefix(err.Error(), "unknown middleware a, b,"), "expected unknown middleware to be listed, got %q", err)
+
+	mws, err := lookupMiddleware(MiddlewareConfig{"test-failing": nil})
+	testutil.Ok(t, err)
+	_, err = newUpstreamMiddleware(&UpstreamConfig{Service: "foo", Middleware: mws})
+	testutil.Equal(t, "error creating middleware test-failing of upstream foo: missing option", err.Error())
+}
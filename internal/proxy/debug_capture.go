@@ -0,0 +1,379 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+// redacted replaces the values scrubbed from captured requests and responses.
+const redacted = "[REDACTED]"
+
+// scrubbedHeaders hold credentials, and are always scrubbed from captured requests and responses.
+var scrubbedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Forwarded-Access-Token",
+	HMACSignatureHeader,
+	signatureHeader,
+}
+
+// pseudonymizedHeaders identify the user of a request, and are pseudonymized in the headers of
+// log entries in privacy mode, like the users of the entries themselves.
+var pseudonymizedHeaders = map[string]bool{
+	"X-Forwarded-Email": true,
+	"X-Forwarded-User":  true,
+	"X-Impersonated-By": true,
+}
+
+// privateHeaders describe the user of a request, and are redacted from the headers of log
+// entries in privacy mode, along with the claim headers of the upstream.
+var privateHeaders = []string{
+	"X-Forwarded-Groups",
+}
+
+// scrubbedFields are the names of fields of JSON and form bodies that are always scrubbed.
+var scrubbedFields = []string{
+	"password",
+	"passwd",
+	"secret",
+	"client_secret",
+	"token",
+	"access_token",
+	"refresh_token",
+	"id_token",
+	"api_key",
+	"apikey",
+}
+
+// secretPatterns match well known secrets wherever they appear in captured bodies.
+var secretPatterns = []*regexp.Regexp{
+	// bearer tokens
+	regexp.MustCompile(`(?i)bearer\s+[a-z0-9._~+/=-]+`),
+	// JSON web tokens
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	// AWS access key ids
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	// PEM encoded private keys
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`),
+}
+
+// DebugCaptureConfig maps to the debug_capture yaml config fields of an upstream:
+// * until - the RFC 3339 time after which requests are no longer captured, required so that captures are short-lived
+// * max_body_size - the number of bytes of request and response bodies that are logged, defaults to 4KiB
+// * scrub_fields - the names of headers and of JSON and form fields to scrub, in addition to credentials
+type DebugCaptureConfig struct {
+	Until       string   `yaml:"until"`
+	MaxBodySize int64    `yaml:"max_body_size"`
+	ScrubFields []string `yaml:"scrub_fields"`
+
+	until time.Time
+}
+
+func (c *DebugCaptureConfig) validate() error {
+	if c.Until == "" {
+		return fmt.Errorf("until is required")
+	}
+	until, err := time.Parse(time.RFC3339, c.Until)
+	if err != nil {
+		return fmt.Errorf("until must be an RFC 3339 time: %s", err)
+	}
+	c.until = until
+
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = 4 * 1024
+	}
+	if c.MaxBodySize < 0 {
+		return fmt.Errorf("max_body_size must not be negative")
+	}
+	return nil
+}
+
+// debugCapture logs the headers and bodies of the requests of an upstream and of their
+// responses, scrubbed of credentials, to troubleshoot integrations with the upstream.
+type debugCapture struct {
+	config  *DebugCaptureConfig
+	handler http.Handler
+	scrub   *scrubber
+	// record writes the log entry of a captured request
+	record func(*log.LogEntry)
+}
+
+// newDebugCaptureHandler wraps the handler of an upstream, capturing its requests until the
+// configured time.
+func newDebugCaptureHandler(handler http.Handler, config *UpstreamConfig) http.Handler {
+	log.NewLogEntry().WithProxyHost(config.RouteConfig.From).Warn(
+		fmt.Sprintf("debug capture is enabled for upstream %s until %s", config.Service, config.DebugCapture.Until))
+
+	return &debugCapture{
+		config:  config.DebugCapture,
+		handler: handler,
+		scrub:   newLogScrubber(config.DebugCapture.ScrubFields, config.ClaimHeaders),
+		record: func(entry *log.LogEntry) {
+			entry.Info(fmt.Sprintf("debug capture of upstream %s", config.Service))
+		},
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (d *debugCapture) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// upgraded connections, e.g. websockets, aren't captured
+	if req.Header.Get("Upgrade") != "" || time.Now().After(d.config.until) {
+		d.handler.ServeHTTP(rw, req)
+		return
+	}
+
+	// the body is read up to the limit, and the part that was read is put back in front of the
+	// rest for the request that is proxied
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		reqBody, _ = ioutil.ReadAll(io.LimitReader(req.Body, d.config.MaxBodySize))
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(reqBody), req.Body), req.Body}
+	}
+	reqHeader := cloneHeader(req.Header)
+
+	crw := &captureResponseWriter{ResponseWriter: rw, limit: d.config.MaxBodySize}
+	d.handler.ServeHTTP(crw, req)
+
+	d.record(log.NewLogEntry().
+		WithProxyHost(req.Host).
+		WithRequestMethod(req.Method).
+		WithRequestURI(d.scrub.uri(req.URL)).
+		WithRequestHeaders(d.scrub.headers(reqHeader)).
+		WithRequestBody(d.scrub.body(reqHeader.Get("Content-Type"), reqBody)).
+		WithHTTPStatus(crw.status()).
+		WithResponseHeaders(d.scrub.headers(cloneHeader(rw.Header()))).
+		WithResponseBodyText(d.scrub.body(rw.Header().Get("Content-Type"), crw.body.Bytes())))
+}
+
+// captureResponseWriter records the status and the start of the body of a response.
+type captureResponseWriter struct {
+	http.ResponseWriter
+	limit int64
+
+	code int
+	body bytes.Buffer
+}
+
+func (c *captureResponseWriter) WriteHeader(code int) {
+	if c.code == 0 {
+		c.code = code
+	}
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *captureResponseWriter) Write(b []byte) (int, error) {
+	if c.code == 0 {
+		c.code = http.StatusOK
+	}
+	if remaining := c.limit - int64(c.body.Len()); remaining > 0 {
+		if int64(len(b)) > remaining {
+			c.body.Write(b[:remaining])
+		} else {
+			c.body.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+func (c *captureResponseWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *captureResponseWriter) status() int {
+	if c.code == 0 {
+		return http.StatusOK
+	}
+	return c.code
+}
+
+func cloneHeader(h http.Header) http.Header {
+	clone := make(http.Header, len(h))
+	for key, values := range h {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// scrubber removes credentials and secrets from captured requests and responses.
+type scrubber struct {
+	headerNames map[string]bool
+	fieldNames  map[string]bool
+	// fieldPattern matches the values of scrubbed fields in bodies that can't be parsed
+	fieldPattern *regexp.Regexp
+	// privateHeaderNames describe users, and are redacted in privacy mode by scrubbers of headers
+	// that are logged
+	privateHeaderNames map[string]bool
+}
+
+// newScrubber returns a scrubber of the default credentials, along with the given header and
+// field names.
+func newScrubber(names []string) *scrubber {
+	s := &scrubber{
+		headerNames: make(map[string]bool),
+		fieldNames:  make(map[string]bool),
+	}
+	for _, h := range scrubbedHeaders {
+		s.headerNames[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, f := range scrubbedFields {
+		s.fieldNames[f] = true
+	}
+	for _, name := range names {
+		s.headerNames[http.CanonicalHeaderKey(name)] = true
+		s.fieldNames[strings.ToLower(name)] = true
+	}
+
+	names = make([]string, 0, len(s.fieldNames))
+	for name := range s.fieldNames {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+	sort.Strings(names)
+	s.fieldPattern = regexp.MustCompile(fmt.Sprintf(
+		`(?i)("(?:%[1]s)"\s*:\s*)(?:"(?:[^"\\]|\\.)*"?|[^,}\]\s]+)|((?:^|[?&])(?:%[1]s)=)[^&]*`,
+		strings.Join(names, "|")))
+	return s
+}
+
+// newLogScrubber returns a scrubber of headers that are logged, which in privacy mode also
+// pseudonymizes the user of each request, and redacts the headers describing them, including
+// the default claim headers and those of claimHeaders.
+func newLogScrubber(names []string, claimHeaders map[string]string) *scrubber {
+	s := newScrubber(names)
+	s.privateHeaderNames = make(map[string]bool)
+	for _, h := range privateHeaders {
+		s.privateHeaderNames[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, key := range claimHeaderKeys(newClaimHeaders(claimHeaders)) {
+		s.privateHeaderNames[key] = true
+	}
+	return s
+}
+
+// headers scrubs headers in place, returning them.
+func (s *scrubber) headers(h http.Header) http.Header {
+	private := s.privateHeaderNames != nil && log.Pseudonymizing()
+	for key, values := range h {
+		if s.headerNames[key] || private && s.privateHeaderNames[key] {
+			for i := range values {
+				values[i] = redacted
+			}
+			continue
+		}
+		if private && pseudonymizedHeaders[key] {
+			for i, v := range values {
+				values[i] = log.Pseudonymize(v)
+			}
+			continue
+		}
+		for i, v := range values {
+			values[i] = s.text(v)
+		}
+	}
+	return h
+}
+
+// uri returns the request uri, with the values of scrubbed fields in its query redacted.
+func (s *scrubber) uri(u *url.URL) string {
+	if u.RawQuery == "" {
+		return s.text(u.RequestURI())
+	}
+	scrubbed := *u
+	scrubbed.RawQuery = s.form(u.RawQuery)
+	return s.text(scrubbed.RequestURI())
+}
+
+// body scrubs a body according to its content type. Bodies that can't be parsed, including
+// those cut short by the size limit, are scrubbed of the values of fields that look like JSON
+// or form fields, and of well known secrets.
+func (s *scrubber) body(contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			if scrubbed, err := json.Marshal(s.json(v)); err == nil {
+				return s.text(string(scrubbed))
+			}
+		}
+	case mediaType == "application/x-www-form-urlencoded":
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			return s.text(s.encodeForm(form))
+		}
+	}
+	return s.text(s.fields(string(body)))
+}
+
+// json redacts the values of scrubbed fields in a decoded JSON value.
+func (s *scrubber) json(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			if s.fieldNames[strings.ToLower(key)] {
+				v[key] = redacted
+				continue
+			}
+			v[key] = s.json(val)
+		}
+	case []interface{}:
+		for i, val := range v {
+			v[i] = s.json(val)
+		}
+	}
+	return v
+}
+
+// form redacts the values of scrubbed fields in a url encoded form. Forms that can't be
+// parsed only have the values of fields that look like form fields redacted.
+func (s *scrubber) form(raw string) string {
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return s.fields(raw)
+	}
+	return s.encodeForm(values)
+}
+
+// encodeForm encodes a form, with the values of scrubbed fields redacted.
+func (s *scrubber) encodeForm(values url.Values) string {
+	for key := range values {
+		if s.fieldNames[strings.ToLower(key)] {
+			values[key] = []string{redacted}
+		}
+	}
+	// redacted values are left unescaped so that they stand out
+	return strings.Replace(values.Encode(), url.QueryEscape(redacted), redacted, -1)
+}
+
+// fields redacts the values of scrubbed fields from text that looks like JSON or a form.
+func (s *scrubber) fields(t string) string {
+	return s.fieldPattern.ReplaceAllStringFunc(t, func(match string) string {
+		groups := s.fieldPattern.FindStringSubmatch(match)
+		if groups[1] != "" {
+			return groups[1] + `"` + redacted + `"`
+		}
+		return groups[2] + redacted
+	})
+}
+
+// text redacts well known secrets from text.
+func (s *scrubber) text(t string) string {
+	for _, pattern := range secretPatterns {
+		t = pattern.ReplaceAllString(t, redacted)
+	}
+	return t
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+// Actions taken on requests whose host matches no upstream.
+const (
+	// defaultRouteMisdirected replies with a 421 Misdirected Request error.
+	defaultRouteMisdirected = "misdirected"
+	// defaultRouteNotFound replies with a 404 error page.
+	defaultRouteNotFound = "not_found"
+	// defaultRouteRedirect redirects to DefaultRouteURL, e.g. a portal listing services.
+	defaultRouteRedirect = "redirect"
+	// defaultRouteUpstream serves the request with the upstream named by DefaultRouteUpstream.
+	defaultRouteUpstream = "upstream"
+)
+
+var defaultRouteActions = []string{
+	defaultRouteMisdirected,
+	defaultRouteNotFound,
+	defaultRouteRedirect,
+	defaultRouteUpstream,
+}
+
+func isDefaultRouteAction(action string) bool {
+	for _, a := range defaultRouteActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// newDefaultRouteHandler returns the handler of requests whose host matches no upstream, or
+// nil to keep the router's default. upstreams holds the handler of each upstream by service.
+func newDefaultRouteHandler(opts *Options, upstreams map[string]http.Handler) (http.Handler, error) {
+	switch opts.DefaultRouteAction {
+	case defaultRouteMisdirected, "":
+		return nil, nil
+	case defaultRouteNotFound:
+		return notFoundHandler(getTemplates()), nil
+	case defaultRouteRedirect:
+		return http.RedirectHandler(opts.DefaultRouteURL, http.StatusFound), nil
+	case defaultRouteUpstream:
+		handler, ok := upstreams[opts.DefaultRouteUpstream]
+		if !ok {
+			return nil, fmt.Errorf("default route upstream %q is not configured", opts.DefaultRouteUpstream)
+		}
+		return handler, nil
+	default:
+		return nil, fmt.Errorf("unknown default route action %q", opts.DefaultRouteAction)
+	}
+}
+
+// notFoundHandler replies with a 404 error page.
+func notFoundHandler(templates *template.Template) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req)).WithRequestID(requestID(req)).WithRequestHost(
+			req.Host).WithHTTPStatus(http.StatusNotFound).Info("no upstream configured for host")
+
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.WriteHeader(http.StatusNotFound)
+		templates.ExecuteTemplate(rw, "error.html", struct {
+			Code      int
+			Title     string
+			Message   string
+			RequestID string
+		}{
+			Code:      http.StatusNotFound,
+			Title:     "Not Found",
+			Message:   "There is no service at this address.",
+			RequestID: requestID(req),
+		})
+	})
+}
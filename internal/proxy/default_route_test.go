@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestDefaultRoute(t *testing.T) {
+	testCases := []struct {
+		name         string
+		action       string
+		url          string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:     "misdirected by default",
+			wantCode: http.StatusMisdirectedRequest,
+		},
+		{
+			name:     "not found page",
+			action:   defaultRouteNotFound,
+			wantCode: http.StatusNotFound,
+			wantBody: "There is no service at this address.",
+		},
+		{
+			name:         "redirect to portal",
+			action:       defaultRouteRedirect,
+			url:          "https://portal.example.com",
+			wantCode:     http.StatusFound,
+			wantLocation: "https://portal.example.com",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := NewOptions()
+			if tc.action != "" {
+				opts.DefaultRouteAction = tc.action
+			}
+			opts.DefaultRouteURL = tc.url
+			sso, err := New(opts)
+			testutil.Assert(t, err == nil, "unexpected error creating proxy: %s", err)
+
+			rw := httptest.NewRecorder()
+			sso.ServeHTTP(rw, httptest.NewRequest("GET", "https://unknown.example.com/foo", nil))
+			testutil.Equal(t, tc.wantCode, rw.Code)
+			testutil.Equal(t, tc.wantLocation, rw.Header().Get("Location"))
+			testutil.Assert(t, strings.Contains(rw.Body.String(), tc.wantBody), "unexpected body: %s", rw.Body.String())
+		})
+	}
+}
+
+func TestDefaultRouteUpstream(t *testing.T) {
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	upstreams := map[string]http.Handler{"catch_all": upstream}
+
+	opts := NewOptions()
+	opts.DefaultRouteAction = defaultRouteUpstream
+	opts.DefaultRouteUpstream = "catch_all"
+	handler, err := newDefaultRouteHandler(opts, upstreams)
+	testutil.Assert(t, err == nil, "unexpected error creating handler: %s", err)
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "https://unknown.example.com/", nil))
+	testutil.Equal(t, http.StatusTeapot, rw.Code)
+
+	opts.DefaultRouteUpstream = "missing"
+	_, err = newDefaultRouteHandler(opts, upstreams)
+	testutil.NotEqual(t, nil, err)
+}
+
+func TestValidateDefaultRouteOptions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		action   string
+		url      string
+		upstream string
+		wantMsgs []string
+	}{
+		{
+			name:   "misdirected",
+			action: defaultRouteMisdirected,
+		},
+		{
+			name:   "redirect",
+			action: defaultRouteRedirect,
+			url:    "https://portal.example.com",
+		},
+		{
+			name:     "redirect without url",
+			action:   defaultRouteRedirect,
+			wantMsgs: []string{"invalid value for default-route-url: must include scheme and host"},
+		},
+		{
+			name:     "upstream",
+			action:   defaultRouteUpstream,
+			upstream: "foo",
+		},
+		{
+			name:     "unknown upstream",
+			action:   defaultRouteUpstream,
+			upstream: "bar",
+			wantMsgs: []string{`invalid value for default-route-upstream: no upstream "bar" is configured`},
+		},
+		{
+			name:     "unknown action",
+			action:   "teapot",
+			wantMsgs: []string{`invalid value for default-route-action: "teapot"`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := NewOptions()
+			o.DefaultRouteAction = tc.action
+			o.DefaultRouteURL = tc.url
+			o.DefaultRouteUpstream = tc.upstream
+			o.upstreamConfigs = []*UpstreamConfig{{Service: "foo"}}
+			msgs := validateDefaultRouteOptions(o, []string{})
+			if tc.wantMsgs == nil {
+				tc.wantMsgs = []string{}
+			}
+			testutil.Equal(t, tc.wantMsgs, msgs)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+)
+
+// Policies applied to the requests of an upstream while the provider is unavailable, and the
+// sessions of its users are only honored for the grace period.
+const (
+	// degradedAllow serves requests with the stale session, as during any grace period.
+	degradedAllow = "allow"
+	// degradedReadOnly serves only requests with safe methods, e.g. GET.
+	degradedReadOnly = "read_only"
+	// degradedDeny rejects requests until the provider is available again.
+	degradedDeny = "deny"
+)
+
+// degradedHeader is set on requests served while the provider is unavailable, to the policy of
+// the upstream, so that upstream applications can warn their users.
+const degradedHeader = "X-SSO-Auth-Degraded"
+
+// ErrProviderDegraded is returned when a request is rejected by the policy of its upstream
+// while the provider is unavailable.
+var ErrProviderDegraded = errors.New("request rejected while the identity provider is unavailable")
+
+// validateDegradedMode returns an error if mode isn't a known policy.
+func validateDegradedMode(mode string) error {
+	switch mode {
+	case degradedAllow, degradedReadOnly, degradedDeny:
+		return nil
+	}
+	return fmt.Errorf("unknown degraded_mode %q, must be one of %q, %q, or %q",
+		mode, degradedAllow, degradedReadOnly, degradedDeny)
+}
+
+// isDegraded returns true if the session is only honored because the provider was unavailable
+// when it was last refreshed or validated. The provider clears the start of the grace period
+// once it is available again.
+func isDegraded(s *sessions.SessionState) bool {
+	return !s.GracePeriodStart.IsZero()
+}
+
+// degradedMode returns the policy of the upstream, which defaults to allowing requests.
+func (p *OAuthProxy) degradedMode() string {
+	if p.upstreamConfig.DegradedMode == "" {
+		return degradedAllow
+	}
+	return p.upstreamConfig.DegradedMode
+}
+
+// checkDegraded applies the policy of the upstream to requests made with a degraded session.
+func (p *OAuthProxy) checkDegraded(req *http.Request, session *sessions.SessionState) error {
+	if !isDegraded(session) {
+		return nil
+	}
+
+	mode := p.degradedMode()
+	allowed := true
+	switch mode {
+	case degradedDeny:
+		allowed = false
+	case degradedReadOnly:
+		allowed = isSafeMethod(req.Method)
+	}
+
+	result := "result:allowed"
+	if !allowed {
+		result = "result:rejected"
+	}
+	p.StatsdClient.Incr("degraded_request", []string{"policy:" + mode, result}, 1.0)
+
+	if !allowed {
+		return ErrProviderDegraded
+	}
+	return nil
+}
+
+// isSafeMethod returns true for request methods that are not expected to change any state.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestAuthenticateDegraded(t *testing.T) {
+	testCases := []struct {
+		name           string
+		mode           string
+		method         string
+		degraded       bool
+		buzzfeedCompat bool
+		expectedErr    error
+		expectedHeader string
+	}{
+		{
+			name:   "sessions within their deadlines are not degraded",
+			mode:   degradedDeny,
+			method: "POST",
+		},
+		{
+			name:           "unset policy allows requests",
+			method:         "POST",
+			degraded:       true,
+			expectedHeader: degradedAllow,
+		},
+		{
+			name:           "allow policy allows requests",
+			mode:           degradedAllow,
+			method:         "DELETE",
+			degraded:       true,
+			expectedHeader: degradedAllow,
+		},
+		{
+			name:           "buzzfeed compat mode doesn't set the header",
+			mode:           degradedAllow,
+			method:         "GET",
+			degraded:       true,
+			buzzfeedCompat: true,
+		},
+		{
+			name:           "read_only policy allows safe methods",
+			mode:           degradedReadOnly,
+			method:         "GET",
+			degraded:       true,
+			expectedHeader: degradedReadOnly,
+		},
+		{
+			name:        "read_only policy rejects unsafe methods",
+			mode:        degradedReadOnly,
+			method:      "POST",
+			degraded:    true,
+			expectedErr: ErrProviderDegraded,
+		},
+		{
+			name:        "deny policy rejects requests",
+			mode:        degradedDeny,
+			method:      "GET",
+			degraded:    true,
+			expectedErr: ErrProviderDegraded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := testSession()
+			if tc.degraded {
+				session.GracePeriodStart = time.Now().Add(-time.Minute)
+			}
+			store := &sessions.MockSessionStore{Session: session, ResponseSession: "session"}
+			proxy, close := testNewOAuthProxy(t, setSessionStore(store))
+			defer close()
+			proxy.upstreamConfig.DegradedMode = tc.mode
+			proxy.buzzfeedCompat = tc.buzzfeedCompat
+
+			req := httptest.NewRequest(tc.method, "https://localhost/", nil)
+			// the header can't be set by clients
+			req.Header.Set(degradedHeader, "spoofed")
+			err := proxy.Authenticate(httptest.NewRecorder(), req)
+
+			testutil.Equal(t, tc.expectedErr, err)
+			if err != nil {
+				// the session is kept for when the provider is available again
+				testutil.Equal(t, "session", store.ResponseSession)
+				return
+			}
+			testutil.Equal(t, tc.expectedHeader, req.Header.Get(degradedHeader))
+		})
+	}
+}
+
+func TestDegradedErrorPage(t *testing.T) {
+	session := testSession()
+	session.GracePeriodStart = time.Now()
+	proxy, close := testNewOAuthProxy(t, setSessionStore(&sessions.MockSessionStore{Session: session}))
+	defer close()
+	proxy.upstreamConfig.DegradedMode = degradedDeny
+
+	rw := httptest.NewRecorder()
+	proxy.Proxy(rw, httptest.NewRequest("GET", "https://localhost/", nil))
+	testutil.Equal(t, http.StatusServiceUnavailable, rw.Code)
+}
+
+func TestParseDegradedMode(t *testing.T) {
+	upstreamConfig := &UpstreamConfig{
+		RouteConfig: RouteConfig{
+			Options: &OptionsConfig{DegradedMode: degradedReadOnly},
+		},
+	}
+	testutil.Ok(t, parseOptionsConfig(upstreamConfig, nil))
+	testutil.Equal(t, degradedReadOnly, upstreamConfig.DegradedMode)
+
+	upstreamConfig = &UpstreamConfig{
+		RouteConfig: RouteConfig{
+			Options: &OptionsConfig{DegradedMode: "readonly"},
+		},
+	}
+	err := parseOptionsConfig(upstreamConfig, nil)
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, `invalid degraded_mode error=unknown degraded_mode "readonly", must be one of "allow", "read_only", or "deny"`, err.Error())
+}
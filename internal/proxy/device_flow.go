@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/options"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/pkg/middleware"
+)
+
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// ErrInvalidBearerToken is returned when a request presents an unknown, expired, or
+// revoked bearer token.
+var ErrInvalidBearerToken = errors.New("invalid bearer token")
+
+// SetDeviceTokenStore enables the device authorization flow, minting bearer tokens into
+// the given store, as a functional option
+func SetDeviceTokenStore(store *deviceTokenStore) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.deviceTokens = store
+		return nil
+	}
+}
+
+// DeviceCode starts the OAuth 2.0 device authorization flow (RFC 8628) for command line
+// tools. The tool shows the user the returned user code and verification uri, then polls
+// DeviceToken until the user has approved it.
+func (p *OAuthProxy) DeviceCode(rw http.ResponseWriter, req *http.Request) {
+	logger := requestLogEntry(req)
+
+	if p.deviceTokens == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	auth, err := p.deviceTokens.start(req.Host)
+	if err == errTooManyAuthorizations {
+		logger.Warn("too many pending device authorizations")
+		p.StatsdClient.Incr("device_authorization_refused", []string{"action:device_code"}, 1.0)
+		writeOAuthError(rw, http.StatusServiceUnavailable, errSlowDown)
+		return
+	}
+	if err != nil {
+		logger.Error(err, "error starting device authorization")
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	verificationURL := p.GetRedirectURL(req.Host)
+	verificationURL.Path = "/oauth2/device"
+	verificationURI := verificationURL.String()
+	verificationURL.RawQuery = url.Values{"user_code": []string{auth.UserCode}}.Encode()
+
+	p.StatsdClient.Incr("device_authorization_started", []string{"action:device_code"}, 1.0)
+	writeJSON(rw, http.StatusOK, struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+		ExpiresIn               int64  `json:"expires_in"`
+		Interval                int64  `json:"interval"`
+	}{
+		DeviceCode:              auth.DeviceCode,
+		UserCode:                auth.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURL.String(),
+		ExpiresIn:               int64(time.Until(auth.ExpiresAt) / time.Second),
+		Interval:                int64(auth.Interval / time.Second),
+	})
+}
+
+// DeviceToken is polled by command line tools with the device code returned by
+// DeviceCode, and returns a bearer token once the user has approved the device.
+func (p *OAuthProxy) DeviceToken(rw http.ResponseWriter, req *http.Request) {
+	logger := requestLogEntry(req)
+	tags := []string{"action:device_token"}
+
+	if p.deviceTokens == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if req.PostFormValue("grant_type") != deviceCodeGrantType {
+		writeOAuthError(rw, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	rawToken, token, errCode := p.deviceTokens.poll(req.PostFormValue("device_code"), req.Host)
+	if errCode != "" {
+		writeOAuthError(rw, http.StatusBadRequest, errCode)
+		return
+	}
+
+	p.StatsdClient.Incr("bearer_token_issued", tags, 1.0)
+	logger.WithUser(token.Email).WithRequestHost(req.Host).Info(
+		fmt.Sprintf("bearer token %s issued", token.ID))
+
+	rw.Header().Set("Cache-Control", "no-store")
+	writeJSON(rw, http.StatusOK, struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{
+		AccessToken: rawToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(token.ExpiresAt) / time.Second),
+	})
+}
+
+// DeviceVerify is the verification page of the device flow. Signed in users enter the
+// user code shown by their command line tool, and approve or deny it with a POST request.
+// Approved devices receive a bearer token for the current host, with the user's identity.
+func (p *OAuthProxy) DeviceVerify(rw http.ResponseWriter, req *http.Request) {
+	logger := requestLogEntry(req)
+	tags := []string{"action:device_verify"}
+
+	if p.deviceTokens == nil {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "GET, POST")
+		p.ErrorPage(rw, req, http.StatusMethodNotAllowed, "Method Not Allowed", "Method Not Allowed")
+		return
+	}
+
+	if req.Method == http.MethodPost && !isSameOrigin(req) {
+		tags = append(tags, "error:cross_origin_request")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Cross-origin requests are not allowed")
+		return
+	}
+
+	session, err := p.authenticate(rw, req)
+	if err != nil {
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+
+	userCode := req.FormValue("user_code")
+	if req.Method == http.MethodGet {
+		p.renderDeviceVerify(rw, req, userCode, "", false)
+		return
+	}
+
+	_, err = p.deviceTokens.pending(userCode, req.Host)
+	if err != nil {
+		p.renderDeviceVerify(rw, req, "", "That code is invalid or has expired. Check the code and try again.", false)
+		return
+	}
+
+	if req.PostFormValue("action") != "approve" {
+		p.deviceTokens.deny(userCode, req.Host)
+		logger.WithUser(session.Email).WithRequestHost(req.Host).Info("device authorization denied")
+		p.renderDeviceVerify(rw, req, "", "The device was denied access.", true)
+		return
+	}
+
+	err = p.deviceTokens.approve(userCode, req.Host, &bearerToken{
+		Email:  session.Email,
+		User:   session.User,
+		Groups: session.Groups,
+	})
+	if err != nil {
+		logger.WithUser(session.Email).Error(err, "error approving device authorization")
+		p.renderDeviceVerify(rw, req, "", "That code is invalid or has expired. Check the code and try again.", false)
+		return
+	}
+
+	p.StatsdClient.Incr("device_authorization_approved", tags, 1.0)
+	logger.WithUser(session.Email).WithRequestHost(req.Host).Info("device authorization approved")
+	p.renderDeviceVerify(rw, req, "", "The device was approved. You can return to your terminal.", true)
+}
+
+func (p *OAuthProxy) renderDeviceVerify(rw http.ResponseWriter, req *http.Request, userCode, message string, done bool) {
+	rw.Header().Set("Cache-Control", "no-store")
+	p.templates.ExecuteTemplate(rw, "device.html", struct {
+		Host     string
+		UserCode string
+		Message  string
+		Done     bool
+	}{
+		Host:     req.Host,
+		UserCode: userCode,
+		Message:  message,
+		Done:     done,
+	})
+}
+
+// bearerToken returns the sso proxy bearer token of a request's Authorization header,
+// if it has one and the device flow is enabled.
+func (p *OAuthProxy) bearerToken(req *http.Request) (string, bool) {
+	if p.deviceTokens == nil {
+		return "", false
+	}
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	rawToken := strings.TrimSpace(parts[1])
+	if !strings.HasPrefix(rawToken, bearerTokenPrefix) {
+		return "", false
+	}
+	return rawToken, true
+}
+
+// authenticateBearerToken authenticates a request made with a bearer token minted by
+// the device flow, setting the same headers for the upstream as a session would.
+func (p *OAuthProxy) authenticateBearerToken(rw http.ResponseWriter, req *http.Request, rawToken string) error {
+	logger := requestLogEntry(req)
+	tags := []string{"action:authenticate", "auth_type:bearer_token"}
+
+	token, ok := p.deviceTokens.lookup(rawToken, req.Host)
+	if !ok {
+		logger.WithRequestHost(req.Host).Info("unknown, expired, or revoked bearer token")
+		return ErrInvalidBearerToken
+	}
+
+	// Group membership was checked when the session that approved the token was last
+	// validated; the token's lifetime bounds how long it's trusted for.
+	session := &sessions.SessionState{
+		Email:  token.Email,
+		User:   token.User,
+		Groups: token.Groups,
+	}
+	for _, v := range p.Validators {
+		_, EmailGroupValidator := v.(options.EmailGroupValidator)
+
+		if !EmailGroupValidator {
+			err := v.Validate(session)
+			if err != nil {
+				tags = append(tags, "error:validation_failed")
+				p.StatsdClient.Incr("application_error", tags, 1.0)
+				logger.WithUser(token.Email).Info(
+					fmt.Sprintf("permission denied: unauthorized: %q", err))
+				return ErrUserNotAuthorized
+			}
+		}
+	}
+
+	logger.WithUser(token.Email).Info(
+		fmt.Sprintf("authentication: bearer token %s validated", token.ID))
+
+	p.injectRequestHeaders.set(req.Header)
+
+	// the bearer token is only meaningful to sso proxy, and is never passed upstream
+	req.Header.Del("Authorization")
+	req.Header.Del("X-Impersonated-By")
+	req.Header.Del("X-Forwarded-Access-Token")
+	req.Header.Del(degradedHeader)
+	// bearer tokens carry no claims, so no claim header is passed, and none can be set by clients
+	p.delClaimHeaders(req)
+
+	req.Header.Set("X-Forwarded-User", token.User)
+	req.Header.Set("X-Forwarded-Email", token.Email)
+	req.Header.Set("X-Forwarded-Groups", strings.Join(token.Groups, ","))
+	setForwardedIdentity(req, middleware.Identity{User: token.User, Email: token.Email, Groups: token.Groups})
+
+	// stash authenticated user so that it can be logged later (see func logRequest)
+	rw.Header().Set(loggingUserHeader, token.Email)
+	return nil
+}
+
+// writeOAuthError writes an OAuth 2.0 error response.
+func writeOAuthError(rw http.ResponseWriter, code int, errCode string) {
+	rw.Header().Set("Cache-Control", "no-store")
+	writeJSON(rw, code, struct {
+		Error string `json:"error"`
+	}{
+		Error: errCode,
+	})
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(v)
+}
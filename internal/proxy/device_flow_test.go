@@ -0,0 +1,332 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func pollDeviceToken(t *testing.T, proxy *OAuthProxy, deviceCode string) (int, map[string]interface{}) {
+	form := url.Values{
+		"grant_type":  []string{deviceCodeGrantType},
+		"device_code": []string{deviceCode},
+	}
+	req := httptest.NewRequest("POST", "https://localhost/oauth2/device/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw := httptest.NewRecorder()
+
+	proxy.Handler().ServeHTTP(rw, req)
+
+	body := map[string]interface{}{}
+	err := json.Unmarshal(rw.Body.Bytes(), &body)
+	testutil.Assert(t, err == nil, "could not decode token response %q: %s", rw.Body.String(), err)
+	return rw.Code, body
+}
+
+func TestDeviceFlow(t *testing.T) {
+	store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+	proxy, close := testNewOAuthProxy(t,
+		setSessionStore(&sessions.MockSessionStore{Session: testSession()}),
+		SetDeviceTokenStore(store),
+	)
+	defer close()
+
+	req := httptest.NewRequest("POST", "https://localhost/oauth2/device/code", nil)
+	rw := httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+
+	codes := struct {
+		DeviceCode              string `json:"device_code"`
+		UserCode                string `json:"user_code"`
+		VerificationURI         string `json:"verification_uri"`
+		VerificationURIComplete string `json:"verification_uri_complete"`
+	}{}
+	err := json.Unmarshal(rw.Body.Bytes(), &codes)
+	testutil.Assert(t, err == nil, "could not decode device code response: %s", err)
+	testutil.Equal(t, "https://localhost/oauth2/device", codes.VerificationURI)
+	testutil.Equal(t, "https://localhost/oauth2/device?user_code="+codes.UserCode, codes.VerificationURIComplete)
+
+	code, body := pollDeviceToken(t, proxy, codes.DeviceCode)
+	testutil.Equal(t, http.StatusBadRequest, code)
+	testutil.Equal(t, errAuthorizationPending, body["error"])
+
+	// users may type the code in lower case, without the separator
+	form := url.Values{
+		"user_code": []string{strings.ToLower(strings.Replace(codes.UserCode, "-", "", 1))},
+		"action":    []string{"approve"},
+	}
+	req = httptest.NewRequest("POST", "https://localhost/oauth2/device", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://localhost")
+	rw = httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Assert(t, strings.Contains(rw.Body.String(), "The device was approved"), "expected approval, got %q", rw.Body.String())
+
+	code, body = pollDeviceToken(t, proxy, codes.DeviceCode)
+	testutil.Equal(t, http.StatusOK, code)
+	testutil.Equal(t, "Bearer", body["token_type"])
+	accessToken, _ := body["access_token"].(string)
+	testutil.Assert(t, strings.HasPrefix(accessToken, bearerTokenPrefix), "unexpected access token %q", accessToken)
+
+	// the device code can only be redeemed once
+	code, body = pollDeviceToken(t, proxy, codes.DeviceCode)
+	testutil.Equal(t, http.StatusBadRequest, code)
+	testutil.Equal(t, errInvalidGrant, body["error"])
+
+	req = httptest.NewRequest("GET", "https://localhost/api", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rw = httptest.NewRecorder()
+	proxy.Authorize(rw, req)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, "michael.bland@gsa.gov", rw.Header().Get("X-Forwarded-Email"))
+	testutil.Equal(t, "foo,bar", rw.Header().Get("X-Forwarded-Groups"))
+	testutil.Equal(t, "", req.Header.Get("Authorization"))
+}
+
+func TestDeviceCodeTooManyPending(t *testing.T) {
+	store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+	store.maxPending = 1
+	proxy, close := testNewOAuthProxy(t, SetDeviceTokenStore(store))
+	defer close()
+
+	_, err := store.start("localhost")
+	testutil.Ok(t, err)
+
+	req := httptest.NewRequest("POST", "https://localhost/oauth2/device/code", nil)
+	rw := httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	body := map[string]interface{}{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &body))
+	testutil.Equal(t, errSlowDown, body["error"])
+}
+
+func TestDeviceVerifyRejectsCrossOriginRequests(t *testing.T) {
+	store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+	proxy, close := testNewOAuthProxy(t,
+		setSessionStore(&sessions.MockSessionStore{Session: testSession()}),
+		SetDeviceTokenStore(store),
+	)
+	defer close()
+
+	auth, err := store.start("localhost")
+	testutil.Assert(t, err == nil, "unexpected error starting device authorization: %s", err)
+
+	form := url.Values{"user_code": []string{auth.UserCode}, "action": []string{"approve"}}
+	req := httptest.NewRequest("POST", "https://localhost/oauth2/device", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example.com")
+	rw := httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, req)
+
+	testutil.Equal(t, http.StatusForbidden, rw.Code)
+	_, _, errCode := store.poll(auth.DeviceCode, "localhost")
+	testutil.Equal(t, errAuthorizationPending, errCode)
+}
+
+func TestDeviceFlowDisabled(t *testing.T) {
+	proxy, close := testNewOAuthProxy(t)
+	defer close()
+
+	for _, path := range []string{"/oauth2/device", "/oauth2/device/code", "/oauth2/device/token"} {
+		req := httptest.NewRequest("POST", "https://localhost"+path, nil)
+		rw := httptest.NewRecorder()
+		proxy.Handler().ServeHTTP(rw, req)
+		testutil.Equal(t, http.StatusNotFound, rw.Code)
+	}
+}
+
+func TestAuthenticateBearerToken(t *testing.T) {
+	store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+	auth, err := store.start("localhost")
+	testutil.Assert(t, err == nil, "unexpected error starting device authorization: %s", err)
+	err = store.approve(auth.UserCode, "localhost", &bearerToken{Email: "user@example.com", User: "user"})
+	testutil.Assert(t, err == nil, "unexpected error approving device authorization: %s", err)
+	rawToken, _, _ := store.poll(auth.DeviceCode, "localhost")
+
+	testCases := []struct {
+		name          string
+		host          string
+		authorization string
+		expectedCode  int
+		expectedEmail string
+	}{
+		{
+			name:          "valid token",
+			host:          "localhost",
+			authorization: "Bearer " + rawToken,
+			expectedCode:  http.StatusOK,
+			expectedEmail: "user@example.com",
+		},
+		{
+			name:          "unknown token",
+			host:          "localhost",
+			authorization: "Bearer " + bearerTokenPrefix + "unknown",
+			expectedCode:  http.StatusUnauthorized,
+		},
+		{
+			name:          "token for another host",
+			host:          "other.localhost",
+			authorization: "Bearer " + rawToken,
+			expectedCode:  http.StatusUnauthorized,
+		},
+		{
+			name:          "other bearer tokens are left for the upstream",
+			host:          "localhost",
+			authorization: "Bearer upstream-token",
+			expectedCode:  http.StatusOK,
+			expectedEmail: "michael.bland@gsa.gov",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t,
+				setSessionStore(&sessions.MockSessionStore{Session: testSession()}),
+				SetDeviceTokenStore(store),
+			)
+			defer close()
+
+			req := httptest.NewRequest("GET", "https://"+tc.host+"/api", nil)
+			req.Header.Set("Authorization", tc.authorization)
+			rw := httptest.NewRecorder()
+			proxy.Authorize(rw, req)
+
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+			testutil.Equal(t, tc.expectedEmail, rw.Header().Get("X-Forwarded-Email"))
+			if tc.expectedCode == http.StatusUnauthorized {
+				testutil.Equal(t, `Bearer error="invalid_token"`, rw.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAuthenticateBearerTokenClaimHeaders(t *testing.T) {
+	store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+	auth, err := store.start("localhost")
+	testutil.Assert(t, err == nil, "unexpected error starting device authorization: %s", err)
+	err = store.approve(auth.UserCode, "localhost", &bearerToken{Email: "user@example.com", User: "user"})
+	testutil.Assert(t, err == nil, "unexpected error approving device authorization: %s", err)
+	rawToken, _, _ := store.poll(auth.DeviceCode, "localhost")
+
+	proxy, close := testNewOAuthProxy(t,
+		SetDeviceTokenStore(store),
+		SetUpstreamConfig(&UpstreamConfig{
+			ClaimHeaders: map[string]string{"employee_id": "X-Employee-Id"},
+		}),
+	)
+	defer close()
+
+	req := httptest.NewRequest("GET", "https://localhost/api", nil)
+	req.Header.Set("Authorization", "Bearer "+rawToken)
+	req.Header.Set("X-Employee-Id", "1234")
+	req.Header.Set("X-Forwarded-Name", "Someone Else")
+	req.Header.Set(degradedHeader, "allow")
+	err = proxy.Authenticate(httptest.NewRecorder(), req)
+	testutil.Ok(t, err)
+
+	testutil.Equal(t, "user@example.com", req.Header.Get("X-Forwarded-Email"))
+	testutil.Equal(t, "", req.Header.Get("X-Employee-Id"))
+	testutil.Equal(t, "", req.Header.Get("X-Forwarded-Name"))
+	testutil.Equal(t, "", req.Header.Get(degradedHeader))
+}
+
+func TestDeviceTokenStore(t *testing.T) {
+	t.Run("slow down", func(t *testing.T) {
+		store := newDeviceTokenStore(time.Minute, time.Hour, time.Hour)
+		auth, _ := store.start("localhost")
+
+		_, _, errCode := store.poll(auth.DeviceCode, "localhost")
+		testutil.Equal(t, errAuthorizationPending, errCode)
+		_, _, errCode = store.poll(auth.DeviceCode, "localhost")
+		testutil.Equal(t, errSlowDown, errCode)
+	})
+
+	t.Run("too many pending", func(t *testing.T) {
+		store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+		store.maxPending = 2
+		_, err := store.start("localhost")
+		testutil.Ok(t, err)
+		auth, err := store.start("localhost")
+		testutil.Ok(t, err)
+
+		_, err = store.start("localhost")
+		testutil.Equal(t, errTooManyAuthorizations, err)
+
+		// authorizations that are no longer pending make room for new ones
+		store.deny(auth.UserCode, "localhost")
+		store.poll(auth.DeviceCode, "localhost")
+		_, err = store.start("localhost")
+		testutil.Ok(t, err)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+		auth, _ := store.start("localhost")
+
+		err := store.deny(auth.UserCode, "localhost")
+		testutil.Assert(t, err == nil, "unexpected error denying device authorization: %s", err)
+		_, _, errCode := store.poll(auth.DeviceCode, "localhost")
+		testutil.Equal(t, errAccessDenied, errCode)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		store := newDeviceTokenStore(-1*time.Second, time.Hour, 0)
+		auth, _ := store.start("localhost")
+
+		err := store.approve(auth.UserCode, "localhost", &bearerToken{Email: "user@example.com"})
+		testutil.Equal(t, errUnknownUserCode, err)
+		_, _, errCode := store.poll(auth.DeviceCode, "localhost")
+		testutil.Equal(t, errExpiredToken, errCode)
+	})
+
+	t.Run("wrong host", func(t *testing.T) {
+		store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+		auth, _ := store.start("localhost")
+
+		err := store.approve(auth.UserCode, "other.localhost", &bearerToken{Email: "user@example.com"})
+		testutil.Equal(t, errUnknownUserCode, err)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		store := newDeviceTokenStore(time.Minute, -1*time.Second, 0)
+		auth, _ := store.start("localhost")
+		store.approve(auth.UserCode, "localhost", &bearerToken{Email: "user@example.com"})
+		rawToken, _, _ := store.poll(auth.DeviceCode, "localhost")
+
+		_, ok := store.lookup(rawToken, "localhost")
+		testutil.Assert(t, !ok, "expected expired token to be rejected")
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+		auth, _ := store.start("localhost")
+		store.approve(auth.UserCode, "localhost", &bearerToken{Email: "user@example.com"})
+		rawToken, token, _ := store.poll(auth.DeviceCode, "localhost")
+
+		_, ok := store.lookup(rawToken, "localhost")
+		testutil.Assert(t, ok, "expected token to be valid")
+		_, ok = store.revoke(token.ID)
+		testutil.Assert(t, ok, "expected token to be revoked")
+		_, ok = store.lookup(rawToken, "localhost")
+		testutil.Assert(t, !ok, "expected revoked token to be rejected")
+	})
+}
+
+func TestNewUserCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code, err := newUserCode()
+		testutil.Assert(t, err == nil, "unexpected error generating user code: %s", err)
+		testutil.Equal(t, 9, len(code))
+		testutil.Equal(t, code, normalizeUserCode(strings.ToLower(code)))
+	}
+}
@@ -0,0 +1,383 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bearerTokenPrefix marks the bearer tokens minted by sso proxy, so that an Authorization
+// header meant for the upstream itself is never mistaken for one of ours.
+const bearerTokenPrefix = "sso_"
+
+// userCodeAlphabet omits vowels and easily confused characters, following the
+// recommendations of RFC 8628 for codes that users type by hand.
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const (
+	// deviceCodeTTL is how long a user has to approve a device after it starts the flow.
+	deviceCodeTTL = 10 * time.Minute
+	// devicePollInterval is the minimum time devices must wait between polls.
+	devicePollInterval = 5 * time.Second
+	// deviceMaxPending bounds the memory held by pending device authorizations, which
+	// anyone can start, so new ones are refused once it is reached.
+	deviceMaxPending = 10000
+)
+
+// Device authorization errors, as defined by RFC 8628 section 3.5.
+const (
+	errAuthorizationPending = "authorization_pending"
+	errSlowDown             = "slow_down"
+	errAccessDenied         = "access_denied"
+	errExpiredToken         = "expired_token"
+	errInvalidGrant         = "invalid_grant"
+)
+
+var (
+	errUnknownUserCode       = errors.New("unknown or expired user code")
+	errTooManyAuthorizations = errors.New("too many pending device authorizations")
+)
+
+// bearerToken is the identity a bearer token minted through the device flow authenticates as.
+type bearerToken struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	User      string    `json:"user"`
+	Groups    []string  `json:"groups"`
+	Host      string    `json:"host"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// deviceAuthorization is a pending device flow, waiting for a user to approve it.
+type deviceAuthorization struct {
+	DeviceCode string
+	UserCode   string
+	Host       string
+	ExpiresAt  time.Time
+	Interval   time.Duration
+
+	lastPolled time.Time
+	denied     bool
+	// token is minted when the authorization is approved, and handed to the device
+	// on its next poll.
+	token    *bearerToken
+	rawToken string
+}
+
+// deviceTokenStore holds pending device authorizations and the bearer tokens minted for
+// them. Tokens are stored by their hash, so the tokens themselves are only ever known to
+// the device that requested them.
+//
+// The store is held in memory, and shared by every upstream of a single sso proxy process.
+type deviceTokenStore struct {
+	mu             sync.Mutex
+	authorizations map[string]*deviceAuthorization // by device code
+	userCodes      map[string]string               // user code to device code
+	tokens         map[string]*bearerToken         // by token hash
+
+	codeTTL    time.Duration
+	tokenTTL   time.Duration
+	interval   time.Duration
+	maxPending int
+}
+
+func newDeviceTokenStore(codeTTL, tokenTTL, interval time.Duration) *deviceTokenStore {
+	return &deviceTokenStore{
+		authorizations: make(map[string]*deviceAuthorization),
+		userCodes:      make(map[string]string),
+		tokens:         make(map[string]*bearerToken),
+		codeTTL:        codeTTL,
+		tokenTTL:       tokenTTL,
+		interval:       interval,
+		maxPending:     deviceMaxPending,
+	}
+}
+
+// start begins a device authorization for a host, or returns errTooManyAuthorizations if
+// too many are already pending.
+func (s *deviceTokenStore) start(host string) (*deviceAuthorization, error) {
+	deviceCode, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+	if len(s.authorizations) >= s.maxPending {
+		return nil, errTooManyAuthorizations
+	}
+
+	var userCode string
+	for {
+		userCode, err = newUserCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := s.userCodes[userCode]; !ok {
+			break
+		}
+	}
+
+	auth := &deviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		Host:       host,
+		ExpiresAt:  time.Now().Add(s.codeTTL),
+		Interval:   s.interval,
+	}
+	s.authorizations[deviceCode] = auth
+	s.userCodes[userCode] = deviceCode
+
+	copied := *auth
+	return &copied, nil
+}
+
+// pending returns the pending authorization for a user code entered on a host.
+func (s *deviceTokenStore) pending(userCode, host string) (*deviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	auth := s.lookupUserCode(userCode, host, time.Now())
+	if auth == nil || auth.denied || auth.token != nil {
+		return nil, errUnknownUserCode
+	}
+	copied := *auth
+	return &copied, nil
+}
+
+// approve mints a bearer token for the authorization with the given user code. The token
+// is handed to the device the next time it polls.
+func (s *deviceTokenStore) approve(userCode, host string, token *bearerToken) error {
+	rawToken, err := randomString(32)
+	if err != nil {
+		return err
+	}
+	id, err := randomString(12)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	auth := s.lookupUserCode(userCode, host, now)
+	if auth == nil || auth.denied || auth.token != nil {
+		return errUnknownUserCode
+	}
+
+	token.ID = id
+	token.Host = host
+	token.IssuedAt = now
+	token.ExpiresAt = now.Add(s.tokenTTL)
+	auth.token = token
+	auth.rawToken = bearerTokenPrefix + rawToken
+	return nil
+}
+
+// deny rejects the authorization with the given user code.
+func (s *deviceTokenStore) deny(userCode, host string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	auth := s.lookupUserCode(userCode, host, time.Now())
+	if auth == nil || auth.token != nil {
+		return errUnknownUserCode
+	}
+	auth.denied = true
+	return nil
+}
+
+// poll returns the bearer token for an approved device authorization, or the RFC 8628
+// error code describing why it can't be issued yet.
+func (s *deviceTokenStore) poll(deviceCode, host string) (string, *bearerToken, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	auth, ok := s.authorizations[deviceCode]
+	if !ok || auth.Host != host {
+		return "", nil, errInvalidGrant
+	}
+	if now.After(auth.ExpiresAt) {
+		s.remove(auth)
+		return "", nil, errExpiredToken
+	}
+	if auth.denied {
+		s.remove(auth)
+		return "", nil, errAccessDenied
+	}
+	if auth.token == nil {
+		tooSoon := now.Sub(auth.lastPolled) < auth.Interval
+		auth.lastPolled = now
+		if tooSoon {
+			return "", nil, errSlowDown
+		}
+		return "", nil, errAuthorizationPending
+	}
+
+	s.remove(auth)
+	s.tokens[hashToken(auth.rawToken)] = auth.token
+	copied := *auth.token
+	return auth.rawToken, &copied, ""
+}
+
+// lookup returns the unexpired token for a bearer token presented to a host.
+func (s *deviceTokenStore) lookup(rawToken, host string) (*bearerToken, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hashToken(rawToken)]
+	if !ok || token.Host != host || time.Now().After(token.ExpiresAt) {
+		return nil, false
+	}
+	copied := *token
+	return &copied, true
+}
+
+// list returns the unexpired tokens, optionally restricted to those of a single user.
+func (s *deviceTokenStore) list(email string) []*bearerToken {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune(time.Now())
+
+	tokens := []*bearerToken{}
+	for _, token := range s.tokens {
+		if email != "" && !strings.EqualFold(token.Email, email) {
+			continue
+		}
+		copied := *token
+		tokens = append(tokens, &copied)
+	}
+	return tokens
+}
+
+// revoke revokes the token with the given id, returning it, or false if there is no such token.
+func (s *deviceTokenStore) revoke(id string) (*bearerToken, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.tokens {
+		if token.ID == id {
+			delete(s.tokens, hash)
+			return token, true
+		}
+	}
+	return nil, false
+}
+
+// revokeUser revokes every token of a user, returning the number of tokens revoked.
+func (s *deviceTokenStore) revokeUser(email string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := 0
+	for hash, token := range s.tokens {
+		if strings.EqualFold(token.Email, email) {
+			delete(s.tokens, hash)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// purgeUser revokes every token of a user, like revokeUser, and also drops the authorizations
+// approved by the user whose tokens haven't been handed to their devices yet.
+func (s *deviceTokenStore) purgeUser(email string) int {
+	revoked := s.revokeUser(email)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, auth := range s.authorizations {
+		if auth.token != nil && strings.EqualFold(auth.token.Email, email) {
+			s.remove(auth)
+			revoked++
+		}
+	}
+	return revoked
+}
+
+// lookupUserCode must be called with the lock held.
+func (s *deviceTokenStore) lookupUserCode(userCode, host string, now time.Time) *deviceAuthorization {
+	deviceCode, ok := s.userCodes[normalizeUserCode(userCode)]
+	if !ok {
+		return nil
+	}
+	auth := s.authorizations[deviceCode]
+	if auth.Host != host || now.After(auth.ExpiresAt) {
+		return nil
+	}
+	return auth
+}
+
+// remove must be called with the lock held.
+func (s *deviceTokenStore) remove(auth *deviceAuthorization) {
+	delete(s.authorizations, auth.DeviceCode)
+	delete(s.userCodes, auth.UserCode)
+}
+
+// prune drops expired authorizations and tokens, and must be called with the lock held.
+func (s *deviceTokenStore) prune(now time.Time) {
+	for _, auth := range s.authorizations {
+		if now.After(auth.ExpiresAt) {
+			s.remove(auth)
+		}
+	}
+	for hash, token := range s.tokens {
+		if now.After(token.ExpiresAt) {
+			delete(s.tokens, hash)
+		}
+	}
+}
+
+// newUserCode returns a random user code formatted as XXXX-XXXX.
+func newUserCode() (string, error) {
+	code := make([]byte, 0, 9)
+	b := make([]byte, 1)
+	for len(code) < 9 {
+		if len(code) == 4 {
+			code = append(code, '-')
+		}
+		_, err := rand.Read(b)
+		if err != nil {
+			return "", err
+		}
+		// discard bytes past the largest multiple of the alphabet size, so every
+		// character is equally likely
+		if int(b[0]) >= 256-256%len(userCodeAlphabet) {
+			continue
+		}
+		code = append(code, userCodeAlphabet[int(b[0])%len(userCodeAlphabet)])
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode accepts user codes typed in lower case or without the separator.
+func normalizeUserCode(userCode string) string {
+	code := strings.ToUpper(strings.Replace(strings.TrimSpace(userCode), "-", "", -1))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(rawToken string) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// drainStatus counts the requests in flight on the proxy's server, and when it's shutting down,
+// the deadline they have to finish by, so that deployment tooling can wait for the proxy to be
+// drained through the admin API instead of sleeping for the whole shutdown timeout.
+type drainStatus struct {
+	inflight int64
+
+	mu       sync.RWMutex
+	started  time.Time
+	deadline time.Time
+	now      func() time.Time
+}
+
+func newDrainStatus() *drainStatus {
+	return &drainStatus{now: time.Now}
+}
+
+// drainState is the drain status of the proxy, as reported by the admin API. Its deadline and
+// remaining seconds are only set once it's draining.
+type drainState struct {
+	Draining         bool       `json:"draining"`
+	Drained          bool       `json:"drained"`
+	Inflight         int64      `json:"inflight"`
+	Started          *time.Time `json:"started,omitempty"`
+	Deadline         *time.Time `json:"deadline,omitempty"`
+	RemainingSeconds float64    `json:"remaining_seconds"`
+}
+
+// handler counts the requests in flight on a handler.
+func (d *drainStatus) handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&d.inflight, 1)
+		defer atomic.AddInt64(&d.inflight, -1)
+		handler.ServeHTTP(rw, req)
+	})
+}
+
+// start marks the proxy as draining, until the deadline requests in flight have to finish by.
+func (d *drainStatus) start(deadline time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.started.IsZero() {
+		return
+	}
+	d.started = d.now()
+	d.deadline = deadline
+}
+
+func (d *drainStatus) state() drainState {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	state := drainState{
+		Draining: !d.started.IsZero(),
+		Inflight: atomic.LoadInt64(&d.inflight),
+	}
+	if !state.Draining {
+		return state
+	}
+
+	started, deadline := d.started, d.deadline
+	state.Started, state.Deadline = &started, &deadline
+	state.Drained = state.Inflight == 0
+	if remaining := deadline.Sub(d.now()); remaining > 0 {
+		state.RemainingSeconds = remaining.Seconds()
+	}
+	return state
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestDrainStatus(t *testing.T) {
+	drain := newDrainStatus()
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	drain.now = func() time.Time { return now }
+
+	served := make(chan struct{})
+	release := make(chan struct{})
+	handler := drain.handler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		served <- struct{}{}
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://foo.sso.dev/", nil))
+		close(done)
+	}()
+	<-served
+
+	testutil.Equal(t, drainState{Inflight: 1}, drain.state())
+
+	deadline := now.Add(30 * time.Second)
+	drain.start(deadline)
+	now = now.Add(10 * time.Second)
+	// a drain that's started already keeps its deadline
+	drain.start(now.Add(30 * time.Second))
+
+	state := drain.state()
+	testutil.Equal(t, true, state.Draining)
+	testutil.Equal(t, false, state.Drained)
+	testutil.Equal(t, int64(1), state.Inflight)
+	testutil.Equal(t, deadline, *state.Deadline)
+	testutil.Equal(t, 20.0, state.RemainingSeconds)
+
+	close(release)
+	<-done
+	state = drain.state()
+	testutil.Equal(t, true, state.Drained)
+	testutil.Equal(t, int64(0), state.Inflight)
+
+	now = now.Add(time.Minute)
+	testutil.Equal(t, 0.0, drain.state().RemainingSeconds)
+}
+
+func TestAdminDrain(t *testing.T) {
+	drain := newDrainStatus()
+	handler := newAdminHandler(adminConfig{token: "admin-token", drain: drain})
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost/admin/drain", nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := serve("GET")
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, "{\"draining\":false,\"drained\":false,\"inflight\":0,\"remaining_seconds\":0}\n", rw.Body.String())
+
+	proxy := &SSOProxy{drain: drain}
+	proxy.StartDrain(time.Now().Add(time.Minute))
+	rw = serve("GET")
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	state := drainState{}
+	err := json.Unmarshal(rw.Body.Bytes(), &state)
+	testutil.Assert(t, err == nil, "could not decode drain status: %s", err)
+	testutil.Equal(t, true, state.Draining)
+	testutil.Equal(t, true, state.Drained)
+	testutil.Assert(t, state.RemainingSeconds > 0, "expected time to remain before the deadline, got %v", state.RemainingSeconds)
+
+	testutil.Equal(t, http.StatusMethodNotAllowed, serve("POST").Code)
+}
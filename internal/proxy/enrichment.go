@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/singleflight"
+	"github.com/datadog/datadog-go/statsd"
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	defaultEnrichmentTimeout  = 100 * time.Millisecond
+	defaultEnrichmentCacheTTL = time.Minute
+
+	// maxEnrichmentCacheEntries is the number of identities whose headers are cached for each
+	// upstream. Once it's reached, the headers of other identities aren't cached until entries
+	// expire.
+	maxEnrichmentCacheEntries = 10000
+
+	// maxEnrichmentResponseSize is the largest response of an enrichment service that is read.
+	maxEnrichmentResponseSize = 64 * 1024
+)
+
+// EnrichmentConfig maps to the enrichment yaml config fields of an upstream:
+// * url - the url of the service each request's identity is sent to, which returns the headers injected into it
+// * headers - the headers the service may inject, which are removed from requests to the upstream otherwise
+// * timeout - the time a call to the service may take, defaults to 100ms
+// * cache_ttl - the time the headers of an identity are cached for, defaults to 1m
+// * fail_open - proxy requests without the headers when the service fails, instead of rejecting them
+type EnrichmentConfig struct {
+	URL      string        `yaml:"url"`
+	Headers  []string      `yaml:"headers"`
+	Timeout  time.Duration `yaml:"timeout"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	FailOpen bool          `yaml:"fail_open"`
+
+	url *url.URL
+}
+
+func (c *EnrichmentConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https url")
+	}
+	c.url = u
+
+	if len(c.Headers) == 0 {
+		return fmt.Errorf("headers is required")
+	}
+	for i, header := range c.Headers {
+		header = http.CanonicalHeaderKey(header)
+		// the identity the upstream is sent, and the signature of its requests, are the proxy's own
+		if strings.HasPrefix(header, "X-Forwarded-") || header == "Authorization" || header == "Cookie" ||
+			header == signatureHeader {
+			return fmt.Errorf("header %s can't be set by the enrichment service", header)
+		}
+		c.Headers[i] = header
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = defaultEnrichmentTimeout
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if c.CacheTTL == 0 {
+		c.CacheTTL = defaultEnrichmentCacheTTL
+	}
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("cache_ttl must not be negative")
+	}
+	return nil
+}
+
+// enrichmentRequest is the body of requests to an enrichment service.
+type enrichmentRequest struct {
+	Service string   `json:"service"`
+	User    string   `json:"user"`
+	Email   string   `json:"email"`
+	Groups  []string `json:"groups"`
+}
+
+// enrichmentResponse is the body of the responses of an enrichment service.
+type enrichmentResponse struct {
+	Headers map[string]string `json:"headers"`
+}
+
+type enrichmentEntry struct {
+	headers map[string]string
+	expires time.Time
+}
+
+// enrichment injects the headers an enrichment service returns for the identity of each
+// request to an upstream.
+type enrichment struct {
+	handler http.Handler
+	config  *EnrichmentConfig
+	service string
+	client  *http.Client
+
+	calls singleflight.Group
+	mu    sync.Mutex
+	cache map[string]enrichmentEntry
+	now   func() time.Time
+
+	StatsdClient *statsd.Client
+}
+
+// newEnrichmentHandler wraps the handler of an upstream, injecting the headers of its
+// enrichment service.
+func newEnrichmentHandler(handler http.Handler, config *UpstreamConfig, statsdClient *statsd.Client) http.Handler {
+	return &enrichment{
+		handler: handler,
+		config:  config.Enrichment,
+		service: config.Service,
+		client: &http.Client{
+			Timeout: config.Enrichment.Timeout,
+		},
+		cache:        make(map[string]enrichmentEntry),
+		now:          time.Now,
+		StatsdClient: statsdClient,
+	}
+}
+
+func (e *enrichment) incr(result string) {
+	e.StatsdClient.Incr("upstream.enrichment", []string{"service:" + e.service, "result:" + result}, 1.0)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (e *enrichment) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// only the enrichment service can set its headers
+	for _, header := range e.config.Headers {
+		req.Header.Del(header)
+	}
+
+	// requests that skip authentication have no identity to enrich, whatever headers they're
+	// sent with
+	forwarded, ok := forwardedIdentity(req.Context())
+	if !ok {
+		e.handler.ServeHTTP(rw, req)
+		return
+	}
+	identity := enrichmentRequest{
+		Service: e.service,
+		User:    forwarded.User,
+		Email:   forwarded.Email,
+		Groups:  forwarded.Groups,
+	}
+
+	headers, err := e.headers(identity)
+	if err != nil {
+		log.NewLogEntry().
+			WithServiceName(e.service).
+			WithRequestID(requestID(req)).
+			WithUser(identity.Email).
+			WithError(err).
+			Error("error calling enrichment service")
+		if !e.config.FailOpen {
+			e.incr("error")
+			http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		e.incr("failed_open")
+	}
+
+	for _, header := range e.config.Headers {
+		if value, ok := headers[header]; ok {
+			req.Header.Set(header, value)
+		}
+	}
+	e.handler.ServeHTTP(rw, req)
+}
+
+// headers returns the headers of an identity, from the cache or the enrichment service.
+func (e *enrichment) headers(identity enrichmentRequest) (map[string]string, error) {
+	key := strings.Join([]string{identity.User, identity.Email, strings.Join(identity.Groups, ",")}, "\x00")
+
+	e.mu.Lock()
+	entry, ok := e.cache[key]
+	e.mu.Unlock()
+	if ok && e.now().Before(entry.expires) {
+		e.incr("cache_hit")
+		return entry.headers, nil
+	}
+
+	// concurrent requests of the same identity share a call to the service
+	v, _, err := e.calls.Do(key, func() (interface{}, error) {
+		headers, err := e.call(identity)
+		if err != nil {
+			return nil, err
+		}
+		e.store(key, headers)
+		return headers, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.incr("ok")
+	return v.(map[string]string), nil
+}
+
+// call calls the enrichment service, returning the headers it set for an identity.
+func (e *enrichment) call(identity enrichmentRequest) (map[string]string, error) {
+	body, err := json.Marshal(identity)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", e.config.url.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxEnrichmentResponseSize))
+		return nil, fmt.Errorf("enrichment service responded with status %d", resp.StatusCode)
+	}
+
+	var response enrichmentResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxEnrichmentResponseSize)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("error decoding enrichment service response: %s", err)
+	}
+	headers := make(map[string]string, len(response.Headers))
+	for name, value := range response.Headers {
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("enrichment service returned an invalid value of header %s", name)
+		}
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	return headers, nil
+}
+
+// store caches the headers of an identity for the cache_ttl.
+func (e *enrichment) store(key string, headers map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	if len(e.cache) >= maxEnrichmentCacheEntries {
+		for k, entry := range e.cache {
+			if !now.Before(entry.expires) {
+				delete(e.cache, k)
+			}
+		}
+		if len(e.cache) >= maxEnrichmentCacheEntries {
+			return
+		}
+	}
+	e.cache[key] = enrichmentEntry{headers: headers, expires: now.Add(e.config.CacheTTL)}
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/pkg/middleware"
+)
+
+func TestEnrichmentConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  *EnrichmentConfig
+		wantErr string
+	}{
+		{
+			name:    "invalid url",
+			config:  &EnrichmentConfig{URL: "entitlements.internal", Headers: []string{"X-Plan"}},
+			wantErr: "url must be an http or https url",
+		},
+		{
+			name:    "without headers",
+			config:  &EnrichmentConfig{URL: "http://entitlements.internal"},
+			wantErr: "headers is required",
+		},
+		{
+			name:    "identity header",
+			config:  &EnrichmentConfig{URL: "http://entitlements.internal", Headers: []string{"x-forwarded-email"}},
+			wantErr: "header X-Forwarded-Email can't be set by the enrichment service",
+		},
+		{
+			name:    "negative cache ttl",
+			config:  &EnrichmentConfig{URL: "http://entitlements.internal", Headers: []string{"X-Plan"}, CacheTTL: -time.Second},
+			wantErr: "cache_ttl must not be negative",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.validate()
+			testutil.NotEqual(t, nil, err)
+			testutil.Equal(t, tc.wantErr, err.Error())
+		})
+	}
+
+	config := &EnrichmentConfig{URL: "http://entitlements.internal", Headers: []string{"x-plan"}}
+	testutil.Ok(t, config.validate())
+	testutil.Equal(t, []string{"X-Plan"}, config.Headers)
+	testutil.Equal(t, defaultEnrichmentTimeout, config.Timeout)
+	testutil.Equal(t, defaultEnrichmentCacheTTL, config.CacheTTL)
+}
+
+func TestEnrichment(t *testing.T) {
+	var calls int32
+	var sent enrichmentRequest
+	service := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		json.NewDecoder(req.Body).Decode(&sent)
+		rw.Write([]byte(`{"headers": {"x-plan": "enterprise", "X-Flags": "beta", "X-Other": "ignored"}}`))
+	}))
+	defer service.Close()
+
+	config := &EnrichmentConfig{URL: service.URL, Headers: []string{"X-Plan", "X-Flags", "X-Missing"}}
+	testutil.Ok(t, config.validate())
+	var got http.Header
+	e := newEnrichmentHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = req.Header
+	}), &UpstreamConfig{Service: "foo", Enrichment: config}, nil).(*enrichment)
+	now := time.Now()
+	e.now = func() time.Time { return now }
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+		req = req.WithContext(NewSessionContext(req.Context()))
+		setForwardedIdentity(req, middleware.Identity{User: "user", Email: "user@example.com", Groups: []string{"admins", "engineers"}})
+		req.Header.Set("X-Missing", "spoofed")
+		return req
+	}
+
+	e.ServeHTTP(httptest.NewRecorder(), newRequest())
+	testutil.Equal(t, enrichmentRequest{
+		Service: "foo",
+		User:    "user",
+		Email:   "user@example.com",
+		Groups:  []string{"admins", "engineers"},
+	}, sent)
+	testutil.Equal(t, "enterprise", got.Get("X-Plan"))
+	testutil.Equal(t, "beta", got.Get("X-Flags"))
+	testutil.Equal(t, "", got.Get("X-Other"))
+	testutil.Equal(t, "", got.Get("X-Missing"))
+
+	// the headers are cached until the cache_ttl passes
+	e.ServeHTTP(httptest.NewRecorder(), newRequest())
+	testutil.Equal(t, "enterprise", got.Get("X-Plan"))
+	testutil.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	now = now.Add(defaultEnrichmentCacheTTL)
+	e.ServeHTTP(httptest.NewRecorder(), newRequest())
+	testutil.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// requests without an identity aren't enriched, whatever headers they're sent with
+	req := httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+	req.Header.Set("X-Forwarded-Email", "user@example.com")
+	req.Header.Set("X-Plan", "spoofed")
+	e.ServeHTTP(httptest.NewRecorder(), req)
+	testutil.Equal(t, "", got.Get("X-Plan"))
+	testutil.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestEnrichmentFailures(t *testing.T) {
+	testCases := []struct {
+		name       string
+		handler    http.HandlerFunc
+		failOpen   bool
+		wantStatus int
+	}{
+		{
+			name: "error status",
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusInternalServerError)
+			},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "timeout",
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				time.Sleep(50 * time.Millisecond)
+			},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "invalid header value",
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				rw.Write([]byte(`{"headers": {"X-Plan": "a\r\nb"}}`))
+			},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "fail open",
+			handler: func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusInternalServerError)
+			},
+			failOpen:   true,
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := httptest.NewServer(tc.handler)
+			defer service.Close()
+
+			config := &EnrichmentConfig{
+				URL:      service.URL,
+				Headers:  []string{"X-Plan"},
+				Timeout:  10 * time.Millisecond,
+				FailOpen: tc.failOpen,
+			}
+			testutil.Ok(t, config.validate())
+			handler := newEnrichmentHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				testutil.Equal(t, "", req.Header.Get("X-Plan"))
+			}), &UpstreamConfig{Service: "foo", Enrichment: config}, nil)
+
+			req := httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+			req = req.WithContext(NewSessionContext(req.Context()))
+			setForwardedIdentity(req, middleware.Identity{Email: "user@example.com"})
+			req.Header.Set("X-Plan", "spoofed")
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			testutil.Equal(t, tc.wantStatus, rw.Code)
+		})
+	}
+}
+
+func TestEnrichmentSkipAuth(t *testing.T) {
+	var calls int32
+	service := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.Write([]byte(`{"headers": {"X-Plan": "enterprise"}}`))
+	}))
+	defer service.Close()
+
+	config := &EnrichmentConfig{URL: service.URL, Headers: []string{"X-Plan"}}
+	testutil.Ok(t, config.validate())
+	upstreamConfig := &UpstreamConfig{
+		Service:               "foo",
+		Enrichment:            config,
+		SkipAuthCompiledRegex: []*regexp.Regexp{regexp.MustCompile(`^/public$`)},
+	}
+	var got http.Header
+	backend := newEnrichmentHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = req.Header
+	}), upstreamConfig, nil)
+	proxy, close := testNewOAuthProxy(t,
+		SetProxyHandler(backend),
+		SetUpstreamConfig(upstreamConfig),
+		setSessionStore(&sessions.MockSessionStore{LoadError: http.ErrNoCookie}),
+	)
+	defer close()
+
+	// the identity headers of a request that skips authentication are the client's own
+	req := httptest.NewRequest("GET", "https://localhost/public", nil)
+	req.Header.Set("X-Forwarded-User", "admin")
+	req.Header.Set("X-Forwarded-Email", "admin@example.com")
+	req.Header.Set("X-Forwarded-Groups", "admins")
+	rw := httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, req)
+
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, "", got.Get("X-Plan"))
+	testutil.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
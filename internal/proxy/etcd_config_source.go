@@ -0,0 +1,467 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// etcdUpstreamsKey prefixes the keys of the services of an etcd prefix, each a service of an
+	// upstream configs file, e.g. /sso/upstreams/foo
+	etcdUpstreamsKey = "upstreams/"
+	// etcdOverridesKey prefixes the keys of the overrides of an etcd prefix, each options that
+	// override those of every cluster of the service of the same name, e.g. /sso/overrides/foo
+	etcdOverridesKey = "overrides/"
+)
+
+// etcdTemplateVar matches the placeholders template variables are replaced with while the
+// services of an etcd prefix are merged with their overrides.
+var etcdTemplateVar = regexp.MustCompile(`sso_template_var_\d+`)
+
+// etcdConfigSource reads the services of the upstream configs from the keys of an etcd prefix,
+// through the JSON gateway of the etcd v3 API, and polls the prefix for changes, which are
+// applied. Polls only read the values of the keys once the version of the prefix has moved.
+type etcdConfigSource struct {
+	endpoints []string
+	prefix    string
+	username  string
+	password  string
+	interval  time.Duration
+	client    *http.Client
+
+	StatsdClient *statsd.Client
+
+	// tokens are the auth tokens of the endpoints, kept across polls until etcd rejects them
+	tokens map[string]string
+
+	// revision is the etcd revision the running configs were read at, version the version of the
+	// prefix they were read from, and digest the digest of the configs
+	revision int64
+	version  etcdPrefixVersion
+	digest   [sha256.Size]byte
+}
+
+// etcdPrefixVersion identifies the keys of a prefix: any put moves the latest mod revision of
+// its keys, and deletes alone lower their count. Unlike the revision of the etcd cluster, it
+// doesn't move with keys outside the prefix.
+type etcdPrefixVersion struct {
+	modRevision int64
+	count       int64
+}
+
+// etcdStatusError is the error of a request the JSON gateway answered with an unexpected status.
+type etcdStatusError struct {
+	statusCode int
+	body       []byte
+}
+
+func (e *etcdStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.statusCode, e.body)
+}
+
+// etcdRangeResponse is the response of the JSON gateway to a range request, whose keys and
+// values are base64 encoded.
+type etcdRangeResponse struct {
+	Header struct {
+		Revision int64 `json:"revision,string"`
+	} `json:"header"`
+	KVs []struct {
+		Key         []byte `json:"key"`
+		Value       []byte `json:"value"`
+		ModRevision int64  `json:"mod_revision,string"`
+	} `json:"kvs"`
+	Count int64 `json:"count,string"`
+}
+
+// version returns the version of the prefix, from a range request sorted by descending mod
+// revision, or of all of its keys.
+func (r *etcdRangeResponse) version() etcdPrefixVersion {
+	version := etcdPrefixVersion{count: r.Count}
+	for _, kv := range r.KVs {
+		if kv.ModRevision > version.modRevision {
+			version.modRevision = kv.ModRevision
+		}
+	}
+	return version
+}
+
+// newEtcdConfigSource returns the etcdConfigSource of the options.
+func newEtcdConfigSource(opts *Options) *etcdConfigSource {
+	endpoints := make([]string, 0, len(opts.UpstreamConfigsEtcdEndpoints))
+	for _, endpoint := range opts.UpstreamConfigsEtcdEndpoints {
+		if endpoint = strings.TrimRight(strings.TrimSpace(endpoint), "/"); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return &etcdConfigSource{
+		endpoints: endpoints,
+		prefix:    opts.UpstreamConfigsEtcdPrefix,
+		username:  opts.UpstreamConfigsEtcdUsername,
+		password:  opts.UpstreamConfigsEtcdPassword,
+		interval:  opts.UpstreamConfigsEtcdInterval,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		StatsdClient: opts.StatsdClient,
+		tokens:       map[string]string{},
+	}
+}
+
+// fetch reads the keys of the prefix and returns them as an upstream configs file, unless they
+// are unchanged since the last fetch, in which case it returns nil. Endpoints are tried in turn
+// until one of them answers.
+func (s *etcdConfigSource) fetch() ([]byte, error) {
+	var errs []string
+	for _, endpoint := range s.endpoints {
+		raw, err := s.fetchFrom(endpoint)
+		if err == nil {
+			return raw, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", endpoint, err))
+	}
+	return nil, fmt.Errorf("error reading etcd prefix %s: %s", s.prefix, strings.Join(errs, "; "))
+}
+
+func (s *etcdConfigSource) fetchFrom(endpoint string) ([]byte, error) {
+	// the probe only reads the key of the latest change, and the count of the keys
+	if s.revision != 0 {
+		probe, err := s.rangeFrom(endpoint, map[string]interface{}{
+			"key":         []byte(s.prefix),
+			"range_end":   etcdPrefixEnd([]byte(s.prefix)),
+			"keys_only":   true,
+			"limit":       1,
+			"sort_order":  "DESCEND",
+			"sort_target": "MOD",
+		})
+		if err != nil {
+			return nil, err
+		}
+		if probe.version() == s.version {
+			return nil, nil
+		}
+	}
+
+	resp, err := s.rangeFrom(endpoint, map[string][]byte{
+		"key":       []byte(s.prefix),
+		"range_end": etcdPrefixEnd([]byte(s.prefix)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	services := map[string][]byte{}
+	overrides := map[string][]byte{}
+	for _, kv := range resp.KVs {
+		key := strings.TrimPrefix(string(kv.Key), s.prefix)
+		switch {
+		case strings.HasPrefix(key, etcdUpstreamsKey):
+			services[strings.TrimPrefix(key, etcdUpstreamsKey)] = kv.Value
+		case strings.HasPrefix(key, etcdOverridesKey):
+			overrides[strings.TrimPrefix(key, etcdOverridesKey)] = kv.Value
+		}
+	}
+	raw, err := etcdUpstreamConfigs(services, overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	s.revision = resp.Header.Revision
+	s.version = resp.version()
+	// the version of the prefix also moves when keys are put with the values they already had
+	digest := sha256.Sum256(raw)
+	if digest == s.digest {
+		return nil, nil
+	}
+	s.digest = digest
+	return raw, nil
+}
+
+// rangeFrom posts a range request to an endpoint. If etcd auth is enabled, the request is
+// authenticated with the token of the endpoint, which is renewed once etcd rejects it, e.g.
+// because it expired.
+func (s *etcdConfigSource) rangeFrom(endpoint string, body interface{}) (*etcdRangeResponse, error) {
+	resp := &etcdRangeResponse{}
+	if s.username == "" {
+		return resp, s.post(endpoint, "/v3/kv/range", "", body, resp)
+	}
+
+	if token, ok := s.tokens[endpoint]; ok {
+		err := s.post(endpoint, "/v3/kv/range", token, body, resp)
+		if statusErr, ok := err.(*etcdStatusError); !ok || statusErr.statusCode != http.StatusUnauthorized {
+			return resp, err
+		}
+		delete(s.tokens, endpoint)
+	}
+
+	token, err := s.authenticate(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	s.tokens[endpoint] = token
+	resp = &etcdRangeResponse{}
+	return resp, s.post(endpoint, "/v3/kv/range", token, body, resp)
+}
+
+// authenticate returns a token for the configured user.
+func (s *etcdConfigSource) authenticate(endpoint string) (string, error) {
+	resp := struct {
+		Token string `json:"token"`
+	}{}
+	err := s.post(endpoint, "/v3/auth/authenticate", "", map[string]string{
+		"name":     s.username,
+		"password": s.password,
+	}, &resp)
+	if err != nil {
+		return "", fmt.Errorf("error authenticating: %s", err)
+	}
+	return resp.Token, nil
+}
+
+// post posts a request to the JSON gateway of an endpoint, and decodes its response.
+func (s *etcdConfigSource) post(endpoint, path, token string, body, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &etcdStatusError{statusCode: resp.StatusCode, body: bytes.TrimSpace(data)}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// etcdPrefixEnd returns the end of the range of keys with a prefix.
+func etcdPrefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// every key is within the prefix
+	return []byte{0}
+}
+
+// etcdUpstreamConfigs returns the upstream configs file of the services of an etcd prefix, in
+// the order of their names, with the options of their overrides set in every cluster of the
+// service. Template variables are kept, so that the file is templated like any other.
+func etcdUpstreamConfigs(services, overrides map[string][]byte) ([]byte, error) {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for name := range overrides {
+		if _, ok := services[name]; !ok {
+			return nil, fmt.Errorf("override %s has no upstream of the same name", name)
+		}
+	}
+
+	// template variables aren't valid yaml everywhere until they're substituted, so they're
+	// replaced with placeholders, which are restored once the services are merged
+	vars := []string{}
+	placeholder := func(raw []byte) []byte {
+		return templateVar.ReplaceAllFunc(raw, func(match []byte) []byte {
+			vars = append(vars, string(match))
+			return []byte(fmt.Sprintf("sso_template_var_%d", len(vars)-1))
+		})
+	}
+
+	file := struct {
+		Version  int             `yaml:"version"`
+		Services []yaml.MapSlice `yaml:"services"`
+	}{Version: CurrentConfigVersion}
+	for _, name := range names {
+		service := yaml.MapSlice{}
+		if err := yaml.Unmarshal(placeholder(services[name]), &service); err != nil {
+			return nil, fmt.Errorf("error parsing upstream %s: %s", name, err)
+		}
+		if raw, ok := overrides[name]; ok {
+			override := yaml.MapSlice{}
+			if err := yaml.Unmarshal(placeholder(raw), &override); err != nil {
+				return nil, fmt.Errorf("error parsing override %s: %s", name, err)
+			}
+			if err := overrideServiceOptions(service, override); err != nil {
+				return nil, fmt.Errorf("error applying override %s: %s", name, err)
+			}
+		}
+		file.Services = append(file.Services, service)
+	}
+
+	raw, err := yaml.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	var restoreErr error
+	raw = etcdTemplateVar.ReplaceAllFunc(raw, func(match []byte) []byte {
+		i, err := strconv.Atoi(strings.TrimPrefix(string(match), "sso_template_var_"))
+		if err != nil || i >= len(vars) {
+			restoreErr = errors.New("upstream configs must not contain sso_template_var_ placeholders")
+			return match
+		}
+		return []byte(vars[i])
+	})
+	return raw, restoreErr
+}
+
+// overrideServiceOptions sets the options of an override in every cluster of a service.
+func overrideServiceOptions(service, override yaml.MapSlice) error {
+	for i, item := range service {
+		if item.Key == "service" {
+			continue
+		}
+		cluster, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return fmt.Errorf("cluster %v is not a mapping", item.Key)
+		}
+
+		options := yaml.MapSlice{}
+		j := -1
+		for k, field := range cluster {
+			if field.Key == "options" {
+				j = k
+				if field.Value != nil {
+					if options, ok = field.Value.(yaml.MapSlice); !ok {
+						return fmt.Errorf("options of cluster %v are not a mapping", item.Key)
+					}
+				}
+			}
+		}
+		options = mergeMapSlices(options, override)
+		if j == -1 {
+			cluster = append(cluster, yaml.MapItem{Key: "options", Value: options})
+		} else {
+			cluster[j].Value = options
+		}
+		service[i].Value = cluster
+	}
+	return nil
+}
+
+// mergeMapSlices returns the items of base, with the values of override replacing those of the
+// same key, followed by the items of override base doesn't have.
+func mergeMapSlices(base, override yaml.MapSlice) yaml.MapSlice {
+	merged := make(yaml.MapSlice, 0, len(base)+len(override))
+	overridden := map[interface{}]bool{}
+	for _, item := range base {
+		for _, o := range override {
+			if o.Key == item.Key {
+				item.Value = o.Value
+				overridden[o.Key] = true
+			}
+		}
+		merged = append(merged, item)
+	}
+	for _, o := range override {
+		if !overridden[o.Key] {
+			merged = append(merged, o)
+		}
+	}
+	return merged
+}
+
+// run polls the prefix at the configured interval, forever, and applies the upstream configs
+// whenever they change. Configs that fail to apply are reported, and the running configs are
+// kept until a later change fixes them.
+func (s *etcdConfigSource) run(apply func(raw []byte) error) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.poll(apply)
+	}
+}
+
+// poll reads the prefix once, and applies the upstream configs if they changed.
+func (s *etcdConfigSource) poll(apply func(raw []byte) error) {
+	logger := log.NewLogEntry()
+
+	raw, err := s.fetch()
+	if err != nil {
+		s.StatsdClient.Incr("config.etcd_poll", []string{"result:error"}, 1.0)
+		logger.Error(err, "error reading upstream configs from etcd; the running configs are unchanged")
+		return
+	}
+	if raw == nil {
+		s.StatsdClient.Incr("config.etcd_poll", []string{"result:unchanged"}, 1.0)
+		return
+	}
+
+	if err := apply(raw); err != nil {
+		// the digest of the configs is kept, so that they aren't applied again until they change
+		s.StatsdClient.Incr("config.etcd_poll", []string{"result:invalid"}, 1.0)
+		logger.Error(err, fmt.Sprintf("error applying upstream configs from etcd revision %d; the running configs are unchanged", s.revision))
+		return
+	}
+	s.StatsdClient.Incr("config.etcd_poll", []string{"result:applied"}, 1.0)
+	logger.Info(fmt.Sprintf("applied upstream configs from etcd revision %d of %s", s.revision, s.prefix))
+}
+
+// validateEtcdConfigSourceOptions validates the etcd settings. The upstream configs are read from
+// the prefix with the other upstream configs settings.
+func validateEtcdConfigSourceOptions(o *Options, msgs []string) []string {
+	o.etcdConfigSource = nil
+	if len(o.UpstreamConfigsEtcdEndpoints) == 0 {
+		return msgs
+	}
+	if o.UpstreamConfigsFile != "" || o.UpstreamConfigsGitURL != "" {
+		return append(msgs, "invalid setting: upstream-configs-etcd-endpoints is mutually exclusive with upstream-configs and upstream-configs-git-url")
+	}
+
+	source := newEtcdConfigSource(o)
+	valid := true
+	for _, endpoint := range source.endpoints {
+		if !validAbsoluteURL(endpoint) {
+			msgs = append(msgs, fmt.Sprintf("invalid value for upstream-configs-etcd-endpoints: %s must include scheme and host", endpoint))
+			valid = false
+		}
+	}
+	if len(source.endpoints) == 0 {
+		msgs = append(msgs, "missing setting: upstream-configs-etcd-endpoints")
+		valid = false
+	}
+	if source.prefix == "" {
+		msgs = append(msgs, "missing setting: upstream-configs-etcd-prefix")
+		valid = false
+	}
+	if source.interval <= 0 {
+		msgs = append(msgs, "invalid value for upstream-configs-etcd-interval: must be positive")
+		valid = false
+	}
+	if valid {
+		o.etcdConfigSource = source
+	}
+	return msgs
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestEtcdUpstreamConfigs(t *testing.T) {
+	services := map[string][]byte{
+		"foo": []byte(`
+service: foo
+default:
+  from: foo.{{cluster}}.{{root_domain}}
+  to: foo-internal.{{cluster}}.{{root_domain}}
+  options:
+    allowed_groups: [engineering]
+    timeout: 2s
+prod:
+  from: foo.{{root_domain}}
+  to: foo-internal.{{root_domain}}
+`),
+		"bar": []byte(`
+service: bar
+default:
+  from: bar.{{cluster}}.{{root_domain}}
+  to: bar-internal.{{cluster}}.{{root_domain}}
+`),
+	}
+	overrides := map[string][]byte{
+		"foo": []byte(`
+allowed_groups: [admins]
+`),
+	}
+
+	raw, err := etcdUpstreamConfigs(services, overrides)
+	testutil.Ok(t, err)
+
+	configs, err := loadClusterServiceConfigs("", raw, []string{"sso", "prod"}, "http",
+		map[string]string{"root_domain": "example.com"}, &OptionsConfig{AllowedGroups: []string{"everyone"}})
+	testutil.Ok(t, err)
+
+	byKey := map[string]*UpstreamConfig{}
+	for _, config := range configs {
+		byKey[config.Cluster+"/"+config.Service] = config
+	}
+	testutil.Equal(t, 4, len(byKey))
+
+	// overrides apply to every cluster of their upstream, over its own options
+	foo := byKey["sso/foo"]
+	testutil.Equal(t, "foo.sso.example.com", foo.RouteConfig.From)
+	testutil.Equal(t, []string{"admins"}, foo.AllowedGroups)
+	testutil.Equal(t, 2*time.Second, foo.Timeout)
+	testutil.Equal(t, "foo.example.com", byKey["prod/foo"].RouteConfig.From)
+	testutil.Equal(t, []string{"admins"}, byKey["prod/foo"].AllowedGroups)
+	testutil.Equal(t, []string{"everyone"}, byKey["sso/bar"].AllowedGroups)
+
+	_, err = etcdUpstreamConfigs(services, map[string][]byte{"baz": []byte("allowed_groups: [admins]")})
+	testutil.NotEqual(t, nil, err)
+}
+
+func TestEtcdConfigSourceFetch(t *testing.T) {
+	value := []byte("service: foo\ndefault:\n  from: foo.example.com\n  to: foo-internal.example.com\n")
+	modRevision := "3"
+	reads, authentications := 0, 0
+	expired := map[string]bool{}
+	gateway := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v3/auth/authenticate":
+			authentications++
+			json.NewEncoder(rw).Encode(map[string]string{"token": fmt.Sprintf("token-%d", authentications)})
+			return
+		case "/v3/kv/range":
+		default:
+			http.NotFound(rw, req)
+			return
+		}
+		if token := req.Header.Get("Authorization"); token == "" || expired[token] {
+			http.Error(rw, `{"error":"etcdserver: invalid auth token","code":16}`, http.StatusUnauthorized)
+			return
+		}
+
+		body := struct {
+			Key        []byte `json:"key"`
+			RangeEnd   []byte `json:"range_end"`
+			KeysOnly   bool   `json:"keys_only"`
+			Limit      int64  `json:"limit"`
+			SortTarget string `json:"sort_target"`
+		}{}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("unexpected error decoding range request: %v", err)
+		}
+		testutil.Equal(t, "/sso/", string(body.Key))
+		testutil.Equal(t, "/sso0", string(body.RangeEnd))
+
+		if body.KeysOnly {
+			testutil.Equal(t, int64(1), body.Limit)
+			testutil.Equal(t, "MOD", body.SortTarget)
+			json.NewEncoder(rw).Encode(map[string]interface{}{
+				"header": map[string]string{"revision": "9"},
+				"kvs":    []map[string]interface{}{{"key": []byte("/sso/upstreams/foo"), "mod_revision": modRevision}},
+				"count":  "2",
+			})
+			return
+		}
+		reads++
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"header": map[string]string{"revision": "5"},
+			"kvs": []map[string]interface{}{
+				{"key": []byte("/sso/upstreams/foo"), "value": value, "mod_revision": modRevision},
+				{"key": []byte("/sso/unrelated"), "value": []byte("ignored"), "mod_revision": "2"},
+			},
+			"count": "2",
+		})
+	}))
+	defer gateway.Close()
+
+	opts := NewOptions()
+	opts.UpstreamConfigsEtcdEndpoints = []string{"http://127.0.0.1:1", gateway.URL}
+	opts.UpstreamConfigsEtcdUsername = "sso"
+	source := newEtcdConfigSource(opts)
+
+	// unreachable endpoints are skipped
+	raw, err := source.fetch()
+	testutil.Ok(t, err)
+	configs, err := loadServiceConfigsFrom("", raw, "sso", "http", map[string]string{}, nil)
+	testutil.Ok(t, err)
+	testutil.Equal(t, 1, len(configs))
+	testutil.Equal(t, "foo.example.com", configs[0].RouteConfig.From)
+
+	// the prefix isn't read again until its version moves, even though the revision of the
+	// cluster has, and the token is only renewed once it expires
+	expired["token-1"] = true
+	raw, err = source.fetch()
+	testutil.Ok(t, err)
+	testutil.Equal(t, []byte(nil), raw)
+	testutil.Equal(t, 1, reads)
+	testutil.Equal(t, 2, authentications)
+
+	modRevision = "7"
+	value = []byte("service: foo\ndefault:\n  from: foo.example.com\n  to: foo-v2.example.com\n")
+	raw, err = source.fetch()
+	testutil.Ok(t, err)
+	configs, err = loadServiceConfigsFrom("", raw, "sso", "http", map[string]string{}, nil)
+	testutil.Ok(t, err)
+	testutil.Equal(t, "foo-v2.example.com", configs[0].RouteConfig.To)
+	testutil.Equal(t, 2, reads)
+	testutil.Equal(t, 2, authentications)
+}
@@ -0,0 +1,178 @@
+package extauthz
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// The types below mirror the subset of Envoy's external authorization protobuf messages
+// (envoy.service.auth.v2 and envoy.service.auth.v3) that sso proxy reads and writes. Field
+// numbers match the upstream definitions so they are wire compatible with Envoy, while
+// letting us avoid depending on the full generated go-control-plane module.
+//
+// See https://www.envoyproxy.io/docs/envoy/latest/api-v3/service/auth/v3/external_auth.proto
+
+// gRPC status codes used in CheckResponse.Status, from google.rpc.Code.
+const (
+	codeOK               = 0
+	codePermissionDenied = 7
+)
+
+// CheckRequest is envoy.service.auth.v2.CheckRequest.
+type CheckRequest struct {
+	Attributes *AttributeContext `protobuf:"bytes,1,opt,name=attributes,proto3" json:"attributes,omitempty"`
+}
+
+func (m *CheckRequest) Reset()         { *m = CheckRequest{} }
+func (m *CheckRequest) String() string { return proto.CompactTextString(m) }
+func (*CheckRequest) ProtoMessage()    {}
+
+// AttributeContext is envoy.service.auth.v2.AttributeContext.
+type AttributeContext struct {
+	Source            *Peer             `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Destination       *Peer             `protobuf:"bytes,2,opt,name=destination,proto3" json:"destination,omitempty"`
+	Request           *Request          `protobuf:"bytes,4,opt,name=request,proto3" json:"request,omitempty"`
+	ContextExtensions map[string]string `protobuf:"bytes,10,rep,name=context_extensions,json=contextExtensions,proto3" json:"context_extensions,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *AttributeContext) Reset()         { *m = AttributeContext{} }
+func (m *AttributeContext) String() string { return proto.CompactTextString(m) }
+func (*AttributeContext) ProtoMessage()    {}
+
+// Peer is envoy.service.auth.v2.AttributeContext.Peer.
+type Peer struct {
+	Address   *Address `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Service   string   `protobuf:"bytes,2,opt,name=service,proto3" json:"service,omitempty"`
+	Principal string   `protobuf:"bytes,4,opt,name=principal,proto3" json:"principal,omitempty"`
+}
+
+func (m *Peer) Reset()         { *m = Peer{} }
+func (m *Peer) String() string { return proto.CompactTextString(m) }
+func (*Peer) ProtoMessage()    {}
+
+// Address is envoy.api.v2.core.Address, restricted to socket addresses.
+type Address struct {
+	SocketAddress *SocketAddress `protobuf:"bytes,1,opt,name=socket_address,json=socketAddress,proto3" json:"socket_address,omitempty"`
+}
+
+func (m *Address) Reset()         { *m = Address{} }
+func (m *Address) String() string { return proto.CompactTextString(m) }
+func (*Address) ProtoMessage()    {}
+
+// SocketAddress is envoy.api.v2.core.SocketAddress, restricted to numeric ports.
+type SocketAddress struct {
+	Address   string `protobuf:"bytes,2,opt,name=address,proto3" json:"address,omitempty"`
+	PortValue uint32 `protobuf:"varint,3,opt,name=port_value,json=portValue,proto3" json:"port_value,omitempty"`
+}
+
+func (m *SocketAddress) Reset()         { *m = SocketAddress{} }
+func (m *SocketAddress) String() string { return proto.CompactTextString(m) }
+func (*SocketAddress) ProtoMessage()    {}
+
+// Request is envoy.service.auth.v2.AttributeContext.Request.
+type Request struct {
+	HTTP *HTTPRequest `protobuf:"bytes,2,opt,name=http,proto3" json:"http,omitempty"`
+}
+
+func (m *Request) Reset()         { *m = Request{} }
+func (m *Request) String() string { return proto.CompactTextString(m) }
+func (*Request) ProtoMessage()    {}
+
+// HTTPRequest is envoy.service.auth.v2.AttributeContext.HttpRequest.
+type HTTPRequest struct {
+	ID       string            `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Method   string            `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	Headers  map[string]string `protobuf:"bytes,3,rep,name=headers,proto3" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Path     string            `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	Host     string            `protobuf:"bytes,5,opt,name=host,proto3" json:"host,omitempty"`
+	Scheme   string            `protobuf:"bytes,6,opt,name=scheme,proto3" json:"scheme,omitempty"`
+	Query    string            `protobuf:"bytes,7,opt,name=query,proto3" json:"query,omitempty"`
+	Fragment string            `protobuf:"bytes,8,opt,name=fragment,proto3" json:"fragment,omitempty"`
+	Size     int64             `protobuf:"varint,9,opt,name=size,proto3" json:"size,omitempty"`
+	Protocol string            `protobuf:"bytes,10,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	Body     string            `protobuf:"bytes,11,opt,name=body,proto3" json:"body,omitempty"`
+}
+
+func (m *HTTPRequest) Reset()         { *m = HTTPRequest{} }
+func (m *HTTPRequest) String() string { return proto.CompactTextString(m) }
+func (*HTTPRequest) ProtoMessage()    {}
+
+// CheckResponse is envoy.service.auth.v2.CheckResponse. The upstream definition declares
+// DeniedResponse and OkResponse as members of the `http_response` oneof, which is
+// encoded identically to two optional fields; at most one of them is ever set.
+type CheckResponse struct {
+	Status         *Status             `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	DeniedResponse *DeniedHTTPResponse `protobuf:"bytes,2,opt,name=denied_response,json=deniedResponse,proto3" json:"denied_response,omitempty"`
+	OkResponse     *OkHTTPResponse     `protobuf:"bytes,3,opt,name=ok_response,json=okResponse,proto3" json:"ok_response,omitempty"`
+}
+
+func (m *CheckResponse) Reset()         { *m = CheckResponse{} }
+func (m *CheckResponse) String() string { return proto.CompactTextString(m) }
+func (*CheckResponse) ProtoMessage()    {}
+
+// Status is google.rpc.Status, without details.
+type Status struct {
+	Code    int32  `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (m *Status) Reset()         { *m = Status{} }
+func (m *Status) String() string { return proto.CompactTextString(m) }
+func (*Status) ProtoMessage()    {}
+
+// DeniedHTTPResponse is envoy.service.auth.v2.DeniedHttpResponse.
+type DeniedHTTPResponse struct {
+	Status  *HTTPStatus          `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	Headers []*HeaderValueOption `protobuf:"bytes,2,rep,name=headers,proto3" json:"headers,omitempty"`
+	Body    string               `protobuf:"bytes,3,opt,name=body,proto3" json:"body,omitempty"`
+}
+
+func (m *DeniedHTTPResponse) Reset()         { *m = DeniedHTTPResponse{} }
+func (m *DeniedHTTPResponse) String() string { return proto.CompactTextString(m) }
+func (*DeniedHTTPResponse) ProtoMessage()    {}
+
+// OkHTTPResponse is envoy.service.auth.v2.OkHttpResponse.
+type OkHTTPResponse struct {
+	Headers []*HeaderValueOption `protobuf:"bytes,2,rep,name=headers,proto3" json:"headers,omitempty"`
+}
+
+func (m *OkHTTPResponse) Reset()         { *m = OkHTTPResponse{} }
+func (m *OkHTTPResponse) String() string { return proto.CompactTextString(m) }
+func (*OkHTTPResponse) ProtoMessage()    {}
+
+// HTTPStatus is envoy.type.HttpStatus.
+type HTTPStatus struct {
+	Code int32 `protobuf:"varint,1,opt,name=code,proto3" json:"code,omitempty"`
+}
+
+func (m *HTTPStatus) Reset()         { *m = HTTPStatus{} }
+func (m *HTTPStatus) String() string { return proto.CompactTextString(m) }
+func (*HTTPStatus) ProtoMessage()    {}
+
+// HeaderValueOption is envoy.api.v2.core.HeaderValueOption.
+type HeaderValueOption struct {
+	Header *HeaderValue `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Append *BoolValue   `protobuf:"bytes,2,opt,name=append,proto3" json:"append,omitempty"`
+}
+
+func (m *HeaderValueOption) Reset()         { *m = HeaderValueOption{} }
+func (m *HeaderValueOption) String() string { return proto.CompactTextString(m) }
+func (*HeaderValueOption) ProtoMessage()    {}
+
+// HeaderValue is envoy.api.v2.core.HeaderValue.
+type HeaderValue struct {
+	Key   string `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *HeaderValue) Reset()         { *m = HeaderValue{} }
+func (m *HeaderValue) String() string { return proto.CompactTextString(m) }
+func (*HeaderValue) ProtoMessage()    {}
+
+// BoolValue is google.protobuf.BoolValue.
+type BoolValue struct {
+	Value bool `protobuf:"varint,1,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *BoolValue) Reset()         { *m = BoolValue{} }
+func (m *BoolValue) String() string { return proto.CompactTextString(m) }
+func (*BoolValue) ProtoMessage()    {}
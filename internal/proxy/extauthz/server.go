@@ -0,0 +1,199 @@
+package extauthz
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+
+	"google.golang.org/grpc"
+)
+
+// Service names implementing the external authorization Check rpc. The v2 and v3
+// messages share the field numbers we rely on, so a single handler serves both.
+var serviceNames = []string{
+	"envoy.service.auth.v2.Authorization",
+	"envoy.service.auth.v3.Authorization",
+}
+
+// Headers that are stashed by sso proxy for internal bookkeeping and must never
+// be returned to Envoy.
+var internalHeaders = []string{
+	"SSO-Authenticated-User",
+	"SSO-Country",
+	"SSO-ASN",
+	"SSO-Team",
+	"SSO-Tier",
+	"SSO-Service-Name",
+}
+
+// Server implements Envoy's external authorization gRPC service on top of an http.Handler.
+// Each CheckRequest is translated into an http.Request and served by the handler; a 200
+// response authorizes the request, any other response is returned to the client as-is.
+type Server struct {
+	handler http.Handler
+}
+
+// NewServer returns a new Server that authorizes requests with the given handler.
+func NewServer(handler http.Handler) *Server {
+	return &Server{
+		handler: handler,
+	}
+}
+
+// Register registers the authorization service on the given grpc.Server.
+func (s *Server) Register(g *grpc.Server) {
+	for _, name := range serviceNames {
+		g.RegisterService(&grpc.ServiceDesc{
+			ServiceName: name,
+			HandlerType: (*interface{})(nil),
+			Methods: []grpc.MethodDesc{
+				{
+					MethodName: "Check",
+					Handler:    s.checkHandler(name),
+				},
+			},
+			Streams: []grpc.StreamDesc{},
+		}, s)
+	}
+}
+
+func (s *Server) checkHandler(serviceName string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := &CheckRequest{}
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return s.Check(ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: fmt.Sprintf("/%s/Check", serviceName),
+		}
+		return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Check(ctx, req.(*CheckRequest))
+		})
+	}
+}
+
+// Check authorizes a single request.
+func (s *Server) Check(ctx context.Context, in *CheckRequest) (*CheckResponse, error) {
+	req, err := newHTTPRequest(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	rw := httptest.NewRecorder()
+	s.handler.ServeHTTP(rw, req)
+	for _, key := range internalHeaders {
+		rw.Header().Del(key)
+	}
+
+	if rw.Code == http.StatusOK {
+		// Cookies can only be set on the client response, which Envoy does not let us
+		// modify for authorized requests.
+		rw.Header().Del("Set-Cookie")
+		return &CheckResponse{
+			Status: &Status{Code: codeOK},
+			OkResponse: &OkHTTPResponse{
+				Headers: headerValueOptions(rw.Header()),
+			},
+		}, nil
+	}
+
+	return &CheckResponse{
+		Status: &Status{
+			Code:    codePermissionDenied,
+			Message: http.StatusText(rw.Code),
+		},
+		DeniedResponse: &DeniedHTTPResponse{
+			Status:  &HTTPStatus{Code: int32(rw.Code)},
+			Headers: headerValueOptions(rw.Header()),
+			Body:    rw.Body.String(),
+		},
+	}, nil
+}
+
+// newHTTPRequest reconstructs the original client request described by a CheckRequest.
+func newHTTPRequest(ctx context.Context, in *CheckRequest) (*http.Request, error) {
+	if in.Attributes == nil || in.Attributes.Request == nil || in.Attributes.Request.HTTP == nil {
+		return nil, fmt.Errorf("check request is missing http request attributes")
+	}
+	attrs := in.Attributes.Request.HTTP
+
+	// Envoy's path attribute is the :path pseudo-header, including any query string.
+	u, err := url.ParseRequestURI(attrs.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request path %q: %s", attrs.Path, err)
+	}
+	u.Scheme = attrs.Scheme
+	u.Host = attrs.Host
+
+	method := attrs.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Host = attrs.Host
+	for key, val := range attrs.Headers {
+		// skip HTTP/2 pseudo-headers such as :authority and :path
+		if strings.HasPrefix(key, ":") {
+			continue
+		}
+		req.Header.Set(key, val)
+	}
+	if req.Header.Get("X-Forwarded-Proto") == "" && attrs.Scheme != "" {
+		req.Header.Set("X-Forwarded-Proto", attrs.Scheme)
+	}
+
+	if src := in.Attributes.Source; src != nil && src.Address != nil && src.Address.SocketAddress != nil {
+		sa := src.Address.SocketAddress
+		req.RemoteAddr = net.JoinHostPort(sa.Address, strconv.Itoa(int(sa.PortValue)))
+	}
+
+	return req, nil
+}
+
+func headerValueOptions(headers http.Header) []*HeaderValueOption {
+	options := []*HeaderValueOption{}
+	for key, vals := range headers {
+		for i, val := range vals {
+			options = append(options, &HeaderValueOption{
+				Header: &HeaderValue{Key: key, Value: val},
+				// the first value replaces any existing header, the rest are appended
+				Append: &BoolValue{Value: i > 0},
+			})
+		}
+	}
+	return options
+}
+
+// Run serves the authorization service on the given address until the listener fails.
+func Run(addr string, handler http.Handler, logger *log.LogEntry) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(ln, handler, logger)
+}
+
+// Serve serves the authorization service on a listener until it fails.
+func Serve(ln net.Listener, handler http.Handler, logger *log.LogEntry) error {
+	g := grpc.NewServer()
+	NewServer(handler).Register(g)
+
+	logger.Info("serving envoy external authorization on ", ln.Addr())
+	return g.Serve(ln)
+}
@@ -0,0 +1,90 @@
+package extauthz
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func testCheckRequest(path string, headers map[string]string) *CheckRequest {
+	return &CheckRequest{
+		Attributes: &AttributeContext{
+			Source: &Peer{
+				Address: &Address{
+					SocketAddress: &SocketAddress{Address: "10.0.0.1", PortValue: 5000},
+				},
+			},
+			Request: &Request{
+				HTTP: &HTTPRequest{
+					Method:  "GET",
+					Host:    "foo.example.com",
+					Scheme:  "https",
+					Path:    path,
+					Headers: headers,
+				},
+			},
+		},
+	}
+}
+
+func headerMap(options []*HeaderValueOption) map[string]string {
+	m := map[string]string{}
+	for _, o := range options {
+		m[o.Header.Key] = o.Header.Value
+	}
+	return m
+}
+
+func TestCheck(t *testing.T) {
+	var received *http.Request
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received = req
+		rw.Header().Set("SSO-Authenticated-User", "foo@example.com")
+		rw.Header().Set("SSO-Team", "payments")
+		if req.Header.Get("Cookie") == "" {
+			rw.Header().Set("Location", "https://sso-auth.example.com/sign_in")
+			rw.WriteHeader(http.StatusFound)
+			return
+		}
+		rw.Header().Set("Set-Cookie", "_sso_proxy=refreshed")
+		rw.Header().Set("X-Forwarded-Email", "foo@example.com")
+		rw.WriteHeader(http.StatusOK)
+	})
+	s := NewServer(handler)
+
+	t.Run("authorized request", func(t *testing.T) {
+		resp, err := s.Check(context.Background(), testCheckRequest("/bar?baz=qux", map[string]string{
+			":authority": "foo.example.com",
+			"cookie":     "_sso_proxy=session",
+		}))
+		testutil.Ok(t, err)
+
+		testutil.Equal(t, "foo.example.com", received.Host)
+		testutil.Equal(t, "/bar", received.URL.Path)
+		testutil.Equal(t, "baz=qux", received.URL.RawQuery)
+		testutil.Equal(t, "https", received.Header.Get("X-Forwarded-Proto"))
+		testutil.Equal(t, "10.0.0.1:5000", received.RemoteAddr)
+		testutil.Equal(t, "", received.Header.Get(":authority"))
+
+		testutil.Equal(t, int32(codeOK), resp.Status.Code)
+		testutil.Assert(t, resp.DeniedResponse == nil, "expected no denied response")
+		testutil.Equal(t, map[string]string{"X-Forwarded-Email": "foo@example.com"}, headerMap(resp.OkResponse.Headers))
+	})
+
+	t.Run("denied request", func(t *testing.T) {
+		resp, err := s.Check(context.Background(), testCheckRequest("/", nil))
+		testutil.Ok(t, err)
+
+		testutil.Equal(t, int32(codePermissionDenied), resp.Status.Code)
+		testutil.Assert(t, resp.OkResponse == nil, "expected no ok response")
+		testutil.Equal(t, int32(http.StatusFound), resp.DeniedResponse.Status.Code)
+		testutil.Equal(t, map[string]string{"Location": "https://sso-auth.example.com/sign_in"}, headerMap(resp.DeniedResponse.Headers))
+	})
+
+	t.Run("missing http attributes", func(t *testing.T) {
+		_, err := s.Check(context.Background(), &CheckRequest{})
+		testutil.NotEqual(t, nil, err)
+	})
+}
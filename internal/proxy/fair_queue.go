@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// defaultPriorityClass is the priority class of upstreams without a priority_class, whose
+// metadata tier isn't a priority class either.
+const defaultPriorityClass = "default"
+
+// priorityClass returns the priority class of an upstream: its priority_class, or else the tier
+// of its metadata.
+func priorityClass(upstreamConfig *UpstreamConfig) string {
+	if upstreamConfig.PriorityClass != "" {
+		return upstreamConfig.PriorityClass
+	}
+	if upstreamConfig.Metadata != nil {
+		return upstreamConfig.Metadata.Tier
+	}
+	return ""
+}
+
+// fairScheduler limits the requests the proxy forwards to its upstreams at once, so that a
+// saturated proxy doesn't slow every upstream down alike. Requests beyond the limit wait in the
+// queue of their upstream's priority class, and as requests end, waiting requests are started
+// from each class in proportion to its weight, as in weighted fair queuing: a class of weight 8
+// starts 8 requests for each request of a class of weight 1 while both are waiting, but classes
+// that aren't waiting don't hold back those that are. It is shared by every upstream.
+type fairScheduler struct {
+	limit   int
+	timeout time.Duration
+	weights map[string]int
+
+	mu          sync.Mutex
+	active      int
+	virtualTime float64
+	classes     map[string]*priorityQueue
+}
+
+// priorityQueue is the queue of the requests of a priority class waiting to start.
+type priorityQueue struct {
+	class  string
+	weight int
+	// pass is the virtual time at which the class's next request starts, which advances by the
+	// inverse of its weight with each request it starts
+	pass    float64
+	waiting []*queuedRequest
+}
+
+// queuedRequest is a request waiting to start, whose channel is closed once it does.
+type queuedRequest struct {
+	start   chan struct{}
+	started bool
+}
+
+// newFairScheduler returns the scheduler configured by opts, or nil if requests aren't limited.
+func newFairScheduler(opts *Options) *fairScheduler {
+	if opts.MaxConcurrentRequests == 0 {
+		return nil
+	}
+	return &fairScheduler{
+		limit:   opts.MaxConcurrentRequests,
+		timeout: opts.PriorityQueueTimeout,
+		weights: opts.PriorityClasses,
+		classes: make(map[string]*priorityQueue),
+	}
+}
+
+// class returns the priority class of an upstream that requests are scheduled in.
+func (s *fairScheduler) class(upstreamConfig *UpstreamConfig) string {
+	class := priorityClass(upstreamConfig)
+	if _, ok := s.weights[class]; !ok {
+		return defaultPriorityClass
+	}
+	return class
+}
+
+// queue returns the queue of a priority class, creating it if it doesn't exist.
+func (s *fairScheduler) queue(class string) *priorityQueue {
+	q, ok := s.classes[class]
+	if !ok {
+		weight := s.weights[class]
+		if weight <= 0 {
+			weight = 1
+		}
+		q = &priorityQueue{class: class, weight: weight}
+		s.classes[class] = q
+	}
+	return q
+}
+
+// acquire starts a request of a priority class, waiting for up to the timeout while the limit
+// of requests has been reached. It returns false if the request couldn't start in time.
+func (s *fairScheduler) acquire(class string) bool {
+	s.mu.Lock()
+	q := s.queue(class)
+	if s.active < s.limit && !s.waiting() {
+		s.active++
+		s.mu.Unlock()
+		return true
+	}
+
+	// a class that starts waiting doesn't get credit for the time it wasn't
+	if len(q.waiting) == 0 && q.pass < s.virtualTime {
+		q.pass = s.virtualTime
+	}
+	r := &queuedRequest{start: make(chan struct{})}
+	q.waiting = append(q.waiting, r)
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case <-r.start:
+		return true
+	case <-timer.C:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.started {
+		// the request started as it timed out
+		return true
+	}
+	for i, waiting := range q.waiting {
+		if waiting == r {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			break
+		}
+	}
+	return false
+}
+
+// release ends a request, starting the waiting request of the class whose turn is next.
+func (s *fairScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+
+	var next *priorityQueue
+	for _, q := range s.classes {
+		if len(q.waiting) == 0 {
+			continue
+		}
+		// ties are broken by name, so that classes are scheduled in the same order every time
+		if next == nil || q.pass < next.pass || (q.pass == next.pass && q.class < next.class) {
+			next = q
+		}
+	}
+	if next == nil {
+		return
+	}
+
+	r := next.waiting[0]
+	next.waiting = next.waiting[1:]
+	s.virtualTime = next.pass
+	next.pass += 1 / float64(next.weight)
+	s.active++
+	r.started = true
+	close(r.start)
+}
+
+// waiting returns true if any request is waiting to start.
+func (s *fairScheduler) waiting() bool {
+	for _, q := range s.classes {
+		if len(q.waiting) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// handler returns a handler that schedules the requests of an upstream in its priority class,
+// rejecting those that wait too long to start.
+func (s *fairScheduler) handler(h http.Handler, upstreamConfig *UpstreamConfig, statsdClient *statsd.Client) http.Handler {
+	class := s.class(upstreamConfig)
+	tags := []string{"service:" + upstreamConfig.Service, "priority_class:" + class}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		if !s.acquire(class) {
+			statsdClient.Incr("upstream.queue_timeout", tags, 1.0)
+			rw.Header().Set(loadShedHeader, "true")
+			http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer s.release()
+		statsdClient.Timing("upstream.queue_time", time.Since(start), tags, 1.0)
+		h.ServeHTTP(rw, req)
+	})
+}
+
+// validateFairQueueOptions validates the limit of requests and the priority classes of the
+// upstreams, if requests are limited.
+func validateFairQueueOptions(o *Options, msgs []string) []string {
+	if o.MaxConcurrentRequests < 0 {
+		msgs = append(msgs, "invalid value for max-concurrent-requests: must not be negative")
+	}
+	if o.MaxConcurrentRequests <= 0 {
+		return msgs
+	}
+	if o.PriorityQueueTimeout <= 0 {
+		msgs = append(msgs, "invalid value for priority-queue-timeout: must be positive")
+	}
+
+	classes := make([]string, 0, len(o.PriorityClasses))
+	for class := range o.PriorityClasses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		if o.PriorityClasses[class] <= 0 {
+			msgs = append(msgs, fmt.Sprintf("invalid weight for priority class %s: must be positive", class))
+		}
+	}
+	for _, uc := range o.upstreamConfigs {
+		if _, ok := o.PriorityClasses[uc.PriorityClass]; uc.PriorityClass != "" && !ok {
+			msgs = append(msgs, fmt.Sprintf("invalid priority_class of upstream %s: %q, must be one of %s",
+				uc.Service, uc.PriorityClass, strings.Join(classes, ", ")))
+		}
+	}
+	return msgs
+}
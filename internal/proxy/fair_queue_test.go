@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func testFairScheduler(limit int, timeout time.Duration) *fairScheduler {
+	o := testOptions()
+	o.MaxConcurrentRequests = limit
+	o.PriorityQueueTimeout = timeout
+	o.PriorityClasses = map[string]int{"critical": 2, "batch": 1}
+	return newFairScheduler(o)
+}
+
+// waitQueued waits until a number of requests are waiting to start.
+func waitQueued(t *testing.T, s *fairScheduler, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		queued := 0
+		for _, q := range s.classes {
+			queued += len(q.waiting)
+		}
+		s.mu.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d requests to be waiting", n)
+}
+
+func TestFairSchedulerWeights(t *testing.T) {
+	s := testFairScheduler(1, time.Minute)
+	testutil.Equal(t, true, s.acquire("critical"))
+
+	started := make(chan string)
+	queue := func(class string, n int) {
+		for i := 0; i < n; i++ {
+			go func() {
+				s.acquire(class)
+				started <- class
+			}()
+		}
+	}
+	queue("critical", 4)
+	queue("batch", 2)
+	waitQueued(t, s, 6)
+
+	// while both classes are waiting, critical requests start twice as often as batch requests
+	order := []string{}
+	for i := 0; i < 6; i++ {
+		s.release()
+		order = append(order, <-started)
+	}
+	testutil.Equal(t, []string{"batch", "critical", "critical", "batch", "critical", "critical"}, order)
+
+	s.mu.Lock()
+	testutil.Equal(t, 1, s.active)
+	s.mu.Unlock()
+}
+
+func TestFairSchedulerTimeout(t *testing.T) {
+	s := testFairScheduler(1, 10*time.Millisecond)
+	testutil.Equal(t, true, s.acquire("batch"))
+	testutil.Equal(t, false, s.acquire("critical"))
+	waitQueued(t, s, 0)
+
+	// requests start right away while the limit isn't reached
+	s.release()
+	testutil.Equal(t, true, s.acquire("critical"))
+}
+
+func TestFairSchedulerHandler(t *testing.T) {
+	s := testFairScheduler(1, 10*time.Millisecond)
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	handler := s.handler(upstream, &UpstreamConfig{Service: "reports", PriorityClass: "batch"}, nil)
+	serve := func() *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "http://reports.sso.dev/", nil))
+		return rw
+	}
+
+	testutil.Equal(t, http.StatusOK, serve().Code)
+
+	testutil.Equal(t, true, s.acquire("critical"))
+	rw := serve()
+	testutil.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	testutil.Equal(t, "true", rw.Header().Get(loadShedHeader))
+}
+
+func TestFairSchedulerClass(t *testing.T) {
+	s := testFairScheduler(1, time.Second)
+	testCases := []struct {
+		config *UpstreamConfig
+		class  string
+	}{
+		{&UpstreamConfig{PriorityClass: "critical", Metadata: &MetadataConfig{Tier: "batch"}}, "critical"},
+		{&UpstreamConfig{Metadata: &MetadataConfig{Tier: "batch"}}, "batch"},
+		{&UpstreamConfig{Metadata: &MetadataConfig{Tier: "gold"}}, defaultPriorityClass},
+		{&UpstreamConfig{}, defaultPriorityClass},
+	}
+	for _, tc := range testCases {
+		testutil.Equal(t, tc.class, s.class(tc.config))
+	}
+}
+
+func TestValidateFairQueueOptions(t *testing.T) {
+	o := testOptions()
+	testutil.Ok(t, o.Validate())
+
+	o.MaxConcurrentRequests = 100
+	o.PriorityClasses = map[string]int{"critical": 0, "batch": 1}
+	o.PriorityQueueTimeout = 0
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"invalid value for priority-queue-timeout: must be positive",
+		"invalid weight for priority class critical: must be positive",
+	}), err.Error())
+}
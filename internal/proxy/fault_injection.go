@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// faultInjectedHeader is set on responses replaced by an injected fault.
+const faultInjectedHeader = "X-SSO-Fault-Injected"
+
+// FaultInjectionConfig maps to the fault_injection yaml config fields of an upstream:
+// * latency - the delay added to requests
+// * latency_percent - the percentage of requests that are delayed
+// * abort_status - the 5xx status code aborted requests are answered with, defaults to 503
+// * abort_percent - the percentage of requests that are aborted instead of being proxied
+// * reset_percent - the percentage of requests whose client connections are reset
+type FaultInjectionConfig struct {
+	Latency        time.Duration `yaml:"latency"`
+	LatencyPercent float64       `yaml:"latency_percent"`
+	AbortStatus    int           `yaml:"abort_status"`
+	AbortPercent   float64       `yaml:"abort_percent"`
+	ResetPercent   float64       `yaml:"reset_percent"`
+}
+
+func (c *FaultInjectionConfig) validate() error {
+	for name, percent := range map[string]float64{
+		"latency_percent": c.LatencyPercent,
+		"abort_percent":   c.AbortPercent,
+		"reset_percent":   c.ResetPercent,
+	} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("%s must be between 0 and 100", name)
+		}
+	}
+
+	if c.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+
+	if c.AbortStatus == 0 {
+		c.AbortStatus = http.StatusServiceUnavailable
+	}
+	if c.AbortStatus < 500 || c.AbortStatus > 599 {
+		return fmt.Errorf("abort_status must be a 5xx status code")
+	}
+	return nil
+}
+
+// faultInjector injects faults into a percentage of requests to an upstream, to validate the
+// retry behavior of clients and the tuning of circuit breakers.
+type faultInjector struct {
+	config       *FaultInjectionConfig
+	service      string
+	handler      http.Handler
+	StatsdClient *statsd.Client
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// newFaultInjectionHandler wraps the handler of an upstream with its configured faults.
+func newFaultInjectionHandler(handler http.Handler, config *UpstreamConfig, statsdClient *statsd.Client) http.Handler {
+	log.NewLogEntry().WithProxyHost(config.RouteConfig.From).Warn(
+		fmt.Sprintf("fault injection is enabled for upstream %s", config.Service))
+
+	return &faultInjector{
+		config:       config.FaultInjection,
+		service:      config.Service,
+		handler:      handler,
+		StatsdClient: statsdClient,
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// roll returns true for the given percentage of calls.
+func (f *faultInjector) roll(percent float64) bool {
+	if percent <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64()*100 < percent
+}
+
+func (f *faultInjector) incr(fault string) {
+	f.StatsdClient.Incr("fault_injected", []string{"fault:" + fault, "service:" + f.service}, 1.0)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (f *faultInjector) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if f.config.Latency > 0 && f.roll(f.config.LatencyPercent) {
+		f.incr("latency")
+		select {
+		case <-time.After(f.config.Latency):
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	if f.roll(f.config.ResetPercent) {
+		f.incr("reset")
+		resetConnection(rw)
+		return
+	}
+
+	if f.roll(f.config.AbortPercent) {
+		f.incr("abort")
+		rw.Header().Set(faultInjectedHeader, "abort")
+		http.Error(rw, http.StatusText(f.config.AbortStatus), f.config.AbortStatus)
+		return
+	}
+
+	f.handler.ServeHTTP(rw, req)
+}
+
+// resetConnection resets the client connection of a request without responding. Connections
+// that can't be hijacked, e.g. those of HTTP/2 requests, have their stream aborted instead.
+func resetConnection(rw http.ResponseWriter) {
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+
+	// closing a connection without lingering sends a RST rather than a FIN
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestFaultInjectionConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		config      *FaultInjectionConfig
+		wantErr     string
+		wantAbortSC int
+	}{
+		{
+			name:        "defaults abort status",
+			config:      &FaultInjectionConfig{AbortPercent: 5},
+			wantAbortSC: http.StatusServiceUnavailable,
+		},
+		{
+			name:    "percent out of range",
+			config:  &FaultInjectionConfig{ResetPercent: 101},
+			wantErr: "reset_percent must be between 0 and 100",
+		},
+		{
+			name:    "negative latency",
+			config:  &FaultInjectionConfig{Latency: -time.Second},
+			wantErr: "latency must not be negative",
+		},
+		{
+			name:    "non 5xx abort status",
+			config:  &FaultInjectionConfig{AbortStatus: 404},
+			wantErr: "abort_status must be a 5xx status code",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.validate()
+			if tc.wantErr != "" {
+				testutil.NotEqual(t, nil, err)
+				testutil.Equal(t, tc.wantErr, err.Error())
+				return
+			}
+			testutil.Assert(t, err == nil, "unexpected error: %s", err)
+			testutil.Equal(t, tc.wantAbortSC, tc.config.AbortStatus)
+		})
+	}
+}
+
+func testFaultInjectionHandler(faults *FaultInjectionConfig) http.Handler {
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	faults.validate()
+	return newFaultInjectionHandler(upstream, &UpstreamConfig{Service: "foo", FaultInjection: faults}, nil)
+}
+
+func TestFaultInjection(t *testing.T) {
+	// no faults are injected into requests when the percentages are zero
+	handler := testFaultInjectionHandler(&FaultInjectionConfig{Latency: time.Hour})
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	testutil.Equal(t, http.StatusTeapot, rw.Code)
+
+	handler = testFaultInjectionHandler(&FaultInjectionConfig{AbortPercent: 100, AbortStatus: http.StatusBadGateway})
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	testutil.Equal(t, http.StatusBadGateway, rw.Code)
+	testutil.Equal(t, "abort", rw.Header().Get(faultInjectedHeader))
+
+	handler = testFaultInjectionHandler(&FaultInjectionConfig{Latency: 20 * time.Millisecond, LatencyPercent: 100})
+	start := time.Now()
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	testutil.Equal(t, http.StatusTeapot, rw.Code)
+	testutil.Assert(t, time.Since(start) >= 20*time.Millisecond, "expected request to be delayed")
+}
+
+func TestFaultInjectionReset(t *testing.T) {
+	server := httptest.NewServer(testFaultInjectionHandler(&FaultInjectionConfig{ResetPercent: 100}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	testutil.NotEqual(t, nil, err)
+}
+
+func TestParseFaultInjectionConfig(t *testing.T) {
+	upstreamConfig := &UpstreamConfig{
+		RouteConfig: RouteConfig{
+			Options: &OptionsConfig{
+				FaultInjection: &FaultInjectionConfig{AbortPercent: 200},
+			},
+		},
+	}
+	err := parseOptionsConfig(upstreamConfig, nil)
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, "invalid fault_injection error=abort_percent must be between 0 and 100", err.Error())
+}
@@ -0,0 +1,22 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+)
+
+// featureFlagsReloadInterval is how often the feature flags file is reloaded, and so the longest
+// it takes to roll a feature back.
+const featureFlagsReloadInterval = 10 * time.Second
+
+// featureEnabled returns true if a feature is rolled out to the user of the session on the
+// upstream. Sessions of the same user get the same result, so that a user isn't switched between
+// behaviors from one request to the next; requests without a session get a random result.
+func (p *OAuthProxy) featureEnabled(name string, session *sessions.SessionState) bool {
+	key := ""
+	if session != nil {
+		key = session.Email
+	}
+	return p.featureFlags.Enabled(name, p.upstreamConfig.Service, key)
+}
@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestFeatureEnabled(t *testing.T) {
+	opts := testOptions()
+	opts.FeatureFlags = map[string]string{
+		"new_signer":     "0",
+		"new_signer/foo": "100",
+		"new_cipher":     "100",
+	}
+	err := opts.Validate()
+	testutil.Ok(t, err)
+
+	session := &sessions.SessionState{Email: "michael.bland@gsa.gov"}
+	for _, tc := range []struct {
+		service  string
+		feature  string
+		expected bool
+	}{
+		{service: "foo", feature: "new_signer", expected: true},
+		{service: "bar", feature: "new_signer", expected: false},
+		{service: "bar", feature: "new_cipher", expected: true},
+		{service: "bar", feature: "new_routing", expected: false},
+	} {
+		p := &OAuthProxy{
+			upstreamConfig: &UpstreamConfig{Service: tc.service},
+			featureFlags:   opts.featureFlags,
+		}
+		if got := p.featureEnabled(tc.feature, session); got != tc.expected {
+			t.Errorf("expected %s enabled on %s to be %v, got %v", tc.feature, tc.service, tc.expected, got)
+		}
+	}
+}
+
+func TestFeatureFlagsValidation(t *testing.T) {
+	opts := testOptions()
+	opts.FeatureFlags = map[string]string{"new_signer": "half"}
+	err := opts.Validate()
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, errorMsg([]string{
+		`invalid value for feature-flags: invalid feature flag "new_signer": "half" is not a percentage`,
+	}), err.Error())
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/18F/hmacauth"
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+	"github.com/buzzfeed/sso/internal/pkg/fips"
+)
+
+// fipsMode returns true if the proxy runs in FIPS mode, either because FIPS_MODE is set or
+// because it was built with the fips build tag.
+func (o *Options) fipsMode() bool {
+	return o.FIPSMode || fips.Required
+}
+
+// newCookieCipher returns the cipher of a cookie secret: AES-256-GCM in FIPS mode, and
+// AES-CMAC-SIV otherwise.
+func newCookieCipher(secret []byte) (aead.Cipher, error) {
+	if fips.Enabled() {
+		return aead.NewGCMCipher(secret)
+	}
+	return aead.NewMiscreantCipher(secret)
+}
+
+// validateFIPSOptions refuses the settings whose algorithms aren't FIPS-approved in FIPS mode.
+func validateFIPSOptions(o *Options, msgs []string) []string {
+	if !o.fipsMode() {
+		return msgs
+	}
+
+	// AES-256-GCM, which takes the place of AES-CMAC-SIV, has 32 byte keys
+	if o.CookieSecretKMSKey == "" && len(o.decodedCookieSecret) == 64 {
+		msgs = append(msgs, "invalid value for COOKIE_SECRET: must decode to 32 bytes in FIPS mode")
+	}
+
+	// TLS 1.3 isn't negotiated in FIPS mode, and neither are cipher suites that aren't approved
+	if o.OutboundTLSMinVersion == "1.3" {
+		msgs = append(msgs, "invalid value for outbound-tls-min-version: TLS 1.3 is not negotiated in FIPS mode")
+	}
+	if cipherSuites, err := parseCipherSuites(o.OutboundTLSCipherSuites); err == nil {
+		for _, suite := range cipherSuites {
+			if err := fips.CheckCipherSuite(suite); err != nil {
+				msgs = append(msgs, fmt.Sprintf("invalid value for outbound-tls-cipher-suites: %s", err))
+			}
+		}
+	}
+
+	if o.RequestSigningKey != "" {
+		// keys that don't load are reported when the proxy starts
+		if signer, err := NewRequestSigner(o.RequestSigningKey); err == nil {
+			if err := fips.CheckPublicKey(signer.publicKey); err != nil {
+				msgs = append(msgs, fmt.Sprintf("invalid value for request-signature-key: %s", err))
+			}
+		}
+	}
+
+	// upstream signing keys are template variables of the form hash:secret
+	templateVars := o.templateVars()
+	names := make([]string, 0, len(templateVars))
+	for name := range templateVars {
+		if strings.HasSuffix(name, "_signing_key") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		algorithm := strings.SplitN(templateVars[name], ":", 2)[0]
+		hash, err := hmacauth.DigestNameToCryptoHash(algorithm)
+		if err != nil {
+			// unsupported hashes are reported when the upstream configs are parsed
+			continue
+		}
+		if err := fips.CheckHash(hash); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid value for SSO_CONFIG_%s: %s", strings.ToUpper(name), err))
+		}
+	}
+	return msgs
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestValidateFIPSOptions(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	testutil.Ok(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	edKeyPEM := testSigningKeyPEM(t, "PRIVATE KEY", der, err)
+
+	o := testOptions()
+	o.CookieSecret = base64.StdEncoding.EncodeToString(append(aead.GenerateKey(), aead.GenerateKey()...))
+	o.RequestSigningKey = edKeyPEM
+	o.testTemplateVars["foo_signing_key"] = "sha1:secret"
+	o.testTemplateVars["bar_signing_key"] = "sha256:secret"
+	o.OutboundTLSMinVersion = "1.3"
+	o.OutboundTLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}
+
+	// settings that aren't FIPS-approved are allowed outside of FIPS mode
+	testutil.Ok(t, o.Validate())
+
+	o.FIPSMode = true
+	err = o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"invalid value for COOKIE_SECRET: must decode to 32 bytes in FIPS mode",
+		"invalid value for outbound-tls-min-version: TLS 1.3 is not negotiated in FIPS mode",
+		"invalid value for outbound-tls-cipher-suites: TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256 is not a FIPS-approved cipher suite",
+		"invalid value for request-signature-key: ed25519.PublicKey keys are not FIPS-approved",
+		"invalid value for SSO_CONFIG_FOO_SIGNING_KEY: SHA-1 is not a FIPS-approved hash",
+	}), err.Error())
+
+	o.CookieSecret = testEncodedCookieSecret
+	o.RequestSigningKey = ""
+	o.OutboundTLSMinVersion = "1.2"
+	o.OutboundTLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+	o.testTemplateVars["foo_signing_key"] = "sha512:secret"
+	testutil.Ok(t, o.Validate())
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// forwardAuthPath is the path that reverse proxies such as Traefik and Caddy delegate
+// authentication to when forward auth is enabled.
+const forwardAuthPath = "/oauth2/forward_auth"
+
+// setForwardAuth serves forward auth requests on forwardAuthPath with the authorize
+// handler, passing every other request through to next.
+//
+// Forward auth requests describe the original client request with the
+// X-Forwarded-Method, X-Forwarded-Proto, X-Forwarded-Host, and X-Forwarded-Uri headers.
+// The request is rebuilt from those headers, so the reverse proxy sees the same redirect
+// to sso_auth, error page, or identity headers that a proxied request would have produced.
+func setForwardAuth(path string, authorize http.Handler, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != path {
+			next.ServeHTTP(rw, req)
+			return
+		}
+
+		forwardedReq, err := newForwardedRequest(req)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		authorize.ServeHTTP(rw, forwardedReq)
+	})
+}
+
+// newForwardedRequest reconstructs the original client request described by the
+// X-Forwarded-* headers of a forward auth request.
+func newForwardedRequest(req *http.Request) (*http.Request, error) {
+	host := req.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		return nil, fmt.Errorf("missing X-Forwarded-Host header")
+	}
+
+	uri := req.Header.Get("X-Forwarded-Uri")
+	if uri == "" {
+		uri = "/"
+	}
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X-Forwarded-Uri header %q: %s", uri, err)
+	}
+
+	method := req.Header.Get("X-Forwarded-Method")
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	// the scheme of the original request is that of its url, as it is for requests proxied
+	// with an absolute url, so that the scheme checks of the authorize handler see it
+	proto := req.Header.Get("X-Forwarded-Proto")
+	switch proto {
+	case "":
+	case "http", "https":
+		u.Scheme = proto
+		u.Host = host
+	default:
+		return nil, fmt.Errorf("invalid X-Forwarded-Proto header %q", proto)
+	}
+
+	forwardedReq := req.WithContext(req.Context())
+	forwardedReq.Header = make(http.Header, len(req.Header))
+	for key, vals := range req.Header {
+		forwardedReq.Header[key] = append([]string(nil), vals...)
+	}
+	forwardedReq.Method = method
+	forwardedReq.URL = u
+	forwardedReq.Host = host
+	forwardedReq.RequestURI = uri
+	if proto == "http" {
+		// the forward auth request may be sent over TLS when the original request wasn't
+		forwardedReq.TLS = nil
+	}
+	// The forward auth request itself carries no body; the original request's
+	// body is never sent to us.
+	forwardedReq.Body = http.NoBody
+	forwardedReq.ContentLength = 0
+
+	return forwardedReq, nil
+}
@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestForwardAuth(t *testing.T) {
+	var received *http.Request
+	authorize := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received = req
+		rw.WriteHeader(http.StatusOK)
+	})
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	handler := setForwardAuth(forwardAuthPath, authorize, next)
+
+	testCases := []struct {
+		name           string
+		path           string
+		headers        map[string]string
+		expectedStatus int
+		expectedMethod string
+		expectedHost   string
+		expectedPath   string
+		expectedQuery  string
+		expectedScheme string
+	}{
+		{
+			name: "forward auth request is rebuilt from forwarded headers",
+			path: forwardAuthPath,
+			headers: map[string]string{
+				"X-Forwarded-Method": "POST",
+				"X-Forwarded-Proto":  "https",
+				"X-Forwarded-Host":   "foo.example.com",
+				"X-Forwarded-Uri":    "/bar?baz=qux",
+			},
+			expectedStatus: http.StatusOK,
+			expectedMethod: "POST",
+			expectedHost:   "foo.example.com",
+			expectedPath:   "/bar",
+			expectedQuery:  "baz=qux",
+			expectedScheme: "https",
+		},
+		{
+			name: "plain http requests keep their scheme",
+			path: forwardAuthPath,
+			headers: map[string]string{
+				"X-Forwarded-Proto": "http",
+				"X-Forwarded-Host":  "foo.example.com",
+			},
+			expectedStatus: http.StatusOK,
+			expectedMethod: "GET",
+			expectedHost:   "foo.example.com",
+			expectedPath:   "/",
+			expectedScheme: "http",
+		},
+		{
+			name: "missing method and uri default to GET /",
+			path: forwardAuthPath,
+			headers: map[string]string{
+				"X-Forwarded-Host": "foo.example.com",
+			},
+			expectedStatus: http.StatusOK,
+			expectedMethod: "GET",
+			expectedHost:   "foo.example.com",
+			expectedPath:   "/",
+		},
+		{
+			name:           "missing host is rejected",
+			path:           forwardAuthPath,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid uri is rejected",
+			path: forwardAuthPath,
+			headers: map[string]string{
+				"X-Forwarded-Host": "foo.example.com",
+				"X-Forwarded-Uri":  "not-a-uri",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "invalid proto is rejected",
+			path: forwardAuthPath,
+			headers: map[string]string{
+				"X-Forwarded-Proto": "gopher",
+				"X-Forwarded-Host":  "foo.example.com",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "other paths are passed through",
+			path:           "/bar",
+			expectedStatus: http.StatusTeapot,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			received = nil
+			req := httptest.NewRequest("GET", "http://sso-proxy.internal"+tc.path, nil)
+			for key, val := range tc.headers {
+				req.Header.Set(key, val)
+			}
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+
+			testutil.Equal(t, tc.expectedStatus, rw.Code)
+			if tc.expectedStatus != http.StatusOK {
+				testutil.Assert(t, received == nil, "expected authorize handler not to be called")
+				return
+			}
+			testutil.Equal(t, tc.expectedMethod, received.Method)
+			testutil.Equal(t, tc.expectedHost, received.Host)
+			testutil.Equal(t, tc.expectedPath, received.URL.Path)
+			testutil.Equal(t, tc.expectedQuery, received.URL.RawQuery)
+			testutil.Equal(t, tc.expectedScheme, received.URL.Scheme)
+		})
+	}
+}
+
+func TestForwardAuthRequireHTTPS(t *testing.T) {
+	handler := setForwardAuth(forwardAuthPath, requireHTTPS(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})), nil)
+
+	for proto, expectedStatus := range map[string]int{
+		"https": http.StatusOK,
+		"http":  http.StatusMovedPermanently,
+	} {
+		req := httptest.NewRequest("GET", "http://sso-proxy.internal"+forwardAuthPath, nil)
+		req.Header.Set("X-Forwarded-Proto", proto)
+		req.Header.Set("X-Forwarded-Host", "foo.example.com")
+		req.Header.Set("X-Forwarded-Uri", "/bar")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		testutil.Equal(t, expectedStatus, rw.Code)
+		if expectedStatus == http.StatusMovedPermanently {
+			testutil.Equal(t, "https://foo.example.com/bar", rw.Header().Get("Location"))
+		}
+	}
+}
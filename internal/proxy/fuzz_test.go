@@ -0,0 +1,35 @@
+//go:build go1.18
+// +build go1.18
+
+package proxy
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// FuzzLoadServiceConfigs loads arbitrary upstream configs files, which operators supply, and
+// which may be fetched from remote config sources.
+func FuzzLoadServiceConfigs(f *testing.F) {
+	for _, path := range []string{"testdata/upstream_configs.yml", "testdata/tenant_upstream_configs.yml"} {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatalf("error reading %s: %v", path, err)
+		}
+		f.Add(raw)
+	}
+	f.Add([]byte("version: 2\ndefaults:\n  options:\n    timeout: 1s\nenvironments:\n  sso:\n    vars:\n      root_domain: dev\nservices:\n- service: foo\n  default:\n    from: foo.{{root_domain}}\n    to: foo-internal\n    type: rewrite\n"))
+	f.Add([]byte("- service: foo\n  default:\n    from: foo.dev\n    to: foo-internal\n    extra_routes:\n    - from: bar.dev\n      to: bar-internal\n"))
+
+	templateVars := map[string]string{
+		"root_domain": "dev",
+		"cluster":     "sso",
+	}
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		// files fuzzed from an empty directory can't include others
+		loadServiceConfigsFrom("", raw, "sso", "http", templateVars, &OptionsConfig{
+			Timeout: time.Second,
+		})
+	})
+}
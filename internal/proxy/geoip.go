@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/buzzfeed/sso/internal/pkg/geoip"
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+type geoLocationKey struct{}
+
+// SetGeoIPLocator sets the locator used to look up the country and network of requests,
+// as a functional option
+func SetGeoIPLocator(locator *geoip.Locator) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.geoipLocator = locator
+		return nil
+	}
+}
+
+// setGeoLocation looks up the location of each request's client, recording it for the
+// request log and rejecting requests from countries the upstream does not allow.
+func (p *OAuthProxy) setGeoLocation(h http.Handler) http.Handler {
+	if p.geoipLocator == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		remoteAddr := getRemoteAddr(req)
+		location := p.geoipLocator.Lookup(remoteAddr)
+
+		// stash the location so that it can be logged later (see func logRequest)
+		if location.Country != "" {
+			rw.Header().Set(loggingCountryHeader, location.Country)
+		}
+		if location.ASN != 0 {
+			rw.Header().Set(loggingASNHeader, strconv.FormatUint(location.ASN, 10))
+		}
+
+		if !p.countryAllowed(location.Country) {
+			country := location.Country
+			if country == "" {
+				country = "unknown"
+			}
+			p.StatsdClient.Incr("geoip_blocked", []string{"action:geoip", "country:" + country}, 1.0)
+			logger := log.NewLogEntry().WithRemoteAddress(remoteAddr)
+			withGeoLocation(logger, location).WithRequestHost(req.Host).Info("request blocked by country")
+			p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Access from your location is not allowed")
+			return
+		}
+
+		h.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), geoLocationKey{}, location)))
+	})
+}
+
+// countryAllowed returns true if requests from a country may access the upstream. Requests
+// whose country is unknown are only allowed if the upstream has no allowed countries.
+func (p *OAuthProxy) countryAllowed(country string) bool {
+	for _, denied := range p.upstreamConfig.DeniedCountries {
+		if country == denied {
+			return false
+		}
+	}
+	if len(p.upstreamConfig.AllowedCountries) == 0 {
+		return true
+	}
+	for _, allowed := range p.upstreamConfig.AllowedCountries {
+		if country == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// withGeoLocation appends the country and network of a location to a LogEntry.
+func withGeoLocation(logger *log.LogEntry, location geoip.Location) *log.LogEntry {
+	if location.Country != "" {
+		logger = logger.WithCountry(location.Country)
+	}
+	if location.ASN != 0 {
+		logger = logger.WithASN(location.ASN)
+	}
+	return logger
+}
+
+// requestLogEntry returns a LogEntry for a request, with the client's address and, if it
+// was looked up, location.
+func requestLogEntry(req *http.Request) *log.LogEntry {
+	logger := log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req)).WithRequestID(requestID(req))
+	if location, ok := req.Context().Value(geoLocationKey{}).(geoip.Location); ok {
+		logger = withGeoLocation(logger, location)
+	}
+	return logger
+}
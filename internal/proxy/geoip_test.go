@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/geoip"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestCountryAllowed(t *testing.T) {
+	testCases := []struct {
+		name             string
+		allowedCountries []string
+		deniedCountries  []string
+		country          string
+		expected         bool
+	}{
+		{
+			name:     "no restrictions",
+			country:  "US",
+			expected: true,
+		},
+		{
+			name:     "no restrictions, unknown country",
+			expected: true,
+		},
+		{
+			name:             "allowed country",
+			allowedCountries: []string{"US", "GB"},
+			country:          "GB",
+			expected:         true,
+		},
+		{
+			name:             "country not allowed",
+			allowedCountries: []string{"US", "GB"},
+			country:          "FR",
+			expected:         false,
+		},
+		{
+			name:             "unknown country not allowed",
+			allowedCountries: []string{"US"},
+			expected:         false,
+		},
+		{
+			name:            "denied country",
+			deniedCountries: []string{"KP"},
+			country:         "KP",
+			expected:        false,
+		},
+		{
+			name:            "unknown country not denied",
+			deniedCountries: []string{"KP"},
+			expected:        true,
+		},
+		{
+			name:             "denied takes precedence",
+			allowedCountries: []string{"US"},
+			deniedCountries:  []string{"US"},
+			country:          "US",
+			expected:         false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &OAuthProxy{upstreamConfig: &UpstreamConfig{
+				AllowedCountries: tc.allowedCountries,
+				DeniedCountries:  tc.deniedCountries,
+			}}
+			testutil.Equal(t, tc.expected, p.countryAllowed(tc.country))
+		})
+	}
+}
+
+func TestSetGeoLocation(t *testing.T) {
+	// a locator without databases locates every request in an unknown country
+	locator, err := geoip.NewLocator("", "")
+	testutil.Assert(t, err == nil, "unexpected error creating locator: %s", err)
+
+	testCases := []struct {
+		name             string
+		locator          *geoip.Locator
+		allowedCountries []string
+		expectedCode     int
+	}{
+		{
+			name:             "without a locator",
+			allowedCountries: []string{"US"},
+			expectedCode:     http.StatusOK,
+		},
+		{
+			name:         "unrestricted upstream",
+			locator:      locator,
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:             "blocked request",
+			locator:          locator,
+			allowedCountries: []string{"US"},
+			expectedCode:     http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t, SetGeoIPLocator(tc.locator))
+			defer close()
+			proxy.upstreamConfig.AllowedCountries = tc.allowedCountries
+
+			handler := proxy.setGeoLocation(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				rw.WriteHeader(http.StatusOK)
+			}))
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, httptest.NewRequest("GET", "https://localhost/", nil))
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// gitConfigSource keeps a clone of the git repository the upstream configs file is read from,
+// and applies the changes to the file that are pushed to its branch, so that upstream configs
+// are managed as code, with the history and rollback of the repository.
+type gitConfigSource struct {
+	url        string
+	branch     string
+	path       string
+	dir        string
+	sshKey     string
+	knownHosts string
+	interval   time.Duration
+
+	StatsdClient *statsd.Client
+
+	// revision is the commit the running configs were read from
+	revision string
+	git      func(dir string, args ...string) (string, error)
+}
+
+// newGitConfigSource returns the gitConfigSource of the options.
+func newGitConfigSource(opts *Options) *gitConfigSource {
+	s := &gitConfigSource{
+		url:          opts.UpstreamConfigsGitURL,
+		branch:       opts.UpstreamConfigsGitBranch,
+		path:         opts.UpstreamConfigsGitPath,
+		dir:          opts.UpstreamConfigsGitDir,
+		sshKey:       opts.UpstreamConfigsGitSSHKey,
+		knownHosts:   opts.UpstreamConfigsGitKnownHosts,
+		interval:     opts.UpstreamConfigsGitInterval,
+		StatsdClient: opts.StatsdClient,
+	}
+	s.git = s.runGit
+	return s
+}
+
+// file returns the path of the upstream configs file in the clone of the repository.
+func (s *gitConfigSource) file() string {
+	return filepath.Join(s.dir, s.path)
+}
+
+// isSSHGitURL returns true if git connects to a repository over ssh, either by an ssh:// url or
+// the scp-like syntax of user@host:path.
+func isSSHGitURL(url string) bool {
+	if strings.HasPrefix(url, "ssh://") || strings.HasPrefix(url, "git+ssh://") {
+		return true
+	}
+	colon := strings.Index(url, ":")
+	return !strings.Contains(url, "://") && colon > 0 && !strings.Contains(url[:colon], "/")
+}
+
+// shellQuote quotes a word of the shell command git runs ssh with.
+func shellQuote(word string) string {
+	return "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+}
+
+// sshCommand returns the ssh command git connects to the repository with, which only trusts the
+// host keys of the known hosts file, and authenticates with the ssh key if one is set.
+func (s *gitConfigSource) sshCommand() string {
+	cmd := "ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile=" + shellQuote(s.knownHosts)
+	if s.sshKey != "" {
+		cmd += " -o IdentitiesOnly=yes -i " + shellQuote(s.sshKey)
+	}
+	return cmd
+}
+
+// runGit runs a git command in a directory, over ssh with the known hosts file if one is set,
+// and returns its trimmed output.
+func (s *gitConfigSource) runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if s.knownHosts != "" {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND="+s.sshCommand())
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %s: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// isClone returns true if the directory is a clone of the repository, or false if it doesn't
+// exist or is empty. Any other directory is an error, so that a mistyped directory is never
+// overwritten by a clone.
+func (s *gitConfigSource) isClone() (bool, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) || (err == nil && len(entries) == 0) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading git directory: %s", err)
+	}
+
+	notClone := fmt.Errorf("git directory %s isn't empty or a clone of %s", s.dir, s.url)
+	// the directory must be the top of its own clone, not within another repository
+	if info, err := os.Stat(filepath.Join(s.dir, ".git")); err != nil || !info.IsDir() {
+		return false, notClone
+	}
+	origin, err := s.git(s.dir, "config", "--get", "remote.origin.url")
+	if err != nil || origin != s.url {
+		return false, notClone
+	}
+	return true, nil
+}
+
+// checkout clones the branch of the repository into the directory, or pulls it if the directory
+// is a clone of the repository already, and returns the commit it's at.
+func (s *gitConfigSource) checkout() (string, error) {
+	clone, err := s.isClone()
+	if err != nil {
+		return "", err
+	}
+
+	var revision string
+	if clone {
+		revision, err = s.pull()
+	} else {
+		if err := os.MkdirAll(filepath.Dir(s.dir), 0700); err != nil {
+			return "", fmt.Errorf("error creating git directory: %s", err)
+		}
+		_, err = s.git(filepath.Dir(s.dir), "clone", "--quiet", "--depth", "1", "--branch", s.branch, "--single-branch", s.url, s.dir)
+		if err == nil {
+			revision, err = s.git(s.dir, "rev-parse", "HEAD")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	s.revision = revision
+	return revision, nil
+}
+
+// pull fetches the branch of the repository and checks out its latest commit, which it returns.
+// Local changes to the clone are discarded, and force pushes are followed, so that the configs
+// are always those of the branch.
+func (s *gitConfigSource) pull() (string, error) {
+	if _, err := s.git(s.dir, "fetch", "--quiet", "--depth", "1", "origin", s.branch); err != nil {
+		return "", err
+	}
+	if _, err := s.git(s.dir, "reset", "--quiet", "--hard", "FETCH_HEAD"); err != nil {
+		return "", err
+	}
+	return s.git(s.dir, "rev-parse", "HEAD")
+}
+
+// run pulls the repository at the configured interval, forever, and applies the upstream configs
+// file of every new commit. Configs that fail to apply are reported, and the running configs are
+// kept until a later commit fixes them.
+func (s *gitConfigSource) run(apply func(raw []byte) error) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.sync(apply)
+	}
+}
+
+// sync pulls the repository once, and applies the upstream configs file if the branch has moved.
+func (s *gitConfigSource) sync(apply func(raw []byte) error) {
+	logger := log.NewLogEntry()
+
+	revision, err := s.pull()
+	if err != nil {
+		s.StatsdClient.Incr("config.git_sync", []string{"result:error"}, 1.0)
+		logger.Error(err, "error pulling upstream configs repository; the running configs are unchanged")
+		return
+	}
+	if revision == s.revision {
+		s.StatsdClient.Incr("config.git_sync", []string{"result:unchanged"}, 1.0)
+		return
+	}
+
+	raw, err := ioutil.ReadFile(s.file())
+	if err == nil {
+		err = apply(raw)
+	}
+	if err != nil {
+		s.StatsdClient.Incr("config.git_sync", []string{"result:invalid"}, 1.0)
+		logger.Error(err, fmt.Sprintf("error applying upstream configs of commit %s; the running configs are unchanged", revision))
+		// the commit isn't retried until the branch moves again
+		s.revision = revision
+		return
+	}
+	s.StatsdClient.Incr("config.git_sync", []string{"result:applied"}, 1.0)
+	logger.Info(fmt.Sprintf("applied upstream configs of commit %s of %s@%s", revision, s.url, s.branch))
+	s.revision = revision
+}
+
+// validateGitConfigSourceOptions validates the git repository settings, and that its directory
+// can be cloned into. The repository is only cloned when the proxy starts, see
+// loadGitUpstreamConfigs.
+func validateGitConfigSourceOptions(o *Options, msgs []string) []string {
+	if o.UpstreamConfigsGitURL == "" {
+		return msgs
+	}
+	if o.UpstreamConfigsFile != "" {
+		return append(msgs, "invalid setting: upstream-configs and upstream-configs-git-url are mutually exclusive")
+	}
+
+	valid := true
+	if o.UpstreamConfigsGitBranch == "" {
+		msgs = append(msgs, "missing setting: upstream-configs-git-branch")
+		valid = false
+	}
+	if o.UpstreamConfigsGitDir == "" {
+		msgs = append(msgs, "missing setting: upstream-configs-git-dir")
+		valid = false
+	}
+	path := filepath.Clean(o.UpstreamConfigsGitPath)
+	if o.UpstreamConfigsGitPath == "" || filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, "../") {
+		msgs = append(msgs, "invalid value for upstream-configs-git-path: must be a relative path within the repository")
+		valid = false
+	}
+	if o.UpstreamConfigsGitInterval <= 0 {
+		msgs = append(msgs, "invalid value for upstream-configs-git-interval: must be positive")
+		valid = false
+	}
+	// host keys are never trusted on first use, since a container's first use is every use
+	if o.UpstreamConfigsGitKnownHosts == "" && (o.UpstreamConfigsGitSSHKey != "" || isSSHGitURL(o.UpstreamConfigsGitURL)) {
+		msgs = append(msgs, "missing setting: upstream-configs-git-known-hosts")
+		valid = false
+	}
+	if !valid {
+		return msgs
+	}
+
+	source := newGitConfigSource(o)
+	if _, err := source.isClone(); err != nil {
+		return append(msgs, fmt.Sprintf("invalid value for upstream-configs-git-dir: %s", err))
+	}
+	o.gitConfigSource = source
+	return msgs
+}
+
+// loadGitUpstreamConfigs clones the repository of the upstream configs when the proxy starts, and
+// loads and validates the upstream configs file of the commit it's at, as Validate does any other.
+func loadGitUpstreamConfigs(o *Options) error {
+	source := o.gitConfigSource
+	revision, err := source.checkout()
+	if err != nil {
+		return fmt.Errorf("error cloning upstream configs repository: %s", err)
+	}
+	o.UpstreamConfigsFile = source.file()
+
+	raw, err := ioutil.ReadFile(o.UpstreamConfigsFile)
+	if err != nil {
+		return fmt.Errorf("error reading upstream configs file: %s", err)
+	}
+	configs, err := o.loadUpstreamConfigs(raw)
+	if err != nil {
+		return fmt.Errorf("error parsing upstream configs file %s", err)
+	}
+	if err := o.validateUpstreamEnvironments(raw); err != nil {
+		return fmt.Errorf("error validating upstream configs environments %s", err)
+	}
+	o.upstreamConfigs = configs
+	o.upstreamConfigsRaw = raw
+
+	msgs := validateUpstreamConfigs(o, nil)
+	msgs = validateFairQueueOptions(o, msgs)
+	msgs = validateDefaultRouteOptions(o, msgs)
+	msgs = validatePortalOptions(o, msgs)
+	if len(msgs) != 0 {
+		return fmt.Errorf("invalid upstream configs of commit %s:\n  %s", revision, strings.Join(msgs, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateGitConfigSourceOptions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		setup    func(*Options)
+		expected []string
+	}{
+		{
+			name:  "no repository",
+			setup: func(*Options) {},
+		},
+		{
+			name: "repository and file",
+			setup: func(o *Options) {
+				o.UpstreamConfigsFile = "testdata/upstream_configs.yml"
+			},
+			expected: []string{"invalid setting: upstream-configs and upstream-configs-git-url are mutually exclusive"},
+		},
+		{
+			name: "path outside the repository",
+			setup: func(o *Options) {
+				o.UpstreamConfigsGitPath = "../upstream_configs.yml"
+			},
+			expected: []string{"invalid value for upstream-configs-git-path: must be a relative path within the repository"},
+		},
+		{
+			name: "ssh without known hosts",
+			setup: func(o *Options) {
+				o.UpstreamConfigsGitKnownHosts = ""
+			},
+			expected: []string{"missing setting: upstream-configs-git-known-hosts"},
+		},
+		{
+			name: "missing branch and interval",
+			setup: func(o *Options) {
+				o.UpstreamConfigsGitBranch = ""
+				o.UpstreamConfigsGitInterval = 0
+			},
+			expected: []string{
+				"missing setting: upstream-configs-git-branch",
+				"invalid value for upstream-configs-git-interval: must be positive",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := NewOptions()
+			o.UpstreamConfigsGitURL = "git@example.com:sso/configs.git"
+			o.UpstreamConfigsGitKnownHosts = "/etc/sso/known_hosts"
+			if tc.expected == nil {
+				o.UpstreamConfigsGitURL = ""
+			}
+			tc.setup(o)
+			msgs := validateGitConfigSourceOptions(o, nil)
+			if fmt.Sprint(msgs) != fmt.Sprint(tc.expected) {
+				t.Errorf("unexpected messages\n got: %v\nwant: %v", msgs, tc.expected)
+			}
+			if o.gitConfigSource != nil {
+				t.Errorf("expected no git config source")
+			}
+		})
+	}
+}
+
+func TestGitSSHCommand(t *testing.T) {
+	for url, ssh := range map[string]bool{
+		"git@github.com:example/sso-configs.git":       true,
+		"ssh://git@github.com/example/sso-configs.git": true,
+		"https://github.com/example/sso-configs.git":   false,
+		"/var/lib/sso/sso-configs":                     false,
+		"./sso-configs:v2":                             false,
+		"file:///var/lib/sso/sso-configs.git":          false,
+	} {
+		if isSSHGitURL(url) != ssh {
+			t.Errorf("expected isSSHGitURL(%q) to be %v", url, ssh)
+		}
+	}
+
+	s := &gitConfigSource{knownHosts: "/etc/sso/known hosts", sshKey: "/etc/sso/it's a key"}
+	want := `ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile='/etc/sso/known hosts' -o IdentitiesOnly=yes -i '/etc/sso/it'\''s a key'`
+	if got := s.sshCommand(); got != want {
+		t.Errorf("unexpected ssh command\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestGitConfigSourceDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sso-git-config-source")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep me"), 0600); err != nil {
+		t.Fatalf("unexpected error writing file: %v", err)
+	}
+
+	// a directory that isn't a clone of the repository is refused, and left alone
+	o := testOptions()
+	o.UpstreamConfigsFile = ""
+	o.UpstreamConfigsGitURL = "https://example.com/sso/configs.git"
+	o.UpstreamConfigsGitDir = dir
+	msgs := validateGitConfigSourceOptions(o, nil)
+	want := fmt.Sprintf("invalid value for upstream-configs-git-dir: git directory %s isn't empty or a clone of %s", dir, o.UpstreamConfigsGitURL)
+	if fmt.Sprint(msgs) != fmt.Sprint([]string{want}) {
+		t.Errorf("unexpected messages\n got: %v\nwant: %v", msgs, []string{want})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("expected the directory to be left alone: %v", err)
+	}
+
+	// a missing directory is cloned into when the proxy starts
+	o.UpstreamConfigsGitDir = filepath.Join(dir, "clone")
+	if msgs := validateGitConfigSourceOptions(o, nil); len(msgs) != 0 {
+		t.Errorf("unexpected messages: %v", msgs)
+	}
+	if _, err := os.Stat(o.UpstreamConfigsGitDir); !os.IsNotExist(err) {
+		t.Errorf("expected the repository not to be cloned when validating options")
+	}
+}
+
+func TestGitConfigSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+
+	dir, err := ioutil.TempDir("", "sso-git-config-source")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "repo")
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=sso", "-c", "user.email=sso@example.com"}, args...)...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("unexpected error running git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	commit := func(configs string) {
+		if err := ioutil.WriteFile(filepath.Join(repo, "upstream_configs.yml"), []byte(configs), 0600); err != nil {
+			t.Fatalf("unexpected error writing upstream configs: %v", err)
+		}
+		git("add", "upstream_configs.yml")
+		git("commit", "--quiet", "-m", "update upstream configs")
+	}
+	if err := os.Mkdir(repo, 0700); err != nil {
+		t.Fatalf("unexpected error creating repo: %v", err)
+	}
+	git("init", "--quiet")
+	git("checkout", "--quiet", "-b", "main")
+	commit(`
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: foo-internal.{{cluster}}.{{root_domain}}
+`)
+
+	opts := testOptions()
+	opts.UpstreamConfigsFile = ""
+	opts.UpstreamConfigsGitURL = repo
+	opts.UpstreamConfigsGitDir = filepath.Join(dir, "clone")
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("unexpected error validating options: %v", err)
+	}
+	if opts.gitConfigSource == nil {
+		t.Fatalf("expected a git config source")
+	}
+	if len(opts.upstreamConfigs) != 0 {
+		t.Fatalf("expected the repository not to be loaded until the proxy starts, got %v", opts.upstreamConfigs)
+	}
+	if err := loadGitUpstreamConfigs(opts); err != nil {
+		t.Fatalf("unexpected error loading upstream configs: %v", err)
+	}
+	if len(opts.upstreamConfigs) != 1 || opts.upstreamConfigs[0].Service != "foo" {
+		t.Fatalf("expected the upstream configs of the repository, got %v", opts.upstreamConfigs)
+	}
+
+	components, err := newProxyComponents(opts)
+	if err != nil {
+		t.Fatalf("unexpected error creating proxy components: %v", err)
+	}
+	routing, err := newUpstreamRouting(opts, components)
+	if err != nil {
+		t.Fatalf("unexpected error routing upstreams: %v", err)
+	}
+	reloader := newUpstreamReloader(opts, components, routing)
+	routed := func(host string) bool {
+		rw := httptest.NewRecorder()
+		reloader.handler.ServeHTTP(rw, httptest.NewRequest("GET", fmt.Sprintf("http://%s/", host), nil))
+		return rw.Code != http.StatusMisdirectedRequest
+	}
+	if !routed("foo.sso.dev") || routed("bar.sso.dev") {
+		t.Fatalf("expected only foo to be routed")
+	}
+
+	// a valid commit is applied
+	commit(`
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: bar-internal.{{cluster}}.{{root_domain}}
+`)
+	opts.gitConfigSource.sync(reloader.reload)
+	if routed("foo.sso.dev") || !routed("bar.sso.dev") {
+		t.Errorf("expected only bar to be routed")
+	}
+
+	// an invalid commit is skipped, and the running configs are kept
+	commit(`
+- service: baz
+  default:
+    from: baz.{{cluster}}.{{root_domain}}
+    to: baz-internal.{{cluster}}.{{root_domain}}
+    options:
+      timeout: 1h
+`)
+	opts.gitConfigSource.sync(reloader.reload)
+	if routed("baz.sso.dev") || !routed("bar.sso.dev") {
+		t.Errorf("expected bar to still be routed")
+	}
+
+	// a restart pulls the existing clone rather than replacing it
+	restarted := newGitConfigSource(opts)
+	revision, err := restarted.checkout()
+	if err != nil {
+		t.Fatalf("unexpected error checking out the existing clone: %v", err)
+	}
+	if revision != opts.gitConfigSource.revision {
+		t.Errorf("expected the clone to be at %s, got %s", opts.gitConfigSource.revision, revision)
+	}
+}
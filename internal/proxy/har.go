@@ -0,0 +1,405 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// harMaxBodySize is the number of bytes of each request and response body recorded.
+	harMaxBodySize = 64 * 1024
+	// harDefaultCount is the number of requests recorded when the count isn't given.
+	harDefaultCount = 10
+	// harMaxCount is the largest number of requests a single capture may record.
+	harMaxCount = 1000
+	// harMaxCaptures is the number of captures kept, after which the oldest is dropped.
+	harMaxCaptures = 10
+)
+
+// The types below are the subset of the HTTP Archive (HAR) 1.2 format recorded by captures,
+// see http://www.softwareishard.com/blog/har-12-spec/
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+
+	// user is the email of the user who made the request, if it was authenticated.
+	user string
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harCapture records the next requests of an upstream.
+type harCapture struct {
+	ID        string    `json:"id"`
+	Service   string    `json:"service"`
+	Count     int       `json:"count"`
+	Recorded  int       `json:"recorded"`
+	Done      bool      `json:"done"`
+	StartedAt time.Time `json:"started_at"`
+
+	entries []*harEntry
+}
+
+// harRecorder holds the captures started through the admin API, which are recorded by the
+// handler of each upstream, see newHARHandler.
+type harRecorder struct {
+	scrub *scrubber
+	// retention is how long captures are kept after they're started, forever if zero.
+	retention time.Duration
+
+	mu       sync.RWMutex
+	services map[string]bool
+	active   map[string]*harCapture
+	captures []*harCapture
+}
+
+func newHARRecorder(retention time.Duration) *harRecorder {
+	return &harRecorder{
+		scrub:     newScrubber(nil),
+		retention: retention,
+		services:  make(map[string]bool),
+		active:    make(map[string]*harCapture),
+	}
+}
+
+// start starts a capture of the next count requests of a service, replacing any capture of
+// the service in progress. It returns false if the service isn't known.
+func (r *harRecorder) start(service string, count int) (*harCapture, bool, error) {
+	id, err := randomString(12)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.services[service] {
+		return nil, false, nil
+	}
+	r.prune(time.Now())
+
+	if previous, ok := r.active[service]; ok {
+		previous.Done = true
+	}
+	c := &harCapture{ID: id, Service: service, Count: count, StartedAt: time.Now()}
+	r.active[service] = c
+	r.captures = append(r.captures, c)
+	if len(r.captures) > harMaxCaptures {
+		dropped := r.captures[0]
+		r.captures = r.captures[1:]
+		if r.active[dropped.Service] == dropped {
+			delete(r.active, dropped.Service)
+		}
+	}
+	return c, true, nil
+}
+
+// list returns a copy of every capture, oldest first.
+func (r *harRecorder) list() []harCapture {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+	captures := make([]harCapture, 0, len(r.captures))
+	for _, c := range r.captures {
+		captures = append(captures, *c)
+	}
+	return captures
+}
+
+// har returns the HAR file of a capture, with the requests it recorded so far, and the
+// service it records.
+func (r *harRecorder) har(id string) (*harFile, string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+	for _, c := range r.captures {
+		if c.ID == id {
+			entries := make([]*harEntry, len(c.entries))
+			copy(entries, c.entries)
+			return &harFile{Log: harLog{
+				Version: "1.2",
+				Creator: harCreator{Name: "sso-proxy", Version: VERSION},
+				Entries: entries,
+			}}, c.Service, true
+		}
+	}
+	return nil, "", false
+}
+
+// remove stops and removes a capture, returning false if it doesn't exist.
+func (r *harRecorder) remove(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, c := range r.captures {
+		if c.ID == id {
+			r.captures = append(r.captures[:i], r.captures[i+1:]...)
+			if r.active[c.Service] == c {
+				delete(r.active, c.Service)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// purgeUser removes the requests of a user from every capture, returning the number removed.
+func (r *harRecorder) purgeUser(email string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	purged := 0
+	for _, c := range r.captures {
+		entries := c.entries[:0]
+		for _, entry := range c.entries {
+			if entry.user != "" && strings.EqualFold(entry.user, email) {
+				purged++
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		c.entries = entries
+	}
+	return purged
+}
+
+// prune drops the captures started longer than the retention ago, and must be called with the
+// lock held.
+func (r *harRecorder) prune(now time.Time) {
+	if r.retention == 0 {
+		return
+	}
+	captures := []*harCapture{}
+	for _, c := range r.captures {
+		if now.Sub(c.StartedAt) > r.retention {
+			if r.active[c.Service] == c {
+				delete(r.active, c.Service)
+			}
+			continue
+		}
+		captures = append(captures, c)
+	}
+	r.captures = captures
+}
+
+// capturing returns true if there is a capture in progress for the service.
+func (r *harRecorder) capturing(service string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.active[service]
+	return ok
+}
+
+// record adds an entry to the capture in progress for the service, if any, completing the
+// capture once it has recorded its count.
+func (r *harRecorder) record(service string, entry *harEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+	c, ok := r.active[service]
+	if !ok {
+		return
+	}
+	c.entries = append(c.entries, entry)
+	c.Recorded++
+	if c.Recorded >= c.Count {
+		c.Done = true
+		delete(r.active, service)
+	}
+}
+
+// harHandler records the requests of an upstream while a capture of it is in progress.
+type harHandler struct {
+	service  string
+	handler  http.Handler
+	recorder *harRecorder
+}
+
+// newHARHandler wraps the handler of an upstream, registering it with the recorder.
+func newHARHandler(handler http.Handler, config *UpstreamConfig, recorder *harRecorder) http.Handler {
+	recorder.mu.Lock()
+	recorder.services[config.Service] = true
+	recorder.mu.Unlock()
+
+	return &harHandler{
+		service:  config.Service,
+		handler:  handler,
+		recorder: recorder,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *harHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// upgraded connections, e.g. websockets, aren't recorded
+	if req.Header.Get("Upgrade") != "" || !h.recorder.capturing(h.service) {
+		h.handler.ServeHTTP(rw, req)
+		return
+	}
+
+	start := time.Now()
+	var reqBody []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		reqBody, _ = ioutil.ReadAll(io.LimitReader(req.Body, harMaxBodySize))
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(reqBody), req.Body), req.Body}
+	}
+	reqHeader := cloneHeader(req.Header)
+	reqURL := *req.URL
+	reqURL.Scheme = "https"
+	if req.TLS == nil && req.Header.Get("X-Forwarded-Proto") != "https" {
+		reqURL.Scheme = "http"
+	}
+	reqURL.Host = req.Host
+
+	crw := &captureResponseWriter{ResponseWriter: rw, limit: harMaxBodySize}
+	h.handler.ServeHTTP(crw, req)
+	elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+
+	scrub := h.recorder.scrub
+	entry := &harEntry{
+		StartedDateTime: start,
+		Time:            elapsed,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         reqURL.Scheme + "://" + reqURL.Host + scrub.uri(&reqURL),
+			HTTPVersion: req.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(scrub.headers(reqHeader)),
+			QueryString: harQuery(scrub, reqURL.RawQuery),
+			HeadersSize: -1,
+			BodySize:    req.ContentLength,
+		},
+		Response: harResponse{
+			Status:      crw.status(),
+			StatusText:  http.StatusText(crw.status()),
+			HTTPVersion: req.Proto,
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(scrub.headers(cloneHeader(rw.Header()))),
+			Content: harContent{
+				Size:     int64(crw.body.Len()),
+				MimeType: rw.Header().Get("Content-Type"),
+				Text:     scrub.body(rw.Header().Get("Content-Type"), crw.body.Bytes()),
+			},
+			RedirectURL: rw.Header().Get("Location"),
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: harTimings{Send: 0, Wait: elapsed, Receive: 0},
+		user:    reqHeader.Get("X-Forwarded-Email"),
+	}
+	if len(reqBody) != 0 {
+		entry.Request.PostData = &harPostData{
+			MimeType: reqHeader.Get("Content-Type"),
+			Text:     scrub.body(reqHeader.Get("Content-Type"), reqBody),
+		}
+	}
+	h.recorder.record(h.service, entry)
+}
+
+// harHeaders returns headers as HAR name value pairs, sorted by name.
+func harHeaders(h http.Header) []harNameValue {
+	pairs := []harNameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			pairs = append(pairs, harNameValue{Name: name, Value: v})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Name < pairs[j].Name })
+	return pairs
+}
+
+// harQuery returns the parameters of a query as HAR name value pairs, sorted by name, with
+// the values of scrubbed fields redacted.
+func harQuery(scrub *scrubber, rawQuery string) []harNameValue {
+	pairs := []harNameValue{}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return pairs
+	}
+	for name, vals := range values {
+		for _, v := range vals {
+			if scrub.fieldNames[strings.ToLower(name)] {
+				v = redacted
+			}
+			pairs = append(pairs, harNameValue{Name: name, Value: scrub.text(v)})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Name < pairs[j].Name })
+	return pairs
+}
+
+// harFilename returns the value of the Content-Disposition header a capture is downloaded with.
+func harFilename(service, id string) string {
+	return mime.FormatMediaType("attachment", map[string]string{
+		"filename": service + "-" + id + ".har",
+	})
+}
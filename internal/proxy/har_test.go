@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestAdminHandlerHAR(t *testing.T) {
+	recorder := newHARRecorder(0)
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Set-Cookie", "upstream=session")
+		rw.Write([]byte(`{"ok":true,"access_token":"abc"}`))
+	})
+	handler := newHARHandler(upstream, &UpstreamConfig{Service: "foo"}, recorder)
+	admin := newAdminHandler(adminConfig{token: "admin-token", har: recorder})
+
+	serveAdmin := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rw := httptest.NewRecorder()
+		admin.ServeHTTP(rw, req)
+		return rw
+	}
+	proxyRequest := func() {
+		req := httptest.NewRequest("POST", "https://foo.sso.dev/items?q=1&token=secret", strings.NewReader("user=jane&password=hunter2"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Cookie", "_sso_proxy=session")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// requests aren't recorded without a capture
+	proxyRequest()
+
+	testutil.Equal(t, http.StatusNotFound, serveAdmin("POST", "http://localhost/admin/har", `{"service":"bar"}`).Code)
+	testutil.Equal(t, http.StatusBadRequest, serveAdmin("POST", "http://localhost/admin/har", `{"service":"foo","count":-1}`).Code)
+
+	rw := serveAdmin("POST", "http://localhost/admin/har", `{"service":"foo","count":2}`)
+	testutil.Equal(t, http.StatusCreated, rw.Code)
+	capture := harCapture{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &capture))
+	testutil.Equal(t, "foo", capture.Service)
+	testutil.Equal(t, 2, capture.Count)
+
+	for i := 0; i < 3; i++ {
+		proxyRequest()
+	}
+
+	rw = serveAdmin("GET", "http://localhost/admin/har", "")
+	listing := struct {
+		Captures []harCapture `json:"captures"`
+	}{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &listing))
+	testutil.Equal(t, 1, len(listing.Captures))
+	testutil.Equal(t, 2, listing.Captures[0].Recorded)
+	testutil.Assert(t, listing.Captures[0].Done, "expected the capture to be done")
+
+	rw = serveAdmin("GET", "http://localhost/admin/har/"+capture.ID, "")
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, `attachment; filename=foo-`+capture.ID+`.har`, rw.Header().Get("Content-Disposition"))
+	har := harFile{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &har))
+	testutil.Equal(t, "1.2", har.Log.Version)
+	testutil.Equal(t, 2, len(har.Log.Entries))
+
+	entry := har.Log.Entries[0]
+	testutil.Equal(t, "POST", entry.Request.Method)
+	testutil.Equal(t, "https://foo.sso.dev/items?q=1&token=[REDACTED]", entry.Request.URL)
+	testutil.Equal(t, []harNameValue{{"q", "1"}, {"token", redacted}}, entry.Request.QueryString)
+	testutil.Equal(t, "password=[REDACTED]&user=jane", entry.Request.PostData.Text)
+	testutil.Equal(t, harNameValue{"Content-Type", "application/x-www-form-urlencoded"}, entry.Request.Headers[0])
+	testutil.Equal(t, harNameValue{"Cookie", redacted}, entry.Request.Headers[1])
+	testutil.Equal(t, http.StatusOK, entry.Response.Status)
+	testutil.Equal(t, `{"access_token":"[REDACTED]","ok":true}`, entry.Response.Content.Text)
+	testutil.Equal(t, harNameValue{"Set-Cookie", redacted}, entry.Response.Headers[1])
+
+	testutil.Equal(t, http.StatusNoContent, serveAdmin("DELETE", "http://localhost/admin/har/"+capture.ID, "").Code)
+	testutil.Equal(t, http.StatusNotFound, serveAdmin("GET", "http://localhost/admin/har/"+capture.ID, "").Code)
+}
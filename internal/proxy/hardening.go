@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+// Hardened mode checks, which HARDENED_MODE_EXCEPTIONS may skip by name.
+const (
+	hardeningCookieSecure   = "cookie_secure"
+	hardeningCookieHTTPOnly = "cookie_http_only"
+	hardeningOutboundTLS    = "outbound_tls"
+	hardeningRunAsRoot      = "run_as_root"
+	hardeningWritableFiles  = "writable_files"
+)
+
+var hardeningChecks = []string{
+	hardeningCookieSecure,
+	hardeningCookieHTTPOnly,
+	hardeningOutboundTLS,
+	hardeningRunAsRoot,
+	hardeningWritableFiles,
+}
+
+// isHardeningCheck returns true if a name is the name of a hardened mode check.
+func isHardeningCheck(name string) bool {
+	for _, check := range hardeningChecks {
+		if check == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hardeningException returns true if a hardened mode check is skipped by HARDENED_MODE_EXCEPTIONS.
+func (o *Options) hardeningException(check string) bool {
+	for _, exception := range o.HardenedModeExceptions {
+		if exception == check {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHardeningOptions refuses insecure settings in hardened mode, unless their checks are
+// skipped, and requires the state directory that's the only one the proxy may write to.
+func validateHardeningOptions(o *Options, msgs []string) []string {
+	for _, exception := range o.HardenedModeExceptions {
+		if !isHardeningCheck(exception) {
+			msgs = append(msgs, fmt.Sprintf("invalid value for hardened-mode-exceptions: %q, must be one of %s",
+				exception, strings.Join(hardeningChecks, ", ")))
+		}
+	}
+	if !o.HardenedMode {
+		return msgs
+	}
+
+	if o.StateDir == "" {
+		msgs = append(msgs, "missing setting: state-dir is required in hardened mode")
+	} else if !filepath.IsAbs(o.StateDir) {
+		msgs = append(msgs, "invalid value for state-dir: must be an absolute path")
+	} else if o.UpstreamConfigsGitURL != "" && !withinDir(o.UpstreamConfigsGitDir, o.StateDir) {
+		msgs = append(msgs, "invalid value for upstream-configs-git-dir: must be within state-dir in hardened mode")
+	} else if o.UsageReporting && o.UsageFile != "" && !withinDir(o.UsageFile, o.StateDir) {
+		msgs = append(msgs, "invalid value for usage-file: must be within state-dir in hardened mode")
+	}
+
+	if o.Scheme == "https" && !o.CookieSecure && !o.hardeningException(hardeningCookieSecure) {
+		msgs = append(msgs, "invalid setting: cookie-secure must be true with the https scheme in hardened mode")
+	}
+	if !o.CookieHTTPOnly && !o.hardeningException(hardeningCookieHTTPOnly) {
+		msgs = append(msgs, "invalid setting: cookie-http-only must be true in hardened mode")
+	}
+	if (o.OutboundTLSMinVersion == "1.0" || o.OutboundTLSMinVersion == "1.1") && !o.hardeningException(hardeningOutboundTLS) {
+		msgs = append(msgs, "invalid value for outbound-tls-min-version: must be 1.2 or later in hardened mode")
+	}
+	return msgs
+}
+
+// Harden drops the privileges of the proxy to RUN_AS_USER, and should be called once its ports
+// are bound, so that it can still bind low ports. In hardened mode, it then verifies that the
+// proxy can run with no write access besides the state directory, which becomes its temp
+// directory: that it doesn't run as root, that the state directory is writable, and that its
+// executable and the files it reads aren't.
+func Harden(opts *Options) error {
+	if opts.RunAsUser != "" {
+		if err := dropPrivileges(opts.RunAsUser); err != nil {
+			return fmt.Errorf("error dropping privileges to %s: %v", opts.RunAsUser, err)
+		}
+	}
+	if !opts.HardenedMode {
+		return nil
+	}
+
+	if os.Geteuid() == 0 && !opts.hardeningException(hardeningRunAsRoot) {
+		return errors.New("hardened mode: the proxy runs as root; set RUN_AS_USER to drop its privileges once its ports are bound")
+	}
+	if err := checkStateDir(opts.StateDir); err != nil {
+		return fmt.Errorf("hardened mode: state directory %s isn't writable: %v", opts.StateDir, err)
+	}
+	if err := os.Setenv("TMPDIR", opts.StateDir); err != nil {
+		return err
+	}
+
+	if !opts.hardeningException(hardeningWritableFiles) {
+		var writable []string
+		for _, path := range readOnlyPaths(opts) {
+			if isWritable(path) {
+				writable = append(writable, path)
+			}
+		}
+		if len(writable) != 0 {
+			return fmt.Errorf("hardened mode: files outside the state directory are writable: %s",
+				strings.Join(writable, ", "))
+		}
+	}
+
+	log.NewLogEntry().Info("hardened mode: only the state directory ", opts.StateDir, " is writable")
+	return nil
+}
+
+// checkStateDir verifies that files can be written to the state directory.
+func checkStateDir(dir string) error {
+	f, err := ioutil.TempFile(dir, ".sso-proxy-probe")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// readOnlyPaths returns the executable of the proxy and the files it reads, which it shouldn't be
+// able to write to, unless they're in the state directory.
+func readOnlyPaths(opts *Options) []string {
+	paths := []string{}
+	if executable, err := os.Executable(); err == nil {
+		paths = append(paths, executable, filepath.Dir(executable))
+	}
+	if opts.UpstreamConfigsFile != "" && !isUpstreamConfigsURL(opts.UpstreamConfigsFile) {
+		paths = append(paths, opts.UpstreamConfigsFile)
+	}
+	for _, path := range []string{
+		opts.FeatureFlagsFile,
+		opts.CookieSecretKMSCiphertextFile,
+		opts.OutboundTLSCABundle,
+		opts.GeoIPDatabase,
+	} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	readOnly := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !withinDir(path, opts.StateDir) {
+			readOnly = append(readOnly, path)
+		}
+	}
+	return readOnly
+}
+
+// withinDir returns true if a path is within a directory, or is the directory.
+func withinDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestValidateHardeningOptions(t *testing.T) {
+	o := testOptions()
+	o.Scheme = "https"
+	o.CookieSecure = false
+	o.CookieHTTPOnly = false
+	o.OutboundTLSMinVersion = "1.1"
+
+	// insecure settings are allowed outside of hardened mode
+	testutil.Ok(t, o.Validate())
+
+	o.HardenedMode = true
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"missing setting: state-dir is required in hardened mode",
+		"invalid setting: cookie-secure must be true with the https scheme in hardened mode",
+		"invalid setting: cookie-http-only must be true in hardened mode",
+		"invalid value for outbound-tls-min-version: must be 1.2 or later in hardened mode",
+	}), err.Error())
+
+	o.StateDir = "state"
+	o.HardenedModeExceptions = []string{"cookie_secure", "cookie_http_only", "outbound_tls", "everything"}
+	err = o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		`invalid value for hardened-mode-exceptions: "everything", must be one of cookie_secure, cookie_http_only, outbound_tls, run_as_root, writable_files`,
+		"invalid value for state-dir: must be an absolute path",
+	}), err.Error())
+
+	o.StateDir = "/var/lib/sso-proxy"
+	o.HardenedModeExceptions = nil
+	o.CookieSecure = true
+	o.CookieHTTPOnly = true
+	o.OutboundTLSMinVersion = "1.2"
+	testutil.Ok(t, o.Validate())
+
+	o.UsageReporting = true
+	o.UsageFile = "/var/lib/usage.json"
+	err = o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"invalid value for usage-file: must be within state-dir in hardened mode",
+	}), err.Error())
+
+	o.UsageFile = "/var/lib/sso-proxy/usage.json"
+	testutil.Ok(t, o.Validate())
+}
+
+func TestHarden(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "state")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(stateDir)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+
+	o := testOptions()
+	o.StateDir = filepath.Join(stateDir, "missing")
+
+	// nothing is verified outside of hardened mode
+	testutil.Ok(t, Harden(o))
+
+	o.HardenedMode = true
+	o.HardenedModeExceptions = []string{hardeningRunAsRoot, hardeningWritableFiles}
+	err = Harden(o)
+	testutil.NotEqual(t, nil, err)
+
+	o.StateDir = stateDir
+	testutil.Ok(t, Harden(o))
+	testutil.Equal(t, stateDir, os.TempDir())
+
+	// the probe is removed from the state directory
+	files, err := ioutil.ReadDir(stateDir)
+	testutil.Ok(t, err)
+	testutil.Equal(t, 0, len(files))
+}
+
+func TestReadOnlyPaths(t *testing.T) {
+	o := testOptions()
+	o.StateDir = "/var/lib/sso-proxy"
+	o.FeatureFlagsFile = "/etc/sso-proxy/features.yml"
+	o.GeoIPDatabase = "/var/lib/sso-proxy/GeoLite2-Country.mmdb"
+
+	paths := readOnlyPaths(o)
+	executable, err := os.Executable()
+	testutil.Ok(t, err)
+	testutil.Equal(t, []string{
+		executable,
+		filepath.Dir(executable),
+		"testdata/upstream_configs.yml",
+		"/etc/sso-proxy/features.yml",
+	}, paths)
+}
+
+func TestWithinDir(t *testing.T) {
+	testCases := []struct {
+		path   string
+		within bool
+	}{
+		{"/var/lib/sso-proxy", true},
+		{"/var/lib/sso-proxy/git", true},
+		{"/var/lib/sso-proxy/../sso-proxy/git", true},
+		{"/var/lib/sso-proxy-git", false},
+		{"/var/lib", false},
+		{"/tmp/sso-upstream-configs", false},
+	}
+	for _, tc := range testCases {
+		if got := withinDir(tc.path, "/var/lib/sso-proxy"); got != tc.within {
+			t.Errorf("expected withinDir(%q) to be %v, got %v", tc.path, tc.within, got)
+		}
+	}
+}
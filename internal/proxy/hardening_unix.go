@@ -0,0 +1,63 @@
+//go:build !windows
+// +build !windows
+
+package proxy
+
+import (
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// accessWrite is the W_OK mode of access(2).
+const accessWrite = 0x2
+
+// dropPrivileges switches the process to a user, given as user or user:group by name or id, and
+// to the user's primary group if no group is given. Every thread of the process switches since
+// Go 1.16; earlier releases return an error, refusing to run with privileges it was asked to drop.
+func dropPrivileges(spec string) error {
+	name, group := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, group = spec[:i], spec[i+1:]
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return err
+		}
+	}
+	gid := u.Gid
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return err
+			}
+		}
+		gid = g.Gid
+	}
+
+	uidNum, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return err
+	}
+	gidNum, err := strconv.Atoi(gid)
+	if err != nil {
+		return err
+	}
+	// the group is dropped first, since only a privileged user can change it
+	if err := syscall.Setgroups([]int{gidNum}); err != nil {
+		return err
+	}
+	if err := syscall.Setgid(gidNum); err != nil {
+		return err
+	}
+	return syscall.Setuid(uidNum)
+}
+
+// isWritable returns true if the process may write to a file or directory.
+func isWritable(path string) bool {
+	return syscall.Access(path, accessWrite) == nil
+}
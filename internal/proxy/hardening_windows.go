@@ -0,0 +1,33 @@
+//go:build windows
+// +build windows
+
+package proxy
+
+import (
+	"errors"
+	"os"
+)
+
+// dropPrivileges isn't supported on Windows, where services run as the account the service
+// control manager starts them as.
+func dropPrivileges(spec string) error {
+	return errors.New("dropping privileges isn't supported on windows, run the service as the user instead")
+}
+
+// isWritable returns true if the process may write to a file, or to a directory that isn't
+// read-only.
+func isWritable(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		return info.Mode().Perm()&0200 != 0
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// upstreamUnhealthyHeader is set on responses to requests rejected while their upstream is
+// failing its health checks.
+const upstreamUnhealthyHeader = "X-SSO-Upstream-Unhealthy"
+
+// HealthCheckConfig maps to the health_check yaml config fields of an upstream:
+// * path - the path of the upstream probed with GET requests, which must respond with a 2xx status code
+// * interval - the time between probes, defaults to 10s
+// * timeout - the time a probe may take before it fails, defaults to 2s
+// * healthy_threshold - the consecutive successful probes after which an unhealthy upstream is healthy again, defaults to 2
+// * unhealthy_threshold - the consecutive failed probes after which the upstream is unhealthy, defaults to 3
+type HealthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	HealthyThreshold   int           `yaml:"healthy_threshold"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+}
+
+func (c *HealthCheckConfig) validate() error {
+	if !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+
+	if c.Interval == 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 2 * time.Second
+	}
+	if c.Interval < 0 || c.Timeout < 0 {
+		return fmt.Errorf("interval and timeout must not be negative")
+	}
+
+	if c.HealthyThreshold == 0 {
+		c.HealthyThreshold = 2
+	}
+	if c.UnhealthyThreshold == 0 {
+		c.UnhealthyThreshold = 3
+	}
+	if c.HealthyThreshold < 0 || c.UnhealthyThreshold < 0 {
+		return fmt.Errorf("healthy_threshold and unhealthy_threshold must not be negative")
+	}
+	return nil
+}
+
+// healthStatus is the health of an upstream, as reported by the admin API.
+type healthStatus struct {
+	Service              string    `json:"service"`
+	Cluster              string    `json:"cluster"`
+	Host                 string    `json:"host"`
+	Target               string    `json:"target"`
+	Healthy              bool      `json:"healthy"`
+	ConsecutiveSuccesses int       `json:"consecutive_successes"`
+	ConsecutiveFailures  int       `json:"consecutive_failures"`
+	LastProbe            time.Time `json:"last_probe"`
+	LastError            string    `json:"last_error,omitempty"`
+}
+
+// healthChecker actively probes the backend of an upstream, and rejects the requests to it
+// while it is unhealthy, rather than holding them until they time out. Upstreams are assumed
+// to be healthy until they fail enough consecutive probes.
+type healthChecker struct {
+	config       *HealthCheckConfig
+	service      string
+	target       *url.URL
+	client       *http.Client
+	handler      http.Handler
+	StatsdClient *statsd.Client
+
+	mu     sync.RWMutex
+	status healthStatus
+
+	stop chan struct{}
+}
+
+// newHealthCheckHandler wraps the handler of an upstream with its configured health checks.
+// Only simple routes, whose requests are all sent to the same backend, can be health checked.
+func newHealthCheckHandler(handler http.Handler, config *UpstreamConfig, statsdClient *statsd.Client) (*healthChecker, error) {
+	route, ok := config.Route.(*SimpleRoute)
+	if !ok {
+		return nil, fmt.Errorf("health_check is only supported by simple routes")
+	}
+
+	target := *route.ToURL
+	target.Path = strings.TrimSuffix(target.Path, "/") + config.HealthCheck.Path
+
+	return &healthChecker{
+		config:  config.HealthCheck,
+		service: config.Service,
+		target:  &target,
+		client: &http.Client{
+			Timeout: config.HealthCheck.Timeout,
+			// probes are sent the way requests are proxied to the upstream
+			Transport: newUpstreamTransport(config),
+			// a redirect is the response of the upstream, not the page it points to
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		handler:      handler,
+		StatsdClient: statsdClient,
+		stop:         make(chan struct{}),
+		status: healthStatus{
+			Service: config.Service,
+			Cluster: config.Cluster,
+			Host:    route.FromURL.Host,
+			Target:  target.String(),
+			Healthy: true,
+		},
+	}, nil
+}
+
+// run probes the upstream at the configured interval, until the checker is stopped.
+func (h *healthChecker) run() {
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+	for {
+		h.check()
+		select {
+		case <-ticker.C:
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// Stop stops probing the upstream, once the upstream configs it was loaded from are replaced.
+func (h *healthChecker) Stop() {
+	close(h.stop)
+}
+
+// probe sends a single health check request to the upstream.
+func (h *healthChecker) probe() error {
+	resp, err := h.client.Get(h.target.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so that the connection can be reused by the next probe
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// check probes the upstream and updates its health once a threshold is reached.
+func (h *healthChecker) check() {
+	err := h.probe()
+
+	h.mu.Lock()
+	s := &h.status
+	wasHealthy := s.Healthy
+	s.LastProbe = time.Now()
+	if err != nil {
+		s.LastError = err.Error()
+		s.ConsecutiveSuccesses = 0
+		s.ConsecutiveFailures++
+		if s.ConsecutiveFailures >= h.config.UnhealthyThreshold {
+			s.Healthy = false
+		}
+	} else {
+		s.LastError = ""
+		s.ConsecutiveFailures = 0
+		s.ConsecutiveSuccesses++
+		if s.ConsecutiveSuccesses >= h.config.HealthyThreshold {
+			s.Healthy = true
+		}
+	}
+	status := *s
+	h.mu.Unlock()
+
+	tags := []string{"service:" + status.Service, "cluster:" + status.Cluster}
+	result := "result:healthy"
+	if err != nil {
+		result = "result:unhealthy"
+	}
+	h.StatsdClient.Incr("upstream.health_check", append(tags, result), 1.0)
+	healthy := 0.0
+	if status.Healthy {
+		healthy = 1.0
+	}
+	h.StatsdClient.Gauge("upstream.healthy", healthy, tags, 1.0)
+
+	if status.Healthy != wasHealthy {
+		logger := log.NewLogEntry().WithProxyHost(status.Host)
+		if status.Healthy {
+			logger.Info(fmt.Sprintf("upstream %s is healthy again", status.Service))
+		} else {
+			logger.Warn(fmt.Sprintf("upstream %s is unhealthy after %d failed health checks: %s",
+				status.Service, status.ConsecutiveFailures, status.LastError))
+		}
+	}
+}
+
+// healthy returns true unless the upstream is failing its health checks.
+func (h *healthChecker) healthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status.Healthy
+}
+
+// Status returns the current health of the upstream.
+func (h *healthChecker) Status() healthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.status
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *healthChecker) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !h.healthy() {
+		h.StatsdClient.Incr("upstream.unhealthy_rejected", []string{"service:" + h.service}, 1.0)
+		rw.Header().Set(upstreamUnhealthyHeader, "true")
+		http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	h.handler.ServeHTTP(rw, req)
+}
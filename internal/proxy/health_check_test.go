@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestHealthCheckConfigValidate(t *testing.T) {
+	config := &HealthCheckConfig{Path: "/healthz"}
+	testutil.Ok(t, config.validate())
+	testutil.Equal(t, &HealthCheckConfig{
+		Path:               "/healthz",
+		Interval:           10 * time.Second,
+		Timeout:            2 * time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}, config)
+
+	err := (&HealthCheckConfig{Path: "healthz"}).validate()
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, "path must start with /", err.Error())
+
+	err = (&HealthCheckConfig{Path: "/healthz", UnhealthyThreshold: -1}).validate()
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, "healthy_threshold and unhealthy_threshold must not be negative", err.Error())
+}
+
+func testHealthChecker(t *testing.T, backend *httptest.Server) *healthChecker {
+	toURL, _ := url.Parse(backend.URL + "/api/")
+	config := &UpstreamConfig{
+		Service: "foo",
+		Route: &SimpleRoute{
+			FromURL: &url.URL{Scheme: "http", Host: "foo.sso.dev"},
+			ToURL:   toURL,
+		},
+		HealthCheck: &HealthCheckConfig{Path: "/healthz"},
+	}
+	testutil.Ok(t, config.HealthCheck.validate())
+
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	checker, err := newHealthCheckHandler(upstream, config, nil)
+	testutil.Ok(t, err)
+	return checker
+}
+
+func TestHealthCheckThresholds(t *testing.T) {
+	var failing int32
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		testutil.Equal(t, "/api/healthz", req.URL.Path)
+		if atomic.LoadInt32(&failing) == 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backend.Close()
+
+	checker := testHealthChecker(t, backend)
+	serve := func() *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		checker.ServeHTTP(rw, httptest.NewRequest("GET", "http://foo.sso.dev/", nil))
+		return rw
+	}
+
+	// the upstream is healthy until it fails enough consecutive probes
+	atomic.StoreInt32(&failing, 1)
+	for i := 0; i < 2; i++ {
+		checker.check()
+	}
+	testutil.Equal(t, http.StatusOK, serve().Code)
+	checker.check()
+	rw := serve()
+	testutil.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	testutil.Equal(t, "true", rw.Header().Get(upstreamUnhealthyHeader))
+	testutil.Equal(t, "unexpected status code 500", checker.Status().LastError)
+
+	// and unhealthy until it passes enough consecutive probes
+	atomic.StoreInt32(&failing, 0)
+	checker.check()
+	testutil.Equal(t, http.StatusServiceUnavailable, serve().Code)
+	checker.check()
+	testutil.Equal(t, http.StatusOK, serve().Code)
+	testutil.Equal(t, 2, checker.Status().ConsecutiveSuccesses)
+}
+
+func TestHealthCheckRequiresSimpleRoute(t *testing.T) {
+	config := &UpstreamConfig{
+		Route:       &StaticFilesRoute{},
+		HealthCheck: &HealthCheckConfig{Path: "/healthz"},
+	}
+	_, err := newHealthCheckHandler(nil, config, nil)
+	testutil.NotEqual(t, nil, err)
+}
+
+func TestAdminHandlerHealth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer backend.Close()
+
+	checker := testHealthChecker(t, backend)
+	checker.check()
+	handler := newAdminHandler(adminConfig{token: "admin-token", health: []*healthChecker{checker}})
+
+	req := httptest.NewRequest("GET", "http://localhost/admin/health", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+
+	listing := struct {
+		Upstreams []healthStatus `json:"upstreams"`
+	}{}
+	err := json.Unmarshal(rw.Body.Bytes(), &listing)
+	testutil.Assert(t, err == nil, "could not decode health listing: %s", err)
+	testutil.Equal(t, 1, len(listing.Upstreams))
+	testutil.Equal(t, "foo", listing.Upstreams[0].Service)
+	testutil.Equal(t, "foo.sso.dev", listing.Upstreams[0].Host)
+	testutil.Equal(t, backend.URL+"/api/healthz", listing.Upstreams[0].Target)
+	testutil.Assert(t, listing.Upstreams[0].Healthy, "expected the upstream to be healthy")
+}
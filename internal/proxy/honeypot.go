@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// honeypotScrubber removes credentials from the headers of honeypot hits before they're logged,
+// and in privacy mode, the identity of their users.
+var honeypotScrubber = newLogScrubber(nil, nil)
+
+// SetHoneypotPaths sets the decoy paths of every upstream, as a functional option
+func SetHoneypotPaths(paths []string) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.honeypotPaths = paths
+		return nil
+	}
+}
+
+// validateHoneypotOptions validates that honeypot paths are absolute, and don't mask the
+// proxy's own endpoints.
+func validateHoneypotOptions(o *Options, msgs []string) []string {
+	for _, path := range o.HoneypotPaths {
+		switch {
+		case !strings.HasPrefix(path, "/") || path == "/":
+			msgs = append(msgs, fmt.Sprintf("invalid honeypot path: %q must be an absolute path other than /", path))
+		case path == "/ping" || path == "/oauth2" || strings.HasPrefix(path, "/oauth2/"):
+			msgs = append(msgs, fmt.Sprintf("invalid honeypot path: %q is served by the proxy", path))
+		}
+	}
+	return msgs
+}
+
+// matchHoneypot returns the honeypot path a request path is, or is beneath, or "" if it's
+// neither, so that /wp-admin traps /wp-admin/install.php as well.
+func matchHoneypot(paths []string, path string) string {
+	for _, honeypot := range paths {
+		prefix := strings.TrimSuffix(honeypot, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return honeypot
+		}
+	}
+	return ""
+}
+
+// trapHoneypots answers requests to the decoy paths with a 404, as if the upstream didn't serve
+// them, without proxying them or authenticating them. No one has a reason to request them, so
+// each request is logged with everything known about its client, and alerted on.
+func (p *OAuthProxy) trapHoneypots(handler http.Handler) http.Handler {
+	if len(p.honeypotPaths) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		honeypot := matchHoneypot(p.honeypotPaths, req.URL.Path)
+		if honeypot == "" {
+			handler.ServeHTTP(rw, req)
+			return
+		}
+
+		// a session cookie identifies who is scanning, even though the request isn't authenticated
+		var email string
+		if session, err := p.loadSession(req); err == nil && session != nil {
+			email = session.Email
+		}
+
+		p.StatsdClient.Incr("honeypot_hit", []string{"action:honeypot", "honeypot:" + honeypot}, 1.0)
+		requestLogEntry(req).WithRequestHost(req.Host).WithRequestMethod(req.Method).WithRequestURI(
+			honeypotScrubber.uri(req.URL)).WithRequestHeaders(honeypotScrubber.headers(cloneHeader(req.Header))).WithUserAgent(
+			req.UserAgent()).WithUser(email).WithAction("honeypot").Warn(fmt.Sprintf("honeypot %s requested", honeypot))
+		p.notifyEvent(req, eventHoneypot, email, nil,
+			fmt.Sprintf("honeypot %s requested: %s %s", honeypot, req.Method, honeypotScrubber.uri(req.URL)))
+
+		http.NotFound(rw, req)
+	})
+}
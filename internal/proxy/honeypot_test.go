@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestValidateHoneypotOptions(t *testing.T) {
+	o := testOptions()
+	o.HoneypotPaths = []string{"/wp-admin", "wp-login.php", "/", "/oauth2/admin", "/ping"}
+	testutil.Equal(t, []string{
+		`invalid honeypot path: "wp-login.php" must be an absolute path other than /`,
+		`invalid honeypot path: "/" must be an absolute path other than /`,
+		`invalid honeypot path: "/oauth2/admin" is served by the proxy`,
+		`invalid honeypot path: "/ping" is served by the proxy`,
+	}, validateHoneypotOptions(o, []string{}))
+
+	o.HoneypotPaths = []string{"/wp-admin", "/.env"}
+	testutil.Equal(t, 0, len(validateHoneypotOptions(o, []string{})))
+}
+
+func TestMatchHoneypot(t *testing.T) {
+	paths := []string{"/wp-admin/", "/.env"}
+	testutil.Equal(t, "/wp-admin/", matchHoneypot(paths, "/wp-admin"))
+	testutil.Equal(t, "/wp-admin/", matchHoneypot(paths, "/wp-admin/install.php"))
+	testutil.Equal(t, "/.env", matchHoneypot(paths, "/.env"))
+	testutil.Equal(t, "", matchHoneypot(paths, "/.envoy"))
+	testutil.Equal(t, "", matchHoneypot(paths, "/wp-administrator"))
+	testutil.Equal(t, "", matchHoneypot(paths, "/"))
+}
+
+func TestTrapHoneypots(t *testing.T) {
+	events := make(chan lifecycleEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		event := lifecycleEvent{}
+		json.Unmarshal(body, &event)
+		events <- event
+	}))
+	defer server.Close()
+
+	o := testOptions()
+	o.WebhookURL = server.URL
+	o.WebhookMaxAttempts = 1
+	notifier, err := newEventNotifier(o)
+	testutil.Ok(t, err)
+
+	proxy, close := testNewOAuthProxy(t, SetHoneypotPaths([]string{"/wp-admin", "/.env"}), SetEventNotifier(notifier))
+	defer close()
+	handler := proxy.Handler()
+
+	req := httptest.NewRequest("GET", "https://localhost/wp-admin/install.php", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "scanner/1.0")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusNotFound, rw.Code)
+
+	event := <-events
+	testutil.Equal(t, eventHoneypot, event.Event)
+	testutil.Equal(t, "192.0.2.1:1234", event.RemoteAddress)
+	testutil.Equal(t, "scanner/1.0", event.UserAgent)
+	testutil.Equal(t, testSession().Email, event.Email)
+	testutil.Equal(t, "honeypot /wp-admin requested: GET /wp-admin/install.php", event.Reason)
+
+	// other paths are proxied as usual
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "https://localhost/get", nil))
+	testutil.Equal(t, http.StatusOK, rw.Code)
+}
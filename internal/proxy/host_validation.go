@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// hostLabel matches a label of a hostname, e.g. sso in sso.example.com.
+var hostLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9_-]{0,61}[a-z0-9])?$`)
+
+// hostAllowList is the hosts the proxy serves requests for: the hosts of its upstreams and
+// portal, and the hosts and domains it's configured to allow, e.g. the domain of its cluster.
+type hostAllowList struct {
+	hosts   map[string]bool
+	domains []string
+	regexps []*regexp.Regexp
+}
+
+func newHostAllowList(allowed []string) *hostAllowList {
+	l := &hostAllowList{hosts: map[string]bool{}}
+	for _, entry := range allowed {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if domain, ok := allowedDomain(entry); ok {
+			l.domains = append(l.domains, domain)
+		} else if entry != "" {
+			l.hosts[entry] = true
+		}
+	}
+	return l
+}
+
+// allowedDomain returns the domain of an allowed hosts entry that allows a domain's subdomains,
+// written as .example.com or *.example.com.
+func allowedDomain(entry string) (string, bool) {
+	for _, prefix := range []string{"*.", "."} {
+		if strings.HasPrefix(entry, prefix) {
+			return strings.TrimPrefix(entry, prefix), true
+		}
+	}
+	return "", false
+}
+
+// addRoute allows the hosts of an upstream's route.
+func (l *hostAllowList) addRoute(route interface{}) {
+	if re, ok := route.(*RewriteRoute); ok {
+		l.regexps = append(l.regexps, re.FromRegex)
+		return
+	}
+	if host := routeHost(route); host != "" {
+		l.addHost(host)
+	}
+}
+
+func (l *hostAllowList) addHost(host string) {
+	hostname, _, err := splitHost(host)
+	if err != nil {
+		hostname = host
+	}
+	l.hosts[strings.ToLower(hostname)] = true
+}
+
+// allows returns true if the hostname is allowed. Rewrite routes are matched against the host
+// as it was sent, since their regexps may include its port.
+func (l *hostAllowList) allows(hostname, host string) bool {
+	if l.hosts[hostname] {
+		return true
+	}
+	for _, domain := range l.domains {
+		if strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+	for _, re := range l.regexps {
+		if re.MatchString(host) || re.MatchString(hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHost splits a Host header into its lowercase hostname and port, which may be empty, and
+// returns an error if either is malformed.
+func splitHost(host string) (string, string, error) {
+	hostname, port := host, ""
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+		var err error
+		hostname, port, err = net.SplitHostPort(host)
+		if err != nil {
+			return "", "", err
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return "", "", fmt.Errorf("invalid port %q", port)
+		}
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(strings.Trim(hostname, "[]"), "."))
+	if hostname == "" {
+		return "", "", fmt.Errorf("missing hostname")
+	}
+	if net.ParseIP(hostname) != nil {
+		return hostname, port, nil
+	}
+	if len(hostname) > 253 {
+		return "", "", fmt.Errorf("hostname is longer than 253 characters")
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if !hostLabel.MatchString(label) {
+			return "", "", fmt.Errorf("invalid hostname %q", hostname)
+		}
+	}
+	return hostname, port, nil
+}
+
+// hostValidator rejects requests whose Host header is malformed or isn't allowed before they're
+// routed or authenticated, so that a forged Host can't reach the default route or be reflected
+// in redirects and cookies. Requests made over TLS must also have been sent to the server name
+// of their Host.
+type hostValidator struct {
+	handler   http.Handler
+	allowList *hostAllowList
+
+	StatsdClient *statsd.Client
+}
+
+func newHostValidator(handler http.Handler, allowList *hostAllowList, statsdClient *statsd.Client) *hostValidator {
+	return &hostValidator{
+		handler:      handler,
+		allowList:    allowList,
+		StatsdClient: statsdClient,
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (v *hostValidator) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	reason, err := v.validate(req)
+	if err == nil {
+		v.handler.ServeHTTP(rw, req)
+		return
+	}
+
+	v.StatsdClient.Incr("invalid_host_rejected", []string{"reason:" + reason}, 1.0)
+	log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req)).WithRequestHost(req.Host).WithError(err).Warn(
+		"rejected request with invalid host")
+
+	if reason == "malformed" {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	http.Error(rw, http.StatusText(http.StatusMisdirectedRequest), http.StatusMisdirectedRequest)
+}
+
+// validate returns the reason a request's host is rejected, and why, or a nil error if it's
+// valid.
+func (v *hostValidator) validate(req *http.Request) (string, error) {
+	hostname, _, err := splitHost(req.Host)
+	if err != nil {
+		return "malformed", fmt.Errorf("malformed host %q: %s", req.Host, err)
+	}
+	if req.TLS != nil && req.TLS.ServerName != "" && !strings.EqualFold(req.TLS.ServerName, hostname) {
+		return "sni_mismatch", fmt.Errorf("host %q doesn't match server name %q", req.Host, req.TLS.ServerName)
+	}
+	if !v.allowList.allows(hostname, req.Host) {
+		return "unknown", fmt.Errorf("host %q isn't served by the proxy", req.Host)
+	}
+	return "", nil
+}
+
+func validateAllowedHosts(o *Options, msgs []string) []string {
+	for _, entry := range o.AllowedHosts {
+		entry = strings.TrimSpace(entry)
+		host := entry
+		if domain, ok := allowedDomain(strings.ToLower(entry)); ok {
+			host = domain
+		}
+		if net.ParseIP(host) != nil {
+			continue
+		}
+		if _, _, err := splitHost(host); err != nil || strings.Contains(host, ":") {
+			msgs = append(msgs, fmt.Sprintf("invalid value for allowed-hosts: %q is not a host or domain", entry))
+		}
+	}
+	return msgs
+}
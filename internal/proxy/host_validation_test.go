@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestSplitHost(t *testing.T) {
+	testCases := []struct {
+		host         string
+		wantHostname string
+		wantPort     string
+		wantErr      bool
+	}{
+		{host: "foo.sso.dev", wantHostname: "foo.sso.dev"},
+		{host: "Foo.SSO.dev", wantHostname: "foo.sso.dev"},
+		{host: "foo.sso.dev.", wantHostname: "foo.sso.dev"},
+		{host: "foo.sso.dev:8443", wantHostname: "foo.sso.dev", wantPort: "8443"},
+		{host: "127.0.0.1:80", wantHostname: "127.0.0.1", wantPort: "80"},
+		{host: "[::1]:443", wantHostname: "::1", wantPort: "443"},
+		{host: "[::1]", wantHostname: "::1"},
+		{host: "", wantErr: true},
+		{host: ":443", wantErr: true},
+		{host: "foo.sso.dev:http", wantErr: true},
+		{host: "foo.sso.dev:99999", wantErr: true},
+		{host: "foo..sso.dev", wantErr: true},
+		{host: "-foo.sso.dev", wantErr: true},
+		{host: "foo.sso.dev/evil", wantErr: true},
+		{host: "foo.sso.dev@evil.com", wantErr: true},
+		{host: "foo.sso.dev:443:443", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.host, func(t *testing.T) {
+			hostname, port, err := splitHost(tc.host)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equal(t, tc.wantHostname, hostname)
+			testutil.Equal(t, tc.wantPort, port)
+		})
+	}
+}
+
+func TestHostValidator(t *testing.T) {
+	allowList := newHostAllowList([]string{"sso-proxy.internal", ".sso.dev", "*.example.com"})
+	allowList.addRoute(&SimpleRoute{FromURL: &url.URL{Scheme: "https", Host: "wiki.dev"}})
+	allowList.addRoute(&RewriteRoute{FromRegex: regexp.MustCompile(`^(.*)\.rewrite\.dev$`)})
+	allowList.addHost("portal.dev:443")
+
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	validator := newHostValidator(upstream, allowList, nil)
+
+	testCases := []struct {
+		name       string
+		host       string
+		serverName string
+		wantCode   int
+	}{
+		{name: "upstream host", host: "wiki.dev", wantCode: http.StatusOK},
+		{name: "upstream host with port", host: "WIKI.dev:443", wantCode: http.StatusOK},
+		{name: "rewrite route host", host: "foo.rewrite.dev", wantCode: http.StatusOK},
+		{name: "portal host", host: "portal.dev", wantCode: http.StatusOK},
+		{name: "allowed host", host: "sso-proxy.internal", wantCode: http.StatusOK},
+		{name: "allowed domain", host: "foo.sso.dev", wantCode: http.StatusOK},
+		{name: "allowed wildcard domain", host: "foo.example.com", wantCode: http.StatusOK},
+		{name: "allowed domain itself is not a subdomain", host: "sso.dev", wantCode: http.StatusMisdirectedRequest},
+		{name: "unknown host", host: "evil.com", wantCode: http.StatusMisdirectedRequest},
+		{name: "unknown suffix", host: "wiki.dev.evil.com", wantCode: http.StatusMisdirectedRequest},
+		{name: "malformed host", host: "wiki.dev/evil", wantCode: http.StatusBadRequest},
+		{name: "empty host", host: "", wantCode: http.StatusBadRequest},
+		{name: "matching server name", host: "wiki.dev", serverName: "wiki.dev", wantCode: http.StatusOK},
+		{name: "mismatched server name", host: "wiki.dev", serverName: "foo.sso.dev", wantCode: http.StatusMisdirectedRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// requests made with an https URL have the server name of its host
+			req := httptest.NewRequest("GET", "http://wiki.dev/", nil)
+			req.Host = tc.host
+			if tc.serverName != "" {
+				req.TLS = &tls.ConnectionState{ServerName: tc.serverName}
+			}
+			rw := httptest.NewRecorder()
+			validator.ServeHTTP(rw, req)
+			testutil.Equal(t, tc.wantCode, rw.Code)
+		})
+	}
+}
+
+func TestHostValidation(t *testing.T) {
+	opts := testOptions()
+	opts.HostValidation = true
+	opts.AllowedHosts = []string{".internal.dev"}
+	testutil.Ok(t, opts.Validate())
+
+	ssoProxy, err := New(opts)
+	testutil.Ok(t, err)
+
+	testCases := []struct {
+		name     string
+		host     string
+		wantCode int
+	}{
+		{name: "health check isn't validated", host: "10.0.0.1", wantCode: http.StatusOK},
+		{name: "unknown host", host: "evil.com", wantCode: http.StatusMisdirectedRequest},
+		{name: "malformed host", host: "foo.sso.dev:evil", wantCode: http.StatusBadRequest},
+		{name: "allowed host with no upstream is served the default route", host: "foo.internal.dev", wantCode: http.StatusMisdirectedRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := "/"
+			if tc.wantCode == http.StatusOK {
+				path = "/ping"
+			}
+			req := httptest.NewRequest("GET", "http://foo.sso.dev"+path, nil)
+			req.Host = tc.host
+			rw := httptest.NewRecorder()
+			ssoProxy.ServeHTTP(rw, req)
+			testutil.Equal(t, tc.wantCode, rw.Code)
+		})
+	}
+
+	// requests to an upstream are passed on to be authenticated
+	rw := httptest.NewRecorder()
+	ssoProxy.ServeHTTP(rw, httptest.NewRequest("GET", "http://foo.sso.dev/", nil))
+	testutil.NotEqual(t, http.StatusMisdirectedRequest, rw.Code)
+	testutil.NotEqual(t, http.StatusBadRequest, rw.Code)
+}
+
+func TestHostValidationForwardAuth(t *testing.T) {
+	opts := testOptions()
+	opts.HostValidation = true
+	opts.ForwardAuth = true
+	opts.AllowedHosts = []string{"sso-proxy.internal"}
+	testutil.Ok(t, opts.Validate())
+
+	ssoProxy, err := New(opts)
+	testutil.Ok(t, err)
+
+	forwardAuth := func(host string) int {
+		req := httptest.NewRequest("GET", "http://sso-proxy.internal"+forwardAuthPath, nil)
+		req.Host = host
+		req.Header.Set("X-Forwarded-Host", "foo.sso.dev")
+		req.Header.Set("X-Forwarded-Uri", "/")
+		rw := httptest.NewRecorder()
+		ssoProxy.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	// forward auth requests are validated by the host they're sent to, not the host they describe
+	testutil.Equal(t, http.StatusMisdirectedRequest, forwardAuth("evil.com"))
+	testutil.NotEqual(t, http.StatusMisdirectedRequest, forwardAuth("sso-proxy.internal"))
+}
+
+func TestValidateAllowedHosts(t *testing.T) {
+	o := testOptions()
+	o.AllowedHosts = []string{"sso-proxy.internal", ".sso.dev", "*.example.com", "10.0.0.1", "bad host", "*.", "foo.dev:443"}
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		`invalid value for allowed-hosts: "bad host" is not a host or domain`,
+		`invalid value for allowed-hosts: "*." is not a host or domain`,
+		`invalid value for allowed-hosts: "foo.dev:443" is not a host or domain`,
+	}), err.Error())
+}
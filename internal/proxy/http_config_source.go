@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+const (
+	// configSignatureHeader holds the base64 encoded signature of an upstream configs file
+	// fetched over https: an RSA PKCS #1 v1.5 signature of its SHA-256 digest, or an Ed25519
+	// signature of the file itself.
+	configSignatureHeader = "X-SSO-Config-Signature"
+
+	// maxRemoteConfigsSize is the size of the largest upstream configs file fetched over https.
+	maxRemoteConfigsSize = 10 << 20
+)
+
+// isUpstreamConfigsURL returns true if the upstream configs file is fetched from a url, rather
+// than read from disk.
+func isUpstreamConfigsURL(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// httpConfigSource fetches the upstream configs file from a central service over https, and
+// polls it for changes, which are applied. Polls are conditional on the ETag of the last
+// response, so that unchanged configs aren't downloaded again.
+type httpConfigSource struct {
+	url       string
+	interval  time.Duration
+	publicKey crypto.PublicKey
+	client    *http.Client
+
+	StatsdClient *statsd.Client
+
+	etag string
+}
+
+// newHTTPConfigSource returns the httpConfigSource of the options.
+func newHTTPConfigSource(opts *Options) (*httpConfigSource, error) {
+	u, err := url.Parse(opts.UpstreamConfigsFile)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, errors.New("upstream configs must be fetched from an https url")
+	}
+	if opts.UpstreamConfigsPollInterval <= 0 {
+		return nil, errors.New("invalid value for upstream-configs-poll-interval: must be positive")
+	}
+
+	var publicKey crypto.PublicKey
+	if opts.UpstreamConfigsPublicKey != "" {
+		publicKey, err = loadConfigPublicKey(opts.UpstreamConfigsPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading upstream-configs-public-key: %s", err)
+		}
+	}
+
+	return &httpConfigSource{
+		url:       opts.UpstreamConfigsFile,
+		interval:  opts.UpstreamConfigsPollInterval,
+		publicKey: publicKey,
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		StatsdClient: opts.StatsdClient,
+	}, nil
+}
+
+// loadConfigPublicKey loads the PEM encoded RSA or Ed25519 public key upstream configs are
+// signed with.
+func loadConfigPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// fetch fetches the upstream configs file, unless it's unchanged since the last fetch, in which
+// case it returns nil. The contents of a changed file are only returned once their signature is
+// verified, if a public key is configured.
+func (s *httpConfigSource) fetch() ([]byte, error) {
+	req, err := http.NewRequest("GET", s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > maxRemoteConfigsSize {
+		return nil, fmt.Errorf("upstream configs are larger than %d bytes", maxRemoteConfigsSize)
+	}
+	if err := s.verify(raw, resp.Header.Get(configSignatureHeader)); err != nil {
+		return nil, err
+	}
+
+	s.etag = resp.Header.Get("ETag")
+	return raw, nil
+}
+
+// verify verifies the signature of the upstream configs, if a public key is configured.
+func (s *httpConfigSource) verify(raw []byte, signature string) error {
+	if s.publicKey == nil {
+		return nil
+	}
+	if signature == "" {
+		return fmt.Errorf("missing %s header", configSignatureHeader)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %s", configSignatureHeader, err)
+	}
+
+	switch key := s.publicKey.(type) {
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(raw)
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, raw, sig) {
+			err = errors.New("verification error")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid signature of upstream configs: %s", err)
+	}
+	return nil
+}
+
+// run polls the upstream configs file at the configured interval, forever, and applies its
+// contents whenever they change. Configs that fail to apply are reported, and the running
+// configs are kept until a later version fixes them.
+func (s *httpConfigSource) run(apply func(raw []byte) error) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.poll(apply)
+	}
+}
+
+// poll fetches the upstream configs file once, and applies it if it changed.
+func (s *httpConfigSource) poll(apply func(raw []byte) error) {
+	logger := log.NewLogEntry()
+
+	raw, err := s.fetch()
+	if err != nil {
+		s.StatsdClient.Incr("config.http_poll", []string{"result:error"}, 1.0)
+		logger.Error(err, fmt.Sprintf("error fetching upstream configs from %s; the running configs are unchanged", s.url))
+		return
+	}
+	if raw == nil {
+		s.StatsdClient.Incr("config.http_poll", []string{"result:unchanged"}, 1.0)
+		return
+	}
+
+	if err := apply(raw); err != nil {
+		// the ETag of the configs is kept, so that they aren't applied again until they change
+		s.StatsdClient.Incr("config.http_poll", []string{"result:invalid"}, 1.0)
+		logger.Error(err, fmt.Sprintf("error applying upstream configs from %s; the running configs are unchanged", s.url))
+		return
+	}
+	s.StatsdClient.Incr("config.http_poll", []string{"result:applied"}, 1.0)
+	logger.Info(fmt.Sprintf("applied upstream configs from %s", s.url))
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPConfigSource(t *testing.T) {
+	testCases := []struct {
+		name     string
+		url      string
+		interval time.Duration
+		err      bool
+	}{
+		{
+			name:     "https url",
+			url:      "https://configs.example.com/sso/upstream_configs.yml",
+			interval: time.Minute,
+		},
+		{
+			name:     "http url",
+			url:      "http://configs.example.com/sso/upstream_configs.yml",
+			interval: time.Minute,
+			err:      true,
+		},
+		{
+			name: "no poll interval",
+			url:  "https://configs.example.com/sso/upstream_configs.yml",
+			err:  true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := NewOptions()
+			opts.UpstreamConfigsFile = tc.url
+			opts.UpstreamConfigsPollInterval = tc.interval
+			_, err := newHTTPConfigSource(opts)
+			if tc.err && err == nil {
+				t.Errorf("expected an error")
+			} else if !tc.err && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPConfigSourceFetch(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %v", err)
+	}
+
+	configs := []byte("- service: foo\n")
+	etag := `"v1"`
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, configs))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("If-None-Match") == etag {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+		rw.Header().Set("ETag", etag)
+		rw.Header().Set(configSignatureHeader, signature)
+		rw.Write(configs)
+	}))
+	defer server.Close()
+
+	source := &httpConfigSource{
+		url:       server.URL,
+		interval:  time.Minute,
+		publicKey: publicKey,
+		client:    server.Client(),
+	}
+
+	raw, err := source.fetch()
+	if err != nil {
+		t.Fatalf("unexpected error fetching configs: %v", err)
+	}
+	if string(raw) != string(configs) {
+		t.Errorf("unexpected configs %q", raw)
+	}
+
+	// unchanged configs aren't fetched again
+	raw, err = source.fetch()
+	if err != nil || raw != nil {
+		t.Errorf("expected unchanged configs, got %q, %v", raw, err)
+	}
+
+	// changed configs must be signed by the key
+	configs = []byte("- service: bar\n")
+	etag = `"v2"`
+	if _, err := source.fetch(); err == nil {
+		t.Errorf("expected an error fetching configs with an invalid signature")
+	}
+	signature = ""
+	if _, err := source.fetch(); err == nil {
+		t.Errorf("expected an error fetching configs without a signature")
+	}
+	signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, configs))
+	raw, err = source.fetch()
+	if err != nil {
+		t.Fatalf("unexpected error fetching configs: %v", err)
+	}
+	if string(raw) != string(configs) {
+		t.Errorf("unexpected configs %q", raw)
+	}
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/pkg/middleware"
+)
+
+// Impersonate lets members of the configured impersonation groups act as another user on
+// the current host. A GET request renders a form to choose the user, and a POST request
+// with an `email` form value starts the impersonation.
+func (p *OAuthProxy) Impersonate(rw http.ResponseWriter, req *http.Request) {
+	logger := requestLogEntry(req)
+	tags := []string{"action:impersonate"}
+
+	if len(p.impersonationGroups) == 0 {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "GET, POST")
+		p.ErrorPage(rw, req, http.StatusMethodNotAllowed, "Method Not Allowed", "Method Not Allowed")
+		return
+	}
+
+	if req.Method == http.MethodPost && !isSameOrigin(req) {
+		tags = append(tags, "error:cross_origin_request")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Cross-origin requests are not allowed")
+		return
+	}
+
+	session, err := p.authenticate(rw, req)
+	if err != nil {
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+
+	_, isAdmin, err := p.provider.ValidateGroup(session.Email, p.impersonationGroups, session.AccessToken)
+	if err != nil {
+		tags = append(tags, "error:validate_group_error")
+		p.StatsdClient.Incr("provider_error", tags, 1.0)
+		logger.WithUser(session.Email).Error(err, "error validating impersonation groups")
+		p.ErrorPage(rw, req, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred")
+		return
+	}
+	if !isAdmin {
+		tags = append(tags, "error:user_unauthorized")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		logger.WithUser(session.Email).Info("permission denied: not a member of an impersonation group")
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "You're not authorized to impersonate other users")
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		impersonating := ""
+		if session.ImpersonatingOn(req.Host) {
+			impersonating = session.Impersonation.Email
+		}
+		p.templates.ExecuteTemplate(rw, "impersonate.html", struct {
+			Host          string
+			Impersonating string
+		}{
+			Host:          req.Host,
+			Impersonating: impersonating,
+		})
+		return
+	}
+
+	email := strings.TrimSpace(req.PostFormValue("email"))
+	if email == "" {
+		p.ErrorPage(rw, req, http.StatusBadRequest, "Bad Request", "Missing email of the user to impersonate")
+		return
+	}
+	if strings.EqualFold(email, session.Email) {
+		p.ErrorPage(rw, req, http.StatusBadRequest, "Bad Request", "You can't impersonate yourself")
+		return
+	}
+
+	// The impersonated user's groups are looked up once, for the groups this upstream
+	// allows, so the upstream sees the same X-Forwarded-Groups it would for that user.
+	var groups []string
+	if len(p.upstreamConfig.AllowedGroups) != 0 {
+		groups, err = p.provider.UserGroups(email, p.upstreamConfig.AllowedGroups, session.AccessToken)
+		if err != nil {
+			tags = append(tags, "error:user_groups_error")
+			p.StatsdClient.Incr("provider_error", tags, 1.0)
+			logger.WithUser(session.Email).WithImpersonatedUser(email).Error(
+				err, "error fetching groups of impersonated user")
+			p.ErrorPage(rw, req, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred")
+			return
+		}
+	}
+
+	session.Impersonation = &sessions.Impersonation{
+		Email:    email,
+		Groups:   groups,
+		Host:     req.Host,
+		Deadline: sessions.ExtendDeadline(p.impersonationTTL),
+	}
+	err = p.sessionStore.SaveSession(rw, req, session)
+	if err != nil {
+		tags = append(tags, "error:save_session_error")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		logger.WithUser(session.Email).Error(err, "error saving session")
+		p.ErrorPage(rw, req, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred")
+		return
+	}
+
+	p.StatsdClient.Incr("impersonation_started", tags, 1.0)
+	logger.WithUser(session.Email).WithImpersonatedUser(email).WithRequestHost(req.Host).WithInGroups(groups).Info(
+		"impersonation started")
+	http.Redirect(rw, req, "/", http.StatusSeeOther)
+}
+
+// StopImpersonating ends an impersonation started with Impersonate.
+func (p *OAuthProxy) StopImpersonating(rw http.ResponseWriter, req *http.Request) {
+	logger := requestLogEntry(req)
+	tags := []string{"action:stop_impersonating"}
+
+	if len(p.impersonationGroups) == 0 {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		p.ErrorPage(rw, req, http.StatusMethodNotAllowed, "Method Not Allowed", "Method Not Allowed")
+		return
+	}
+
+	if !isSameOrigin(req) {
+		tags = append(tags, "error:cross_origin_request")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Cross-origin requests are not allowed")
+		return
+	}
+
+	session, err := p.authenticate(rw, req)
+	if err != nil {
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+
+	if session.Impersonation != nil {
+		impersonated := session.Impersonation.Email
+		session.Impersonation = nil
+		err = p.sessionStore.SaveSession(rw, req, session)
+		if err != nil {
+			tags = append(tags, "error:save_session_error")
+			p.StatsdClient.Incr("application_error", tags, 1.0)
+			logger.WithUser(session.Email).Error(err, "error saving session")
+			p.ErrorPage(rw, req, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred")
+			return
+		}
+		logger.WithUser(session.Email).WithImpersonatedUser(impersonated).WithRequestHost(req.Host).Info(
+			"impersonation stopped")
+	}
+
+	http.Redirect(rw, req, "/", http.StatusSeeOther)
+}
+
+// setImpersonationHeaders identifies the impersonated user to the upstream, and the
+// administrator acting as them in the X-Impersonated-By header.
+func (p *OAuthProxy) setImpersonationHeaders(req *http.Request, session *sessions.SessionState) {
+	impersonation := session.Impersonation
+
+	req.Header.Set("X-Forwarded-User", strings.Split(impersonation.Email, "@")[0])
+	req.Header.Set("X-Forwarded-Email", impersonation.Email)
+	req.Header.Set("X-Forwarded-Groups", strings.Join(impersonation.Groups, ","))
+	req.Header.Set("X-Impersonated-By", session.Email)
+	// the administrator's access token must never be passed off as the impersonated user's
+	req.Header.Del("X-Forwarded-Access-Token")
+	setForwardedIdentity(req, middleware.Identity{
+		User:   strings.Split(impersonation.Email, "@")[0],
+		Email:  impersonation.Email,
+		Groups: impersonation.Groups,
+	})
+}
+
+// isSameOrigin returns true if a request was made from a page served by the same host,
+// protecting state changing endpoints from cross site request forgery.
+func isSameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		origin = req.Header.Get("Referer")
+	}
+	if origin == "" {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == req.Host
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/proxy/providers"
+)
+
+func setImpersonationGroups(groups []string) func(*OAuthProxy) error {
+	return func(p *OAuthProxy) error {
+		p.impersonationGroups = groups
+		p.impersonationTTL = time.Hour
+		return nil
+	}
+}
+
+func testImpersonationProvider(isAdmin bool) *providers.TestProvider {
+	providerURL, _ := url.Parse("http://localhost/")
+	provider := providers.NewTestProvider(providerURL, "")
+	provider.ValidateGroupsFunc = func(string, []string, string) ([]string, bool, error) {
+		return nil, isAdmin, nil
+	}
+	provider.UserGroupsFunc = func(string, []string, string) ([]string, error) {
+		return []string{"foo"}, nil
+	}
+	return provider
+}
+
+func TestImpersonate(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		impersonationGroups   []string
+		method                string
+		origin                string
+		email                 string
+		isAdmin               bool
+		expectedCode          int
+		expectedImpersonation *sessions.Impersonation
+	}{
+		{
+			name:         "disabled without impersonation groups",
+			method:       "POST",
+			origin:       "https://localhost",
+			email:        "user@example.com",
+			isAdmin:      true,
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:                "renders form for admins",
+			impersonationGroups: []string{"admins"},
+			method:              "GET",
+			isAdmin:             true,
+			expectedCode:        http.StatusOK,
+		},
+		{
+			name:                "rejects cross origin requests",
+			impersonationGroups: []string{"admins"},
+			method:              "POST",
+			origin:              "https://evil.example.com",
+			email:               "user@example.com",
+			isAdmin:             true,
+			expectedCode:        http.StatusForbidden,
+		},
+		{
+			name:                "rejects non admins",
+			impersonationGroups: []string{"admins"},
+			method:              "POST",
+			origin:              "https://localhost",
+			email:               "user@example.com",
+			expectedCode:        http.StatusForbidden,
+		},
+		{
+			name:                "rejects missing email",
+			impersonationGroups: []string{"admins"},
+			method:              "POST",
+			origin:              "https://localhost",
+			isAdmin:             true,
+			expectedCode:        http.StatusBadRequest,
+		},
+		{
+			name:                "starts impersonation",
+			impersonationGroups: []string{"admins"},
+			method:              "POST",
+			origin:              "https://localhost",
+			email:               "user@example.com",
+			isAdmin:             true,
+			expectedCode:        http.StatusSeeOther,
+			expectedImpersonation: &sessions.Impersonation{
+				Email:  "user@example.com",
+				Groups: []string{"foo"},
+				Host:   "localhost",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sessionStore := &sessions.MockSessionStore{Session: testSession()}
+			proxy, close := testNewOAuthProxy(t,
+				SetProvider(testImpersonationProvider(tc.isAdmin)),
+				setSessionStore(sessionStore),
+				setImpersonationGroups(tc.impersonationGroups),
+			)
+			defer close()
+
+			form := url.Values{}
+			if tc.email != "" {
+				form.Set("email", tc.email)
+			}
+			req := httptest.NewRequest(tc.method, "https://localhost/oauth2/impersonate", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			rw := httptest.NewRecorder()
+
+			proxy.Handler().ServeHTTP(rw, req)
+
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedImpersonation == nil {
+				testutil.Assert(t, sessionStore.Session.Impersonation == nil, "expected no impersonation")
+				return
+			}
+			impersonation := sessionStore.Session.Impersonation
+			testutil.Equal(t, tc.expectedImpersonation.Email, impersonation.Email)
+			testutil.Equal(t, tc.expectedImpersonation.Groups, impersonation.Groups)
+			testutil.Equal(t, tc.expectedImpersonation.Host, impersonation.Host)
+			testutil.Assert(t, impersonation.Deadline.After(time.Now()), "expected impersonation deadline in the future")
+		})
+	}
+}
+
+func TestStopImpersonating(t *testing.T) {
+	session := testSession()
+	session.Impersonation = &sessions.Impersonation{
+		Email:    "user@example.com",
+		Host:     "localhost",
+		Deadline: time.Now().Add(time.Hour),
+	}
+	sessionStore := &sessions.MockSessionStore{Session: session}
+	proxy, close := testNewOAuthProxy(t,
+		setSessionStore(sessionStore),
+		setImpersonationGroups([]string{"admins"}),
+	)
+	defer close()
+
+	req := httptest.NewRequest("POST", "https://localhost/oauth2/impersonate/stop", nil)
+	req.Header.Set("Referer", "https://localhost/some/page")
+	rw := httptest.NewRecorder()
+
+	proxy.Handler().ServeHTTP(rw, req)
+
+	testutil.Equal(t, http.StatusSeeOther, rw.Code)
+	testutil.Assert(t, sessionStore.Session.Impersonation == nil, "expected impersonation to be stopped")
+}
+
+func TestAuthenticateWhileImpersonating(t *testing.T) {
+	testCases := []struct {
+		name            string
+		host            string
+		deadline        time.Time
+		expectedHeaders map[string]string
+		expectStopped   bool
+	}{
+		{
+			name:     "impersonated user is sent upstream",
+			host:     "localhost",
+			deadline: time.Now().Add(time.Hour),
+			expectedHeaders: map[string]string{
+				"X-Forwarded-User":   "user",
+				"X-Forwarded-Email":  "user@example.com",
+				"X-Forwarded-Groups": "foo",
+				"X-Impersonated-By":  "michael.bland@gsa.gov",
+			},
+		},
+		{
+			name:     "impersonation only applies to its host",
+			host:     "other.localhost",
+			deadline: time.Now().Add(time.Hour),
+			expectedHeaders: map[string]string{
+				"X-Forwarded-Email": "michael.bland@gsa.gov",
+				"X-Impersonated-By": "",
+			},
+		},
+		{
+			name:     "expired impersonation is ended",
+			host:     "localhost",
+			deadline: time.Now().Add(-1 * time.Minute),
+			expectedHeaders: map[string]string{
+				"X-Forwarded-Email": "michael.bland@gsa.gov",
+				"X-Impersonated-By": "",
+			},
+			expectStopped: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := testSession()
+			session.Impersonation = &sessions.Impersonation{
+				Email:    "user@example.com",
+				Groups:   []string{"foo"},
+				Host:     "localhost",
+				Deadline: tc.deadline,
+			}
+			sessionStore := &sessions.MockSessionStore{Session: session}
+			proxy, close := testNewOAuthProxy(t, setSessionStore(sessionStore))
+			defer close()
+
+			req := httptest.NewRequest("GET", "https://"+tc.host+"/", nil)
+			// impersonation headers sent by clients must never reach the upstream
+			req.Header.Set("X-Impersonated-By", "spoofed@example.com")
+			rw := httptest.NewRecorder()
+
+			err := proxy.Authenticate(rw, req)
+			testutil.Ok(t, err)
+
+			for key, val := range tc.expectedHeaders {
+				testutil.Equal(t, val, req.Header.Get(key))
+			}
+			testutil.Equal(t, tc.expectStopped, sessionStore.Session.Impersonation == nil)
+		})
+	}
+}
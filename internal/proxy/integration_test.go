@@ -0,0 +1,172 @@
+//go:build integration
+// +build integration
+
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/proxy/providertest"
+)
+
+// integrationProviders are the provider slugs the integration tests sign in through, one for
+// each type of provider the authenticator supports.
+var integrationProviders = []string{"google", "okta", "cognito"}
+
+// integrationHost is the host of the upstream the integration tests proxy to, which the
+// client of an integrationEnv resolves to the proxy.
+const integrationHost = "echo.sso.dev"
+
+// integrationEnv is a proxy running in front of an echo upstream, signing users in through a
+// simulated authenticator, and a browser-like client of it.
+type integrationEnv struct {
+	sim      *providertest.Server
+	upstream *httptest.Server
+	proxy    *httptest.Server
+	client   *http.Client
+	dir      string
+}
+
+func newIntegrationEnv(t *testing.T, slug string) *integrationEnv {
+	env := &integrationEnv{}
+
+	env.sim = providertest.NewServer(slug, "client", "secret")
+	env.sim.TokenTTL = time.Second
+	env.sim.AddUser("jane@example.com", "engineering")
+	env.sim.SignInAs("jane@example.com")
+
+	env.upstream = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(map[string]string{
+			"path":  req.URL.Path,
+			"email": req.Header.Get("X-Forwarded-Email"),
+		})
+	}))
+	upstreamURL, err := url.Parse(env.upstream.URL)
+	testutil.Ok(t, err)
+
+	env.dir, err = ioutil.TempDir("", "sso-integration")
+	testutil.Ok(t, err)
+	upstreamConfigs := filepath.Join(env.dir, "upstream_configs.yml")
+	err = ioutil.WriteFile(upstreamConfigs, []byte(fmt.Sprintf(`
+- service: echo
+  default:
+    from: echo.{{cluster}}.{{root_domain}}
+    to: %s
+    options:
+      allowed_groups: [engineering]
+`, upstreamURL.Host)), 0600)
+	testutil.Ok(t, err)
+
+	opts := testOptions()
+	opts.UpstreamConfigsFile = upstreamConfigs
+	opts.ClientID = env.sim.ClientID
+	opts.ClientSecret = env.sim.ClientSecret
+	opts.DefaultProviderSlug = slug
+	opts.ProviderURLString = env.sim.URL
+	opts.CookieSecure = false
+	testutil.Ok(t, opts.Validate())
+
+	ssoProxy, err := New(opts)
+	testutil.Ok(t, err)
+	env.proxy = httptest.NewServer(NewLoggingHandler(ioutil.Discard, ssoProxy, true, opts.StatsdClient))
+
+	jar, err := cookiejar.New(nil)
+	testutil.Ok(t, err)
+	proxyAddr := env.proxy.Listener.Addr().String()
+	env.client = &http.Client{
+		Jar: jar,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if strings.HasPrefix(addr, integrationHost+":") {
+					addr = proxyAddr
+				}
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+	return env
+}
+
+func (env *integrationEnv) Close() {
+	env.proxy.Close()
+	env.upstream.Close()
+	env.sim.Close()
+	os.RemoveAll(env.dir)
+}
+
+// get requests a path of the upstream, following redirects, and returns the status code of
+// the final response and the email the upstream saw, if it was proxied to.
+func (env *integrationEnv) get(t *testing.T, path string) (int, string) {
+	resp, err := env.client.Get(fmt.Sprintf("http://%s%s", integrationHost, path))
+	testutil.Ok(t, err)
+	defer resp.Body.Close()
+
+	echo := map[string]string{}
+	if resp.Header.Get("Content-Type") == "application/json" {
+		testutil.Ok(t, json.NewDecoder(resp.Body).Decode(&echo))
+		testutil.Equal(t, path, echo["path"])
+	}
+	return resp.StatusCode, echo["email"]
+}
+
+// expireTokens waits until the access tokens issued by the simulator expire, so that the next
+// request refreshes its session.
+func (env *integrationEnv) expireTokens() {
+	time.Sleep(env.sim.TokenTTL + 100*time.Millisecond)
+}
+
+func TestIntegration(t *testing.T) {
+	for _, slug := range integrationProviders {
+		t.Run(slug, func(t *testing.T) {
+			env := newIntegrationEnv(t, slug)
+			defer env.Close()
+
+			// signing in redirects through the authenticator, and back to the upstream
+			status, email := env.get(t, "/hello")
+			testutil.Equal(t, http.StatusOK, status)
+			testutil.Equal(t, "jane@example.com", email)
+			testutil.Equal(t, 1, len(env.sim.InteractionsWith(providertest.SignIn)))
+			testutil.Equal(t, 1, len(env.sim.InteractionsWith(providertest.Redeem)))
+
+			// signed in requests are proxied with the session cookie alone
+			status, email = env.get(t, "/again")
+			testutil.Equal(t, http.StatusOK, status)
+			testutil.Equal(t, "jane@example.com", email)
+			testutil.Equal(t, 1, len(env.sim.InteractionsWith(providertest.SignIn)))
+
+			// sessions are refreshed once their access tokens expire
+			env.expireTokens()
+			status, _ = env.get(t, "/refreshed")
+			testutil.Equal(t, http.StatusOK, status)
+			testutil.Equal(t, 1, len(env.sim.InteractionsWith(providertest.Refresh)))
+
+			// users removed from the allowed groups are denied on their next refresh
+			env.sim.SetGroups("jane@example.com", "sales")
+			env.expireTokens()
+			status, _ = env.get(t, "/denied")
+			testutil.Equal(t, http.StatusForbidden, status)
+
+			// revoked tokens can't be refreshed
+			env.sim.SetGroups("jane@example.com", "engineering")
+			env.sim.RevokeTokens("jane@example.com")
+			env.expireTokens()
+			status, _ = env.get(t, "/revoked")
+			testutil.Equal(t, http.StatusUnauthorized, status)
+		})
+	}
+}
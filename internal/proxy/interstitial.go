@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+)
+
+const acknowledgePath = "/oauth2/acknowledge"
+
+// ErrAcknowledgementRequired is returned when a user must acknowledge an upstream's
+// interstitial page before their request can proceed.
+var ErrAcknowledgementRequired = errors.New("interstitial acknowledgement required")
+
+// requiresAcknowledgement returns true if the upstream has an interstitial page that the
+// session has not yet acknowledged.
+func (p *OAuthProxy) requiresAcknowledgement(session *sessions.SessionState, req *http.Request) bool {
+	return p.upstreamConfig.Interstitial != "" && !session.AcknowledgedOn(req.Host)
+}
+
+// redirectToAcknowledge sends browsers to the interstitial page, which returns them to the
+// page they requested once they have acknowledged it. Other requests can't show the page,
+// so they are rejected until the user has acknowledged it in their browser.
+func (p *OAuthProxy) redirectToAcknowledge(rw http.ResponseWriter, req *http.Request, tags []string) {
+	if req.Method != http.MethodGet || p.wantsJSON(req) {
+		tags = append(tags, "error:acknowledgement_required")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden",
+			"You must acknowledge the notice for this site in your browser before continuing")
+		return
+	}
+
+	dest := p.GetRedirectURL(req.Host)
+	dest.Path = acknowledgePath
+	dest.RawQuery = url.Values{"rd": []string{req.URL.RequestURI()}}.Encode()
+	http.Redirect(rw, req, dest.String(), http.StatusFound)
+}
+
+// Acknowledge shows the upstream's interstitial page, and records the user's acknowledgement
+// of it in their session with a POST request. Users are only shown the page once per session.
+func (p *OAuthProxy) Acknowledge(rw http.ResponseWriter, req *http.Request) {
+	logger := requestLogEntry(req)
+	tags := []string{"action:acknowledge"}
+
+	if p.upstreamConfig.Interstitial == "" {
+		http.NotFound(rw, req)
+		return
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "GET, POST")
+		p.ErrorPage(rw, req, http.StatusMethodNotAllowed, "Method Not Allowed", "Method Not Allowed")
+		return
+	}
+
+	if req.Method == http.MethodPost && !isSameOrigin(req) {
+		tags = append(tags, "error:cross_origin_request")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Cross-origin requests are not allowed")
+		return
+	}
+
+	session, err := p.authenticate(rw, req)
+	if err != nil {
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+
+	redirect := localRedirect(req.FormValue("rd"))
+	if session.AcknowledgedOn(req.Host) {
+		http.Redirect(rw, req, redirect, http.StatusSeeOther)
+		return
+	}
+
+	if req.Method == http.MethodGet {
+		rw.Header().Set("Cache-Control", "no-store")
+		p.templates.ExecuteTemplate(rw, "interstitial.html", struct {
+			Host     string
+			Message  string
+			Redirect string
+		}{
+			Host:     req.Host,
+			Message:  p.upstreamConfig.Interstitial,
+			Redirect: redirect,
+		})
+		return
+	}
+
+	session.Acknowledge(req.Host)
+	err = p.sessionStore.SaveSession(rw, req, session)
+	if err != nil {
+		tags = append(tags, "error:save_session_error")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		logger.WithUser(session.Email).Error(err, "error saving session")
+		p.ErrorPage(rw, req, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred")
+		return
+	}
+
+	p.StatsdClient.Incr("interstitial_acknowledged", tags, 1.0)
+	logger.WithUser(session.Email).WithRequestHost(req.Host).WithAction("acknowledge").Info(
+		"interstitial acknowledged")
+	http.Redirect(rw, req, redirect, http.StatusSeeOther)
+}
+
+// localRedirect returns the redirect if it's a path on the current host, and "/" otherwise,
+// so the interstitial page can't be used as an open redirect.
+func localRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return "/"
+	}
+	return redirect
+}
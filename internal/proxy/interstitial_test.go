@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestAuthorizeRequiresAcknowledgement(t *testing.T) {
+	testCases := []struct {
+		name             string
+		acknowledged     bool
+		xhr              bool
+		expectedCode     int
+		expectedLocation string
+	}{
+		{
+			name:             "redirects to the interstitial page",
+			expectedCode:     http.StatusFound,
+			expectedLocation: "https://localhost/oauth2/acknowledge?rd=%2Fsome%2Fpage%3Fq%3D1",
+		},
+		{
+			name:         "rejects xhr requests",
+			xhr:          true,
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:         "allows acknowledged sessions",
+			acknowledged: true,
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := testSession()
+			if tc.acknowledged {
+				session.Acknowledge("localhost")
+			}
+			proxy, close := testNewOAuthProxy(t, setSessionStore(&sessions.MockSessionStore{Session: session}))
+			defer close()
+			proxy.upstreamConfig.Interstitial = "This system contains production data."
+
+			req := httptest.NewRequest("GET", "https://localhost/some/page?q=1", nil)
+			if tc.xhr {
+				req.Header.Set("X-Requested-With", "XMLHttpRequest")
+			}
+			rw := httptest.NewRecorder()
+			proxy.Authorize(rw, req)
+
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+			testutil.Equal(t, tc.expectedLocation, rw.Header().Get("Location"))
+		})
+	}
+}
+
+func TestAcknowledge(t *testing.T) {
+	testCases := []struct {
+		name               string
+		interstitial       string
+		method             string
+		origin             string
+		rd                 string
+		expectedCode       int
+		expectedLocation   string
+		expectAcknowledged bool
+	}{
+		{
+			name:         "not found without an interstitial",
+			method:       "GET",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "renders the interstitial",
+			interstitial: "This system contains production data.",
+			method:       "GET",
+			rd:           "/some/page",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "rejects cross origin requests",
+			interstitial: "This system contains production data.",
+			method:       "POST",
+			origin:       "https://evil.example.com",
+			rd:           "/some/page",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:               "records the acknowledgement",
+			interstitial:       "This system contains production data.",
+			method:             "POST",
+			origin:             "https://localhost",
+			rd:                 "/some/page",
+			expectedCode:       http.StatusSeeOther,
+			expectedLocation:   "/some/page",
+			expectAcknowledged: true,
+		},
+		{
+			name:               "never redirects off site",
+			interstitial:       "This system contains production data.",
+			method:             "POST",
+			origin:             "https://localhost",
+			rd:                 "//evil.example.com/",
+			expectedCode:       http.StatusSeeOther,
+			expectedLocation:   "/",
+			expectAcknowledged: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sessionStore := &sessions.MockSessionStore{Session: testSession()}
+			proxy, close := testNewOAuthProxy(t, setSessionStore(sessionStore))
+			defer close()
+			proxy.upstreamConfig.Interstitial = tc.interstitial
+
+			form := url.Values{"rd": []string{tc.rd}}
+			req := httptest.NewRequest(tc.method, "https://localhost/oauth2/acknowledge?"+form.Encode(), nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			rw := httptest.NewRecorder()
+			proxy.Handler().ServeHTTP(rw, req)
+
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+			testutil.Equal(t, tc.expectedLocation, rw.Header().Get("Location"))
+			testutil.Equal(t, tc.expectAcknowledged, sessionStore.Session.AcknowledgedOn("localhost"))
+			if tc.expectedCode == http.StatusOK {
+				testutil.Assert(t, strings.Contains(rw.Body.String(), tc.interstitial), "expected interstitial message in %q", rw.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+)
+
+// The types of keys GenerateKey generates.
+const (
+	KeyTypeCookie  = "cookie"
+	KeyTypeRSA     = "rsa"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeEd25519 = "ed25519"
+)
+
+const (
+	// cookieSecretSize is the size of generated cookie secrets, which is valid in FIPS mode.
+	cookieSecretSize = 32
+	// rsaKeyBits is the size of generated RSA signing keys.
+	rsaKeyBits = 2048
+)
+
+// GeneratedKey is a key generated by GenerateKey, encoded as sso_proxy is configured with it.
+type GeneratedKey struct {
+	// Secret is the base64 encoded secret of COOKIE_SECRET, or the PEM encoded PKCS8 private key
+	// of REQUEST_SIGNATURE_KEY.
+	Secret []byte
+	// PublicKey and KeyID are the public key of a signing key and its ID, as they're published
+	// at /oauth2/v1/certs. They're empty for cookie secrets.
+	PublicKey []byte
+	KeyID     string
+}
+
+// GenerateKey generates a cookie secret or a signing key of the given type.
+func GenerateKey(keyType string) (*GeneratedKey, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch keyType {
+	case KeyTypeCookie:
+		secret := make([]byte, cookieSecretSize)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return &GeneratedKey{Secret: []byte(base64.StdEncoding.EncodeToString(secret))}, nil
+	case KeyTypeRSA:
+		privateKey, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case KeyTypeECDSA:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown key type %q: must be one of %s, %s, %s, or %s",
+			keyType, KeyTypeCookie, KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal private key: %s", err)
+	}
+	// the public key is encoded by a signer, so that its ID is the one the proxy publishes
+	signer, err := newRequestSigner(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &GeneratedKey{
+		Secret:    pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateKeyBytes}),
+		PublicKey: []byte(signer.publicKeyStr),
+		KeyID:     signer.publicKeyID,
+	}, nil
+}
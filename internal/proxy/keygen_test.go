@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/pkg/signature"
+)
+
+func TestGenerateCookieSecret(t *testing.T) {
+	key, err := GenerateKey(KeyTypeCookie)
+	testutil.Ok(t, err)
+	testutil.Equal(t, "", key.KeyID)
+
+	o := testOptions()
+	o.CookieSecret = string(key.Secret)
+	testutil.Ok(t, o.Validate())
+	testutil.Equal(t, cookieSecretSize, len(o.decodedCookieSecret))
+}
+
+func TestGenerateSigningKey(t *testing.T) {
+	testCases := []struct {
+		keyType   string
+		algorithm string
+	}{
+		{KeyTypeRSA, signature.AlgorithmRS256},
+		{KeyTypeECDSA, signature.AlgorithmES256},
+		{KeyTypeEd25519, signature.AlgorithmEdDSA},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.keyType, func(t *testing.T) {
+			key, err := GenerateKey(tc.keyType)
+			testutil.Ok(t, err)
+
+			// the generated key is accepted as the request signing key, and its public key and
+			// ID are those the proxy publishes
+			signer, err := NewRequestSigner(string(key.Secret))
+			testutil.Ok(t, err)
+			testutil.Equal(t, tc.algorithm, signer.algorithm)
+			testutil.Equal(t, signer.publicKeyStr, string(key.PublicKey))
+			testutil.Equal(t, signer.publicKeyID, key.KeyID)
+
+			publicKey, err := signature.ParsePublicKey(string(key.PublicKey))
+			testutil.Ok(t, err)
+			algorithm, err := signature.Algorithm(publicKey)
+			testutil.Ok(t, err)
+			testutil.Equal(t, tc.algorithm, algorithm)
+		})
+	}
+
+	_, err := GenerateKey("dsa")
+	testutil.NotEqual(t, nil, err)
+}
@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/buzzfeed/sso/pkg/signature"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awssession "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"golang.org/x/oauth2/google"
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+// kmsTimeout bounds each call to a KMS, so that a slow KMS fails requests rather than holding
+// them open.
+const kmsTimeout = 10 * time.Second
+
+var gcpKMSKeyVersion = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+/cryptoKeyVersions/[^/]+$`)
+
+// kmsClient signs digests with an asymmetric key that never leaves a KMS.
+type kmsClient interface {
+	// publicKey returns the public key of the KMS key, or an error if the key can't sign
+	// SHA-256 digests with an algorithm supported by the request signer.
+	publicKey(ctx context.Context) (crypto.PublicKey, error)
+	// sign returns the signature of a SHA-256 digest, made with the given algorithm.
+	sign(ctx context.Context, digest []byte, algorithm string) ([]byte, error)
+}
+
+// kmsSigner is a crypto.Signer whose private key is held by a KMS. Its public key is fetched
+// once, when it's created, and cached for the certs and JWKS endpoints.
+type kmsSigner struct {
+	client    kmsClient
+	public    crypto.PublicKey
+	algorithm string
+}
+
+func newKMSSigner(ctx context.Context, client kmsClient) (*kmsSigner, error) {
+	public, err := client.publicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := signature.Algorithm(public)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm == signature.AlgorithmEdDSA {
+		return nil, fmt.Errorf("Ed25519 KMS keys are not supported")
+	}
+	return &kmsSigner{
+		client:    client,
+		public:    public,
+		algorithm: algorithm,
+	}, nil
+}
+
+// Public implements the crypto.Signer interface.
+func (s *kmsSigner) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign implements the crypto.Signer interface. The KMS provides the randomness of ECDSA
+// signatures, so rand is unused.
+func (s *kmsSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, fmt.Errorf("KMS keys only sign SHA-256 digests")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	return s.client.sign(ctx, digest, s.algorithm)
+}
+
+// NewKMSRequestSigner constructs a RequestSigner object whose private key is held by a KMS,
+// given the ARN of an AWS KMS key, or the resource name of a GCP Cloud KMS key version. Every
+// request is signed by a call to the KMS.
+func NewKMSRequestSigner(keyName string) (*RequestSigner, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+
+	client, err := newKMSClient(ctx, keyName)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := newKMSSigner(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("could not load KMS key %s: %s", keyName, err)
+	}
+	return newRequestSigner(signer)
+}
+
+func newKMSClient(ctx context.Context, keyName string) (kmsClient, error) {
+	switch {
+	case strings.HasPrefix(keyName, "arn:"):
+		svc, err := newAWSKMSService(keyName)
+		if err != nil {
+			return nil, err
+		}
+		return &awsKMSClient{svc: svc, keyID: keyName}, nil
+	case gcpKMSKeyVersion.MatchString(keyName):
+		svc, err := newGCPKMSService(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &gcpKMSClient{svc: svc, name: keyName}, nil
+	default:
+		return nil, fmt.Errorf("%q is neither an AWS KMS key ARN nor a GCP KMS key version", keyName)
+	}
+}
+
+// newAWSKMSService returns an AWS KMS client for the region of a key ARN, with the default
+// credentials of the environment.
+func newAWSKMSService(arn string) (*kms.KMS, error) {
+	region, err := awsKMSRegion(arn)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := awssession.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, err
+	}
+	return kms.New(sess), nil
+}
+
+// newGCPKMSService returns a GCP Cloud KMS client with the application default credentials.
+func newGCPKMSService(ctx context.Context) (*cloudkms.Service, error) {
+	client, err := google.DefaultClient(ctx, cloudkms.CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	return cloudkms.New(client)
+}
+
+// awsKMSRegion returns the region of an AWS KMS key or alias ARN, e.g.
+// arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab.
+func awsKMSRegion(arn string) (string, error) {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[2] != "kms" || parts[3] == "" ||
+		!(strings.HasPrefix(parts[5], "key/") || strings.HasPrefix(parts[5], "alias/")) {
+		return "", fmt.Errorf("%q is not an AWS KMS key ARN", arn)
+	}
+	return parts[3], nil
+}
+
+// validateKMSKeyName returns an error if a key name can't name a KMS key, without calling the KMS.
+func validateKMSKeyName(keyName string) error {
+	if strings.HasPrefix(keyName, "arn:") {
+		_, err := awsKMSRegion(keyName)
+		return err
+	}
+	if !gcpKMSKeyVersion.MatchString(keyName) {
+		return fmt.Errorf("%q is neither an AWS KMS key ARN nor a GCP KMS key version", keyName)
+	}
+	return nil
+}
+
+// awsSigningAlgorithms are the AWS KMS names of the signature algorithms.
+var awsSigningAlgorithms = map[string]string{
+	signature.AlgorithmRS256: "RSASSA_PKCS1_V1_5_SHA_256",
+	signature.AlgorithmES256: "ECDSA_SHA_256",
+}
+
+// awsKMSClient signs with an AWS KMS key. The vendored aws-sdk-go predates asymmetric KMS keys,
+// so the Sign and GetPublicKey operations are sent through the KMS client as raw JSON requests.
+type awsKMSClient struct {
+	svc   *kms.KMS
+	keyID string
+}
+
+type awsKMSGetPublicKeyInput struct {
+	_     struct{} `type:"structure"`
+	KeyId *string  `type:"string"`
+}
+
+type awsKMSGetPublicKeyOutput struct {
+	_                 struct{}  `type:"structure"`
+	PublicKey         []byte    `type:"blob"`
+	KeyUsage          *string   `type:"string"`
+	SigningAlgorithms []*string `type:"list"`
+}
+
+type awsKMSSignInput struct {
+	_                struct{} `type:"structure"`
+	KeyId            *string  `type:"string"`
+	Message          []byte   `type:"blob"`
+	MessageType      *string  `type:"string"`
+	SigningAlgorithm *string  `type:"string"`
+}
+
+type awsKMSSignOutput struct {
+	_         struct{} `type:"structure"`
+	Signature []byte   `type:"blob"`
+}
+
+func (c *awsKMSClient) send(ctx context.Context, operation string, input, output interface{}) error {
+	req := c.svc.NewRequest(&request.Operation{
+		Name:       operation,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}, input, output)
+	req.SetContext(ctx)
+	return req.Send()
+}
+
+func (c *awsKMSClient) publicKey(ctx context.Context) (crypto.PublicKey, error) {
+	output := &awsKMSGetPublicKeyOutput{}
+	err := c.send(ctx, "GetPublicKey", &awsKMSGetPublicKeyInput{KeyId: aws.String(c.keyID)}, output)
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(output.KeyUsage) != "SIGN_VERIFY" {
+		return nil, fmt.Errorf("key usage is %s, not SIGN_VERIFY", aws.StringValue(output.KeyUsage))
+	}
+
+	public, err := x509.ParsePKIXPublicKey(output.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := signature.Algorithm(public)
+	if err != nil {
+		return nil, err
+	}
+	for _, supported := range output.SigningAlgorithms {
+		if aws.StringValue(supported) == awsSigningAlgorithms[algorithm] {
+			return public, nil
+		}
+	}
+	return nil, fmt.Errorf("key can't sign with %s", awsSigningAlgorithms[algorithm])
+}
+
+func (c *awsKMSClient) sign(ctx context.Context, digest []byte, algorithm string) ([]byte, error) {
+	output := &awsKMSSignOutput{}
+	err := c.send(ctx, "Sign", &awsKMSSignInput{
+		KeyId:            aws.String(c.keyID),
+		Message:          digest,
+		MessageType:      aws.String("DIGEST"),
+		SigningAlgorithm: aws.String(awsSigningAlgorithms[algorithm]),
+	}, output)
+	if err != nil {
+		return nil, err
+	}
+	return output.Signature, nil
+}
+
+// gcpSigningAlgorithms are the GCP Cloud KMS key version algorithms that sign SHA-256 digests
+// with a signature algorithm supported by the request signer.
+var gcpSigningAlgorithms = map[string]bool{
+	"RSA_SIGN_PKCS1_2048_SHA256": true,
+	"RSA_SIGN_PKCS1_3072_SHA256": true,
+	"RSA_SIGN_PKCS1_4096_SHA256": true,
+	"EC_SIGN_P256_SHA256":        true,
+}
+
+// gcpKMSClient signs with a GCP Cloud KMS key version.
+type gcpKMSClient struct {
+	svc  *cloudkms.Service
+	name string
+}
+
+func (c *gcpKMSClient) publicKey(ctx context.Context) (crypto.PublicKey, error) {
+	resp, err := c.svc.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.GetPublicKey(c.name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if !gcpSigningAlgorithms[resp.Algorithm] {
+		return nil, fmt.Errorf("unsupported key algorithm %s", resp.Algorithm)
+	}
+
+	block, _ := pem.Decode([]byte(resp.Pem))
+	if block == nil {
+		return nil, fmt.Errorf("could not read PEM block from public key")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func (c *gcpKMSClient) sign(ctx context.Context, digest []byte, algorithm string) ([]byte, error) {
+	resp, err := c.svc.Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.AsymmetricSign(c.name, &cloudkms.AsymmetricSignRequest{
+		Digest: &cloudkms.Digest{Sha256: base64.StdEncoding.EncodeToString(digest)},
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Signature)
+}
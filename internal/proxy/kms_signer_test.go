@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/pkg/signature"
+)
+
+// fakeKMSClient signs with a local key, recording the calls made to it.
+type fakeKMSClient struct {
+	key            crypto.Signer
+	signErr        error
+	publicKeyCalls int
+	algorithms     []string
+}
+
+func (c *fakeKMSClient) publicKey(ctx context.Context) (crypto.PublicKey, error) {
+	c.publicKeyCalls++
+	return c.key.Public(), nil
+}
+
+func (c *fakeKMSClient) sign(ctx context.Context, digest []byte, algorithm string) ([]byte, error) {
+	c.algorithms = append(c.algorithms, algorithm)
+	if c.signErr != nil {
+		return nil, c.signErr
+	}
+	return c.key.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+func TestKMSRequestSigner(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	testutil.Assert(t, err == nil, "could not generate RSA key: %s", err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Assert(t, err == nil, "could not generate ECDSA key: %s", err)
+
+	testCases := []struct {
+		name          string
+		key           crypto.Signer
+		wantAlgorithm string
+	}{
+		{name: "RSA key", key: rsaKey, wantAlgorithm: "RS256"},
+		{name: "ECDSA key", key: ecKey, wantAlgorithm: "ES256"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &fakeKMSClient{key: tc.key}
+			kmsSigner, err := newKMSSigner(context.Background(), client)
+			testutil.Assert(t, err == nil, "could not create KMS signer: %s", err)
+			requestSigner, err := newRequestSigner(kmsSigner)
+			testutil.Assert(t, err == nil, "could not initialize request signer: %s", err)
+
+			kid, publicKey := requestSigner.PublicKey()
+			verifier, err := signature.NewVerifier(map[string]string{kid: publicKey})
+			testutil.Assert(t, err == nil, "could not initialize verifier: %s", err)
+
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest("POST", urlExample, strings.NewReader("something\nor other"))
+				addHeaders(req, []string{"X-Forwarded-Email", "X-Forwarded-Groups"}, nil)
+				err = requestSigner.Sign(req)
+				testutil.Assert(t, err == nil, "could not sign request: %s", err)
+				testutil.Equal(t, tc.wantAlgorithm, req.Header.Get("Sso-Signature-Algorithm"))
+				testutil.Equal(t, nil, verifier.Verify(req))
+			}
+
+			// every request is signed by the KMS, but the public key is only fetched once
+			testutil.Equal(t, []string{tc.wantAlgorithm, tc.wantAlgorithm, tc.wantAlgorithm}, client.algorithms)
+			testutil.Equal(t, 1, client.publicKeyCalls)
+			_, err = requestSigner.JWKS()
+			testutil.Ok(t, err)
+			testutil.Equal(t, 1, client.publicKeyCalls)
+		})
+	}
+}
+
+func TestKMSSignerErrors(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	testutil.Assert(t, err == nil, "could not generate Ed25519 key: %s", err)
+	_, err = newKMSSigner(context.Background(), &fakeKMSClient{key: edKey})
+	testutil.NotEqual(t, nil, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Assert(t, err == nil, "could not generate ECDSA key: %s", err)
+	kmsSigner, err := newKMSSigner(context.Background(), &fakeKMSClient{key: ecKey, signErr: fmt.Errorf("kms unavailable")})
+	testutil.Ok(t, err)
+	requestSigner, err := newRequestSigner(kmsSigner)
+	testutil.Ok(t, err)
+
+	// requests that can't be signed aren't sent to the upstream
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Errorf("unsigned request was sent to the upstream")
+	})
+	handler := newSigningHandler(upstream, &UpstreamConfig{}, requestSigner)
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", urlExample, nil))
+	testutil.Equal(t, http.StatusInternalServerError, rw.Code)
+}
+
+func TestValidateKMSKeyName(t *testing.T) {
+	testCases := []struct {
+		keyName string
+		wantErr bool
+	}{
+		{keyName: "arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"},
+		{keyName: "arn:aws-us-gov:kms:us-gov-west-1:111122223333:alias/sso-proxy"},
+		{keyName: "projects/sso/locations/global/keyRings/proxy/cryptoKeys/signing/cryptoKeyVersions/1"},
+		{keyName: "arn:aws:s3:::bucket/key", wantErr: true},
+		{keyName: "arn:aws:kms::111122223333:key/1234abcd", wantErr: true},
+		{keyName: "arn:aws:kms:us-east-1:111122223333:grant/1234abcd", wantErr: true},
+		{keyName: "projects/sso/locations/global/keyRings/proxy/cryptoKeys/signing", wantErr: true},
+		{keyName: "1234abcd-12ab-34cd-56ef-1234567890ab", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.keyName, func(t *testing.T) {
+			err := validateKMSKeyName(tc.keyName)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+			} else {
+				testutil.Ok(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateRequestSignerKMSKey(t *testing.T) {
+	o := testOptions()
+	o.RequestSigningKey = "key"
+	o.RequestSignatureKMSKey = "key/1234abcd"
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"invalid setting: REQUEST_SIGNATURE_KEY and REQUEST_SIGNATURE_KMS_KEY can't both be set",
+		`invalid value for request-signature-kms-key: "key/1234abcd" is neither an AWS KMS key ARN nor a GCP KMS key version`,
+	}), err.Error())
+
+	// REQUESTSIGNER_KMS_KEY_ARN is the deprecated name of REQUEST_SIGNATURE_KMS_KEY
+	keyARN := "arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	o = testOptions()
+	o.RequestSignerKMSKeyARN = keyARN
+	testutil.Equal(t, nil, o.Validate())
+	testutil.Equal(t, keyARN, o.RequestSignatureKMSKey)
+
+	o = testOptions()
+	o.RequestSignerKMSKeyARN = keyARN
+	o.RequestSignatureKMSKey = "projects/sso/locations/global/keyRings/proxy/cryptoKeys/signing/cryptoKeyVersions/1"
+	err = o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"invalid setting: REQUEST_SIGNATURE_KMS_KEY and REQUESTSIGNER_KMS_KEY_ARN can't both be set",
+	}), err.Error())
+}
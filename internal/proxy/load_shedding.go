@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// loadShedHeader is set on responses to requests shed while the proxy or their upstream is
+// overloaded.
+const loadShedHeader = "X-SSO-Load-Shed"
+
+// loadShedder sheds the requests of the lowest priority upstreams while the latency of the proxy,
+// or of an upstream, exceeds its target, so that the requests of higher priority upstreams are
+// still served. An upstream's priority is the position of its tier in the list of tiers, highest
+// first; upstreams with other tiers, or without one, have the lowest priority. Requests of the
+// highest priority are never shed. It is shared by every upstream, so that the latency of the
+// proxy is that of all of their requests.
+type loadShedder struct {
+	tiers    []string
+	interval time.Duration
+	proxy    *latencyDetector
+}
+
+// newLoadShedder returns the load shedder configured by opts, or nil if load shedding is disabled.
+func newLoadShedder(opts *Options) *loadShedder {
+	if !opts.LoadShedding {
+		return nil
+	}
+	return &loadShedder{
+		tiers:    opts.LoadSheddingTiers,
+		interval: opts.LoadSheddingInterval,
+		proxy:    newLatencyDetector(opts.LoadSheddingTarget, opts.LoadSheddingInterval, len(opts.LoadSheddingTiers)),
+	}
+}
+
+// priority returns the priority of an upstream by its priority class, or else its tier, where 0
+// is the highest and the number of tiers the lowest.
+func (s *loadShedder) priority(upstreamConfig *UpstreamConfig) int {
+	class := priorityClass(upstreamConfig)
+	for i, tier := range s.tiers {
+		if tier == class {
+			return i
+		}
+	}
+	return len(s.tiers)
+}
+
+// handler returns a handler that sheds the requests of an upstream while they're of too low a
+// priority for the current load, and otherwise measures their latency.
+func (s *loadShedder) handler(h http.Handler, upstreamConfig *UpstreamConfig, statsdClient *statsd.Client) http.Handler {
+	priority := s.priority(upstreamConfig)
+	upstream := newLatencyDetector(s.proxy.target, s.interval, len(s.tiers))
+	retryAfter := strconv.Itoa(int(math.Ceil(s.interval.Seconds())))
+	tags := []string{"service:" + upstreamConfig.Service, fmt.Sprintf("priority:%d", priority)}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		if s.proxy.shed(priority, start) || upstream.shed(priority, start) {
+			statsdClient.Incr("upstream.load_shed", tags, 1.0)
+			rw.Header().Set(loadShedHeader, "true")
+			rw.Header().Set("Retry-After", retryAfter)
+			http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		h.ServeHTTP(rw, req)
+		end := time.Now()
+		latency := end.Sub(start)
+		s.proxy.observe(latency, end)
+		upstream.observe(latency, end)
+	})
+}
+
+// latencyDetector detects overload as CoDel does: the latency of requests is only too high if
+// even the fastest request of an interval exceeds the target, which rules out a few slow
+// requests, and means requests are queueing. Each interval the latency stays too high sheds one
+// more priority, lowest first, and each interval it doesn't sheds one less.
+type latencyDetector struct {
+	target   time.Duration
+	interval time.Duration
+	maxLevel int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	minLatency  time.Duration
+	observed    bool
+	level       int
+}
+
+// newLatencyDetector returns a detector of requests with the given number of priorities below
+// the highest, which may be shed.
+func newLatencyDetector(target, interval time.Duration, maxLevel int) *latencyDetector {
+	return &latencyDetector{
+		target:   target,
+		interval: interval,
+		maxLevel: maxLevel,
+	}
+}
+
+// observe records the latency of a request that ended at a time.
+func (d *latencyDetector) observe(latency time.Duration, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.roll(now)
+	if !d.observed || latency < d.minLatency {
+		d.minLatency = latency
+		d.observed = true
+	}
+}
+
+// shed returns true if a request of a priority is shed at a time.
+func (d *latencyDetector) shed(priority int, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.roll(now)
+	return priority > d.maxLevel-d.level
+}
+
+// roll ends the interval once it has elapsed, raising the shed level if its fastest request
+// exceeded the target, and lowering it otherwise, including when there were no requests.
+func (d *latencyDetector) roll(now time.Time) {
+	if d.windowStart.IsZero() {
+		d.windowStart = now
+		return
+	}
+	if now.Sub(d.windowStart) < d.interval {
+		return
+	}
+
+	if d.observed && d.minLatency > d.target {
+		if d.level < d.maxLevel {
+			d.level++
+		}
+	} else if d.level > 0 {
+		d.level--
+	}
+	d.windowStart = now
+	d.observed = false
+}
+
+// validateLoadSheddingOptions validates the load shedding settings, if it's enabled.
+func validateLoadSheddingOptions(o *Options, msgs []string) []string {
+	if !o.LoadShedding {
+		return msgs
+	}
+	if o.LoadSheddingTarget <= 0 {
+		msgs = append(msgs, "invalid value for load-shedding-target: must be positive")
+	}
+	if o.LoadSheddingInterval <= 0 {
+		msgs = append(msgs, "invalid value for load-shedding-interval: must be positive")
+	}
+	if len(o.LoadSheddingTiers) == 0 {
+		msgs = append(msgs, "missing setting: load-shedding-tiers is required to shed load")
+	}
+	return msgs
+}
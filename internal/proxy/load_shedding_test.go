@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestLatencyDetector(t *testing.T) {
+	d := newLatencyDetector(100*time.Millisecond, time.Second, 2)
+	now := time.Unix(1500000000, 0)
+	shedPriorities := func() []int {
+		shed := []int{}
+		for priority := 0; priority <= 2; priority++ {
+			if d.shed(priority, now) {
+				shed = append(shed, priority)
+			}
+		}
+		return shed
+	}
+
+	// a slow request doesn't shed load while others are fast
+	d.observe(50*time.Millisecond, now)
+	d.observe(time.Second, now.Add(500*time.Millisecond))
+	now = now.Add(time.Second)
+	testutil.Equal(t, []int{}, shedPriorities())
+
+	// each interval whose fastest request exceeds the target sheds one more priority, lowest first
+	for _, want := range [][]int{{2}, {1, 2}, {1, 2}} {
+		d.observe(200*time.Millisecond, now)
+		d.observe(300*time.Millisecond, now.Add(500*time.Millisecond))
+		now = now.Add(time.Second)
+		testutil.Equal(t, want, shedPriorities())
+	}
+
+	// and each interval that doesn't, including those without requests, sheds one less
+	d.observe(50*time.Millisecond, now)
+	now = now.Add(time.Second)
+	testutil.Equal(t, []int{2}, shedPriorities())
+	now = now.Add(time.Second)
+	testutil.Equal(t, []int{}, shedPriorities())
+}
+
+func TestLoadShedderPriority(t *testing.T) {
+	o := testOptions()
+	o.LoadShedding = true
+	o.LoadSheddingTiers = []string{"critical", "standard"}
+	s := newLoadShedder(o)
+
+	testCases := []struct {
+		metadata *MetadataConfig
+		priority int
+	}{
+		{&MetadataConfig{Tier: "critical"}, 0},
+		{&MetadataConfig{Tier: "standard"}, 1},
+		{&MetadataConfig{Tier: "batch"}, 2},
+		{&MetadataConfig{Team: "payments"}, 2},
+		{nil, 2},
+	}
+	for _, tc := range testCases {
+		priority := s.priority(&UpstreamConfig{Service: "foo", Metadata: tc.metadata})
+		testutil.Equal(t, tc.priority, priority)
+	}
+
+	o.LoadShedding = false
+	testutil.Equal(t, (*loadShedder)(nil), newLoadShedder(o))
+}
+
+func TestLoadShedderHandler(t *testing.T) {
+	o := testOptions()
+	o.LoadShedding = true
+	o.LoadSheddingTiers = []string{"critical", "standard"}
+	s := newLoadShedder(o)
+
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	critical := s.handler(upstream, &UpstreamConfig{Service: "oncall", Metadata: &MetadataConfig{Tier: "critical"}}, nil)
+	batch := s.handler(upstream, &UpstreamConfig{Service: "reports"}, nil)
+	serve := func(h http.Handler) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", "http://foo.sso.dev/", nil))
+		return rw
+	}
+
+	testutil.Equal(t, http.StatusOK, serve(batch).Code)
+
+	// while the proxy is overloaded, requests of the lowest priority are shed first, and those of
+	// the highest aren't shed at all
+	s.proxy.level = s.proxy.maxLevel
+	s.proxy.windowStart = time.Now()
+	rw := serve(batch)
+	testutil.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	testutil.Equal(t, "true", rw.Header().Get(loadShedHeader))
+	testutil.Equal(t, "1", rw.Header().Get("Retry-After"))
+	testutil.Equal(t, http.StatusOK, serve(critical).Code)
+}
+
+func TestValidateLoadSheddingOptions(t *testing.T) {
+	o := testOptions()
+	o.LoadSheddingTiers = nil
+	testutil.Ok(t, o.Validate())
+
+	o.LoadShedding = true
+	o.LoadSheddingTarget = 0
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"invalid value for load-shedding-target: must be positive",
+		"missing setting: load-shedding-tiers is required to shed load",
+	}), err.Error())
+}
@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,9 +18,17 @@ import (
 	"github.com/datadog/datadog-go/statsd"
 )
 
-// Used to stash the authenticated user in the response for access when logging requests.
+// Used to stash the authenticated user in the response for access when logging requests that
+// weren't authenticated with a session, which is otherwise read from the request context.
 const loggingUserHeader = "SSO-Authenticated-User"
 
+// Used to stash the GeoIP country and ASN of the client, if known, in the response for
+// access when logging requests.
+const (
+	loggingCountryHeader = "SSO-Country"
+	loggingASNHeader     = "SSO-ASN"
+)
+
 // responseLogger is wrapper of http.ResponseWriter that keeps track of its HTTP status
 // code and body size
 type responseLogger struct {
@@ -27,6 +36,9 @@ type responseLogger struct {
 	status   int
 	size     int
 	authInfo string
+	country  string
+	asn      string
+	metadata MetadataConfig
 }
 
 func (l *responseLogger) Header() http.Header {
@@ -39,6 +51,26 @@ func (l *responseLogger) extractUser() {
 		l.authInfo = authInfo
 		l.w.Header().Del(loggingUserHeader)
 	}
+	country := l.w.Header().Get(loggingCountryHeader)
+	if country != "" {
+		l.country = country
+		l.w.Header().Del(loggingCountryHeader)
+	}
+	asn := l.w.Header().Get(loggingASNHeader)
+	if asn != "" {
+		l.asn = asn
+		l.w.Header().Del(loggingASNHeader)
+	}
+	for header, value := range map[string]*string{
+		loggingTeamHeader:        &l.metadata.Team,
+		loggingTierHeader:        &l.metadata.Tier,
+		loggingServiceNameHeader: &l.metadata.ServiceName,
+	} {
+		if v := l.w.Header().Get(header); v != "" {
+			*value = v
+			l.w.Header().Del(header)
+		}
+	}
 }
 
 // Support Websockets
@@ -100,15 +132,17 @@ func (h loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	now := time.Now()
 	url := *req.URL
 	logger := &responseLogger{w: w}
+	req = req.WithContext(NewSessionContext(req.Context()))
 	h.handler.ServeHTTP(logger, req)
 	if !h.enabled {
 		return
 	}
-	logRequest(logger.authInfo, req, url, now, logger.Status(), h.StatsdClient)
+	logRequest(logger, req, url, now, h.StatsdClient)
 }
 
 // logRequest logs information about a request
-func logRequest(username string, req *http.Request, url url.URL, ts time.Time, status int, StatsdClient *statsd.Client) {
+func logRequest(l *responseLogger, req *http.Request, url url.URL, ts time.Time, StatsdClient *statsd.Client) {
+	status := l.Status()
 	duration := time.Now().Sub(ts)
 
 	// Convert duration to floating point milliseconds
@@ -117,12 +151,35 @@ func logRequest(username string, req *http.Request, url url.URL, ts time.Time, s
 
 	uri := req.Host + url.RequestURI()
 
+	user := l.authInfo
 	logger := log.NewLogEntry()
-	logger.WithHTTPStatus(status).WithRequestMethod(req.Method).WithRequestURI(
+	if session, ok := SessionFromContext(req.Context()); ok {
+		user = session.Email
+		// every impersonated request is logged with the administrator responsible for it
+		if session.ImpersonatingOn(req.Host) {
+			logger = logger.WithImpersonatedUser(session.Impersonation.Email)
+		}
+	}
+	if l.country != "" {
+		logger = logger.WithCountry(l.country)
+	}
+	if asn, err := strconv.ParseUint(l.asn, 10, 64); err == nil {
+		logger = logger.WithASN(asn)
+	}
+	if l.metadata.Team != "" {
+		logger = logger.WithTeam(l.metadata.Team)
+	}
+	if l.metadata.Tier != "" {
+		logger = logger.WithTier(l.metadata.Tier)
+	}
+	if l.metadata.ServiceName != "" {
+		logger = logger.WithServiceName(l.metadata.ServiceName)
+	}
+	logger.WithHTTPStatus(status).WithRequestID(l.Header().Get(requestIDHeader)).WithRequestMethod(req.Method).WithRequestURI(
 		uri).WithUserAgent(req.Header.Get("User-Agent")).WithRemoteAddress(
 		getRemoteAddr(req)).WithRequestDurationMs(durationMS).WithUser(
-		username).WithAction(GetActionTag(req)).Info()
-	logRequestMetrics(req, duration, status, StatsdClient)
+		user).WithAction(GetActionTag(req)).Info()
+	logRequestMetrics(req, duration, status, l.metadata.tags(), StatsdClient)
 }
 
 // getRemoteAddr returns the client IP address from a request. If present, the
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+)
+
+// loginThrottle tracks the failed sign ins of each user and client address, and locks them out
+// for a while once they fail too often, to slow down the enumeration of users and groups who
+// are authorized. Each failure beyond the threshold doubles the lockout, up to a maximum. It is
+// shared by every upstream, so failures on one upstream lock the user out of all of them.
+type loginThrottle struct {
+	threshold  int
+	window     time.Duration
+	lockout    time.Duration
+	maxLockout time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+	pruned   time.Time
+}
+
+// loginFailures are the recent failed sign ins of a user or client address.
+type loginFailures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// newLoginThrottle returns the throttle of failed sign ins configured by opts, or nil if
+// lockouts are disabled.
+func newLoginThrottle(opts *Options) *loginThrottle {
+	if opts.LoginLockoutThreshold == 0 {
+		return nil
+	}
+	return &loginThrottle{
+		threshold:  opts.LoginLockoutThreshold,
+		window:     opts.LoginFailureWindow,
+		lockout:    opts.LoginLockoutDuration,
+		maxLockout: opts.LoginLockoutMaxDuration,
+		failures:   make(map[string]*loginFailures),
+	}
+}
+
+// SetLoginThrottle sets the throttle of failed sign ins, as a functional option
+func SetLoginThrottle(t *loginThrottle) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.loginThrottle = t
+		return nil
+	}
+}
+
+// validateLoginThrottleOptions validates the lockout settings, if lockouts are enabled.
+func validateLoginThrottleOptions(o *Options, msgs []string) []string {
+	if o.LoginLockoutThreshold < 0 {
+		msgs = append(msgs, "invalid value for login-lockout-threshold: must not be negative")
+	}
+	if o.LoginLockoutThreshold <= 0 {
+		return msgs
+	}
+	if o.LoginFailureWindow <= 0 {
+		msgs = append(msgs, "invalid value for login-failure-window: must be positive")
+	}
+	if o.LoginLockoutDuration <= 0 {
+		msgs = append(msgs, "invalid value for login-lockout-duration: must be positive")
+	}
+	if o.LoginLockoutMaxDuration < o.LoginLockoutDuration {
+		msgs = append(msgs, "invalid value for login-lockout-max-duration: must not be less than login-lockout-duration")
+	}
+	return msgs
+}
+
+// loginEmailKey and loginAddrKey are the keys of the failures of a user and a client address.
+func loginEmailKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func loginAddrKey(req *http.Request) string {
+	addr := getRemoteAddr(req)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return "addr:" + addr
+}
+
+// lockedOut returns how much longer a key is locked out for, or zero if it isn't.
+func (t *loginThrottle) lockedOut(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.failures[key]
+	if !ok || !now.Before(f.lockedUntil) {
+		return 0
+	}
+	return f.lockedUntil.Sub(now)
+}
+
+// fail records a failed sign in of a key, returning the lockout it starts, or zero if the key
+// hasn't failed often enough to be locked out.
+func (t *loginThrottle) fail(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.pruned) > t.window {
+		for k, f := range t.failures {
+			if now.Sub(f.last) > t.window && !now.Before(f.lockedUntil) {
+				delete(t.failures, k)
+			}
+		}
+		t.pruned = now
+	}
+
+	f, ok := t.failures[key]
+	if !ok || now.Sub(f.last) > t.window {
+		f = &loginFailures{}
+		t.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < t.threshold {
+		return 0
+	}
+
+	// the lockout doubles with each failure beyond the threshold
+	lockout := t.lockout
+	for i := t.threshold; i < f.count && lockout < t.maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > t.maxLockout {
+		lockout = t.maxLockout
+	}
+	f.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// succeed forgets the failures of a key.
+func (t *loginThrottle) succeed(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, key)
+}
+
+// loginLockedOut responds with a 429 if the client address, or the user if an email is given,
+// is locked out of signing in, returning true if it did.
+func (p *OAuthProxy) loginLockedOut(rw http.ResponseWriter, req *http.Request, email string, tags []string) bool {
+	if p.loginThrottle == nil {
+		return false
+	}
+
+	now := time.Now()
+	scope := "address"
+	remaining := p.loginThrottle.lockedOut(loginAddrKey(req), now)
+	if email != "" {
+		if r := p.loginThrottle.lockedOut(loginEmailKey(email), now); r > remaining {
+			scope = "user"
+			remaining = r
+		}
+	}
+	if remaining == 0 {
+		return false
+	}
+
+	p.StatsdClient.Incr("login_locked_out", append(tags, "scope:"+scope), 1.0)
+	log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req)).WithUser(email).Info(
+		fmt.Sprintf("sign in refused: %s locked out after too many failed sign ins", scope))
+
+	retryAfter := int(math.Ceil(remaining.Seconds()))
+	rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	p.ErrorPage(rw, req, http.StatusTooManyRequests, "Too Many Requests",
+		fmt.Sprintf("There have been too many failed sign in attempts. Try again in %s.", time.Duration(retryAfter)*time.Second))
+	return true
+}
+
+// loginFailed records a failed sign in of a user from the client address of a request, locking
+// either of them out if they have failed too often.
+func (p *OAuthProxy) loginFailed(req *http.Request, session *sessions.SessionState, tags []string) {
+	if p.loginThrottle == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, k := range []struct{ scope, key string }{
+		{"user", loginEmailKey(session.Email)},
+		{"address", loginAddrKey(req)},
+	} {
+		lockout := p.loginThrottle.fail(k.key, now)
+		if lockout == 0 {
+			continue
+		}
+		p.StatsdClient.Incr("login_lockout", append(tags, "scope:"+k.scope), 1.0)
+		log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req)).WithUser(session.Email).Info(
+			fmt.Sprintf("%s locked out of signing in for %s after too many failed sign ins", k.scope, lockout))
+		p.notifyEvent(req, eventLockout, session.Email, session.Groups,
+			fmt.Sprintf("%s locked out for %s after too many failed sign ins", k.scope, lockout))
+	}
+}
+
+// loginSucceeded forgets the failed sign ins of a user.
+func (p *OAuthProxy) loginSucceeded(session *sessions.SessionState) {
+	if p.loginThrottle == nil {
+		return
+	}
+	p.loginThrottle.succeed(loginEmailKey(session.Email))
+}
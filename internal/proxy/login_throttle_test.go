@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func testLoginThrottleOptions() *Options {
+	o := testOptions()
+	o.LoginLockoutThreshold = 3
+	o.LoginFailureWindow = 15 * time.Minute
+	o.LoginLockoutDuration = time.Minute
+	o.LoginLockoutMaxDuration = 5 * time.Minute
+	return o
+}
+
+func TestValidateLoginThrottleOptions(t *testing.T) {
+	o := testOptions()
+	o.LoginLockoutThreshold = -1
+	testutil.Equal(t, []string{
+		"invalid value for login-lockout-threshold: must not be negative",
+	}, validateLoginThrottleOptions(o, []string{}))
+
+	o.LoginLockoutThreshold = 3
+	testutil.Equal(t, []string{
+		"invalid value for login-failure-window: must be positive",
+		"invalid value for login-lockout-duration: must be positive",
+	}, validateLoginThrottleOptions(o, []string{}))
+
+	o = testLoginThrottleOptions()
+	o.LoginLockoutMaxDuration = time.Second
+	testutil.Equal(t, []string{
+		"invalid value for login-lockout-max-duration: must not be less than login-lockout-duration",
+	}, validateLoginThrottleOptions(o, []string{}))
+
+	testutil.Equal(t, 0, len(validateLoginThrottleOptions(testLoginThrottleOptions(), []string{})))
+	testutil.Assert(t, newLoginThrottle(testOptions()) == nil, "expected no throttle without a threshold")
+}
+
+func TestLoginThrottle(t *testing.T) {
+	throttle := newLoginThrottle(testLoginThrottleOptions())
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testutil.Equal(t, time.Duration(0), throttle.fail("email:foo@example.com", now))
+	testutil.Equal(t, time.Duration(0), throttle.fail("email:foo@example.com", now.Add(time.Second)))
+	testutil.Equal(t, time.Duration(0), throttle.lockedOut("email:foo@example.com", now.Add(time.Second)))
+
+	// the threshold starts a lockout, which doubles with each further failure up to the maximum
+	testutil.Equal(t, time.Minute, throttle.fail("email:foo@example.com", now.Add(2*time.Second)))
+	testutil.Equal(t, 30*time.Second, throttle.lockedOut("email:foo@example.com", now.Add(32*time.Second)))
+	testutil.Equal(t, time.Duration(0), throttle.lockedOut("email:bar@example.com", now.Add(32*time.Second)))
+	testutil.Equal(t, time.Duration(0), throttle.lockedOut("email:foo@example.com", now.Add(2*time.Minute)))
+	testutil.Equal(t, 2*time.Minute, throttle.fail("email:foo@example.com", now.Add(3*time.Minute)))
+	testutil.Equal(t, 4*time.Minute, throttle.fail("email:foo@example.com", now.Add(4*time.Minute)))
+	testutil.Equal(t, 5*time.Minute, throttle.fail("email:foo@example.com", now.Add(5*time.Minute)))
+
+	// failures outside the window are forgotten, as are those of a user who succeeds
+	testutil.Equal(t, time.Duration(0), throttle.fail("email:foo@example.com", now.Add(time.Hour)))
+	throttle.fail("email:foo@example.com", now.Add(time.Hour+time.Second))
+	throttle.succeed("email:foo@example.com")
+	testutil.Equal(t, time.Duration(0), throttle.fail("email:foo@example.com", now.Add(time.Hour+2*time.Second)))
+}
+
+func TestLoginLockout(t *testing.T) {
+	proxy, close := testNewOAuthProxy(t, SetLoginThrottle(newLoginThrottle(testLoginThrottleOptions())))
+	defer close()
+
+	newRequest := func(addr string) *http.Request {
+		req := httptest.NewRequest("GET", "https://localhost/", nil)
+		req.RemoteAddr = addr
+		return req
+	}
+
+	// failures of a user lock out the user, and failures from an address lock out the address
+	session := testSession()
+	for _, addr := range []string{"192.0.2.1:1234", "192.0.2.2:1234", "192.0.2.1:5678"} {
+		proxy.loginFailed(newRequest(addr), session, nil)
+	}
+
+	rw := httptest.NewRecorder()
+	testutil.Equal(t, true, proxy.loginLockedOut(rw, newRequest("198.51.100.1:1234"), session.Email, nil))
+	testutil.Equal(t, http.StatusTooManyRequests, rw.Code)
+	testutil.Equal(t, "60", rw.Header().Get("Retry-After"))
+	testutil.Equal(t, false, proxy.loginLockedOut(httptest.NewRecorder(), newRequest("198.51.100.1:1234"), "other@example.com", nil))
+
+	rw = httptest.NewRecorder()
+	proxy.OAuthStart(rw, newRequest("192.0.2.1:9999"), nil)
+	testutil.Equal(t, http.StatusFound, rw.Code)
+
+	// once an address is locked out, sign ins from it don't start, whoever the user is
+	other := testSession()
+	other.Email = "other@example.com"
+	proxy.loginFailed(newRequest("192.0.2.1:1234"), other, nil)
+	rw = httptest.NewRecorder()
+	proxy.OAuthStart(rw, newRequest("192.0.2.1:9999"), nil)
+	testutil.Equal(t, http.StatusTooManyRequests, rw.Code)
+
+	rw = httptest.NewRecorder()
+	proxy.OAuthStart(rw, newRequest("192.0.2.2:9999"), nil)
+	testutil.Equal(t, http.StatusFound, rw.Code)
+}
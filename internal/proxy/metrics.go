@@ -27,12 +27,19 @@ func newStatsdClient(opts *Options) (*statsd.Client, error) {
 func GetActionTag(req *http.Request) string {
 	// only log metrics for these paths and actions
 	pathToAction := map[string]string{
-		"/favicon.ico":     "favicon",
-		"/oauth2/sign_out": "sign_out",
-		"/oauth2/callback": "callback",
-		"/oauth2/auth":     "auth",
-		"/ping":            "ping",
-		"/robots.txt":      "robots",
+		"/favicon.ico":         "favicon",
+		"/oauth2/sign_out":     "sign_out",
+		"/oauth2/callback":     "callback",
+		"/oauth2/auth":         "auth",
+		"/oauth2/forward_auth": "forward_auth",
+		"/oauth2/session":      "session",
+		"/oauth2/ping":         "session_ping",
+		"/oauth2/silent_auth":  "silent_auth",
+		"/oauth2/device":       "device_verify",
+		"/oauth2/device/code":  "device_code",
+		"/oauth2/device/token": "device_token",
+		"/ping":                "ping",
+		"/robots.txt":          "robots",
 	}
 	// get the action from the url path
 	path := req.URL.Path
@@ -42,8 +49,9 @@ func GetActionTag(req *http.Request) string {
 	return "proxy"
 }
 
-// logMetrics logs all metrics surrounding a given request to the metricsWriter
-func logRequestMetrics(req *http.Request, requestDuration time.Duration, status int, StatsdClient *statsd.Client) {
+// logMetrics logs all metrics surrounding a given request to the metricsWriter, tagged with the
+// metadata of the upstream it was routed to, if any
+func logRequestMetrics(req *http.Request, requestDuration time.Duration, status int, metadataTags []string, StatsdClient *statsd.Client) {
 	// Normalize proxyHost for a) invalid requests or b) LB health checks to
 	// avoid polluting the proxy_host tag's value space
 	proxyHost := req.Host
@@ -61,6 +69,7 @@ func logRequestMetrics(req *http.Request, requestDuration time.Duration, status
 		fmt.Sprintf("action:%s", GetActionTag(req)),
 		fmt.Sprintf("proxy_host:%s", proxyHost),
 	}
+	tags = append(tags, metadataTags...)
 
 	// TODO: eventually make rates configurable
 	StatsdClient.Timing("request.duration", requestDuration, tags, 1.0)
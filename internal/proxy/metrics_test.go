@@ -101,6 +101,7 @@ func TestLogRequestMetrics(t *testing.T) {
 		requestURL   string
 		method       string
 		status       int
+		metadataTags []string
 		expectedTags []string
 	}{
 		{
@@ -143,6 +144,23 @@ func TestLogRequestMetrics(t *testing.T) {
 				"proxy_host:_healthcheck",
 			},
 		},
+		{
+			name:         "upstream metadata is added to tags",
+			requestURL:   "/ping",
+			method:       "GET",
+			status:       http.StatusOK,
+			metadataTags: []string{"team:payments", "tier:critical"},
+			expectedTags: []string{
+				"service:sso_proxy",
+				"method:GET",
+				fmt.Sprintf("status_code:%d", http.StatusOK),
+				"status_category:2xx",
+				"action:ping",
+				"proxy_host:_healthcheck",
+				"team:payments",
+				"tier:critical",
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -174,7 +192,7 @@ func TestLogRequestMetrics(t *testing.T) {
 			// check metrics
 			req := httptest.NewRequest(tc.method, tc.requestURL, nil)
 
-			logRequestMetrics(req, time.Millisecond*5, tc.status, client)
+			logRequestMetrics(req, time.Millisecond*5, tc.status, tc.metadataTags, client)
 			readBytes := make([]byte, len(expectedPacketString))
 			pc.ReadFrom(readBytes)
 			if expectedPacketString != string(readBytes) {
@@ -230,6 +248,16 @@ func TestGetActionTag(t *testing.T) {
 			url:            "/oauth2/auth?query=parameter",
 			expectedAction: "auth",
 		},
+		{
+			name:           "request with oauth2/forward_auth in the path",
+			url:            "/oauth2/forward_auth",
+			expectedAction: "forward_auth",
+		},
+		{
+			name:           "request with oauth2/device/token in the path",
+			url:            "/oauth2/device/token",
+			expectedAction: "device_token",
+		},
 		{
 			name:           "request with ping in the path",
 			url:            "/ping",
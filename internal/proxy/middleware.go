@@ -3,6 +3,7 @@ package proxy
 import (
 	"net/http"
 	"net/url"
+	"sort"
 )
 
 // With inspiration from https://github.com/unrolled/secure
@@ -14,16 +15,64 @@ var securityHeaders = map[string]string{
 	"X-XSS-Protection":       "1; mode=block",
 }
 
+var securityHeaderSet = newHeaderSet(securityHeaders)
+
+// headerSet is a set of headers computed once, when an upstream is configured, so that it can
+// be applied to each request without canonicalizing keys or allocating values.
+type headerSet []headerEntry
+
+type headerEntry struct {
+	key    string
+	values []string
+}
+
+// newHeaderSet returns the headers with canonical keys, sorted by key.
+func newHeaderSet(headers map[string]string) headerSet {
+	hs := make(headerSet, 0, len(headers))
+	for key, val := range headers {
+		hs = append(hs, headerEntry{
+			key: http.CanonicalHeaderKey(key),
+			// the capacity is capped so that adding values to a header copies them, rather than
+			// modifying those shared by every request
+			values: []string{val}[:1:1],
+		})
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].key < hs[j].key })
+	return hs
+}
+
+// keys returns the canonical keys of the headers.
+func (hs headerSet) keys() []string {
+	keys := make([]string, 0, len(hs))
+	for _, entry := range hs {
+		keys = append(keys, entry.key)
+	}
+	return keys
+}
+
+// set sets the headers, replacing any existing values.
+func (hs headerSet) set(h http.Header) {
+	for _, entry := range hs {
+		h[entry.key] = entry.values
+	}
+}
+
+// del deletes the headers.
+func (hs headerSet) del(h http.Header) {
+	for _, entry := range hs {
+		delete(h, entry.key)
+	}
+}
+
 // setHeaders ensures that every response includes some basic security headers.
 //
 // Note: the Strict-Transport-Security header is set by the requireHTTPS
 // middleware below, to avoid issues with development environments that must
 // allow plain HTTP.
 func setHeaders(h http.Handler, headers map[string]string) http.Handler {
+	hs := newHeaderSet(headers)
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		for key, val := range headers {
-			rw.Header().Set(key, val)
-		}
+		hs.set(rw.Header())
 		h.ServeHTTP(rw, req)
 	})
 }
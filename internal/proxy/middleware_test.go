@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestHeaderSet(t *testing.T) {
+	hs := newHeaderSet(map[string]string{
+		"x-team":        "docs",
+		"X-Environment": "production",
+	})
+	testutil.Equal(t, []string{"X-Environment", "X-Team"}, hs.keys())
+
+	h := http.Header{}
+	h.Set("X-Team", "support")
+	hs.set(h)
+	testutil.Equal(t, "docs", h.Get("X-Team"))
+	testutil.Equal(t, "production", h.Get("X-Environment"))
+
+	// adding values to a set header leaves those of the next request untouched
+	h.Add("X-Team", "support")
+	next := http.Header{}
+	hs.set(next)
+	testutil.Equal(t, []string{"docs"}, next["X-Team"])
+
+	hs.del(h)
+	testutil.Equal(t, http.Header{}, h)
+}
+
+func BenchmarkSetHeaders(b *testing.B) {
+	handler := setSecurityHeaders(setHeaders(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), map[string]string{
+		"cache-control": "no-store",
+		"X-Team":        "docs",
+	}))
+
+	req := httptest.NewRequest("GET", "https://localhost", nil)
+	rw := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(rw, req)
+	}
+}
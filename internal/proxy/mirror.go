@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// mirroredHeader is set on mirrored requests, so that the secondary upstream can tell them
+// apart from its own traffic.
+const mirroredHeader = "X-SSO-Mirrored"
+
+// maxInFlightMirrors is the number of mirrored requests of an upstream that can be in flight at
+// once. Requests are not mirrored while the secondary upstream is this far behind.
+const maxInFlightMirrors = 100
+
+// hopHeaders are only meaningful for a single connection, and aren't copied to mirrored requests.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// MirrorConfig maps to the mirror yaml config fields of an upstream:
+// * to - the url of the secondary upstream requests are mirrored to
+// * percent - the percentage of requests that are mirrored
+// * max_body_size - the largest request body, in bytes, that is mirrored, defaults to 64KiB
+// * timeout - the time a mirrored request may take, defaults to 5s
+type MirrorConfig struct {
+	To          string        `yaml:"to"`
+	Percent     float64       `yaml:"percent"`
+	MaxBodySize int64         `yaml:"max_body_size"`
+	Timeout     time.Duration `yaml:"timeout"`
+
+	toURL *url.URL
+}
+
+func (c *MirrorConfig) validate() error {
+	toURL, err := url.Parse(c.To)
+	if err != nil {
+		return err
+	}
+	if (toURL.Scheme != "http" && toURL.Scheme != "https") || toURL.Host == "" {
+		return fmt.Errorf("to must be an http or https url")
+	}
+	c.toURL = toURL
+
+	if c.Percent < 0 || c.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100")
+	}
+
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = 64 * 1024
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+	if c.MaxBodySize < 0 || c.Timeout < 0 {
+		return fmt.Errorf("max_body_size and timeout must not be negative")
+	}
+	return nil
+}
+
+// mirror sends a copy of a percentage of the requests of an upstream to a secondary upstream,
+// so that a replacement service can be validated against production traffic. Mirrored requests
+// are sent in the background once the request is proxied, and their responses are discarded.
+type mirror struct {
+	config       *MirrorConfig
+	service      string
+	handler      http.Handler
+	client       *http.Client
+	inFlight     chan struct{}
+	StatsdClient *statsd.Client
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// newMirrorHandler wraps the handler of an upstream, mirroring its requests.
+func newMirrorHandler(handler http.Handler, config *UpstreamConfig, statsdClient *statsd.Client) http.Handler {
+	return &mirror{
+		config:  config.Mirror,
+		service: config.Service,
+		handler: handler,
+		client: &http.Client{
+			Timeout:   config.Mirror.Timeout,
+			Transport: newUpstreamTransport(config),
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		inFlight:     make(chan struct{}, maxInFlightMirrors),
+		StatsdClient: statsdClient,
+		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// roll returns true for the configured percentage of calls.
+func (m *mirror) roll() bool {
+	if m.config.Percent <= 0 {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rand.Float64()*100 < m.config.Percent
+}
+
+func (m *mirror) incr(result string) {
+	m.StatsdClient.Incr("mirror_request", []string{"service:" + m.service, "result:" + result}, 1.0)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (m *mirror) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	// upgraded connections, e.g. websockets, can't be replayed
+	if req.Header.Get("Upgrade") != "" || !m.roll() {
+		m.handler.ServeHTTP(rw, req)
+		return
+	}
+
+	// the body is read up to the limit, and the part that was read is put back in front of the
+	// rest for the request that is proxied
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(req.Body, m.config.MaxBodySize+1))
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		if err != nil {
+			m.incr("body_error")
+			m.handler.ServeHTTP(rw, req)
+			return
+		}
+		if int64(len(body)) > m.config.MaxBodySize {
+			m.incr("body_too_large")
+			m.handler.ServeHTTP(rw, req)
+			return
+		}
+	}
+
+	mirrored := m.newMirroredRequest(req, body)
+	m.handler.ServeHTTP(rw, req)
+
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		m.incr("dropped")
+		return
+	}
+	go func() {
+		defer func() { <-m.inFlight }()
+		m.send(mirrored)
+	}()
+}
+
+// newMirroredRequest returns a copy of the request addressed to the secondary upstream. It is
+// built before the request is proxied, which may modify it.
+func (m *mirror) newMirroredRequest(req *http.Request, body []byte) *http.Request {
+	target := *m.config.toURL
+	target.Path = singleJoiningSlash(target.Path, req.URL.Path)
+	target.RawQuery = req.URL.RawQuery
+
+	mirrored := &http.Request{
+		Method:        req.Method,
+		URL:           &target,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header, len(req.Header)+1),
+		Host:          target.Host,
+		ContentLength: int64(len(body)),
+	}
+	for key, values := range req.Header {
+		mirrored.Header[key] = append([]string(nil), values...)
+	}
+	for _, h := range hopHeaders {
+		mirrored.Header.Del(h)
+	}
+	mirrored.Header.Set(mirroredHeader, "true")
+	if len(body) != 0 {
+		mirrored.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	return mirrored
+}
+
+// send sends a mirrored request, discarding its response.
+func (m *mirror) send(req *http.Request) {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		log.NewLogEntry().WithProxyHost(req.Host).Error(err, "error mirroring request")
+		m.incr("error")
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	m.incr("sent")
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestMirrorConfigValidate(t *testing.T) {
+	config := &MirrorConfig{To: "https://shadow.example.com/v2", Percent: 10}
+	testutil.Ok(t, config.validate())
+	testutil.Equal(t, int64(64*1024), config.MaxBodySize)
+	testutil.Equal(t, 5*time.Second, config.Timeout)
+
+	testCases := []struct {
+		config  *MirrorConfig
+		wantErr string
+	}{
+		{&MirrorConfig{To: "shadow.example.com"}, "to must be an http or https url"},
+		{&MirrorConfig{To: "https://shadow.example.com", Percent: 101}, "percent must be between 0 and 100"},
+		{&MirrorConfig{To: "https://shadow.example.com", MaxBodySize: -1}, "max_body_size and timeout must not be negative"},
+	}
+	for _, tc := range testCases {
+		err := tc.config.validate()
+		testutil.NotEqual(t, nil, err)
+		testutil.Equal(t, tc.wantErr, err.Error())
+	}
+}
+
+type mirroredRequest struct {
+	method string
+	uri    string
+	host   string
+	body   string
+	header http.Header
+}
+
+func testMirror(t *testing.T, percent float64, maxBodySize int64) (http.Handler, <-chan mirroredRequest, func()) {
+	mirrored := make(chan mirroredRequest, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		mirrored <- mirroredRequest{req.Method, req.URL.RequestURI(), req.Host, string(body), req.Header}
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	config := &UpstreamConfig{
+		Service: "foo",
+		Mirror:  &MirrorConfig{To: shadow.URL + "/v2", Percent: percent, MaxBodySize: maxBodySize},
+	}
+	testutil.Ok(t, config.Mirror.validate())
+
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// the upstream still receives the whole body
+		body, _ := ioutil.ReadAll(req.Body)
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(body)
+	})
+	return newMirrorHandler(upstream, config, nil), mirrored, shadow.Close
+}
+
+func TestMirror(t *testing.T) {
+	handler, mirrored, close := testMirror(t, 100, 0)
+	defer close()
+
+	req := httptest.NewRequest("POST", "https://foo.sso.dev/api/items?q=1", strings.NewReader("item"))
+	req.Header.Set("X-Forwarded-Email", "jane@example.com")
+	req.Header.Set("Connection", "keep-alive")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+
+	// the response of the secondary upstream is discarded
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, "item", rw.Body.String())
+
+	select {
+	case m := <-mirrored:
+		testutil.Equal(t, "POST", m.method)
+		testutil.Equal(t, "/v2/api/items?q=1", m.uri)
+		testutil.Equal(t, "item", m.body)
+		testutil.Equal(t, "jane@example.com", m.header.Get("X-Forwarded-Email"))
+		testutil.Equal(t, "true", m.header.Get(mirroredHeader))
+		testutil.Equal(t, "", m.header.Get("Keep-Alive"))
+	case <-time.After(time.Second):
+		t.Fatalf("expected the request to be mirrored")
+	}
+}
+
+func TestMirrorSkipsRequests(t *testing.T) {
+	testCases := []struct {
+		name    string
+		percent float64
+		body    string
+	}{
+		{
+			name:    "no requests are mirrored at zero percent",
+			percent: 0,
+			body:    "item",
+		},
+		{
+			name:    "bodies over the limit are not mirrored",
+			percent: 100,
+			body:    "a body over the limit",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, mirrored, close := testMirror(t, tc.percent, 8)
+			defer close()
+
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, httptest.NewRequest("POST", "https://foo.sso.dev/", strings.NewReader(tc.body)))
+			testutil.Equal(t, http.StatusOK, rw.Code)
+			testutil.Equal(t, tc.body, rw.Body.String())
+
+			select {
+			case <-mirrored:
+				t.Fatalf("expected the request not to be mirrored")
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
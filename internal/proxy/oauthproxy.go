@@ -12,10 +12,14 @@ import (
 	"time"
 
 	"github.com/buzzfeed/sso/internal/pkg/aead"
+	"github.com/buzzfeed/sso/internal/pkg/features"
+	"github.com/buzzfeed/sso/internal/pkg/fips"
+	"github.com/buzzfeed/sso/internal/pkg/geoip"
 	log "github.com/buzzfeed/sso/internal/pkg/logging"
 	"github.com/buzzfeed/sso/internal/pkg/options"
 	"github.com/buzzfeed/sso/internal/pkg/sessions"
 	"github.com/buzzfeed/sso/internal/proxy/providers"
+	"github.com/buzzfeed/sso/pkg/middleware"
 
 	"github.com/datadog/datadog-go/statsd"
 )
@@ -42,6 +46,7 @@ var (
 	ErrLifetimeExpired       = errors.New("user lifetime expired")
 	ErrUserNotAuthorized     = errors.New("user not authorized")
 	ErrWrongIdentityProvider = errors.New("user authenticated with wrong identity provider")
+	ErrMissingScopes         = errors.New("user hasn't granted the scopes required by the upstream")
 )
 
 type ErrOAuthProxyMisconfigured struct {
@@ -63,6 +68,27 @@ type OAuthProxy struct {
 
 	skipAuthPreflight bool
 	passAccessToken   bool
+	// buzzfeedCompat only sets the identity headers buzzfeed/sso sets
+	buzzfeedCompat bool
+	// featureFlags roll new behaviors out to a percentage of users, see featureEnabled
+	featureFlags *features.Flags
+
+	impersonationGroups []string
+	impersonationTTL    time.Duration
+
+	deviceTokens  *deviceTokenStore
+	geoipLocator  *geoip.Locator
+	anomalies     *anomalyMonitor
+	deprovisioner *deprovisioner
+	usage         *usageTracker
+	loginThrottle *loginThrottle
+	botDetector   *botDetector
+	honeypotPaths []string
+	postStash     *postStash
+	events        *eventNotifier
+	portal        *portal
+
+	backgroundRefresh *backgroundRefresher
 
 	StatsdClient *statsd.Client
 
@@ -76,15 +102,41 @@ type OAuthProxy struct {
 	handler        http.Handler
 	csrfStore      sessions.CSRFStore
 	sessionStore   sessions.SessionStore
+
+	// computed once from the upstream config, rather than on each request. upstreamHeaderKeys
+	// are the request headers that Authenticate sets on behalf of the upstream.
+	injectRequestHeaders headerSet
+	claimHeaders         []claimHeader
+	sessionClaims        map[string]struct{}
+	upstreamHeaderKeys   []string
 }
 
 // SetCookieStore sets the session and csrf stores as a functional option
 func SetCookieStore(opts *Options) func(*OAuthProxy) error {
 	return func(op *OAuthProxy) error {
+		cookieCipher := sessions.CreateMiscreantCookieCipher(opts.decodedCookieSecret)
+		switch {
+		case opts.cookieCipher != nil:
+			// the cookie secret was unwrapped with a KMS key, and is shared by every upstream
+			cookieCipher = func(c *sessions.CookieStore) error {
+				c.CookieCipher = opts.cookieCipher
+				return nil
+			}
+		case fips.Enabled():
+			cookieCipher = func(c *sessions.CookieStore) error {
+				cipher, err := newCookieCipher(opts.decodedCookieSecret)
+				if err != nil {
+					return fmt.Errorf("cookie-secret error: %s", err)
+				}
+				c.CookieCipher = cipher
+				return nil
+			}
+		}
 		cookieStore, err := sessions.NewCookieStore(opts.CookieName,
-			sessions.CreateMiscreantCookieCipher(opts.decodedCookieSecret),
+			cookieCipher,
 			func(c *sessions.CookieStore) error {
 				c.CookieDomain = opts.CookieDomain
+				c.DeriveCookieDomain = opts.CookieDeriveDomain
 				c.CookieHTTPOnly = opts.CookieHTTPOnly
 				c.CookieExpire = opts.CookieExpire
 				c.CookieSecure = opts.CookieSecure
@@ -149,6 +201,14 @@ func SetProxyHandler(handler http.Handler) func(*OAuthProxy) error {
 	}
 }
 
+// SetStatsdClient sets the statsd client as a functional option
+func SetStatsdClient(client *statsd.Client) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.StatsdClient = client
+		return nil
+	}
+}
+
 // SetValidator sets the email validator as a functional option
 func SetValidators(validators []options.Validator) func(*OAuthProxy) error {
 	return func(op *OAuthProxy) error {
@@ -169,6 +229,7 @@ func SetProvider(provider providers.Provider) func(*OAuthProxy) error {
 type StateParameter struct {
 	SessionID   string `json:"session_id"`
 	RedirectURI string `json:"redirect_uri"`
+	Silent      bool   `json:"silent,omitempty"`
 }
 
 // NewOAuthProxy creates a new OAuthProxy struct.
@@ -183,6 +244,11 @@ func NewOAuthProxy(opts *Options, optFuncs ...func(*OAuthProxy) error) (*OAuthPr
 
 		skipAuthPreflight: opts.SkipAuthPreflight,
 		passAccessToken:   opts.PassAccessToken,
+		buzzfeedCompat:    opts.BuzzfeedCompat,
+		featureFlags:      opts.featureFlags,
+
+		impersonationGroups: opts.ImpersonationGroups,
+		impersonationTTL:    opts.ImpersonationTTL,
 	}
 
 	for _, optFunc := range optFuncs {
@@ -224,6 +290,21 @@ func NewOAuthProxy(opts *Options, optFuncs ...func(*OAuthProxy) error) (*OAuthPr
 		}
 	}
 
+	p.botDetector = newBotDetector(p.upstreamConfig.BotDetection)
+	p.injectRequestHeaders = newHeaderSet(p.upstreamConfig.InjectRequestHeaders)
+	if !p.buzzfeedCompat {
+		p.claimHeaders = newClaimHeaders(p.upstreamConfig.ClaimHeaders)
+	}
+	p.upstreamHeaderKeys = append([]string{
+		"X-Forwarded-User",
+		"X-Forwarded-Email",
+		"X-Forwarded-Groups",
+		"X-Forwarded-Access-Token",
+		"X-Impersonated-By",
+		degradedHeader,
+	}, p.injectRequestHeaders.keys()...)
+	p.upstreamHeaderKeys = append(p.upstreamHeaderKeys, claimHeaderKeys(p.claimHeaders)...)
+
 	return p, nil
 }
 
@@ -236,7 +317,22 @@ func (p *OAuthProxy) Handler() http.Handler {
 	mux.HandleFunc("/oauth2/sign_out", p.SignOut)
 	mux.HandleFunc("/oauth2/callback", p.OAuthCallback)
 	mux.HandleFunc("/oauth2/auth", p.AuthenticateOnly)
-	mux.HandleFunc("/", p.Proxy)
+	mux.HandleFunc("/oauth2/session", p.SessionInfo)
+	mux.HandleFunc(sessionPingPath, p.SessionPing)
+	mux.HandleFunc(silentAuthPath, p.SilentAuth)
+	mux.HandleFunc("/oauth2/session/refresh", p.ForceRefresh)
+	mux.HandleFunc("/oauth2/impersonate", p.Impersonate)
+	mux.HandleFunc("/oauth2/impersonate/stop", p.StopImpersonating)
+	mux.HandleFunc(acknowledgePath, p.Acknowledge)
+	mux.HandleFunc(botChallengePath, p.BotChallenge)
+	mux.HandleFunc("/oauth2/device", p.DeviceVerify)
+	mux.HandleFunc("/oauth2/device/code", p.DeviceCode)
+	mux.HandleFunc("/oauth2/device/token", p.DeviceToken)
+	if p.portal != nil {
+		mux.HandleFunc("/", p.Portal)
+	} else {
+		mux.HandleFunc("/", p.Proxy)
+	}
 
 	// Global middleware, which will be applied to each request in reverse
 	// order as applied here (i.e., we want to validate the host _first_ when
@@ -246,7 +342,12 @@ func (p *OAuthProxy) Handler() http.Handler {
 		handler = requireHTTPS(handler)
 	}
 	handler = p.setResponseHeaderOverrides(p.upstreamConfig, handler)
+	handler = p.detectBots(handler)
+	handler = p.trapHoneypots(handler)
+	handler = p.setGeoLocation(handler)
+	handler = p.setUpstreamMetadata(handler)
 	handler = setSecurityHeaders(handler)
+	handler = withSessionContext(handler)
 
 	return handler
 }
@@ -283,6 +384,7 @@ func (p *OAuthProxy) redeemCode(host, code string) (*sessions.SessionState, erro
 	if s.Email == "" {
 		return s, errors.New("invalid email address")
 	}
+	p.minimizeClaims(s)
 	return s, nil
 }
 
@@ -311,15 +413,20 @@ func (p *OAuthProxy) Favicon(rw http.ResponseWriter, req *http.Request) {
 	p.Proxy(rw, req)
 }
 
-// XHRError returns a simple error response with an error message to the application if the request is an XML request
+// XHRError returns a simple error response with an error message to the application if the request
+// is an XML request, or otherwise wants JSON, see wantsJSON
 func (p *OAuthProxy) XHRError(rw http.ResponseWriter, req *http.Request, code int, err error) {
 	remoteAddr := getRemoteAddr(req)
-	logger := log.NewLogEntry().WithRemoteAddress(remoteAddr)
+	logger := log.NewLogEntry().WithRemoteAddress(remoteAddr).WithRequestID(requestID(req))
 
 	jsonError := struct {
-		Error error `json:"error"`
+		Error     string `json:"error"`
+		Status    int    `json:"status"`
+		RequestID string `json:"request_id,omitempty"`
 	}{
-		Error: err,
+		Error:     err.Error(),
+		Status:    code,
+		RequestID: requestID(req),
 	}
 
 	jsonBytes, err := json.Marshal(jsonError)
@@ -336,32 +443,34 @@ func (p *OAuthProxy) XHRError(rw http.ResponseWriter, req *http.Request, code in
 
 // ErrorPage renders an error page with a given status code, title, and message.
 func (p *OAuthProxy) ErrorPage(rw http.ResponseWriter, req *http.Request, code int, title string, message string) {
-	if p.isXHR(req) {
+	if p.wantsJSON(req) {
 		p.XHRError(rw, req, code, errors.New(message))
 		return
 	}
 
 	remoteAddr := getRemoteAddr(req)
-	logger := log.NewLogEntry().WithRemoteAddress(remoteAddr)
+	logger := log.NewLogEntry().WithRemoteAddress(remoteAddr).WithRequestID(requestID(req))
 
 	logger.WithHTTPStatus(code).WithPageTitle(title).WithPageMessage(message).Info(
 		"error page")
 	rw.WriteHeader(code)
 	t := struct {
-		Code    int
-		Title   string
-		Message string
+		Code      int
+		Title     string
+		Message   string
+		RequestID string
 	}{
-		Code:    code,
-		Title:   title,
-		Message: message,
+		Code:      code,
+		Title:     title,
+		Message:   message,
+		RequestID: requestID(req),
 	}
 	p.templates.ExecuteTemplate(rw, "error.html", t)
 }
 
 // IsWhitelistedRequest cheks that proxy host exists and checks the SkipAuthRegex
 func (p *OAuthProxy) IsWhitelistedRequest(req *http.Request) bool {
-	if p.skipAuthPreflight && req.Method == "OPTIONS" {
+	if p.isSkippedPreflight(req) {
 		return true
 	}
 
@@ -381,6 +490,9 @@ func (p *OAuthProxy) isXHR(req *http.Request) bool {
 
 // SignOut redirects the request to the provider's sign out url.
 func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
+	if session, err := p.loadSession(req); err == nil {
+		p.notifyEvent(req, eventSignOut, session.Email, nil, "")
+	}
 	p.sessionStore.ClearSession(rw, req)
 
 	var scheme string
@@ -405,17 +517,27 @@ func (p *OAuthProxy) SignOut(rw http.ResponseWriter, req *http.Request) {
 
 // OAuthStart begins the authentication flow, encrypting the redirect url in a request to the provider's sign in endpoint.
 func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request, tags []string) {
+	p.startOAuthFlow(rw, req, tags, false)
+}
+
+// startOAuthFlow begins the authentication flow. A silent flow asks the authenticator to
+// answer without showing the user anything, see SilentAuth.
+func (p *OAuthProxy) startOAuthFlow(rw http.ResponseWriter, req *http.Request, tags []string, silent bool) {
 	// The proxy redirects to the authenticator, and provides it with redirectURI (which points
 	// back to the sso proxy).
 	logger := log.NewLogEntry()
 	remoteAddr := getRemoteAddr(req)
 
-	if p.isXHR(req) {
+	if p.wantsJSON(req) {
 		logger.WithRemoteAddress(remoteAddr).Error("aborting start of oauth flow on XHR")
 		p.XHRError(rw, req, http.StatusUnauthorized, errors.New("cannot continue oauth flow on xhr"))
 		return
 	}
 
+	if p.loginLockedOut(rw, req, "", tags) || p.botSignIn(rw, req) {
+		return
+	}
+
 	requestURI := req.URL.String()
 	callbackURL := p.GetRedirectURL(req.Host)
 
@@ -440,13 +562,19 @@ func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request, tags
 	//
 	// * state: Defined by the OAuth2 RFC https://tools.ietf.org/html/rfc6749.
 	//          Used to prevent cross site forgery and maintain state across the client and server.
+	//
+	// * prompt: Defined by the OpenID Connect spec https://openid.net/specs/openid-connect-core-1_0.html.
+	//           Set to "none" for silent flows, which the authenticator answers with an error
+	//           rather than a sign in page.
 
 	key := aead.GenerateKey()
 
 	state := &StateParameter{
 		SessionID:   fmt.Sprintf("%x", key),
 		RedirectURI: requestURI,
+		Silent:      silent,
 	}
+	p.stashPost(req, state.SessionID)
 
 	// we encrypt this value to be opaque the browser cookie
 	// this value will be unique since we always use a randomized nonce as part of marshaling
@@ -472,6 +600,11 @@ func (p *OAuthProxy) OAuthStart(rw http.ResponseWriter, req *http.Request, tags
 	}
 
 	signinURL := p.provider.GetSignInURL(callbackURL, encryptedState)
+	if silent {
+		params := signinURL.Query()
+		params.Set("prompt", "none")
+		signinURL.RawQuery = params.Encode()
+	}
 	logger.WithSignInURL(signinURL).Info("starting OAuth flow")
 	http.Redirect(rw, req, signinURL.String(), http.StatusFound)
 }
@@ -497,12 +630,22 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 	}
 	errorString := req.Form.Get("error")
 	if errorString != "" {
+		// a silent sign in that needs the user tells the page that started it, so that it can
+		// fall back to an interactive sign in
+		if p.silentAuthFailed(rw, req, errorString) {
+			return
+		}
 		tags = append(tags, "error:callback_error_exists")
 		p.StatsdClient.Incr("application_error", tags, 1.0)
 		p.ErrorPage(rw, req, http.StatusForbidden, "Permission Denied", errorString)
 		return
 	}
 
+	// locked out clients aren't let near the provider
+	if p.loginLockedOut(rw, req, "", tags) {
+		return
+	}
+
 	// We begin the process of redeeming the code for an access token.
 	session, err := p.redeemCode(req.Host, req.Form.Get("code"))
 	if err != nil {
@@ -564,6 +707,10 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if p.loginLockedOut(rw, req, session.Email, tags) {
+		return
+	}
+
 	// We validate the user information, and check that this user has proper authorization
 	// for the resources requested.
 	//
@@ -582,9 +729,16 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 		}
 		errorMsg := fmt.Sprintf("We ran into some issues while validating your account: \"%s\"",
 			strings.Join(formattedErrors, ", "))
+		p.notifyEvent(req, eventAuthorizationDenied, session.Email, session.Groups, strings.Join(formattedErrors, ", "))
+		p.loginFailed(req, session, tags)
+		if stateParameter.Silent {
+			p.renderSilentAuth(rw, req, &silentAuthResult{Result: "access_denied"})
+			return
+		}
 		p.ErrorPage(rw, req, http.StatusForbidden, "Permission Denied", errorMsg)
 		return
 	}
+	p.loginSucceeded(session)
 
 	logger.WithRemoteAddress(remoteAddr).WithUser(session.Email).WithInGroups(session.Groups).Info(
 		fmt.Sprintf("oauth callback: user validated "))
@@ -601,6 +755,22 @@ func (p *OAuthProxy) OAuthCallback(rw http.ResponseWriter, req *http.Request) {
 
 	// Now that we know the request and user is valid, clear the CSRF token
 	p.csrfStore.ClearCSRF(rw, req)
+	p.notifyEvent(req, eventSignIn, session.Email, session.Groups, "")
+
+	if stateParameter.Silent {
+		p.StatsdClient.Incr("silent_auth_renewed", tags, 1.0)
+		p.renderSilentAuth(rw, req, &silentAuthResult{
+			Result:    "renewed",
+			Email:     session.Email,
+			ExpiresIn: expiresIn(session),
+		})
+		return
+	}
+
+	// A form submission that started the sign in is resubmitted, rather than lost
+	if p.replayPost(rw, req, stateParameter.SessionID, session.Email) {
+		return
+	}
 
 	// This is the redirect back to the original requested application
 	http.Redirect(rw, req, stateParameter.RedirectURI, http.StatusFound)
@@ -618,95 +788,217 @@ func (p *OAuthProxy) AuthenticateOnly(rw http.ResponseWriter, req *http.Request)
 	rw.WriteHeader(http.StatusAccepted)
 }
 
+// Authorize authenticates and authorizes a request without proxying it, for use by
+// external authorization servers. Authorized requests receive a 200 response carrying
+// the identity headers that would have been sent to the upstream, while all other
+// requests receive the same redirect or error page the Proxy handler would serve.
+func (p *OAuthProxy) Authorize(rw http.ResponseWriter, req *http.Request) {
+	tags := []string{"action:authorize"}
+	var session *sessions.SessionState
+	var err error
+
+	if !p.methodAllowed(rw, req, tags) {
+		return
+	}
+
+	if p.IsWhitelistedRequest(req) {
+		tags = append(tags, "auth_type:whitelisted")
+	} else {
+		tags = append(tags, "auth_type:authenticated")
+		session, err = p.authenticateAndAcknowledge(rw, req)
+	}
+
+	if err != nil {
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+	p.observeActivity(req, session, 0)
+
+	// the keys are canonical, so the headers can be read and written directly
+	for _, key := range p.upstreamHeaderKeys {
+		if vals := req.Header[key]; len(vals) > 0 && vals[0] != "" {
+			rw.Header()[key] = vals[:1:1]
+		}
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// AuthorizeHandler returns a http handler serving Authorize for an OAuthProxy
+func (p *OAuthProxy) AuthorizeHandler() http.Handler {
+	return withSessionContext(p.setUpstreamMetadata(p.setGeoLocation(http.HandlerFunc(p.Authorize))))
+}
+
 // Proxy authenticates a request, either proxying the request if it is authenticated, or starting the authentication process if not.
 func (p *OAuthProxy) Proxy(rw http.ResponseWriter, req *http.Request) {
 	// Attempts to validate the user and their cookie.
-	logger := log.NewLogEntry()
 	start := time.Now()
 	tags := []string{"action:proxy"}
+	var session *sessions.SessionState
 	var err error
 
+	if !p.methodAllowed(rw, req, tags) {
+		return
+	}
+
 	// If the request is explicitly whitelisted, we skip authentication
 	if p.IsWhitelistedRequest(req) {
 		tags = append(tags, "auth_type:whitelisted")
 	} else {
 		tags = append(tags, "auth_type:authenticated")
-		err = p.Authenticate(rw, req)
+		session, err = p.authenticateAndAcknowledge(rw, req)
 	}
 
 	// If the authentication is not successful we proceed to start the OAuth Flow with
 	// OAuthStart. If authentication is successful, we proceed to proxy to the configured
 	// upstream.
 	if err != nil {
-		switch err {
-		case http.ErrNoCookie:
-			// No cookie is set, start the oauth flow
-			p.OAuthStart(rw, req, tags)
-			return
-		case ErrLifetimeExpired:
-			// User's lifetime expired, we trigger the start of the oauth flow
-			p.OAuthStart(rw, req, tags)
-			return
-		case ErrWrongIdentityProvider:
-			// User is authenticated with the incorrect provider. This most common non-malicious
-			// case occurs when an upstream has been transitioned to a different provider but
-			// the user has a stale sesssion.
-			p.OAuthStart(rw, req, tags)
-			return
-		case sessions.ErrInvalidSession:
-			// The user session is invalid and we can't decode it.
-			// This can happen for a variety of reasons but the most common non-malicious
-			// case occurs when the session encoding schema changes. We manage this ux
-			// by triggering the start of the oauth flow.
-			p.OAuthStart(rw, req, tags)
-			return
-		case ErrUserNotAuthorized:
-			tags = append(tags, "error:user_unauthorized")
-			p.StatsdClient.Incr("application_error", tags, 1.0)
-			// We know the user is not authorized for the request, we show them a forbidden page
-			p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "You're not authorized to view this page")
-			return
-		case providers.ErrTokenRevoked:
-			p.ErrorPage(rw, req, http.StatusUnauthorized, "Unauthorized", "Token Expired or Revoked")
-			return
-		default:
-			logger.Error(err, "unknown error authenticating user")
-			tags = append(tags, "error:internal_error")
-			p.StatsdClient.Incr("application_error", tags, 1.0)
-			// We don't know exactly what happened, but authenticating the user failed, show an error
-			p.ErrorPage(rw, req, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred")
-			return
-		}
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+
+	if session != nil {
+		setSessionExpiresIn(rw, session)
 	}
 
 	overhead := time.Now().Sub(start)
 	p.StatsdClient.Timing("request_overhead", overhead, tags, 1.0)
 
-	p.handler.ServeHTTP(rw, req)
+	var upstreamRW http.ResponseWriter = rw
+	if timing := requestServerTiming(req); timing != nil {
+		timing.auth = start
+		timing.upstream = start.Add(overhead)
+		upstreamRW = &serverTimingWriter{ResponseWriter: rw, timing: timing}
+	}
+
+	p.handler.ServeHTTP(upstreamRW, req)
+	p.observeActivity(req, session, responseStatus(rw))
+	p.recordUsage(session)
+}
+
+// handleAuthenticationError responds to a request that failed authentication, either
+// starting the OAuth flow or rendering an appropriate error page.
+func (p *OAuthProxy) handleAuthenticationError(rw http.ResponseWriter, req *http.Request, err error, tags []string) {
+	logger := log.NewLogEntry()
+
+	switch err {
+	case http.ErrNoCookie:
+		// No cookie is set, start the oauth flow
+		p.OAuthStart(rw, req, tags)
+	case ErrLifetimeExpired:
+		// User's lifetime expired, we trigger the start of the oauth flow
+		p.OAuthStart(rw, req, tags)
+	case ErrReauthenticationRequired:
+		// Suspicious activity was detected on the user's session, we trigger the start of the oauth flow
+		p.OAuthStart(rw, req, tags)
+	case ErrWrongIdentityProvider:
+		// User is authenticated with the incorrect provider. This most common non-malicious
+		// case occurs when an upstream has been transitioned to a different provider but
+		// the user has a stale sesssion.
+		p.OAuthStart(rw, req, tags)
+	case ErrMissingScopes:
+		// The upstream requires scopes the user hasn't granted, we trigger the start of the oauth
+		// flow, which requests consent to them
+		p.OAuthStart(rw, req, tags)
+	case sessions.ErrInvalidSession:
+		// The user session is invalid and we can't decode it.
+		// This can happen for a variety of reasons but the most common non-malicious
+		// case occurs when the session encoding schema changes. We manage this ux
+		// by triggering the start of the oauth flow.
+		p.OAuthStart(rw, req, tags)
+	case ErrUserNotAuthorized:
+		tags = append(tags, "error:user_unauthorized")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		// We know the user is not authorized for the request, we show them a forbidden page
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "You're not authorized to view this page")
+	case ErrUserDeprovisioned:
+		tags = append(tags, "error:user_deprovisioned")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		// The user was deactivated by the identity provider, signing in again won't help
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Your account has been deactivated")
+	case providers.ErrTokenRevoked:
+		p.ErrorPage(rw, req, http.StatusUnauthorized, "Unauthorized", "Token Expired or Revoked")
+	case ErrAcknowledgementRequired:
+		// The user must acknowledge the upstream's interstitial page before proceeding
+		p.redirectToAcknowledge(rw, req, tags)
+	case ErrProviderDegraded:
+		tags = append(tags, "error:provider_degraded")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		// The upstream doesn't serve this request while the provider is unavailable, the user
+		// can try again once it recovers
+		p.ErrorPage(rw, req, http.StatusServiceUnavailable, "Service Unavailable",
+			"Sign in is temporarily unavailable, so this request can't be served. Please try again later.")
+	case ErrInvalidBearerToken:
+		tags = append(tags, "error:invalid_bearer_token")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		// Bearer tokens are used by command line tools, which can't follow the oauth flow
+		rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(rw, http.StatusUnauthorized, "invalid_token")
+	default:
+		logger.Error(err, "unknown error authenticating user")
+		tags = append(tags, "error:internal_error")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		// We don't know exactly what happened, but authenticating the user failed, show an error
+		p.ErrorPage(rw, req, http.StatusInternalServerError, "Internal Error", "An unexpected error occurred")
+	}
 }
 
 // Authenticate authenticates a request by checking for a session cookie, and validating its expiration,
 // clearing the session cookie if it's invalid and returning an error if necessary..
-func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (err error) {
-	logger := log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req))
+// Requests carrying a bearer token minted by the device flow are authenticated by that
+// token instead.
+func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) error {
+	_, err := p.authenticateRequest(rw, req)
+	return err
+}
+
+// authenticateRequest implements Authenticate, returning the authenticated session, which
+// is nil for requests authenticated by a bearer token.
+func (p *OAuthProxy) authenticateRequest(rw http.ResponseWriter, req *http.Request) (*sessions.SessionState, error) {
+	if rawToken, ok := p.bearerToken(req); ok {
+		return nil, p.authenticateBearerToken(rw, req, rawToken)
+	}
+	return p.authenticate(rw, req)
+}
+
+// authenticateAndAcknowledge authenticates a request, additionally requiring the session
+// to have acknowledged the upstream's interstitial page, if it has one.
+func (p *OAuthProxy) authenticateAndAcknowledge(rw http.ResponseWriter, req *http.Request) (*sessions.SessionState, error) {
+	session, err := p.authenticateRequest(rw, req)
+	if err != nil {
+		return nil, err
+	}
+	if session != nil && p.requiresAcknowledgement(session, req) {
+		return nil, ErrAcknowledgementRequired
+	}
+	return session, nil
+}
+
+// authenticate implements Authenticate, returning the authenticated session.
+func (p *OAuthProxy) authenticate(rw http.ResponseWriter, req *http.Request) (session *sessions.SessionState, err error) {
+	logger := requestLogEntry(req)
 
 	remoteAddr := getRemoteAddr(req)
 	tags := []string{"action:authenticate"}
 
 	allowedGroups := p.upstreamConfig.AllowedGroups
 
-	// Clear the session cookie if anything goes wrong.
+	// Clear the session cookie if anything goes wrong, except when the request is rejected
+	// while the provider is unavailable, as the session is still valid once it recovers, or
+	// when consent to more scopes is required, as the session is still valid for other upstreams.
 	defer func() {
 		if err != nil {
-			p.sessionStore.ClearSession(rw, req)
+			if err != ErrProviderDegraded && err != ErrMissingScopes {
+				p.sessionStore.ClearSession(rw, req)
+			}
+			setAuthenticatedSession(req, nil)
 		}
 	}()
 
-	session, err := p.sessionStore.LoadSession(req)
+	session, err = p.loadSession(req)
 	if err != nil {
 		// We loaded a cookie but it wasn't valid, clear it, and reject the request
 		logger.Error(err, "error authenticating user")
-		return err
+		return nil, err
 	}
 
 	// check if this session belongs to the correct identity provider application.
@@ -715,7 +1007,40 @@ func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (er
 	if session.ProviderSlug != p.provider.Data().ProviderSlug {
 		logger.WithUser(session.Email).Info(
 			"authenticated with incorrect identity provider; restarting authentication")
-		return ErrWrongIdentityProvider
+		return nil, ErrWrongIdentityProvider
+	}
+
+	// The upstream requires scopes the user hasn't granted yet, which the authenticator requests
+	// consent to before issuing a new session.
+	if missing := session.MissingScopes(p.upstreamConfig.Scopes); len(missing) != 0 {
+		logger.WithUser(session.Email).Info(
+			fmt.Sprintf("session is missing scopes %q; restarting authentication", missing))
+		return nil, ErrMissingScopes
+	}
+
+	// Suspicious activity was detected on this session, so the user must sign in again.
+	if p.anomalies != nil && p.anomalies.reauthenticationRequired(session) {
+		logger.WithUser(session.Email).Info(
+			"suspicious activity detected; restarting authentication")
+		p.notifyEvent(req, eventSessionRevoked, session.Email, nil, "suspicious activity detected")
+		return nil, ErrReauthenticationRequired
+	}
+
+	// The user was deactivated by the identity provider since the session was issued.
+	if p.deprovisioner.isDenied(session.Email, time.Now()) {
+		logger.WithUser(session.Email).Info("user deprovisioned by the identity provider")
+		return nil, ErrUserDeprovisioned
+	}
+
+	// Apply the result of refreshing or validating the session in the background, if it
+	// completed since the previous request.
+	if p.backgroundRefresh != nil && p.backgroundRefresh.apply(p.provider, session, allowedGroups) {
+		err = p.sessionStore.SaveSession(rw, req, session)
+		if err != nil {
+			logger.WithUser(session.Email).Error(
+				err, "could not save session refreshed in the background")
+			return nil, err
+		}
 	}
 
 	// Lifetime period is the entire duration in which the session is valid.
@@ -724,7 +1049,7 @@ func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (er
 		// session lifetime has expired, we reject the request and clear the cookie
 		logger.WithUser(session.Email).Info(
 			"lifetime has expired; restarting authentication")
-		return ErrLifetimeExpired
+		return nil, ErrLifetimeExpired
 	} else if session.RefreshPeriodExpired() {
 		// Refresh period is the period in which the access token is valid. This is ultimately
 		// controlled by the upstream provider and tends to be around 1 hour.
@@ -733,7 +1058,10 @@ func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (er
 		// clear the cookie and reject the request
 		if err != nil {
 			logger.WithUser(session.Email).Error(err, "refreshing session failed")
-			return err
+			if err == providers.ErrTokenRevoked {
+				p.notifyEvent(req, eventSessionRevoked, session.Email, nil, "token revoked by provider")
+			}
+			return nil, err
 		}
 
 		if !ok {
@@ -741,7 +1069,8 @@ func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (er
 			// clear the cookie and reject the request
 			logger.WithUser(session.Email).Info(
 				"not authorized after refreshing session")
-			return ErrUserNotAuthorized
+			p.notifyEvent(req, eventAuthorizationDenied, session.Email, session.Groups, "not authorized after refreshing session")
+			return nil, ErrUserNotAuthorized
 		}
 
 		err = p.sessionStore.SaveSession(rw, req, session)
@@ -752,7 +1081,7 @@ func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (er
 			// But, we clear the session cookie and reject the request!
 			logger.WithUser(session.Email).Error(
 				err, "could not save refreshed session")
-			return err
+			return nil, err
 		}
 	} else if session.ValidationPeriodExpired() {
 		// Validation period has expired, this is the shortest interval we use to
@@ -766,7 +1095,8 @@ func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (er
 			// Clear the cookie and reject the request
 			logger.WithUser(session.Email).Error(
 				err, "no longer authorized after validation period")
-			return ErrUserNotAuthorized
+			p.notifyEvent(req, eventAuthorizationDenied, session.Email, session.Groups, "no longer authorized after validation period")
+			return nil, ErrUserNotAuthorized
 		}
 
 		err = p.sessionStore.SaveSession(rw, req, session)
@@ -777,7 +1107,7 @@ func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (er
 			// But, we clear the session cookie and reject the request!
 			logger.WithUser(session.Email).Error(
 				err, "could not save validated session")
-			return err
+			return nil, err
 		}
 	}
 
@@ -795,16 +1125,58 @@ func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (er
 				p.StatsdClient.Incr("application_error", tags, 1.0)
 				logger.WithRemoteAddress(remoteAddr).WithUser(session.Email).Info(
 					fmt.Sprintf("permission denied: unauthorized: %q", err))
-				return ErrUserNotAuthorized
+				p.notifyEvent(req, eventAuthorizationDenied, session.Email, session.Groups, err.Error())
+				return nil, ErrUserNotAuthorized
 			}
 		}
 	}
 
+	if session.ImpersonationExpired() {
+		logger.WithUser(session.Email).WithImpersonatedUser(session.Impersonation.Email).Info(
+			"impersonation expired")
+		session.Impersonation = nil
+		err = p.sessionStore.SaveSession(rw, req, session)
+		if err != nil {
+			logger.WithUser(session.Email).Error(
+				err, "could not save session after impersonation expired")
+			return nil, err
+		}
+	}
+
+	// The session is only honored for the grace period while the provider is unavailable, so
+	// the upstream's policy decides whether the request is served.
+	if err := p.checkDegraded(req, session); err != nil {
+		logger.WithRemoteAddress(remoteAddr).WithUser(session.Email).Info(
+			fmt.Sprintf("rejected by degraded_mode %q while the provider is unavailable", p.degradedMode()))
+		return nil, err
+	}
+
 	logger.WithRemoteAddress(remoteAddr).WithUser(session.Email).Info(
 		fmt.Sprintf("authentication: user validated"))
 
-	for key, val := range p.upstreamConfig.InjectRequestHeaders {
-		req.Header.Set(key, val)
+	// record the session so that it can be logged later (see func logRequest)
+	setAuthenticatedSession(req, session)
+
+	if p.backgroundRefresh != nil {
+		p.backgroundRefresh.refreshIfDue(p.provider, session, allowedGroups)
+	}
+
+	p.injectRequestHeaders.set(req.Header)
+
+	// never trust an impersonation header sent by the client
+	req.Header.Del("X-Impersonated-By")
+
+	req.Header.Del(degradedHeader)
+	if isDegraded(session) && !p.buzzfeedCompat {
+		req.Header.Set(degradedHeader, p.degradedMode())
+	}
+
+	// the claims of an impersonating session are those of the administrator, so they're never passed
+	p.delClaimHeaders(req)
+
+	if session.ImpersonatingOn(req.Host) {
+		p.setImpersonationHeaders(req, session)
+		return session, nil
 	}
 
 	req.Header.Set("X-Forwarded-User", session.User)
@@ -815,10 +1187,9 @@ func (p *OAuthProxy) Authenticate(rw http.ResponseWriter, req *http.Request) (er
 
 	req.Header.Set("X-Forwarded-Email", session.Email)
 	req.Header.Set("X-Forwarded-Groups", strings.Join(session.Groups, ","))
-
-	// stash authenticated user so that it can be logged later (see func logRequest)
-	rw.Header().Set(loggingUserHeader, session.Email)
+	p.setClaimHeaders(req, session)
+	setForwardedIdentity(req, middleware.Identity{User: session.User, Email: session.Email, Groups: session.Groups})
 
 	// This user has been OK'd. Allow the request!
-	return nil
+	return session, nil
 }
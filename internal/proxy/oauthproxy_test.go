@@ -83,7 +83,7 @@ func testSession() *sessions.SessionState {
 	}
 }
 
-func testHTTPBin(t *testing.T) (*url.URL, func()) {
+func testHTTPBin(t testing.TB) (*url.URL, func()) {
 	h := httpbin.NewHTTPBin()
 
 	backend := httptest.NewServer(h.Handler())
@@ -92,7 +92,7 @@ func testHTTPBin(t *testing.T) (*url.URL, func()) {
 	return backendURL, backend.Close
 }
 
-func testNewOAuthProxy(t *testing.T, optFuncs ...func(*OAuthProxy) error) (*OAuthProxy, func()) {
+func testNewOAuthProxy(t testing.TB, optFuncs ...func(*OAuthProxy) error) (*OAuthProxy, func()) {
 	backendURL, close := testHTTPBin(t)
 
 	opts := NewOptions()
@@ -272,6 +272,61 @@ func TestAuthOnlyEndpoint(t *testing.T) {
 	}
 }
 
+func TestAuthorize(t *testing.T) {
+	testCases := []struct {
+		name            string
+		validEmail      bool
+		sessionStore    *sessions.MockSessionStore
+		expectedCode    int
+		expectedHeaders map[string]string
+	}{
+		{
+			name: "authorized requests return upstream headers",
+			sessionStore: &sessions.MockSessionStore{
+				Session: testSession(),
+			},
+			validEmail:   true,
+			expectedCode: http.StatusOK,
+			expectedHeaders: map[string]string{
+				"X-Forwarded-Email":  "michael.bland@gsa.gov",
+				"X-Forwarded-Groups": "foo,bar",
+			},
+		},
+		{
+			name:         "unauthenticated requests are redirected to sign in",
+			sessionStore: &sessions.MockSessionStore{},
+			validEmail:   true,
+			expectedCode: http.StatusFound,
+		},
+		{
+			name: "unauthorized requests are forbidden",
+			sessionStore: &sessions.MockSessionStore{
+				Session: testSession(),
+			},
+			expectedCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t,
+				setSessionStore(tc.sessionStore),
+				SetValidators([]options.Validator{options.NewMockValidator(tc.validEmail)}),
+			)
+			defer close()
+
+			req := httptest.NewRequest("GET", "https://localhost/foo", nil)
+			resp := httptest.NewRecorder()
+
+			proxy.Authorize(resp, req)
+			testutil.Equal(t, tc.expectedCode, resp.Code)
+			for key, val := range tc.expectedHeaders {
+				testutil.Equal(t, val, resp.Header().Get(key))
+			}
+		})
+	}
+}
+
 func TestSkipAuthRequest(t *testing.T) {
 	// start an upstream server
 	testCases := []struct {
@@ -610,6 +665,26 @@ func TestAuthenticate(t *testing.T) {
 	}
 }
 
+// BenchmarkAuthenticate measures the authenticated hot path: validating the session and
+// injecting the identity and configured headers into the upstream request.
+func BenchmarkAuthenticate(b *testing.B) {
+	proxy, close := testNewOAuthProxy(b)
+	defer close()
+	proxy.injectRequestHeaders = newHeaderSet(map[string]string{
+		"x-team":        "docs",
+		"X-Environment": "production",
+	})
+
+	req := httptest.NewRequest("GET", "https://localhost", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := proxy.Authenticate(httptest.NewRecorder(), req); err != nil {
+			b.Fatalf("unexpected error authenticating: %s", err)
+		}
+	}
+}
+
 func TestAuthenticationUXFlows(t *testing.T) {
 	var (
 		ErrRefreshFailed = errors.New("refresh failed")
@@ -1321,3 +1396,36 @@ func TestHTTPSRedirect(t *testing.T) {
 		})
 	}
 }
+
+func TestAuthenticateMissingScopes(t *testing.T) {
+	testCases := []struct {
+		name        string
+		scopes      []string
+		expectedErr error
+	}{
+		{
+			name:        "sessions without the upstream scopes are rejected",
+			expectedErr: ErrMissingScopes,
+		},
+		{
+			name:   "sessions with the upstream scopes are authenticated",
+			scopes: []string{"email", "calendar.readonly"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session := testSession()
+			session.Scopes = tc.scopes
+			store := &sessions.MockSessionStore{Session: session, ResponseSession: "session"}
+			proxy, close := testNewOAuthProxy(t, setSessionStore(store))
+			defer close()
+			proxy.upstreamConfig.Scopes = []string{"calendar.readonly"}
+
+			err := proxy.Authenticate(httptest.NewRecorder(), httptest.NewRequest("GET", "https://localhost/", nil))
+			testutil.Equal(t, tc.expectedErr, err)
+			// the session is kept, as it's still valid for other upstreams
+			testutil.Equal(t, "session", store.ResponseSession)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -8,9 +9,14 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+	"github.com/buzzfeed/sso/internal/pkg/features"
+	"github.com/buzzfeed/sso/internal/pkg/singleflight"
+	"github.com/buzzfeed/sso/internal/pkg/spiffe"
 	"github.com/buzzfeed/sso/internal/proxy/providers"
 
 	"github.com/datadog/datadog-go/statsd"
@@ -20,8 +26,9 @@ import (
 // Port - int -  port to listen on for HTTP clients
 // ProviderURLString - the URL for the provider in this environment: "https://sso-auth.example.com"
 // ProxyProviderURLString - the internal URL for the provider in this environment: "https://sso-auth-int.example.com"
-// UpstreamConfigsFile - the path to upstream configs file
+// UpstreamConfigsFile - the path to upstream configs file, or an https url it is fetched from and polled for changes, which are applied without a restart
 // Cluster - the cluster in which this is running, used for upstream configs
+// Clusters - csv list of additional clusters whose upstream configs are served by this proxy, e.g. to serve staging and production root domains from one deployment
 // Scheme - the default scheme, used for upstream configs
 // SkipAuthPreflight - will skip authentication for OPTIONS requests, default false
 // DefaultAllowedEmailDomains - csv list of emails with the specified domain to authenticate. Use * to authenticate any email
@@ -35,7 +42,11 @@ import (
 // TCPReadTimeout - http server tcp read timeout
 // CookieName - name of the cookie
 // CookieSecret - the seed string for secure cookies (optionally base64 encoded)
+// CookieSecretKMSKey - ARN of an AWS KMS key, or resource name of a GCP Cloud KMS key, the cookie secret is wrapped with instead of setting CookieSecret
+// CookieSecretKMSCiphertextFile - file holding the base64 encoded ciphertext of the cookie secret wrapped with CookieSecretKMSKey
+// CookieSecretKMSReloadInterval - how often the ciphertext file is re-read to pick up a rotated cookie secret, disabled if 0
 // CookieDomain - an optional cookie domain to force cookies to (ie: .yourcompany.com)*
+// CookieDeriveDomain - set cookies on the registrable domain of the request's host instead of CookieDomain
 // CookieExpire - expire timeframe for cookie
 // CookieSecure - set secure (HTTPS) cookie flag
 // CookieHTTPOnly - set HttpOnly cookie flag
@@ -46,18 +57,112 @@ import (
 // SessionLifetimeTTL - time to live for a session lifetime
 // SessionValidTTL - time to live for a valid session
 // GracePeriodTTL - time to reuse session data when provider unavailable
+// ProviderWorkers - maximum number of concurrent session refresh and validation calls to the provider, unbounded if unset
+// BackgroundRefreshPercent - refresh sessions in the background within this percentage of SessionValidTTL of their deadlines, disabled if unset
+// ImpersonationGroups - csv list of groups whose members may impersonate other users, disabled if unset
+// ImpersonationTTL - maximum duration of an impersonation before it ends automatically
+// DeviceFlow - let command line tools obtain bearer tokens through the OAuth device authorization flow
+// BearerTokenTTL - time to live for bearer tokens issued through the device flow
+// FIPSMode - restrict ciphers, hashes, keys, and outbound TLS to FIPS-approved algorithms, and refuse settings that aren't
+// OutboundTLSMinVersion - the minimum TLS version of connections to providers, upstreams, and other services: 1.0, 1.1, 1.2, or 1.3
+// OutboundTLSCipherSuites - csv list of the TLS 1.0-1.2 cipher suites of outbound connections, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, the Go defaults if unset
+// OutboundTLSCABundle - PEM file of CAs trusted to issue the certificates of outbound connections, besides the system's
+// ProviderTLSPins - csv list of base64 encoded SHA-256 digests of subject public key infos, one of which the provider's certificate chain must include
 // RequestLoging - boolean whether or not to log requests
 // StatsdHost - host addr for statsd client to listen on
 // StatsdPort - port for statsdclient to listen on
+// RequestSigningKey - PEM encoded RSA, ECDSA P-256, or Ed25519 private key requests to upstreams are signed with, in the Sso-Signature header
+// RequestSignatureKMSKey - ARN of an AWS KMS key, or resource name of a GCP Cloud KMS key version, requests to upstreams are signed with instead of RequestSigningKey
+// RequestSignerKMSKeyARN - deprecated name of RequestSignatureKMSKey
+// RequestSignatureDigest - sign a timestamp and the SHA-256 digest of request bodies rather than the bodies themselves, so upstreams can reject replayed requests
+// RequestSignatureMaxBodySize - the size, in bytes, of the largest request body hashed for RequestSignatureDigest; larger bodies are sent unsigned
 // ShutdownTimeout - maximum time to wait for in-flight HTTP requests to complete before shutdown
+// ExtAuthzPort - port to serve the Envoy external authorization gRPC service on, disabled if unset
+// ForwardAuth - serve forward auth requests from reverse proxies such as Traefik and Caddy on /oauth2/forward_auth
+// AdminPort - port to serve the admin API on, disabled if unset
+// AdminToken - bearer token required by every request to the admin API
+// AdminSPIFFEIDs - csv list of SPIFFE IDs of callers authorized to call the admin API with their SVIDs instead of AdminToken, which serves it over TLS
+// SPIFFEEndpointSocket - the SPIFFE Workload API endpoint, e.g. unix:///run/spire/sockets/agent.sock, the proxy's SVIDs are fetched from, disabled if unset
+// GeoIPDatabase - path to a MaxMind GeoIP2 or GeoLite2 country or city database, used to log the country of requests
+// GeoIPASNDatabase - path to a MaxMind GeoIP2 or GeoLite2 ASN database, used to log the network of requests
+// AnomalyDetectors - csv list of detectors flagging suspicious activity: impossible_travel, user_agent_change, excessive_forbidden
+// AnomalyActions - csv list of actions taken on suspicious activity: log, webhook, reauthenticate
+// AnomalyWebhookURL - URL that suspicious activity is posted to by the webhook action
+// AnomalyMaxTravelSpeed - speed, in km/h, above which travel between two requests of a user is impossible
+// AnomalyMaxForbidden - number of forbidden responses to a user within AnomalyForbiddenWindow that is excessive
+// AnomalyForbiddenWindow - time period in which forbidden responses are counted
+// LoginLockoutThreshold - number of failed sign ins of a user or client address within LoginFailureWindow after which it is locked out, disabled if unset
+// LoginFailureWindow - time period in which failed sign ins are counted
+// LoginLockoutDuration - how long the first lockout lasts, doubling with each further failed sign in
+// LoginLockoutMaxDuration - the longest a lockout lasts
+// PreservePostMaxBytes - largest url encoded form submission, in bytes, stashed when it starts a sign in and resubmitted once the user has signed in, disabled if unset
+// HoneypotPaths - csv list of decoy paths, such as /wp-admin or /.env, whose requests to any upstream are answered with a 404, logged, and alerted on
+// SCIMToken - bearer token identity providers authenticate to the SCIM endpoint with to deprovision users, disabled if unset
+// DeprovisionDenyTTL - how long the sessions of a user deprovisioned through SCIM are denied, which should be at least SessionValidTTL
+// MiddlewarePlugins - csv list of paths of Go plugins registering custom middleware, which upstreams enable with their middleware option
+// WebhookURL - URL that auth lifecycle events are posted to, disabled if unset
+// WebhookSecret - secret used to sign webhook deliveries with HMAC-SHA256, including those of anomalies
+// WebhookEvents - csv list of auth lifecycle events to deliver: sign_in, sign_out, authorization_denied, session_revoked, lockout, honeypot. Defaults to all
+// WebhookMaxAttempts - number of times delivery of a webhook event is attempted
+// DefaultRouteAction - action taken on requests whose host matches no upstream: misdirected, not_found, redirect, upstream
+// DefaultRouteURL - URL requests whose host matches no upstream are redirected to by the redirect action
+// DefaultRouteUpstream - service whose upstream serves requests whose host matches no upstream with the upstream action
+// PortalHost - host to serve a landing page on, listing the upstreams each user is authorized to access, disabled if unset
+// FaultInjection - apply the fault_injection options of upstreams, injecting latency, errors, and connection resets. Only for staging environments
+// BuzzfeedCompat - only set the cookies, request signatures, and identity headers of buzzfeed/sso, so that its deployments can be migrated instance by instance
+// PrivacyMode - pseudonymize users in logs with a keyed hash of their email, see PseudonymizationKey
+// PseudonymizationKey - secret key users are pseudonymized with, which must be shared by every instance for pseudonyms to match
+// AuditRetention - how long the records of users' requests the proxy holds, such as the HAR captures of the admin API and their usage of services, are kept, forever if unset
+// CertExpiryCheckInterval - interval at which the certificates of the provider and HTTPS upstreams are checked for expiry, disabled if unset
+// CertExpiryWarningDays - days before a certificate expires that a warning is logged at every check
+// ConfigDriftInterval - interval at which the upstream configs file is re-read and compared to the running configs, disabled if unset
+// UpstreamConfigsGitURL - url of a git repository the upstream configs file is read from, instead of UpstreamConfigs, whose validated changes are applied without a restart
+// UpstreamConfigsGitBranch - branch of the git repository the upstream configs are read from
+// UpstreamConfigsGitPath - path of the upstream configs file within the git repository
+// UpstreamConfigsGitDir - directory the git repository is cloned into
+// UpstreamConfigsGitInterval - interval at which the git repository is pulled for changes
+// UpstreamConfigsGitSSHKey - path to the private key the git repository is cloned with over ssh
+// UpstreamConfigsGitKnownHosts - path to the known hosts file the host keys of a git repository cloned over ssh are verified with
+// UpstreamConfigsPollInterval - interval at which upstream configs fetched from an https url are polled for changes
+// UpstreamConfigsPublicKey - path to the PEM encoded RSA or Ed25519 public key that upstream configs fetched from an https url must be signed with
+// UpstreamConfigsEtcdEndpoints - csv list of etcd endpoints the upstream configs are read from, instead of UpstreamConfigs, whose changes are applied without a restart
+// UpstreamConfigsEtcdPrefix - etcd prefix of the keys of the upstreams and their overrides
+// UpstreamConfigsEtcdUsername - user the etcd endpoints are authenticated as, if any
+// UpstreamConfigsEtcdPassword - password of the etcd user
+// UpstreamConfigsEtcdInterval - interval at which the etcd prefix is polled for changes
+// FeatureFlags - percentages of traffic new behaviors are rolled out to, e.g. new_signer:10,new_signer/wiki:100 to enable new_signer for 10% of users and all users of the wiki upstream
+// FeatureFlagsFile - path to a yaml file of feature flags, overriding FeatureFlags, which is reloaded so features can be rolled back without a restart
+// Tenants - csv list of tenants served alongside the proxy's own upstreams, each configured by TENANT_<NAME>_ env vars, see TenantOptions
+// TrustRequestID - use the X-Request-Id or traceparent headers of inbound requests as their request IDs, rather than always generating fresh ones. Only for proxies whose clients are trusted, such as those behind a load balancer that sets them
+// HostValidation - reject requests whose Host header is malformed or isn't the host of an upstream, the portal, or AllowedHosts before they're routed
+// AllowedHosts - csv list of additional hosts allowed by HostValidation, where .example.com or *.example.com allows the subdomains of a domain
+// StartupChecks - whether failed startup checks of the provider, statsd, upstream configs, and signing key stop the proxy (fail), are logged (warn), or aren't run (off)
+// HardenedMode - refuse insecure settings, and refuse to start unless the proxy runs as a user who can only write to StateDir
+// HardenedModeExceptions - csv list of the hardened mode checks to skip: cookie_secure, cookie_http_only, outbound_tls, run_as_root, or writable_files
+// StateDir - absolute path of the only directory the proxy writes to in hardened mode, which is also its temp directory
+// RunAsUser - user, or user:group, the proxy drops its privileges to once its ports are bound
+// LoadShedding - shed the requests of the lowest priority upstreams while the latency of the proxy or an upstream exceeds LoadSheddingTarget
+// LoadSheddingTarget - the latency the fastest request of each LoadSheddingInterval must not exceed
+// LoadSheddingInterval - the interval over which latency is measured, and by which the shed priorities change
+// LoadSheddingTiers - csv list of the upstream metadata tiers, highest priority first, whose requests are shed in reverse order; upstreams of other tiers are shed first
+// MaxConcurrentRequests - the number of requests forwarded to upstreams at once, beyond which requests wait to be scheduled by the priority classes of their upstreams, unlimited if unset
+// PriorityClasses - the weights of the priority classes of upstreams, e.g. critical:8,standard:4,batch:1, by which waiting requests are scheduled; other classes have a weight of 1
+// PriorityQueueTimeout - how long a request may wait to be scheduled before it's rejected
+// UsageReporting - count the requests of each user to each service, which the admin API reports
+// UsageFile - path of the file usage is saved to, so that it survives restarts, kept in memory only if unset
+// AccessExportDestination - directory, or s3://bucket/prefix url, who has access to each upstream is periodically exported to for access reviews, disabled if unset
+// AccessExportFormat - the format access is exported in: csv or json
+// AccessExportInterval - the interval at which access is exported
+// ServerTiming - add a Server-Timing header to the responses of upstreams, breaking down the time spent routing, authenticating, and waiting for the upstream
 type Options struct {
 	Port int `envconfig:"PORT" default:"4180"`
 
-	ProviderURLString         string `envconfig:"PROVIDER_URL"`
-	ProviderURLInternalString string `envconfig:"PROVIDER_URL_INTERNAL"`
-	UpstreamConfigsFile       string `envconfig:"UPSTREAM_CONFIGS"`
-	Cluster                   string `envconfig:"CLUSTER"`
-	Scheme                    string `envconfig:"SCHEME" default:"https"`
+	ProviderURLString         string   `envconfig:"PROVIDER_URL"`
+	ProviderURLInternalString string   `envconfig:"PROVIDER_URL_INTERNAL"`
+	UpstreamConfigsFile       string   `envconfig:"UPSTREAM_CONFIGS"`
+	Cluster                   string   `envconfig:"CLUSTER"`
+	Clusters                  []string `envconfig:"CLUSTERS"`
+	Scheme                    string   `envconfig:"SCHEME" default:"https"`
 
 	SkipAuthPreflight bool `envconfig:"SKIP_AUTH_PREFLIGHT"`
 
@@ -74,12 +179,17 @@ type Options struct {
 	TCPWriteTimeout time.Duration `envconfig:"TCP_WRITE_TIMEOUT" default:"30s"`
 	TCPReadTimeout  time.Duration `envconfig:"TCP_READ_TIMEOUT" default:"30s"`
 
-	CookieName     string        `envconfig:"COOKIE_NAME" default:"_sso_proxy"`
-	CookieSecret   string        `envconfig:"COOKIE_SECRET"`
-	CookieDomain   string        `envconfig:"COOKIE_DOMAIN"`
-	CookieExpire   time.Duration `envconfig:"COOKIE_EXPIRE" default:"168h"`
-	CookieSecure   bool          `envconfig:"COOKIE_SECURE" default:"true"`
-	CookieHTTPOnly bool          `envconfig:"COOKIE_HTTP_ONLY"`
+	CookieName         string        `envconfig:"COOKIE_NAME" default:"_sso_proxy"`
+	CookieSecret       string        `envconfig:"COOKIE_SECRET"`
+	CookieDomain       string        `envconfig:"COOKIE_DOMAIN"`
+	CookieDeriveDomain bool          `envconfig:"COOKIE_DERIVE_DOMAIN"`
+	CookieExpire       time.Duration `envconfig:"COOKIE_EXPIRE" default:"168h"`
+	CookieSecure       bool          `envconfig:"COOKIE_SECURE" default:"true"`
+	CookieHTTPOnly     bool          `envconfig:"COOKIE_HTTP_ONLY"`
+
+	CookieSecretKMSKey            string        `envconfig:"COOKIE_SECRET_KMS_KEY"`
+	CookieSecretKMSCiphertextFile string        `envconfig:"COOKIE_SECRET_KMS_CIPHERTEXT_FILE"`
+	CookieSecretKMSReloadInterval time.Duration `envconfig:"COOKIE_SECRET_KMS_RELOAD_INTERVAL" default:"1m"`
 
 	PassAccessToken bool `envconfig:"PASS_ACCESS_TOKEN" default:"false"`
 
@@ -91,23 +201,173 @@ type Options struct {
 	SessionValidTTL    time.Duration `envconfig:"SESSION_VALID_TTL" default:"1m"`
 	GracePeriodTTL     time.Duration `envconfig:"GRACE_PERIOD_TTL" default:"3h"`
 
+	ProviderWorkers          int     `envconfig:"PROVIDER_WORKERS"`
+	BackgroundRefreshPercent float64 `envconfig:"BACKGROUND_REFRESH_PERCENT"`
+
+	ImpersonationGroups []string      `envconfig:"IMPERSONATION_GROUPS"`
+	ImpersonationTTL    time.Duration `envconfig:"IMPERSONATION_TTL" default:"1h"`
+
+	DeviceFlow     bool          `envconfig:"DEVICE_FLOW"`
+	BearerTokenTTL time.Duration `envconfig:"BEARER_TOKEN_TTL" default:"12h"`
+
+	FIPSMode bool `envconfig:"FIPS_MODE"`
+
+	OutboundTLSMinVersion   string   `envconfig:"OUTBOUND_TLS_MIN_VERSION" default:"1.2"`
+	OutboundTLSCipherSuites []string `envconfig:"OUTBOUND_TLS_CIPHER_SUITES"`
+	OutboundTLSCABundle     string   `envconfig:"OUTBOUND_TLS_CA_BUNDLE"`
+	ProviderTLSPins         []string `envconfig:"PROVIDER_TLS_PINS"`
+
 	RequestLogging bool `envconfig:"REQUEST_LOGGING" default:"true"`
 
 	StatsdHost string `envconfig:"STATSD_HOST"`
 	StatsdPort int    `envconfig:"STATSD_PORT"`
 
-	RequestSigningKey string `envconfig:"REQUEST_SIGNATURE_KEY"`
+	RequestSigningKey           string `envconfig:"REQUEST_SIGNATURE_KEY"`
+	RequestSignatureKMSKey      string `envconfig:"REQUEST_SIGNATURE_KMS_KEY"`
+	RequestSignerKMSKeyARN      string `envconfig:"REQUESTSIGNER_KMS_KEY_ARN"`
+	RequestSignatureDigest      bool   `envconfig:"REQUEST_SIGNATURE_DIGEST"`
+	RequestSignatureMaxBodySize int64  `envconfig:"REQUEST_SIGNATURE_MAX_BODY_SIZE" default:"1048576"`
 
 	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"30s"`
 
+	ExtAuthzPort int  `envconfig:"EXT_AUTHZ_PORT"`
+	ForwardAuth  bool `envconfig:"FORWARD_AUTH"`
+
+	AdminPort  int    `envconfig:"ADMIN_PORT"`
+	AdminToken string `envconfig:"ADMIN_TOKEN"`
+
+	AdminSPIFFEIDs       []string `envconfig:"ADMIN_SPIFFE_IDS"`
+	SPIFFEEndpointSocket string   `envconfig:"SPIFFE_ENDPOINT_SOCKET"`
+
+	GeoIPDatabase    string `envconfig:"GEOIP_DATABASE"`
+	GeoIPASNDatabase string `envconfig:"GEOIP_ASN_DATABASE"`
+
+	AnomalyDetectors       []string      `envconfig:"ANOMALY_DETECTORS"`
+	AnomalyActions         []string      `envconfig:"ANOMALY_ACTIONS" default:"log"`
+	AnomalyWebhookURL      string        `envconfig:"ANOMALY_WEBHOOK_URL"`
+	AnomalyMaxTravelSpeed  float64       `envconfig:"ANOMALY_MAX_TRAVEL_SPEED" default:"1000"`
+	AnomalyMaxForbidden    int           `envconfig:"ANOMALY_MAX_FORBIDDEN" default:"20"`
+	AnomalyForbiddenWindow time.Duration `envconfig:"ANOMALY_FORBIDDEN_WINDOW" default:"5m"`
+
+	LoginLockoutThreshold   int           `envconfig:"LOGIN_LOCKOUT_THRESHOLD"`
+	LoginFailureWindow      time.Duration `envconfig:"LOGIN_FAILURE_WINDOW" default:"15m"`
+	LoginLockoutDuration    time.Duration `envconfig:"LOGIN_LOCKOUT_DURATION" default:"1m"`
+	LoginLockoutMaxDuration time.Duration `envconfig:"LOGIN_LOCKOUT_MAX_DURATION" default:"1h"`
+
+	HoneypotPaths []string `envconfig:"HONEYPOT_PATHS"`
+
+	PreservePostMaxBytes int64 `envconfig:"PRESERVE_POST_MAX_BYTES"`
+
+	SCIMToken          string        `envconfig:"SCIM_TOKEN"`
+	DeprovisionDenyTTL time.Duration `envconfig:"DEPROVISION_DENY_TTL" default:"24h"`
+
+	MiddlewarePlugins []string `envconfig:"MIDDLEWARE_PLUGINS"`
+
+	WebhookURL         string   `envconfig:"WEBHOOK_URL"`
+	WebhookSecret      string   `envconfig:"WEBHOOK_SECRET"`
+	WebhookEvents      []string `envconfig:"WEBHOOK_EVENTS"`
+	WebhookMaxAttempts int      `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"5"`
+
+	DefaultRouteAction   string `envconfig:"DEFAULT_ROUTE_ACTION" default:"misdirected"`
+	DefaultRouteURL      string `envconfig:"DEFAULT_ROUTE_URL"`
+	DefaultRouteUpstream string `envconfig:"DEFAULT_ROUTE_UPSTREAM"`
+
+	PortalHost string `envconfig:"PORTAL_HOST"`
+
+	TrustRequestID bool `envconfig:"TRUST_REQUEST_ID"`
+
+	HostValidation bool     `envconfig:"HOST_VALIDATION"`
+	AllowedHosts   []string `envconfig:"ALLOWED_HOSTS"`
+
+	FaultInjection bool `envconfig:"FAULT_INJECTION"`
+
+	BuzzfeedCompat bool `envconfig:"BUZZFEED_COMPAT"`
+
+	StartupChecks string `envconfig:"STARTUP_CHECKS" default:"warn"`
+
+	ConfigDriftInterval time.Duration `envconfig:"CONFIG_DRIFT_INTERVAL"`
+
+	UpstreamConfigsGitURL        string        `envconfig:"UPSTREAM_CONFIGS_GIT_URL"`
+	UpstreamConfigsGitBranch     string        `envconfig:"UPSTREAM_CONFIGS_GIT_BRANCH" default:"main"`
+	UpstreamConfigsGitPath       string        `envconfig:"UPSTREAM_CONFIGS_GIT_PATH" default:"upstream_configs.yml"`
+	UpstreamConfigsGitDir        string        `envconfig:"UPSTREAM_CONFIGS_GIT_DIR" default:"/tmp/sso-upstream-configs"`
+	UpstreamConfigsGitInterval   time.Duration `envconfig:"UPSTREAM_CONFIGS_GIT_INTERVAL" default:"1m"`
+	UpstreamConfigsGitSSHKey     string        `envconfig:"UPSTREAM_CONFIGS_GIT_SSH_KEY"`
+	UpstreamConfigsGitKnownHosts string        `envconfig:"UPSTREAM_CONFIGS_GIT_KNOWN_HOSTS"`
+
+	UpstreamConfigsPollInterval time.Duration `envconfig:"UPSTREAM_CONFIGS_POLL_INTERVAL" default:"1m"`
+	UpstreamConfigsPublicKey    string        `envconfig:"UPSTREAM_CONFIGS_PUBLIC_KEY"`
+
+	UpstreamConfigsEtcdEndpoints []string      `envconfig:"UPSTREAM_CONFIGS_ETCD_ENDPOINTS"`
+	UpstreamConfigsEtcdPrefix    string        `envconfig:"UPSTREAM_CONFIGS_ETCD_PREFIX" default:"/sso/"`
+	UpstreamConfigsEtcdUsername  string        `envconfig:"UPSTREAM_CONFIGS_ETCD_USERNAME"`
+	UpstreamConfigsEtcdPassword  string        `envconfig:"UPSTREAM_CONFIGS_ETCD_PASSWORD"`
+	UpstreamConfigsEtcdInterval  time.Duration `envconfig:"UPSTREAM_CONFIGS_ETCD_INTERVAL" default:"10s"`
+
+	PrivacyMode         bool          `envconfig:"PRIVACY_MODE"`
+	PseudonymizationKey string        `envconfig:"PSEUDONYMIZATION_KEY"`
+	AuditRetention      time.Duration `envconfig:"AUDIT_RETENTION"`
+
+	CertExpiryCheckInterval time.Duration `envconfig:"CERT_EXPIRY_CHECK_INTERVAL"`
+	CertExpiryWarningDays   int           `envconfig:"CERT_EXPIRY_WARNING_DAYS" default:"14"`
+
+	FeatureFlags     map[string]string `envconfig:"FEATURE_FLAGS"`
+	FeatureFlagsFile string            `envconfig:"FEATURE_FLAGS_FILE"`
+
+	Tenants []string `envconfig:"TENANTS"`
+
+	HardenedMode           bool     `envconfig:"HARDENED_MODE"`
+	HardenedModeExceptions []string `envconfig:"HARDENED_MODE_EXCEPTIONS"`
+	StateDir               string   `envconfig:"STATE_DIR"`
+	RunAsUser              string   `envconfig:"RUN_AS_USER"`
+
+	LoadShedding         bool          `envconfig:"LOAD_SHEDDING"`
+	LoadSheddingTarget   time.Duration `envconfig:"LOAD_SHEDDING_TARGET" default:"500ms"`
+	LoadSheddingInterval time.Duration `envconfig:"LOAD_SHEDDING_INTERVAL" default:"1s"`
+	LoadSheddingTiers    []string      `envconfig:"LOAD_SHEDDING_TIERS" default:"critical,standard"`
+
+	MaxConcurrentRequests int            `envconfig:"MAX_CONCURRENT_REQUESTS"`
+	PriorityClasses       map[string]int `envconfig:"PRIORITY_CLASSES" default:"critical:8,standard:4,batch:1"`
+	PriorityQueueTimeout  time.Duration  `envconfig:"PRIORITY_QUEUE_TIMEOUT" default:"5s"`
+
+	ServerTiming bool `envconfig:"SERVER_TIMING"`
+
+	UsageReporting bool   `envconfig:"USAGE_REPORTING"`
+	UsageFile      string `envconfig:"USAGE_FILE"`
+
+	AccessExportDestination string        `envconfig:"ACCESS_EXPORT_DESTINATION"`
+	AccessExportFormat      string        `envconfig:"ACCESS_EXPORT_FORMAT" default:"csv"`
+	AccessExportInterval    time.Duration `envconfig:"ACCESS_EXPORT_INTERVAL" default:"24h"`
+
 	StatsdClient *statsd.Client
 
 	// This is an override for supplying template vars at test time
 	testTemplateVars map[string]string
+	// This is an override for supplying the settings of tenants at test time
+	testTenantOptions map[string]*TenantOptions
 
 	// internal values that are set after config validation
 	upstreamConfigs     []*UpstreamConfig
+	upstreamConfigsRaw  []byte
+	gitConfigSource     *gitConfigSource
+	httpConfigSource    *httpConfigSource
+	etcdConfigSource    *etcdConfigSource
 	decodedCookieSecret []byte
+	cookieCipher        aead.Cipher
+	outboundTLSConfig   *tls.Config
+	providerTLSPins     [][]byte
+	spiffeSource        *spiffe.Source
+	featureFlags        *features.Flags
+	tenants             []*tenant
+	drain               *drainStatus
+
+	// the name of the tenant the options are of, which is empty for the proxy's own
+	tenantName string
+
+	// shared by the providers of every upstream, so that their calls are coalesced and bounded
+	// together
+	providerCalls *singleflight.Group
+	providerPool  *providers.WorkerPool
 }
 
 // NewOptions returns a new options struct
@@ -128,11 +388,59 @@ func NewOptions() *Options {
 		DefaultAllowedEmailDomains:   []string{},
 		DefaultAllowedGroups:         []string{},
 		PassAccessToken:              false,
+
+		ImpersonationTTL: time.Duration(1) * time.Hour,
+		BearerTokenTTL:   time.Duration(12) * time.Hour,
+
+		AnomalyActions:         []string{"log"},
+		AnomalyMaxTravelSpeed:  1000,
+		AnomalyMaxForbidden:    20,
+		AnomalyForbiddenWindow: time.Duration(5) * time.Minute,
+
+		DeprovisionDenyTTL: time.Duration(24) * time.Hour,
+
+		WebhookMaxAttempts: 5,
+
+		DefaultRouteAction: defaultRouteMisdirected,
+
+		UpstreamConfigsGitBranch:   "main",
+		UpstreamConfigsGitPath:     "upstream_configs.yml",
+		UpstreamConfigsGitDir:      "/tmp/sso-upstream-configs",
+		UpstreamConfigsGitInterval: time.Duration(1) * time.Minute,
+
+		UpstreamConfigsPollInterval: time.Duration(1) * time.Minute,
+
+		UpstreamConfigsEtcdPrefix:   "/sso/",
+		UpstreamConfigsEtcdInterval: time.Duration(10) * time.Second,
+
+		RequestSignatureMaxBodySize: 1 << 20,
+
+		StartupChecks: startupChecksWarn,
+
+		LoadSheddingTarget:   time.Duration(500) * time.Millisecond,
+		LoadSheddingInterval: time.Duration(1) * time.Second,
+		LoadSheddingTiers:    []string{"critical", "standard"},
+
+		PriorityClasses:      map[string]int{"critical": 8, "standard": 4, "batch": 1},
+		PriorityQueueTimeout: time.Duration(5) * time.Second,
+
+		AccessExportFormat:   accessExportCSV,
+		AccessExportInterval: time.Duration(24) * time.Hour,
 	}
 }
 
 // Validate validates options
 func (o *Options) Validate() error {
+	msgs := o.validate()
+	if len(msgs) != 0 {
+		return fmt.Errorf("Invalid configuration:\n  %s",
+			strings.Join(msgs, "\n  "))
+	}
+	return nil
+}
+
+// validate validates options, returning a message for every invalid setting.
+func (o *Options) validate() []string {
 	msgs := make([]string, 0)
 	if o.Cluster == "" {
 		msgs = append(msgs, "missing setting: cluster")
@@ -140,10 +448,10 @@ func (o *Options) Validate() error {
 	if o.ProviderURLString == "" {
 		msgs = append(msgs, "missing setting: provider-url")
 	}
-	if o.UpstreamConfigsFile == "" {
+	if o.UpstreamConfigsFile == "" && o.UpstreamConfigsGitURL == "" && len(o.UpstreamConfigsEtcdEndpoints) == 0 {
 		msgs = append(msgs, "missing setting: upstream-configs")
 	}
-	if o.CookieSecret == "" {
+	if o.CookieSecret == "" && o.CookieSecretKMSKey == "" {
 		msgs = append(msgs, "missing setting: cookie-secret")
 	}
 	if o.ClientID == "" {
@@ -153,6 +461,92 @@ func (o *Options) Validate() error {
 		msgs = append(msgs, "missing setting: client-secret")
 	}
 
+	if o.AdminPort != 0 && o.AdminToken == "" && len(o.AdminSPIFFEIDs) == 0 {
+		msgs = append(msgs, "missing setting: admin-token")
+	}
+
+	msgs = validateAnomalyOptions(o, msgs)
+	msgs = validateLoginThrottleOptions(o, msgs)
+	msgs = validateLoadSheddingOptions(o, msgs)
+	msgs = validateHoneypotOptions(o, msgs)
+	msgs = validatePostStashOptions(o, msgs)
+	msgs = validateWebhookOptions(o, msgs)
+
+	// instances of buzzfeed/sso sharing the session cookie would ignore impersonations
+	if o.BuzzfeedCompat && len(o.ImpersonationGroups) != 0 {
+		msgs = append(msgs, "invalid setting: IMPERSONATION_GROUPS can't be set with BUZZFEED_COMPAT")
+	}
+
+	if o.CookieSecretKMSKey != "" {
+		if o.CookieSecret != "" {
+			msgs = append(msgs, "invalid setting: COOKIE_SECRET and COOKIE_SECRET_KMS_KEY can't both be set")
+		}
+		if err := validateKMSCookieSecretKey(o.CookieSecretKMSKey); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid value for cookie-secret-kms-key: %s", err))
+		}
+		if o.CookieSecretKMSCiphertextFile == "" {
+			msgs = append(msgs, "missing setting: COOKIE_SECRET_KMS_CIPHERTEXT_FILE is required by COOKIE_SECRET_KMS_KEY")
+		}
+	} else if o.CookieSecretKMSCiphertextFile != "" {
+		msgs = append(msgs, "missing setting: COOKIE_SECRET_KMS_KEY is required by COOKIE_SECRET_KMS_CIPHERTEXT_FILE")
+	}
+	if o.CookieSecretKMSReloadInterval < 0 {
+		msgs = append(msgs, "invalid value for cookie-secret-kms-reload-interval: must not be negative")
+	}
+
+	if o.RequestSignerKMSKeyARN != "" {
+		if o.RequestSignatureKMSKey != "" && o.RequestSignatureKMSKey != o.RequestSignerKMSKeyARN {
+			msgs = append(msgs, "invalid setting: REQUEST_SIGNATURE_KMS_KEY and REQUESTSIGNER_KMS_KEY_ARN can't both be set")
+		} else {
+			o.RequestSignatureKMSKey = o.RequestSignerKMSKeyARN
+		}
+	}
+	if o.RequestSignatureKMSKey != "" {
+		if o.RequestSigningKey != "" {
+			msgs = append(msgs, "invalid setting: REQUEST_SIGNATURE_KEY and REQUEST_SIGNATURE_KMS_KEY can't both be set")
+		}
+		if err := validateKMSKeyName(o.RequestSignatureKMSKey); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid value for request-signature-kms-key: %s", err))
+		}
+	}
+	if o.RequestSignatureDigest {
+		if o.RequestSigningKey == "" && o.RequestSignatureKMSKey == "" {
+			msgs = append(msgs, "missing setting: REQUEST_SIGNATURE_KEY or REQUEST_SIGNATURE_KMS_KEY is required by REQUEST_SIGNATURE_DIGEST")
+		}
+		// upstreams of buzzfeed/sso only verify signatures of request bodies
+		if o.BuzzfeedCompat {
+			msgs = append(msgs, "invalid setting: REQUEST_SIGNATURE_DIGEST can't be set with BUZZFEED_COMPAT")
+		}
+	}
+	if o.RequestSignatureMaxBodySize < 0 {
+		msgs = append(msgs, "invalid value for request-signature-max-body-size: must not be negative")
+	}
+
+	if o.ProviderWorkers < 0 {
+		msgs = append(msgs, "invalid value for provider-workers: must not be negative")
+	}
+
+	if o.ConfigDriftInterval < 0 {
+		msgs = append(msgs, "invalid value for config-drift-interval: must not be negative")
+	}
+
+	if o.CertExpiryCheckInterval < 0 {
+		msgs = append(msgs, "invalid value for cert-expiry-check-interval: must not be negative")
+	}
+	if o.CertExpiryWarningDays < 0 {
+		msgs = append(msgs, "invalid value for cert-expiry-warning-days: must not be negative")
+	}
+
+	featureFlags, err := features.New(o.FeatureFlags, o.FeatureFlagsFile)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid value for feature-flags: %s", err))
+	}
+	o.featureFlags = featureFlags
+
+	if o.BackgroundRefreshPercent < 0 || o.BackgroundRefreshPercent >= 100 {
+		msgs = append(msgs, "invalid value for background-refresh-percent: must be at least 0 and less than 100")
+	}
+
 	if o.StatsdHost == "" {
 		msgs = append(msgs, "missing setting: statsd-host")
 	}
@@ -168,50 +562,38 @@ func (o *Options) Validate() error {
 		o.StatsdClient = StatsdClient
 	}
 
-	if o.UpstreamConfigsFile != "" {
-		rawBytes, err := ioutil.ReadFile(o.UpstreamConfigsFile)
-		if err != nil {
-			msgs = append(msgs, fmt.Sprintf("error reading upstream configs file: %s", err))
-		}
+	// plugins register their middleware before the upstream configs enabling them are loaded
+	msgs = loadMiddlewarePlugins(o, msgs)
 
-		templateVars := parseEnvironment(os.Environ())
-		if o.testTemplateVars != nil {
-			templateVars = o.testTemplateVars
-		}
+	// the upstream configs of a git repository are only loaded once it's cloned, when the proxy
+	// starts
+	msgs = validateGitConfigSourceOptions(o, msgs)
+	msgs = validateEtcdConfigSourceOptions(o, msgs)
 
-		defaultUpstreamOptionsConfig := &OptionsConfig{
-			AllowedEmailAddresses: o.DefaultAllowedEmailAddresses,
-			AllowedEmailDomains:   o.DefaultAllowedEmailDomains,
-			AllowedGroups:         o.DefaultAllowedGroups,
-			Timeout:               o.DefaultUpstreamTimeout,
-			ResetDeadline:         o.DefaultUpstreamTCPResetDeadline,
-			ProviderSlug:          o.DefaultProviderSlug,
-			CookieName:            o.CookieName,
+	if o.UpstreamConfigsFile != "" || o.etcdConfigSource != nil {
+		rawBytes, err := o.readUpstreamConfigs()
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("error reading upstream configs file: %s", err))
 		}
+		o.upstreamConfigsRaw = rawBytes
 
-		o.upstreamConfigs, err = loadServiceConfigs(rawBytes, o.Cluster, o.Scheme, templateVars, defaultUpstreamOptionsConfig)
+		o.upstreamConfigs, err = o.loadUpstreamConfigs(rawBytes)
 		if err != nil {
 			msgs = append(msgs, fmt.Sprintf("error parsing upstream configs file %s", err))
+		} else if err := o.validateUpstreamEnvironments(rawBytes); err != nil {
+			msgs = append(msgs, fmt.Sprintf("error validating upstream configs environments %s", err))
 		}
 	}
 
-	if o.upstreamConfigs != nil {
-		invalidUpstreams := []string{}
-		for _, uc := range o.upstreamConfigs {
-			if uc.Timeout > o.TCPWriteTimeout {
-				o.TCPWriteTimeout = uc.Timeout
-			}
-
-			if len(uc.AllowedEmailDomains) == 0 && len(uc.AllowedEmailAddresses) == 0 && len(uc.AllowedGroups) == 0 {
-				invalidUpstreams = append(invalidUpstreams, uc.Service)
-			}
-		}
-		if len(invalidUpstreams) != 0 {
-			msgs = append(msgs, fmt.Sprintf(
-				"missing setting: ALLOWED_EMAIL_DOMAINS, ALLOWED_EMAIL_ADDRESSES, ALLOWED_GROUPS default in environment or override in upstream config in the following upstreams: %v",
-				invalidUpstreams))
-		}
+	msgs = validateUpstreamConfigs(o, msgs)
+	msgs = validateFairQueueOptions(o, msgs)
+	if o.gitConfigSource == nil {
+		// the default upstream is checked once the upstreams of a git repository are loaded
+		msgs = validateDefaultRouteOptions(o, msgs)
 	}
+	msgs = validatePortalOptions(o, msgs)
+	msgs = validateAllowedHosts(o, msgs)
+	msgs = validateStartupChecks(o, msgs)
 
 	if o.ProviderURLString != "" {
 		err := parseProviderInfo(o)
@@ -220,30 +602,136 @@ func (o *Options) Validate() error {
 		}
 	}
 
-	decodedCookieSecret, err := base64.StdEncoding.DecodeString(o.CookieSecret)
+	// a cookie secret wrapped with a KMS key is only unwrapped when the proxy starts
+	if o.CookieSecretKMSKey == "" {
+		decodedCookieSecret, err := base64.StdEncoding.DecodeString(o.CookieSecret)
+		if err != nil {
+			msgs = append(msgs, "Invalid value for COOKIE_SECRET; expected base64-encoded bytes, as from `sso-proxy keygen -type cookie`")
+		}
+		validCookieSecretLength := false
+		for _, i := range []int{32, 64} {
+			if len(decodedCookieSecret) == i {
+				validCookieSecretLength = true
+			}
+		}
+
+		if !validCookieSecretLength {
+			msgs = append(msgs, fmt.Sprintf("Invalid value for COOKIE_SECRET; must decode to 32 or 64 bytes, but decoded to %d bytes", len(decodedCookieSecret)))
+		}
+
+		o.decodedCookieSecret = decodedCookieSecret
+	}
+
+	msgs = validateCookieOptions(o, msgs)
+	msgs = validateOutboundTLSOptions(o, msgs)
+	msgs = validateSPIFFEOptions(o, msgs)
+	msgs = validatePrivacyOptions(o, msgs)
+	msgs = validateAccessExportOptions(o, msgs)
+	msgs = validateSCIMOptions(o, msgs)
+	msgs = validateFIPSOptions(o, msgs)
+	msgs = validateHardeningOptions(o, msgs)
+	msgs = validateTenants(o, msgs)
+
+	return msgs
+}
+
+// templateVars returns the variables substituted in the upstream configs file.
+func (o *Options) templateVars() map[string]string {
+	if o.testTemplateVars != nil {
+		return o.testTemplateVars
+	}
+	return parseEnvironment(os.Environ())
+}
+
+// readUpstreamConfigs reads the upstream configs file, or fetches it if it's a url or read from
+// etcd.
+func (o *Options) readUpstreamConfigs() ([]byte, error) {
+	o.httpConfigSource = nil
+	if o.etcdConfigSource != nil {
+		return o.etcdConfigSource.fetch()
+	}
+	if !isUpstreamConfigsURL(o.UpstreamConfigsFile) {
+		return ioutil.ReadFile(o.UpstreamConfigsFile)
+	}
+	source, err := newHTTPConfigSource(o)
 	if err != nil {
-		msgs = append(msgs, "Invalid value for COOKIE_SECRET; expected base64-encoded bytes, as from `openssl rand 32 -base64`")
+		return nil, err
 	}
-	validCookieSecretLength := false
-	for _, i := range []int{32, 64} {
-		if len(decodedCookieSecret) == i {
-			validCookieSecretLength = true
-		}
+	raw, err := source.fetch()
+	if err != nil {
+		return nil, err
 	}
+	o.httpConfigSource = source
+	return raw, nil
+}
 
-	if !validCookieSecretLength {
-		msgs = append(msgs, fmt.Sprintf("Invalid value for COOKIE_SECRET; must decode to 32 or 64 bytes, but decoded to %d bytes", len(decodedCookieSecret)))
+// upstreamConfigsDir returns the directory the files the upstream configs file includes are
+// relative to, which is empty if it's fetched from a url or read from etcd, since it can't
+// include files.
+func (o *Options) upstreamConfigsDir() string {
+	if o.etcdConfigSource != nil || isUpstreamConfigsURL(o.UpstreamConfigsFile) {
+		return ""
 	}
+	return filepath.Dir(o.UpstreamConfigsFile)
+}
 
-	o.decodedCookieSecret = decodedCookieSecret
+// upstreamConfigSource returns the source whose changes to the upstream configs are applied
+// without a restart, or nil if they're read from a file.
+func (o *Options) upstreamConfigSource() upstreamConfigSource {
+	switch {
+	case o.gitConfigSource != nil:
+		return o.gitConfigSource
+	case o.httpConfigSource != nil:
+		return o.httpConfigSource
+	case o.etcdConfigSource != nil:
+		return o.etcdConfigSource
+	default:
+		return nil
+	}
+}
 
-	msgs = validateCookieName(o, msgs)
+// loadUpstreamConfigs parses the upstream configs of every cluster served from the contents of
+// the upstream configs file.
+func (o *Options) loadUpstreamConfigs(raw []byte) ([]*UpstreamConfig, error) {
+	return loadClusterServiceConfigs(o.upstreamConfigsDir(), raw, o.clusters(), o.Scheme, o.templateVars(), o.defaultUpstreamOptions())
+}
 
-	if len(msgs) != 0 {
-		return fmt.Errorf("Invalid configuration:\n  %s",
-			strings.Join(msgs, "\n  "))
+// validateUpstreamEnvironments checks that the upstream configs of every environment of the
+// upstream configs file load, including those of clusters that aren't served.
+func (o *Options) validateUpstreamEnvironments(raw []byte) error {
+	return validateEnvironments(o.upstreamConfigsDir(), raw, o.Scheme, o.templateVars(), o.defaultUpstreamOptions())
+}
+
+// defaultUpstreamOptions returns the options of upstreams that neither they nor the upstream
+// configs file set.
+func (o *Options) defaultUpstreamOptions() *OptionsConfig {
+	return &OptionsConfig{
+		AllowedEmailAddresses: o.DefaultAllowedEmailAddresses,
+		AllowedEmailDomains:   o.DefaultAllowedEmailDomains,
+		AllowedGroups:         o.DefaultAllowedGroups,
+		Timeout:               o.DefaultUpstreamTimeout,
+		ResetDeadline:         o.DefaultUpstreamTCPResetDeadline,
+		ProviderSlug:          o.DefaultProviderSlug,
+		CookieName:            o.CookieName,
+		TLSMinVersion:         o.OutboundTLSMinVersion,
+		TLSCipherSuites:       o.OutboundTLSCipherSuites,
+		TLSCABundle:           o.OutboundTLSCABundle,
 	}
-	return nil
+}
+
+// clusters returns the clusters whose upstreams are served, Cluster first.
+func (o *Options) clusters() []string {
+	clusters := []string{o.Cluster}
+	seen := map[string]bool{o.Cluster: true}
+	for _, cluster := range o.Clusters {
+		cluster = strings.TrimSpace(cluster)
+		if cluster == "" || seen[cluster] {
+			continue
+		}
+		seen[cluster] = true
+		clusters = append(clusters, cluster)
+	}
+	return clusters
 }
 
 func parseProviderInfo(o *Options) error {
@@ -291,23 +779,162 @@ func newProvider(opts *Options, upstreamConfig *UpstreamConfig) (providers.Provi
 		ProviderURLInternal: providerURLInternal,
 		ProviderSlug:        upstreamConfig.ProviderSlug,
 		Scope:               opts.Scope,
+		Scopes:              upstreamConfig.Scopes,
 		SessionLifetimeTTL:  opts.SessionLifetimeTTL,
 		SessionValidTTL:     opts.SessionValidTTL,
 		GracePeriodTTL:      opts.GracePeriodTTL,
 	}
 
+	if opts.providerCalls == nil {
+		opts.providerCalls = &singleflight.Group{}
+		opts.providerPool = providers.NewWorkerPool(opts.ProviderWorkers, opts.StatsdClient)
+	}
+
 	p := providers.New(opts.Provider, providerData, opts.StatsdClient)
-	return providers.NewSingleFlightProvider(p, opts.StatsdClient), nil
+	return providers.NewSingleFlightProvider(p, opts.providerCalls, opts.providerPool, opts.StatsdClient), nil
 }
 
-func validateCookieName(o *Options, msgs []string) []string {
+func validateCookieOptions(o *Options, msgs []string) []string {
 	cookie := &http.Cookie{Name: o.CookieName}
 	if cookie.String() == "" {
-		return append(msgs, fmt.Sprintf("invalid cookie name: %q", o.CookieName))
+		msgs = append(msgs, fmt.Sprintf("invalid cookie name: %q", o.CookieName))
+	}
+	if o.CookieDomain != "" && o.CookieDeriveDomain {
+		msgs = append(msgs, "invalid setting: cookie-domain and cookie-derive-domain can't both be set")
 	}
 	return msgs
 }
 
+func validateAnomalyOptions(o *Options, msgs []string) []string {
+	for _, detector := range o.AnomalyDetectors {
+		switch detector {
+		case anomalyImpossibleTravel:
+			if o.GeoIPDatabase == "" {
+				msgs = append(msgs, "missing setting: GEOIP_DATABASE is required by the impossible_travel anomaly detector")
+			}
+		case anomalyUserAgentChange, anomalyExcessiveForbidden:
+		default:
+			msgs = append(msgs, fmt.Sprintf("invalid anomaly detector: %q", detector))
+		}
+	}
+	for _, action := range o.AnomalyActions {
+		switch action {
+		case anomalyActionWebhook:
+			if o.AnomalyWebhookURL == "" {
+				msgs = append(msgs, "missing setting: anomaly-webhook-url")
+			} else if !validAbsoluteURL(o.AnomalyWebhookURL) {
+				msgs = append(msgs, "invalid value for anomaly-webhook-url: must include scheme and host")
+			}
+		case anomalyActionLog, anomalyActionReauthenticate:
+		default:
+			msgs = append(msgs, fmt.Sprintf("invalid anomaly action: %q", action))
+		}
+	}
+	return msgs
+}
+
+func validateWebhookOptions(o *Options, msgs []string) []string {
+	if o.WebhookURL == "" {
+		return msgs
+	}
+	if !validAbsoluteURL(o.WebhookURL) {
+		msgs = append(msgs, "invalid value for webhook-url: must include scheme and host")
+	}
+	for _, event := range o.WebhookEvents {
+		if !isLifecycleEvent(event) {
+			msgs = append(msgs, fmt.Sprintf("invalid webhook event: %q", event))
+		}
+	}
+	if o.WebhookMaxAttempts < 1 {
+		msgs = append(msgs, "invalid value for webhook-max-attempts: must be at least 1")
+	}
+	return msgs
+}
+
+// validateUpstreamConfigs validates the options of the loaded upstream configs that depend on
+// other settings.
+func validateUpstreamConfigs(o *Options, msgs []string) []string {
+	invalidUpstreams := []string{}
+	for _, uc := range o.upstreamConfigs {
+		if uc.Timeout > o.TCPWriteTimeout {
+			o.TCPWriteTimeout = uc.Timeout
+		}
+
+		if len(uc.AllowedEmailDomains) == 0 && len(uc.AllowedEmailAddresses) == 0 && len(uc.AllowedGroups) == 0 {
+			invalidUpstreams = append(invalidUpstreams, uc.Service)
+		}
+
+		if (len(uc.AllowedCountries) != 0 || len(uc.DeniedCountries) != 0) && o.GeoIPDatabase == "" {
+			msgs = append(msgs, fmt.Sprintf(
+				"missing setting: GEOIP_DATABASE is required by the allowed_countries and denied_countries of upstream %s",
+				uc.Service))
+		}
+
+		if _, ok := uc.Route.(*SimpleRoute); uc.HealthCheck != nil && !ok {
+			msgs = append(msgs, fmt.Sprintf(
+				"invalid setting: health_check is only supported by simple routes, not by upstream %s",
+				uc.Service))
+		}
+
+		if _, ok := uc.Route.(*SimpleRoute); uc.WarmConnections != 0 && !ok {
+			msgs = append(msgs, fmt.Sprintf(
+				"invalid setting: warm_connections is only supported by simple routes, not by upstream %s",
+				uc.Service))
+		}
+	}
+	if len(invalidUpstreams) != 0 {
+		msgs = append(msgs, fmt.Sprintf(
+			"missing setting: ALLOWED_EMAIL_DOMAINS, ALLOWED_EMAIL_ADDRESSES, ALLOWED_GROUPS default in environment or override in upstream config in the following upstreams: %v",
+			invalidUpstreams))
+	}
+	return msgs
+}
+
+func validateDefaultRouteOptions(o *Options, msgs []string) []string {
+	switch o.DefaultRouteAction {
+	case defaultRouteRedirect:
+		if !validAbsoluteURL(o.DefaultRouteURL) {
+			msgs = append(msgs, "invalid value for default-route-url: must include scheme and host")
+		}
+	case defaultRouteUpstream:
+		found := false
+		for _, uc := range o.upstreamConfigs {
+			if uc.Service == o.DefaultRouteUpstream {
+				found = true
+			}
+		}
+		if !found {
+			msgs = append(msgs, fmt.Sprintf("invalid value for default-route-upstream: no upstream %q is configured",
+				o.DefaultRouteUpstream))
+		}
+	default:
+		if !isDefaultRouteAction(o.DefaultRouteAction) {
+			msgs = append(msgs, fmt.Sprintf("invalid value for default-route-action: %q", o.DefaultRouteAction))
+		}
+	}
+	return msgs
+}
+
+func validatePortalOptions(o *Options, msgs []string) []string {
+	if o.PortalHost == "" {
+		return msgs
+	}
+	if len(o.DefaultAllowedEmailDomains) == 0 && len(o.DefaultAllowedEmailAddresses) == 0 && len(o.DefaultAllowedGroups) == 0 {
+		msgs = append(msgs, "missing setting: DEFAULT_ALLOWED_EMAIL_DOMAINS, DEFAULT_ALLOWED_EMAIL_ADDRESSES, or DEFAULT_ALLOWED_GROUPS is required by the portal")
+	}
+	for _, uc := range o.upstreamConfigs {
+		if routeHost(uc.Route) == o.PortalHost {
+			msgs = append(msgs, fmt.Sprintf("invalid value for portal-host: %s is the host of upstream %s", o.PortalHost, uc.Service))
+		}
+	}
+	return msgs
+}
+
+func validAbsoluteURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
 func parseEnvironment(environ []string) map[string]string {
 	envPrefix := "SSO_CONFIG_"
 	env := make(map[string]string)
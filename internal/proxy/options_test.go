@@ -70,6 +70,14 @@ func TestInitializedOptions(t *testing.T) {
 	testutil.Equal(t, nil, o.Validate())
 }
 
+func TestClusters(t *testing.T) {
+	o := testOptions()
+	testutil.Equal(t, []string{"sso"}, o.clusters())
+
+	o.Clusters = []string{"staging", " sso", "", "staging", "dev"}
+	testutil.Equal(t, []string{"sso", "staging", "dev"}, o.clusters())
+}
+
 func TestProviderURLValidation(t *testing.T) {
 	testCases := []struct {
 		name                              string
@@ -183,6 +191,19 @@ func TestValidateCookieBadName(t *testing.T) {
 		fmt.Sprintf("  invalid cookie name: %q", o.CookieName))
 }
 
+func TestValidateCookieDeriveDomain(t *testing.T) {
+	o := testOptions()
+	o.CookieDeriveDomain = true
+	testutil.Equal(t, nil, o.Validate())
+
+	o = testOptions()
+	o.CookieDomain = ".example.com"
+	o.CookieDeriveDomain = true
+	err := o.Validate()
+	testutil.Equal(t, err.Error(), "Invalid configuration:\n"+
+		"  invalid setting: cookie-domain and cookie-derive-domain can't both be set")
+}
+
 func TestPassAccessToken(t *testing.T) {
 	o := testOptions()
 	testutil.Equal(t, false, o.PassAccessToken)
@@ -190,3 +211,156 @@ func TestPassAccessToken(t *testing.T) {
 	testutil.Equal(t, nil, o.Validate())
 	testutil.Equal(t, true, o.PassAccessToken)
 }
+
+func TestBuzzfeedCompat(t *testing.T) {
+	o := testOptions()
+	o.BuzzfeedCompat = true
+	testutil.Equal(t, nil, o.Validate())
+
+	o.ImpersonationGroups = []string{"admins"}
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{"invalid setting: IMPERSONATION_GROUPS can't be set with BUZZFEED_COMPAT"}), err.Error())
+}
+
+func TestValidateRequestSignatureDigest(t *testing.T) {
+	o := testOptions()
+	o.RequestSignatureDigest = true
+	o.BuzzfeedCompat = true
+	o.RequestSignatureMaxBodySize = -1
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"missing setting: REQUEST_SIGNATURE_KEY or REQUEST_SIGNATURE_KMS_KEY is required by REQUEST_SIGNATURE_DIGEST",
+		"invalid setting: REQUEST_SIGNATURE_DIGEST can't be set with BUZZFEED_COMPAT",
+		"invalid value for request-signature-max-body-size: must not be negative",
+	}), err.Error())
+
+	o = testOptions()
+	o.RequestSignatureDigest = true
+	o.RequestSigningKey = "key"
+	testutil.Equal(t, nil, o.Validate())
+
+	o = testOptions()
+	o.RequestSignatureDigest = true
+	o.RequestSignatureKMSKey = "arn:aws:kms:us-east-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+	testutil.Equal(t, nil, o.Validate())
+}
+
+func TestValidateAnomalyOptions(t *testing.T) {
+	testCases := []struct {
+		name        string
+		detectors   []string
+		actions     []string
+		webhookURL  string
+		expectedErr []string
+	}{
+		{
+			name:      "valid options",
+			detectors: []string{"user_agent_change", "excessive_forbidden"},
+			actions:   []string{"log", "reauthenticate"},
+		},
+		{
+			name:        "unknown detector",
+			detectors:   []string{"unknown"},
+			actions:     []string{"log"},
+			expectedErr: []string{`invalid anomaly detector: "unknown"`},
+		},
+		{
+			name:        "unknown action",
+			detectors:   []string{"user_agent_change"},
+			actions:     []string{"page"},
+			expectedErr: []string{`invalid anomaly action: "page"`},
+		},
+		{
+			name:        "impossible travel requires geoip",
+			detectors:   []string{"impossible_travel"},
+			actions:     []string{"log"},
+			expectedErr: []string{"missing setting: GEOIP_DATABASE is required by the impossible_travel anomaly detector"},
+		},
+		{
+			name:        "webhook requires url",
+			detectors:   []string{"user_agent_change"},
+			actions:     []string{"webhook"},
+			expectedErr: []string{"missing setting: anomaly-webhook-url"},
+		},
+		{
+			name:        "webhook requires valid url",
+			detectors:   []string{"user_agent_change"},
+			actions:     []string{"webhook"},
+			webhookURL:  "example.com/anomalies",
+			expectedErr: []string{"invalid value for anomaly-webhook-url: must include scheme and host"},
+		},
+		{
+			name:       "valid webhook",
+			detectors:  []string{"user_agent_change"},
+			actions:    []string{"webhook"},
+			webhookURL: "https://example.com/anomalies",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			o.AnomalyDetectors = tc.detectors
+			o.AnomalyActions = tc.actions
+			o.AnomalyWebhookURL = tc.webhookURL
+			err := o.Validate()
+			if len(tc.expectedErr) == 0 {
+				testutil.Equal(t, nil, err)
+				return
+			}
+			testutil.NotEqual(t, nil, err)
+			testutil.Equal(t, errorMsg(tc.expectedErr), err.Error())
+		})
+	}
+}
+
+func TestValidateWebhookOptions(t *testing.T) {
+	testCases := []struct {
+		name        string
+		url         string
+		events      []string
+		maxAttempts int
+		expectedErr []string
+	}{
+		{
+			name:        "valid options",
+			url:         "https://example.com/events",
+			events:      []string{"sign_in", "session_revoked"},
+			maxAttempts: 5,
+		},
+		{
+			name:        "invalid url",
+			url:         "example.com/events",
+			maxAttempts: 5,
+			expectedErr: []string{"invalid value for webhook-url: must include scheme and host"},
+		},
+		{
+			name:        "unknown event",
+			url:         "https://example.com/events",
+			events:      []string{"sign_up"},
+			maxAttempts: 5,
+			expectedErr: []string{`invalid webhook event: "sign_up"`},
+		},
+		{
+			name:        "invalid max attempts",
+			url:         "https://example.com/events",
+			expectedErr: []string{"invalid value for webhook-max-attempts: must be at least 1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			o.WebhookURL = tc.url
+			o.WebhookEvents = tc.events
+			o.WebhookMaxAttempts = tc.maxAttempts
+			err := o.Validate()
+			if len(tc.expectedErr) == 0 {
+				testutil.Equal(t, nil, err)
+				return
+			}
+			testutil.NotEqual(t, nil, err)
+			testutil.Equal(t, errorMsg(tc.expectedErr), err.Error())
+		})
+	}
+}
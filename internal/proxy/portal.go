@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/buzzfeed/sso/internal/pkg/options"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+)
+
+// portal is a landing page listing the upstreams a user is authorized to access.
+type portal struct {
+	entries []*portalEntry
+}
+
+// portalEntry is an upstream listed on the portal.
+type portalEntry struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+	URL         string `json:"url"`
+
+	validators []options.Validator
+}
+
+// newPortalEntry returns the portal entry of an upstream, or nil if the upstream can't be
+// linked to because its route matches hosts by regular expression.
+func newPortalEntry(upstreamConfig *UpstreamConfig, validators []options.Validator) *portalEntry {
+	var fromURL *url.URL
+	switch route := upstreamConfig.Route.(type) {
+	case *SimpleRoute:
+		fromURL = route.FromURL
+	case *StaticFilesRoute:
+		fromURL = route.FromURL
+	case *BucketRoute:
+		fromURL = route.FromURL
+	default:
+		return nil
+	}
+
+	name := upstreamConfig.DisplayName
+	if name == "" {
+		name = upstreamConfig.Service
+	}
+
+	return &portalEntry{
+		Name:        name,
+		Description: upstreamConfig.Description,
+		Icon:        upstreamConfig.Icon,
+		URL:         (&url.URL{Scheme: fromURL.Scheme, Host: fromURL.Host, Path: "/"}).String(),
+		validators:  validators,
+	}
+}
+
+// newPortalUpstreamConfig returns the upstream config the portal is served with. Users allowed
+// by the default allowed email domains, email addresses, or groups may access the portal.
+func newPortalUpstreamConfig(opts *Options) *UpstreamConfig {
+	return &UpstreamConfig{
+		Service: "portal",
+		RouteConfig: RouteConfig{
+			From: opts.PortalHost,
+		},
+		Route: &SimpleRoute{
+			FromURL: &url.URL{Scheme: opts.Scheme, Host: opts.PortalHost},
+		},
+		AllowedEmailDomains:   opts.DefaultAllowedEmailDomains,
+		AllowedEmailAddresses: opts.DefaultAllowedEmailAddresses,
+		AllowedGroups:         opts.DefaultAllowedGroups,
+		Timeout:               opts.DefaultUpstreamTimeout,
+		CookieName:            opts.CookieName,
+		ProviderSlug:          opts.DefaultProviderSlug,
+	}
+}
+
+// authorized returns whether the user of the session may access the entry's upstream,
+// using the same rule as the upstream itself: at least one of its validators must pass.
+func (e *portalEntry) authorized(session *sessions.SessionState) bool {
+	if len(e.validators) == 0 {
+		return false
+	}
+
+	// validators may record the groups that were matched on the session, which is shared
+	// between entries, so each entry validates a copy.
+	s := *session
+	errors := options.RunValidators(e.validators, &s)
+	return len(errors) < len(e.validators)
+}
+
+// authorizedEntries returns the entries whose upstreams the user of the session may access.
+func (pt *portal) authorizedEntries(session *sessions.SessionState) []*portalEntry {
+	entries := make([]*portalEntry, 0, len(pt.entries))
+	for _, entry := range pt.entries {
+		if entry.authorized(session) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// SetPortal serves the portal in place of proxying requests to an upstream.
+func SetPortal(pt *portal) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.portal = pt
+		// requests are never proxied, see Handler
+		op.handler = http.NotFoundHandler()
+		return nil
+	}
+}
+
+// Portal renders the landing page listing the upstreams the user is authorized to access,
+// as JSON for XHR requests or requests accepting JSON.
+func (p *OAuthProxy) Portal(rw http.ResponseWriter, req *http.Request) {
+	tags := []string{"action:portal"}
+
+	if req.URL.Path != "/" {
+		p.ErrorPage(rw, req, http.StatusNotFound, "Not Found", "There is no page at this address.")
+		return
+	}
+
+	session, err := p.authenticate(rw, req)
+	if err != nil {
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+
+	entries := p.portal.authorizedEntries(session)
+	p.StatsdClient.Incr("portal", tags, 1.0)
+
+	// the portal is specific to its user, and must not be cached
+	rw.Header().Set("Cache-Control", "no-store")
+
+	if p.isXHR(req) || strings.Contains(req.Header.Get("Accept"), "application/json") {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(entries)
+		return
+	}
+
+	p.templates.ExecuteTemplate(rw, "portal.html", struct {
+		Email   string
+		Entries []*portalEntry
+	}{
+		Email:   session.Email,
+		Entries: entries,
+	})
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/options"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func testPortal() *portal {
+	route := func(host string) *SimpleRoute {
+		return &SimpleRoute{FromURL: &url.URL{Scheme: "https", Host: host}}
+	}
+
+	return &portal{entries: []*portalEntry{
+		newPortalEntry(&UpstreamConfig{
+			Service:     "wiki",
+			Route:       route("wiki.sso.dev"),
+			DisplayName: "Wiki",
+			Description: "Internal documentation",
+			Icon:        "https://wiki.sso.dev/icon.png",
+		}, []options.Validator{options.NewEmailDomainValidator([]string{"gsa.gov"})}),
+		newPortalEntry(&UpstreamConfig{
+			Service: "payroll",
+			Route:   route("payroll.sso.dev"),
+		}, []options.Validator{options.NewEmailAddressValidator([]string{"finance@gsa.gov"})}),
+		newPortalEntry(&UpstreamConfig{
+			Service: "dashboards",
+			Route:   route("dashboards.sso.dev"),
+		}, []options.Validator{
+			options.NewEmailAddressValidator([]string{"finance@gsa.gov"}),
+			options.NewEmailDomainValidator([]string{"*"}),
+		}),
+	}}
+}
+
+func TestNewPortalEntry(t *testing.T) {
+	entry := newPortalEntry(&UpstreamConfig{
+		Service: "rewrite",
+		Route:   &RewriteRoute{},
+	}, nil)
+	testutil.Assert(t, entry == nil, "expected no entry for a rewrite route")
+
+	entry = newPortalEntry(&UpstreamConfig{
+		Service: "docs",
+		Route:   &StaticFilesRoute{FromURL: &url.URL{Scheme: "https", Host: "docs.sso.dev", Path: "/foo"}},
+	}, nil)
+	testutil.Equal(t, "docs", entry.Name)
+	testutil.Equal(t, "https://docs.sso.dev/", entry.URL)
+	testutil.Equal(t, false, entry.authorized(testSession()))
+}
+
+func TestPortal(t *testing.T) {
+	proxy, close := testNewOAuthProxy(t, SetPortal(testPortal()))
+	defer close()
+
+	req := httptest.NewRequest("GET", "https://portal.sso.dev/", nil)
+	req.Header.Set("Accept", "application/json")
+	rw := httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, "no-store", rw.Header().Get("Cache-Control"))
+
+	entries := []*portalEntry{}
+	err := json.NewDecoder(rw.Body).Decode(&entries)
+	testutil.Assert(t, err == nil, "unexpected error decoding entries: %s", err)
+	testutil.Equal(t, []*portalEntry{
+		{
+			Name:        "Wiki",
+			Description: "Internal documentation",
+			Icon:        "https://wiki.sso.dev/icon.png",
+			URL:         "https://wiki.sso.dev/",
+		},
+		{
+			Name: "dashboards",
+			URL:  "https://dashboards.sso.dev/",
+		},
+	}, entries)
+
+	req = httptest.NewRequest("GET", "https://portal.sso.dev/", nil)
+	rw = httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Assert(t, strings.Contains(rw.Body.String(), `<a href="https://wiki.sso.dev/">`), "expected link to wiki")
+	testutil.Assert(t, !strings.Contains(rw.Body.String(), "payroll"), "expected payroll to be hidden")
+
+	req = httptest.NewRequest("GET", "https://portal.sso.dev/foo", nil)
+	rw = httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusNotFound, rw.Code)
+}
+
+func TestValidatePortalOptions(t *testing.T) {
+	o := NewOptions()
+	testutil.Equal(t, []string{}, validatePortalOptions(o, []string{}))
+
+	o.PortalHost = "portal.sso.dev"
+	testutil.Equal(t, []string{
+		"missing setting: DEFAULT_ALLOWED_EMAIL_DOMAINS, DEFAULT_ALLOWED_EMAIL_ADDRESSES, or DEFAULT_ALLOWED_GROUPS is required by the portal",
+	}, validatePortalOptions(o, []string{}))
+
+	o.DefaultAllowedEmailDomains = []string{"gsa.gov"}
+	o.upstreamConfigs = []*UpstreamConfig{{
+		Service: "foo",
+		Route:   &SimpleRoute{FromURL: &url.URL{Scheme: "https", Host: "portal.sso.dev"}},
+	}}
+	testutil.Equal(t, []string{
+		"invalid value for portal-host: portal.sso.dev is the host of upstream foo",
+	}, validatePortalOptions(o, []string{}))
+}
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// postStashTTL is how long a stashed form submission waits for its sign in to complete.
+	postStashTTL = 10 * time.Minute
+	// postStashMaxEntries bounds the memory held by stashed form submissions, which are
+	// dropped rather than stashed once it is reached.
+	postStashMaxEntries = 10000
+)
+
+// postStash holds the form submissions that started a sign in, because the user's session
+// had expired, so that they can be replayed once the user has signed in rather than lost.
+// Submissions are sealed with the cookie cipher, so that they're encrypted at rest, and held
+// in memory by the proxy, so a sign in must complete on the replica it started on for its
+// submission to be replayed.
+type postStash struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*stashedPost
+}
+
+type stashedPost struct {
+	sealed  string
+	expires time.Time
+}
+
+// stashedForm is a form submission, as sealed in the stash.
+type stashedForm struct {
+	Host       string `json:"host"`
+	RequestURI string `json:"request_uri"`
+	Body       string `json:"body"`
+}
+
+// formField is a field of a replayed form submission.
+type formField struct {
+	Name  string
+	Value string
+}
+
+// newPostStash returns the stash of form submissions configured by opts, or nil if form
+// submissions aren't preserved.
+func newPostStash(opts *Options) *postStash {
+	if opts.PreservePostMaxBytes <= 0 {
+		return nil
+	}
+	return &postStash{
+		maxBytes: opts.PreservePostMaxBytes,
+		entries:  make(map[string]*stashedPost),
+	}
+}
+
+// SetPostStash sets the stash of form submissions, as a functional option
+func SetPostStash(s *postStash) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.postStash = s
+		return nil
+	}
+}
+
+// put stashes a sealed form submission under the ID of the sign in it started, returning false
+// if the stash is full.
+func (s *postStash) put(id, sealed string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	if len(s.entries) >= postStashMaxEntries {
+		return false
+	}
+	s.entries[id] = &stashedPost{sealed: sealed, expires: now.Add(postStashTTL)}
+	return true
+}
+
+// take removes and returns the sealed form submission stashed under the ID of a sign in, if
+// there is one that hasn't expired.
+func (s *postStash) take(id string, now time.Time) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id]
+	if !ok {
+		return "", false
+	}
+	delete(s.entries, id)
+	if !now.Before(e.expires) {
+		return "", false
+	}
+	return e.sealed, true
+}
+
+// stashPost stashes the body of a form submission starting a sign in, under the ID of the sign
+// in. Only url encoded forms no larger than the limit are stashed; others are lost as before.
+// Forms submitted from other sites are never stashed, so that a cross site form can't have
+// itself replayed with the user's session once they sign in.
+func (p *OAuthProxy) stashPost(req *http.Request, id string) {
+	if p.postStash == nil || req.Method != http.MethodPost || req.Body == nil {
+		return
+	}
+	tags := []string{"action:stash_post"}
+
+	if !isSameOrigin(req) && req.Header.Get("Sec-Fetch-Site") != "same-origin" {
+		p.StatsdClient.Incr("post_body_skipped", append(tags, "reason:cross_origin"), 1.0)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		p.StatsdClient.Incr("post_body_skipped", append(tags, "reason:unsupported"), 1.0)
+		return
+	}
+	if req.ContentLength > p.postStash.maxBytes {
+		p.StatsdClient.Incr("post_body_skipped", append(tags, "reason:too_large"), 1.0)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, p.postStash.maxBytes+1))
+	if err != nil || int64(len(body)) > p.postStash.maxBytes {
+		p.StatsdClient.Incr("post_body_skipped", append(tags, "reason:too_large"), 1.0)
+		return
+	}
+
+	sealed, err := p.cookieCipher.Marshal(&stashedForm{
+		Host:       req.Host,
+		RequestURI: req.URL.RequestURI(),
+		Body:       string(body),
+	})
+	if err != nil {
+		p.StatsdClient.Incr("application_error", append(tags, "error:seal_post_body"), 1.0)
+		requestLogEntry(req).Error(err, "error sealing form submission")
+		return
+	}
+	if !p.postStash.put(id, sealed, time.Now()) {
+		p.StatsdClient.Incr("post_body_skipped", append(tags, "reason:stash_full"), 1.0)
+		return
+	}
+	p.StatsdClient.Incr("post_body_stashed", tags, 1.0)
+}
+
+// replayPost responds to a completed sign in with a page resubmitting the form submission that
+// started it, returning true if there was one to replay.
+func (p *OAuthProxy) replayPost(rw http.ResponseWriter, req *http.Request, id, email string) bool {
+	if p.postStash == nil {
+		return false
+	}
+	sealed, ok := p.postStash.take(id, time.Now())
+	if !ok {
+		return false
+	}
+	tags := []string{"action:replay_post"}
+
+	form := &stashedForm{}
+	if err := p.cookieCipher.Unmarshal(sealed, form); err != nil || form.Host != req.Host {
+		p.StatsdClient.Incr("application_error", append(tags, "error:unseal_post_body"), 1.0)
+		return false
+	}
+	fields, err := formFields(form.Body)
+	if err != nil {
+		p.StatsdClient.Incr("application_error", append(tags, "error:parse_post_body"), 1.0)
+		return false
+	}
+
+	p.StatsdClient.Incr("post_body_replayed", tags, 1.0)
+	requestLogEntry(req).WithUser(email).WithRequestURI(form.RequestURI).Info("replaying form submission after sign in")
+
+	// the page holds the form's values, so it must not be cached
+	rw.Header().Set("Cache-Control", "no-store")
+	p.templates.ExecuteTemplate(rw, "post_replay.html", struct {
+		Host   string
+		Action string
+		Fields []formField
+	}{
+		Host:   req.Host,
+		Action: localRedirect(form.RequestURI),
+		Fields: fields,
+	})
+	return true
+}
+
+// formFields returns the fields of a url encoded form body, in the order they were submitted.
+func formFields(body string) ([]formField, error) {
+	fields := []formField{}
+	for _, pair := range strings.Split(body, "&") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		name, err := url.QueryUnescape(kv[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid field name %q: %s", kv[0], err)
+		}
+		var value string
+		if len(kv) == 2 {
+			if value, err = url.QueryUnescape(kv[1]); err != nil {
+				return nil, fmt.Errorf("invalid value of field %q: %s", name, err)
+			}
+		}
+		fields = append(fields, formField{Name: name, Value: value})
+	}
+	return fields, nil
+}
+
+// validatePostStashOptions validates the size limit of preserved form submissions.
+func validatePostStashOptions(o *Options, msgs []string) []string {
+	if o.PreservePostMaxBytes < 0 {
+		msgs = append(msgs, "invalid value for preserve-post-max-bytes: must not be negative")
+	}
+	return msgs
+}
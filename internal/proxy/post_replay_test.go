@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/proxy/providers"
+)
+
+func TestPostStash(t *testing.T) {
+	testutil.Assert(t, newPostStash(testOptions()) == nil, "expected no stash without a size limit")
+
+	o := testOptions()
+	o.PreservePostMaxBytes = 1024
+	stash := newPostStash(o)
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testutil.Equal(t, true, stash.put("a", "sealed-a", now))
+	testutil.Equal(t, true, stash.put("b", "sealed-b", now))
+
+	// submissions are taken once, and only before they expire
+	sealed, ok := stash.take("a", now.Add(time.Minute))
+	testutil.Equal(t, true, ok)
+	testutil.Equal(t, "sealed-a", sealed)
+	_, ok = stash.take("a", now.Add(time.Minute))
+	testutil.Equal(t, false, ok)
+	_, ok = stash.take("b", now.Add(postStashTTL))
+	testutil.Equal(t, false, ok)
+
+	o.PreservePostMaxBytes = -1
+	testutil.Equal(t, []string{
+		"invalid value for preserve-post-max-bytes: must not be negative",
+	}, validatePostStashOptions(o, []string{}))
+}
+
+func TestFormFields(t *testing.T) {
+	fields, err := formFields("b=2&a=1+2&a=%26&empty=&flag")
+	testutil.Ok(t, err)
+	testutil.Equal(t, []formField{
+		{Name: "b", Value: "2"},
+		{Name: "a", Value: "1 2"},
+		{Name: "a", Value: "&"},
+		{Name: "empty", Value: ""},
+		{Name: "flag", Value: ""},
+	}, fields)
+
+	_, err = formFields("a=%zz")
+	testutil.NotEqual(t, nil, err)
+}
+
+func TestPostReplay(t *testing.T) {
+	cipher, err := aead.NewMiscreantCipher([]byte(strings.Repeat("k", 32)))
+	testutil.Ok(t, err)
+
+	testCases := []struct {
+		name        string
+		contentType string
+		origin      string
+		fetchSite   string
+		body        string
+		wantReplay  bool
+	}{
+		{"form", "application/x-www-form-urlencoded", "https://localhost", "", "title=Hello&body=%3Cscript%3E", true},
+		{"form with fetch metadata", "application/x-www-form-urlencoded", "", "same-origin", "title=Hello&body=%3Cscript%3E", true},
+		{"cross origin form", "application/x-www-form-urlencoded", "https://evil.example.com", "cross-site", "title=Hello&body=%3Cscript%3E", false},
+		{"form without an origin", "application/x-www-form-urlencoded", "", "", "title=Hello&body=%3Cscript%3E", false},
+		{"form too large", "application/x-www-form-urlencoded", "https://localhost", "", "body=" + strings.Repeat("a", 64), false},
+		{"json", "application/json", "https://localhost", "", `{"title":"Hello"}`, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			o.PreservePostMaxBytes = 64
+			csrfStore := &sessions.MockCSRFStore{}
+			provider := providers.NewTestProvider(&url.URL{Host: "localhost"}, "")
+			provider.RedeemFunc = func(string, string) (*sessions.SessionState, error) {
+				return testSession(), nil
+			}
+			proxy, close := testNewOAuthProxy(t,
+				SetProvider(provider),
+				setSessionStore(&sessions.MockSessionStore{LoadError: http.ErrNoCookie}),
+				setCSRFStore(csrfStore),
+				setCookieCipher(cipher),
+				SetPostStash(newPostStash(o)),
+			)
+			defer close()
+
+			req := httptest.NewRequest("POST", "https://localhost/posts?draft=1", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			if tc.fetchSite != "" {
+				req.Header.Set("Sec-Fetch-Site", tc.fetchSite)
+			}
+			rw := httptest.NewRecorder()
+			proxy.Handler().ServeHTTP(rw, req)
+			testutil.Equal(t, http.StatusFound, rw.Code)
+			location, err := rw.Result().Location()
+			testutil.Ok(t, err)
+
+			csrfStore.Cookie = &http.Cookie{Value: csrfStore.ResponseCSRF}
+			callback := "https://localhost/oauth2/callback?" + url.Values{
+				"code":  {"code"},
+				"state": {location.Query().Get("state")},
+			}.Encode()
+			rw = httptest.NewRecorder()
+			proxy.Handler().ServeHTTP(rw, httptest.NewRequest("GET", callback, nil))
+
+			if !tc.wantReplay {
+				testutil.Equal(t, http.StatusFound, rw.Code)
+				testutil.Equal(t, "https://localhost/posts?draft=1", rw.Header().Get("Location"))
+				return
+			}
+			testutil.Equal(t, http.StatusOK, rw.Code)
+			testutil.Equal(t, "no-store", rw.Header().Get("Cache-Control"))
+			body := rw.Body.String()
+			for _, want := range []string{
+				`action="/posts?draft=1"`,
+				`name="title" value="Hello"`,
+				`name="body" value="&lt;script&gt;"`,
+			} {
+				testutil.Assert(t, strings.Contains(body, want), "expected %q in body: %s", want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parsePreflightOrigins returns the origins whose preflight requests skip authentication, in the
+// form browsers send them in the Origin header.
+func parsePreflightOrigins(origins []string) ([]string, error) {
+	parsed := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		u, err := url.Parse(strings.TrimSuffix(origin, "/"))
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return nil, fmt.Errorf("%q is not an origin, which is a scheme and host, e.g. https://example.com", origin)
+		}
+		parsed = append(parsed, strings.ToLower(u.Scheme+"://"+u.Host))
+	}
+	return parsed, nil
+}
+
+// isSkippedPreflight returns true if the request is a preflight request whose authentication is
+// skipped, either by SKIP_AUTH_PREFLIGHT or by the skip_auth_preflight option of the upstream.
+// When the upstream lists preflight_origins, only CORS preflight requests from those origins are
+// skipped, rather than every OPTIONS request.
+func (p *OAuthProxy) isSkippedPreflight(req *http.Request) bool {
+	if req.Method != http.MethodOptions || !p.skipAuthPreflight && !p.upstreamConfig.SkipAuthPreflight {
+		return false
+	}
+
+	origins := p.upstreamConfig.PreflightOrigins
+	if len(origins) == 0 {
+		return true
+	}
+	origin := strings.ToLower(req.Header.Get("Origin"))
+	if origin == "" || req.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	for _, o := range origins {
+		if o == origin {
+			return true
+		}
+	}
+	return false
+}
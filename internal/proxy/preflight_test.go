@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestParsePreflightOrigins(t *testing.T) {
+	origins, err := parsePreflightOrigins([]string{"https://App.example.com/", "http://localhost:3000"})
+	testutil.Ok(t, err)
+	testutil.Equal(t, []string{"https://app.example.com", "http://localhost:3000"}, origins)
+
+	for _, origin := range []string{"app.example.com", "https://app.example.com/path", "ftp://app.example.com"} {
+		_, err := parsePreflightOrigins([]string{origin})
+		testutil.NotEqual(t, nil, err)
+	}
+}
+
+func TestIsSkippedPreflight(t *testing.T) {
+	testCases := []struct {
+		name              string
+		skipAuthPreflight bool
+		upstream          *UpstreamConfig
+		method            string
+		headers           map[string]string
+		expected          bool
+	}{
+		{
+			name:     "preflight requests aren't skipped by default",
+			upstream: &UpstreamConfig{},
+			method:   "OPTIONS",
+		},
+		{
+			name:              "every preflight request is skipped globally",
+			skipAuthPreflight: true,
+			upstream:          &UpstreamConfig{},
+			method:            "OPTIONS",
+			expected:          true,
+		},
+		{
+			name:              "other methods aren't skipped",
+			skipAuthPreflight: true,
+			upstream:          &UpstreamConfig{},
+			method:            "GET",
+		},
+		{
+			name:     "preflight requests are skipped for the upstream",
+			upstream: &UpstreamConfig{SkipAuthPreflight: true},
+			method:   "OPTIONS",
+			expected: true,
+		},
+		{
+			name:     "cors preflight requests from allowed origins are skipped",
+			upstream: &UpstreamConfig{SkipAuthPreflight: true, PreflightOrigins: []string{"https://app.example.com"}},
+			method:   "OPTIONS",
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			expected: true,
+		},
+		{
+			name:              "cors preflight requests from other origins aren't skipped",
+			skipAuthPreflight: true,
+			upstream:          &UpstreamConfig{PreflightOrigins: []string{"https://app.example.com"}},
+			method:            "OPTIONS",
+			headers: map[string]string{
+				"Origin":                        "https://evil.example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+		},
+		{
+			name:     "options requests that aren't cors preflight requests aren't skipped",
+			upstream: &UpstreamConfig{SkipAuthPreflight: true, PreflightOrigins: []string{"https://app.example.com"}},
+			method:   "OPTIONS",
+			headers: map[string]string{
+				"Origin": "https://app.example.com",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t,
+				setSkipAuthPreflight(tc.skipAuthPreflight),
+				SetUpstreamConfig(tc.upstream),
+			)
+			defer close()
+
+			req := httptest.NewRequest(tc.method, "https://localhost/", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			testutil.Equal(t, tc.expected, proxy.IsWhitelistedRequest(req))
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"fmt"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+// pseudonymizationKeyMinLength is the length, in bytes, of the shortest pseudonymization key,
+// so that pseudonyms can't be reversed by hashing guessed emails with guessed keys.
+const pseudonymizationKeyMinLength = 32
+
+// validatePrivacyOptions validates that privacy mode has a key to pseudonymize users with, and
+// the retention of the records of users' requests.
+func validatePrivacyOptions(o *Options, msgs []string) []string {
+	if o.PrivacyMode && o.PseudonymizationKey == "" {
+		msgs = append(msgs, "missing setting: PSEUDONYMIZATION_KEY is required by PRIVACY_MODE")
+	}
+	if o.PseudonymizationKey != "" && len(o.PseudonymizationKey) < pseudonymizationKeyMinLength {
+		msgs = append(msgs, fmt.Sprintf("invalid value for pseudonymization-key: must be at least %d bytes", pseudonymizationKeyMinLength))
+	}
+	if o.AuditRetention < 0 {
+		msgs = append(msgs, "invalid value for audit-retention: must not be negative")
+	}
+	return msgs
+}
+
+// configurePrivacy pseudonymizes the users of every log entry in privacy mode, along with the
+// identity headers of the requests logged by debug captures and honeypots, see newLogScrubber.
+// Metrics are never tagged with users, so they need no pseudonymizing.
+func configurePrivacy(opts *Options) {
+	if opts.PrivacyMode {
+		log.SetPseudonymizationKey([]byte(opts.PseudonymizationKey))
+	}
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/anomaly"
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestValidatePrivacyOptions(t *testing.T) {
+	o := testOptions()
+	o.PrivacyMode = true
+	o.AuditRetention = -time.Hour
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"missing setting: PSEUDONYMIZATION_KEY is required by PRIVACY_MODE",
+		"invalid value for audit-retention: must not be negative",
+	}), err.Error())
+
+	o = testOptions()
+	o.PrivacyMode = true
+	o.PseudonymizationKey = "too-short"
+	err = o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"invalid value for pseudonymization-key: must be at least 32 bytes",
+	}), err.Error())
+
+	o.PseudonymizationKey = strings.Repeat("k", 32)
+	o.AuditRetention = 24 * time.Hour
+	testutil.Ok(t, o.Validate())
+}
+
+func TestConfigurePrivacy(t *testing.T) {
+	defer log.SetPseudonymizationKey(nil)
+
+	o := testOptions()
+	configurePrivacy(o)
+	testutil.Equal(t, "user@example.com", log.Pseudonymize("user@example.com"))
+
+	o.PrivacyMode = true
+	o.PseudonymizationKey = strings.Repeat("k", 32)
+	configurePrivacy(o)
+	testutil.NotEqual(t, "user@example.com", log.Pseudonymize("user@example.com"))
+}
+
+func TestHARRecorderRetention(t *testing.T) {
+	recorder := newHARRecorder(time.Hour)
+	recorder.services["foo"] = true
+
+	old, _, err := recorder.start("foo", 10)
+	testutil.Ok(t, err)
+	recorder.record("foo", &harEntry{})
+	testutil.Equal(t, 1, len(recorder.list()))
+
+	// captures started longer than the retention ago are dropped, even while recording
+	old.StartedAt = time.Now().Add(-2 * time.Hour)
+	testutil.Equal(t, 0, len(recorder.list()))
+	testutil.Equal(t, false, recorder.capturing("foo"))
+	_, _, ok := recorder.har(old.ID)
+	testutil.Equal(t, false, ok)
+}
+
+func TestAdminHandlerSubjects(t *testing.T) {
+	store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+	rawToken, _ := testIssueBearerToken(t, store, "user@example.com")
+	testIssueBearerToken(t, store, "other@example.com")
+
+	// an approved authorization whose token hasn't been handed to the device yet
+	auth, err := store.start("localhost")
+	testutil.Ok(t, err)
+	testutil.Ok(t, store.approve(auth.UserCode, "localhost", &bearerToken{Email: "user@example.com"}))
+
+	recorder := newHARRecorder(0)
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	harHandler := newHARHandler(upstream, &UpstreamConfig{Service: "foo"}, recorder)
+	_, _, err = recorder.start("foo", 10)
+	testutil.Ok(t, err)
+	for _, email := range []string{"user@example.com", "other@example.com", "USER@example.com"} {
+		req := httptest.NewRequest("GET", "https://foo.sso.dev/", nil)
+		req.Header.Set("X-Forwarded-Email", email)
+		harHandler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	detector := anomaly.NewExcessiveForbiddenDetector(2, time.Minute)
+	anomalies := &anomalyMonitor{detectors: []anomaly.Detector{detector}}
+	forbidden := anomaly.Activity{Email: "user@example.com", StatusCode: http.StatusForbidden, Time: time.Now()}
+	testutil.Equal(t, 0, len(anomalies.observe(forbidden, time.Time{})))
+
+	handler := newAdminHandler(adminConfig{token: "admin-token", deviceTokens: store, anomalies: anomalies, har: recorder})
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	testutil.Equal(t, http.StatusMethodNotAllowed, serve("GET", "http://localhost/admin/subjects?email=user@example.com").Code)
+	testutil.Equal(t, http.StatusBadRequest, serve("DELETE", "http://localhost/admin/subjects").Code)
+
+	rw := serve("DELETE", "http://localhost/admin/subjects?email=user@example.com")
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	purged := struct {
+		BearerTokens int `json:"bearer_tokens"`
+		HAREntries   int `json:"har_entries"`
+	}{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &purged))
+	testutil.Equal(t, 2, purged.BearerTokens)
+	testutil.Equal(t, 2, purged.HAREntries)
+
+	_, ok := store.lookup(rawToken, "localhost")
+	testutil.Equal(t, false, ok)
+	testutil.Equal(t, 1, len(store.list("")))
+	_, token, errCode := store.poll(auth.DeviceCode, "localhost")
+	testutil.Assert(t, token == nil, "expected the approved authorization to be dropped")
+	testutil.Equal(t, errInvalidGrant, errCode)
+
+	har, _, ok := recorder.har(recorder.list()[0].ID)
+	testutil.Equal(t, true, ok)
+	testutil.Equal(t, 1, len(har.Log.Entries))
+	testutil.Equal(t, "other@example.com", har.Log.Entries[0].user)
+
+	// the detector counts the user's forbidden responses afresh
+	testutil.Equal(t, 0, len(anomalies.observe(forbidden, time.Time{})))
+}
@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"crypto/tls"
 	"net"
 	"net/http"
 	"time"
@@ -15,3 +16,9 @@ var httpClient = &http.Client{
 		TLSHandshakeTimeout: 2 * time.Second,
 	},
 }
+
+// SetTLSConfig sets the TLS config of the connections to providers. It's called at startup,
+// before any request to a provider.
+func SetTLSConfig(c *tls.Config) {
+	httpClient.Transport.(*http.Transport).TLSClientConfig = c
+}
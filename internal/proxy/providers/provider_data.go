@@ -21,6 +21,8 @@ type ProviderData struct {
 	ProfileURL          *url.URL
 	ValidateURL         *url.URL
 	Scope               string
+	// Scopes are the additional scopes the upstream requires the user to have granted
+	Scopes []string
 
 	SessionValidTTL    time.Duration
 	SessionLifetimeTTL time.Duration
@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/buzzfeed/sso/internal/pkg/sessions"
 	"github.com/buzzfeed/sso/internal/pkg/singleflight"
@@ -33,26 +34,41 @@ var (
 // * https://www.varnish-cache.org/docs/3.0/tutorial/handling_misbehaving_servers.html
 // * http://nginx.org/en/docs/http/ngx_http_proxy_module.html#proxy_cache_lock
 // * http://wiki.squid-cache.org/Features/CollapsedForwarding
+//
+// The calls of every upstream's provider can be coalesced by sharing a singleflight.Group
+// between them, and bounded by sharing a WorkerPool.
 type SingleFlightProvider struct {
 	StatsdClient *statsd.Client
 
 	provider Provider
 
 	single *singleflight.Group
+	pool   *WorkerPool
 }
 
-// NewSingleFlightProvider instatiates a SingleFlightProvider given a provider and statsdClient
-func NewSingleFlightProvider(provider Provider, StatsdClient *statsd.Client) *SingleFlightProvider {
+// NewSingleFlightProvider instatiates a SingleFlightProvider given a provider and statsdClient.
+// A nil single creates a group used by this provider alone, and a nil pool leaves calls to
+// the provider unbounded.
+func NewSingleFlightProvider(provider Provider, single *singleflight.Group, pool *WorkerPool, StatsdClient *statsd.Client) *SingleFlightProvider {
+	if single == nil {
+		single = &singleflight.Group{}
+	}
 	return &SingleFlightProvider{
 		provider:     provider,
-		single:       &singleflight.Group{},
+		single:       single,
+		pool:         pool,
 		StatsdClient: StatsdClient,
 	}
 }
 
 func (p *SingleFlightProvider) do(endpoint, key string, fn func() (interface{}, error)) (interface{}, error) {
-	compositeKey := fmt.Sprintf("%s/%s", endpoint, key)
-	resp, shared, err := p.single.Do(compositeKey, fn)
+	compositeKey := fmt.Sprintf("%s/%s/%s", endpoint, p.provider.Data().ProviderSlug, key)
+	resp, shared, err := p.single.Do(compositeKey, func() (resp interface{}, err error) {
+		p.pool.Do(endpoint, func() {
+			resp, err = fn()
+		})
+		return resp, err
+	})
 	if shared > 0 {
 		tags := []string{fmt.Sprintf("endpoint:%s", endpoint)}
 		p.StatsdClient.Incr("provider.singleflight", tags, float64(shared))
@@ -60,6 +76,46 @@ func (p *SingleFlightProvider) do(endpoint, key string, fn func() (interface{},
 	return resp, err
 }
 
+// groupsKey returns a key identifying a set of groups, regardless of their order.
+func groupsKey(groups []string) string {
+	sorted := make([]string, len(groups))
+	copy(sorted, groups)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// sessionResult holds the result of a coalesced call that updates a session, along with the
+// fields of the session the provider updated, so they can be applied to the session of each
+// caller the result is shared with.
+type sessionResult struct {
+	ok bool
+
+	accessToken      string
+	groups           []string
+	refreshDeadline  time.Time
+	validDeadline    time.Time
+	gracePeriodStart time.Time
+}
+
+func newSessionResult(ok bool, s *sessions.SessionState) *sessionResult {
+	return &sessionResult{
+		ok:               ok,
+		accessToken:      s.AccessToken,
+		groups:           s.Groups,
+		refreshDeadline:  s.RefreshDeadline,
+		validDeadline:    s.ValidDeadline,
+		gracePeriodStart: s.GracePeriodStart,
+	}
+}
+
+func (r *sessionResult) apply(s *sessions.SessionState) {
+	s.AccessToken = r.accessToken
+	s.Groups = r.groups
+	s.RefreshDeadline = r.refreshDeadline
+	s.ValidDeadline = r.validDeadline
+	s.GracePeriodStart = r.gracePeriodStart
+}
+
 // Data calls the provider's Data function
 func (p *SingleFlightProvider) Data() *ProviderData {
 	return p.provider.Data()
@@ -79,7 +135,7 @@ func (p *SingleFlightProvider) ValidateGroup(email string, allowedGroups []strin
 func (p *SingleFlightProvider) UserGroups(email string, groups []string, accessToken string) ([]string, error) {
 	// sort the groups so that other requests may be able to use the cached request
 	sort.Strings(groups)
-	response, err := p.do("UserGroups", fmt.Sprintf("%s:%s", email, strings.Join(groups, ",")), func() (interface{}, error) {
+	response, err := p.do("UserGroups", fmt.Sprintf("%s:%s", email, groupsKey(groups)), func() (interface{}, error) {
 		return p.provider.UserGroups(email, groups, accessToken)
 	})
 	if err != nil {
@@ -96,38 +152,53 @@ func (p *SingleFlightProvider) UserGroups(email string, groups []string, accessT
 
 // ValidateSessionState calls the provider's ValidateSessionState function and returns the response
 func (p *SingleFlightProvider) ValidateSessionState(s *sessions.SessionState, allowedGroups []string) bool {
-	response, err := p.do("ValidateSessionState", s.AccessToken, func() (interface{}, error) {
+	leader := false
+	response, err := p.do("ValidateSessionState", fmt.Sprintf("%s:%s", s.AccessToken, groupsKey(allowedGroups)), func() (interface{}, error) {
+		leader = true
 		valid := p.provider.ValidateSessionState(s, allowedGroups)
-		return valid, nil
+		return newSessionResult(valid, s), nil
 	})
 	if err != nil {
 		return false
 	}
 
-	valid, ok := response.(bool)
+	result, ok := response.(*sessionResult)
 	if !ok {
 		return false
 	}
+	if !leader && result.ok {
+		result.apply(s)
+	}
 
-	return valid
+	return result.ok
 }
 
 // RefreshSession takes in a SessionState and allowedGroups and
 // returns false if the session is not refreshed and true if it is.
 func (p *SingleFlightProvider) RefreshSession(s *sessions.SessionState, allowedGroups []string) (bool, error) {
-	response, err := p.do("RefreshSession", s.RefreshToken, func() (interface{}, error) {
-		return p.provider.RefreshSession(s, allowedGroups)
+	leader := false
+	response, err := p.do("RefreshSession", fmt.Sprintf("%s:%s", s.RefreshToken, groupsKey(allowedGroups)), func() (interface{}, error) {
+		leader = true
+		refreshed, err := p.provider.RefreshSession(s, allowedGroups)
+		if err != nil {
+			return nil, err
+		}
+		return newSessionResult(refreshed, s), nil
 	})
 	if err != nil {
 		return false, err
 	}
 
-	r, ok := response.(bool)
+	result, ok := response.(*sessionResult)
 	if !ok {
 		return false, ErrUnexpectedReturnType
 	}
+	// callers the refresh was shared with are refreshed too
+	if !leader && result.ok {
+		result.apply(s)
+	}
 
-	return r, nil
+	return result.ok, nil
 }
 
 // GetSignInURL calls the GetSignInURL for the provider, which will return the sign in url
@@ -0,0 +1,92 @@
+package providers
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/singleflight"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestSingleFlightRefreshSessionShared(t *testing.T) {
+	providerURL, _ := url.Parse("http://localhost/")
+	refreshedDeadline := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	var calls int64
+	started := make(chan struct{})
+	release := make(chan struct{})
+	tp := NewTestProvider(providerURL, "")
+	tp.ProviderSlug = "idp"
+	tp.RefreshSessionFunc = func(s *sessions.SessionState, groups []string) (bool, error) {
+		atomic.AddInt64(&calls, 1)
+		close(started)
+		<-release
+		s.AccessToken = "new_access_token"
+		s.Groups = []string{"writers"}
+		s.RefreshDeadline = refreshedDeadline
+		return true, nil
+	}
+
+	// the providers of two upstreams sharing a group coalesce their calls
+	single := &singleflight.Group{}
+	pool := NewWorkerPool(1, nil)
+	first := NewSingleFlightProvider(tp, single, pool, nil)
+	second := NewSingleFlightProvider(tp, single, pool, nil)
+
+	newSession := func() *sessions.SessionState {
+		return &sessions.SessionState{
+			Email:        "jane@example.com",
+			AccessToken:  "old_access_token",
+			RefreshToken: "refresh_token",
+		}
+	}
+	leader, follower := newSession(), newSession()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ok, err := first.RefreshSession(leader, []string{"writers", "readers"})
+		testutil.Assert(t, err == nil, "unexpected error: %s", err)
+		testutil.Assert(t, ok, "expected session to be refreshed")
+	}()
+	<-started
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// the order of the allowed groups doesn't matter
+		ok, err := second.RefreshSession(follower, []string{"readers", "writers"})
+		testutil.Assert(t, err == nil, "unexpected error: %s", err)
+		testutil.Assert(t, ok, "expected session to be refreshed")
+	}()
+
+	// wait for the second call to join the first before letting it complete
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	testutil.Equal(t, int64(1), atomic.LoadInt64(&calls))
+	for _, s := range []*sessions.SessionState{leader, follower} {
+		testutil.Equal(t, "new_access_token", s.AccessToken)
+		testutil.Equal(t, []string{"writers"}, s.Groups)
+		testutil.Equal(t, refreshedDeadline, s.RefreshDeadline)
+	}
+}
+
+func TestSingleFlightKeysIncludeAllowedGroups(t *testing.T) {
+	providerURL, _ := url.Parse("http://localhost/")
+	tp := NewTestProvider(providerURL, "")
+	tp.ValidateSessionFunc = func(s *sessions.SessionState, groups []string) bool {
+		return len(groups) == 1 && groups[0] == "writers"
+	}
+	p := NewSingleFlightProvider(tp, nil, nil, nil)
+
+	s := &sessions.SessionState{AccessToken: "access_token"}
+	testutil.Assert(t, p.ValidateSessionState(s, []string{"writers"}), "expected session to be valid")
+	testutil.Assert(t, !p.ValidateSessionState(s, []string{"admins"}), "expected session to be invalid")
+}
@@ -147,10 +147,12 @@ func (p *SSOProvider) Redeem(redirectURL, code string) (*sessions.SessionState,
 	}
 
 	var jsonResponse struct {
-		AccessToken  string `json:"access_token"`
-		RefreshToken string `json:"refresh_token"`
-		ExpiresIn    int64  `json:"expires_in"`
-		Email        string `json:"email"`
+		AccessToken  string                 `json:"access_token"`
+		RefreshToken string                 `json:"refresh_token"`
+		ExpiresIn    int64                  `json:"expires_in"`
+		Email        string                 `json:"email"`
+		Scopes       []string               `json:"scopes"`
+		Claims       map[string]interface{} `json:"claims"`
 	}
 	err = json.Unmarshal(body, &jsonResponse)
 	if err != nil {
@@ -165,12 +167,15 @@ func (p *SSOProvider) Redeem(redirectURL, code string) (*sessions.SessionState,
 		AccessToken:  jsonResponse.AccessToken,
 		RefreshToken: jsonResponse.RefreshToken,
 
+		IssuedAt:         time.Now().Truncate(time.Second),
 		RefreshDeadline:  extendDeadline(time.Duration(jsonResponse.ExpiresIn) * time.Second),
 		LifetimeDeadline: extendDeadline(p.SessionLifetimeTTL),
 		ValidDeadline:    extendDeadline(p.SessionValidTTL),
 
-		Email: jsonResponse.Email,
-		User:  user,
+		Email:  jsonResponse.Email,
+		User:   user,
+		Scopes: jsonResponse.Scopes,
+		Claims: jsonResponse.Claims,
 	}, nil
 }
 
@@ -416,6 +421,10 @@ func (p *SSOProvider) GetSignInURL(redirectURL *url.URL, state string) *url.URL
 	params, _ := url.ParseQuery(a.RawQuery)
 	params.Set("redirect_uri", rawRedirect)
 	params.Add("scope", p.Scope)
+	if len(p.Scopes) != 0 {
+		// the authenticator requests consent for the scopes the user hasn't granted yet
+		params.Set("required_scopes", strings.Join(p.Scopes, " "))
+	}
 	params.Set("client_id", p.ClientID)
 	params.Set("response_type", "code")
 	params.Add("state", state)
@@ -0,0 +1,60 @@
+package providers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/proxy/providertest"
+)
+
+func TestSSOProviderWithSimulator(t *testing.T) {
+	sim := providertest.NewServer("idp", "client", "secret")
+	defer sim.Close()
+	sim.AddUser("jane@example.com", "writers", "readers")
+	sim.SignInAs("jane@example.com")
+
+	simURL, _ := url.Parse(sim.URL)
+	p := NewSSOProvider(&ProviderData{
+		ProviderSlug:       "idp",
+		ProviderURL:        simURL,
+		ClientID:           "client",
+		ClientSecret:       "secret",
+		SessionLifetimeTTL: time.Hour,
+		SessionValidTTL:    time.Minute,
+	}, nil)
+
+	// sign in through the simulator, which redirects back with a code
+	signInURL := p.GetSignInURL(&url.URL{Scheme: "https", Host: "proxy.example.com", Path: "/oauth2/callback"}, "state")
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(signInURL.String())
+	testutil.Assert(t, err == nil, "unexpected error signing in: %s", err)
+	resp.Body.Close()
+	testutil.Equal(t, http.StatusFound, resp.StatusCode)
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	session, err := p.Redeem("https://proxy.example.com/oauth2/callback", callback.Query().Get("code"))
+	testutil.Assert(t, err == nil, "unexpected error redeeming code: %s", err)
+	testutil.Equal(t, "jane@example.com", session.Email)
+	testutil.Equal(t, "jane", session.User)
+
+	ok, err := p.RefreshSession(session, []string{"writers"})
+	testutil.Assert(t, err == nil, "unexpected error refreshing session: %s", err)
+	testutil.Assert(t, ok, "expected session to be refreshed")
+	testutil.Equal(t, []string{"writers"}, session.Groups)
+
+	testutil.Assert(t, p.ValidateSessionState(session, []string{"writers"}), "expected session to be valid")
+
+	sim.SetGroups("jane@example.com", "readers")
+	testutil.Assert(t, !p.ValidateSessionState(session, []string{"writers"}), "expected group membership to be revoked")
+
+	sim.SetBehavior(providertest.Profile, providertest.Behavior{StatusCode: http.StatusServiceUnavailable, Times: 1})
+	_, err = p.UserGroups("jane@example.com", []string{"readers"}, session.AccessToken)
+	testutil.Equal(t, ErrAuthProviderUnavailable, err)
+
+	sim.RevokeTokens("jane@example.com")
+	_, err = p.RefreshSession(session, []string{"readers"})
+	testutil.Equal(t, ErrTokenRevoked, err)
+}
@@ -114,11 +114,13 @@ func TestSSOProviderDefaults(t *testing.T) {
 }
 
 type redeemResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int64  `json:"expires_in"`
-	Email        string `json:"email"`
-	User         string `json:"user"`
+	AccessToken  string                 `json:"access_token"`
+	RefreshToken string                 `json:"refresh_token"`
+	ExpiresIn    int64                  `json:"expires_in"`
+	Email        string                 `json:"email"`
+	User         string                 `json:"user"`
+	Scopes       []string               `json:"scopes,omitempty"`
+	Claims       map[string]interface{} `json:"claims,omitempty"`
 }
 
 type refreshResponse struct {
@@ -260,6 +262,23 @@ func TestSSOProviderRedeem(t *testing.T) {
 				Groups: []string{"users@example.com"},
 			},
 		},
+		{
+			Name: "redeem successful, with granted scopes",
+			Code: "code1234",
+			RedeemResponse: &redeemResponse{
+				AccessToken:  "a1234",
+				ExpiresIn:    10,
+				RefreshToken: "refresh12345",
+				Email:        "michael.bland@gsa.gov",
+				User:         "michael.bland",
+				Scopes:       []string{"email", "calendar.readonly"},
+				Claims:       map[string]interface{}{"name": "Michael Bland"},
+			},
+			ProfileResponse: &profileResponse{
+				Email:  "michael.bland@gsa.gov",
+				Groups: []string{"core@gsa.gov"},
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -294,6 +313,9 @@ func TestSSOProviderRedeem(t *testing.T) {
 				testutil.Equal(t, tc.RedeemResponse.AccessToken, session.AccessToken)
 				testutil.Equal(t, tc.RedeemResponse.RefreshToken, session.RefreshToken)
 				testutil.Equal(t, tc.RedeemResponse.User, session.User)
+				testutil.Equal(t, tc.RedeemResponse.Scopes, session.Scopes)
+				testutil.Equal(t, tc.RedeemResponse.Claims, session.Claims)
+				testutil.Assert(t, !session.IssuedAt.IsZero(), "expected session issued at to be set")
 			}
 			if tc.ExpectedError != "" && !strings.Contains(err.Error(), tc.ExpectedError) {
 				t.Errorf("got unexpected result.\nwant=%v\ngot=%v\n", tc.ExpectedError, err.Error())
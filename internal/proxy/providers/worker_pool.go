@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// WorkerPool runs calls to a provider on a fixed number of workers. When many sessions need to
+// be refreshed or validated at once, e.g. because they were issued together, calls beyond the
+// number of workers wait in a queue instead of all being made to the provider concurrently.
+//
+// A nil *WorkerPool runs calls immediately.
+type WorkerPool struct {
+	StatsdClient *statsd.Client
+
+	jobs   chan func()
+	queued int64
+}
+
+// NewWorkerPool starts a WorkerPool with the given number of workers. If workers isn't
+// positive, it returns nil, leaving calls to the provider unbounded.
+func NewWorkerPool(workers int, StatsdClient *statsd.Client) *WorkerPool {
+	if workers <= 0 {
+		return nil
+	}
+
+	wp := &WorkerPool{
+		StatsdClient: StatsdClient,
+		jobs:         make(chan func()),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range wp.jobs {
+				job()
+			}
+		}()
+	}
+	return wp
+}
+
+// Do runs fn on a worker once one is free, returning after it completes. A panic in fn is
+// re-raised in the calling goroutine, so that it is recovered like any other in a handler.
+func (wp *WorkerPool) Do(endpoint string, fn func()) {
+	if wp == nil {
+		fn()
+		return
+	}
+
+	tags := []string{"endpoint:" + endpoint}
+	queuedAt := time.Now()
+	wp.StatsdClient.Gauge("provider.queue_depth", float64(atomic.AddInt64(&wp.queued, 1)), tags, 1.0)
+
+	done := make(chan interface{}, 1)
+	wp.jobs <- func() {
+		wp.StatsdClient.Gauge("provider.queue_depth", float64(atomic.AddInt64(&wp.queued, -1)), tags, 1.0)
+		wp.StatsdClient.Timing("provider.queue_wait", time.Since(queuedAt), tags, 1.0)
+
+		defer func() {
+			done <- recover()
+		}()
+		fn()
+	}
+
+	if r := <-done; r != nil {
+		panic(r)
+	}
+}
+
+// QueueDepth returns the number of calls waiting for a free worker.
+func (wp *WorkerPool) QueueDepth() int {
+	if wp == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&wp.queued))
+}
@@ -0,0 +1,73 @@
+package providers
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestWorkerPoolBoundsConcurrency(t *testing.T) {
+	pool := NewWorkerPool(2, nil)
+
+	var running, maxRunning int64
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pool.Do("RefreshSession", func() {
+				n := atomic.AddInt64(&running, 1)
+				for {
+					max := atomic.LoadInt64(&maxRunning)
+					if n <= max || atomic.CompareAndSwapInt64(&maxRunning, max, n) {
+						break
+					}
+				}
+				<-release
+				atomic.AddInt64(&running, -1)
+			})
+		}()
+	}
+
+	// two calls run while the rest wait for a free worker
+	deadline := time.Now().Add(time.Second)
+	for pool.QueueDepth() != 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	testutil.Equal(t, 3, pool.QueueDepth())
+
+	close(release)
+	wg.Wait()
+	testutil.Equal(t, int64(2), atomic.LoadInt64(&maxRunning))
+	testutil.Equal(t, 0, pool.QueueDepth())
+}
+
+func TestWorkerPoolPanics(t *testing.T) {
+	pool := NewWorkerPool(1, nil)
+
+	func() {
+		defer func() {
+			testutil.Equal(t, "boom", recover())
+		}()
+		pool.Do("ValidateSessionState", func() { panic("boom") })
+	}()
+
+	// the worker survives the panic
+	ran := false
+	pool.Do("ValidateSessionState", func() { ran = true })
+	testutil.Assert(t, ran, "expected the call to run")
+}
+
+func TestNilWorkerPool(t *testing.T) {
+	pool := NewWorkerPool(0, nil)
+	testutil.Assert(t, pool == nil, "expected no pool without workers")
+
+	ran := false
+	pool.Do("RefreshSession", func() { ran = true })
+	testutil.Assert(t, ran, "expected the call to run")
+	testutil.Equal(t, 0, pool.QueueDepth())
+}
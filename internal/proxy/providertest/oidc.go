@@ -0,0 +1,120 @@
+package providertest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	oidcAuthorizePath = "/oauth2/v1/authorize"
+	oidcTokenPath     = "/oauth2/v1/token"
+	oidcUserInfoPath  = "/oauth2/v1/userinfo"
+)
+
+// The OpenID Connect endpoints implement the authorization code flow for the simulator's
+// client, with opaque access tokens whose claims are returned by the userinfo endpoint.
+// ID tokens are not issued.
+
+func (s *Server) discovery(rw http.ResponseWriter, req *http.Request) {
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"issuer":                   s.URL,
+		"authorization_endpoint":   s.URLFor(oidcAuthorizePath).String(),
+		"token_endpoint":           s.URLFor(oidcTokenPath).String(),
+		"userinfo_endpoint":        s.URLFor(oidcUserInfoPath).String(),
+		"response_types_supported": []string{"code"},
+		"grant_types_supported":    []string{"authorization_code", "refresh_token"},
+		"scopes_supported":         []string{"openid", "email", "profile", "groups", "offline_access"},
+	})
+}
+
+func (s *Server) authorize(rw http.ResponseWriter, req *http.Request) {
+	if req.Form.Get("client_id") != s.ClientID {
+		http.Error(rw, "invalid client_id", http.StatusUnauthorized)
+		return
+	}
+	if req.Form.Get("response_type") != "code" {
+		http.Error(rw, "unsupported response_type", http.StatusBadRequest)
+		return
+	}
+
+	redirectURL, err := url.Parse(req.Form.Get("redirect_uri"))
+	if err != nil || !redirectURL.IsAbs() {
+		http.Error(rw, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	s.redirectWithCode(rw, req, redirectURL)
+}
+
+func (s *Server) token(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientID, clientSecret, ok := req.BasicAuth()
+	if !ok {
+		clientID, clientSecret = req.Form.Get("client_id"), req.Form.Get("client_secret")
+	}
+	if clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeJSON(rw, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	switch req.Form.Get("grant_type") {
+	case "authorization_code":
+		email, ok := s.redeemCode(req.Form.Get("code"))
+		if !ok {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		accessToken, refreshToken := s.issueTokens(email)
+		writeJSON(rw, http.StatusOK, map[string]interface{}{
+			"access_token":  accessToken,
+			"refresh_token": refreshToken,
+			"token_type":    "Bearer",
+			"expires_in":    int64(s.TokenTTL / time.Second),
+		})
+	case "refresh_token":
+		accessToken, ok := s.refreshAccessToken(req.Form.Get("refresh_token"))
+		if !ok {
+			writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(rw, http.StatusOK, map[string]interface{}{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int64(s.TokenTTL / time.Second),
+		})
+	default:
+		writeJSON(rw, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+	}
+}
+
+func (s *Server) userInfo(rw http.ResponseWriter, req *http.Request) {
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(rw, "missing access token", http.StatusUnauthorized)
+		return
+	}
+
+	email, ok := s.tokenOwner(parts[1])
+	if !ok {
+		rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(rw, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+
+	groups := s.userGroups(email)
+	if groups == nil {
+		groups = []string{}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"sub":            email,
+		"email":          email,
+		"email_verified": true,
+		"groups":         groups,
+	})
+}
@@ -0,0 +1,470 @@
+// Package providertest provides an httptest-based simulator of the sso authenticator, and of
+// a minimal OpenID Connect provider, so that integration tests and local development don't
+// require a real identity provider. The simulator records every request it serves, and the
+// responses of each endpoint can be delayed or replaced to simulate latencies and failures.
+package providertest
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints of the simulator, which Behaviors are configured for.
+const (
+	SignIn   = "sign_in"
+	SignOut  = "sign_out"
+	Redeem   = "redeem"
+	Refresh  = "refresh"
+	Validate = "validate"
+	Profile  = "profile"
+
+	Discovery = "discovery"
+	Authorize = "authorize"
+	Token     = "token"
+	UserInfo  = "userinfo"
+)
+
+// User is a user known to the simulator.
+type User struct {
+	Email  string
+	Groups []string
+}
+
+// Behavior changes how the simulator responds to requests to an endpoint.
+type Behavior struct {
+	// Latency delays responses.
+	Latency time.Duration
+	// StatusCode, if set, is returned with Body in place of the endpoint's response.
+	StatusCode int
+	Body       string
+	// Times limits the behavior to the next number of requests, or all requests if zero.
+	Times int
+}
+
+// Interaction is a request served by the simulator.
+type Interaction struct {
+	Time       time.Time
+	Endpoint   string
+	Method     string
+	URL        string
+	Form       url.Values
+	StatusCode int
+}
+
+// Server simulates the sso authenticator for a single provider slug, and a minimal OpenID
+// Connect provider. Users sign in as the user set with SignInAs, without any interaction.
+type Server struct {
+	*httptest.Server
+
+	Slug         string
+	ClientID     string
+	ClientSecret string
+	// TokenTTL is the lifetime of the access tokens issued by the simulator.
+	TokenTTL time.Duration
+
+	mu            sync.Mutex
+	users         map[string]*User
+	signedIn      string
+	codes         map[string]string
+	accessTokens  map[string]string
+	refreshTokens map[string]string
+	behaviors     map[string]*Behavior
+	interactions  []Interaction
+}
+
+// NewServer starts a simulator for the provider slug, accepting the given client credentials.
+// Callers should call Close when finished, to shut it down.
+func NewServer(slug, clientID, clientSecret string) *Server {
+	s := &Server{
+		Slug:          slug,
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		TokenTTL:      time.Hour,
+		users:         make(map[string]*User),
+		codes:         make(map[string]string),
+		accessTokens:  make(map[string]string),
+		refreshTokens: make(map[string]string),
+		behaviors:     make(map[string]*Behavior),
+	}
+
+	mux := http.NewServeMux()
+	s.handle(mux, "/"+slug+"/sign_in", SignIn, s.signIn)
+	s.handle(mux, "/"+slug+"/sign_out", SignOut, s.signOut)
+	s.handle(mux, "/"+slug+"/redeem", Redeem, s.redeem)
+	s.handle(mux, "/"+slug+"/refresh", Refresh, s.refresh)
+	s.handle(mux, "/"+slug+"/validate", Validate, s.validate)
+	s.handle(mux, "/"+slug+"/profile", Profile, s.profile)
+	s.handle(mux, "/.well-known/openid-configuration", Discovery, s.discovery)
+	s.handle(mux, oidcAuthorizePath, Authorize, s.authorize)
+	s.handle(mux, oidcTokenPath, Token, s.token)
+	s.handle(mux, oidcUserInfoPath, UserInfo, s.userInfo)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// AddUser adds a user known to the simulator.
+func (s *Server) AddUser(email string, groups ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[email] = &User{Email: email, Groups: groups}
+}
+
+// SetGroups changes the groups of a user, e.g. to simulate their membership being revoked.
+func (s *Server) SetGroups(email string, groups ...string) {
+	s.AddUser(email, groups...)
+}
+
+// SignInAs sets the user who is signed in by the sign in and authorize endpoints. Requests
+// to sign in are rejected until a user is set.
+func (s *Server) SignInAs(email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signedIn = email
+}
+
+// RevokeTokens revokes the access and refresh tokens issued to a user.
+func (s *Server) RevokeTokens(email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tokens := range []map[string]string{s.accessTokens, s.refreshTokens} {
+		for token, owner := range tokens {
+			if owner == email {
+				delete(tokens, token)
+			}
+		}
+	}
+}
+
+// SetBehavior changes how the simulator responds to requests to an endpoint.
+func (s *Server) SetBehavior(endpoint string, b Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.behaviors[endpoint] = &b
+}
+
+// ResetBehaviors restores the normal responses of every endpoint.
+func (s *Server) ResetBehaviors() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.behaviors = make(map[string]*Behavior)
+}
+
+// Interactions returns the requests served by the simulator, in the order they were received.
+func (s *Server) Interactions() []Interaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Interaction{}, s.interactions...)
+}
+
+// InteractionsWith returns the requests to an endpoint served by the simulator.
+func (s *Server) InteractionsWith(endpoint string) []Interaction {
+	interactions := []Interaction{}
+	for _, i := range s.Interactions() {
+		if i.Endpoint == endpoint {
+			interactions = append(interactions, i)
+		}
+	}
+	return interactions
+}
+
+// URLFor returns the URL of a path on the simulator.
+func (s *Server) URLFor(path string) *url.URL {
+	u, _ := url.Parse(s.URL)
+	u.Path = path
+	return u
+}
+
+// SignRedirectURL signs a redirect url the way the sso proxy does, for requests to the sign in
+// and sign out endpoints.
+func (s *Server) SignRedirectURL(rawRedirect string, timestamp time.Time) string {
+	h := hmac.New(sha256.New, []byte(s.ClientSecret))
+	h.Write([]byte(rawRedirect))
+	h.Write([]byte(fmt.Sprint(timestamp.Unix())))
+	return base64.URLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (s *Server) handle(mux *http.ServeMux, path, endpoint string, handler http.HandlerFunc) {
+	mux.HandleFunc(path, func(rw http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		defer func() {
+			s.mu.Lock()
+			s.interactions = append(s.interactions, Interaction{
+				Time:       time.Now(),
+				Endpoint:   endpoint,
+				Method:     req.Method,
+				URL:        req.URL.String(),
+				Form:       req.Form,
+				StatusCode: recorder.status,
+			})
+			s.mu.Unlock()
+		}()
+
+		if b, ok := s.nextBehavior(endpoint); ok {
+			time.Sleep(b.Latency)
+			if b.StatusCode != 0 {
+				recorder.WriteHeader(b.StatusCode)
+				recorder.Write([]byte(b.Body))
+				return
+			}
+		}
+		handler(recorder, req)
+	})
+}
+
+// nextBehavior returns the behavior of the next request to an endpoint, if any.
+func (s *Server) nextBehavior(endpoint string) (Behavior, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.behaviors[endpoint]
+	if !ok {
+		return Behavior{}, false
+	}
+	if b.Times != 0 {
+		b.Times--
+		if b.Times == 0 {
+			delete(s.behaviors, endpoint)
+		}
+	}
+	return *b, true
+}
+
+func (s *Server) signIn(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if req.Form.Get("client_id") != s.ClientID {
+		http.Error(rw, "invalid client_id", http.StatusUnauthorized)
+		return
+	}
+
+	redirectURL, ok := s.validRedirect(rw, req)
+	if !ok {
+		return
+	}
+	s.redirectWithCode(rw, req, redirectURL)
+}
+
+func (s *Server) signOut(rw http.ResponseWriter, req *http.Request) {
+	redirectURL, ok := s.validRedirect(rw, req)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	s.signedIn = ""
+	s.mu.Unlock()
+	http.Redirect(rw, req, redirectURL.String(), http.StatusFound)
+}
+
+// validRedirect checks the signature of the redirect uri of sign in and sign out requests.
+func (s *Server) validRedirect(rw http.ResponseWriter, req *http.Request) (*url.URL, bool) {
+	rawRedirect := req.Form.Get("redirect_uri")
+	redirectURL, err := url.Parse(rawRedirect)
+	if rawRedirect == "" || err != nil {
+		http.Error(rw, "invalid redirect_uri", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var ts int64
+	fmt.Sscan(req.Form.Get("ts"), &ts)
+	want := s.SignRedirectURL(rawRedirect, time.Unix(ts, 0))
+	if !hmac.Equal([]byte(want), []byte(req.Form.Get("sig"))) {
+		http.Error(rw, "invalid signature", http.StatusUnauthorized)
+		return nil, false
+	}
+	return redirectURL, true
+}
+
+// redirectWithCode redirects the signed in user back to the client with an authorization code.
+func (s *Server) redirectWithCode(rw http.ResponseWriter, req *http.Request, redirectURL *url.URL) {
+	s.mu.Lock()
+	email := s.signedIn
+	_, known := s.users[email]
+	code := ""
+	if known {
+		code = newToken()
+		s.codes[code] = email
+	}
+	s.mu.Unlock()
+
+	if !known {
+		http.Error(rw, "no user is signed in", http.StatusForbidden)
+		return
+	}
+
+	params := redirectURL.Query()
+	params.Set("code", code)
+	params.Set("state", req.Form.Get("state"))
+	redirectURL.RawQuery = params.Encode()
+	http.Redirect(rw, req, redirectURL.String(), http.StatusFound)
+}
+
+func (s *Server) redeem(rw http.ResponseWriter, req *http.Request) {
+	if !s.validClient(rw, req, req.Form.Get("client_secret")) {
+		return
+	}
+
+	email, ok := s.redeemCode(req.Form.Get("code"))
+	if !ok {
+		http.Error(rw, "invalid auth code", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, refreshToken := s.issueTokens(email)
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int64(s.TokenTTL / time.Second),
+		"email":         email,
+	})
+}
+
+func (s *Server) refresh(rw http.ResponseWriter, req *http.Request) {
+	if !s.validClient(rw, req, req.Form.Get("client_secret")) {
+		return
+	}
+
+	accessToken, ok := s.refreshAccessToken(req.Form.Get("refresh_token"))
+	if !ok {
+		http.Error(rw, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(rw, http.StatusCreated, map[string]interface{}{
+		"access_token": accessToken,
+		"expires_in":   int64(s.TokenTTL / time.Second),
+	})
+}
+
+func (s *Server) validate(rw http.ResponseWriter, req *http.Request) {
+	if !s.validClient(rw, req, req.Header.Get("X-Client-Secret")) {
+		return
+	}
+
+	if _, ok := s.tokenOwner(req.Header.Get("X-Access-Token")); !ok {
+		http.Error(rw, "invalid access token", http.StatusUnauthorized)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) profile(rw http.ResponseWriter, req *http.Request) {
+	if !s.validClient(rw, req, req.Header.Get("X-Client-Secret")) {
+		return
+	}
+
+	email := req.Form.Get("email")
+	if email == "" {
+		http.Error(rw, "no email address included", http.StatusBadRequest)
+		return
+	}
+
+	allowedGroups := []string{}
+	if groups := req.Form.Get("groups"); groups != "" {
+		allowedGroups = strings.Split(groups, ",")
+	}
+
+	// like the authenticator, only the user's groups that were asked about are returned
+	groups := []string{}
+	for _, group := range s.userGroups(email) {
+		for _, allowed := range allowedGroups {
+			if group == allowed {
+				groups = append(groups, group)
+			}
+		}
+	}
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"email":  email,
+		"groups": groups,
+	})
+}
+
+func (s *Server) validClient(rw http.ResponseWriter, req *http.Request, secret string) bool {
+	if req.Form.Get("client_id") != s.ClientID || secret != s.ClientSecret {
+		http.Error(rw, "invalid client credentials", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (s *Server) redeemCode(code string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email, ok := s.codes[code]
+	delete(s.codes, code)
+	return email, ok
+}
+
+func (s *Server) issueTokens(email string) (string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accessToken, refreshToken := newToken(), newToken()
+	s.accessTokens[accessToken] = email
+	s.refreshTokens[refreshToken] = email
+	return accessToken, refreshToken
+}
+
+// refreshAccessToken issues a new access token to the owner of a refresh token.
+func (s *Server) refreshAccessToken(refreshToken string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email, ok := s.refreshTokens[refreshToken]
+	if !ok {
+		return "", false
+	}
+	accessToken := newToken()
+	s.accessTokens[accessToken] = email
+	return accessToken, true
+}
+
+func (s *Server) tokenOwner(accessToken string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	email, ok := s.accessTokens[accessToken]
+	return email, ok
+}
+
+func (s *Server) userGroups(email string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[email]; ok {
+		return user.Groups
+	}
+	return nil
+}
+
+// statusRecorder records the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,205 @@
+package providertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+var noRedirects = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func testServer() *Server {
+	s := NewServer("idp", "client", "secret")
+	s.AddUser("jane@example.com", "writers", "readers")
+	return s
+}
+
+// signIn follows the sso sign in flow, returning the authorization code.
+func signIn(t *testing.T, s *Server) string {
+	redirect := "https://proxy.example.com/oauth2/callback"
+	now := time.Now()
+	params := url.Values{
+		"client_id":    {s.ClientID},
+		"redirect_uri": {redirect},
+		"state":        {"state"},
+		"ts":           {fmt.Sprint(now.Unix())},
+		"sig":          {s.SignRedirectURL(redirect, now)},
+	}
+	resp, err := noRedirects.Get(s.URL + "/idp/sign_in?" + params.Encode())
+	testutil.Assert(t, err == nil, "unexpected error signing in: %s", err)
+	resp.Body.Close()
+	testutil.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	testutil.Assert(t, err == nil, "unexpected error parsing redirect: %s", err)
+	testutil.Equal(t, "proxy.example.com", location.Host)
+	testutil.Equal(t, "state", location.Query().Get("state"))
+	return location.Query().Get("code")
+}
+
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	defer resp.Body.Close()
+	err := json.NewDecoder(resp.Body).Decode(v)
+	testutil.Assert(t, err == nil, "unexpected error decoding response: %s", err)
+}
+
+func TestSSOFlow(t *testing.T) {
+	s := testServer()
+	defer s.Close()
+
+	resp, err := noRedirects.Get(s.URL + "/idp/sign_in?client_id=client&redirect_uri=https://proxy.example.com/")
+	testutil.Assert(t, err == nil, "unexpected error: %s", err)
+	testutil.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	s.SignInAs("jane@example.com")
+	code := signIn(t, s)
+
+	resp, err = http.PostForm(s.URL+"/idp/redeem", url.Values{
+		"client_id":     {"client"},
+		"client_secret": {"secret"},
+		"code":          {code},
+	})
+	testutil.Assert(t, err == nil, "unexpected error redeeming code: %s", err)
+	testutil.Equal(t, http.StatusOK, resp.StatusCode)
+	redeemed := struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		Email        string `json:"email"`
+	}{}
+	decode(t, resp, &redeemed)
+	testutil.Equal(t, "jane@example.com", redeemed.Email)
+	testutil.Equal(t, int64(3600), redeemed.ExpiresIn)
+
+	// codes can only be redeemed once
+	resp, err = http.PostForm(s.URL+"/idp/redeem", url.Values{
+		"client_id":     {"client"},
+		"client_secret": {"secret"},
+		"code":          {code},
+	})
+	testutil.Assert(t, err == nil, "unexpected error redeeming code: %s", err)
+	resp.Body.Close()
+	testutil.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ := http.NewRequest("GET", s.URL+"/idp/profile?client_id=client&email=jane@example.com&groups=writers,admins", nil)
+	req.Header.Set("X-Client-Secret", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	testutil.Assert(t, err == nil, "unexpected error fetching profile: %s", err)
+	profile := struct {
+		Groups []string `json:"groups"`
+	}{}
+	decode(t, resp, &profile)
+	testutil.Equal(t, []string{"writers"}, profile.Groups)
+
+	validate := func() int {
+		req, _ := http.NewRequest("GET", s.URL+"/idp/validate?client_id=client", nil)
+		req.Header.Set("X-Client-Secret", "secret")
+		req.Header.Set("X-Access-Token", redeemed.AccessToken)
+		resp, err := http.DefaultClient.Do(req)
+		testutil.Assert(t, err == nil, "unexpected error validating token: %s", err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	testutil.Equal(t, http.StatusOK, validate())
+
+	resp, err = http.PostForm(s.URL+"/idp/refresh", url.Values{
+		"client_id":     {"client"},
+		"client_secret": {"secret"},
+		"refresh_token": {redeemed.RefreshToken},
+	})
+	testutil.Assert(t, err == nil, "unexpected error refreshing token: %s", err)
+	resp.Body.Close()
+	testutil.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	s.RevokeTokens("jane@example.com")
+	testutil.Equal(t, http.StatusUnauthorized, validate())
+
+	testutil.Equal(t, 2, len(s.InteractionsWith(SignIn)))
+	testutil.Equal(t, 2, len(s.InteractionsWith(Redeem)))
+	testutil.Equal(t, http.StatusUnauthorized, s.InteractionsWith(Validate)[1].StatusCode)
+}
+
+func TestBehaviors(t *testing.T) {
+	s := testServer()
+	defer s.Close()
+
+	s.SetBehavior(Validate, Behavior{StatusCode: http.StatusServiceUnavailable, Body: "unavailable", Times: 2})
+	for _, want := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusUnauthorized} {
+		resp, err := http.Get(s.URL + "/idp/validate?client_id=client")
+		testutil.Assert(t, err == nil, "unexpected error: %s", err)
+		resp.Body.Close()
+		testutil.Equal(t, want, resp.StatusCode)
+	}
+
+	s.SetBehavior(Discovery, Behavior{Latency: 50 * time.Millisecond})
+	start := time.Now()
+	resp, err := http.Get(s.URL + "/.well-known/openid-configuration")
+	testutil.Assert(t, err == nil, "unexpected error: %s", err)
+	resp.Body.Close()
+	testutil.Equal(t, http.StatusOK, resp.StatusCode)
+	testutil.Assert(t, time.Since(start) >= 50*time.Millisecond, "expected response to be delayed")
+
+	s.ResetBehaviors()
+	testutil.Equal(t, 0, len(s.behaviors))
+}
+
+func TestOIDCFlow(t *testing.T) {
+	s := testServer()
+	defer s.Close()
+	s.SignInAs("jane@example.com")
+
+	discovery := map[string]interface{}{}
+	resp, err := http.Get(s.URL + "/.well-known/openid-configuration")
+	testutil.Assert(t, err == nil, "unexpected error: %s", err)
+	decode(t, resp, &discovery)
+
+	params := url.Values{
+		"client_id":     {"client"},
+		"redirect_uri":  {"https://auth.example.com/callback"},
+		"response_type": {"code"},
+		"state":         {"state"},
+	}
+	resp, err = noRedirects.Get(discovery["authorization_endpoint"].(string) + "?" + params.Encode())
+	testutil.Assert(t, err == nil, "unexpected error authorizing: %s", err)
+	resp.Body.Close()
+	testutil.Equal(t, http.StatusFound, resp.StatusCode)
+	location, _ := url.Parse(resp.Header.Get("Location"))
+
+	req, _ := http.NewRequest("POST", discovery["token_endpoint"].(string), strings.NewReader(url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {location.Query().Get("code")},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("client", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	testutil.Assert(t, err == nil, "unexpected error redeeming code: %s", err)
+	testutil.Equal(t, http.StatusOK, resp.StatusCode)
+	token := struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}{}
+	decode(t, resp, &token)
+	testutil.Equal(t, "Bearer", token.TokenType)
+
+	req, _ = http.NewRequest("GET", discovery["userinfo_endpoint"].(string), nil)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err = http.DefaultClient.Do(req)
+	testutil.Assert(t, err == nil, "unexpected error fetching userinfo: %s", err)
+	userInfo := struct {
+		Email  string   `json:"email"`
+		Groups []string `json:"groups"`
+	}{}
+	decode(t, resp, &userInfo)
+	testutil.Equal(t, "jane@example.com", userInfo.Email)
+	testutil.Equal(t, []string{"writers", "readers"}, userInfo.Groups)
+}
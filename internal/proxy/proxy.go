@@ -1,62 +1,465 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/buzzfeed/sso/internal/pkg/fips"
+	"github.com/buzzfeed/sso/internal/pkg/geoip"
 	"github.com/buzzfeed/sso/internal/pkg/hostmux"
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
 	"github.com/buzzfeed/sso/internal/pkg/options"
+	"github.com/buzzfeed/sso/internal/proxy/providers"
+	"github.com/buzzfeed/sso/pkg/middleware"
+	"github.com/datadog/datadog-go/statsd"
 )
 
+// SSOProxy is the http.Handler serving all configured upstreams.
 type SSOProxy struct {
 	http.Handler
+
+	// AuthorizeHandler authenticates and authorizes requests for all configured
+	// upstreams without proxying them. It backs external authorization integrations.
+	AuthorizeHandler http.Handler
+
+	// AdminHandler serves the admin API. It is nil unless an admin token or the SPIFFE IDs of
+	// its callers are configured.
+	AdminHandler http.Handler
+
+	// AdminTLSConfig is the TLS config the admin API is served with when its callers are
+	// authenticated by their SPIFFE SVIDs, and nil otherwise.
+	AdminTLSConfig *tls.Config
+
+	drain         *drainStatus
+	configMetrics *configMetrics
+}
+
+// StartDrain reports that the proxy is shutting down through the drain status of its admin API,
+// with the deadline its requests in flight have to finish by.
+func (p *SSOProxy) StartDrain(deadline time.Time) {
+	if p.drain != nil {
+		p.drain.start(deadline)
+	}
 }
 
+// New returns the SSOProxy serving the configured upstreams, and those of every tenant on their
+// host suffixes.
 func New(opts *Options) (*SSOProxy, error) {
+	if opts.fipsMode() {
+		fips.Enable()
+	}
+	configureOutboundTLS(opts)
+	configurePrivacy(opts)
+
+	if opts.SPIFFEEndpointSocket != "" {
+		source, err := loadSPIFFESource(opts)
+		if err != nil {
+			return nil, err
+		}
+		opts.spiffeSource = source
+		for _, t := range opts.tenants {
+			t.opts.spiffeSource = source
+		}
+	}
+
+	if opts.RequestSignerKMSKeyARN != "" {
+		log.NewLogEntry().Warn("REQUESTSIGNER_KMS_KEY_ARN is deprecated, set REQUEST_SIGNATURE_KMS_KEY instead")
+	}
+
+	if opts.featureFlags != nil {
+		logger := log.NewLogEntry()
+		logger.Info(fmt.Sprintf("feature flags: %s", opts.featureFlags))
+		go opts.featureFlags.Watch(featureFlagsReloadInterval)
+	}
+
+	if opts.CookieSecretKMSKey != "" {
+		cookieSecret, err := loadKMSCookieSecret(opts)
+		if err != nil {
+			return nil, err
+		}
+		opts.cookieCipher = cookieSecret.cipher
+		for _, t := range opts.tenants {
+			// tenants without a cookie secret of their own share the proxy's
+			if t.opts.CookieSecretKMSKey != "" {
+				t.opts.cookieCipher = cookieSecret.cipher
+			}
+		}
+		go cookieSecret.Watch(opts.CookieSecretKMSReloadInterval)
+	}
+
+	// a git repository of upstream configs is only cloned when the proxy starts, not when its
+	// options are validated
+	if opts.gitConfigSource != nil {
+		if err := loadGitUpstreamConfigs(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	// the requests of every tenant are counted together, as they're served by the same server
+	opts.drain = newDrainStatus()
+
+	ssoProxy, err := newSSOProxy(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.tenants) == 0 {
+		ssoProxy.Handler = opts.drain.handler(ssoProxy.Handler)
+		ssoProxy.drain = opts.drain
+		return ssoProxy, nil
+	}
+
+	handler := newTenantRouter(ssoProxy.Handler)
+	authorizeHandler := newTenantRouter(ssoProxy.AuthorizeHandler)
+	started := []*SSOProxy{ssoProxy}
+	for _, t := range opts.tenants {
+		tenantProxy, err := newSSOProxy(t.opts)
+		if err != nil {
+			for _, p := range started {
+				p.configMetrics.Stop()
+			}
+			return nil, fmt.Errorf("error creating tenant %s: %s", t.name, err)
+		}
+		started = append(started, tenantProxy)
+		handler.handle(t.hostSuffix, tenantProxy.Handler)
+		authorizeHandler.handle(t.hostSuffix, tenantProxy.AuthorizeHandler)
+	}
+
+	// the admin API is only served for the proxy's own upstreams
+	return &SSOProxy{
+		Handler:          opts.drain.handler(handler),
+		AuthorizeHandler: authorizeHandler,
+		AdminHandler:     ssoProxy.AdminHandler,
+		AdminTLSConfig:   ssoProxy.AdminTLSConfig,
+		drain:            opts.drain,
+	}, nil
+}
+
+// proxyComponents are the parts of an SSOProxy shared by all of its upstreams, which are kept
+// when its upstream configs are reloaded.
+type proxyComponents struct {
+	optFuncs      []func(*OAuthProxy) error
+	requestSigner *RequestSigner
+	deviceTokens  *deviceTokenStore
+	events        *eventNotifier
+	anomalies     *anomalyMonitor
+	deprovisioner *deprovisioner
+	harRecorder   *harRecorder
+	configMetrics *configMetrics
+	loadShedder   *loadShedder
+	scheduler     *fairScheduler
+	inflight      *inflightRequests
+	usage         *usageTracker
+	exporter      *accessExporter
+	blueGreen     *blueGreenSwitch
+}
+
+// upstreamRouting routes the requests of an SSOProxy to its upstreams, as built from a single
+// version of its upstream configs.
+type upstreamRouting struct {
+	handler   http.Handler
+	authorize http.Handler
+	admin     http.Handler
+
+	healthCheckers []*healthChecker
+	certChecker    *certExpiryChecker
+	warmPools      []*warmPool
+
+	// the statsd clients of upstreams with metadata, see newUpstreamStatsdClient
+	statsdClients []*statsd.Client
+}
+
+// stop stops the background checks of the upstreams, once the routing is replaced.
+func (r *upstreamRouting) stop() {
+	for _, checker := range r.healthCheckers {
+		checker.Stop()
+	}
+	if r.certChecker != nil {
+		r.certChecker.Stop()
+	}
+	for _, pool := range r.warmPools {
+		pool.Stop()
+	}
+	for _, client := range r.statsdClients {
+		client.Close()
+	}
+}
+
+// newSSOProxy returns the SSOProxy serving the upstreams of a single set of options.
+func newSSOProxy(opts *Options) (*SSOProxy, error) {
+	components, err := newProxyComponents(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if detector := newConfigDriftDetector(opts); detector != nil {
+		go detector.run()
+	}
+
+	routing, err := newUpstreamRouting(opts, components)
+	if err != nil {
+		return nil, err
+	}
+	logUpstreamConfigDiff(nil, opts.upstreamConfigs)
+	go components.configMetrics.run()
+	if components.usage != nil {
+		go components.usage.run()
+	}
+	if components.exporter != nil {
+		go components.exporter.run()
+	}
+
+	ssoProxy := &SSOProxy{
+		Handler:          routing.handler,
+		AuthorizeHandler: routing.authorize,
+		AdminHandler:     routing.admin,
+		AdminTLSConfig:   newAdminTLSConfig(opts),
+		configMetrics:    components.configMetrics,
+	}
+	if source := opts.upstreamConfigSource(); source != nil {
+		reloader := newUpstreamReloader(opts, components, routing)
+		ssoProxy.Handler = reloader.handler
+		ssoProxy.AuthorizeHandler = reloader.authorize
+		if routing.admin != nil {
+			ssoProxy.AdminHandler = reloader.admin
+		}
+		go source.run(reloader.reload)
+	}
+	return ssoProxy, nil
+}
+
+// newProxyComponents returns the parts of the SSOProxy of a set of options that are shared by
+// all of its upstreams.
+func newProxyComponents(opts *Options) (*proxyComponents, error) {
 	optFuncs := []func(*OAuthProxy) error{}
 
 	var requestSigner *RequestSigner
 	var err error
 
-	if opts.RequestSigningKey != "" {
-		requestSigner, err = NewRequestSigner(opts.RequestSigningKey)
+	if opts.RequestSigningKey != "" || opts.RequestSignatureKMSKey != "" {
+		if opts.RequestSignatureKMSKey != "" {
+			requestSigner, err = NewKMSRequestSigner(opts.RequestSignatureKMSKey)
+		} else {
+			requestSigner, err = NewRequestSigner(opts.RequestSigningKey)
+		}
 		if err != nil {
 			return nil, err
 		}
+		// the key of a KMS is only known once it's fetched
+		if fips.Enabled() {
+			if err := fips.CheckPublicKey(requestSigner.publicKey); err != nil {
+				return nil, fmt.Errorf("request signing key can't be used in FIPS mode: %s", err)
+			}
+		}
+		if opts.RequestSignatureDigest {
+			requestSigner.EnableBodyDigest(opts.RequestSignatureMaxBodySize)
+		}
 		optFuncs = append(optFuncs, SetRequestSigner(requestSigner))
 	}
 
+	var deviceTokens *deviceTokenStore
+	if opts.DeviceFlow {
+		deviceTokens = newDeviceTokenStore(deviceCodeTTL, opts.BearerTokenTTL, devicePollInterval)
+		optFuncs = append(optFuncs, SetDeviceTokenStore(deviceTokens))
+	}
+
+	if opts.GeoIPDatabase != "" || opts.GeoIPASNDatabase != "" {
+		locator, err := geoip.NewLocator(opts.GeoIPDatabase, opts.GeoIPASNDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("error opening geoip database: %s", err)
+		}
+		optFuncs = append(optFuncs, SetGeoIPLocator(locator))
+	}
+
+	events, err := newEventNotifier(opts)
+	if err != nil {
+		return nil, err
+	}
+	optFuncs = append(optFuncs, SetEventNotifier(events))
+
+	deprovisioner := newDeprovisioner(opts, deviceTokens, events)
+	if deprovisioner != nil {
+		optFuncs = append(optFuncs, SetDeprovisioner(deprovisioner))
+	}
+
+	if refresher := newBackgroundRefresher(opts); refresher != nil {
+		optFuncs = append(optFuncs, SetBackgroundRefresher(refresher))
+	}
+
+	var anomalies *anomalyMonitor
+	if len(opts.AnomalyDetectors) != 0 {
+		anomalies, err = newAnomalyMonitor(opts)
+		if err != nil {
+			return nil, err
+		}
+		optFuncs = append(optFuncs, SetAnomalyMonitor(anomalies))
+	}
+
+	usage, err := newUsageTracker(opts)
+	if err != nil {
+		return nil, err
+	}
+	if usage != nil {
+		optFuncs = append(optFuncs, SetUsageTracker(usage))
+	}
+
+	exporter, err := newAccessExporter(opts, usage)
+	if err != nil {
+		return nil, fmt.Errorf("error creating access exporter: %s", err)
+	}
+
+	if throttle := newLoginThrottle(opts); throttle != nil {
+		optFuncs = append(optFuncs, SetLoginThrottle(throttle))
+	}
+
+	if stash := newPostStash(opts); stash != nil {
+		optFuncs = append(optFuncs, SetPostStash(stash))
+	}
+
+	if len(opts.HoneypotPaths) != 0 {
+		optFuncs = append(optFuncs, SetHoneypotPaths(opts.HoneypotPaths))
+	}
+
+	var inflight *inflightRequests
+	if opts.adminAPI() {
+		inflight = newInflightRequests()
+	}
+
+	return &proxyComponents{
+		optFuncs:      optFuncs,
+		requestSigner: requestSigner,
+		deviceTokens:  deviceTokens,
+		events:        events,
+		anomalies:     anomalies,
+		deprovisioner: deprovisioner,
+		harRecorder:   newHARRecorder(opts.AuditRetention),
+		configMetrics: newConfigMetrics(opts),
+		loadShedder:   newLoadShedder(opts),
+		scheduler:     newFairScheduler(opts),
+		inflight:      inflight,
+		usage:         usage,
+		exporter:      exporter,
+		blueGreen:     newBlueGreenSwitch(),
+	}, nil
+}
+
+// newUpstreamRouting returns the routing of requests to the upstreams of a set of options, and
+// starts their background checks.
+func newUpstreamRouting(opts *Options, components *proxyComponents) (*upstreamRouting, error) {
+	requestSigner := components.requestSigner
+	harRecorder := components.harRecorder
+	optFuncs := make([]func(*OAuthProxy) error, len(components.optFuncs))
+	copy(optFuncs, components.optFuncs)
+	optFuncs = append(optFuncs, SetSessionClaims(sessionClaims(opts.upstreamConfigs)))
+
 	hostRouter := hostmux.NewRouter()
+	authorizeRouter := hostmux.NewRouter()
+	traceRouter := hostmux.NewRouter()
+	upstreamHandlers := make(map[string]http.Handler)
+	upstreamAuthorizeHandlers := make(map[string]http.Handler)
+	upstreamTraceHandlers := make(map[string]http.Handler)
+	portalEntries := []*portalEntry{}
+	healthCheckers := []*healthChecker{}
+	warmPools := []*warmPool{}
+	statsdClients := []*statsd.Client{}
+	routes := []*routeEntry{}
+	allowedHosts := newHostAllowList(opts.AllowedHosts)
+	portalServices := make(map[string]bool)
 	for _, upstreamConfig := range opts.upstreamConfigs {
+		setSPIFFESource(opts, upstreamConfig)
+		if upstreamConfig.BlueGreen != nil {
+			upstreamConfig.blueGreenSwitch = components.blueGreen
+		}
+
 		provider, err := newProvider(opts, upstreamConfig)
 		if err != nil {
 			return nil, err
 		}
 
-		handler, err := NewUpstreamReverseProxy(upstreamConfig, requestSigner)
+		statsdClient, err := newUpstreamStatsdClient(opts, upstreamConfig)
 		if err != nil {
 			return nil, err
 		}
+		if statsdClient != opts.StatsdClient {
+			statsdClients = append(statsdClients, statsdClient)
+		}
 
-		validators := []options.Validator{}
-		if len(upstreamConfig.AllowedEmailAddresses) != 0 {
-			validators = append(validators, options.NewEmailAddressValidator(upstreamConfig.AllowedEmailAddresses))
+		handler, err := newUpstreamHandler(upstreamConfig, requestSigner)
+		if err != nil {
+			return nil, err
 		}
 
-		if len(upstreamConfig.AllowedEmailDomains) != 0 {
-			validators = append(validators, options.NewEmailDomainValidator(upstreamConfig.AllowedEmailDomains))
+		mws, err := newUpstreamMiddleware(upstreamConfig)
+		if err != nil {
+			return nil, err
 		}
+		handler = mws.wrap(middleware.PreProxy, mws.wrap(middleware.PostProxy, handler))
 
-		if len(upstreamConfig.AllowedGroups) != 0 {
-			validators = append(validators, options.NewEmailGroupValidator(provider, upstreamConfig.AllowedGroups))
+		if upstreamConfig.Script != nil {
+			handler = newScriptHandler(handler, upstreamConfig, statsdClient)
 		}
 
+		if upstreamConfig.Enrichment != nil {
+			handler = newEnrichmentHandler(handler, upstreamConfig, statsdClient)
+		}
+
+		if pool := upstreamConfig.warmPool; pool != nil {
+			warmPools = append(warmPools, pool)
+			go pool.run()
+		}
+
+		if components.inflight != nil || upstreamConfig.SlowRequestThreshold > 0 {
+			handler = components.inflight.handler(handler, upstreamConfig, statsdClient)
+		}
+
+		if upstreamConfig.Mirror != nil {
+			handler = newMirrorHandler(handler, upstreamConfig, statsdClient)
+		}
+
+		if upstreamConfig.DebugCapture != nil {
+			handler = newDebugCaptureHandler(handler, upstreamConfig)
+		}
+
+		if opts.adminAPI() {
+			handler = newHARHandler(handler, upstreamConfig, harRecorder)
+		}
+
+		if opts.FaultInjection && upstreamConfig.FaultInjection != nil {
+			handler = newFaultInjectionHandler(handler, upstreamConfig, statsdClient)
+		}
+
+		var checker *healthChecker
+		if upstreamConfig.HealthCheck != nil {
+			checker, err = newHealthCheckHandler(handler, upstreamConfig, statsdClient)
+			if err != nil {
+				return nil, err
+			}
+			healthCheckers = append(healthCheckers, checker)
+			handler = checker
+		}
+
+		if components.scheduler != nil {
+			handler = components.scheduler.handler(handler, upstreamConfig, statsdClient)
+		}
+
+		if components.loadShedder != nil {
+			handler = components.loadShedder.handler(handler, upstreamConfig, statsdClient)
+		}
+
+		if len(upstreamConfig.Middleware) != 0 {
+			handler = withIdentity(mws.wrap(middleware.PostAuth, handler))
+		}
+
+		validators := newValidators(provider, upstreamConfig)
+
 		optFuncs = append(optFuncs,
 			SetProvider(provider),
 			SetCookieStore(opts),
 			SetUpstreamConfig(upstreamConfig),
 			SetProxyHandler(handler),
 			SetValidators(validators),
+			SetStatsdClient(statsdClient),
 		)
 
 		oauthproxy, err := NewOAuthProxy(opts, optFuncs...)
@@ -64,19 +467,172 @@ func New(opts *Options) (*SSOProxy, error) {
 			return nil, err
 		}
 
+		upstreamHandler := mws.wrap(middleware.PreAuth, oauthproxy.Handler())
+
 		switch route := upstreamConfig.Route.(type) {
 		case *SimpleRoute:
-			hostRouter.HandleStatic(route.FromURL.Host, oauthproxy.Handler())
+			hostRouter.HandleStatic(route.FromURL.Host, upstreamHandler)
+			authorizeRouter.HandleStatic(route.FromURL.Host, oauthproxy.AuthorizeHandler())
+			traceRouter.HandleStatic(route.FromURL.Host, http.HandlerFunc(oauthproxy.Trace))
+		case *StaticFilesRoute:
+			hostRouter.HandleStatic(route.FromURL.Host, upstreamHandler)
+			authorizeRouter.HandleStatic(route.FromURL.Host, oauthproxy.AuthorizeHandler())
+			traceRouter.HandleStatic(route.FromURL.Host, http.HandlerFunc(oauthproxy.Trace))
+		case *BucketRoute:
+			hostRouter.HandleStatic(route.FromURL.Host, upstreamHandler)
+			authorizeRouter.HandleStatic(route.FromURL.Host, oauthproxy.AuthorizeHandler())
+			traceRouter.HandleStatic(route.FromURL.Host, http.HandlerFunc(oauthproxy.Trace))
 		case *RewriteRoute:
-			hostRouter.HandleRegexp(route.FromRegex, oauthproxy.Handler())
+			hostRouter.HandleRegexp(route.FromRegex, upstreamHandler)
+			authorizeRouter.HandleRegexp(route.FromRegex, oauthproxy.AuthorizeHandler())
+			traceRouter.HandleRegexp(route.FromRegex, http.HandlerFunc(oauthproxy.Trace))
 		default:
 			return nil, fmt.Errorf("unknown route type")
 		}
+		routes = append(routes, newRouteEntry(oauthproxy, checker))
+		allowedHosts.addRoute(upstreamConfig.Route)
+
+		// with several clusters or extra routes, a service's first upstream is its default
+		if _, ok := upstreamHandlers[upstreamConfig.Service]; !ok {
+			upstreamHandlers[upstreamConfig.Service] = upstreamHandler
+			upstreamAuthorizeHandlers[upstreamConfig.Service] = oauthproxy.AuthorizeHandler()
+			upstreamTraceHandlers[upstreamConfig.Service] = http.HandlerFunc(oauthproxy.Trace)
+		}
+
+		// extra routes are listed on the portal under their parent's entry
+		portalKey := upstreamConfig.Cluster + "/" + upstreamConfig.Service
+		if entry := newPortalEntry(upstreamConfig, validators); entry != nil && !portalServices[portalKey] {
+			portalServices[portalKey] = true
+			portalEntries = append(portalEntries, entry)
+		}
 	}
 
-	healthcheckHandler := setHealthCheck("/ping", hostRouter)
+	if opts.PortalHost != "" {
+		portalConfig := newPortalUpstreamConfig(opts)
+		provider, err := newProvider(opts, portalConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		optFuncs = append(optFuncs,
+			SetProvider(provider),
+			SetCookieStore(opts),
+			SetUpstreamConfig(portalConfig),
+			SetPortal(&portal{entries: portalEntries}),
+			SetValidators(newValidators(provider, portalConfig)),
+		)
 
-	return &SSOProxy{
-		healthcheckHandler,
+		portalProxy, err := NewOAuthProxy(opts, optFuncs...)
+		if err != nil {
+			return nil, err
+		}
+		hostRouter.HandleStatic(opts.PortalHost, portalProxy.Handler())
+		allowedHosts.addHost(opts.PortalHost)
+		traceRouter.HandleStatic(opts.PortalHost, http.HandlerFunc(portalProxy.Trace))
+	}
+
+	defaultHandler, err := newDefaultRouteHandler(opts, upstreamHandlers)
+	if err != nil {
+		return nil, err
+	}
+	if defaultHandler != nil {
+		hostRouter.HandleDefault(defaultHandler)
+	}
+
+	defaultAuthorizeHandler, err := newDefaultRouteHandler(opts, upstreamAuthorizeHandlers)
+	if err != nil {
+		return nil, err
+	}
+	if defaultAuthorizeHandler != nil {
+		authorizeRouter.HandleDefault(defaultAuthorizeHandler)
+	}
+	traceRouter.HandleDefault(newDefaultRouteTraceHandler(opts, upstreamTraceHandlers))
+
+	// checks are only started once the routing is built, so that none are left running if it
+	// can't be
+	for _, checker := range healthCheckers {
+		go checker.run()
+	}
+	certChecker := newCertExpiryChecker(opts)
+	if certChecker != nil {
+		go certChecker.run()
+	}
+
+	var handler http.Handler = hostRouter
+	if opts.ForwardAuth {
+		handler = setForwardAuth(forwardAuthPath, authorizeRouter, handler)
+	}
+	if components.deprovisioner != nil {
+		handler = components.deprovisioner.handler(handler)
+	}
+	// hosts are validated outermost, so that forward auth and SCIM requests are validated too
+	if opts.HostValidation {
+		handler = newHostValidator(handler, allowedHosts, opts.StatsdClient)
+	}
+
+	healthcheckHandler := withRequestID(setHealthCheck("/ping", handler), opts.TrustRequestID)
+	if opts.ServerTiming {
+		healthcheckHandler = withServerTiming(healthcheckHandler)
+	}
+
+	var adminHandler http.Handler
+	if opts.adminAPI() {
+		adminHandler = newAdminHandler(adminConfig{
+			token:        opts.AdminToken,
+			spiffeIDs:    opts.AdminSPIFFEIDs,
+			deviceTokens: components.deviceTokens,
+			events:       components.events,
+			anomalies:    components.anomalies,
+			tracer:       traceRouter,
+			health:       healthCheckers,
+			har:          harRecorder,
+			routes:       routes,
+			signer:       requestSigner,
+			inflight:     components.inflight,
+			usage:        components.usage,
+			blueGreen:    components.blueGreen,
+			drain:        opts.drain,
+		})
+	}
+
+	components.blueGreen.update(opts.upstreamConfigs)
+
+	return &upstreamRouting{
+		handler:        healthcheckHandler,
+		authorize:      authorizeRouter,
+		admin:          adminHandler,
+		healthCheckers: healthCheckers,
+		certChecker:    certChecker,
+		warmPools:      warmPools,
+		statsdClients:  statsdClients,
 	}, nil
 }
+
+// newUpstreamHandler returns the http.Handler that serves authorized requests for an upstream.
+func newUpstreamHandler(config *UpstreamConfig, signer *RequestSigner) (http.Handler, error) {
+	switch route := config.Route.(type) {
+	case *StaticFilesRoute:
+		return NewStaticFilesHandler(route, config)
+	case *BucketRoute:
+		return NewBucketHandler(route, config)
+	default:
+		return NewUpstreamReverseProxy(config, signer)
+	}
+}
+
+// newValidators returns the validators authorizing access to an upstream.
+func newValidators(provider providers.Provider, upstreamConfig *UpstreamConfig) []options.Validator {
+	validators := []options.Validator{}
+	if len(upstreamConfig.AllowedEmailAddresses) != 0 {
+		validators = append(validators, options.NewEmailAddressValidator(upstreamConfig.AllowedEmailAddresses))
+	}
+
+	if len(upstreamConfig.AllowedEmailDomains) != 0 {
+		validators = append(validators, options.NewEmailDomainValidator(upstreamConfig.AllowedEmailDomains))
+	}
+
+	if len(upstreamConfig.AllowedGroups) != 0 {
+		validators = append(validators, options.NewEmailGroupValidator(provider, upstreamConfig.AllowedGroups))
+	}
+	return validators
+}
@@ -1,20 +1,26 @@
 package proxy
 
 import (
+	"crypto/x509"
 	"fmt"
 	"net/url"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/18F/hmacauth"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/buzzfeed/sso/internal/pkg/spiffe"
+	"github.com/buzzfeed/sso/pkg/middleware"
 	"github.com/imdario/mergo"
-	"gopkg.in/yaml.v2"
 )
 
 const (
-	simple  = "simple"
-	rewrite = "rewrite"
+	simple      = "simple"
+	rewrite     = "rewrite"
+	staticFiles = "static_files"
+	bucket      = "bucket"
 )
 
 var (
@@ -25,6 +31,9 @@ var (
 type ServiceConfig struct {
 	Service        string                     `yaml:"service"`
 	ClusterConfigs map[string]*UpstreamConfig `yaml:",inline"`
+
+	// the defaults of the file the service is configured in
+	defaults *OptionsConfig
 }
 
 // SimpleRoute contains a FromURL and ToURL used to construct simple routes in the reverse proxy.
@@ -39,9 +48,24 @@ type RewriteRoute struct {
 	ToTemplate *url.URL
 }
 
+// StaticFilesRoute contains a FromURL and the Root directory used to serve static files directly from sso proxy.
+type StaticFilesRoute struct {
+	FromURL *url.URL
+	Root    string
+}
+
+// BucketRoute contains a FromURL and the S3 or GCS bucket used to serve objects directly from sso proxy.
+type BucketRoute struct {
+	FromURL  *url.URL
+	Provider string
+	Bucket   string
+	Prefix   string
+}
+
 // UpstreamConfig represents the configuration for a given cluster in a given service
 type UpstreamConfig struct {
 	Service string
+	Cluster string
 
 	RouteConfig RouteConfig `yaml:",inline"`
 
@@ -63,8 +87,61 @@ type UpstreamConfig struct {
 	HeaderOverrides       map[string]string
 	InjectRequestHeaders  map[string]string
 	SkipRequestSigning    bool
+	SkipAuthPreflight     bool
+	PreflightOrigins      []string
+	Scopes                []string
+	ClaimHeaders          map[string]string
 	CookieName            string
 	ProviderSlug          string
+	IndexFile             string
+	CacheMaxAge           time.Duration
+	BucketRegion          string
+	BucketEndpoint        string
+	BucketCredentials     *credentials.Credentials
+	Interstitial          string
+	AllowedCountries      []string
+	DeniedCountries       []string
+	DisplayName           string
+	Description           string
+	Icon                  string
+	FaultInjection        *FaultInjectionConfig
+	DegradedMode          string
+	HealthCheck           *HealthCheckConfig
+	Mirror                *MirrorConfig
+	DebugCapture          *DebugCaptureConfig
+	AllowedMethods        []string
+	TLSMinVersion         uint16
+	TLSCipherSuites       []uint16
+	TLSCABundle           string
+	TLSRootCAs            *x509.CertPool
+	SPIFFEMTLS            bool
+	SPIFFEID              string
+	BotDetection          *BotDetectionConfig
+	AuthResponses         *AuthResponsesConfig
+	Metadata              *MetadataConfig
+	PriorityClass         string
+	WarmConnections       int
+	SlowRequestThreshold  time.Duration
+	Middleware            []middleware.Middleware
+	MiddlewareOptions     MiddlewareConfig
+	Script                *ScriptConfig
+	Enrichment            *EnrichmentConfig
+	Backends              []*url.URL
+	Affinity              string
+	AffinityCookie        string
+	BlueGreen             *BlueGreenConfig
+
+	// the defaults of the file the upstream is configured in, see upstreamDefaults
+	defaultOptions *OptionsConfig
+
+	// set when the proxy starts, for upstreams with spiffe options
+	spiffeSource *spiffe.Source
+
+	// set when the proxy starts, for upstreams with blue_green options
+	blueGreenSwitch *blueGreenSwitch
+
+	// set when the upstream's transport is created, for upstreams with warm_connections
+	warmPool *warmPool
 }
 
 // RouteConfig maps to the yaml config fields,
@@ -89,20 +166,96 @@ type RouteConfig struct {
 // * flush_interval - interval at which the proxy should flush data to the browser
 // * skip_request_signing - skip request signing if this behavior is problematic or undesired. For requests with large http bodies
 //   this maybe useful to unset as http bodies are read into memory in order to sign.
+// * index_file - for static_files and bucket routes, the file served for requests to a directory, defaults to index.html
+// * cache_max_age - for static_files routes, the max-age of the Cache-Control header sent with files
+// * bucket_region - for bucket routes, the region of an s3 bucket, defaults to us-east-1
+// * bucket_endpoint - for bucket routes, overrides the s3 or gcs endpoint, e.g. for s3 compatible object stores
+// * interstitial - a message users must acknowledge, once per session, before they can access the service
+// * allowed_countries - optional list of ISO country codes that requests must come from, requires a GeoIP database
+// * denied_countries - optional list of ISO country codes that requests are blocked from, requires a GeoIP database
+// * display_name - the name the service is listed under on the portal, defaults to the service name
+// * description - a short description of the service shown on the portal
+// * icon - the url of an image shown beside the service on the portal
+// * fault_injection - faults injected into a percentage of requests to the upstream, only applied when FAULT_INJECTION is set
+// * degraded_mode - how requests are handled while the provider is unavailable: allow (the default), read_only, or deny
+// * health_check - active health checks of the upstream, whose requests are rejected while it is unhealthy, only for simple routes
+// * mirror - a secondary upstream a percentage of requests are mirrored to, discarding its responses
+// * debug_capture - logs the scrubbed headers and bodies of requests and responses until a given time, for troubleshooting
+// * skip_auth_preflight - skips authentication for preflight (OPTIONS) requests to this upstream, as SKIP_AUTH_PREFLIGHT does for every upstream
+// * preflight_origins - limits skipping authentication to CORS preflight requests from these origins, e.g. https://app.example.com
+// * scopes - additional OAuth scopes users must grant before accessing the upstream, whose consent is requested incrementally
+// * claim_headers - maps claims of the user's ID token to the request headers they're passed in, e.g. employee_id: X-Employee-Id
+// * allowed_methods - optional list of the HTTP methods the upstream accepts, e.g. GET and HEAD for a read-only upstream
+// * tls_min_version - the minimum TLS version of connections to the upstream, defaults to OUTBOUND_TLS_MIN_VERSION
+// * tls_cipher_suites - the TLS 1.0-1.2 cipher suites of connections to the upstream, defaults to OUTBOUND_TLS_CIPHER_SUITES
+// * tls_ca_bundle - a PEM file of CAs trusted to issue the upstream's certificate, besides the system's, defaults to OUTBOUND_TLS_CA_BUNDLE
+// * spiffe_mtls - present the proxy's SPIFFE SVID as the client certificate of connections to the upstream
+// * spiffe_id - the SPIFFE ID of the SVID the upstream must present, verified against the SPIFFE trust bundle instead of the system's CAs
+// * bot_detection - challenges or blocks requests suspected to be made by bots and scanners, with a given sensitivity
+// * auth_responses - whether unauthenticated and unauthorized requests get redirects and error pages or JSON errors, by path or content negotiation
+// * metadata - the team, tier, and service name of the upstream, which tag the metrics and logs of its requests
+// * priority_class - the class requests to the upstream are scheduled in when MAX_CONCURRENT_REQUESTS is reached, defaults to the tier of its metadata
+// * warm_connections - the number of connections to the upstream kept established ahead of its requests, only for simple routes
+// * slow_request_threshold - the duration beyond which requests to the upstream are logged as slow
+// * middleware - the registered custom middleware run for the upstream, each with a map of its own options
+// * script - a Lua script run on the upstream's requests and responses, for small changes to their headers and paths
+// * enrichment - a service called with the identity of each request, returning extra headers injected into it
+// * backends - the urls of other backends serving a simple route, besides its to, which its requests are spread across
+// * affinity - sends the requests of each identity, or each value of the affinity_cookie, to the same one of the backends
+// * affinity_cookie - the cookie whose value picks the backend of requests with cookie affinity
+// * blue_green - blue and green backends of a simple route, which the admin API switches its requests between
 type OptionsConfig struct {
-	HeaderOverrides       map[string]string `yaml:"header_overrides"`
-	InjectRequestHeaders  map[string]string `yaml:"inject_request_headers"`
-	SkipAuthRegex         []string          `yaml:"skip_auth_regex"`
-	AllowedGroups         []string          `yaml:"allowed_groups"`
-	AllowedEmailDomains   []string          `yaml:"allowed_email_domains"`
-	AllowedEmailAddresses []string          `yaml:"allowed_email_addresses"`
-	TLSSkipVerify         bool              `yaml:"tls_skip_verify"`
-	PreserveHost          bool              `yaml:"preserve_host"`
-	Timeout               time.Duration     `yaml:"timeout"`
-	ResetDeadline         time.Duration     `yaml:"reset_deadline"`
-	FlushInterval         time.Duration     `yaml:"flush_interval"`
-	SkipRequestSigning    bool              `yaml:"skip_request_signing"`
-	ProviderSlug          string            `yaml:"provider_slug"`
+	HeaderOverrides       map[string]string     `yaml:"header_overrides"`
+	InjectRequestHeaders  map[string]string     `yaml:"inject_request_headers"`
+	SkipAuthRegex         []string              `yaml:"skip_auth_regex"`
+	AllowedGroups         []string              `yaml:"allowed_groups"`
+	AllowedEmailDomains   []string              `yaml:"allowed_email_domains"`
+	AllowedEmailAddresses []string              `yaml:"allowed_email_addresses"`
+	TLSSkipVerify         bool                  `yaml:"tls_skip_verify"`
+	PreserveHost          bool                  `yaml:"preserve_host"`
+	Timeout               time.Duration         `yaml:"timeout"`
+	ResetDeadline         time.Duration         `yaml:"reset_deadline"`
+	FlushInterval         time.Duration         `yaml:"flush_interval"`
+	SkipRequestSigning    bool                  `yaml:"skip_request_signing"`
+	ProviderSlug          string                `yaml:"provider_slug"`
+	IndexFile             string                `yaml:"index_file"`
+	CacheMaxAge           time.Duration         `yaml:"cache_max_age"`
+	BucketRegion          string                `yaml:"bucket_region"`
+	BucketEndpoint        string                `yaml:"bucket_endpoint"`
+	Interstitial          string                `yaml:"interstitial"`
+	AllowedCountries      []string              `yaml:"allowed_countries"`
+	DeniedCountries       []string              `yaml:"denied_countries"`
+	DisplayName           string                `yaml:"display_name"`
+	Description           string                `yaml:"description"`
+	Icon                  string                `yaml:"icon"`
+	FaultInjection        *FaultInjectionConfig `yaml:"fault_injection"`
+	DegradedMode          string                `yaml:"degraded_mode"`
+	HealthCheck           *HealthCheckConfig    `yaml:"health_check"`
+	Mirror                *MirrorConfig         `yaml:"mirror"`
+	DebugCapture          *DebugCaptureConfig   `yaml:"debug_capture"`
+	SkipAuthPreflight     bool                  `yaml:"skip_auth_preflight"`
+	PreflightOrigins      []string              `yaml:"preflight_origins"`
+	Scopes                []string              `yaml:"scopes"`
+	ClaimHeaders          map[string]string     `yaml:"claim_headers"`
+	AllowedMethods        []string              `yaml:"allowed_methods"`
+	TLSMinVersion         string                `yaml:"tls_min_version"`
+	TLSCipherSuites       []string              `yaml:"tls_cipher_suites"`
+	TLSCABundle           string                `yaml:"tls_ca_bundle"`
+	SPIFFEMTLS            bool                  `yaml:"spiffe_mtls"`
+	SPIFFEID              string                `yaml:"spiffe_id"`
+	BotDetection          *BotDetectionConfig   `yaml:"bot_detection"`
+	AuthResponses         *AuthResponsesConfig  `yaml:"auth_responses"`
+	Metadata              *MetadataConfig       `yaml:"metadata"`
+	PriorityClass         string                `yaml:"priority_class"`
+	WarmConnections       int                   `yaml:"warm_connections"`
+	SlowRequestThreshold  time.Duration         `yaml:"slow_request_threshold"`
+	Middleware            MiddlewareConfig      `yaml:"middleware"`
+	Script                *ScriptConfig         `yaml:"script"`
+	Enrichment            *EnrichmentConfig     `yaml:"enrichment"`
+	Backends              []string              `yaml:"backends"`
+	Affinity              string                `yaml:"affinity"`
+	AffinityCookie        string                `yaml:"affinity_cookie"`
+	BlueGreen             *BlueGreenConfig      `yaml:"blue_green"`
 
 	// CookieName is still set globally, so we do not provide override behavior
 	CookieName string
@@ -123,10 +276,30 @@ func (e *ErrParsingConfig) Error() string {
 }
 
 func loadServiceConfigs(raw []byte, cluster, scheme string, configVars map[string]string, defaultOpts *OptionsConfig) ([]*UpstreamConfig, error) {
+	return loadServiceConfigsFrom(".", raw, cluster, scheme, configVars, defaultOpts)
+}
+
+// loadServiceConfigsFrom loads the upstream configs of a cluster from an upstream configs file
+// in configDir, which the paths of files it includes are relative to.
+func loadServiceConfigsFrom(configDir string, raw []byte, cluster, scheme string, configVars map[string]string, defaultOpts *OptionsConfig) ([]*UpstreamConfig, error) {
+	// The overlay of the cluster's environment sets template variables SSO_CONFIG_ variables don't
+	environments, err := parseEnvironments(raw)
+	if err != nil {
+		return nil, err
+	}
+	configVars = environmentVars(environments[cluster], configVars)
+
 	// We fill in all templated values and resolve overrides
 	rawTemplated := resolveTemplates(raw, configVars)
 
-	serviceConfigs, err := parseServiceConfigs(rawTemplated)
+	file, err := parseUpstreamConfigsFile(rawTemplated)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyEnvironmentDefaults(file, cluster); err != nil {
+		return nil, err
+	}
+	serviceConfigs, err := resolveIncludes(file, configDir, configVars, nil, map[string]bool{})
 	if err != nil {
 		return nil, err
 	}
@@ -144,6 +317,7 @@ func loadServiceConfigs(raw []byte, cluster, scheme string, configVars map[strin
 		// if we don't resolve a upstream config, this cluster is not configured for this upstream
 		// so the proxy struct will be nil and we skip adding it to our running config
 		if proxy != nil {
+			proxy.defaultOptions = service.defaults
 			configs = append(configs, proxy)
 		}
 	}
@@ -155,10 +329,16 @@ func loadServiceConfigs(raw []byte, cluster, scheme string, configVars map[strin
 		}
 
 		for _, extra := range proxy.ExtraRoutes {
+			if extra == nil {
+				return nil, &ErrParsingConfig{
+					Message: fmt.Sprintf("empty extra route of %s", proxy.Service),
+				}
+			}
 			resolvedProxy, err := resolveExtraRoute(extra, proxy)
 			if err != nil {
 				return nil, err
 			}
+			resolvedProxy.defaultOptions = proxy.defaultOptions
 			extraRoutes = append(extraRoutes, resolvedProxy)
 		}
 		// for completeness, we set this to nil now that we've processed extra routes
@@ -190,6 +370,18 @@ func loadServiceConfigs(raw []byte, cluster, scheme string, configVars map[strin
 				return nil, err
 			}
 			proxy.Route = route
+		case staticFiles:
+			route, err := staticFilesRoute(scheme, proxy.RouteConfig)
+			if err != nil {
+				return nil, err
+			}
+			proxy.Route = route
+		case bucket:
+			route, err := bucketRoute(scheme, proxy.RouteConfig)
+			if err != nil {
+				return nil, err
+			}
+			proxy.Route = route
 		default:
 			return nil, &ErrParsingConfig{
 				Message: fmt.Sprintf("unknown routing config type %q", proxy.RouteConfig.Type),
@@ -198,9 +390,13 @@ func loadServiceConfigs(raw []byte, cluster, scheme string, configVars map[strin
 		}
 	}
 
-	// We validate OptionsConfig
+	// We validate OptionsConfig, whose defaults are overridden by those of the file
 	for _, proxy := range configs {
-		err := parseOptionsConfig(proxy, defaultOpts)
+		opts, err := mergeOptionsConfigs(defaultOpts, proxy.defaultOptions)
+		if err != nil {
+			return nil, err
+		}
+		err = parseOptionsConfig(proxy, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -223,9 +419,99 @@ func loadServiceConfigs(raw []byte, cluster, scheme string, configVars map[strin
 
 	}
 
+	for _, proxy := range configs {
+		key := fmt.Sprintf("%s_bucket_credentials", proxy.Service)
+		bucketCredentials, ok := configVars[key]
+		if !ok {
+			continue
+		}
+		creds, err := generateBucketCredentials(bucketCredentials)
+		if err != nil {
+			return nil, &ErrParsingConfig{
+				Message: fmt.Sprintf("unable to generate bucket credentials for %s", proxy.Service),
+				Err:     err,
+			}
+		}
+		proxy.BucketCredentials = creds
+	}
+
 	return configs, nil
 }
 
+// loadClusterServiceConfigs loads the upstream configs of each of the given clusters, so that
+// a single proxy can serve the upstreams of several clusters, e.g. those of a staging and a
+// production root domain.
+func loadClusterServiceConfigs(configDir string, raw []byte, clusters []string, scheme string, configVars map[string]string, defaultOpts *OptionsConfig) ([]*UpstreamConfig, error) {
+	configs := make([]*UpstreamConfig, 0)
+	hosts := make(map[string]string)
+	for _, cluster := range clusters {
+		clusterVars := clusterTemplateVars(configVars, cluster, len(clusters) > 1)
+		clusterConfigs, err := loadServiceConfigsFrom(configDir, raw, cluster, scheme, clusterVars, defaultOpts)
+		if err != nil {
+			if len(clusters) == 1 {
+				return nil, err
+			}
+			return nil, &ErrParsingConfig{
+				Message: fmt.Sprintf("unable to load upstream configs of cluster %s", cluster),
+				Err:     err,
+			}
+		}
+
+		for _, proxy := range clusterConfigs {
+			proxy.Cluster = cluster
+
+			host := routeHost(proxy.Route)
+			if other, ok := hosts[host]; ok && host != "" && other != cluster {
+				return nil, &ErrParsingConfig{
+					Message: fmt.Sprintf("upstream %s of cluster %s has the same `from` host as an upstream of cluster %s: %s",
+						proxy.Service, cluster, other, host),
+				}
+			}
+			hosts[host] = cluster
+		}
+		configs = append(configs, clusterConfigs...)
+	}
+	return configs, nil
+}
+
+// clusterTemplateVars returns the template variables of the given cluster. Variables
+// prefixed with the name of the cluster, e.g. staging_root_domain, override the variable
+// without the prefix. When several clusters are served the cluster variable defaults to the
+// name of the cluster, so that the same upstream configs resolve to each cluster's domains.
+func clusterTemplateVars(configVars map[string]string, cluster string, multiCluster bool) map[string]string {
+	vars := make(map[string]string, len(configVars)+1)
+	for k, v := range configVars {
+		vars[k] = v
+	}
+
+	if multiCluster {
+		vars["cluster"] = cluster
+	}
+
+	prefix := strings.ToLower(cluster) + "_"
+	for k, v := range configVars {
+		if strings.HasPrefix(k, prefix) && len(k) > len(prefix) {
+			vars[strings.TrimPrefix(k, prefix)] = v
+		}
+	}
+	return vars
+}
+
+// routeHost returns the host an upstream's route is served on, or an empty string for
+// routes matching hosts by regular expression.
+func routeHost(route interface{}) string {
+	switch route := route.(type) {
+	case *SimpleRoute:
+		return route.FromURL.Host
+	case *StaticFilesRoute:
+		return route.FromURL.Host
+	case *BucketRoute:
+		return route.FromURL.Host
+	default:
+		return ""
+	}
+}
+
 func rewriteRoute(scheme string, routeConfig RouteConfig) (*RewriteRoute, error) {
 	compiled, err := regexp.Compile(routeConfig.From)
 	if err != nil {
@@ -271,6 +557,65 @@ func simpleRoute(scheme string, routeConfig RouteConfig) (*SimpleRoute, error) {
 	}, nil
 }
 
+func staticFilesRoute(scheme string, routeConfig RouteConfig) (*StaticFilesRoute, error) {
+	fromURL, err := urlParse(scheme, routeConfig.From)
+	if err != nil {
+		return nil, &ErrParsingConfig{
+			Message: "unable to url parse `from` parameter",
+			Err:     err,
+		}
+	}
+
+	// for static files routes the `to` parameter is the root directory to serve
+	if !filepath.IsAbs(routeConfig.To) {
+		return nil, &ErrParsingConfig{
+			Message: "`to` parameter must be an absolute path for static_files routes",
+		}
+	}
+
+	return &StaticFilesRoute{
+		FromURL: fromURL,
+		Root:    filepath.Clean(routeConfig.To),
+	}, nil
+}
+
+func bucketRoute(scheme string, routeConfig RouteConfig) (*BucketRoute, error) {
+	fromURL, err := urlParse(scheme, routeConfig.From)
+	if err != nil {
+		return nil, &ErrParsingConfig{
+			Message: "unable to url parse `from` parameter",
+			Err:     err,
+		}
+	}
+
+	// for bucket routes the `to` parameter is a bucket url, e.g. s3://bucket/prefix or gs://bucket/prefix
+	toURL, err := url.Parse(routeConfig.To)
+	if err != nil {
+		return nil, &ErrParsingConfig{
+			Message: "unable to url parse `to` parameter",
+			Err:     err,
+		}
+	}
+
+	if (toURL.Scheme != bucketProviderS3 && toURL.Scheme != bucketProviderGCS) || toURL.Host == "" {
+		return nil, &ErrParsingConfig{
+			Message: "`to` parameter must be an s3:// or gs:// bucket url for bucket routes",
+		}
+	}
+
+	prefix := strings.Trim(toURL.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	return &BucketRoute{
+		FromURL:  fromURL,
+		Provider: toURL.Scheme,
+		Bucket:   toURL.Host,
+		Prefix:   prefix,
+	}, nil
+}
+
 func urlParse(scheme, uri string) (*url.URL, error) {
 	// NOTE: This is done intentionally to add a scheme so it is valid to parse.
 	//
@@ -283,19 +628,6 @@ func urlParse(scheme, uri string) (*url.URL, error) {
 	return url.Parse(uri)
 }
 
-func parseServiceConfigs(data []byte) ([]*ServiceConfig, error) {
-	serviceConfigs := make([]*ServiceConfig, 0)
-	err := yaml.Unmarshal(data, &serviceConfigs)
-	if err != nil {
-		return nil, &ErrParsingConfig{
-			Message: "failed to parse yaml",
-			Err:     err,
-		}
-	}
-
-	return serviceConfigs, err
-}
-
 func resolveExtraRoute(routeConfig *RouteConfig, src *UpstreamConfig) (*UpstreamConfig, error) {
 	dst := &UpstreamConfig{RouteConfig: *routeConfig}
 
@@ -410,14 +742,276 @@ func parseOptionsConfig(proxy *UpstreamConfig, defaultOpts *OptionsConfig) error
 	proxy.TLSSkipVerify = dst.TLSSkipVerify
 	proxy.PreserveHost = dst.PreserveHost
 	proxy.SkipRequestSigning = dst.SkipRequestSigning
+	proxy.SkipAuthPreflight = dst.SkipAuthPreflight
+	proxy.Scopes = dst.Scopes
 	proxy.CookieName = dst.CookieName
 	proxy.ProviderSlug = dst.ProviderSlug
+	proxy.IndexFile = dst.IndexFile
+	proxy.CacheMaxAge = dst.CacheMaxAge
+	proxy.BucketRegion = dst.BucketRegion
+	proxy.BucketEndpoint = dst.BucketEndpoint
+	proxy.Interstitial = strings.TrimSpace(dst.Interstitial)
+	proxy.AllowedCountries = upperCountryCodes(dst.AllowedCountries)
+	proxy.DeniedCountries = upperCountryCodes(dst.DeniedCountries)
+	proxy.DisplayName = strings.TrimSpace(dst.DisplayName)
+	proxy.Description = strings.TrimSpace(dst.Description)
+	proxy.Icon = dst.Icon
+
+	claimHeaders, err := parseClaimHeaders(dst.ClaimHeaders)
+	if err != nil {
+		return &ErrParsingConfig{
+			Message: "invalid claim_headers",
+			Err:     err,
+		}
+	}
+	proxy.ClaimHeaders = claimHeaders
+
+	if len(dst.PreflightOrigins) != 0 {
+		origins, err := parsePreflightOrigins(dst.PreflightOrigins)
+		if err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid preflight_origins",
+				Err:     err,
+			}
+		}
+		proxy.PreflightOrigins = origins
+	}
+
+	allowedMethods, err := parseAllowedMethods(dst.AllowedMethods)
+	if err != nil {
+		return &ErrParsingConfig{
+			Message: "invalid allowed_methods",
+			Err:     err,
+		}
+	}
+	proxy.AllowedMethods = allowedMethods
+
+	proxy.TLSMinVersion, err = parseTLSVersion(dst.TLSMinVersion)
+	if err != nil {
+		return &ErrParsingConfig{
+			Message: "invalid tls_min_version",
+			Err:     err,
+		}
+	}
+	proxy.TLSCipherSuites, err = parseCipherSuites(dst.TLSCipherSuites)
+	if err != nil {
+		return &ErrParsingConfig{
+			Message: "invalid tls_cipher_suites",
+			Err:     err,
+		}
+	}
+	proxy.TLSRootCAs, err = loadCABundle(dst.TLSCABundle)
+	if err != nil {
+		return &ErrParsingConfig{
+			Message: "invalid tls_ca_bundle",
+			Err:     err,
+		}
+	}
+	proxy.TLSCABundle = dst.TLSCABundle
+
+	if dst.SPIFFEID != "" {
+		if err := spiffe.ValidateID(dst.SPIFFEID); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid spiffe_id",
+				Err:     err,
+			}
+		}
+	}
+	proxy.SPIFFEMTLS = dst.SPIFFEMTLS
+	proxy.SPIFFEID = dst.SPIFFEID
+
+	if dst.BotDetection != nil {
+		if err := dst.BotDetection.validate(); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid bot_detection",
+				Err:     err,
+			}
+		}
+		proxy.BotDetection = dst.BotDetection
+	}
+
+	if dst.AuthResponses != nil {
+		if err := dst.AuthResponses.validate(); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid auth_responses",
+				Err:     err,
+			}
+		}
+		proxy.AuthResponses = dst.AuthResponses
+	}
+
+	if dst.Metadata != nil {
+		if err := dst.Metadata.validate(); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid metadata",
+				Err:     err,
+			}
+		}
+		proxy.Metadata = dst.Metadata
+	}
+
+	proxy.PriorityClass = dst.PriorityClass
+
+	if dst.WarmConnections < 0 {
+		return &ErrParsingConfig{
+			Message: "invalid warm_connections",
+			Err:     fmt.Errorf("must not be negative"),
+		}
+	}
+	proxy.WarmConnections = dst.WarmConnections
+
+	if dst.SlowRequestThreshold < 0 {
+		return &ErrParsingConfig{
+			Message: "invalid slow_request_threshold",
+			Err:     fmt.Errorf("must not be negative"),
+		}
+	}
+	proxy.SlowRequestThreshold = dst.SlowRequestThreshold
+
+	if len(dst.Middleware) != 0 {
+		mws, err := lookupMiddleware(dst.Middleware)
+		if err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid middleware",
+				Err:     err,
+			}
+		}
+		proxy.Middleware = mws
+		proxy.MiddlewareOptions = dst.Middleware
+	}
+
+	if dst.Script != nil {
+		if err := dst.Script.validate(); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid script",
+				Err:     err,
+			}
+		}
+		proxy.Script = dst.Script
+	}
+
+	if dst.Enrichment != nil {
+		if err := dst.Enrichment.validate(); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid enrichment",
+				Err:     err,
+			}
+		}
+		proxy.Enrichment = dst.Enrichment
+	}
+
+	if len(dst.Backends) != 0 {
+		route, ok := proxy.Route.(*SimpleRoute)
+		if !ok {
+			return &ErrParsingConfig{
+				Message: "invalid backends",
+				Err:     fmt.Errorf("only simple routes can have backends"),
+			}
+		}
+		backends, err := parseBackends(route.ToURL.Scheme, dst.Backends)
+		if err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid backends",
+				Err:     err,
+			}
+		}
+		proxy.Backends = backends
+	}
+
+	if dst.BlueGreen != nil {
+		if err := dst.BlueGreen.validate(proxy); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid blue_green",
+				Err:     err,
+			}
+		}
+		proxy.BlueGreen = dst.BlueGreen
+	}
+
+	if dst.Affinity != "" || dst.AffinityCookie != "" {
+		if len(dst.Backends) == 0 && dst.BlueGreen == nil {
+			return &ErrParsingConfig{
+				Message: "invalid affinity",
+				Err:     fmt.Errorf("affinity requires backends or blue_green"),
+			}
+		}
+		if err := validateAffinity(dst.Affinity, dst.AffinityCookie); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid affinity",
+				Err:     err,
+			}
+		}
+	}
+	proxy.Affinity = dst.Affinity
+	proxy.AffinityCookie = dst.AffinityCookie
+
+	if dst.DegradedMode != "" {
+		if err := validateDegradedMode(dst.DegradedMode); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid degraded_mode",
+				Err:     err,
+			}
+		}
+		proxy.DegradedMode = dst.DegradedMode
+	}
+
+	if dst.FaultInjection != nil {
+		if err := dst.FaultInjection.validate(); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid fault_injection",
+				Err:     err,
+			}
+		}
+		proxy.FaultInjection = dst.FaultInjection
+	}
+
+	if dst.HealthCheck != nil {
+		if err := dst.HealthCheck.validate(); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid health_check",
+				Err:     err,
+			}
+		}
+		proxy.HealthCheck = dst.HealthCheck
+	}
+
+	if dst.Mirror != nil {
+		if err := dst.Mirror.validate(); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid mirror",
+				Err:     err,
+			}
+		}
+		proxy.Mirror = dst.Mirror
+	}
+
+	if dst.DebugCapture != nil {
+		if err := dst.DebugCapture.validate(); err != nil {
+			return &ErrParsingConfig{
+				Message: "invalid debug_capture",
+				Err:     err,
+			}
+		}
+		proxy.DebugCapture = dst.DebugCapture
+	}
 
 	proxy.RouteConfig.Options = nil
 
 	return nil
 }
 
+// upperCountryCodes normalizes ISO country codes to upper case, as returned by GeoIP databases.
+func upperCountryCodes(codes []string) []string {
+	if len(codes) == 0 {
+		return nil
+	}
+	upper := make([]string, 0, len(codes))
+	for _, code := range codes {
+		upper = append(upper, strings.ToUpper(strings.TrimSpace(code)))
+	}
+	return upper
+}
+
 func cleanWhiteSpace(s string) string {
 	// This trims all white space from a service name and collapses all remaining space to `_`
 	return space.ReplaceAllString(strings.TrimSpace(s), "_") //
@@ -437,3 +1031,11 @@ func generateHmacAuth(signatureKey string) (hmacauth.HmacAuth, error) {
 	auth := hmacauth.NewHmacAuth(hash, []byte(secret), HMACSignatureHeader, SignatureHeaders)
 	return auth, nil
 }
+
+func generateBucketCredentials(bucketCredentials string) (*credentials.Credentials, error) {
+	components := strings.SplitN(bucketCredentials, ":", 2)
+	if len(components) != 2 || components[0] == "" || components[1] == "" {
+		return nil, fmt.Errorf("invalid bucket credentials access_key_id:secret_access_key spec")
+	}
+	return credentials.NewStaticCredentials(components[0], components[1], ""), nil
+}
@@ -388,6 +388,39 @@ func TestUpstreamConfigInjectRequestHeaders(t *testing.T) {
 	}
 }
 
+func TestUpstreamConfigAllowedMethods(t *testing.T) {
+	wantMethods := []string{"GET", "HEAD"}
+	templateVars := map[string]string{
+		"cluster":     "sso",
+		"root_domain": "dev",
+	}
+	upstreamConfigs, err := loadServiceConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: foo-internal.{{cluster}}.{{root_domain}}
+    options:
+      allowed_methods:
+        - get
+        - HEAD
+        - GET
+`), "sso", "http", templateVars, nil)
+	if err != nil {
+		t.Fatalf("expected to parse upstream configs: %s", err)
+	}
+
+	if len(upstreamConfigs) == 0 {
+		t.Fatalf("expected service config")
+	}
+
+	upstreamConfig := upstreamConfigs[0]
+	if !reflect.DeepEqual(upstreamConfig.AllowedMethods, wantMethods) {
+		t.Logf("want: %v", wantMethods)
+		t.Logf(" got: %v", upstreamConfig.AllowedMethods)
+		t.Errorf("got unexpected allowed methods")
+	}
+}
+
 func TestUpstreamConfigErrorParsing(t *testing.T) {
 	testCases := []struct {
 		Name    string
@@ -458,6 +491,32 @@ func TestUpstreamConfigErrorParsing(t *testing.T) {
 				Message: "unable to url parse `to` parameter",
 			},
 		},
+		{
+			Name: "error on relative static files root",
+			Config: []byte(`
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: var/www/bar
+    type: static_files
+`),
+			WantErr: &ErrParsingConfig{
+				Message: "`to` parameter must be an absolute path for static_files routes",
+			},
+		},
+		{
+			Name: "error on non bucket url for bucket route",
+			Config: []byte(`
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: https://bar-docs.s3.amazonaws.com
+    type: bucket
+`),
+			WantErr: &ErrParsingConfig{
+				Message: "`to` parameter must be an s3:// or gs:// bucket url for bucket routes",
+			},
+		},
 		{
 			Name: "error on malformed skip auth regex",
 			Config: []byte(`
@@ -473,6 +532,42 @@ func TestUpstreamConfigErrorParsing(t *testing.T) {
 				Message: "unable to compile skip auth regex",
 			},
 		},
+		{
+			Name: "error on malformed allowed methods",
+			Config: []byte(`
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: bar-internal.{{cluster}}.{{root_domain}}
+    options:
+      allowed_methods:
+        - GET HEAD
+`),
+			WantErr: &ErrParsingConfig{
+				Message: "invalid allowed_methods",
+			},
+		},
+		{
+			Name:   "error on empty service config",
+			Config: []byte(`[~]`),
+			WantErr: &ErrParsingConfig{
+				Message: "empty service config",
+			},
+		},
+		{
+			Name: "error on empty extra route",
+			Config: []byte(`
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: bar-internal.{{cluster}}.{{root_domain}}
+    extra_routes:
+      - ~
+`),
+			WantErr: &ErrParsingConfig{
+				Message: "empty extra route of bar",
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -566,6 +661,138 @@ func TestUpstreamConfigRoutes(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "handle static files route",
+			rawConfig: []byte(`
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: /var/www/bar/
+    type: static_files
+    options:
+      index_file: README.html
+      cache_max_age: 5m
+`),
+			wantConfigs: []*UpstreamConfig{
+				{
+					Service: "bar",
+					RouteConfig: RouteConfig{
+						From: "bar.sso.dev",
+						To:   "/var/www/bar/",
+						Type: "static_files",
+					},
+					Route: &StaticFilesRoute{
+						FromURL: &url.URL{
+							Scheme: "http",
+							Host:   "bar.sso.dev",
+						},
+						Root: "/var/www/bar",
+					},
+					IndexFile:   "README.html",
+					CacheMaxAge: 5 * time.Minute,
+				},
+			},
+		},
+		{
+			name: "handle bucket route",
+			rawConfig: []byte(`
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: s3://bar-docs/builds/
+    type: bucket
+    options:
+      bucket_region: us-west-2
+`),
+			wantConfigs: []*UpstreamConfig{
+				{
+					Service: "bar",
+					RouteConfig: RouteConfig{
+						From: "bar.sso.dev",
+						To:   "s3://bar-docs/builds/",
+						Type: "bucket",
+					},
+					Route: &BucketRoute{
+						FromURL: &url.URL{
+							Scheme: "http",
+							Host:   "bar.sso.dev",
+						},
+						Provider: "s3",
+						Bucket:   "bar-docs",
+						Prefix:   "builds/",
+					},
+					BucketRegion: "us-west-2",
+				},
+			},
+		},
+		{
+			name: "handle interstitial option",
+			rawConfig: []byte(`
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: bar-internal.{{cluster}}.{{root_domain}}
+    options:
+      interstitial: |
+        This system contains production data.
+`),
+			wantConfigs: []*UpstreamConfig{
+				{
+					Service: "bar",
+					RouteConfig: RouteConfig{
+						From: "bar.sso.dev",
+						To:   "bar-internal.sso.dev",
+					},
+					Route: &SimpleRoute{
+						FromURL: &url.URL{
+							Scheme: "http",
+							Host:   "bar.sso.dev",
+						},
+						ToURL: &url.URL{
+							Scheme: "http",
+							Host:   "bar-internal.sso.dev",
+						},
+					},
+					Interstitial: "This system contains production data.",
+				},
+			},
+		},
+		{
+			name: "handle country options",
+			rawConfig: []byte(`
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: bar-internal.{{cluster}}.{{root_domain}}
+    options:
+      allowed_countries:
+        - us
+        - " GB "
+      denied_countries:
+        - KP
+`),
+			wantConfigs: []*UpstreamConfig{
+				{
+					Service: "bar",
+					RouteConfig: RouteConfig{
+						From: "bar.sso.dev",
+						To:   "bar-internal.sso.dev",
+					},
+					Route: &SimpleRoute{
+						FromURL: &url.URL{
+							Scheme: "http",
+							Host:   "bar.sso.dev",
+						},
+						ToURL: &url.URL{
+							Scheme: "http",
+							Host:   "bar-internal.sso.dev",
+						},
+					},
+					AllowedCountries: []string{"US", "GB"},
+					DeniedCountries:  []string{"KP"},
+				},
+			},
+		},
 		{
 			name: "handle default route w/ explicit tls_verify: true",
 			rawConfig: []byte(`
@@ -1008,3 +1235,90 @@ func TestUpstreamConfigExtraRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestUpstreamConfigBucketCredentials(t *testing.T) {
+	rawConfig := []byte(`
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: gs://foo-docs
+    type: bucket
+`)
+	templateVars := map[string]string{
+		"cluster":                "sso",
+		"root_domain":            "dev",
+		"foo_bucket_credentials": "GOOGACCESSKEY:secret",
+	}
+	upstreamConfigs, err := loadServiceConfigs(rawConfig, "sso", "http", templateVars, nil)
+	if err != nil {
+		t.Fatalf("expected to parse upstream configs: %s", err)
+	}
+
+	value, err := upstreamConfigs[0].BucketCredentials.Get()
+	if err != nil {
+		t.Fatalf("expected bucket credentials: %s", err)
+	}
+	if value.AccessKeyID != "GOOGACCESSKEY" || value.SecretAccessKey != "secret" {
+		t.Errorf("got unexpected bucket credentials %#v", value)
+	}
+
+	templateVars["foo_bucket_credentials"] = "malformed"
+	_, err = loadServiceConfigs(rawConfig, "sso", "http", templateVars, nil)
+	if err == nil {
+		t.Errorf("expected error for malformed bucket credentials")
+	}
+}
+
+func TestUpstreamConfigMultiCluster(t *testing.T) {
+	rawConfig := []byte(`
+- service: foo
+  default:
+    from: foo.{{root_domain}}
+    to: foo.{{cluster}}.internal
+    options:
+      allowed_groups:
+        - "{{allowed_group}}"
+- service: bar
+  staging:
+    from: bar.{{root_domain}}
+    to: bar.{{cluster}}.internal
+`)
+	templateVars := map[string]string{
+		"cluster":               "prod",
+		"root_domain":           "example.com",
+		"allowed_group":         "engineering@example.com",
+		"staging_root_domain":   "staging.example.com",
+		"staging_allowed_group": "everyone@example.com",
+	}
+	upstreamConfigs, err := loadClusterServiceConfigs(".", rawConfig, []string{"prod", "staging"}, "http", templateVars, nil)
+	if err != nil {
+		t.Fatalf("expected to parse upstream configs: %s", err)
+	}
+
+	type result struct {
+		Service, Cluster, From, To string
+		AllowedGroups              []string
+	}
+	got := []result{}
+	for _, uc := range upstreamConfigs {
+		route := uc.Route.(*SimpleRoute)
+		got = append(got, result{uc.Service, uc.Cluster, route.FromURL.Host, route.ToURL.Host, uc.AllowedGroups})
+	}
+	want := []result{
+		{"foo", "prod", "foo.example.com", "foo.prod.internal", []string{"engineering@example.com"}},
+		{"foo", "staging", "foo.staging.example.com", "foo.staging.internal", []string{"everyone@example.com"}},
+		{"bar", "staging", "bar.staging.example.com", "bar.staging.internal", nil},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Logf("got  %#v", got)
+		t.Logf("want %#v", want)
+		t.Fatalf("expected configs to be equal")
+	}
+
+	// clusters may not serve the same host
+	delete(templateVars, "staging_root_domain")
+	_, err = loadClusterServiceConfigs(".", rawConfig, []string{"prod", "staging"}, "http", templateVars, nil)
+	if err == nil {
+		t.Errorf("expected error for upstreams of different clusters sharing a host")
+	}
+}
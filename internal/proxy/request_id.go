@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+const (
+	requestIDHeader   = "X-Request-Id"
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// requestIDPattern matches the inbound request IDs that are trusted, which are short enough to
+// log and can't smuggle anything into log lines or headers.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:/+=@-]{1,128}$`)
+
+// traceparentPattern matches a W3C trace context traceparent header, capturing its trace ID.
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+type requestIDKey struct{}
+
+// requestID returns the ID of a request, or "" if it wasn't given one by withRequestID.
+func requestID(req *http.Request) string {
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random request ID, in the format of a W3C trace ID so that it can
+// begin a trace as well.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("error generating request id: %s", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// inboundRequestID returns the ID a client gave a request, in its X-Request-Id header or else
+// as the trace ID of its traceparent header, or "" if it gave none that's valid.
+func inboundRequestID(req *http.Request) string {
+	if id := req.Header.Get(requestIDHeader); requestIDPattern.MatchString(id) {
+		return id
+	}
+	if m := traceparentPattern.FindStringSubmatch(req.Header.Get(traceparentHeader)); m != nil && m[1] != "00000000000000000000000000000000" {
+		return m[1]
+	}
+	return ""
+}
+
+// withRequestID is middleware giving each request an ID, which is passed to the upstream in
+// the X-Request-Id header, returned to the client in the same response header, and included
+// in the logs, webhook events, and error pages of the request. Unless trust is set, the IDs
+// and trace context sent by clients are replaced by a fresh ID and a trace it begins, so that
+// clients can't collide with or join the traces of others.
+func withRequestID(h http.Handler, trust bool) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var id string
+		if trust {
+			id = inboundRequestID(req)
+		}
+		if id == "" {
+			id = newRequestID()
+			req.Header.Del(tracestateHeader)
+			req.Header.Set(traceparentHeader, newTraceparent(id))
+		}
+
+		req.Header.Set(requestIDHeader, id)
+		rw.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+	})
+}
+
+// newTraceparent returns a sampled traceparent header beginning a trace with a given ID.
+func newTraceparent(traceID string) string {
+	span := make([]byte, 8)
+	rand.Read(span)
+	return fmt.Sprintf("00-%s-%s-01", traceID, hex.EncodeToString(span))
+}
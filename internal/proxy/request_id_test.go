@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestWithRequestID(t *testing.T) {
+	const (
+		inboundID          = "client-request-1"
+		inboundTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		inboundTraceparent = "00-" + inboundTraceID + "-00f067aa0ba902b7-01"
+	)
+
+	testCases := []struct {
+		name        string
+		trust       bool
+		requestID   string
+		traceparent string
+		wantID      string
+		wantTrace   string
+	}{
+		{"generated", false, "", "", "", ""},
+		{"untrusted request id", false, inboundID, inboundTraceparent, "", ""},
+		{"trusted request id", true, inboundID, "", inboundID, ""},
+		{"trusted traceparent", true, "", inboundTraceparent, inboundTraceID, inboundTraceparent},
+		{"trusted request id and traceparent", true, inboundID, inboundTraceparent, inboundID, inboundTraceparent},
+		{"invalid request id", true, "bad id\r\nX-Injected: 1", "", "", ""},
+		{"invalid traceparent", true, "", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var upstream *http.Request
+			handler := withRequestID(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				upstream = req
+			}), tc.trust)
+
+			req := httptest.NewRequest("GET", "https://localhost/", nil)
+			if tc.requestID != "" {
+				req.Header.Set(requestIDHeader, tc.requestID)
+			}
+			if tc.traceparent != "" {
+				req.Header.Set(traceparentHeader, tc.traceparent)
+				req.Header.Set(tracestateHeader, "vendor=client")
+			}
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+
+			id := requestID(upstream)
+			if tc.wantID != "" {
+				testutil.Equal(t, tc.wantID, id)
+			} else {
+				testutil.Equal(t, 32, len(id))
+				testutil.NotEqual(t, tc.requestID, id)
+			}
+			testutil.Equal(t, id, upstream.Header.Get(requestIDHeader))
+			testutil.Equal(t, id, rw.Header().Get(requestIDHeader))
+
+			// a generated id begins a trace, replacing the client's
+			traceparent := upstream.Header.Get(traceparentHeader)
+			if tc.wantTrace != "" {
+				testutil.Equal(t, tc.wantTrace, traceparent)
+				testutil.Equal(t, "vendor=client", upstream.Header.Get(tracestateHeader))
+			} else if tc.wantID == inboundID {
+				testutil.Equal(t, "", traceparent)
+			} else {
+				testutil.Assert(t, strings.HasPrefix(traceparent, "00-"+id+"-"), "unexpected traceparent %q", traceparent)
+				testutil.Equal(t, "", upstream.Header.Get(tracestateHeader))
+			}
+		})
+	}
+}
+
+func TestErrorPageRequestID(t *testing.T) {
+	proxy, close := testNewOAuthProxy(t)
+	defer close()
+
+	handler := withRequestID(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		proxy.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Forbidden")
+	}), true)
+
+	req := httptest.NewRequest("GET", "https://localhost/", nil)
+	req.Header.Set(requestIDHeader, "abc123")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, "abc123", rw.Header().Get(requestIDHeader))
+	testutil.Assert(t, strings.Contains(rw.Body.String(), "Request ID abc123"), "expected request id in error page: %s", rw.Body.String())
+
+	req = httptest.NewRequest("GET", "https://localhost/", nil)
+	req.Header.Set(requestIDHeader, "abc123")
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	body := struct {
+		RequestID string `json:"request_id"`
+	}{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &body))
+	testutil.Equal(t, "abc123", body.RequestID)
+}
@@ -12,9 +12,14 @@ import (
 	"encoding/pem"
 	"fmt"
 	"hash"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/buzzfeed/sso/pkg/signature"
 )
 
 // Only headers enumerated in this list are used to compute the signature of a request.
@@ -35,20 +40,29 @@ var signedHeaders = []string{
 var signatureHeader = "Sso-Signature"
 var signingKeyHeader = "kid"
 
-// RequestSigner exposes an interface for digitally signing requests using an RSA private key.
-// See comments for the Sign() method below, for more on how this signature is constructed.
+// RequestSigner exposes an interface for digitally signing requests using an RSA, ECDSA P-256, or
+// Ed25519 private key. See comments for the Sign() method below, for more on how this signature is
+// constructed.
 type RequestSigner struct {
 	newHasher    func() hash.Hash
 	signingKey   crypto.Signer
+	signerOpts   crypto.SignerOpts
+	algorithm    string
+	publicKey    crypto.PublicKey
 	publicKeyStr string
 	publicKeyID  string
+
+	// bodyDigest signs a timestamp and the digest of request bodies of up to maxBodySize bytes,
+	// rather than the bodies themselves
+	bodyDigest  bool
+	maxBodySize int64
+	now         func() time.Time
 }
 
-// NewRequestSigner constructs a RequestSigner object from a PEM+PKCS8 encoded RSA public key.
+// NewRequestSigner constructs a RequestSigner object from a PEM encoded private key. The key's
+// encoding is detected from its contents: it may be a PKCS8 RSA, ECDSA P-256, or Ed25519 key, a
+// PKCS1 RSA key, or a SEC1 ECDSA P-256 key.
 func NewRequestSigner(signingKeyPemStr string) (*RequestSigner, error) {
-	var privateKey crypto.Signer
-	var publicKeyPEM []byte
-
 	// Strip PEM encoding from private key.
 	block, _ := pem.Decode([]byte(signingKeyPemStr))
 	if block == nil {
@@ -56,22 +70,47 @@ func NewRequestSigner(signingKeyPemStr string) (*RequestSigner, error) {
 	}
 
 	// Extract private key as a crypto.Signer object.
-	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	privateKey, err := parseSigningKey(block.Bytes)
 	if err != nil {
 		return nil, fmt.Errorf("could not read key from signing key bytes: %s", err)
 	}
-	privateKey = key.(crypto.Signer)
+	return newRequestSigner(privateKey)
+}
 
-	// Derive public key.
-	rsaPublicKey, ok := privateKey.Public().(*rsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("only RSA public keys are currently supported")
+// newRequestSigner constructs a RequestSigner object from a crypto.Signer, which may keep its
+// private key out of memory, e.g. in a KMS.
+func newRequestSigner(privateKey crypto.Signer) (*RequestSigner, error) {
+	var publicKeyPEM []byte
+
+	algorithm, err := signature.Algorithm(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive public key. RSA keys keep their PKCS1 encoding, so that their key IDs don't change.
+	var signerOpts crypto.SignerOpts = crypto.SHA256
+	switch publicKey := privateKey.Public().(type) {
+	case *rsa.PublicKey:
+		publicKeyPEM = pem.EncodeToMemory(
+			&pem.Block{
+				Type:  "RSA PUBLIC KEY",
+				Bytes: x509.MarshalPKCS1PublicKey(publicKey),
+			})
+	default:
+		// Ed25519 signs the document hash itself, rather than hashing it again.
+		if algorithm == signature.AlgorithmEdDSA {
+			signerOpts = crypto.Hash(0)
+		}
+		publicKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not marshal public key: %s", err)
+		}
+		publicKeyPEM = pem.EncodeToMemory(
+			&pem.Block{
+				Type:  "PUBLIC KEY",
+				Bytes: publicKeyBytes,
+			})
 	}
-	publicKeyPEM = pem.EncodeToMemory(
-		&pem.Block{
-			Type:  "RSA PUBLIC KEY",
-			Bytes: x509.MarshalPKCS1PublicKey(rsaPublicKey),
-		})
 
 	var keyHash []byte
 	hasher := sha256.New()
@@ -81,11 +120,41 @@ func NewRequestSigner(signingKeyPemStr string) (*RequestSigner, error) {
 	return &RequestSigner{
 		newHasher:    func() hash.Hash { return sha256.New() },
 		signingKey:   privateKey,
+		signerOpts:   signerOpts,
+		algorithm:    algorithm,
+		publicKey:    privateKey.Public(),
 		publicKeyStr: string(publicKeyPEM),
 		publicKeyID:  hex.EncodeToString(keyHash),
+		now:          time.Now,
 	}, nil
 }
 
+// parseSigningKey parses a DER encoded PKCS8, PKCS1, or SEC1 private key, whichever it is.
+func parseSigningKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return signer, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("must be a PKCS8, PKCS1, or SEC1 private key")
+}
+
+// EnableBodyDigest makes the signer sign a timestamp and the SHA-256 digest of request bodies,
+// rather than the bodies themselves, so that upstreams can reject replayed requests. Bodies
+// larger than maxBodySize aren't read into memory to be hashed, and are sent unsigned.
+func (signer *RequestSigner) EnableBodyDigest(maxBodySize int64) {
+	signer.bodyDigest = true
+	signer.maxBodySize = maxBodySize
+}
+
 // mapRequestToHashInput returns a string representation of a Request, formatted as a
 // newline-separated sequence of entries from the request. Any two Requests sharing the same
 // representation are considered "equivalent" for purposes of verifying the integrity of a request.
@@ -139,6 +208,59 @@ func mapRequestToHashInput(req *http.Request) (string, error) {
 	return strings.Join(entries, "\n"), nil
 }
 
+// mapRequestToDigestHashInput returns the representation of a Request signed with a body
+// digest, formatted as a newline-separated sequence of entries from the request:
+//   <HEADER.1>
+//   ...
+//   <HEADER.N>
+//   <URL>
+//   <TIMESTAMP>
+//   <BODY DIGEST>
+//  where:
+//    <HEADER.k> and <URL> are as in mapRequestToHashInput(),
+//    <TIMESTAMP> is the unix time at which the request is signed, in seconds, also set in the
+//      `Sso-Signature-Timestamp` header,
+//    <BODY DIGEST> is the hex encoded SHA-256 digest of the body, also set in the
+//      `Sso-Content-Sha256` header, or UNSIGNED-PAYLOAD if the body is larger than the signer's
+//      maximum body size.
+func (signer RequestSigner) mapRequestToDigestHashInput(req *http.Request) (string, error) {
+	digest, err := signer.bodyDigestOf(req)
+	if err != nil {
+		return "", err
+	}
+	timestamp := strconv.FormatInt(signer.now().Unix(), 10)
+	req.Header.Set(signature.TimestampHeader, timestamp)
+	req.Header.Set(signature.ContentSHA256Header, digest)
+	return signature.DigestRepresentation(req, timestamp, digest), nil
+}
+
+// bodyDigestOf returns the hex encoded SHA-256 digest of the request's body, reading at most
+// one byte more than the maximum body size into memory. The body is replaced, so that it's
+// still sent in full.
+func (signer RequestSigner) bodyDigestOf(req *http.Request) (string, error) {
+	hasher := sha256.New()
+	if req.Body == nil || req.Body == http.NoBody {
+		return hex.EncodeToString(hasher.Sum(nil)), nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, signer.maxBodySize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(body)) > signer.maxBodySize {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		return signature.UnsignedPayload, nil
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	_, _ = hasher.Write(body)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
 // Sign appends a header to the request, with a public-key encrypted signature derive from
 // a subset of the request headers, together with the request URL and body.
 //
@@ -147,7 +269,11 @@ func mapRequestToHashInput(req *http.Request) (string, error) {
 //   hash := SHA256(repr)
 //   sig  := SIGN(hash, SigningKey)
 //   final := WEB_SAFE_BASE64(sig)
-// The header `Sso-Signature` is given the value of `final`.
+// The header `Sso-Signature` is given the value of `final`, and `Sso-Signature-Algorithm` the
+// algorithm of SIGN, which depends on the type of SigningKey:
+//   RS256 is RSASSA-PKCS1-v1_5 with SHA256,
+//   ES256 is ECDSA P-256, with an ASN.1 DER encoded signature,
+//   EdDSA is Ed25519, signing `hash` itself as the message.
 //
 // Receiving endpoints authenticating the integrity of a request should:
 //   1. Strip the WEB_SAFE_BASE64 encoding from the value of `signatureHeader`,
@@ -158,10 +284,21 @@ func mapRequestToHashInput(req *http.Request) (string, error) {
 //   4. Apply SHA256 hash to the recomputed representation, and verify that it matches the decrypted
 //      hash value received through the `Sso-Signature` of the request.
 //
-//  Any requests failing this check should be considered tampered with, and rejected.
+//  Any requests failing this check should be considered tampered with, and rejected. For ECDSA
+//  and Ed25519 keys, steps 2 and 4 are replaced by verifying the signature of the recomputed hash.
+//
+// With a body digest, the request representation is computed by mapRequestToDigestHashInput()
+// instead, and the `Sso-Signature-Timestamp` and `Sso-Content-Sha256` headers are set. The
+// github.com/buzzfeed/sso/pkg/signature package verifies signatures of either kind.
 func (signer RequestSigner) Sign(req *http.Request) error {
 	// Generate the request representation that will serve as hash input.
-	repr, err := mapRequestToHashInput(req)
+	var repr string
+	var err error
+	if signer.bodyDigest {
+		repr, err = signer.mapRequestToDigestHashInput(req)
+	} else {
+		repr, err = mapRequestToHashInput(req)
+	}
 	if err != nil {
 		return fmt.Errorf("could not generate representation for request: %s", err)
 	}
@@ -174,25 +311,37 @@ func (signer RequestSigner) Sign(req *http.Request) error {
 	documentHash = hasher.Sum(documentHash)
 
 	// Sign the documentHash with the signing key.
-	signatureBytes, err := signer.signingKey.Sign(rand.Reader, documentHash, crypto.SHA256)
+	signatureBytes, err := signer.signingKey.Sign(rand.Reader, documentHash, signer.signerOpts)
 	if err != nil {
 		return fmt.Errorf("failed signing document hash with signing key: %s", err)
 	}
-	signature := base64.URLEncoding.EncodeToString(signatureBytes)
+	encodedSignature := base64.URLEncoding.EncodeToString(signatureBytes)
 
 	// Set the signature and signing-key request headers. Return nil to indicate no error.
-	req.Header.Set(signatureHeader, signature)
+	req.Header.Set(signatureHeader, encodedSignature)
 	req.Header.Set(signingKeyHeader, signer.publicKeyID)
+	req.Header.Set(signature.AlgorithmHeader, signer.algorithm)
 	return nil
 }
 
 // PublicKey returns a pair (KeyID, Key), where:
 //   - KeyID is a unique identifier (currently the SHA256 hash of Key),
-//   - Key is the (PEM+PKCS1)-encoding of a public key, usable for validating signed requests.
+//   - Key is the PEM encoding of a public key, usable for validating signed requests; PKCS1 for
+//     RSA keys, and PKIX for ECDSA and Ed25519 keys.
 func (signer RequestSigner) PublicKey() (string, string) {
 	return signer.publicKeyID, signer.publicKeyStr
 }
 
+// JWKS returns the public key as a JSON Web Key Set, for upstreams that verify signatures with
+// JOSE libraries.
+func (signer RequestSigner) JWKS() (*signature.JWKS, error) {
+	jwk, err := signature.NewJWK(signer.publicKeyID, signer.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &signature.JWKS{Keys: []signature.JWK{jwk}}, nil
+}
+
 func removeEmpty(s []string) []string {
 	r := []string{}
 	for _, str := range s {
@@ -2,6 +2,10 @@ package proxy
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
@@ -11,10 +15,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/pkg/signature"
 )
 
 // Convenience variables and utilities.
@@ -178,3 +185,179 @@ func TestSignatureRoundTripDecoding(t *testing.T) {
 	pubKeyHash = hasher.Sum(pubKeyHash)
 	testutil.Equal(t, hex.EncodeToString(pubKeyHash), req.Header.Get("kid"))
 }
+
+func TestSignatureVerifiedByPackage(t *testing.T) {
+	privateKey, err := ioutil.ReadFile("testdata/private_key.pem")
+	testutil.Assert(t, err == nil, "error reading private key from testdata")
+
+	now := time.Unix(1500000000, 0)
+	largeBody := strings.Repeat("a", 64)
+
+	testCases := []struct {
+		name         string
+		bodyDigest   bool
+		body         string
+		expectDigest string
+	}{
+		{
+			name: "signed body",
+			body: "something\nor other",
+		},
+		{
+			name:         "signed body digest",
+			bodyDigest:   true,
+			body:         "something\nor other",
+			expectDigest: "610858ae70ec2b71130e393c08e475f17682bf9eb2785adfa25b57b423bc62b8",
+		},
+		{
+			name:         "empty body digest",
+			bodyDigest:   true,
+			expectDigest: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			name:         "body larger than the maximum body size",
+			bodyDigest:   true,
+			body:         largeBody,
+			expectDigest: signature.UnsignedPayload,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requestSigner, err := NewRequestSigner(string(privateKey))
+			testutil.Assert(t, err == nil, "could not initialize request signer: %s", err)
+			if tc.bodyDigest {
+				requestSigner.EnableBodyDigest(32)
+				requestSigner.now = func() time.Time { return now }
+			}
+
+			req := httptest.NewRequest("POST", urlExample, strings.NewReader(tc.body))
+			addHeaders(req, []string{"X-Forwarded-Email", "X-Forwarded-Groups"}, nil)
+			err = requestSigner.Sign(req)
+			testutil.Assert(t, err == nil, "could not sign request: %s", err)
+
+			if tc.bodyDigest {
+				testutil.Equal(t, "1500000000", req.Header.Get("Sso-Signature-Timestamp"))
+				testutil.Equal(t, tc.expectDigest, req.Header.Get("Sso-Content-Sha256"))
+			} else {
+				testutil.Equal(t, "", req.Header.Get("Sso-Signature-Timestamp"))
+			}
+
+			kid, publicKey := requestSigner.PublicKey()
+			verifier, err := signature.NewVerifier(map[string]string{kid: publicKey})
+			testutil.Assert(t, err == nil, "could not initialize verifier: %s", err)
+			verifier.MaxAge = time.Since(now) + time.Hour
+			testutil.Equal(t, nil, verifier.Verify(req))
+
+			// the body is sent in full, whether or not it was hashed
+			body, _ := ioutil.ReadAll(req.Body)
+			testutil.Equal(t, tc.body, string(body))
+		})
+	}
+}
+
+func testSigningKeyPEM(t *testing.T, blockType string, der []byte, err error) string {
+	testutil.Assert(t, err == nil, "could not marshal signing key: %s", err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}))
+}
+
+func TestRequestSignerKeyTypes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	testutil.Assert(t, err == nil, "could not generate RSA key: %s", err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testutil.Assert(t, err == nil, "could not generate ECDSA key: %s", err)
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	testutil.Assert(t, err == nil, "could not generate ECDSA key: %s", err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	testutil.Assert(t, err == nil, "could not generate Ed25519 key: %s", err)
+
+	pkcs8 := func(key interface{}) string {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		return testSigningKeyPEM(t, "PRIVATE KEY", der, err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+
+	testCases := []struct {
+		name          string
+		keyPEM        string
+		wantAlgorithm string
+		wantKeyType   string
+		wantErr       bool
+	}{
+		{
+			name:          "PKCS8 RSA key",
+			keyPEM:        pkcs8(rsaKey),
+			wantAlgorithm: "RS256",
+			wantKeyType:   "RSA",
+		},
+		{
+			name:          "PKCS1 RSA key",
+			keyPEM:        testSigningKeyPEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey), nil),
+			wantAlgorithm: "RS256",
+			wantKeyType:   "RSA",
+		},
+		{
+			name:          "PKCS8 ECDSA key",
+			keyPEM:        pkcs8(ecKey),
+			wantAlgorithm: "ES256",
+			wantKeyType:   "EC",
+		},
+		{
+			name:          "SEC1 ECDSA key",
+			keyPEM:        testSigningKeyPEM(t, "EC PRIVATE KEY", sec1, err),
+			wantAlgorithm: "ES256",
+			wantKeyType:   "EC",
+		},
+		{
+			name:          "PKCS8 Ed25519 key",
+			keyPEM:        pkcs8(edKey),
+			wantAlgorithm: "EdDSA",
+			wantKeyType:   "OKP",
+		},
+		{
+			name:    "unsupported ECDSA curve",
+			keyPEM:  pkcs8(p384Key),
+			wantErr: true,
+		},
+		{
+			name:    "not a private key",
+			keyPEM:  testSigningKeyPEM(t, "PRIVATE KEY", []byte("not a key"), nil),
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requestSigner, err := NewRequestSigner(tc.keyPEM)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+				return
+			}
+			testutil.Assert(t, err == nil, "could not initialize request signer: %s", err)
+
+			req := httptest.NewRequest("POST", urlExample, strings.NewReader("something\nor other"))
+			addHeaders(req, []string{"X-Forwarded-Email", "X-Forwarded-Groups"}, nil)
+			err = requestSigner.Sign(req)
+			testutil.Assert(t, err == nil, "could not sign request: %s", err)
+			testutil.Equal(t, tc.wantAlgorithm, req.Header.Get("Sso-Signature-Algorithm"))
+
+			// the request can be verified with the key published at /oauth2/v1/certs
+			kid, publicKey := requestSigner.PublicKey()
+			testutil.Equal(t, kid, req.Header.Get("kid"))
+			verifier, err := signature.NewVerifier(map[string]string{kid: publicKey})
+			testutil.Assert(t, err == nil, "could not initialize verifier: %s", err)
+			testutil.Equal(t, nil, verifier.Verify(req))
+
+			// and with the key set published at /admin/jwks
+			jwks, err := requestSigner.JWKS()
+			testutil.Assert(t, err == nil, "could not encode JWKS: %s", err)
+			testutil.Equal(t, 1, len(jwks.Keys))
+			testutil.Equal(t, kid, jwks.Keys[0].KeyID)
+			testutil.Equal(t, tc.wantKeyType, jwks.Keys[0].KeyType)
+			testutil.Equal(t, tc.wantAlgorithm, jwks.Keys[0].Algorithm)
+			verifier, err = signature.NewVerifierFromJWKS(jwks)
+			testutil.Assert(t, err == nil, "could not initialize verifier: %s", err)
+			testutil.Equal(t, nil, verifier.Verify(req))
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/buzzfeed/sso/internal/pkg/fips"
 	log "github.com/buzzfeed/sso/internal/pkg/logging"
 )
 
@@ -25,6 +26,21 @@ type upstreamTransport struct {
 
 	transport          *http.Transport
 	insecureSkipVerify bool
+	tlsConfig          *tls.Config
+	warmPool           *warmPool
+}
+
+// newUpstreamTransport returns the transport of requests to an upstream, whose connections are
+// reset and secured as the upstream's config sets.
+func newUpstreamTransport(config *UpstreamConfig) *upstreamTransport {
+	t := &upstreamTransport{
+		resetDeadline:      config.ResetDeadline,
+		insecureSkipVerify: config.TLSSkipVerify,
+		tlsConfig:          newTLSConfig(config.TLSMinVersion, config.TLSCipherSuites, config.TLSRootCAs),
+	}
+	configureSPIFFETLS(t, config)
+	configureWarmPool(t, config)
+	return t
 }
 
 // RoundTrip fulfilles the RoundTripper interface.
@@ -59,14 +75,27 @@ func (t *upstreamTransport) getTransport() *http.Transport {
 			MaxIdleConns:          100,
 			IdleConnTimeout:       90 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
-			TLSClientConfig:       &tls.Config{InsecureSkipVerify: t.insecureSkipVerify},
+			TLSClientConfig:       t.clientTLSConfig(),
 			ExpectContinueTimeout: 1 * time.Second,
 		}
+		if t.warmPool != nil {
+			warmTransport(t.transport, t.warmPool)
+		}
 	}
 
 	return t.transport
 }
 
+// clientTLSConfig returns the TLS config of connections to the upstream.
+func (t *upstreamTransport) clientTLSConfig() *tls.Config {
+	tlsConfig := &tls.Config{}
+	if t.tlsConfig != nil {
+		tlsConfig = t.tlsConfig.Clone()
+	}
+	tlsConfig.InsecureSkipVerify = t.insecureSkipVerify
+	return fips.TLSConfig(tlsConfig)
+}
+
 // NewUpstreamReverseProxy implements our reverse proxy behavior for each upstream. It is configurable
 // using the passed in UpstreamConfig and returns a generic http.Handler. This reverse proxy implements
 // a variety of directors based on the behavior designed by the configuration, including static and regexp routes.
@@ -79,6 +108,11 @@ func NewUpstreamReverseProxy(config *UpstreamConfig, signer *RequestSigner) (htt
 	switch route := config.Route.(type) {
 	case *SimpleRoute:
 		directorFunc = baseDirector.StaticDirectorFunc(route)
+		if config.BlueGreen != nil {
+			directorFunc = baseDirector.BlueGreenDirectorFunc(route)
+		} else if len(config.Backends) != 0 {
+			directorFunc = baseDirector.BackendsDirectorFunc(route)
+		}
 	case *RewriteRoute:
 		directorFunc = baseDirector.RewriteDirectorFunc(route)
 	default:
@@ -86,20 +120,15 @@ func NewUpstreamReverseProxy(config *UpstreamConfig, signer *RequestSigner) (htt
 	}
 
 	reverseProxy := &httputil.ReverseProxy{
-		Director: directorFunc,
-		Transport: &upstreamTransport{
-			resetDeadline:      config.ResetDeadline,
-			insecureSkipVerify: config.TLSSkipVerify,
-		},
+		Director:      directorFunc,
+		Transport:     newUpstreamTransport(config),
 		FlushInterval: config.FlushInterval,
 		ModifyResponse: func(resp *http.Response) error {
 			// DRAGONS: This helps implement special behavior regarding security headers.
 			//
 			// We do not allow upstreams to override security headers. We set these headers
 			// in a higher-level middleware to ensure they are added to all requests.
-			for key := range securityHeaders {
-				resp.Header.Del(key)
-			}
+			securityHeaderSet.del(resp.Header)
 
 			return nil
 		},
@@ -164,6 +193,16 @@ func (d *Director) StaticDirectorFunc(route *SimpleRoute) func(*http.Request) {
 	return d.DirectorFunc(route.ToURL)
 }
 
+// BackendsDirectorFunc directs requests to one of the backends of a simple route, its to and
+// those of its backends option, by the route's affinity.
+func (d *Director) BackendsDirectorFunc(route *SimpleRoute) func(*http.Request) {
+	backends := append([]*url.URL{route.ToURL}, d.config.Backends...)
+	picker := newBackendPicker(backends, d.config.Affinity, d.config.AffinityCookie)
+	return func(req *http.Request) {
+		d.DirectorFunc(picker.pick(req))(req)
+	}
+}
+
 // RewriteDirectorFunc is capable of using a regexp to re-write requests and dynamically route
 // requests to various upstreams.
 func (d *Director) RewriteDirectorFunc(route *RewriteRoute) func(*http.Request) {
@@ -203,7 +242,14 @@ func newSigningHandler(handler http.Handler, config *UpstreamConfig, signer *Req
 		}
 
 		if signer != nil {
-			signer.Sign(req)
+			// a request that can't be signed, e.g. because the KMS holding the key is
+			// unavailable, is failed rather than sent to the upstream unsigned
+			if err := signer.Sign(req); err != nil {
+				log.NewLogEntry().WithRemoteAddress(getRemoteAddr(req)).WithRequestHost(req.Host).WithError(err).Error(
+					"error signing request")
+				http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
 		}
 
 		handler.ServeHTTP(rw, req)
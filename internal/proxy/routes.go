@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// routeEntry describes an upstream as the proxy loaded it, after templating and resolving the
+// options of its cluster, for the admin API.
+type routeEntry struct {
+	Service  string `json:"service"`
+	Cluster  string `json:"cluster"`
+	Type     string `json:"type"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Provider string `json:"provider"`
+
+	Policy  routePolicy  `json:"policy"`
+	Headers routeHeaders `json:"headers"`
+
+	// Health is the state of the upstream's health checks, which reject its requests while it's
+	// unhealthy. It is nil if the upstream has no health checks.
+	Health *healthStatus `json:"health,omitempty"`
+
+	health *healthChecker
+}
+
+// routePolicy is how requests to an upstream are authenticated and authorized.
+type routePolicy struct {
+	AllowedGroups         []string `json:"allowed_groups,omitempty"`
+	AllowedEmailDomains   []string `json:"allowed_email_domains,omitempty"`
+	AllowedEmailAddresses []string `json:"allowed_email_addresses,omitempty"`
+	SkipAuthRegex         []string `json:"skip_auth_regex,omitempty"`
+	SkipAuthPreflight     bool     `json:"skip_auth_preflight"`
+	PreflightOrigins      []string `json:"preflight_origins,omitempty"`
+	AllowedCountries      []string `json:"allowed_countries,omitempty"`
+	DeniedCountries       []string `json:"denied_countries,omitempty"`
+	Scopes                []string `json:"scopes,omitempty"`
+	DegradedMode          string   `json:"degraded_mode"`
+	AllowedMethods        []string `json:"allowed_methods,omitempty"`
+}
+
+// routeHeaders are the headers an upstream's requests and responses are given. Only the keys
+// of injected request headers are listed, as their values are often credentials.
+type routeHeaders struct {
+	Overrides map[string]string `json:"overrides,omitempty"`
+	Injected  []string          `json:"injected,omitempty"`
+	Claims    map[string]string `json:"claims,omitempty"`
+	Signed    bool              `json:"signed"`
+}
+
+// newRouteEntry returns the routeEntry of the upstream served by an OAuthProxy. The health
+// checker may be nil.
+func newRouteEntry(p *OAuthProxy, health *healthChecker) *routeEntry {
+	entry := compileRouteEntry(p.upstreamConfig, p.injectRequestHeaders, p.claimHeaders, p.requestSigner != nil)
+	entry.health = health
+	return entry
+}
+
+// compileRouteEntry returns the routeEntry of an upstream config, whose requests are given the
+// injected and claim headers, and are signed if signed is true.
+func compileRouteEntry(config *UpstreamConfig, injected headerSet, claims []claimHeader, signed bool) *routeEntry {
+	entry := &routeEntry{
+		Service:  config.Service,
+		Cluster:  config.Cluster,
+		Type:     config.RouteConfig.Type,
+		Provider: config.ProviderSlug,
+		Policy: routePolicy{
+			AllowedGroups:         config.AllowedGroups,
+			AllowedEmailDomains:   config.AllowedEmailDomains,
+			AllowedEmailAddresses: config.AllowedEmailAddresses,
+			SkipAuthRegex:         regexpStrings(config.SkipAuthCompiledRegex),
+			SkipAuthPreflight:     config.SkipAuthPreflight,
+			PreflightOrigins:      config.PreflightOrigins,
+			AllowedCountries:      config.AllowedCountries,
+			DeniedCountries:       config.DeniedCountries,
+			Scopes:                config.Scopes,
+			DegradedMode:          config.DegradedMode,
+			AllowedMethods:        config.AllowedMethods,
+		},
+		Headers: routeHeaders{
+			Overrides: config.HeaderOverrides,
+			Injected:  injected.keys(),
+			Signed:    signed && !config.SkipRequestSigning,
+		},
+	}
+	if entry.Type == "" {
+		entry.Type = simple
+	}
+	if entry.Policy.DegradedMode == "" {
+		entry.Policy.DegradedMode = degradedAllow
+	}
+
+	switch route := config.Route.(type) {
+	case *SimpleRoute:
+		entry.From = route.FromURL.String()
+	case *RewriteRoute:
+		entry.From = route.FromRegex.String()
+	case *StaticFilesRoute:
+		entry.From = route.FromURL.String()
+	case *BucketRoute:
+		entry.From = route.FromURL.String()
+	}
+	entry.To = routeTarget(config)
+
+	if len(claims) != 0 {
+		entry.Headers.Claims = make(map[string]string, len(claims))
+		for _, h := range claims {
+			entry.Headers.Claims[h.claim] = h.key
+		}
+	}
+	return entry
+}
+
+// routeTarget returns where an upstream's requests are sent: the URL of a simple route, the
+// template of a rewrite route, or the files served by static files and bucket routes.
+func routeTarget(config *UpstreamConfig) string {
+	if route, ok := config.Route.(*RewriteRoute); ok {
+		return route.ToTemplate.Scheme + "://" + route.ToTemplate.Opaque
+	}
+	return traceTarget(config, nil)
+}
+
+// status returns the entry with the current state of the upstream's health checks.
+func (e *routeEntry) status() routeEntry {
+	entry := *e
+	if e.health != nil {
+		status := e.health.Status()
+		entry.Health = &status
+	}
+	return entry
+}
+
+func regexpStrings(res []*regexp.Regexp) []string {
+	if len(res) == 0 {
+		return nil
+	}
+	strs := make([]string, 0, len(res))
+	for _, re := range res {
+		strs = append(strs, re.String())
+	}
+	return strs
+}
+
+// Routes lists every upstream the proxy serves, as it was loaded, with a GET request.
+func (h *adminHandler) Routes(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	routes := make([]routeEntry, 0, len(h.routes))
+	for _, route := range h.routes {
+		routes = append(routes, route.status())
+	}
+	writeJSON(rw, http.StatusOK, struct {
+		Routes []routeEntry `json:"routes"`
+	}{
+		Routes: routes,
+	})
+}
+
+// WriteRouteTable writes the routes the upstream configs of validated options are compiled to,
+// as the admin API lists them, without creating their providers or starting their checks. The
+// format is either "table", a line per route, or "json".
+func WriteRouteTable(w io.Writer, opts *Options, format string) error {
+	signed := opts.RequestSigningKey != "" || opts.RequestSignatureKMSKey != ""
+	routes := make([]routeEntry, 0, len(opts.upstreamConfigs))
+	for _, config := range opts.upstreamConfigs {
+		var claims []claimHeader
+		if !opts.BuzzfeedCompat {
+			claims = newClaimHeaders(config.ClaimHeaders)
+		}
+		routes = append(routes, *compileRouteEntry(config, newHeaderSet(config.InjectRequestHeaders), claims, signed))
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Routes []routeEntry `json:"routes"`
+		}{
+			Routes: routes,
+		})
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "SERVICE\tCLUSTER\tTYPE\tFROM\tTO\tPROVIDER\tALLOWED GROUPS")
+		for _, route := range routes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", route.Service, orDash(route.Cluster), route.Type,
+				route.From, route.To, orDash(route.Provider), orDash(strings.Join(route.Policy.AllowedGroups, ",")))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown route table format %q", format)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/proxy/configtest"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of tests with their output")
+
+// checkGolden compares output with the golden file at path, or replaces the golden file with it
+// when the tests are run with -update.
+func checkGolden(t *testing.T, path string, output []byte) {
+	if *updateGolden {
+		testutil.Ok(t, ioutil.WriteFile(path, output, 0644))
+		return
+	}
+
+	golden, err := ioutil.ReadFile(path)
+	testutil.Ok(t, err)
+	if !bytes.Equal(golden, output) {
+		t.Errorf("output differs from %s, run the tests with -update if the change is expected\nwant:\n%s\ngot:\n%s", path, golden, output)
+	}
+}
+
+// goldenUpstreamConfigs exercises each part of the route table: simple and rewrite routes,
+// extra routes, cluster overrides, upstreams of other clusters, and options set by the file's
+// defaults and by each upstream.
+var goldenUpstreamConfigs = &configtest.File{
+	Defaults: configtest.Options{
+		"allowed_email_domains": []string{"example.com"},
+	},
+	Upstreams: []configtest.Upstream{
+		{
+			Service: "foo",
+			Default: &configtest.Route{
+				From: "foo.{{cluster}}.{{root_domain}}",
+				To:   "foo-internal.{{cluster}}.{{root_domain}}",
+				Options: configtest.Options{
+					"allowed_groups":         []string{"dev"},
+					"header_overrides":       map[string]string{"X-Frame-Options": "DENY"},
+					"inject_request_headers": map[string]string{"x-api-key": "secret"},
+				},
+				ExtraRoutes: []configtest.Route{
+					{From: "foo-api.{{cluster}}.{{root_domain}}", To: "foo-api-internal.{{cluster}}.{{root_domain}}"},
+				},
+			},
+		},
+		{
+			Service: "preview",
+			Default: &configtest.Route{
+				From: `^preview-(.*)\.{{cluster}}\.{{root_domain}}$`,
+				To:   "preview-$1.internal",
+				Type: rewrite,
+				Options: configtest.Options{
+					"allowed_methods":      []string{"get", "post"},
+					"claim_headers":        map[string]string{"employee_id": "X-Employee-Id", "name": ""},
+					"degraded_mode":        degradedDeny,
+					"skip_auth_regex":      []string{"^/healthz$"},
+					"skip_request_signing": true,
+				},
+			},
+		},
+		{
+			Service: "bar",
+			Default: &configtest.Route{
+				From: "bar.{{cluster}}.{{root_domain}}",
+				To:   "bar-internal.{{cluster}}.{{root_domain}}",
+			},
+			Clusters: map[string]configtest.Route{
+				configtest.Cluster: {From: "bar.{{root_domain}}", To: "bar-sso-internal.{{root_domain}}"},
+			},
+		},
+		{
+			Service: "qux",
+			Clusters: map[string]configtest.Route{
+				"prod": {From: "qux.example.com", To: "qux-internal.example.com"},
+			},
+		},
+	},
+}
+
+func TestRouteTableGolden(t *testing.T) {
+	configs, err := loadClusterServiceConfigs(".", goldenUpstreamConfigs.Bytes(), []string{configtest.Cluster},
+		configtest.Scheme, configtest.TemplateVars(), nil)
+	testutil.Ok(t, err)
+
+	routes := make([]*routeEntry, 0, len(configs))
+	for _, config := range configs {
+		proxy, close := testNewOAuthProxy(t, SetUpstreamConfig(config))
+		defer close()
+		routes = append(routes, newRouteEntry(proxy, nil))
+	}
+
+	output, err := json.MarshalIndent(routes, "", "  ")
+	testutil.Ok(t, err)
+	checkGolden(t, "testdata/routes.golden", append(output, '\n'))
+}
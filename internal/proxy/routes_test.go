@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestAdminHandlerRoutes(t *testing.T) {
+	upstreamConfig := &UpstreamConfig{
+		Service:     "wiki",
+		Cluster:     "sso",
+		RouteConfig: RouteConfig{From: "wiki.sso.dev", To: "wiki.internal"},
+		Route: &SimpleRoute{
+			FromURL: &url.URL{Scheme: "https", Host: "wiki.sso.dev"},
+			ToURL:   &url.URL{Scheme: "http", Host: "wiki.internal"},
+		},
+		ProviderSlug:          "idp",
+		AllowedGroups:         []string{"writers"},
+		SkipAuthCompiledRegex: []*regexp.Regexp{regexp.MustCompile("^/health$")},
+		HeaderOverrides:       map[string]string{"X-Frame-Options": "DENY"},
+		InjectRequestHeaders:  map[string]string{"x-api-key": "secret"},
+		ClaimHeaders:          map[string]string{"employee_id": "X-Employee-Id", "name": ""},
+	}
+	proxy, close := testNewOAuthProxy(t, SetUpstreamConfig(upstreamConfig))
+	defer close()
+
+	rewriteConfig := &UpstreamConfig{
+		Service:     "preview",
+		Cluster:     "sso",
+		RouteConfig: RouteConfig{Type: rewrite, From: `^preview-(.*)\.sso\.dev$`, To: "preview-$1.internal"},
+		Route: &RewriteRoute{
+			FromRegex:  regexp.MustCompile(`^preview-(.*)\.sso\.dev$`),
+			ToTemplate: &url.URL{Scheme: "http", Opaque: "preview-$1.internal"},
+		},
+		DegradedMode: degradedDeny,
+	}
+	rewriteProxy, closeRewrite := testNewOAuthProxy(t, SetUpstreamConfig(rewriteConfig))
+	defer closeRewrite()
+
+	handler := newAdminHandler(adminConfig{token: "admin-token", routes: []*routeEntry{
+		newRouteEntry(proxy, nil),
+		newRouteEntry(rewriteProxy, nil),
+	}})
+
+	req := httptest.NewRequest("GET", "http://localhost/admin/routes", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+
+	listing := struct {
+		Routes []routeEntry `json:"routes"`
+	}{}
+	err := json.Unmarshal(rw.Body.Bytes(), &listing)
+	testutil.Assert(t, err == nil, "could not decode route listing: %s", err)
+	testutil.Equal(t, 2, len(listing.Routes))
+
+	wiki := listing.Routes[0]
+	testutil.Equal(t, "wiki", wiki.Service)
+	testutil.Equal(t, simple, wiki.Type)
+	testutil.Equal(t, "https://wiki.sso.dev", wiki.From)
+	testutil.Equal(t, "http://wiki.internal", wiki.To)
+	testutil.Equal(t, "idp", wiki.Provider)
+	testutil.Equal(t, []string{"writers"}, wiki.Policy.AllowedGroups)
+	testutil.Equal(t, []string{"^/health$"}, wiki.Policy.SkipAuthRegex)
+	testutil.Equal(t, degradedAllow, wiki.Policy.DegradedMode)
+	testutil.Equal(t, []string{"X-Api-Key"}, wiki.Headers.Injected)
+	wantClaims := map[string]string{
+		"employee_id": "X-Employee-Id",
+		"given_name":  "X-Forwarded-Given-Name",
+		"family_name": "X-Forwarded-Family-Name",
+	}
+	if !reflect.DeepEqual(wantClaims, wiki.Headers.Claims) {
+		t.Errorf("expected claim headers %v, got %v", wantClaims, wiki.Headers.Claims)
+	}
+	if wiki.Health != nil {
+		t.Errorf("expected no health status for an upstream without health checks")
+	}
+
+	preview := listing.Routes[1]
+	testutil.Equal(t, rewrite, preview.Type)
+	testutil.Equal(t, `^preview-(.*)\.sso\.dev$`, preview.From)
+	testutil.Equal(t, "http://preview-$1.internal", preview.To)
+	testutil.Equal(t, degradedDeny, preview.Policy.DegradedMode)
+
+	req = httptest.NewRequest("POST", "http://localhost/admin/routes", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+}
+
+func TestWriteRouteTable(t *testing.T) {
+	opts := testOptions()
+	testutil.Ok(t, opts.Validate())
+
+	buf := &bytes.Buffer{}
+	testutil.Ok(t, WriteRouteTable(buf, opts, "table"))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	testutil.Equal(t, 2, len(lines))
+	testutil.Equal(t, []string{"SERVICE", "CLUSTER", "TYPE", "FROM", "TO", "PROVIDER", "ALLOWED", "GROUPS"}, strings.Fields(lines[0]))
+	testutil.Equal(t, []string{"foo", "sso", "simple", "http://foo.sso.dev", "http://foo-internal.sso.dev", "idp", "dev"}, strings.Fields(lines[1]))
+
+	buf.Reset()
+	testutil.Ok(t, WriteRouteTable(buf, opts, "json"))
+	listing := struct {
+		Routes []routeEntry `json:"routes"`
+	}{}
+	testutil.Ok(t, json.Unmarshal(buf.Bytes(), &listing))
+	testutil.Equal(t, 1, len(listing.Routes))
+	testutil.Equal(t, "http://foo.sso.dev", listing.Routes[0].From)
+	testutil.Equal(t, false, listing.Routes[0].Headers.Signed)
+
+	testutil.NotEqual(t, nil, WriteRouteTable(buf, opts, "yaml"))
+}
@@ -0,0 +1,297 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+// scimUsersPath is the path of the SCIM 2.0 Users resource identity providers deprovision users
+// through.
+const scimUsersPath = "/oauth2/scim/v2/Users"
+
+const (
+	scimContentType    = "application/scim+json"
+	scimUserSchema     = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimListSchema     = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema    = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimMaxRequestSize = 1 << 20
+)
+
+// ErrUserDeprovisioned is returned when the user of a session was deactivated by the identity
+// provider.
+var ErrUserDeprovisioned = errors.New("user deprovisioned")
+
+// scimUser is a SCIM User, of which only the user name, emails, and whether the user is active
+// are used. Users are identified by their user names, which are their emails.
+type scimUser struct {
+	Schemas  []string    `json:"schemas"`
+	ID       string      `json:"id"`
+	UserName string      `json:"userName"`
+	Active   *bool       `json:"active,omitempty"`
+	Emails   []scimEmail `json:"emails,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// email returns the email of the user, their primary email, or else their user name.
+func (u *scimUser) email() string {
+	for _, email := range u.Emails {
+		if email.Primary && email.Value != "" {
+			return email.Value
+		}
+	}
+	return u.UserName
+}
+
+type scimPatch struct {
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+type scimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// active returns whether a patch activates or deactivates a user, with ok false if it does
+// neither. A patch either replaces the active attribute, or the user with an active attribute.
+func (p *scimPatch) active() (active, ok bool) {
+	for _, op := range p.Operations {
+		if !strings.EqualFold(op.Op, "replace") && !strings.EqualFold(op.Op, "add") {
+			continue
+		}
+		if strings.EqualFold(op.Path, "active") {
+			if err := json.Unmarshal(op.Value, &active); err == nil {
+				return active, true
+			}
+			continue
+		}
+		if op.Path == "" {
+			user := scimUser{}
+			if err := json.Unmarshal(op.Value, &user); err == nil && user.Active != nil {
+				return *user.Active, true
+			}
+		}
+	}
+	return false, false
+}
+
+// deprovisioner denies the sessions of users deactivated by the identity provider, and revokes
+// their bearer tokens, until the sessions would have been found invalid by the provider
+// anyway. Identity providers deactivate users through a SCIM 2.0 Users resource.
+type deprovisioner struct {
+	token        string
+	ttl          time.Duration
+	deviceTokens *deviceTokenStore
+	events       *eventNotifier
+
+	mu     sync.Mutex
+	denied map[string]time.Time
+}
+
+// newDeprovisioner returns the deprovisioner of SCIM_TOKEN, or nil without it.
+func newDeprovisioner(opts *Options, deviceTokens *deviceTokenStore, events *eventNotifier) *deprovisioner {
+	if opts.SCIMToken == "" {
+		return nil
+	}
+	return &deprovisioner{
+		token:        opts.SCIMToken,
+		ttl:          opts.DeprovisionDenyTTL,
+		deviceTokens: deviceTokens,
+		events:       events,
+		denied:       make(map[string]time.Time),
+	}
+}
+
+// SetDeprovisioner sets the deprovisioner denying the sessions of deactivated users.
+func SetDeprovisioner(d *deprovisioner) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.deprovisioner = d
+		return nil
+	}
+}
+
+// deprovision denies the sessions of a user, revoking their bearer tokens, returning the number
+// of tokens revoked.
+func (d *deprovisioner) deprovision(email string, now time.Time) int {
+	d.mu.Lock()
+	for denied, expiry := range d.denied {
+		if !now.Before(expiry) {
+			delete(d.denied, denied)
+		}
+	}
+	d.denied[strings.ToLower(email)] = now.Add(d.ttl)
+	d.mu.Unlock()
+
+	revoked := 0
+	if d.deviceTokens != nil {
+		revoked = d.deviceTokens.revokeUser(email)
+	}
+	d.events.notify(lifecycleEvent{
+		Event:  eventSessionRevoked,
+		Email:  email,
+		Reason: "user deprovisioned by the identity provider",
+	})
+	return revoked
+}
+
+// reprovision allows the sessions of a user reactivated by the identity provider again.
+func (d *deprovisioner) reprovision(email string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.denied, strings.ToLower(email))
+}
+
+// isDenied returns true if the sessions of a user are denied. A nil deprovisioner denies none.
+func (d *deprovisioner) isDenied(email string, now time.Time) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	expiry, ok := d.denied[strings.ToLower(email)]
+	return ok && now.Before(expiry)
+}
+
+// handler serves the SCIM Users resource on scimUsersPath, and every other request with next.
+func (d *deprovisioner) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != scimUsersPath && !strings.HasPrefix(req.URL.Path, scimUsersPath+"/") {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		if !d.authorized(req) {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			writeSCIMError(rw, http.StatusUnauthorized, "invalid SCIM token")
+			return
+		}
+		d.serveUsers(rw, req)
+	})
+}
+
+func (d *deprovisioner) authorized(req *http.Request) bool {
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(parts[1])), []byte(d.token)) == 1
+}
+
+// serveUsers serves the SCIM Users resource. Users aren't stored, so no user is ever found by
+// a query, and creating a user only echoes it, with the email it's identified by as its ID, so
+// that later requests for the user name it by its email even if its user name differs. Users are
+// deprovisioned when they're replaced or patched with an active attribute of false, or deleted,
+// and reprovisioned when they're replaced or patched with an active attribute of true.
+func (d *deprovisioner) serveUsers(rw http.ResponseWriter, req *http.Request) {
+	logger := requestLogEntry(req)
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, scimUsersPath), "/")
+	body := http.MaxBytesReader(rw, req.Body, scimMaxRequestSize)
+
+	switch {
+	case req.Method == http.MethodGet && id == "":
+		writeSCIM(rw, http.StatusOK, struct {
+			Schemas      []string   `json:"schemas"`
+			TotalResults int        `json:"totalResults"`
+			Resources    []scimUser `json:"Resources"`
+		}{
+			Schemas:   []string{scimListSchema},
+			Resources: []scimUser{},
+		})
+	case req.Method == http.MethodGet:
+		writeSCIMError(rw, http.StatusNotFound, "user not found")
+	case req.Method == http.MethodPost && id == "":
+		user := scimUser{}
+		if err := json.NewDecoder(body).Decode(&user); err != nil || user.email() == "" {
+			writeSCIMError(rw, http.StatusBadRequest, "invalid user")
+			return
+		}
+		user.Schemas = []string{scimUserSchema}
+		user.ID = user.email()
+		d.apply(logger, user.email(), user.Active)
+		writeSCIM(rw, http.StatusCreated, user)
+	case req.Method == http.MethodPut && id != "":
+		user := scimUser{}
+		if err := json.NewDecoder(body).Decode(&user); err != nil {
+			writeSCIMError(rw, http.StatusBadRequest, "invalid user")
+			return
+		}
+		if user.email() == "" {
+			user.UserName = id
+		}
+		user.Schemas = []string{scimUserSchema}
+		user.ID = id
+		d.apply(logger, user.email(), user.Active)
+		writeSCIM(rw, http.StatusOK, user)
+	case req.Method == http.MethodPatch && id != "":
+		patch := scimPatch{}
+		if err := json.NewDecoder(body).Decode(&patch); err != nil {
+			writeSCIMError(rw, http.StatusBadRequest, "invalid patch")
+			return
+		}
+		user := scimUser{Schemas: []string{scimUserSchema}, ID: id, UserName: id}
+		if active, ok := patch.active(); ok {
+			user.Active = &active
+			d.apply(logger, id, user.Active)
+		}
+		writeSCIM(rw, http.StatusOK, user)
+	case req.Method == http.MethodDelete && id != "":
+		inactive := false
+		d.apply(logger, id, &inactive)
+		rw.WriteHeader(http.StatusNoContent)
+	default:
+		writeSCIMError(rw, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// apply deprovisions or reprovisions a user, if active is set.
+func (d *deprovisioner) apply(logger *log.LogEntry, email string, active *bool) {
+	switch {
+	case active == nil:
+		return
+	case *active:
+		d.reprovision(email)
+		logger.WithUser(email).Info("scim: user reprovisioned")
+	default:
+		revoked := d.deprovision(email, time.Now())
+		logger.WithUser(email).Info("scim: user deprovisioned, denying their sessions and revoking bearer tokens: ", revoked)
+	}
+}
+
+func writeSCIM(rw http.ResponseWriter, code int, v interface{}) {
+	rw.Header().Set("Content-Type", scimContentType)
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(v)
+}
+
+func writeSCIMError(rw http.ResponseWriter, code int, detail string) {
+	writeSCIM(rw, code, struct {
+		Schemas []string `json:"schemas"`
+		Status  string   `json:"status"`
+		Detail  string   `json:"detail"`
+	}{
+		Schemas: []string{scimErrorSchema},
+		Status:  http.StatusText(code),
+		Detail:  detail,
+	})
+}
+
+// validateSCIMOptions validates how long deprovisioned users are denied.
+func validateSCIMOptions(o *Options, msgs []string) []string {
+	if o.SCIMToken == "" {
+		return msgs
+	}
+	if o.DeprovisionDenyTTL <= 0 {
+		msgs = append(msgs, "invalid value for deprovision-deny-ttl: must be positive")
+	}
+	return msgs
+}
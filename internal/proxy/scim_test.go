@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func testDeprovisioner(t *testing.T, deviceTokens *deviceTokenStore) *deprovisioner {
+	opts := NewOptions()
+	opts.SCIMToken = "scim-token"
+	d := newDeprovisioner(opts, deviceTokens, nil)
+	testutil.Assert(t, d != nil, "expected a deprovisioner with a scim token")
+	return d
+}
+
+func TestDeprovisionerDenies(t *testing.T) {
+	testutil.Assert(t, newDeprovisioner(NewOptions(), nil, nil) == nil, "expected no deprovisioner without a scim token")
+	var disabled *deprovisioner
+	testutil.Assert(t, !disabled.isDenied("user@example.com", time.Now()), "expected a nil deprovisioner to deny no one")
+
+	store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+	rawToken, _ := testIssueBearerToken(t, store, "user@example.com")
+	d := testDeprovisioner(t, store)
+
+	now := time.Now()
+	testutil.Equal(t, 1, d.deprovision("User@Example.com", now))
+	testutil.Assert(t, d.isDenied("user@example.com", now), "expected deprovisioned user to be denied")
+	testutil.Assert(t, !d.isDenied("other@example.com", now), "expected other users to be allowed")
+	_, ok := store.lookup(rawToken, "localhost")
+	testutil.Assert(t, !ok, "expected bearer tokens of deprovisioned user to be revoked")
+
+	// users are denied until the deny ttl passes, or they're reprovisioned
+	testutil.Assert(t, !d.isDenied("user@example.com", now.Add(d.ttl)), "expected denial to expire")
+	d.reprovision("user@example.com")
+	testutil.Assert(t, !d.isDenied("user@example.com", now), "expected reprovisioned user to be allowed")
+}
+
+func TestDeprovisionerSCIM(t *testing.T) {
+	d := testDeprovisioner(t, nil)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	handler := d.handler(next)
+
+	serve := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	testCases := []struct {
+		name         string
+		method       string
+		target       string
+		token        string
+		body         string
+		expectedCode int
+		denied       bool
+	}{
+		{"other paths are served by the upstreams", "GET", "https://sso.dev/oauth2/scim/v2/Groups", "", "",
+			http.StatusTeapot, false},
+		{"token required", "PATCH", "https://sso.dev/oauth2/scim/v2/Users/user@example.com", "wrong-token",
+			`{"Operations":[{"op":"replace","path":"active","value":false}]}`, http.StatusUnauthorized, false},
+		{"users are never found", "GET", "https://sso.dev/oauth2/scim/v2/Users?filter=userName+eq+%22user@example.com%22", "scim-token", "",
+			http.StatusOK, false},
+		{"creating a user", "POST", "https://sso.dev/oauth2/scim/v2/Users", "scim-token",
+			`{"userName":"user@example.com","active":true}`, http.StatusCreated, false},
+		{"patching active", "PATCH", "https://sso.dev/oauth2/scim/v2/Users/user@example.com", "scim-token",
+			`{"Operations":[{"op":"replace","path":"active","value":false}]}`, http.StatusOK, true},
+		{"patching the user", "PATCH", "https://sso.dev/oauth2/scim/v2/Users/user@example.com", "scim-token",
+			`{"Operations":[{"op":"replace","value":{"active":false}}]}`, http.StatusOK, true},
+		{"patching other attributes", "PATCH", "https://sso.dev/oauth2/scim/v2/Users/user@example.com", "scim-token",
+			`{"Operations":[{"op":"replace","path":"displayName","value":"User"}]}`, http.StatusOK, false},
+		{"replacing the user", "PUT", "https://sso.dev/oauth2/scim/v2/Users/user@example.com", "scim-token",
+			`{"userName":"user@example.com","active":false}`, http.StatusOK, true},
+		{"deleting the user", "DELETE", "https://sso.dev/oauth2/scim/v2/Users/user@example.com", "scim-token", "",
+			http.StatusNoContent, true},
+		{"invalid patch", "PATCH", "https://sso.dev/oauth2/scim/v2/Users/user@example.com", "scim-token", "{",
+			http.StatusBadRequest, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d.reprovision("user@example.com")
+			rw := serve(tc.method, tc.target, tc.token, tc.body)
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+			testutil.Equal(t, tc.denied, d.isDenied("user@example.com", time.Now()))
+		})
+	}
+
+	// reactivating a user allows them again
+	d.deprovision("user@example.com", time.Now())
+	rw := serve("PUT", "https://sso.dev/oauth2/scim/v2/Users/user@example.com", "scim-token",
+		`{"userName":"user@example.com","active":true}`)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, scimContentType, rw.Header().Get("Content-Type"))
+	user := scimUser{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &user))
+	testutil.Equal(t, "user@example.com", user.ID)
+	testutil.Assert(t, !d.isDenied("user@example.com", time.Now()), "expected reactivated user to be allowed")
+
+	// users whose user name isn't their email are deactivated by the ID they were created with
+	rw = serve("POST", "https://sso.dev/oauth2/scim/v2/Users", "scim-token",
+		`{"userName":"user@corp.onmicrosoft.com","emails":[{"value":"user@example.com","primary":true}],"active":true}`)
+	testutil.Equal(t, http.StatusCreated, rw.Code)
+	user = scimUser{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &user))
+	testutil.Equal(t, "user@example.com", user.ID)
+	rw = serve("PATCH", "https://sso.dev/oauth2/scim/v2/Users/"+user.ID, "scim-token",
+		`{"Operations":[{"op":"replace","path":"active","value":false}]}`)
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Assert(t, d.isDenied("user@example.com", time.Now()), "expected deactivated user to be denied")
+}
+
+func TestProxyDeniesDeprovisionedUsers(t *testing.T) {
+	d := testDeprovisioner(t, nil)
+	proxy, close := testNewOAuthProxy(t, SetDeprovisioner(d))
+	defer close()
+	proxy.handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	rw := httptest.NewRecorder()
+	proxy.Proxy(rw, httptest.NewRequest("GET", "https://localhost/", nil))
+	testutil.Equal(t, http.StatusOK, rw.Code)
+
+	d.deprovision(testSession().Email, time.Now())
+	rw = httptest.NewRecorder()
+	proxy.Proxy(rw, httptest.NewRequest("GET", "https://localhost/", nil))
+	testutil.Equal(t, http.StatusForbidden, rw.Code)
+}
+
+func TestValidateSCIMOptions(t *testing.T) {
+	o := testOptions()
+	o.SCIMToken = "scim-token"
+	testutil.Equal(t, []string(nil), validateSCIMOptions(o, nil))
+
+	o.DeprovisionDenyTTL = 0
+	testutil.Equal(t, []string{"invalid value for deprovision-deny-ttl: must be positive"}, validateSCIMOptions(o, nil))
+}
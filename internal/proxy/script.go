@@ -0,0 +1,468 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	defaultScriptTimeout        = 5 * time.Millisecond
+	maxScriptTimeout            = 100 * time.Millisecond
+	defaultScriptMaxConcurrency = 16
+	maxScriptSize               = 64 * 1024
+
+	// scriptCallStackSize and scriptRegistrySize bound the stack of a script's Lua state, limiting
+	// how deeply it can recurse and how many values it can hold on its stack.
+	scriptCallStackSize = 64
+	scriptRegistrySize  = 16 * 1024
+)
+
+// unsafeScriptGlobals are the functions of Lua's base library removed from scripts, as they
+// load code, touch the filesystem, or reach outside of the script's own state.
+var unsafeScriptGlobals = []string{
+	"collectgarbage",
+	"dofile",
+	"getfenv",
+	"load",
+	"loadfile",
+	"loadstring",
+	"module",
+	"newproxy",
+	"print",
+	"require",
+	"setfenv",
+	"_printregs",
+}
+
+var (
+	errScriptBusy    = errors.New("script is at its max_concurrency")
+	errScriptTimeout = errors.New("script exceeded its timeout")
+)
+
+// ScriptConfig maps to the script yaml config fields of an upstream:
+// * source - the Lua source of the script, defining an on_request function, an on_response function, or both
+// * timeout - the time each call of the script may run for, defaults to 5ms, up to 100ms
+// * max_concurrency - the number of requests the script may run for at once, defaults to 16
+type ScriptConfig struct {
+	Source         string        `yaml:"source"`
+	Timeout        time.Duration `yaml:"timeout"`
+	MaxConcurrency int           `yaml:"max_concurrency"`
+
+	proto      *lua.FunctionProto
+	onRequest  bool
+	onResponse bool
+}
+
+func (c *ScriptConfig) validate() error {
+	if c.Source == "" {
+		return fmt.Errorf("source is required")
+	}
+	if len(c.Source) > maxScriptSize {
+		return fmt.Errorf("source must be at most %d bytes", maxScriptSize)
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = defaultScriptTimeout
+	}
+	if c.Timeout < 0 || c.Timeout > maxScriptTimeout {
+		return fmt.Errorf("timeout must be between 0 and %s", maxScriptTimeout)
+	}
+	if c.MaxConcurrency == 0 {
+		c.MaxConcurrency = defaultScriptMaxConcurrency
+	}
+	if c.MaxConcurrency < 0 {
+		return fmt.Errorf("max_concurrency must be positive")
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(c.Source), "script")
+	if err != nil {
+		return err
+	}
+	c.proto, err = lua.Compile(chunk, "script")
+	if err != nil {
+		return err
+	}
+
+	// the script is run once, so that errors in its top level are reported when it's loaded
+	L, err := c.newState()
+	if err != nil {
+		return err
+	}
+	defer L.Close()
+	c.onRequest = L.GetGlobal("on_request").Type() == lua.LTFunction
+	c.onResponse = L.GetGlobal("on_response").Type() == lua.LTFunction
+	if !c.onRequest && !c.onResponse {
+		return fmt.Errorf("source must define an on_request or on_response function")
+	}
+	return nil
+}
+
+// newState returns a Lua state the script has been run in, defining its functions. Only the
+// base, table, string, and math libraries are available, without their unsafe functions.
+func (c *ScriptConfig) newState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:  true,
+		CallStackSize: scriptCallStackSize,
+		RegistrySize:  scriptRegistrySize,
+	})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range unsafeScriptGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+	// string.rep would let a script allocate a huge string with a single call
+	if str, ok := L.GetGlobal("string").(*lua.LTable); ok {
+		str.RawSetString("rep", lua.LNil)
+		str.RawSetString("dump", lua.LNil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(c.proto))
+	err := L.PCall(0, 0, nil)
+	L.RemoveContext()
+	if err != nil {
+		L.Close()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, errScriptTimeout
+		}
+		return nil, err
+	}
+	return L, nil
+}
+
+// scriptHandler runs the script of an upstream on its requests and responses.
+type scriptHandler struct {
+	handler http.Handler
+	config  *ScriptConfig
+	service string
+
+	// states holds a slot for each request the script may run for at once, with the idle Lua
+	// state of the slot, or nil if one hasn't been created yet
+	states chan *lua.LState
+
+	StatsdClient *statsd.Client
+}
+
+// newScriptHandler wraps the handler of an upstream, running its script.
+func newScriptHandler(handler http.Handler, config *UpstreamConfig, statsdClient *statsd.Client) http.Handler {
+	states := make(chan *lua.LState, config.Script.MaxConcurrency)
+	for i := 0; i < config.Script.MaxConcurrency; i++ {
+		states <- nil
+	}
+	return &scriptHandler{
+		handler:      handler,
+		config:       config.Script,
+		service:      config.Service,
+		states:       states,
+		StatsdClient: statsdClient,
+	}
+}
+
+// call calls a function of the script with a table built by build, passing its results to read
+// while the script's state is held, so that the script can't change the table concurrently.
+func (s *scriptHandler) call(ctx context.Context, fn string, build func(*lua.LState) *lua.LTable,
+	read func(t *lua.LTable, results []lua.LValue) error) error {
+	timer := time.NewTimer(s.config.Timeout)
+	defer timer.Stop()
+	var L *lua.LState
+	select {
+	case L = <-s.states:
+	case <-timer.C:
+		return errScriptBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if L == nil {
+		var err error
+		L, err = s.config.newState()
+		if err != nil {
+			s.states <- nil
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	t := build(L)
+	err := L.CallByParam(lua.P{Fn: L.GetGlobal(fn), NRet: 2, Protect: true}, t)
+	L.RemoveContext()
+	if err != nil {
+		// a state whose script failed part of the way through may have been left inconsistent,
+		// so it's replaced
+		L.Close()
+		s.states <- nil
+		if ctx.Err() == context.DeadlineExceeded {
+			return errScriptTimeout
+		}
+		return err
+	}
+	results := []lua.LValue{L.Get(-2), L.Get(-1)}
+	L.Pop(2)
+	err = read(t, results)
+	s.states <- L
+	return err
+}
+
+func (s *scriptHandler) incr(hook, result string) {
+	s.StatsdClient.Incr("upstream.script", []string{"service:" + s.service, "hook:" + hook, "result:" + result}, 1.0)
+}
+
+// fail counts and logs an error of the script, responding with an error in its place.
+func (s *scriptHandler) fail(rw http.ResponseWriter, req *http.Request, hook string, err error) {
+	result := "error"
+	switch err {
+	case errScriptBusy:
+		result = "busy"
+	case errScriptTimeout:
+		result = "timeout"
+	}
+	s.incr(hook, result)
+	log.NewLogEntry().
+		WithServiceName(s.service).
+		WithRequestID(requestID(req)).
+		WithRequestMethod(req.Method).
+		WithRequestHost(req.Host).
+		WithRequestURI(req.URL.Path).
+		WithError(err).
+		Error("error running script of upstream")
+	http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *scriptHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if s.config.onRequest {
+		responded, err := s.onRequest(rw, req)
+		if err != nil {
+			s.fail(rw, req, "on_request", err)
+			return
+		}
+		if responded {
+			s.incr("on_request", "responded")
+			return
+		}
+		s.incr("on_request", "ok")
+	}
+
+	if s.config.onResponse {
+		rw = &scriptResponseWriter{ResponseWriter: rw, script: s, req: req}
+	}
+	s.handler.ServeHTTP(rw, req)
+}
+
+// onRequest runs the script's on_request function, applying its changes to the request, and
+// responding if it returns a status code.
+func (s *scriptHandler) onRequest(rw http.ResponseWriter, req *http.Request) (bool, error) {
+	var status int
+	var body string
+	err := s.call(req.Context(), "on_request", func(L *lua.LState) *lua.LTable {
+		t := L.NewTable()
+		t.RawSetString("method", lua.LString(req.Method))
+		t.RawSetString("host", lua.LString(req.Host))
+		t.RawSetString("path", lua.LString(req.URL.Path))
+		t.RawSetString("query", lua.LString(req.URL.RawQuery))
+		t.RawSetString("headers", headerTable(L, req.Header))
+		// requests that skip authentication have no identity, whatever headers they're sent with
+		identity, _ := forwardedIdentity(req.Context())
+		t.RawSetString("user", lua.LString(identity.User))
+		t.RawSetString("email", lua.LString(identity.Email))
+		groups := L.NewTable()
+		for _, group := range identity.Groups {
+			groups.Append(lua.LString(group))
+		}
+		t.RawSetString("groups", groups)
+		return t
+	}, func(t *lua.LTable, results []lua.LValue) error {
+		if n, ok := results[0].(lua.LNumber); ok {
+			status = int(n)
+			if status < 100 || status > 599 {
+				return fmt.Errorf("on_request returned invalid status %d", status)
+			}
+			if results[1] != lua.LNil {
+				body = results[1].String()
+			}
+			return nil
+		}
+
+		path, ok := t.RawGetString("path").(lua.LString)
+		if !ok || !strings.HasPrefix(string(path), "/") {
+			return fmt.Errorf("on_request set an invalid path")
+		}
+		query, ok := t.RawGetString("query").(lua.LString)
+		if !ok {
+			return fmt.Errorf("on_request set an invalid query")
+		}
+		if err := applyHeaderTable(t.RawGetString("headers"), req.Header); err != nil {
+			return fmt.Errorf("on_request set invalid headers: %s", err)
+		}
+		if string(path) != req.URL.Path {
+			req.URL.Path = string(path)
+			req.URL.RawPath = ""
+		}
+		req.URL.RawQuery = string(query)
+		return nil
+	})
+	if err != nil || status == 0 {
+		return false, err
+	}
+
+	if body == "" {
+		body = http.StatusText(status)
+	}
+	http.Error(rw, body, status)
+	return true, nil
+}
+
+// headerTable returns a table of headers, by their canonical names, with the values of headers
+// set more than once joined by commas.
+func headerTable(L *lua.LState, header http.Header) *lua.LTable {
+	t := L.NewTable()
+	for name, values := range header {
+		t.RawSetString(name, lua.LString(strings.Join(values, ", ")))
+	}
+	return t
+}
+
+// applyHeaderTable applies the changes a script made to a table of headers: headers it removed
+// are deleted, and those it added or changed are set.
+func applyHeaderTable(v lua.LValue, header http.Header) error {
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return fmt.Errorf("headers must be a table")
+	}
+
+	headers := make(map[string]string)
+	var err error
+	t.ForEach(func(k, v lua.LValue) {
+		name, ok := k.(lua.LString)
+		if !ok || !httpguts.ValidHeaderFieldName(string(name)) {
+			err = fmt.Errorf("invalid header name %s", k)
+			return
+		}
+		if v.Type() != lua.LTString && v.Type() != lua.LTNumber {
+			err = fmt.Errorf("invalid value of header %s", name)
+			return
+		}
+		value := v.String()
+		if !httpguts.ValidHeaderFieldValue(value) {
+			err = fmt.Errorf("invalid value of header %s", name)
+			return
+		}
+		headers[http.CanonicalHeaderKey(string(name))] = value
+	})
+	if err != nil {
+		return err
+	}
+
+	for name := range header {
+		if _, ok := headers[name]; !ok {
+			header.Del(name)
+		}
+	}
+	for name, value := range headers {
+		if strings.Join(header[name], ", ") != value {
+			header.Set(name, value)
+		}
+	}
+	return nil
+}
+
+// scriptResponseWriter is a wrapper of http.ResponseWriter running the script's on_response
+// function before the response's headers are written. Responses the script fails on are
+// replaced by an error.
+type scriptResponseWriter struct {
+	http.ResponseWriter
+	script      *scriptHandler
+	req         *http.Request
+	wroteHeader bool
+	failed      bool
+}
+
+func (w *scriptResponseWriter) onResponse(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.ResponseWriter.Header()
+	err := w.script.call(w.req.Context(), "on_response", func(L *lua.LState) *lua.LTable {
+		t := L.NewTable()
+		t.RawSetString("method", lua.LString(w.req.Method))
+		t.RawSetString("host", lua.LString(w.req.Host))
+		t.RawSetString("path", lua.LString(w.req.URL.Path))
+		t.RawSetString("status", lua.LNumber(status))
+		t.RawSetString("headers", headerTable(L, header))
+		return t
+	}, func(t *lua.LTable, results []lua.LValue) error {
+		if err := applyHeaderTable(t.RawGetString("headers"), header); err != nil {
+			return fmt.Errorf("on_response set invalid headers: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		w.failed = true
+		for name := range header {
+			header.Del(name)
+		}
+		w.script.fail(w.ResponseWriter, w.req, "on_response", err)
+		return
+	}
+	w.script.incr("on_response", "ok")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *scriptResponseWriter) WriteHeader(status int) {
+	w.onResponse(status)
+}
+
+func (w *scriptResponseWriter) Write(b []byte) (int, error) {
+	w.onResponse(http.StatusOK)
+	if w.failed {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Support Websockets
+func (w *scriptResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hij, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hij.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker is not available on writer")
+}
+
+func (w *scriptResponseWriter) Flush() {
+	w.onResponse(http.StatusOK)
+	if w.failed {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
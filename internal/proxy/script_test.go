@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/pkg/middleware"
+)
+
+func TestScriptConfigValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  *ScriptConfig
+		wantErr string
+	}{
+		{
+			name:    "without source",
+			config:  &ScriptConfig{},
+			wantErr: "source is required",
+		},
+		{
+			name:    "without hooks",
+			config:  &ScriptConfig{Source: `x = 1`},
+			wantErr: "source must define an on_request or on_response function",
+		},
+		{
+			name:    "timeout too long",
+			config:  &ScriptConfig{Source: `function on_request(req) end`, Timeout: time.Second},
+			wantErr: "timeout must be between 0 and 100ms",
+		},
+		{
+			name:    "syntax error",
+			config:  &ScriptConfig{Source: `function on_request(req`},
+			wantErr: "script",
+		},
+		{
+			name:    "top level never finishes",
+			config:  &ScriptConfig{Source: `while true do end`},
+			wantErr: "script exceeded its timeout",
+		},
+		{
+			name:    "unsafe functions removed",
+			config:  &ScriptConfig{Source: `dofile("/etc/passwd")`},
+			wantErr: "script",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.validate()
+			testutil.NotEqual(t, nil, err)
+			testutil.Assert(t, strings.HasPrefix(err.Error(), tc.wantErr), "expected error %q, got %q", tc.wantErr, err)
+		})
+	}
+
+	config := &ScriptConfig{Source: `function on_response(res) end`}
+	testutil.Ok(t, config.validate())
+	testutil.Equal(t, defaultScriptTimeout, config.Timeout)
+	testutil.Equal(t, defaultScriptMaxConcurrency, config.MaxConcurrency)
+	testutil.Assert(t, !config.onRequest && config.onResponse, "expected only an on_response hook")
+}
+
+func testScriptHandler(t *testing.T, source string, upstream http.HandlerFunc) http.Handler {
+	config := &ScriptConfig{Source: source, Timeout: 50 * time.Millisecond}
+	testutil.Ok(t, config.validate())
+	return newScriptHandler(upstream, &UpstreamConfig{Service: "foo", Script: config}, nil)
+}
+
+func TestScriptOnRequest(t *testing.T) {
+	var got *http.Request
+	handler := testScriptHandler(t, `
+function on_request(req)
+  if req.headers["X-Beta"] == "1" then
+    req.path = "/beta" .. req.path
+  end
+  req.headers["X-Quota"] = tonumber(req.headers["X-Quota"]) * 2
+  req.headers["X-Groups"] = table.concat(req.groups, ";")
+  req.headers["X-Remove"] = nil
+  req.query = req.query .. "&via=script"
+end
+`, func(rw http.ResponseWriter, req *http.Request) {
+		got = req
+	})
+
+	req := httptest.NewRequest("GET", "http://foo.sso.dev/reports?page=2", nil)
+	req.Header.Set("X-Beta", "1")
+	req.Header.Set("X-Quota", "21")
+	req.Header.Set("X-Remove", "secret")
+	req = req.WithContext(NewSessionContext(req.Context()))
+	setForwardedIdentity(req, middleware.Identity{Email: "user@example.com", Groups: []string{"admins", "engineers"}})
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, "/beta/reports", got.URL.Path)
+	testutil.Equal(t, "page=2&via=script", got.URL.RawQuery)
+	testutil.Equal(t, "42", got.Header.Get("X-Quota"))
+	testutil.Equal(t, "admins;engineers", got.Header.Get("X-Groups"))
+	testutil.Equal(t, "", got.Header.Get("X-Remove"))
+	testutil.Equal(t, "1", got.Header.Get("X-Beta"))
+}
+
+func TestScriptOnRequestResponds(t *testing.T) {
+	handler := testScriptHandler(t, `
+function on_request(req)
+  if req.email == "" then
+    return 403, "email required"
+  end
+end
+`, func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "http://foo.sso.dev/", nil))
+	testutil.Equal(t, http.StatusForbidden, rw.Code)
+	testutil.Equal(t, "email required\n", rw.Body.String())
+
+	// the identity of requests that skip authentication can't be forged with headers
+	req := httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+	req = req.WithContext(NewSessionContext(req.Context()))
+	req.Header.Set("X-Forwarded-Email", "spoofed@example.com")
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusForbidden, rw.Code)
+
+	req = httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+	req = req.WithContext(NewSessionContext(req.Context()))
+	setForwardedIdentity(req, middleware.Identity{Email: "user@example.com"})
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusTeapot, rw.Code)
+}
+
+func TestScriptOnResponse(t *testing.T) {
+	handler := testScriptHandler(t, `
+function on_response(res)
+  if res.status >= 500 then
+    res.headers["Cache-Control"] = "no-store"
+  end
+  res.headers["Server"] = nil
+end
+`, func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Server", "internal/1.2.3")
+		rw.WriteHeader(http.StatusBadGateway)
+		rw.Write([]byte("upstream error"))
+	})
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "http://foo.sso.dev/", nil))
+	testutil.Equal(t, http.StatusBadGateway, rw.Code)
+	testutil.Equal(t, "no-store", rw.Header().Get("Cache-Control"))
+	testutil.Equal(t, "", rw.Header().Get("Server"))
+	testutil.Equal(t, "upstream error", rw.Body.String())
+}
+
+func TestScriptErrors(t *testing.T) {
+	upstream := func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("upstream"))
+	}
+	testCases := []struct {
+		name   string
+		source string
+	}{
+		{"runtime error", `function on_request(req) error("failed") end`},
+		{"timeout", `function on_request(req) while true do end end`},
+		{"invalid header", `function on_request(req) req.headers["X-Injected"] = "a\r\nb" end`},
+		{"invalid path", `function on_request(req) req.path = "reports" end`},
+		{"invalid status", `function on_request(req) return 42 end`},
+		{"string.rep removed", `function on_request(req) req.headers["X-Big"] = string.rep("a", 1e9) end`},
+		{"response error", `function on_response(res) error("failed") end`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := testScriptHandler(t, tc.source, upstream)
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, httptest.NewRequest("GET", "http://foo.sso.dev/", nil))
+			testutil.Equal(t, http.StatusInternalServerError, rw.Code)
+			testutil.Equal(t, "Internal Server Error\n", rw.Body.String())
+		})
+	}
+}
+
+func TestScriptRecoversFromErrors(t *testing.T) {
+	// the script fails on every other request, which mustn't break the requests after
+	config := &ScriptConfig{MaxConcurrency: 1, Source: `
+calls = 0
+function on_request(req)
+  calls = calls + 1
+  if req.headers["X-Fail"] then
+    error("failed")
+  end
+  req.headers["X-Calls"] = calls
+end
+`}
+	testutil.Ok(t, config.validate())
+	handler := newScriptHandler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(req.Header.Get("X-Calls")))
+	}), &UpstreamConfig{Service: "foo", Script: config}, nil)
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "http://foo.sso.dev/", nil)
+		if i%2 == 0 {
+			req.Header.Set("X-Fail", "1")
+		}
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if i%2 == 0 {
+			testutil.Equal(t, http.StatusInternalServerError, rw.Code)
+			continue
+		}
+		testutil.Equal(t, http.StatusOK, rw.Code)
+		// the failed state was replaced, running the script's top level again
+		testutil.Equal(t, "1", rw.Body.String())
+	}
+}
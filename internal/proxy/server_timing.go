@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const serverTimingHeader = "Server-Timing"
+
+type serverTimingKey struct{}
+
+// serverTiming records when each phase of a proxied request began, so that their durations can
+// be reported to the client in a Server-Timing header.
+type serverTiming struct {
+	start    time.Time
+	auth     time.Time
+	upstream time.Time
+}
+
+// withServerTiming is middleware recording when each request arrived, which marks it to have
+// its Server-Timing header written by the upstream it's proxied to.
+func withServerTiming(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		timing := &serverTiming{start: time.Now()}
+		h.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), serverTimingKey{}, timing)))
+	})
+}
+
+// requestServerTiming returns the timing of a request, or nil if it isn't timed.
+func requestServerTiming(req *http.Request) *serverTiming {
+	timing, _ := req.Context().Value(serverTimingKey{}).(*serverTiming)
+	return timing
+}
+
+// header returns the Server-Timing header of the phases of a request: routing, from when it
+// arrived until it reached its upstream's route, auth, until it was authenticated, and upstream,
+// until the upstream's response headers were written, which includes any time it waited to be
+// scheduled.
+func (t *serverTiming) header(end time.Time) string {
+	return fmt.Sprintf("routing;dur=%s, auth;dur=%s, upstream;dur=%s",
+		milliseconds(t.auth.Sub(t.start)), milliseconds(t.upstream.Sub(t.auth)), milliseconds(end.Sub(t.upstream)))
+}
+
+// milliseconds formats a duration as Server-Timing durations are, in milliseconds.
+func milliseconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
+
+// serverTimingWriter is a wrapper of http.ResponseWriter adding the Server-Timing header of a
+// request to its response, alongside any of the upstream's own.
+type serverTimingWriter struct {
+	http.ResponseWriter
+	timing      *serverTiming
+	wroteHeader bool
+}
+
+func (w *serverTimingWriter) writeTiming() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.Header().Add(serverTimingHeader, w.timing.header(time.Now()))
+}
+
+func (w *serverTimingWriter) WriteHeader(status int) {
+	w.writeTiming()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *serverTimingWriter) Write(b []byte) (int, error) {
+	w.writeTiming()
+	return w.ResponseWriter.Write(b)
+}
+
+// Support Websockets
+func (w *serverTimingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hij, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hij.Hijack()
+	}
+	return nil, nil, errors.New("http.Hijacker is not available on writer")
+}
+
+func (w *serverTimingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+var serverTimingPattern = regexp.MustCompile(`^routing;dur=\d+\.\d{3}, auth;dur=\d+\.\d{3}, upstream;dur=\d+\.\d{3}$`)
+
+func TestServerTimingHeader(t *testing.T) {
+	start := time.Now()
+	timing := &serverTiming{
+		start:    start,
+		auth:     start.Add(1500 * time.Microsecond),
+		upstream: start.Add(4 * time.Millisecond),
+	}
+	testutil.Equal(t, "routing;dur=1.500, auth;dur=2.500, upstream;dur=20.250",
+		timing.header(start.Add(24250*time.Microsecond)))
+}
+
+func TestServerTimingProxy(t *testing.T) {
+	proxy, close := testNewOAuthProxy(t)
+	defer close()
+
+	testCases := []struct {
+		name    string
+		handler http.Handler
+		timed   bool
+	}{
+		{"timed", withServerTiming(http.HandlerFunc(proxy.Proxy)), true},
+		{"not timed", http.HandlerFunc(proxy.Proxy), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "https://localhost/status/200", nil)
+			rw := httptest.NewRecorder()
+			tc.handler.ServeHTTP(rw, req)
+
+			testutil.Equal(t, http.StatusOK, rw.Code)
+			header := rw.Header().Get(serverTimingHeader)
+			if tc.timed {
+				testutil.Assert(t, serverTimingPattern.MatchString(header), "unexpected Server-Timing header %q", header)
+			} else {
+				testutil.Equal(t, "", header)
+			}
+		})
+	}
+}
+
+func TestServerTimingWriterKeepsUpstreamTimings(t *testing.T) {
+	upstream := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set(serverTimingHeader, "db;dur=12")
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("created"))
+	})
+
+	now := time.Now()
+	rw := httptest.NewRecorder()
+	w := &serverTimingWriter{ResponseWriter: rw, timing: &serverTiming{start: now, auth: now, upstream: now}}
+	upstream.ServeHTTP(w, httptest.NewRequest("GET", "https://localhost/", nil))
+
+	testutil.Equal(t, http.StatusCreated, rw.Code)
+	timings := rw.Header()[serverTimingHeader]
+	testutil.Equal(t, 2, len(timings))
+	testutil.Equal(t, "db;dur=12", timings[0])
+	testutil.Assert(t, serverTimingPattern.MatchString(timings[1]), "unexpected Server-Timing header %q", timings[1])
+	testutil.Equal(t, "created", rw.Body.String())
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/pkg/middleware"
+)
+
+type requestSessionKey struct{}
+
+// requestSession memoizes the session of a request, so that its session cookie is decoded at
+// most once however many handlers and middleware need it. It is only accessed by the goroutine
+// serving the request.
+type requestSession struct {
+	loaded  bool
+	session *sessions.SessionState
+	err     error
+
+	// authenticated is the session once it has been validated by authenticate.
+	authenticated *sessions.SessionState
+	// identity is the user the request is forwarded to the upstream as, once it has been
+	// authenticated by a session or a bearer token.
+	identity *middleware.Identity
+}
+
+// NewSessionContext returns a copy of ctx in which the session of a request is memoized. The
+// outermost handler of a request should call it, so that every handler it wraps shares the
+// decoded session, and can read the authenticated session back with SessionFromContext.
+func NewSessionContext(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(requestSessionKey{}).(*requestSession); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, requestSessionKey{}, &requestSession{})
+}
+
+// SessionFromContext returns the authenticated session of the request ctx belongs to, if the
+// request was authenticated with a session cookie.
+func SessionFromContext(ctx context.Context) (*sessions.SessionState, bool) {
+	rs, ok := ctx.Value(requestSessionKey{}).(*requestSession)
+	if !ok || rs.authenticated == nil {
+		return nil, false
+	}
+	return rs.authenticated, true
+}
+
+// withSessionContext is middleware memoizing the session of each request.
+func withSessionContext(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		h.ServeHTTP(rw, req.WithContext(NewSessionContext(req.Context())))
+	})
+}
+
+// loadSession returns the session of a request, decoding its session cookie only the first
+// time it is called for the request.
+func (p *OAuthProxy) loadSession(req *http.Request) (*sessions.SessionState, error) {
+	rs, ok := req.Context().Value(requestSessionKey{}).(*requestSession)
+	if !ok {
+		return p.sessionStore.LoadSession(req)
+	}
+	if !rs.loaded {
+		rs.session, rs.err = p.sessionStore.LoadSession(req)
+		rs.loaded = true
+	}
+	return rs.session, rs.err
+}
+
+// setAuthenticatedSession records the session a request was authenticated with, or that it
+// wasn't authenticated when session is nil.
+func setAuthenticatedSession(req *http.Request, session *sessions.SessionState) {
+	if rs, ok := req.Context().Value(requestSessionKey{}).(*requestSession); ok {
+		rs.authenticated = session
+		if session == nil {
+			rs.identity = nil
+		}
+	}
+}
+
+// forwardedIdentity returns the identity the request ctx belongs to is forwarded to the upstream
+// as, if it was authenticated with a session cookie or a bearer token. Unlike the X-Forwarded-*
+// headers, it can't be set by clients of requests that skip authentication.
+func forwardedIdentity(ctx context.Context) (middleware.Identity, bool) {
+	rs, ok := ctx.Value(requestSessionKey{}).(*requestSession)
+	if !ok || rs.identity == nil {
+		return middleware.Identity{}, false
+	}
+	return *rs.identity, true
+}
+
+// setForwardedIdentity records the identity an authenticated request is forwarded as.
+func setForwardedIdentity(req *http.Request, identity middleware.Identity) {
+	if rs, ok := req.Context().Value(requestSessionKey{}).(*requestSession); ok {
+		rs.identity = &identity
+	}
+}
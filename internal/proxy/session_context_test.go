@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/pkg/middleware"
+)
+
+// countingSessionStore counts the number of times sessions are loaded.
+type countingSessionStore struct {
+	*sessions.MockSessionStore
+	loads int
+}
+
+func (cs *countingSessionStore) LoadSession(req *http.Request) (*sessions.SessionState, error) {
+	cs.loads++
+	return cs.MockSessionStore.LoadSession(req)
+}
+
+func TestLoadSessionMemoized(t *testing.T) {
+	store := &countingSessionStore{MockSessionStore: &sessions.MockSessionStore{Session: testSession()}}
+	proxy, close := testNewOAuthProxy(t, setSessionStore(store))
+	defer close()
+
+	// without a session context, the session is decoded on each load
+	req := httptest.NewRequest("GET", "https://localhost", nil)
+	proxy.loadSession(req)
+	proxy.loadSession(req)
+	testutil.Equal(t, 2, store.loads)
+
+	store.loads = 0
+	req = req.WithContext(NewSessionContext(req.Context()))
+	first, err := proxy.loadSession(req)
+	testutil.Ok(t, err)
+	second, err := proxy.loadSession(req)
+	testutil.Ok(t, err)
+	testutil.Equal(t, 1, store.loads)
+	testutil.Assert(t, first == second, "expected the memoized session to be returned")
+
+	// nesting session contexts shares the memoized session
+	req = req.WithContext(NewSessionContext(req.Context()))
+	proxy.loadSession(req)
+	testutil.Equal(t, 1, store.loads)
+}
+
+func TestSessionFromContext(t *testing.T) {
+	testCases := []struct {
+		name          string
+		sessionStore  *sessions.MockSessionStore
+		authenticated bool
+	}{
+		{
+			name:          "authenticated session",
+			sessionStore:  &sessions.MockSessionStore{Session: testSession()},
+			authenticated: true,
+		},
+		{
+			name:         "no session cookie",
+			sessionStore: &sessions.MockSessionStore{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t, setSessionStore(tc.sessionStore))
+			defer close()
+
+			var session *sessions.SessionState
+			var identity middleware.Identity
+			var ok, identified bool
+			handler := withSessionContext(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				proxy.Authenticate(rw, req)
+				session, ok = SessionFromContext(req.Context())
+				identity, identified = forwardedIdentity(req.Context())
+			}))
+			req := httptest.NewRequest("GET", "https://localhost", nil)
+			req.Header.Set("X-Forwarded-Email", "spoofed@example.com")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			testutil.Equal(t, tc.authenticated, ok)
+			testutil.Equal(t, tc.authenticated, identified)
+			if tc.authenticated {
+				testutil.Equal(t, tc.sessionStore.Session.Email, session.Email)
+				testutil.Equal(t, middleware.Identity{
+					User:   tc.sessionStore.Session.User,
+					Email:  tc.sessionStore.Session.Email,
+					Groups: tc.sessionStore.Session.Groups,
+				}, identity)
+			}
+		})
+	}
+}
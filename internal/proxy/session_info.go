@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+)
+
+const (
+	sessionPingPath = "/oauth2/ping"
+	// sessionExpiresInHeader tells single page apps how many seconds remain until the session
+	// of a request expires, so that they can warn their users or renew it before it does.
+	sessionExpiresInHeader = "X-SSO-Session-Expires-In"
+)
+
+// sessionInfo is the view of a session shown to its user by the SessionInfo handler.
+type sessionInfo struct {
+	Email    string   `json:"email"`
+	User     string   `json:"user"`
+	Groups   []string `json:"groups"`
+	Provider string   `json:"provider"`
+
+	IssuedAt         time.Time `json:"issued_at"`
+	RefreshDeadline  time.Time `json:"refresh_deadline"`
+	ValidDeadline    time.Time `json:"valid_deadline"`
+	LifetimeDeadline time.Time `json:"lifetime_deadline"`
+
+	ImpersonatedEmail string `json:"impersonated_email,omitempty"`
+}
+
+func newSessionInfo(session *sessions.SessionState, host string) *sessionInfo {
+	info := &sessionInfo{
+		Email:            session.Email,
+		User:             session.User,
+		Groups:           session.Groups,
+		Provider:         session.ProviderSlug,
+		IssuedAt:         session.IssuedAt,
+		RefreshDeadline:  session.RefreshDeadline,
+		ValidDeadline:    session.ValidDeadline,
+		LifetimeDeadline: session.LifetimeDeadline,
+	}
+	if session.ImpersonatingOn(host) {
+		info.ImpersonatedEmail = session.Impersonation.Email
+	}
+	return info
+}
+
+// SessionInfo shows the current user the state of their session: when it was issued,
+// when it expires, and the groups and provider it was authenticated with. Requests
+// accepting JSON receive the same information as a JSON document.
+func (p *OAuthProxy) SessionInfo(rw http.ResponseWriter, req *http.Request) {
+	tags := []string{"action:session"}
+
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		p.ErrorPage(rw, req, http.StatusMethodNotAllowed, "Method Not Allowed", "Method Not Allowed")
+		return
+	}
+
+	session, err := p.authenticate(rw, req)
+	if err != nil {
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+
+	// the session is only ever shown to its own user, but must not be cached
+	rw.Header().Set("Cache-Control", "no-store")
+
+	info := newSessionInfo(session, req.Host)
+	if p.isXHR(req) || strings.Contains(req.Header.Get("Accept"), "application/json") {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(info)
+		return
+	}
+
+	p.templates.ExecuteTemplate(rw, "session.html", struct {
+		*sessionInfo
+		ExpiresIn   string
+		RefreshesIn string
+	}{
+		sessionInfo: info,
+		ExpiresIn:   untilDeadline(info.LifetimeDeadline),
+		RefreshesIn: untilDeadline(info.RefreshDeadline),
+	})
+}
+
+// sessionPing is the state of a session reported by the SessionPing handler.
+type sessionPing struct {
+	Authenticated    bool       `json:"authenticated"`
+	Email            string     `json:"email,omitempty"`
+	ExpiresIn        int        `json:"expires_in,omitempty"`
+	LifetimeDeadline *time.Time `json:"lifetime_deadline,omitempty"`
+}
+
+// expiresIn returns the whole seconds remaining until a session expires.
+func expiresIn(session *sessions.SessionState) int {
+	remaining := int(time.Until(session.LifetimeDeadline).Seconds())
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// setSessionExpiresIn sets the header telling the client when the session of a request expires.
+func setSessionExpiresIn(rw http.ResponseWriter, session *sessions.SessionState) {
+	rw.Header().Set(sessionExpiresInHeader, strconv.Itoa(expiresIn(session)))
+}
+
+// SessionPing reports whether a request is authenticated, and how long its session has left,
+// as JSON, so that single page apps can poll it cheaply. Unlike other requests, unauthenticated
+// requests receive a 401 rather than a redirect to sign in. A browser navigating to it with
+// ?renew=true, e.g. in a hidden iframe, signs in again, renewing the session before it expires,
+// and ends up back at the ping.
+func (p *OAuthProxy) SessionPing(rw http.ResponseWriter, req *http.Request) {
+	tags := []string{"action:ping"}
+
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		p.XHRError(rw, req, http.StatusMethodNotAllowed, errors.New("Method Not Allowed"))
+		return
+	}
+
+	if req.URL.Query().Get("renew") == "true" {
+		renewReq := req.Clone(req.Context())
+		renewReq.URL = &url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: sessionPingPath}
+		p.StatsdClient.Incr("session_renewed", tags, 1.0)
+		p.OAuthStart(rw, renewReq, tags)
+		return
+	}
+
+	// the state of the session must not be cached
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Content-Type", "application/json")
+
+	session, err := p.authenticate(rw, req)
+	if err != nil {
+		rw.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(rw).Encode(&sessionPing{})
+		return
+	}
+
+	setSessionExpiresIn(rw, session)
+	json.NewEncoder(rw).Encode(&sessionPing{
+		Authenticated:    true,
+		Email:            session.Email,
+		ExpiresIn:        expiresIn(session),
+		LifetimeDeadline: &session.LifetimeDeadline,
+	})
+}
+
+// ForceRefresh refreshes the current session with the provider immediately, rather than
+// waiting for its refresh deadline, and redirects back to the SessionInfo page.
+func (p *OAuthProxy) ForceRefresh(rw http.ResponseWriter, req *http.Request) {
+	logger := requestLogEntry(req)
+	tags := []string{"action:refresh_session"}
+
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		p.ErrorPage(rw, req, http.StatusMethodNotAllowed, "Method Not Allowed", "Method Not Allowed")
+		return
+	}
+
+	if !isSameOrigin(req) {
+		tags = append(tags, "error:cross_origin_request")
+		p.StatsdClient.Incr("application_error", tags, 1.0)
+		p.ErrorPage(rw, req, http.StatusForbidden, "Forbidden", "Cross-origin requests are not allowed")
+		return
+	}
+
+	session, err := p.authenticate(rw, req)
+	if err != nil {
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+
+	ok, err := p.provider.RefreshSession(session, p.upstreamConfig.AllowedGroups)
+	if err == nil && !ok {
+		err = ErrUserNotAuthorized
+	}
+	if err == nil {
+		err = p.sessionStore.SaveSession(rw, req, session)
+	}
+	if err != nil {
+		logger.WithUser(session.Email).Error(err, "forced session refresh failed")
+		p.sessionStore.ClearSession(rw, req)
+		p.handleAuthenticationError(rw, req, err, tags)
+		return
+	}
+
+	logger.WithUser(session.Email).WithRefreshDeadline(session.RefreshDeadline).Info(
+		"forced session refresh")
+	http.Redirect(rw, req, "/oauth2/session", http.StatusSeeOther)
+}
+
+// untilDeadline formats the time remaining until a deadline for display.
+func untilDeadline(deadline time.Time) string {
+	remaining := time.Until(deadline).Round(time.Second)
+	if remaining <= 0 {
+		return "now"
+	}
+	return remaining.String()
+}
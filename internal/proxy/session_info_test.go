@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/proxy/providers"
+)
+
+func TestSessionInfo(t *testing.T) {
+	session := testSession()
+	session.ProviderSlug = "google"
+	session.IssuedAt = time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name             string
+		sessionStore     *sessions.MockSessionStore
+		accept           string
+		expectedCode     int
+		expectedContains []string
+	}{
+		{
+			name:             "renders session page",
+			sessionStore:     &sessions.MockSessionStore{Session: session},
+			expectedCode:     http.StatusOK,
+			expectedContains: []string{"michael.bland@gsa.gov", "foo, bar", "2019-01-01 00:00:00 UTC"},
+		},
+		{
+			name:         "returns json when accepted",
+			sessionStore: &sessions.MockSessionStore{Session: session},
+			accept:       "application/json",
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "redirects unauthenticated users to sign in",
+			sessionStore: &sessions.MockSessionStore{},
+			expectedCode: http.StatusFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t, setSessionStore(tc.sessionStore))
+			// the session's provider slug must match the proxy's provider
+			proxy.provider.Data().ProviderSlug = "google"
+			defer close()
+
+			req := httptest.NewRequest("GET", "https://localhost/oauth2/session", nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			rw := httptest.NewRecorder()
+
+			proxy.Handler().ServeHTTP(rw, req)
+
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+			for _, s := range tc.expectedContains {
+				testutil.Assert(t, strings.Contains(rw.Body.String(), s), "expected body to contain %q", s)
+			}
+			if tc.accept == "application/json" {
+				info := &sessionInfo{}
+				testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), info))
+				testutil.Equal(t, "michael.bland@gsa.gov", info.Email)
+				testutil.Equal(t, "google", info.Provider)
+				testutil.Equal(t, []string{"foo", "bar"}, info.Groups)
+				testutil.Equal(t, session.IssuedAt, info.IssuedAt.UTC())
+			}
+		})
+	}
+}
+
+func TestForceRefresh(t *testing.T) {
+	testCases := []struct {
+		name              string
+		origin            string
+		refreshFunc       func(*sessions.SessionState, []string) (bool, error)
+		expectedCode      int
+		expectedRefreshed bool
+	}{
+		{
+			name:   "refreshes session",
+			origin: "https://localhost",
+			refreshFunc: func(s *sessions.SessionState, _ []string) (bool, error) {
+				s.AccessToken = "refreshed_access_token"
+				return true, nil
+			},
+			expectedCode:      http.StatusSeeOther,
+			expectedRefreshed: true,
+		},
+		{
+			name:         "rejects cross origin requests",
+			origin:       "https://evil.example.com",
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:   "forbids users no longer authorized",
+			origin: "https://localhost",
+			refreshFunc: func(*sessions.SessionState, []string) (bool, error) {
+				return false, nil
+			},
+			expectedCode: http.StatusForbidden,
+		},
+		{
+			name:   "handles provider errors",
+			origin: "https://localhost",
+			refreshFunc: func(*sessions.SessionState, []string) (bool, error) {
+				return false, errors.New("provider unavailable")
+			},
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			providerURL, _ := url.Parse("http://localhost/")
+			provider := providers.NewTestProvider(providerURL, "")
+			provider.RefreshSessionFunc = tc.refreshFunc
+
+			sessionStore := &sessions.MockSessionStore{Session: testSession()}
+			proxy, close := testNewOAuthProxy(t,
+				SetProvider(provider),
+				setSessionStore(sessionStore),
+			)
+			defer close()
+
+			req := httptest.NewRequest("POST", "https://localhost/oauth2/session/refresh", nil)
+			req.Header.Set("Origin", tc.origin)
+			rw := httptest.NewRecorder()
+
+			proxy.Handler().ServeHTTP(rw, req)
+
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+			testutil.Equal(t, tc.expectedRefreshed, strings.Contains(sessionStore.ResponseSession, "refreshed_access_token"))
+		})
+	}
+}
+
+func TestSessionPing(t *testing.T) {
+	session := testSession()
+	session.LifetimeDeadline = time.Now().Add(time.Hour)
+
+	testCases := []struct {
+		name          string
+		sessionStore  *sessions.MockSessionStore
+		target        string
+		expectedCode  int
+		authenticated bool
+	}{
+		{
+			name:          "reports the session",
+			sessionStore:  &sessions.MockSessionStore{Session: session},
+			target:        "/oauth2/ping",
+			expectedCode:  http.StatusOK,
+			authenticated: true,
+		},
+		{
+			name:         "doesn't redirect unauthenticated users",
+			sessionStore: &sessions.MockSessionStore{LoadError: http.ErrNoCookie},
+			target:       "/oauth2/ping",
+			expectedCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "renews the session",
+			sessionStore: &sessions.MockSessionStore{Session: session},
+			target:       "/oauth2/ping?renew=true",
+			expectedCode: http.StatusFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy, close := testNewOAuthProxy(t, setSessionStore(tc.sessionStore))
+			defer close()
+
+			req := httptest.NewRequest("GET", "https://localhost"+tc.target, nil)
+			rw := httptest.NewRecorder()
+			proxy.Handler().ServeHTTP(rw, req)
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+
+			if tc.expectedCode == http.StatusFound {
+				testutil.Assert(t, strings.HasPrefix(rw.Header().Get("Location"), "http://localhost/oauth/authorize"),
+					"expected a redirect to sign in, got %q", rw.Header().Get("Location"))
+				return
+			}
+
+			ping := &sessionPing{}
+			testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), ping))
+			testutil.Equal(t, tc.authenticated, ping.Authenticated)
+			testutil.Equal(t, "no-store", rw.Header().Get("Cache-Control"))
+			if !tc.authenticated {
+				testutil.Equal(t, "", rw.Header().Get(sessionExpiresInHeader))
+				return
+			}
+			testutil.Equal(t, "michael.bland@gsa.gov", ping.Email)
+			testutil.Assert(t, ping.ExpiresIn > 3500 && ping.ExpiresIn <= 3600, "unexpected expires_in %d", ping.ExpiresIn)
+			testutil.Equal(t, strconv.Itoa(ping.ExpiresIn), rw.Header().Get(sessionExpiresInHeader))
+		})
+	}
+}
+
+func TestSessionExpiresInHeader(t *testing.T) {
+	session := testSession()
+	session.LifetimeDeadline = time.Now().Add(time.Hour)
+	proxy, close := testNewOAuthProxy(t, setSessionStore(&sessions.MockSessionStore{Session: session}))
+	defer close()
+
+	rw := httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, httptest.NewRequest("GET", "https://localhost/", nil))
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	expires, err := strconv.Atoi(rw.Header().Get(sessionExpiresInHeader))
+	testutil.Ok(t, err)
+	testutil.Assert(t, expires > 3500 && expires <= 3600, "unexpected %s %d", sessionExpiresInHeader, expires)
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+const silentAuthPath = "/oauth2/silent_auth"
+
+// silentAuthResult is the result of a silent sign in, posted by its page to the page that
+// started it.
+type silentAuthResult struct {
+	Type      string `json:"type"`
+	Result    string `json:"result"`
+	Email     string `json:"email,omitempty"`
+	ExpiresIn int    `json:"expires_in,omitempty"`
+}
+
+// SilentAuth starts a silent sign in, which renews the session of a single page app loading it
+// in a hidden iframe without a visible redirect. The authenticator is asked not to prompt the
+// user, as with OpenID Connect's prompt=none, and so either signs them in again from its own
+// session or answers with an error, e.g. once the user's session with the provider is gone.
+// Either way, the sign in ends on a page posting its result to the app, which can fall back to
+// an interactive sign in when it's told login_required.
+func (p *OAuthProxy) SilentAuth(rw http.ResponseWriter, req *http.Request) {
+	tags := []string{"action:silent_auth"}
+
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		p.ErrorPage(rw, req, http.StatusMethodNotAllowed, "Method Not Allowed", "Method Not Allowed")
+		return
+	}
+
+	p.StatsdClient.Incr("silent_auth_started", tags, 1.0)
+	p.startOAuthFlow(rw, req, tags, true)
+}
+
+// silentAuthFailed answers the callback of a silent sign in the authenticator couldn't complete
+// without the user, returning false if the callback isn't for a silent sign in.
+func (p *OAuthProxy) silentAuthFailed(rw http.ResponseWriter, req *http.Request, errorString string) bool {
+	switch errorString {
+	case "login_required", "consent_required", "interaction_required":
+	default:
+		return false
+	}
+
+	state := &StateParameter{}
+	if err := p.cookieCipher.Unmarshal(req.Form.Get("state"), state); err != nil || !state.Silent {
+		return false
+	}
+
+	p.StatsdClient.Incr("silent_auth_failed", []string{"action:callback", "error:" + errorString}, 1.0)
+	p.csrfStore.ClearCSRF(rw, req)
+	p.renderSilentAuth(rw, req, &silentAuthResult{Result: errorString})
+	return true
+}
+
+// renderSilentAuth responds to a silent sign in with a page posting its result to the page
+// that loaded it. The result is only posted to pages of the same origin, which are the only
+// ones allowed to frame it.
+func (p *OAuthProxy) renderSilentAuth(rw http.ResponseWriter, req *http.Request, result *silentAuthResult) {
+	result.Type = "sso_silent_auth"
+
+	rw.Header().Set("Cache-Control", "no-store")
+	p.templates.ExecuteTemplate(rw, "silent_auth.html", struct {
+		Host   string
+		Result *silentAuthResult
+	}{
+		Host:   req.Host,
+		Result: result,
+	})
+}
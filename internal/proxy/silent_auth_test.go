@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/aead"
+	"github.com/buzzfeed/sso/internal/pkg/options"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/proxy/providers"
+)
+
+func TestSilentAuth(t *testing.T) {
+	cipher, err := aead.NewMiscreantCipher([]byte(strings.Repeat("k", 32)))
+	testutil.Ok(t, err)
+
+	testCases := []struct {
+		name         string
+		callback     url.Values
+		validator    bool
+		wantStatus   int
+		wantContains string
+	}{
+		{
+			name:         "renewed",
+			callback:     url.Values{"code": {"code"}},
+			validator:    true,
+			wantStatus:   http.StatusOK,
+			wantContains: `"result":"renewed","email":"michael.bland@gsa.gov"`,
+		},
+		{
+			name:         "login required",
+			callback:     url.Values{"error": {"login_required"}},
+			validator:    true,
+			wantStatus:   http.StatusOK,
+			wantContains: `"result":"login_required"`,
+		},
+		{
+			name:         "access denied",
+			callback:     url.Values{"code": {"code"}},
+			wantStatus:   http.StatusOK,
+			wantContains: `"result":"access_denied"`,
+		},
+		{
+			name:       "other errors",
+			callback:   url.Values{"error": {"server_error"}},
+			validator:  true,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			csrfStore := &sessions.MockCSRFStore{}
+			provider := providers.NewTestProvider(&url.URL{Host: "localhost"}, "")
+			provider.RedeemFunc = func(string, string) (*sessions.SessionState, error) {
+				return testSession(), nil
+			}
+			proxy, close := testNewOAuthProxy(t,
+				SetProvider(provider),
+				SetValidators([]options.Validator{options.NewMockValidator(tc.validator)}),
+				setSessionStore(&sessions.MockSessionStore{LoadError: http.ErrNoCookie}),
+				setCSRFStore(csrfStore),
+				setCookieCipher(cipher),
+			)
+			defer close()
+
+			rw := httptest.NewRecorder()
+			proxy.Handler().ServeHTTP(rw, httptest.NewRequest("GET", "https://localhost/oauth2/silent_auth", nil))
+			testutil.Equal(t, http.StatusFound, rw.Code)
+			location, err := rw.Result().Location()
+			testutil.Ok(t, err)
+			testutil.Equal(t, "none", location.Query().Get("prompt"))
+
+			csrfStore.Cookie = &http.Cookie{Value: csrfStore.ResponseCSRF}
+			tc.callback.Set("state", location.Query().Get("state"))
+			rw = httptest.NewRecorder()
+			proxy.Handler().ServeHTTP(rw, httptest.NewRequest("GET", "https://localhost/oauth2/callback?"+tc.callback.Encode(), nil))
+
+			testutil.Equal(t, tc.wantStatus, rw.Code)
+			if tc.wantContains != "" {
+				testutil.Equal(t, "no-store", rw.Header().Get("Cache-Control"))
+				body := rw.Body.String()
+				testutil.Assert(t, strings.Contains(body, tc.wantContains), "expected %q in body: %s", tc.wantContains, body)
+				testutil.Assert(t, strings.Contains(body, "postMessage"), "expected postMessage in body: %s", body)
+			}
+		})
+	}
+}
+
+func TestSilentAuthFailedRequiresSilentState(t *testing.T) {
+	cipher, err := aead.NewMiscreantCipher([]byte(strings.Repeat("k", 32)))
+	testutil.Ok(t, err)
+	proxy, close := testNewOAuthProxy(t, setCookieCipher(cipher))
+	defer close()
+
+	// the error of an interactive sign in is shown as before
+	state, err := cipher.Marshal(&StateParameter{SessionID: "id", RedirectURI: "/"})
+	testutil.Ok(t, err)
+	callback := "https://localhost/oauth2/callback?" + url.Values{
+		"error": {"login_required"},
+		"state": {state},
+	}.Encode()
+	rw := httptest.NewRecorder()
+	proxy.Handler().ServeHTTP(rw, httptest.NewRequest("GET", callback, nil))
+	testutil.Equal(t, http.StatusForbidden, rw.Code)
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// inflightRequest is a request being served by an upstream, as listed by the admin API. Only
+// the path of the request is kept, since its query may carry secrets.
+type inflightRequest struct {
+	ID      string    `json:"id"`
+	Service string    `json:"service"`
+	Method  string    `json:"method"`
+	Host    string    `json:"host"`
+	Path    string    `json:"path"`
+	Started time.Time `json:"started"`
+	Age     float64   `json:"age_seconds"`
+}
+
+// inflightRequests tracks the requests being served by upstreams, so that the requests of hung
+// upstreams can be found.
+type inflightRequests struct {
+	mu       sync.Mutex
+	next     uint64
+	requests map[uint64]*inflightRequest
+}
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{requests: make(map[uint64]*inflightRequest)}
+}
+
+// add tracks a request to the upstream of a service, returning the function that stops
+// tracking it once it's served.
+func (r *inflightRequests) add(req *http.Request, service string, started time.Time) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := r.next
+	r.next++
+	r.requests[key] = &inflightRequest{
+		ID:      requestID(req),
+		Service: service,
+		Method:  req.Method,
+		Host:    req.Host,
+		Path:    req.URL.Path,
+		Started: started,
+	}
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.requests, key)
+	}
+}
+
+// list returns the requests in flight for at least a given age, oldest first.
+func (r *inflightRequests) list(minAge time.Duration, now time.Time) []inflightRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	requests := []inflightRequest{}
+	for _, inflight := range r.requests {
+		age := now.Sub(inflight.Started)
+		if age < minAge {
+			continue
+		}
+		listed := *inflight
+		listed.Age = age.Seconds()
+		requests = append(requests, listed)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Started.Before(requests[j].Started)
+	})
+	return requests
+}
+
+// handler tracks the requests to an upstream while they're in flight, unless r is nil, and logs
+// those slower than the upstream's slow_request_threshold.
+func (r *inflightRequests) handler(h http.Handler, upstreamConfig *UpstreamConfig, statsdClient *statsd.Client) http.Handler {
+	threshold := upstreamConfig.SlowRequestThreshold
+	tags := []string{"service:" + upstreamConfig.Service}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		if r != nil {
+			done := r.add(req, upstreamConfig.Service, start)
+			defer done()
+		}
+
+		h.ServeHTTP(rw, req)
+
+		duration := time.Now().Sub(start)
+		if threshold <= 0 || duration < threshold {
+			return
+		}
+		statsdClient.Incr("upstream.slow_request", tags, 1.0)
+		log.NewLogEntry().
+			WithServiceName(upstreamConfig.Service).
+			WithRequestID(requestID(req)).
+			WithRequestMethod(req.Method).
+			WithRequestHost(req.Host).
+			WithRequestURI(req.URL.Path).
+			WithRequestDurationMs(float64(duration)/float64(time.Millisecond)).
+			Warn("slow request to upstream, exceeding its threshold of ", threshold)
+	})
+}
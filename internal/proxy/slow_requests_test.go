@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestInflightRequestsList(t *testing.T) {
+	inflight := newInflightRequests()
+	now := time.Now()
+
+	doneOld := inflight.add(httptest.NewRequest("GET", "https://foo.sso.dev/reports?token=secret", nil), "foo", now.Add(-time.Minute))
+	defer doneOld()
+	doneNew := inflight.add(httptest.NewRequest("POST", "https://bar.sso.dev/upload", nil), "bar", now.Add(-time.Second))
+
+	requests := inflight.list(0, now)
+	testutil.Equal(t, 2, len(requests))
+	testutil.Equal(t, "foo", requests[0].Service)
+	testutil.Equal(t, "/reports", requests[0].Path)
+	testutil.Equal(t, "foo.sso.dev", requests[0].Host)
+	testutil.Equal(t, float64(60), requests[0].Age)
+	testutil.Equal(t, "bar", requests[1].Service)
+
+	requests = inflight.list(10*time.Second, now)
+	testutil.Equal(t, 1, len(requests))
+	testutil.Equal(t, "foo", requests[0].Service)
+
+	// requests stop being listed once they're served
+	doneNew()
+	testutil.Equal(t, 1, len(inflight.list(0, now)))
+}
+
+func TestInflightRequestsHandler(t *testing.T) {
+	inflight := newInflightRequests()
+	upstreamConfig := &UpstreamConfig{Service: "foo", SlowRequestThreshold: time.Millisecond}
+
+	var listed []inflightRequest
+	handler := inflight.handler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		listed = inflight.list(0, time.Now())
+		time.Sleep(2 * time.Millisecond)
+		rw.WriteHeader(http.StatusOK)
+	}), upstreamConfig, nil)
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "https://foo.sso.dev/hung", nil))
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	testutil.Equal(t, 1, len(listed))
+	testutil.Equal(t, "/hung", listed[0].Path)
+	testutil.Equal(t, 0, len(inflight.list(0, time.Now())))
+
+	// without an admin API, requests are only timed
+	var untracked *inflightRequests
+	handler = untracked.handler(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusAccepted)
+	}), upstreamConfig, nil)
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "https://foo.sso.dev/", nil))
+	testutil.Equal(t, http.StatusAccepted, rw.Code)
+}
+
+func TestAdminHandlerInflight(t *testing.T) {
+	inflight := newInflightRequests()
+	done := inflight.add(httptest.NewRequest("GET", "https://foo.sso.dev/hung", nil), "foo", time.Now().Add(-time.Minute))
+	defer done()
+	inflight.add(httptest.NewRequest("GET", "https://foo.sso.dev/", nil), "foo", time.Now())
+	handler := newAdminHandler(adminConfig{token: "admin-token", inflight: inflight})
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	testCases := []struct {
+		name          string
+		method        string
+		target        string
+		expectedCode  int
+		expectedPaths []string
+	}{
+		{"all", "GET", "http://localhost/admin/inflight", http.StatusOK, []string{"/hung", "/"}},
+		{"older than", "GET", "http://localhost/admin/inflight?older_than=30", http.StatusOK, []string{"/hung"}},
+		{"invalid older than", "GET", "http://localhost/admin/inflight?older_than=soon", http.StatusBadRequest, nil},
+		{"negative older than", "GET", "http://localhost/admin/inflight?older_than=-1", http.StatusBadRequest, nil},
+		{"wrong method", "POST", "http://localhost/admin/inflight", http.StatusMethodNotAllowed, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := serve(tc.method, tc.target)
+			testutil.Equal(t, tc.expectedCode, rw.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			listing := struct {
+				Requests []inflightRequest `json:"requests"`
+			}{}
+			err := json.Unmarshal(rw.Body.Bytes(), &listing)
+			testutil.Assert(t, err == nil, "could not decode inflight listing: %s", err)
+			paths := []string{}
+			for _, request := range listing.Requests {
+				paths = append(paths, request.Path)
+			}
+			testutil.Equal(t, tc.expectedPaths, paths)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/fips"
+	"github.com/buzzfeed/sso/internal/pkg/spiffe"
+)
+
+// spiffeTimeout bounds the time the proxy waits at startup for its first SVID.
+const spiffeTimeout = 30 * time.Second
+
+// loadSPIFFESource fetches the SVID of the proxy from the SPIFFE Workload API.
+func loadSPIFFESource(opts *Options) (*spiffe.Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), spiffeTimeout)
+	defer cancel()
+	return spiffe.NewSource(ctx, opts.SPIFFEEndpointSocket)
+}
+
+// validateSPIFFEOptions validates the workload API endpoint and the SPIFFE IDs of admin API
+// callers, and that the upstreams using SVIDs have a workload API to fetch them from.
+func validateSPIFFEOptions(o *Options, msgs []string) []string {
+	if o.SPIFFEEndpointSocket != "" {
+		if _, _, err := spiffe.ParseEndpoint(o.SPIFFEEndpointSocket); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid value for spiffe-endpoint-socket: %s", err))
+		}
+	}
+
+	for _, id := range o.AdminSPIFFEIDs {
+		if err := spiffe.ValidateID(id); err != nil {
+			msgs = append(msgs, fmt.Sprintf("invalid value for admin-spiffe-ids: %s", err))
+		}
+	}
+	if len(o.AdminSPIFFEIDs) != 0 && o.SPIFFEEndpointSocket == "" {
+		msgs = append(msgs, "missing setting: SPIFFE_ENDPOINT_SOCKET is required by ADMIN_SPIFFE_IDS")
+	}
+
+	if o.SPIFFEEndpointSocket == "" {
+		for _, uc := range o.upstreamConfigs {
+			if uc.SPIFFEMTLS || uc.SPIFFEID != "" {
+				msgs = append(msgs, fmt.Sprintf("missing setting: SPIFFE_ENDPOINT_SOCKET is required by the spiffe options of upstream %s", uc.Service))
+			}
+		}
+	}
+	return msgs
+}
+
+// setSPIFFESource sets the SVID source of the upstreams that use their SVIDs for mutual TLS.
+func setSPIFFESource(opts *Options, config *UpstreamConfig) {
+	if config.SPIFFEMTLS || config.SPIFFEID != "" {
+		config.spiffeSource = opts.spiffeSource
+	}
+}
+
+// configureSPIFFETLS presents the proxy's SVID as the client certificate of connections to an
+// upstream with spiffe_mtls, and verifies the SVID of an upstream with a spiffe_id.
+func configureSPIFFETLS(t *upstreamTransport, config *UpstreamConfig) {
+	source := config.spiffeSource
+	if source == nil {
+		return
+	}
+	if t.tlsConfig == nil {
+		t.tlsConfig = &tls.Config{}
+	}
+	if config.SPIFFEMTLS {
+		t.tlsConfig.GetClientCertificate = source.GetClientCertificate
+	}
+	if config.SPIFFEID != "" {
+		// SVIDs aren't issued for host names, so the upstream's SVID is verified against the
+		// trust bundle and its SPIFFE ID rather than by crypto/tls
+		t.insecureSkipVerify = true
+		t.tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			id, err := source.VerifyPeer(rawCerts)
+			if err != nil {
+				return err
+			}
+			if id != config.SPIFFEID {
+				return fmt.Errorf("upstream presented the SVID of %s, not %s", id, config.SPIFFEID)
+			}
+			return nil
+		}
+	}
+}
+
+// newAdminTLSConfig returns the TLS config of the admin API, served with the proxy's SVID, or nil
+// if its callers aren't authenticated by their SVIDs. Callers may present an SVID, which is
+// verified against the trust bundle, or an admin token.
+func newAdminTLSConfig(opts *Options) *tls.Config {
+	source := opts.spiffeSource
+	if source == nil || len(opts.AdminSPIFFEIDs) == 0 {
+		return nil
+	}
+	return fips.TLSConfig(&tls.Config{
+		GetCertificate: source.GetCertificate,
+		ClientAuth:     tls.RequestClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return nil
+			}
+			_, err := source.VerifyPeer(rawCerts)
+			return err
+		},
+	})
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestValidateSPIFFEOptions(t *testing.T) {
+	o := testOptions()
+	o.SPIFFEEndpointSocket = "/run/spire/sockets/agent.sock"
+	o.AdminSPIFFEIDs = []string{"spiffe://example.org/ops", "ops"}
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		`invalid value for spiffe-endpoint-socket: invalid workload API endpoint "/run/spire/sockets/agent.sock": must be a unix:// or tcp:// URL`,
+		`invalid value for admin-spiffe-ids: "ops" is not a SPIFFE ID, e.g. spiffe://example.org/service`,
+	}), err.Error())
+
+	// the admin API needs a token or the SPIFFE IDs of its callers
+	o = testOptions()
+	o.AdminPort = 4181
+	o.AdminSPIFFEIDs = []string{"spiffe://example.org/ops"}
+	err = o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		"missing setting: SPIFFE_ENDPOINT_SOCKET is required by ADMIN_SPIFFE_IDS",
+	}), err.Error())
+	o.SPIFFEEndpointSocket = "unix:///run/spire/sockets/agent.sock"
+	testutil.Ok(t, o.Validate())
+	testutil.Equal(t, true, o.adminAPI())
+
+	// upstreams can only use SVIDs with a workload API to fetch them from
+	o = testOptions()
+	o.upstreamConfigs, err = o.loadUpstreamConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: https://foo.internal
+    options:
+      spiffe_mtls: true
+      spiffe_id: spiffe://example.org/foo
+`))
+	testutil.Ok(t, err)
+	testutil.Equal(t, true, o.upstreamConfigs[0].SPIFFEMTLS)
+	testutil.Equal(t, "spiffe://example.org/foo", o.upstreamConfigs[0].SPIFFEID)
+	testutil.Equal(t, []string{
+		"missing setting: SPIFFE_ENDPOINT_SOCKET is required by the spiffe options of upstream foo",
+	}, validateSPIFFEOptions(o, []string{}))
+
+	_, err = o.loadUpstreamConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: https://foo.internal
+    options:
+      spiffe_id: foo.internal
+`))
+	testutil.NotEqual(t, nil, err)
+}
+
+func TestAdminHandlerSPIFFEIDs(t *testing.T) {
+	handler := newAdminHandler(adminConfig{spiffeIDs: []string{"spiffe://example.org/ops"}, deviceTokens: newDeviceTokenStore(time.Minute, time.Hour, 0)})
+
+	svid := func(id string) *tls.ConnectionState {
+		uri, err := url.Parse(id)
+		testutil.Ok(t, err)
+		return &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{URIs: []*url.URL{uri}}}}
+	}
+
+	testCases := []struct {
+		name          string
+		tls           *tls.ConnectionState
+		authorization string
+		wantCode      int
+	}{
+		{name: "authorized SPIFFE ID", tls: svid("spiffe://example.org/ops"), wantCode: http.StatusOK},
+		{name: "other SPIFFE ID", tls: svid("spiffe://example.org/web"), wantCode: http.StatusUnauthorized},
+		{name: "no client certificate", tls: &tls.ConnectionState{}, wantCode: http.StatusUnauthorized},
+		// without an admin token, an empty bearer token isn't accepted
+		{name: "empty bearer token", authorization: "Bearer ", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "https://localhost/admin/tokens", nil)
+			req.TLS = tc.tls
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+			testutil.Equal(t, tc.wantCode, rw.Code)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+// Startup check modes, set by STARTUP_CHECKS.
+const (
+	startupChecksFail = "fail"
+	startupChecksWarn = "warn"
+	startupChecksOff  = "off"
+)
+
+// startupCheckTimeout bounds each startup check, so that an unreachable dependency can't delay
+// the proxy from starting indefinitely.
+const startupCheckTimeout = 5 * time.Second
+
+// startupCheck is a dependency or part of the configuration verified when the proxy starts.
+type startupCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+// RunStartupChecks verifies that the provider responds, the statsd host resolves, the upstream
+// configs define upstreams for the cluster, and the request signing key loads, for the proxy and
+// every tenant, before the proxy serves traffic. Each failure is logged, followed by a summary of the checks. In fail mode, an
+// error is returned if any check failed, so that the proxy exits rather than serving traffic it
+// can't authenticate; in warn mode, the proxy starts anyway.
+func RunStartupChecks(opts *Options) error {
+	if opts.StartupChecks == startupChecksOff {
+		return nil
+	}
+	logger := log.NewLogEntry()
+
+	var failed []string
+	checks := newStartupChecks(opts)
+	for _, t := range opts.tenants {
+		for _, c := range newStartupChecks(t.opts) {
+			// statsd is shared with the proxy
+			if c.name == "statsd" {
+				continue
+			}
+			c.name = t.name + "." + c.name
+			checks = append(checks, c)
+		}
+	}
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), startupCheckTimeout)
+		err := c.check(ctx)
+		cancel()
+		if err != nil {
+			logger.WithError(err).Warn(fmt.Sprintf("startup check %s failed", c.name))
+			failed = append(failed, c.name)
+		}
+	}
+
+	if len(failed) == 0 {
+		logger.Info(fmt.Sprintf("startup checks: all %d passed", len(checks)))
+		return nil
+	}
+	summary := fmt.Sprintf("startup checks: %d of %d failed: %s",
+		len(failed), len(checks), strings.Join(failed, ", "))
+	if opts.StartupChecks == startupChecksFail {
+		return errors.New(summary)
+	}
+	logger.Warn(summary + "; starting anyway")
+	return nil
+}
+
+func newStartupChecks(opts *Options) []startupCheck {
+	checks := []startupCheck{
+		{name: "upstream_configs", check: func(context.Context) error { return checkUpstreamConfigs(opts) }},
+		{name: "provider", check: func(ctx context.Context) error { return checkProvider(ctx, opts) }},
+		{name: "statsd", check: func(ctx context.Context) error { return checkStatsd(ctx, opts) }},
+	}
+	if opts.RequestSigningKey != "" {
+		checks = append(checks, startupCheck{
+			name: "request_signing_key",
+			check: func(context.Context) error {
+				_, err := NewRequestSigner(opts.RequestSigningKey)
+				return err
+			},
+		})
+	}
+	if opts.RequestSignatureKMSKey != "" {
+		checks = append(checks, startupCheck{
+			name: "request_signer_kms_key",
+			check: func(context.Context) error {
+				_, err := NewKMSRequestSigner(opts.RequestSignatureKMSKey)
+				return err
+			},
+		})
+	}
+	return checks
+}
+
+func checkUpstreamConfigs(opts *Options) error {
+	if len(opts.upstreamConfigs) == 0 {
+		return fmt.Errorf("%s defines no upstreams for cluster %s", opts.UpstreamConfigsFile, opts.Cluster)
+	}
+	return nil
+}
+
+// checkProvider requests the health check of the provider, at the internal URL the proxy
+// redeems and validates sessions with.
+func checkProvider(ctx context.Context, opts *Options) error {
+	rawURL := opts.ProviderURLString
+	if opts.ProviderURLInternalString != "" {
+		rawURL = opts.ProviderURLInternalString
+	}
+	providerURL, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	pingURL := providerURL.ResolveReference(&url.URL{Path: "/ping"})
+
+	req, err := http.NewRequest("GET", pingURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got %d from %s", resp.StatusCode, pingURL)
+	}
+	return nil
+}
+
+// checkStatsd resolves the statsd host, since metrics sent over UDP are otherwise dropped
+// silently.
+func checkStatsd(ctx context.Context, opts *Options) error {
+	if net.ParseIP(opts.StatsdHost) != nil {
+		return nil
+	}
+	_, err := net.DefaultResolver.LookupHost(ctx, opts.StatsdHost)
+	return err
+}
+
+func validateStartupChecks(o *Options, msgs []string) []string {
+	switch o.StartupChecks {
+	case startupChecksFail, startupChecksWarn, startupChecksOff:
+	default:
+		msgs = append(msgs, fmt.Sprintf("invalid value for startup-checks: %q, must be fail, warn, or off", o.StartupChecks))
+	}
+	return msgs
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestRunStartupChecks(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/ping" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer provider.Close()
+
+	testCases := []struct {
+		name          string
+		mode          string
+		providerURL   string
+		signingKey    string
+		noUpstreams   bool
+		expectedError string
+	}{
+		{
+			name:        "all checks pass",
+			mode:        startupChecksFail,
+			providerURL: provider.URL,
+		},
+		{
+			name:          "missing upstreams fail",
+			mode:          startupChecksFail,
+			providerURL:   provider.URL,
+			noUpstreams:   true,
+			expectedError: "startup checks: 1 of 3 failed: upstream_configs",
+		},
+		{
+			name:          "invalid signing key fails",
+			mode:          startupChecksFail,
+			providerURL:   provider.URL,
+			signingKey:    "not a key",
+			expectedError: "startup checks: 1 of 4 failed: request_signing_key",
+		},
+		{
+			name:        "failed checks only warn",
+			mode:        startupChecksWarn,
+			providerURL: provider.URL,
+			signingKey:  "not a key",
+			noUpstreams: true,
+		},
+		{
+			name:        "checks are off",
+			mode:        startupChecksOff,
+			providerURL: "http://127.0.0.1:0",
+			noUpstreams: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := testOptions()
+			if err := opts.Validate(); err != nil {
+				t.Fatalf("unexpected error validating options: %v", err)
+			}
+			opts.StartupChecks = tc.mode
+			opts.ProviderURLInternalString = tc.providerURL
+			opts.RequestSigningKey = tc.signingKey
+			if tc.noUpstreams {
+				opts.upstreamConfigs = nil
+			}
+
+			err := RunStartupChecks(opts)
+			if tc.expectedError == "" {
+				testutil.Ok(t, err)
+				return
+			}
+			testutil.NotEqual(t, nil, err)
+			testutil.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
+
+func TestRunStartupChecksProvider(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer provider.Close()
+
+	opts := testOptions()
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("unexpected error validating options: %v", err)
+	}
+	opts.StartupChecks = startupChecksFail
+	opts.ProviderURLInternalString = provider.URL
+
+	err := RunStartupChecks(opts)
+	testutil.NotEqual(t, nil, err)
+	if !strings.HasSuffix(err.Error(), "failed: provider") {
+		t.Errorf("expected the provider check to fail, got %q", err.Error())
+	}
+}
+
+func TestValidateStartupChecks(t *testing.T) {
+	o := testOptions()
+	o.StartupChecks = "strict"
+	err := o.Validate()
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, errorMsg([]string{
+		`invalid value for startup-checks: "strict", must be fail, warn, or off`,
+	}), err.Error())
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultIndexFile = "index.html"
+
+// staticFilesHandler serves files from a root directory for static_files routes.
+// Unlike http.FileServer it never renders directory listings; directories are only
+// served through their index file.
+type staticFilesHandler struct {
+	root         http.FileSystem
+	indexFile    string
+	cacheControl string
+}
+
+// NewStaticFilesHandler returns an http.Handler serving the root directory of a StaticFilesRoute.
+func NewStaticFilesHandler(route *StaticFilesRoute, config *UpstreamConfig) (http.Handler, error) {
+	fi, err := os.Stat(route.Root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("static files root %q is not a directory", route.Root)
+	}
+
+	indexFile := config.IndexFile
+	if indexFile == "" {
+		indexFile = defaultIndexFile
+	}
+
+	// Responses are only served to authenticated users, so they must never be
+	// stored by shared caches.
+	cacheControl := "private, no-cache"
+	if config.CacheMaxAge > 0 {
+		cacheControl = fmt.Sprintf("private, max-age=%d", int64(config.CacheMaxAge/time.Second))
+	}
+
+	return &staticFilesHandler{
+		root:         http.Dir(route.Root),
+		indexFile:    indexFile,
+		cacheControl: cacheControl,
+	}, nil
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *staticFilesHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, HEAD")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := path.Clean("/" + req.URL.Path)
+	f, err := h.root.Open(name)
+	if err != nil {
+		h.serveError(rw, err)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		h.serveError(rw, err)
+		return
+	}
+
+	if fi.IsDir() {
+		// redirect to the canonical directory path so relative links in the index resolve. The
+		// redirect is relative, like http.FileServer's, so that a path such as //docs can't
+		// redirect to another host.
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			dest := path.Base(req.URL.Path) + "/"
+			if req.URL.RawQuery != "" {
+				dest += "?" + req.URL.RawQuery
+			}
+			http.Redirect(rw, req, dest, http.StatusMovedPermanently)
+			return
+		}
+
+		index, err := h.root.Open(path.Join(name, h.indexFile))
+		if err != nil {
+			h.serveError(rw, err)
+			return
+		}
+		defer index.Close()
+
+		fi, err = index.Stat()
+		if err != nil || fi.IsDir() {
+			http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		f = index
+	}
+
+	rw.Header().Set("Cache-Control", h.cacheControl)
+	// ServeContent handles content types, ranges, and Last-Modified validation.
+	http.ServeContent(rw, req, fi.Name(), fi.ModTime(), f)
+}
+
+func (h *staticFilesHandler) serveError(rw http.ResponseWriter, err error) {
+	switch {
+	case os.IsNotExist(err):
+		http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case os.IsPermission(err):
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func testStaticFilesRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "sso-static-files")
+	testutil.Ok(t, err)
+
+	files := map[string]string{
+		"index.html":      "<h1>home</h1>",
+		"docs/index.html": "<h1>docs</h1>",
+		"docs/style.css":  "body {}",
+		"empty/.keep":     "",
+	}
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		testutil.Ok(t, os.MkdirAll(filepath.Dir(path), 0755))
+		testutil.Ok(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	}
+	return root
+}
+
+func TestNewStaticFilesHandler(t *testing.T) {
+	root := testStaticFilesRoot(t)
+	defer os.RemoveAll(root)
+
+	_, err := NewStaticFilesHandler(&StaticFilesRoute{Root: filepath.Join(root, "missing")}, &UpstreamConfig{})
+	testutil.NotEqual(t, nil, err)
+
+	_, err = NewStaticFilesHandler(&StaticFilesRoute{Root: filepath.Join(root, "index.html")}, &UpstreamConfig{})
+	testutil.NotEqual(t, nil, err)
+}
+
+func TestStaticFilesHandler(t *testing.T) {
+	root := testStaticFilesRoot(t)
+	defer os.RemoveAll(root)
+
+	testCases := []struct {
+		name                 string
+		method               string
+		path                 string
+		config               *UpstreamConfig
+		expectedStatus       int
+		expectedBody         string
+		expectedLocation     string
+		expectedCacheControl string
+	}{
+		{
+			name:                 "serves root index",
+			path:                 "/",
+			config:               &UpstreamConfig{},
+			expectedStatus:       http.StatusOK,
+			expectedBody:         "<h1>home</h1>",
+			expectedCacheControl: "private, no-cache",
+		},
+		{
+			name:                 "serves file with cache max age",
+			path:                 "/docs/style.css",
+			config:               &UpstreamConfig{CacheMaxAge: 5 * time.Minute},
+			expectedStatus:       http.StatusOK,
+			expectedBody:         "body {}",
+			expectedCacheControl: "private, max-age=300",
+		},
+		{
+			name:             "redirects directories to trailing slash",
+			path:             "/docs?foo=bar",
+			config:           &UpstreamConfig{},
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/docs/?foo=bar",
+		},
+		{
+			name:             "redirects directories on the same host",
+			path:             "//docs",
+			config:           &UpstreamConfig{},
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/docs/",
+		},
+		{
+			name:             "redirects unclean directory paths",
+			path:             "/docs/../docs",
+			config:           &UpstreamConfig{},
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/docs/",
+		},
+		{
+			name:           "does not list directories without an index",
+			path:           "/empty/",
+			config:         &UpstreamConfig{},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:                 "serves configured index file",
+			path:                 "/docs/",
+			config:               &UpstreamConfig{IndexFile: "style.css"},
+			expectedStatus:       http.StatusOK,
+			expectedBody:         "body {}",
+			expectedCacheControl: "private, no-cache",
+		},
+		{
+			name:           "missing file",
+			path:           "/missing.html",
+			config:         &UpstreamConfig{},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "does not escape root",
+			path:           "/../../etc/passwd",
+			config:         &UpstreamConfig{},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "rejects non-GET methods",
+			method:         "POST",
+			path:           "/",
+			config:         &UpstreamConfig{},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, err := NewStaticFilesHandler(&StaticFilesRoute{Root: root}, tc.config)
+			testutil.Ok(t, err)
+
+			method := tc.method
+			if method == "" {
+				method = "GET"
+			}
+			req := httptest.NewRequest(method, "http://foo.example.com"+tc.path, nil)
+			rw := httptest.NewRecorder()
+
+			handler.ServeHTTP(rw, req)
+
+			testutil.Equal(t, tc.expectedStatus, rw.Code)
+			if tc.expectedBody != "" {
+				testutil.Equal(t, tc.expectedBody, rw.Body.String())
+			}
+			testutil.Equal(t, tc.expectedLocation, rw.Header().Get("Location"))
+			testutil.Equal(t, tc.expectedCacheControl, rw.Header().Get("Cache-Control"))
+		})
+	}
+}
@@ -110,7 +110,7 @@ footer {
       </header>
       <p>
         {{.Message}}<br>
-        <span class="details">HTTP {{.Code}}</span>
+        <span class="details">HTTP {{.Code}}{{if .RequestID}} &middot; Request ID {{.RequestID}}{{end}}</span>
       </p>
       {{if ne .Code 403 }}
         <form method="GET" action="/">
@@ -121,6 +121,417 @@ footer {
     <footer>Secured by <b>SSO</b></footer>
   </div>
 </body>
+</html>{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "impersonate.html"}}
+<!DOCTYPE html>
+<html lang="en" charset="utf-8">
+<head>
+  <title>Impersonate a user</title>
+<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no">
+<style>
+body {
+  font-family: "Helvetica Neue",Helvetica,Arial,sans-serif;
+  font-size: 1em;
+  line-height: 1.42857143;
+  color: #333;
+  background: #f0f0f0;
+}
+.container {
+  max-width: 40em;
+  margin: 10% auto;
+  padding: 2rem;
+  background-color: #fff;
+  border: 1px solid rgba(0,0,0,.125);
+  border-bottom-width: 4px;
+  border-radius: 4px;
+}
+form {
+  margin: 1.5em 0 0;
+}
+</style>
+</head>
+
+<body>
+  <div class="container">
+    <h1>Impersonate a user on {{.Host}}</h1>
+    {{if .Impersonating}}
+      <p>You are impersonating <b>{{.Impersonating}}</b>.</p>
+      <form method="POST" action="/oauth2/impersonate/stop">
+        <button>Stop impersonating</button>
+      </form>
+    {{end}}
+    <form method="POST" action="/oauth2/impersonate">
+      <input type="email" name="email" placeholder="user@example.com" required>
+      <button>Impersonate</button>
+    </form>
+  </div>
+</body>
+</html>{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "interstitial.html"}}
+<!DOCTYPE html>
+<html lang="en" charset="utf-8">
+<head>
+  <title>{{.Host}}</title>
+<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no">
+<style>
+body {
+  font-family: "Helvetica Neue",Helvetica,Arial,sans-serif;
+  font-size: 1em;
+  line-height: 1.42857143;
+  color: #333;
+  background: #f0f0f0;
+}
+.container {
+  max-width: 40em;
+  margin: 10% auto;
+  padding: 2rem;
+  background-color: #fff;
+  border: 1px solid rgba(0,0,0,.125);
+  border-bottom-width: 4px;
+  border-radius: 4px;
+}
+.message {
+  white-space: pre-line;
+}
+form {
+  margin: 1.5em 0 0;
+}
+</style>
+</head>
+
+<body>
+  <div class="container">
+    <h1>{{.Host}}</h1>
+    <p class="message">{{.Message}}</p>
+    <form method="POST" action="/oauth2/acknowledge">
+      <input type="hidden" name="rd" value="{{.Redirect}}">
+      <button>Acknowledge and continue</button>
+    </form>
+  </div>
+</body>
+</html>{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "post_replay.html"}}
+<!DOCTYPE html>
+<html lang="en" charset="utf-8">
+<head>
+  <title>{{.Host}}</title>
+<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no">
+<style>
+body {
+  font-family: "Helvetica Neue",Helvetica,Arial,sans-serif;
+  font-size: 1em;
+  line-height: 1.42857143;
+  color: #333;
+  background: #f0f0f0;
+}
+.container {
+  max-width: 40em;
+  margin: 10% auto;
+  padding: 2rem;
+  background-color: #fff;
+  border: 1px solid rgba(0,0,0,.125);
+  border-bottom-width: 4px;
+  border-radius: 4px;
+}
+</style>
+</head>
+
+<body>
+  <div class="container">
+    <h1>{{.Host}}</h1>
+    <p>You're signed in. Resubmitting the form you submitted before signing in&hellip;</p>
+    <form method="POST" action="{{.Action}}">
+      {{range .Fields}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">
+      {{end}}<noscript><button>Resubmit</button></noscript>
+    </form>
+  </div>
+  <script>document.forms[0].submit();</script>
+</body>
+</html>{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "silent_auth.html"}}
+<!DOCTYPE html>
+<html lang="en" charset="utf-8">
+<head>
+  <title>{{.Host}}</title>
+</head>
+
+<body>
+  <script>
+  (function() {
+    var result = {{.Result}};
+    if (window.parent !== window) {
+      window.parent.postMessage(result, window.location.origin);
+    } else {
+      // loaded outside of an iframe, there's no app to tell, so carry on to the app
+      window.location.replace("/");
+    }
+  })();
+  </script>
+</body>
+</html>{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "bot_challenge.html"}}
+<!DOCTYPE html>
+<html lang="en" charset="utf-8">
+<head>
+  <title>{{.Host}}</title>
+<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no">
+<style>
+body {
+  font-family: "Helvetica Neue",Helvetica,Arial,sans-serif;
+  font-size: 1em;
+  line-height: 1.42857143;
+  color: #333;
+  background: #f0f0f0;
+}
+.container {
+  max-width: 40em;
+  margin: 10% auto;
+  padding: 2rem;
+  background-color: #fff;
+  border: 1px solid rgba(0,0,0,.125);
+  border-bottom-width: 4px;
+  border-radius: 4px;
+}
+form {
+  margin: 1.5em 0 0;
+}
+</style>
+</head>
+
+<body>
+  <div class="container">
+    <h1>{{.Host}}</h1>
+    <p>Your request looks automated. Confirm that you are a person to continue.</p>
+    <form method="POST" action="/oauth2/bot_challenge">
+      <input type="hidden" name="token" value="{{.Token}}">
+      <input type="hidden" name="rd" value="{{.Redirect}}">
+      <button>Continue</button>
+    </form>
+  </div>
+</body>
+</html>{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "device.html"}}
+<!DOCTYPE html>
+<html lang="en" charset="utf-8">
+<head>
+  <title>Authorize a device</title>
+<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no">
+<style>
+body {
+  font-family: "Helvetica Neue",Helvetica,Arial,sans-serif;
+  font-size: 1em;
+  line-height: 1.42857143;
+  color: #333;
+  background: #f0f0f0;
+}
+.container {
+  max-width: 40em;
+  margin: 10% auto;
+  padding: 2rem;
+  background-color: #fff;
+  border: 1px solid rgba(0,0,0,.125);
+  border-bottom-width: 4px;
+  border-radius: 4px;
+}
+form {
+  margin: 1.5em 0 0;
+}
+</style>
+</head>
+
+<body>
+  <div class="container">
+    <h1>Authorize a device for {{.Host}}</h1>
+    {{if .Message}}
+      <p><b>{{.Message}}</b></p>
+    {{end}}
+    {{if not .Done}}
+      <p>Enter the code shown by your command line tool. Only approve codes you requested
+      yourself: the device will be able to access {{.Host}} as you.</p>
+      <form method="POST" action="/oauth2/device">
+        <input type="text" name="user_code" value="{{.UserCode}}" placeholder="XXXX-XXXX" autocomplete="off" required>
+        <button name="action" value="approve">Approve</button>
+        <button name="action" value="deny">Deny</button>
+      </form>
+    {{end}}
+  </div>
+</body>
+</html>{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "session.html"}}
+<!DOCTYPE html>
+<html lang="en" charset="utf-8">
+<head>
+  <title>Your session</title>
+<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no">
+<style>
+body {
+  font-family: "Helvetica Neue",Helvetica,Arial,sans-serif;
+  font-size: 1em;
+  line-height: 1.42857143;
+  color: #333;
+  background: #f0f0f0;
+}
+.container {
+  max-width: 40em;
+  margin: 10% auto;
+  padding: 2rem;
+  background-color: #fff;
+  border: 1px solid rgba(0,0,0,.125);
+  border-bottom-width: 4px;
+  border-radius: 4px;
+}
+th {
+  text-align: left;
+  padding-right: 2rem;
+}
+form {
+  margin: 1.5em 0 0;
+}
+</style>
+</head>
+
+<body>
+  <div class="container">
+    <h1>Your session</h1>
+    <table>
+      <tr><th>Email</th><td>{{.Email}}</td></tr>
+      {{if .ImpersonatedEmail}}<tr><th>Impersonating</th><td>{{.ImpersonatedEmail}}</td></tr>{{end}}
+      <tr><th>Provider</th><td>{{.Provider}}</td></tr>
+      <tr><th>Groups</th><td>{{range $i, $g := .Groups}}{{if $i}}, {{end}}{{$g}}{{else}}none{{end}}</td></tr>
+      <tr><th>Signed in</th><td>{{if .IssuedAt.IsZero}}unknown{{else}}{{.IssuedAt.UTC.Format "2006-01-02 15:04:05 MST"}}{{end}}</td></tr>
+      <tr><th>Expires</th><td>{{.LifetimeDeadline.UTC.Format "2006-01-02 15:04:05 MST"}} (in {{.ExpiresIn}})</td></tr>
+      <tr><th>Next refresh</th><td>{{.RefreshDeadline.UTC.Format "2006-01-02 15:04:05 MST"}} (in {{.RefreshesIn}})</td></tr>
+    </table>
+    <form method="POST" action="/oauth2/session/refresh">
+      <button>Refresh now</button>
+    </form>
+    <form method="GET" action="/oauth2/sign_out">
+      <button>Sign out</button>
+    </form>
+  </div>
+</body>
+</html>{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "portal.html"}}
+<!DOCTYPE html>
+<html lang="en" charset="utf-8">
+<head>
+  <title>Your applications</title>
+<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no">
+<style>
+body {
+  font-family: "Helvetica Neue",Helvetica,Arial,sans-serif;
+  font-size: 1em;
+  line-height: 1.42857143;
+  color: #333;
+  background: #f0f0f0;
+}
+.container {
+  max-width: 40em;
+  margin: 5% auto;
+  padding: 2rem;
+  background-color: #fff;
+  border: 1px solid rgba(0,0,0,.125);
+  border-bottom-width: 4px;
+  border-radius: 4px;
+}
+ul {
+  list-style: none;
+  padding: 0;
+}
+li a {
+  display: flex;
+  align-items: center;
+  padding: .75rem 0;
+  color: inherit;
+  text-decoration: none;
+  border-top: 1px solid #eee;
+}
+li img {
+  width: 2rem;
+  height: 2rem;
+  margin-right: 1rem;
+}
+.description {
+  font-size: .85rem;
+  color: #999;
+}
+</style>
+</head>
+
+<body>
+  <div class="container">
+    <h1>Your applications</h1>
+    <p>Signed in as {{.Email}}</p>
+    <ul>
+      {{range .Entries}}
+      <li>
+        <a href="{{.URL}}">
+          {{if .Icon}}<img src="{{.Icon}}" alt="">{{end}}
+          <div>
+            <div>{{.Name}}</div>
+            {{if .Description}}<div class="description">{{.Description}}</div>{{end}}
+          </div>
+        </a>
+      </li>
+      {{else}}
+      <li>You don't have access to any applications.</li>
+      {{end}}
+    </ul>
+    <form method="GET" action="/oauth2/sign_out">
+      <button>Sign out</button>
+    </form>
+  </div>
+</body>
+</html>{{end}}`))
+
+	t = template.Must(t.Parse(`{{define "bucket_listing.html"}}
+<!DOCTYPE html>
+<html lang="en" charset="utf-8">
+<head>
+  <title>Index of {{.Path}}</title>
+<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no">
+<style>
+body {
+  font-family: "Helvetica Neue",Helvetica,Arial,sans-serif;
+  font-size: 1em;
+  line-height: 1.42857143;
+  color: #333;
+  margin: 2rem;
+}
+table {
+  border-collapse: collapse;
+}
+td {
+  padding: .25rem 2rem .25rem 0;
+}
+.details {
+  font-size: .85rem;
+  color: #999;
+}
+</style>
+</head>
+
+<body>
+  <h1>Index of {{.Path}}</h1>
+  <table>
+    {{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>{{end}}
+    {{range .Entries}}
+    <tr>
+      <td><a href="{{.Name}}">{{.Name}}</a></td>
+      <td class="details">{{if not .IsDir}}{{.Size}}{{end}}</td>
+      <td class="details">{{if not .IsDir}}{{.LastModified}}{{end}}</td>
+    </tr>
+    {{end}}
+  </table>
+</body>
 </html>{{end}}`))
 	return t
 }
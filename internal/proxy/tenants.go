@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// tenantName matches the names of tenants, which are part of the names of their environment
+// variables.
+var tenantName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// TenantOptions are the settings of a tenant, which override those of the proxy. They're set by
+// environment variables prefixed with TENANT_<NAME>_, e.g. TENANT_PAYMENTS_HOST_SUFFIX, and any
+// that aren't set are inherited from the proxy.
+// HostSuffix - the domain the tenant's upstreams are served on, e.g. payments.example.com, required
+// ProviderURLString - the URL of the tenant's provider
+// ProviderURLInternalString - the internal URL of the tenant's provider
+// DefaultProviderSlug - the provider slug of the tenant's upstreams
+// ClientID - the OAuth Client ID of the tenant
+// ClientSecret - the OAuth Client Secret of the tenant
+// CookieName - the name of the tenant's cookie
+// CookieSecret - the seed string for the tenant's secure cookies
+// CookieDomain - the domain the tenant's cookies are set on, e.g. .payments.example.com
+// UpstreamConfigsFile - the path to the upstream configs file of the tenant, required
+// DefaultAllowedEmailDomains - csv list of email domains allowed by the tenant's upstreams by default
+// DefaultAllowedGroups - csv list of groups allowed by the tenant's upstreams by default
+type TenantOptions struct {
+	HostSuffix string `envconfig:"HOST_SUFFIX"`
+
+	ProviderURLString         string `envconfig:"PROVIDER_URL"`
+	ProviderURLInternalString string `envconfig:"PROVIDER_URL_INTERNAL"`
+	DefaultProviderSlug       string `envconfig:"DEFAULT_PROVIDER_SLUG"`
+	ClientID                  string `envconfig:"CLIENT_ID"`
+	ClientSecret              string `envconfig:"CLIENT_SECRET"`
+
+	CookieName   string `envconfig:"COOKIE_NAME"`
+	CookieSecret string `envconfig:"COOKIE_SECRET"`
+	CookieDomain string `envconfig:"COOKIE_DOMAIN"`
+
+	UpstreamConfigsFile        string   `envconfig:"UPSTREAM_CONFIGS"`
+	DefaultAllowedEmailDomains []string `envconfig:"DEFAULT_ALLOWED_EMAIL_DOMAINS"`
+	DefaultAllowedGroups       []string `envconfig:"DEFAULT_ALLOWED_GROUPS"`
+}
+
+// tenant is a logical tenant served by the proxy: the upstreams of a business unit, with its own
+// provider and cookies, served on the hosts of its domain.
+type tenant struct {
+	name       string
+	hostSuffix string
+	opts       *Options
+}
+
+// tenantOptions returns the settings of a tenant, from the environment unless they're given by a
+// test.
+func (o *Options) tenantOptions(name string) (*TenantOptions, error) {
+	if o.testTenantOptions != nil {
+		t, ok := o.testTenantOptions[name]
+		if !ok {
+			return &TenantOptions{}, nil
+		}
+		return t, nil
+	}
+	t := &TenantOptions{}
+	err := envconfig.Process("TENANT_"+strings.ToUpper(name), t)
+	return t, err
+}
+
+// newTenantOptions returns the options of a tenant: those of the proxy, overridden by the
+// tenant's settings. Tenants don't serve the portal or the default route of the proxy, whose
+// hosts and upstreams belong to the proxy's own upstreams.
+func newTenantOptions(o *Options, t *TenantOptions) *Options {
+	opts := *o
+	opts.Tenants = nil
+	opts.PortalHost = ""
+	opts.DefaultRouteAction = defaultRouteMisdirected
+	opts.DefaultRouteURL = ""
+	opts.DefaultRouteUpstream = ""
+	opts.testTenantOptions = nil
+	opts.upstreamConfigs = nil
+	opts.upstreamConfigsRaw = nil
+	opts.decodedCookieSecret = nil
+	opts.cookieCipher = nil
+	opts.spiffeSource = nil
+	opts.tenants = nil
+	opts.providerCalls = nil
+	opts.providerPool = nil
+
+	override := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	override(&opts.ProviderURLString, t.ProviderURLString)
+	override(&opts.ProviderURLInternalString, t.ProviderURLInternalString)
+	override(&opts.DefaultProviderSlug, t.DefaultProviderSlug)
+	override(&opts.ClientID, t.ClientID)
+	override(&opts.ClientSecret, t.ClientSecret)
+	override(&opts.CookieName, t.CookieName)
+	if t.CookieSecret != "" {
+		// a tenant's own cookie secret takes the place of the proxy's, however it's set
+		opts.CookieSecret = t.CookieSecret
+		opts.CookieSecretKMSKey = ""
+		opts.CookieSecretKMSCiphertextFile = ""
+	}
+	if t.CookieDomain != "" {
+		// a tenant's static domain takes the place of the proxy's, however it's set
+		opts.CookieDomain = t.CookieDomain
+		opts.CookieDeriveDomain = false
+	}
+	// the tenant's upstreams are never those of the proxy
+	opts.UpstreamConfigsFile = t.UpstreamConfigsFile
+	opts.UpstreamConfigsGitURL = ""
+	opts.gitConfigSource = nil
+	opts.UpstreamConfigsEtcdEndpoints = nil
+	opts.httpConfigSource = nil
+	opts.etcdConfigSource = nil
+	if len(t.DefaultAllowedEmailDomains) != 0 {
+		opts.DefaultAllowedEmailDomains = t.DefaultAllowedEmailDomains
+	}
+	if len(t.DefaultAllowedGroups) != 0 {
+		opts.DefaultAllowedGroups = t.DefaultAllowedGroups
+	}
+	return &opts
+}
+
+func validateTenants(o *Options, msgs []string) []string {
+	o.tenants = nil
+	suffixes := map[string]string{}
+	for _, name := range o.Tenants {
+		name = strings.TrimSpace(name)
+		if !tenantName.MatchString(name) {
+			msgs = append(msgs, fmt.Sprintf("invalid tenant name %q: must only contain lowercase letters, digits, and underscores", name))
+			continue
+		}
+
+		t, err := o.tenantOptions(name)
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("tenant %s: error parsing env vars: %s", name, err))
+			continue
+		}
+
+		suffix := strings.ToLower(strings.Trim(strings.TrimSpace(t.HostSuffix), "."))
+		if suffix == "" {
+			msgs = append(msgs, fmt.Sprintf("tenant %s: missing setting: host-suffix", name))
+			continue
+		}
+		if other, ok := suffixes[suffix]; ok {
+			msgs = append(msgs, fmt.Sprintf("tenant %s: host-suffix %s is already the host suffix of tenant %s", name, suffix, other))
+			continue
+		}
+		suffixes[suffix] = name
+
+		opts := newTenantOptions(o, t)
+		opts.tenantName = name
+		if opts.UpstreamConfigsFile != "" && opts.UpstreamConfigsFile == o.UpstreamConfigsFile {
+			msgs = append(msgs, fmt.Sprintf("tenant %s: upstream-configs must not be the upstream configs of the proxy", name))
+			continue
+		}
+		tenantMsgs := opts.validate()
+		// feature flags and metrics are shared with the proxy
+		opts.featureFlags = o.featureFlags
+		opts.StatsdClient = o.StatsdClient
+		for _, uc := range opts.upstreamConfigs {
+			if host := routeHost(uc.Route); host != "" && !hasHostSuffix(host, suffix) {
+				tenantMsgs = append(tenantMsgs, fmt.Sprintf(
+					"upstream %s is served on %s, which isn't within the tenant's host-suffix %s", uc.Service, host, suffix))
+			}
+		}
+		// every tenant is served by the same server
+		if opts.TCPWriteTimeout > o.TCPWriteTimeout {
+			o.TCPWriteTimeout = opts.TCPWriteTimeout
+		}
+		for _, msg := range tenantMsgs {
+			msgs = append(msgs, fmt.Sprintf("tenant %s: %s", name, msg))
+		}
+		o.tenants = append(o.tenants, &tenant{name: name, hostSuffix: suffix, opts: opts})
+	}
+	return msgs
+}
+
+// hasHostSuffix returns true if the host is the suffix itself or one of its subdomains.
+func hasHostSuffix(host, suffix string) bool {
+	host = strings.ToLower(host)
+	return host == suffix || strings.HasSuffix(host, "."+suffix)
+}
+
+// tenantRouter routes requests to the handler of the tenant whose host suffix matches their
+// host, or to the proxy's own handler if none does. When the suffixes of several tenants match,
+// the longest one wins, so that a business unit can carve out a subdomain of another's.
+type tenantRouter struct {
+	tenants  []tenantRoute
+	fallback http.Handler
+}
+
+type tenantRoute struct {
+	hostSuffix string
+	handler    http.Handler
+}
+
+func newTenantRouter(fallback http.Handler) *tenantRouter {
+	return &tenantRouter{fallback: fallback}
+}
+
+func (r *tenantRouter) handle(hostSuffix string, handler http.Handler) {
+	r.tenants = append(r.tenants, tenantRoute{hostSuffix: hostSuffix, handler: handler})
+	sort.SliceStable(r.tenants, func(i, j int) bool {
+		return len(r.tenants[i].hostSuffix) > len(r.tenants[j].hostSuffix)
+	})
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (r *tenantRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	host := req.Host
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	for _, t := range r.tenants {
+		if hasHostSuffix(host, t.hostSuffix) {
+			t.handler.ServeHTTP(rw, req)
+			return
+		}
+	}
+	r.fallback.ServeHTTP(rw, req)
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestValidateTenants(t *testing.T) {
+	o := testOptions()
+	o.CookieDomain = ".dev"
+	o.Tenants = []string{"payments"}
+	o.testTenantOptions = map[string]*TenantOptions{
+		"payments": {
+			HostSuffix:          ".payments.dev",
+			ProviderURLString:   "https://sso-auth.payments.dev",
+			ClientID:            "payments-client",
+			CookieDomain:        ".payments.dev",
+			UpstreamConfigsFile: "testdata/tenant_upstream_configs.yml",
+		},
+	}
+	err := o.Validate()
+	testutil.Ok(t, err)
+
+	testutil.Equal(t, 1, len(o.tenants))
+	tenant := o.tenants[0]
+	testutil.Equal(t, "payments", tenant.name)
+	testutil.Equal(t, "payments.dev", tenant.hostSuffix)
+
+	// the tenant's settings override those of the proxy, which it inherits otherwise
+	testutil.Equal(t, "https://sso-auth.payments.dev", tenant.opts.ProviderURLString)
+	testutil.Equal(t, "payments-client", tenant.opts.ClientID)
+	testutil.Equal(t, ".payments.dev", tenant.opts.CookieDomain)
+	testutil.Equal(t, o.ClientSecret, tenant.opts.ClientSecret)
+	testutil.Equal(t, o.CookieSecret, tenant.opts.CookieSecret)
+	testutil.Equal(t, 1, len(tenant.opts.upstreamConfigs))
+	testutil.Equal(t, "ledger", tenant.opts.upstreamConfigs[0].Service)
+
+	testutil.Equal(t, "bazquux", o.ClientID)
+	testutil.Equal(t, ".dev", o.CookieDomain)
+	testutil.Equal(t, "foo", o.upstreamConfigs[0].Service)
+}
+
+func TestValidateTenantsCookieDomain(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		cookieDomain         string
+		expectedCookieDomain string
+		expectedDeriveDomain bool
+	}{
+		{
+			name:                 "derived domain inherited from the proxy",
+			expectedDeriveDomain: true,
+		},
+		{
+			name:                 "static domain replaces the derived domain",
+			cookieDomain:         ".payments.dev",
+			expectedCookieDomain: ".payments.dev",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			o.CookieDeriveDomain = true
+			o.Tenants = []string{"payments"}
+			o.testTenantOptions = map[string]*TenantOptions{
+				"payments": {
+					HostSuffix:          "payments.dev",
+					CookieDomain:        tc.cookieDomain,
+					UpstreamConfigsFile: "testdata/tenant_upstream_configs.yml",
+				},
+			}
+			testutil.Ok(t, o.Validate())
+
+			testutil.Equal(t, 1, len(o.tenants))
+			testutil.Equal(t, tc.expectedCookieDomain, o.tenants[0].opts.CookieDomain)
+			testutil.Equal(t, tc.expectedDeriveDomain, o.tenants[0].opts.CookieDeriveDomain)
+		})
+	}
+}
+
+func TestValidateTenantsErrors(t *testing.T) {
+	testCases := []struct {
+		name        string
+		tenants     []string
+		options     map[string]*TenantOptions
+		expectedErr []string
+	}{
+		{
+			name:        "invalid name",
+			tenants:     []string{"Payments"},
+			expectedErr: []string{`invalid tenant name "Payments": must only contain lowercase letters, digits, and underscores`},
+		},
+		{
+			name:    "missing settings",
+			tenants: []string{"payments"},
+			options: map[string]*TenantOptions{
+				"payments": {HostSuffix: "payments.dev"},
+			},
+			expectedErr: []string{"tenant payments: missing setting: upstream-configs"},
+		},
+		{
+			name:        "missing host suffix",
+			tenants:     []string{"payments"},
+			expectedErr: []string{"tenant payments: missing setting: host-suffix"},
+		},
+		{
+			name:    "duplicate host suffix",
+			tenants: []string{"payments", "ledger"},
+			options: map[string]*TenantOptions{
+				"payments": {HostSuffix: "payments.dev", UpstreamConfigsFile: "testdata/tenant_upstream_configs.yml"},
+				"ledger":   {HostSuffix: ".payments.dev.", UpstreamConfigsFile: "testdata/tenant_upstream_configs.yml"},
+			},
+			expectedErr: []string{"tenant ledger: host-suffix payments.dev is already the host suffix of tenant payments"},
+		},
+		{
+			name:    "upstreams of the proxy",
+			tenants: []string{"payments"},
+			options: map[string]*TenantOptions{
+				"payments": {HostSuffix: "payments.dev", UpstreamConfigsFile: "testdata/upstream_configs.yml"},
+			},
+			expectedErr: []string{"tenant payments: upstream-configs must not be the upstream configs of the proxy"},
+		},
+		{
+			name:    "upstream outside the host suffix",
+			tenants: []string{"retail"},
+			options: map[string]*TenantOptions{
+				"retail": {HostSuffix: "retail.dev", UpstreamConfigsFile: "testdata/tenant_upstream_configs.yml"},
+			},
+			expectedErr: []string{
+				"tenant retail: upstream ledger is served on ledger.payments.dev, which isn't within the tenant's host-suffix retail.dev",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOptions()
+			o.Tenants = tc.tenants
+			o.testTenantOptions = tc.options
+			if o.testTenantOptions == nil {
+				o.testTenantOptions = map[string]*TenantOptions{}
+			}
+			err := o.Validate()
+			testutil.NotEqual(t, nil, err)
+			testutil.Equal(t, errorMsg(tc.expectedErr), err.Error())
+		})
+	}
+}
+
+func TestTenantRouter(t *testing.T) {
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte(name))
+		})
+	}
+	router := newTenantRouter(handler("proxy"))
+	router.handle("example.com", handler("example"))
+	router.handle("payments.example.com", handler("payments"))
+
+	testCases := map[string]string{
+		"wiki.sso.dev":                  "proxy",
+		"example.com":                   "example",
+		"wiki.example.com:8443":         "example",
+		"ledger.payments.example.com":   "payments",
+		"LEDGER.PAYMENTS.EXAMPLE.COM":   "payments",
+		"payments.example.com":          "payments",
+		"notpayments.example.com":       "example",
+		"ledger.payments.example.com.x": "proxy",
+		"badexample.com":                "proxy",
+	}
+	for host, expected := range testCases {
+		req := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		if rw.Body.String() != expected {
+			t.Errorf("expected %s to be routed to %s, got %s", host, expected, rw.Body.String())
+		}
+	}
+}
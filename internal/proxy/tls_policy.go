@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/buzzfeed/sso/internal/pkg/fips"
+	"github.com/buzzfeed/sso/internal/proxy/providers"
+)
+
+// tlsVersions are the TLS versions outbound connections may be restricted to, by name.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion returns the TLS version of a name, e.g. 1.2, or 0 for the default of crypto/tls
+// if the name is empty.
+func parseTLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("%q is not a TLS version, must be 1.0, 1.1, 1.2, or 1.3", name)
+	}
+	return version, nil
+}
+
+// parseCipherSuites returns the IDs of TLS 1.0-1.2 cipher suites, given their names, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Cipher suites with known security issues are refused,
+// as are those of TLS 1.3, which can't be configured.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	supported := map[string]*tls.CipherSuite{}
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		suite, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("%q is not a supported cipher suite", name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("%s is a TLS 1.3 cipher suite, which can't be configured", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// loadCABundle returns the system's root CAs, and the CA certificates of a PEM bundle file.
+func loadCABundle(file string) (*x509.CertPool, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s has no PEM encoded certificates", file)
+	}
+	return pool, nil
+}
+
+// parseSPKIPins returns the SHA-256 digests of the subject public key infos of pinned keys,
+// given their base64 encodings, optionally prefixed with sha256/ as in HPKP.
+func parseSPKIPins(pins []string) ([][]byte, error) {
+	digests := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+		digest, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("%q is not a base64 encoded SHA-256 digest", pin)
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// verifySPKIPins returns a VerifyPeerCertificate function that accepts connections whose
+// certificate chain includes one of the pinned keys. It's run after the chain is verified, so
+// pinning an intermediate or root CA's key pins every certificate it issues.
+func verifySPKIPins(digests [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		certs := []*x509.Certificate{}
+		for _, chain := range verifiedChains {
+			certs = append(certs, chain...)
+		}
+		if len(verifiedChains) == 0 {
+			// the chain isn't verified when certificate verification is skipped
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs = append(certs, cert)
+			}
+		}
+
+		for _, cert := range certs {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, digest := range digests {
+				if bytes.Equal(sum[:], digest) {
+					return nil
+				}
+			}
+		}
+		return errors.New("no certificate of the provider matches a pinned key")
+	}
+}
+
+// newTLSConfig returns the TLS config of outbound connections restricted to a minimum version and
+// cipher suites, and trusting the CAs of a bundle as well as the system's, or nil if none of them
+// are set.
+func newTLSConfig(minVersion uint16, cipherSuites []uint16, rootCAs *x509.CertPool) *tls.Config {
+	if minVersion == 0 && len(cipherSuites) == 0 && rootCAs == nil {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: cipherSuites,
+		RootCAs:      rootCAs,
+	}
+}
+
+// validateOutboundTLSOptions parses the outbound TLS settings that apply to every connection.
+func validateOutboundTLSOptions(o *Options, msgs []string) []string {
+	minVersion, err := parseTLSVersion(o.OutboundTLSMinVersion)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid value for outbound-tls-min-version: %s", err))
+	}
+	cipherSuites, err := parseCipherSuites(o.OutboundTLSCipherSuites)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid value for outbound-tls-cipher-suites: %s", err))
+	}
+	rootCAs, err := loadCABundle(o.OutboundTLSCABundle)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid value for outbound-tls-ca-bundle: %s", err))
+	}
+	o.outboundTLSConfig = newTLSConfig(minVersion, cipherSuites, rootCAs)
+
+	o.providerTLSPins, err = parseSPKIPins(o.ProviderTLSPins)
+	if err != nil {
+		msgs = append(msgs, fmt.Sprintf("invalid value for provider-tls-pins: %s", err))
+	}
+	return msgs
+}
+
+// configureOutboundTLS applies the outbound TLS settings to the connections of the default HTTP
+// transport, such as those to KMSs and webhooks, and to providers, whose certificates must also
+// match a pinned key if any are set. Upstreams apply the settings through their own configs.
+func configureOutboundTLS(opts *Options) {
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.TLSClientConfig = fips.TLSConfig(opts.outboundTLSConfig)
+	}
+
+	providerTLSConfig := opts.outboundTLSConfig
+	if len(opts.providerTLSPins) != 0 {
+		if providerTLSConfig == nil {
+			providerTLSConfig = &tls.Config{}
+		}
+		providerTLSConfig = providerTLSConfig.Clone()
+		providerTLSConfig.VerifyPeerCertificate = verifySPKIPins(opts.providerTLSPins)
+	}
+	providers.SetTLSConfig(fips.TLSConfig(providerTLSConfig))
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestParseTLSVersion(t *testing.T) {
+	testCases := []struct {
+		name    string
+		want    uint16
+		wantErr bool
+	}{
+		{name: "", want: 0},
+		{name: "1.0", want: tls.VersionTLS10},
+		{name: "1.2", want: tls.VersionTLS12},
+		{name: "1.3", want: tls.VersionTLS13},
+		{name: "TLS1.2", wantErr: true},
+		{name: "1.4", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseTLSVersion(tc.name)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	testCases := []struct {
+		name    string
+		names   []string
+		want    []uint16
+		wantErr bool
+	}{
+		{name: "unset", names: nil, want: nil},
+		{
+			name:  "supported cipher suites",
+			names: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"},
+			want:  []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		},
+		{name: "insecure cipher suite", names: []string{"TLS_RSA_WITH_RC4_128_SHA"}, wantErr: true},
+		{name: "TLS 1.3 cipher suite", names: []string{"TLS_AES_128_GCM_SHA256"}, wantErr: true},
+		{name: "unknown cipher suite", names: []string{"TLS_ECDHE_RSA_WITH_ROT13"}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCipherSuites(tc.names)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+				return
+			}
+			testutil.Ok(t, err)
+			testutil.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestLoadCABundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca-bundle")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	bundle := filepath.Join(dir, "bundle.pem")
+	err = ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	testutil.Ok(t, err)
+	empty := filepath.Join(dir, "empty.pem")
+	testutil.Ok(t, ioutil.WriteFile(empty, []byte("not a certificate"), 0600))
+
+	pool, err := loadCABundle("")
+	testutil.Ok(t, err)
+	testutil.Equal(t, (*x509.CertPool)(nil), pool)
+
+	_, err = loadCABundle(empty)
+	testutil.NotEqual(t, nil, err)
+	_, err = loadCABundle(filepath.Join(dir, "missing.pem"))
+	testutil.NotEqual(t, nil, err)
+
+	// the certificate of the test server is trusted through the bundle
+	pool, err = loadCABundle(bundle)
+	testutil.Ok(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get(server.URL)
+	testutil.Ok(t, err)
+	resp.Body.Close()
+}
+
+func TestSPKIPins(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	// the handshakes refused by the client are logged by the server
+	server.Config.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	sum := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+	otherPin := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	_, err := parseSPKIPins([]string{"sha256/" + base64.StdEncoding.EncodeToString(sum[:16])})
+	testutil.NotEqual(t, nil, err)
+	_, err = parseSPKIPins([]string{"not base64"})
+	testutil.NotEqual(t, nil, err)
+
+	testCases := []struct {
+		name    string
+		pins    []string
+		wantErr bool
+	}{
+		{name: "pinned key", pins: []string{otherPin, "sha256/" + pin}},
+		{name: "pinned key without prefix", pins: []string{pin}},
+		{name: "other key", pins: []string{otherPin}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			digests, err := parseSPKIPins(tc.pins)
+			testutil.Ok(t, err)
+
+			tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+			tlsConfig.VerifyPeerCertificate = verifySPKIPins(digests)
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			resp, err := client.Get(server.URL)
+			if tc.wantErr {
+				testutil.NotEqual(t, nil, err)
+				return
+			}
+			testutil.Ok(t, err)
+			resp.Body.Close()
+		})
+	}
+}
+
+func TestValidateOutboundTLSOptions(t *testing.T) {
+	o := testOptions()
+	o.OutboundTLSMinVersion = "1.4"
+	o.OutboundTLSCipherSuites = []string{"TLS_RSA_WITH_RC4_128_SHA"}
+	o.OutboundTLSCABundle = "testdata/missing.pem"
+	o.ProviderTLSPins = []string{"sha256/abc"}
+	err := o.Validate()
+	testutil.Equal(t, errorMsg([]string{
+		`error parsing upstream configs file invalid tls_min_version error="1.4" is not a TLS version, must be 1.0, 1.1, 1.2, or 1.3`,
+		`invalid value for outbound-tls-min-version: "1.4" is not a TLS version, must be 1.0, 1.1, 1.2, or 1.3`,
+		`invalid value for outbound-tls-cipher-suites: "TLS_RSA_WITH_RC4_128_SHA" is not a supported cipher suite`,
+		"invalid value for outbound-tls-ca-bundle: open testdata/missing.pem: no such file or directory",
+		`invalid value for provider-tls-pins: "abc" is not a base64 encoded SHA-256 digest`,
+	}), err.Error())
+
+	// upstreams inherit the outbound TLS settings, and can override them
+	o = testOptions()
+	o.OutboundTLSMinVersion = "1.2"
+	o.OutboundTLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+	testutil.Ok(t, o.Validate())
+	testutil.Equal(t, uint16(tls.VersionTLS12), o.outboundTLSConfig.MinVersion)
+
+	o.upstreamConfigs, err = o.loadUpstreamConfigs([]byte(`
+- service: foo
+  default:
+    from: foo.{{cluster}}.{{root_domain}}
+    to: foo.{{cluster}}.svc.cluster.local
+- service: bar
+  default:
+    from: bar.{{cluster}}.{{root_domain}}
+    to: bar.{{cluster}}.svc.cluster.local
+    options:
+      tls_min_version: "1.3"
+`))
+	testutil.Ok(t, err)
+	testutil.Equal(t, uint16(tls.VersionTLS12), o.upstreamConfigs[0].TLSMinVersion)
+	testutil.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, o.upstreamConfigs[0].TLSCipherSuites)
+	testutil.Equal(t, uint16(tls.VersionTLS13), o.upstreamConfigs[1].TLSMinVersion)
+
+	transport := newUpstreamTransport(o.upstreamConfigs[1]).getTransport()
+	testutil.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+}
@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/buzzfeed/sso/internal/pkg/options"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+)
+
+// Decisions of a traced request.
+const (
+	traceAllow          = "allow"
+	traceAllowAnonymous = "allow_unauthenticated"
+	traceDeny           = "deny"
+	traceAuthenticate   = "authenticate"
+	traceUnmatched      = "unmatched"
+)
+
+// Results of the policies applied to a traced request.
+const (
+	tracePolicyPass         = "pass"
+	tracePolicyFail         = "fail"
+	tracePolicyMatch        = "match"
+	tracePolicyNoMatch      = "no_match"
+	tracePolicyRequired     = "required"
+	tracePolicyNotEvaluated = "not_evaluated"
+)
+
+// traceAccessTokenHint stands in for the access token of a traced request's user.
+const traceAccessTokenHint = "<access token>"
+
+// traceIdentityKey is the context key of the identity a traced request is made as.
+type traceIdentityKey struct{}
+
+// traceRequest is a hypothetical request to trace through the proxy's routing and
+// authorization rules.
+type traceRequest struct {
+	Host   string   `json:"host"`
+	Path   string   `json:"path"`
+	Method string   `json:"method"`
+	Email  string   `json:"email"`
+	Groups []string `json:"groups"`
+}
+
+// tracePolicy is the outcome of a policy applied to a traced request.
+type tracePolicy struct {
+	Name   string `json:"name"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// traceResult describes how the proxy would handle a traced request.
+type traceResult struct {
+	Upstream        string            `json:"upstream,omitempty"`
+	Cluster         string            `json:"cluster,omitempty"`
+	Route           string            `json:"route,omitempty"`
+	Target          string            `json:"target,omitempty"`
+	Policies        []tracePolicy     `json:"policies"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	Signed          bool              `json:"signed"`
+	Decision        string            `json:"decision"`
+	Reason          string            `json:"reason,omitempty"`
+}
+
+// newTraceRequest returns the http.Request a traced request is routed as.
+func newTraceRequest(tr *traceRequest) (*http.Request, error) {
+	if tr.Host == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	if tr.Method == "" {
+		tr.Method = http.MethodGet
+	}
+	if tr.Path == "" {
+		tr.Path = "/"
+	}
+
+	req, err := http.NewRequest(strings.ToUpper(tr.Method), "http://"+tr.Host+tr.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return req.WithContext(context.WithValue(req.Context(), traceIdentityKey{}, tr)), nil
+}
+
+// newDefaultRouteTraceHandler returns the handler tracing requests that match no upstream,
+// according to the configured default route.
+func newDefaultRouteTraceHandler(opts *Options, upstreams map[string]http.Handler) http.Handler {
+	if opts.DefaultRouteAction == defaultRouteUpstream {
+		if handler, ok := upstreams[opts.DefaultRouteUpstream]; ok {
+			return handler
+		}
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		writeJSON(rw, http.StatusOK, &traceResult{
+			Policies: []tracePolicy{},
+			Decision: traceUnmatched,
+			Reason:   fmt.Sprintf("no upstream matches the host, the default route action is %q", opts.DefaultRouteAction),
+		})
+	})
+}
+
+// Trace reports how the upstream would handle a hypothetical request, made as the identity
+// the request was created with by newTraceRequest, without authenticating or proxying it.
+func (p *OAuthProxy) Trace(rw http.ResponseWriter, req *http.Request) {
+	tr, _ := req.Context().Value(traceIdentityKey{}).(*traceRequest)
+	if tr == nil {
+		http.NotFound(rw, req)
+		return
+	}
+	writeJSON(rw, http.StatusOK, p.trace(req, tr))
+}
+
+func (p *OAuthProxy) trace(req *http.Request, tr *traceRequest) *traceResult {
+	config := p.upstreamConfig
+	result := &traceResult{
+		Upstream:        config.Service,
+		Cluster:         config.Cluster,
+		Route:           config.RouteConfig.From,
+		Target:          traceTarget(config, req),
+		Policies:        []tracePolicy{},
+		ResponseHeaders: config.HeaderOverrides,
+		Signed:          p.requestSigner != nil && !config.SkipRequestSigning && p.portal == nil,
+	}
+
+	if p.isSkippedPreflight(req) {
+		result.Policies = append(result.Policies, tracePolicy{Name: "skip_auth_preflight", Result: tracePolicyMatch})
+		result.Decision = traceAllowAnonymous
+		return result
+	}
+
+	for _, re := range config.SkipAuthCompiledRegex {
+		if re.MatchString(req.URL.Path) {
+			result.Policies = append(result.Policies, tracePolicy{
+				Name:   "skip_auth_regex",
+				Result: tracePolicyMatch,
+				Detail: re.String(),
+			})
+			result.Decision = traceAllowAnonymous
+			return result
+		}
+	}
+	if len(config.SkipAuthCompiledRegex) != 0 {
+		result.Policies = append(result.Policies, tracePolicy{Name: "skip_auth_regex", Result: tracePolicyNoMatch})
+	}
+
+	if len(config.AllowedCountries) != 0 || len(config.DeniedCountries) != 0 {
+		result.Policies = append(result.Policies, tracePolicy{
+			Name:   "countries",
+			Result: tracePolicyNotEvaluated,
+			Detail: "depends on the location of the client",
+		})
+	}
+
+	if tr.Email == "" {
+		result.Decision = traceAuthenticate
+		result.Reason = "the request has no identity, so the user would be asked to sign in"
+		return result
+	}
+
+	session := &sessions.SessionState{
+		Email:  tr.Email,
+		User:   strings.Split(tr.Email, "@")[0],
+		Groups: tr.Groups,
+	}
+
+	// like authenticate, every email validator must pass, while group membership, which is
+	// usually checked with the provider, is checked against the groups of the request.
+	authorized := true
+	for _, v := range p.Validators {
+		policy := tracePolicy{Result: tracePolicyPass}
+		switch v := v.(type) {
+		case options.EmailGroupValidator:
+			policy.Name = "allowed_groups"
+			matched := matchGroups(tr.Groups, v.AllowedGroups)
+			if len(matched) == 0 {
+				policy.Result = tracePolicyFail
+				policy.Detail = options.ErrGroupMembership.Error()
+			}
+			session.Groups = matched
+		case options.EmailAddressValidator:
+			policy.Name = "allowed_email_addresses"
+			if err := v.Validate(session); err != nil {
+				policy.Result = tracePolicyFail
+				policy.Detail = err.Error()
+			}
+		case options.EmailDomainValidator:
+			policy.Name = "allowed_email_domains"
+			if err := v.Validate(session); err != nil {
+				policy.Result = tracePolicyFail
+				policy.Detail = err.Error()
+			}
+		default:
+			policy.Name = fmt.Sprintf("%T", v)
+			if err := v.Validate(session); err != nil {
+				policy.Result = tracePolicyFail
+				policy.Detail = err.Error()
+			}
+		}
+		if policy.Result == tracePolicyFail {
+			authorized = false
+		}
+		result.Policies = append(result.Policies, policy)
+	}
+
+	if !authorized {
+		result.Decision = traceDeny
+		result.Reason = "the user is not authorized to access the upstream"
+		return result
+	}
+
+	if config.Interstitial != "" {
+		result.Policies = append(result.Policies, tracePolicy{
+			Name:   "interstitial",
+			Result: tracePolicyRequired,
+			Detail: "the user must acknowledge the upstream's notice once in their browser",
+		})
+	}
+
+	result.Headers = map[string]string{}
+	for key, val := range config.InjectRequestHeaders {
+		result.Headers[key] = val
+	}
+	result.Headers["X-Forwarded-User"] = session.User
+	result.Headers["X-Forwarded-Email"] = session.Email
+	result.Headers["X-Forwarded-Groups"] = strings.Join(session.Groups, ",")
+	if p.passAccessToken {
+		result.Headers["X-Forwarded-Access-Token"] = traceAccessTokenHint
+	}
+
+	result.Decision = traceAllow
+	return result
+}
+
+// traceTarget returns where a request to an upstream would be sent.
+func traceTarget(config *UpstreamConfig, req *http.Request) string {
+	switch route := config.Route.(type) {
+	case *SimpleRoute:
+		if route.ToURL != nil {
+			return route.ToURL.String()
+		}
+	case *RewriteRoute:
+		rewritten := route.FromRegex.ReplaceAllString(req.Host, route.ToTemplate.Opaque)
+		if target, err := urlParse(route.ToTemplate.Scheme, rewritten); err == nil {
+			return target.String()
+		}
+	case *StaticFilesRoute:
+		return route.Root
+	case *BucketRoute:
+		return fmt.Sprintf("%s://%s/%s", route.Provider, route.Bucket, route.Prefix)
+	}
+	return ""
+}
+
+// matchGroups returns the groups that are allowed.
+func matchGroups(groups, allowedGroups []string) []string {
+	matched := []string{}
+	for _, group := range groups {
+		for _, allowed := range allowedGroups {
+			if group == allowed {
+				matched = append(matched, group)
+				break
+			}
+		}
+	}
+	return matched
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/hostmux"
+	"github.com/buzzfeed/sso/internal/pkg/options"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestAdminHandlerTrace(t *testing.T) {
+	upstreamConfig := &UpstreamConfig{
+		Service:     "wiki",
+		RouteConfig: RouteConfig{From: "wiki.sso.dev"},
+		Route: &SimpleRoute{
+			FromURL: &url.URL{Scheme: "https", Host: "wiki.sso.dev"},
+			ToURL:   &url.URL{Scheme: "http", Host: "wiki.internal"},
+		},
+		SkipAuthCompiledRegex: []*regexp.Regexp{regexp.MustCompile("^/health$")},
+		InjectRequestHeaders:  map[string]string{"X-Team": "docs"},
+	}
+	proxy, close := testNewOAuthProxy(t,
+		SetUpstreamConfig(upstreamConfig),
+		SetValidators([]options.Validator{
+			options.NewEmailDomainValidator([]string{"gsa.gov"}),
+			options.NewEmailGroupValidator(nil, []string{"writers", "admins"}),
+		}),
+	)
+	defer close()
+
+	router := hostmux.NewRouter()
+	router.HandleStatic("wiki.sso.dev", http.HandlerFunc(proxy.Trace))
+	router.HandleDefault(newDefaultRouteTraceHandler(NewOptions(), nil))
+	handler := newAdminHandler(adminConfig{token: "admin-token", tracer: router})
+
+	testCases := []struct {
+		name           string
+		request        string
+		wantDecision   string
+		wantPolicies   []tracePolicy
+		wantHeaders    map[string]string
+		wantCode       int
+		wantUnmatched  bool
+		wantBodyPrefix string
+	}{
+		{
+			name:         "authorized user",
+			request:      `{"host": "wiki.sso.dev", "path": "/page", "email": "jane@gsa.gov", "groups": ["readers", "writers"]}`,
+			wantDecision: traceAllow,
+			wantPolicies: []tracePolicy{
+				{Name: "skip_auth_regex", Result: tracePolicyNoMatch},
+				{Name: "allowed_email_domains", Result: tracePolicyPass},
+				{Name: "allowed_groups", Result: tracePolicyPass},
+			},
+			wantHeaders: map[string]string{
+				"X-Team":             "docs",
+				"X-Forwarded-User":   "jane",
+				"X-Forwarded-Email":  "jane@gsa.gov",
+				"X-Forwarded-Groups": "writers",
+			},
+		},
+		{
+			name:         "user outside allowed groups",
+			request:      `{"host": "wiki.sso.dev", "email": "jane@gsa.gov", "groups": ["readers"]}`,
+			wantDecision: traceDeny,
+			wantPolicies: []tracePolicy{
+				{Name: "skip_auth_regex", Result: tracePolicyNoMatch},
+				{Name: "allowed_email_domains", Result: tracePolicyPass},
+				{Name: "allowed_groups", Result: tracePolicyFail, Detail: "Invalid Group Membership"},
+			},
+		},
+		{
+			name:         "skipped authentication",
+			request:      `{"host": "wiki.sso.dev", "path": "/health"}`,
+			wantDecision: traceAllowAnonymous,
+			wantPolicies: []tracePolicy{
+				{Name: "skip_auth_regex", Result: tracePolicyMatch, Detail: "^/health$"},
+			},
+		},
+		{
+			name:         "anonymous user",
+			request:      `{"host": "wiki.sso.dev", "method": "post"}`,
+			wantDecision: traceAuthenticate,
+			wantPolicies: []tracePolicy{
+				{Name: "skip_auth_regex", Result: tracePolicyNoMatch},
+			},
+		},
+		{
+			name:          "unknown host",
+			request:       `{"host": "unknown.sso.dev", "email": "jane@gsa.gov"}`,
+			wantDecision:  traceUnmatched,
+			wantPolicies:  []tracePolicy{},
+			wantUnmatched: true,
+		},
+		{
+			name:           "missing host",
+			request:        `{"email": "jane@gsa.gov"}`,
+			wantCode:       http.StatusBadRequest,
+			wantBodyPrefix: "invalid trace request: missing host",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://localhost/admin/trace", strings.NewReader(tc.request))
+			req.Header.Set("Authorization", "Bearer admin-token")
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+
+			if tc.wantCode != 0 {
+				testutil.Equal(t, tc.wantCode, rw.Code)
+				testutil.Assert(t, strings.HasPrefix(rw.Body.String(), tc.wantBodyPrefix), "unexpected body: %s", rw.Body.String())
+				return
+			}
+			testutil.Equal(t, http.StatusOK, rw.Code)
+
+			result := &traceResult{}
+			err := json.Unmarshal(rw.Body.Bytes(), result)
+			testutil.Assert(t, err == nil, "could not decode trace result: %s", err)
+			testutil.Equal(t, tc.wantDecision, result.Decision)
+			testutil.Equal(t, tc.wantPolicies, result.Policies)
+			testutil.Equal(t, tc.wantHeaders, result.Headers)
+			if !tc.wantUnmatched {
+				testutil.Equal(t, "wiki", result.Upstream)
+				testutil.Equal(t, "http://wiki.internal", result.Target)
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "http://localhost/admin/trace", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+	testutil.Equal(t, http.StatusMethodNotAllowed, rw.Code)
+}
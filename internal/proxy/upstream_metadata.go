@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/datadog/datadog-go/statsd"
+)
+
+// Used to stash the metadata of the upstream a request is routed to in the response for access
+// when logging requests.
+const (
+	loggingTeamHeader        = "SSO-Team"
+	loggingTierHeader        = "SSO-Tier"
+	loggingServiceNameHeader = "SSO-Service-Name"
+)
+
+// MetadataConfig maps to the yaml config fields of the metadata of an upstream, which are
+// attached to the metrics and logs of its requests, so that their cost and errors can be
+// attributed to the team that owns it:
+// * team - the team that owns the upstream
+// * tier - the tier of the upstream, e.g. critical
+// * service_name - the name of the service the upstream belongs to, if it differs from its sso service
+type MetadataConfig struct {
+	Team        string `yaml:"team"`
+	Tier        string `yaml:"tier"`
+	ServiceName string `yaml:"service_name"`
+}
+
+func (c *MetadataConfig) validate() error {
+	fields := []struct{ name, value string }{
+		{"team", c.Team},
+		{"tier", c.Tier},
+		{"service_name", c.ServiceName},
+	}
+	for _, field := range fields {
+		// the values are sent as statsd tags, which can't contain these characters
+		if strings.ContainsAny(field.value, ",|#:\n") {
+			return fmt.Errorf("invalid %s %q: must not contain any of , | # : or newlines", field.name, field.value)
+		}
+	}
+	return nil
+}
+
+// tags returns the statsd tags of the metadata.
+func (c *MetadataConfig) tags() []string {
+	tags := []string{}
+	if c.Team != "" {
+		tags = append(tags, "team:"+c.Team)
+	}
+	if c.Tier != "" {
+		tags = append(tags, "tier:"+c.Tier)
+	}
+	if c.ServiceName != "" {
+		tags = append(tags, "service_name:"+c.ServiceName)
+	}
+	return tags
+}
+
+// newUpstreamStatsdClient returns the statsd client of an upstream, whose metrics are tagged
+// with its metadata. Upstreams without metadata share the proxy's client.
+func newUpstreamStatsdClient(opts *Options, upstreamConfig *UpstreamConfig) (*statsd.Client, error) {
+	if upstreamConfig.Metadata == nil || opts.StatsdClient == nil {
+		return opts.StatsdClient, nil
+	}
+
+	client, err := statsd.New(net.JoinHostPort(opts.StatsdHost, strconv.Itoa(opts.StatsdPort)))
+	if err != nil {
+		return nil, err
+	}
+	client.Namespace = opts.StatsdClient.Namespace
+	client.Tags = append(append([]string{}, opts.StatsdClient.Tags...), upstreamConfig.Metadata.tags()...)
+	return client, nil
+}
+
+// setUpstreamMetadata records the metadata of the upstream for the request log.
+func (p *OAuthProxy) setUpstreamMetadata(h http.Handler) http.Handler {
+	metadata := p.upstreamConfig.Metadata
+	if metadata == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// stash the metadata so that it can be logged later (see func logRequest)
+		if metadata.Team != "" {
+			rw.Header().Set(loggingTeamHeader, metadata.Team)
+		}
+		if metadata.Tier != "" {
+			rw.Header().Set(loggingTierHeader, metadata.Tier)
+		}
+		if metadata.ServiceName != "" {
+			rw.Header().Set(loggingServiceNameHeader, metadata.ServiceName)
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestParseMetadataConfig(t *testing.T) {
+	upstreamConfig := &UpstreamConfig{
+		RouteConfig: RouteConfig{
+			Options: &OptionsConfig{
+				Metadata: &MetadataConfig{Team: "payments,billing"},
+			},
+		},
+	}
+	err := parseOptionsConfig(upstreamConfig, nil)
+	testutil.NotEqual(t, nil, err)
+	testutil.Equal(t, `invalid metadata error=invalid team "payments,billing": must not contain any of , | # : or newlines`, err.Error())
+
+	upstreamConfig.RouteConfig.Options = &OptionsConfig{
+		Metadata: &MetadataConfig{Team: "payments", ServiceName: "checkout"},
+	}
+	testutil.Ok(t, parseOptionsConfig(upstreamConfig, nil))
+	testutil.Equal(t, []string{"team:payments", "service_name:checkout"}, upstreamConfig.Metadata.tags())
+}
+
+func TestUpstreamMetadataLogged(t *testing.T) {
+	p := &OAuthProxy{upstreamConfig: &UpstreamConfig{
+		Metadata: &MetadataConfig{Team: "payments", Tier: "critical", ServiceName: "checkout"},
+	}}
+	handler := p.setUpstreamMetadata(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	rw := httptest.NewRecorder()
+	logger := &responseLogger{w: rw}
+	handler.ServeHTTP(logger, httptest.NewRequest("GET", "/", nil))
+
+	testutil.Equal(t, MetadataConfig{Team: "payments", Tier: "critical", ServiceName: "checkout"}, logger.metadata)
+	// the metadata is stashed for the request log, but isn't sent to clients
+	for _, header := range []string{loggingTeamHeader, loggingTierHeader, loggingServiceNameHeader} {
+		testutil.Equal(t, "", rw.Header().Get(header))
+	}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// upstreamConfigSource is a source of upstream configs whose changes are applied without a
+// restart.
+type upstreamConfigSource interface {
+	// run watches the source, forever, and applies the contents of the upstream configs file
+	// whenever they change.
+	run(apply func(raw []byte) error)
+}
+
+// upstreamReloader serves requests with the routing of the latest upstream configs it was given,
+// so that validated changes to the configs are applied without a restart. Configs that don't
+// validate, or whose routing can't be built, are rejected, and the running routing is kept.
+type upstreamReloader struct {
+	opts       *Options
+	components *proxyComponents
+
+	mu      sync.RWMutex
+	routing *upstreamRouting
+	configs []*UpstreamConfig
+
+	handler   http.Handler
+	authorize http.Handler
+	admin     http.Handler
+}
+
+// newUpstreamReloader returns the upstreamReloader of a proxy, serving its initial routing.
+func newUpstreamReloader(opts *Options, components *proxyComponents, routing *upstreamRouting) *upstreamReloader {
+	r := &upstreamReloader{
+		opts:       opts,
+		components: components,
+		routing:    routing,
+		configs:    opts.upstreamConfigs,
+	}
+	r.handler = r.serve(func(routing *upstreamRouting) http.Handler { return routing.handler })
+	r.authorize = r.serve(func(routing *upstreamRouting) http.Handler { return routing.authorize })
+	r.admin = r.serve(func(routing *upstreamRouting) http.Handler { return routing.admin })
+	return r
+}
+
+// serve returns an http.Handler serving requests with one of the handlers of the current routing.
+func (r *upstreamReloader) serve(handler func(*upstreamRouting) http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		r.mu.RLock()
+		h := handler(r.routing)
+		r.mu.RUnlock()
+		if h == nil {
+			http.NotFound(rw, req)
+			return
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
+
+// reload validates the contents of an upstream configs file, and replaces the running routing
+// with theirs.
+func (r *upstreamReloader) reload(raw []byte) error {
+	configs, err := r.apply(raw)
+	r.components.configMetrics.reloaded(configs, err)
+	if err == nil && r.components.exporter != nil {
+		r.components.exporter.loaded(configs)
+	}
+	return err
+}
+
+// apply replaces the running routing with that of the contents of an upstream configs file, and
+// returns their configs, if they validate.
+func (r *upstreamReloader) apply(raw []byte) ([]*UpstreamConfig, error) {
+	configs, err := r.opts.loadUpstreamConfigs(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing upstream configs file %s", err)
+	}
+	if err := r.opts.validateUpstreamEnvironments(raw); err != nil {
+		return nil, fmt.Errorf("error validating upstream configs environments %s", err)
+	}
+
+	// the configs are validated and routed with a copy of the options, so that the running
+	// upstreams are untouched until the new ones are ready
+	opts := *r.opts
+	opts.upstreamConfigs = configs
+	opts.upstreamConfigsRaw = raw
+	// the server's write timeout is fixed when it starts, so it can't be raised for new upstreams
+	timeout := opts.TCPWriteTimeout
+	msgs := validateUpstreamConfigs(&opts, nil)
+	msgs = validateDefaultRouteOptions(&opts, msgs)
+	msgs = validatePortalOptions(&opts, msgs)
+	if opts.TCPWriteTimeout > timeout {
+		msgs = append(msgs, fmt.Sprintf(
+			"invalid setting: upstream timeouts must not exceed the running TCP_WRITE_TIMEOUT of %s without a restart", timeout))
+	}
+	if len(msgs) != 0 {
+		return nil, fmt.Errorf("invalid upstream configs:\n  %s", strings.Join(msgs, "\n  "))
+	}
+
+	routing, err := newUpstreamRouting(&opts, r.components)
+	if err != nil {
+		return nil, fmt.Errorf("error routing upstream configs: %s", err)
+	}
+
+	r.mu.Lock()
+	previous, previousConfigs := r.routing, r.configs
+	r.routing, r.configs = routing, configs
+	r.mu.Unlock()
+	previous.stop()
+	logUpstreamConfigDiff(previousConfigs, configs)
+	return configs, nil
+}
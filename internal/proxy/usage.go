@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+)
+
+// usageSaveInterval is the interval at which usage is saved to USAGE_FILE, if it changed.
+const usageSaveInterval = time.Minute
+
+// usageRecord is the usage of the upstreams of a service by a user.
+type usageRecord struct {
+	Email       string    `json:"email"`
+	Service     string    `json:"service"`
+	Requests    int64     `json:"requests"`
+	FirstAccess time.Time `json:"first_access"`
+	LastAccess  time.Time `json:"last_access"`
+}
+
+type usageKey struct {
+	email   string
+	service string
+}
+
+// usageTracker counts the authenticated requests of each user to each service, so that the
+// owners of services can see who uses them. Usage is kept in memory, and saved to a file so
+// that it survives restarts, if one is given.
+type usageTracker struct {
+	path      string
+	retention time.Duration
+
+	mu      sync.Mutex
+	records map[usageKey]*usageRecord
+	changed bool
+}
+
+// newUsageTracker returns the tracker of usage with USAGE_REPORTING, loading the usage saved to
+// USAGE_FILE, or nil without it.
+func newUsageTracker(opts *Options) (*usageTracker, error) {
+	if !opts.UsageReporting {
+		return nil, nil
+	}
+
+	u := &usageTracker{
+		path:      opts.UsageFile,
+		retention: opts.AuditRetention,
+		records:   make(map[usageKey]*usageRecord),
+	}
+	if u.path == "" {
+		return u, nil
+	}
+
+	raw, err := ioutil.ReadFile(u.path)
+	if os.IsNotExist(err) {
+		return u, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading usage file: %s", err)
+	}
+	saved := []*usageRecord{}
+	if err := json.Unmarshal(raw, &saved); err != nil {
+		return nil, fmt.Errorf("error decoding usage file %s: %s", u.path, err)
+	}
+	for _, record := range saved {
+		u.records[usageKey{email: strings.ToLower(record.Email), service: record.Service}] = record
+	}
+	return u, nil
+}
+
+// SetUsageTracker sets the tracker of the usage of upstreams.
+func SetUsageTracker(u *usageTracker) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.usage = u
+		return nil
+	}
+}
+
+// recordUsage counts an authenticated request to the upstream.
+func (p *OAuthProxy) recordUsage(session *sessions.SessionState) {
+	if p.usage == nil || session == nil || session.Email == "" {
+		return
+	}
+	p.usage.record(session.Email, p.upstreamConfig.Service, time.Now())
+}
+
+// record counts a request of a user to a service.
+func (u *usageTracker) record(email, service string, now time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	key := usageKey{email: strings.ToLower(email), service: service}
+	record, ok := u.records[key]
+	if !ok {
+		record = &usageRecord{Email: email, Service: service, FirstAccess: now}
+		u.records[key] = record
+	}
+	record.Requests++
+	record.LastAccess = now
+	u.changed = true
+}
+
+// report returns the usage of a service, or of every service if service is "", and of a user,
+// or of every user if email is "", ordered by service and then by the most recent access.
+func (u *usageTracker) report(service, email string, now time.Time) []usageRecord {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.prune(now)
+
+	records := []usageRecord{}
+	for _, record := range u.records {
+		if service != "" && record.Service != service {
+			continue
+		}
+		if email != "" && !strings.EqualFold(record.Email, email) {
+			continue
+		}
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Service != records[j].Service {
+			return records[i].Service < records[j].Service
+		}
+		return records[i].LastAccess.After(records[j].LastAccess)
+	})
+	return records
+}
+
+// purgeUser forgets the usage of a user, returning the number of services it was recorded for.
+func (u *usageTracker) purgeUser(email string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	purged := 0
+	for key, record := range u.records {
+		if strings.EqualFold(record.Email, email) {
+			delete(u.records, key)
+			purged++
+		}
+	}
+	if purged != 0 {
+		u.changed = true
+	}
+	return purged
+}
+
+// prune forgets the usage last recorded longer than the retention ago, and must be called with
+// the lock held.
+func (u *usageTracker) prune(now time.Time) {
+	if u.retention == 0 {
+		return
+	}
+	for key, record := range u.records {
+		if now.Sub(record.LastAccess) > u.retention {
+			delete(u.records, key)
+			u.changed = true
+		}
+	}
+}
+
+// save writes the usage to the usage file, if it changed since it was last saved. The file is
+// replaced whole, so that it's never left half written.
+func (u *usageTracker) save(now time.Time) error {
+	u.mu.Lock()
+	u.prune(now)
+	if !u.changed {
+		u.mu.Unlock()
+		return nil
+	}
+	records := make([]*usageRecord, 0, len(u.records))
+	for _, record := range u.records {
+		copied := *record
+		records = append(records, &copied)
+	}
+	u.changed = false
+	u.mu.Unlock()
+
+	if err := writeUsageFile(u.path, records); err != nil {
+		// the usage is saved again at the next interval
+		u.mu.Lock()
+		u.changed = true
+		u.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func writeUsageFile(path string, records []*usageRecord) error {
+	raw, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".usage")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(raw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// run saves the usage to the usage file at an interval, forever, if there is one.
+func (u *usageTracker) run() {
+	if u.path == "" {
+		return
+	}
+	ticker := time.NewTicker(usageSaveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := u.save(time.Now()); err != nil {
+			log.NewLogEntry().Error(err, "error saving usage file")
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+func TestUsageTrackerReport(t *testing.T) {
+	u := &usageTracker{records: make(map[usageKey]*usageRecord)}
+	start := time.Now()
+
+	u.record("user@example.com", "wiki", start)
+	u.record("User@Example.com", "wiki", start.Add(time.Minute))
+	u.record("other@example.com", "wiki", start.Add(2*time.Minute))
+	u.record("user@example.com", "dashboards", start)
+
+	report := u.report("", "", start.Add(time.Hour))
+	testutil.Equal(t, 3, len(report))
+	testutil.Equal(t, "dashboards", report[0].Service)
+	// the most recent access of each service is first
+	testutil.Equal(t, "other@example.com", report[1].Email)
+	testutil.Equal(t, "user@example.com", report[2].Email)
+	testutil.Equal(t, int64(2), report[2].Requests)
+	testutil.Equal(t, start, report[2].FirstAccess)
+	testutil.Equal(t, start.Add(time.Minute), report[2].LastAccess)
+
+	testutil.Equal(t, 2, len(u.report("wiki", "", start)))
+	testutil.Equal(t, 2, len(u.report("", "USER@example.com", start)))
+	testutil.Equal(t, 1, len(u.report("wiki", "other@example.com", start)))
+
+	testutil.Equal(t, 2, u.purgeUser("user@example.com"))
+	testutil.Equal(t, 1, len(u.report("", "", start)))
+}
+
+func TestUsageTrackerRetention(t *testing.T) {
+	u := &usageTracker{retention: time.Hour, records: make(map[usageKey]*usageRecord)}
+	start := time.Now()
+
+	u.record("user@example.com", "wiki", start)
+	u.record("other@example.com", "wiki", start.Add(30*time.Minute))
+
+	report := u.report("", "", start.Add(90*time.Minute))
+	testutil.Equal(t, 1, len(report))
+	testutil.Equal(t, "other@example.com", report[0].Email)
+}
+
+func TestUsageTrackerFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "usage")
+	testutil.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := testOptions()
+	opts.UsageReporting = true
+	opts.UsageFile = filepath.Join(dir, "usage.json")
+
+	// usage starts empty without a file
+	u, err := newUsageTracker(opts)
+	testutil.Ok(t, err)
+	now := time.Now().Round(0)
+	u.record("user@example.com", "wiki", now)
+	u.record("user@example.com", "wiki", now)
+	testutil.Ok(t, u.save(now))
+
+	// and survives restarts once saved
+	reloaded, err := newUsageTracker(opts)
+	testutil.Ok(t, err)
+	report := reloaded.report("", "", now)
+	testutil.Equal(t, 1, len(report))
+	testutil.Equal(t, int64(2), report[0].Requests)
+	testutil.Assert(t, report[0].LastAccess.Equal(now), "unexpected last access %s", report[0].LastAccess)
+
+	reloaded.record("user@example.com", "wiki", now)
+	testutil.Equal(t, int64(3), reloaded.report("", "", now)[0].Requests)
+
+	testutil.Ok(t, ioutil.WriteFile(opts.UsageFile, []byte("{"), 0600))
+	_, err = newUsageTracker(opts)
+	testutil.NotEqual(t, nil, err)
+
+	opts.UsageReporting = false
+	u, err = newUsageTracker(opts)
+	testutil.Ok(t, err)
+	testutil.Assert(t, u == nil, "expected no usage tracker without usage reporting")
+}
+
+func TestProxyRecordsUsage(t *testing.T) {
+	u := &usageTracker{records: make(map[usageKey]*usageRecord)}
+	proxy, close := testNewOAuthProxy(t, SetUsageTracker(u))
+	defer close()
+	proxy.upstreamConfig.Service = "wiki"
+	proxy.handler = http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		proxy.Proxy(rw, httptest.NewRequest("GET", "https://localhost/", nil))
+		testutil.Equal(t, http.StatusOK, rw.Code)
+	}
+
+	report := u.report("", "", time.Now())
+	testutil.Equal(t, 1, len(report))
+	testutil.Equal(t, testSession().Email, report[0].Email)
+	testutil.Equal(t, "wiki", report[0].Service)
+	testutil.Equal(t, int64(2), report[0].Requests)
+}
+
+func TestAdminHandlerUsage(t *testing.T) {
+	u := &usageTracker{records: make(map[usageKey]*usageRecord)}
+	u.record("user@example.com", "wiki", time.Now())
+	u.record("other@example.com", "dashboards", time.Now())
+	handler := newAdminHandler(adminConfig{token: "admin-token", usage: u})
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	testutil.Equal(t, http.StatusMethodNotAllowed, serve("POST", "http://localhost/admin/usage").Code)
+
+	rw := serve("GET", "http://localhost/admin/usage?service=wiki")
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	listing := struct {
+		Usage []usageRecord `json:"usage"`
+	}{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &listing))
+	testutil.Equal(t, 1, len(listing.Usage))
+	testutil.Equal(t, "user@example.com", listing.Usage[0].Email)
+	testutil.Equal(t, int64(1), listing.Usage[0].Requests)
+
+	// usage is purged with the rest of a user's data
+	rw = serve("DELETE", "http://localhost/admin/subjects?email=user@example.com")
+	testutil.Equal(t, http.StatusOK, rw.Code)
+	purged := struct {
+		UsageRecords int `json:"usage_records"`
+	}{}
+	testutil.Ok(t, json.Unmarshal(rw.Body.Bytes(), &purged))
+	testutil.Equal(t, 1, purged.UsageRecords)
+	testutil.Equal(t, 0, len(u.report("", "user@example.com", time.Now())))
+}
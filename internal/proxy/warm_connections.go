@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+)
+
+// warmConnectionTTL is how long a warm connection is kept before it's replaced, well within the
+// idle timeouts of common servers, so that a warm connection isn't closed by the upstream before
+// it's used.
+const warmConnectionTTL = 30 * time.Second
+
+// warmHandshakeTimeout bounds the TLS handshakes of warm connections, as the transport's
+// TLSHandshakeTimeout bounds its own.
+const warmHandshakeTimeout = 10 * time.Second
+
+// warmPool keeps a number of connections to an upstream established ahead of its requests, so
+// that the first requests after its routes load don't wait for DNS, TCP, and TLS handshakes. The
+// transport of the upstream takes the warm connections before it dials new ones, and the pool
+// dials replacements in the background.
+type warmPool struct {
+	addr      string
+	size      int
+	dialer    *net.Dialer
+	tlsConfig *tls.Config
+
+	refill chan struct{}
+	done   chan struct{}
+
+	mu      sync.Mutex
+	conns   []*warmConn
+	stopped bool
+}
+
+// warmConn is a warm connection, and when it was established.
+type warmConn struct {
+	net.Conn
+	dialed time.Time
+}
+
+// newWarmPool returns a pool of connections to an address, which are secured with tlsConfig,
+// unless it's nil.
+func newWarmPool(addr string, size int, tlsConfig *tls.Config) *warmPool {
+	return &warmPool{
+		addr: addr,
+		size: size,
+		dialer: &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+		tlsConfig: tlsConfig,
+		refill:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+// configureWarmPool sets the pool of warm connections of the transport of an upstream with
+// warm_connections, which is started and stopped with the upstream's routes.
+func configureWarmPool(t *upstreamTransport, config *UpstreamConfig) {
+	route, ok := config.Route.(*SimpleRoute)
+	if !ok || config.WarmConnections == 0 {
+		return
+	}
+
+	var tlsConfig *tls.Config
+	port := route.ToURL.Port()
+	switch route.ToURL.Scheme {
+	case "https":
+		tlsConfig = t.clientTLSConfig()
+		if port == "" {
+			port = "443"
+		}
+	default:
+		if port == "" {
+			port = "80"
+		}
+	}
+	t.warmPool = newWarmPool(net.JoinHostPort(route.ToURL.Hostname(), port), config.WarmConnections, tlsConfig)
+	config.warmPool = t.warmPool
+}
+
+// warmTransport makes a transport take warm connections from a pool before it dials new ones,
+// and keep as many idle connections as the pool does.
+func warmTransport(transport *http.Transport, p *warmPool) {
+	if p.size > http.DefaultMaxIdleConnsPerHost {
+		transport.MaxIdleConnsPerHost = p.size
+	}
+	if p.tlsConfig != nil {
+		transport.DialTLS = func(network, addr string) (net.Conn, error) {
+			return p.take(context.Background(), network, addr)
+		}
+		return
+	}
+	transport.DialContext = p.take
+}
+
+// dial establishes a connection to an address, completing its TLS handshake for https upstreams.
+func (p *warmPool) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := p.dialer.DialContext(ctx, network, addr)
+	if err != nil || p.tlsConfig == nil {
+		return conn, err
+	}
+
+	config := p.tlsConfig.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	conn.SetDeadline(time.Now().Add(warmHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// take returns a warm connection to an address if there is one, or dials a new one. It's the
+// transport's DialTLS for https upstreams, and its DialContext for http upstreams.
+func (p *warmPool) take(ctx context.Context, network, addr string) (net.Conn, error) {
+	if addr == p.addr {
+		if conn := p.get(time.Now()); conn != nil {
+			return conn, nil
+		}
+	}
+	return p.dial(ctx, network, addr)
+}
+
+// get returns a warm connection that hasn't expired, or nil if there's none, and asks for a
+// replacement.
+func (p *warmPool) get(now time.Time) net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.conns) != 0 {
+		conn := p.conns[0]
+		p.conns = p.conns[1:]
+		if now.Sub(conn.dialed) < warmConnectionTTL {
+			select {
+			case p.refill <- struct{}{}:
+			default:
+			}
+			return conn.Conn
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// fill replaces the warm connections that expired, and dials enough new ones to keep the size
+// of the pool.
+func (p *warmPool) fill(now time.Time) {
+	p.mu.Lock()
+	fresh := p.conns[:0]
+	for _, conn := range p.conns {
+		if now.Sub(conn.dialed) < warmConnectionTTL {
+			fresh = append(fresh, conn)
+		} else {
+			conn.Close()
+		}
+	}
+	p.conns = fresh
+	missing := p.size - len(p.conns)
+	p.mu.Unlock()
+
+	for i := 0; i < missing; i++ {
+		conn, err := p.dial(context.Background(), "tcp", p.addr)
+		if err != nil {
+			log.NewLogEntry().WithError(err).Warn("error warming connection to upstream ", p.addr)
+			return
+		}
+
+		p.mu.Lock()
+		if p.stopped {
+			p.mu.Unlock()
+			conn.Close()
+			return
+		}
+		p.conns = append(p.conns, &warmConn{Conn: conn, dialed: time.Now()})
+		p.mu.Unlock()
+	}
+}
+
+// run keeps the pool filled until it's stopped.
+func (p *warmPool) run() {
+	ticker := time.NewTicker(warmConnectionTTL / 2)
+	defer ticker.Stop()
+	for {
+		p.fill(time.Now())
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		case <-p.refill:
+		}
+	}
+}
+
+// Stop stops filling the pool, and closes its warm connections, once the upstream's routes are
+// replaced.
+func (p *warmPool) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	close(p.done)
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+)
+
+// testWarmServer returns a server that counts the connections established to it.
+func testWarmServer(t *testing.T, secure bool) (*httptest.Server, *int32) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	if secure {
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	return server, &conns
+}
+
+// waitConns waits until a number of connections are established to a server, which counts them
+// once it accepts them.
+func waitConns(t *testing.T, conns *int32, n int32) {
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(conns) != n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	testutil.Equal(t, n, atomic.LoadInt32(conns))
+}
+
+func TestWarmConnections(t *testing.T) {
+	testCases := map[string]bool{
+		"http":  false,
+		"https": true,
+	}
+	for name, secure := range testCases {
+		t.Run(name, func(t *testing.T) {
+			server, conns := testWarmServer(t, secure)
+			defer server.Close()
+
+			toURL, _ := url.Parse(server.URL)
+			config := &UpstreamConfig{
+				Service:         "foo",
+				Route:           &SimpleRoute{FromURL: &url.URL{Host: "foo.sso.dev"}, ToURL: toURL},
+				TLSSkipVerify:   true,
+				ResetDeadline:   time.Minute,
+				WarmConnections: 2,
+			}
+			transport := newUpstreamTransport(config)
+			testutil.NotEqual(t, nil, config.warmPool)
+			defer config.warmPool.Stop()
+
+			// the connections are established before any request
+			config.warmPool.fill(time.Now())
+			waitConns(t, conns, 2)
+
+			// and requests use them rather than establishing their own
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest("GET", server.URL+"/", nil)
+				req.RequestURI = ""
+				resp, err := transport.RoundTrip(req)
+				testutil.Ok(t, err)
+				resp.Body.Close()
+			}
+			waitConns(t, conns, 2)
+		})
+	}
+}
+
+func TestWarmPoolExpiry(t *testing.T) {
+	server, conns := testWarmServer(t, false)
+	defer server.Close()
+
+	pool := newWarmPool(server.Listener.Addr().String(), 1, nil)
+	defer pool.Stop()
+	pool.fill(time.Now())
+	waitConns(t, conns, 1)
+
+	// expired connections aren't used, and are replaced when the pool is filled
+	testutil.Equal(t, nil, pool.get(time.Now().Add(warmConnectionTTL)))
+	pool.fill(time.Now())
+	waitConns(t, conns, 2)
+	conn := pool.get(time.Now())
+	testutil.NotEqual(t, nil, conn)
+	conn.Close()
+}
+
+func TestWarmConnectionsOnlyForSimpleRoutes(t *testing.T) {
+	config := &UpstreamConfig{
+		Service:         "foo",
+		Route:           &RewriteRoute{},
+		WarmConnections: 2,
+	}
+	newUpstreamTransport(config)
+	testutil.Equal(t, (*warmPool)(nil), config.warmPool)
+}
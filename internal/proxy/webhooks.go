@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	log "github.com/buzzfeed/sso/internal/pkg/logging"
+	"github.com/buzzfeed/sso/internal/pkg/webhook"
+)
+
+// Auth lifecycle events delivered to WEBHOOK_URL.
+const (
+	eventSignIn              = "sign_in"
+	eventSignOut             = "sign_out"
+	eventAuthorizationDenied = "authorization_denied"
+	eventSessionRevoked      = "session_revoked"
+	eventLockout             = "lockout"
+	eventHoneypot            = "honeypot"
+)
+
+var lifecycleEvents = []string{eventSignIn, eventSignOut, eventAuthorizationDenied, eventSessionRevoked, eventLockout, eventHoneypot}
+
+func isLifecycleEvent(event string) bool {
+	for _, e := range lifecycleEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// lifecycleEvent is the payload delivered to webhooks for each auth lifecycle event.
+type lifecycleEvent struct {
+	Event         string    `json:"event"`
+	Email         string    `json:"email,omitempty"`
+	Groups        []string  `json:"groups,omitempty"`
+	Host          string    `json:"host,omitempty"`
+	RemoteAddress string    `json:"remote_address,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	RequestID     string    `json:"request_id,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// eventNotifier delivers the configured auth lifecycle events to a webhook. A nil
+// eventNotifier delivers nothing.
+type eventNotifier struct {
+	sender *webhook.Sender
+	events map[string]bool
+}
+
+// newEventNotifier returns an eventNotifier delivering events to the webhook configured
+// by opts, or nil if none is.
+func newEventNotifier(opts *Options) (*eventNotifier, error) {
+	if opts.WebhookURL == "" {
+		return nil, nil
+	}
+
+	sender, err := webhook.NewSender(opts.WebhookURL, opts.WebhookSecret, func(s *webhook.Sender) error {
+		s.MaxAttempts = opts.WebhookMaxAttempts
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := opts.WebhookEvents
+	if len(events) == 0 {
+		events = lifecycleEvents
+	}
+	n := &eventNotifier{sender: sender, events: make(map[string]bool)}
+	for _, event := range events {
+		n.events[event] = true
+	}
+	return n, nil
+}
+
+// SetEventNotifier sets the notifier of auth lifecycle events, as a functional option
+func SetEventNotifier(n *eventNotifier) func(*OAuthProxy) error {
+	return func(op *OAuthProxy) error {
+		op.events = n
+		return nil
+	}
+}
+
+// notify delivers an event, if its type is configured.
+func (n *eventNotifier) notify(event lifecycleEvent) {
+	if n == nil || !n.events[event.Event] {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	err := n.sender.Send(event.Event, event)
+	if err != nil {
+		log.NewLogEntry().WithUser(event.Email).Error(err, "error sending webhook event")
+	}
+}
+
+// notifyEvent delivers an event about a request.
+func (p *OAuthProxy) notifyEvent(req *http.Request, event, email string, groups []string, reason string) {
+	p.events.notify(lifecycleEvent{
+		Event:         event,
+		Email:         email,
+		Groups:        groups,
+		Host:          req.Host,
+		RemoteAddress: getRemoteAddr(req),
+		UserAgent:     req.UserAgent(),
+		Reason:        reason,
+		RequestID:     requestID(req),
+	})
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buzzfeed/sso/internal/pkg/sessions"
+	"github.com/buzzfeed/sso/internal/pkg/testutil"
+	"github.com/buzzfeed/sso/internal/pkg/webhook"
+)
+
+// testEventNotifier returns a notifier delivering the given events to a test server, and
+// the channel the events it receives are sent to.
+func testEventNotifier(t *testing.T, events ...string) (*eventNotifier, chan lifecycleEvent, func()) {
+	received := make(chan lifecycleEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		event := lifecycleEvent{}
+		err := json.NewDecoder(req.Body).Decode(&event)
+		testutil.Assert(t, err == nil, "unexpected error decoding event: %s", err)
+		testutil.Equal(t, event.Event, req.Header.Get(webhook.EventHeader))
+		testutil.Assert(t, req.Header.Get(webhook.SignatureHeader) != "", "expected signed event")
+		received <- event
+	}))
+
+	opts := NewOptions()
+	opts.WebhookURL = server.URL
+	opts.WebhookSecret = "secret"
+	opts.WebhookEvents = events
+	n, err := newEventNotifier(opts)
+	testutil.Assert(t, err == nil, "unexpected error creating notifier: %s", err)
+	return n, received, server.Close
+}
+
+func receiveEvent(t *testing.T, received chan lifecycleEvent) lifecycleEvent {
+	select {
+	case event := <-received:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+	return lifecycleEvent{}
+}
+
+func TestNewEventNotifierDisabled(t *testing.T) {
+	n, err := newEventNotifier(NewOptions())
+	testutil.Assert(t, err == nil, "unexpected error creating notifier: %s", err)
+	testutil.Assert(t, n == nil, "expected no notifier without a webhook url")
+	// a nil notifier delivers nothing
+	n.notify(lifecycleEvent{Event: eventSignIn})
+}
+
+func TestEventNotifierFiltersEvents(t *testing.T) {
+	n, received, close := testEventNotifier(t, eventSignOut)
+	defer close()
+
+	n.notify(lifecycleEvent{Event: eventSignIn, Email: "foo@example.com"})
+	n.notify(lifecycleEvent{Event: eventSignOut, Email: "foo@example.com"})
+
+	event := receiveEvent(t, received)
+	testutil.Equal(t, eventSignOut, event.Event)
+	testutil.Assert(t, !event.Time.IsZero(), "expected event time to be set")
+}
+
+func TestSignOutEvent(t *testing.T) {
+	n, received, close := testEventNotifier(t)
+	defer close()
+
+	proxy, closeProxy := testNewOAuthProxy(t,
+		setSessionStore(&sessions.MockSessionStore{Session: testSession()}),
+		SetEventNotifier(n),
+	)
+	defer closeProxy()
+
+	req := httptest.NewRequest("GET", "https://localhost/oauth2/sign_out", nil)
+	req.Header.Set("User-Agent", "Firefox")
+	rw := httptest.NewRecorder()
+	proxy.SignOut(rw, req)
+	testutil.Equal(t, http.StatusFound, rw.Code)
+
+	event := receiveEvent(t, received)
+	testutil.Equal(t, eventSignOut, event.Event)
+	testutil.Equal(t, "michael.bland@gsa.gov", event.Email)
+	testutil.Equal(t, "localhost", event.Host)
+	testutil.Equal(t, "Firefox", event.UserAgent)
+}
+
+func TestAdminRevocationEvents(t *testing.T) {
+	n, received, close := testEventNotifier(t, eventSessionRevoked)
+	defer close()
+
+	store := newDeviceTokenStore(time.Minute, time.Hour, 0)
+	handler := newAdminHandler(adminConfig{token: "admin-token", deviceTokens: store, events: n})
+	_, token := testIssueBearerToken(t, store, "user@example.com")
+	testIssueBearerToken(t, store, "other@example.com")
+
+	for _, target := range []string{
+		"http://localhost/admin/tokens/" + token.ID,
+		"http://localhost/admin/tokens?email=other@example.com",
+		"http://localhost/admin/tokens?email=nobody@example.com",
+	} {
+		req := httptest.NewRequest("DELETE", target, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	event := receiveEvent(t, received)
+	testutil.Equal(t, eventSessionRevoked, event.Event)
+	testutil.Equal(t, "user@example.com", event.Email)
+	testutil.Equal(t, "localhost", event.Host)
+
+	event = receiveEvent(t, received)
+	testutil.Equal(t, "other@example.com", event.Email)
+
+	select {
+	case event := <-received:
+		t.Fatalf("unexpected event for a user without tokens: %#v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,178 @@
+// Package middleware lets company-specific logic run as part of how sso-proxy handles the
+// requests of its upstreams, without patching it. Middleware are registered by name, for one of
+// the points of the proxy's handling of requests:
+//
+//	func init() {
+//		middleware.Register(middleware.Middleware{
+//			Name:  "entitlements",
+//			Point: middleware.PostAuth,
+//			New:   newEntitlements,
+//		})
+//	}
+//
+// either by a package imported by a build of sso-proxy, or by a Go plugin listed in
+// MIDDLEWARE_PLUGINS, which registers its middleware when it's loaded. Plugins must be built with
+// the same version of Go and of this module as sso-proxy.
+//
+// Registered middleware only run for the upstreams that enable them, by listing them in the
+// middleware option of their upstream configs, along with any options of their own:
+//
+//	options:
+//	  middleware:
+//	    entitlements:
+//	      flag: beta
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"plugin"
+	"sort"
+	"sync"
+)
+
+// Point is a point of the proxy's handling of requests middleware run at.
+type Point string
+
+const (
+	// PreAuth middleware run for every request to an upstream, before it's authenticated,
+	// including the requests of the sign in flow.
+	PreAuth Point = "pre_auth"
+	// PostAuth middleware run for the requests to an upstream that are authenticated and
+	// authorized, or skip authentication, before the proxy handles them for the upstream, e.g.
+	// by shedding load or rejecting them while the upstream is unhealthy. The identity of
+	// authenticated requests is set, see IdentityFromContext.
+	PostAuth Point = "post_auth"
+	// PreProxy middleware run just before a request is proxied to the upstream, or served from
+	// its files or bucket, before it's signed.
+	PreProxy Point = "pre_proxy"
+	// PostProxy middleware run innermost, around the handler proxying to the upstream, so they
+	// see its responses before any other middleware.
+	PostProxy Point = "post_proxy"
+)
+
+// Points are the points middleware run at, in the order requests reach them.
+var Points = []Point{PreAuth, PostAuth, PreProxy, PostProxy}
+
+// Upstream is the upstream middleware are created for.
+type Upstream struct {
+	Service string
+	Cluster string
+
+	// Options are the options of the middleware in the upstream's config.
+	Options map[string]string
+}
+
+// Middleware is middleware registered for a point of the proxy's handling of requests.
+type Middleware struct {
+	// Name is the name upstreams enable the middleware by.
+	Name string
+	// Point is the point the middleware runs at.
+	Point Point
+	// Order orders the middleware of a point, the lowest running first, with middleware of the
+	// same order running in order of their names.
+	Order int
+	// New returns the middleware of an upstream, wrapping the next handler of its point. It's
+	// called whenever the upstream's config is loaded, and an error fails loading it.
+	New func(upstream Upstream) (func(http.Handler) http.Handler, error)
+}
+
+var (
+	mu         sync.RWMutex
+	registered = make(map[string]Middleware)
+)
+
+// Register registers middleware. It panics if the middleware has no name or constructor, its
+// point is unknown, or middleware of the same name is already registered.
+func Register(m Middleware) {
+	mu.Lock()
+	defer mu.Unlock()
+	if m.Name == "" {
+		panic("middleware: Register middleware without a name")
+	}
+	if m.New == nil {
+		panic(fmt.Sprintf("middleware: Register middleware %s without a constructor", m.Name))
+	}
+	if !validPoint(m.Point) {
+		panic(fmt.Sprintf("middleware: Register middleware %s at unknown point %q", m.Name, m.Point))
+	}
+	if _, ok := registered[m.Name]; ok {
+		panic(fmt.Sprintf("middleware: Register called twice for middleware %s", m.Name))
+	}
+	registered[m.Name] = m
+}
+
+// Lookup returns the middleware registered under a name.
+func Lookup(name string) (Middleware, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	m, ok := registered[name]
+	return m, ok
+}
+
+// Registered returns the registered middleware, in the order they run.
+func Registered() []Middleware {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := make([]Middleware, 0, len(registered))
+	for _, m := range registered {
+		all = append(all, m)
+	}
+	Sort(all)
+	return all
+}
+
+// Sort sorts middleware in the order they run: by point, then order, then name.
+func Sort(ms []Middleware) {
+	sort.Slice(ms, func(i, j int) bool {
+		if pi, pj := pointIndex(ms[i].Point), pointIndex(ms[j].Point); pi != pj {
+			return pi < pj
+		}
+		if ms[i].Order != ms[j].Order {
+			return ms[i].Order < ms[j].Order
+		}
+		return ms[i].Name < ms[j].Name
+	})
+}
+
+func validPoint(point Point) bool {
+	return pointIndex(point) >= 0
+}
+
+func pointIndex(point Point) int {
+	for i, p := range Points {
+		if p == point {
+			return i
+		}
+	}
+	return -1
+}
+
+// LoadPlugin loads the Go plugin at path, which registers its middleware when it's loaded.
+// Loading a plugin again has no effect.
+func LoadPlugin(path string) error {
+	_, err := plugin.Open(path)
+	return err
+}
+
+type identityKey struct{}
+
+// Identity is the identity of an authenticated request.
+type Identity struct {
+	User   string
+	Email  string
+	Groups []string
+}
+
+// NewContext returns a copy of ctx carrying the identity of a request.
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity of the request ctx belongs to, if it was
+// authenticated. It's only set for PostAuth, PreProxy, and PostProxy middleware.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func testNew(upstream Upstream) (func(http.Handler) http.Handler, error) {
+	return func(next http.Handler) http.Handler { return next }, nil
+}
+
+func TestRegister(t *testing.T) {
+	Register(Middleware{Name: "register-b", Point: PostAuth, New: testNew})
+	Register(Middleware{Name: "register-a", Point: PostAuth, New: testNew})
+	Register(Middleware{Name: "register-early", Point: PostAuth, Order: -1, New: testNew})
+	Register(Middleware{Name: "register-proxy", Point: PreProxy, New: testNew})
+	Register(Middleware{Name: "register-auth", Point: PreAuth, Order: 10, New: testNew})
+
+	if _, ok := Lookup("register-a"); !ok {
+		t.Errorf("expected register-a to be registered")
+	}
+	if _, ok := Lookup("unregistered"); ok {
+		t.Errorf("expected unregistered not to be registered")
+	}
+
+	names := []string{}
+	for _, m := range Registered() {
+		names = append(names, m.Name)
+	}
+	expected := []string{"register-auth", "register-early", "register-a", "register-b", "register-proxy"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("expected middleware in order %v, got %v", expected, names)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	Register(Middleware{Name: "register-twice", Point: PreAuth, New: testNew})
+	testCases := map[string]Middleware{
+		"without a name":        {Point: PreAuth, New: testNew},
+		"without a constructor": {Name: "no-constructor", Point: PreAuth},
+		"at an unknown point":   {Name: "unknown-point", Point: "pre_dns", New: testNew},
+		"twice":                 {Name: "register-twice", Point: PreAuth, New: testNew},
+	}
+	for name, m := range testCases {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected Register to panic")
+				}
+			}()
+			Register(m)
+		})
+	}
+}
+
+func TestIdentityFromContext(t *testing.T) {
+	if _, ok := IdentityFromContext(context.Background()); ok {
+		t.Errorf("expected no identity")
+	}
+	identity := Identity{User: "user", Email: "user@example.com", Groups: []string{"admins"}}
+	got, ok := IdentityFromContext(NewContext(context.Background(), identity))
+	if !ok || !reflect.DeepEqual(identity, got) {
+		t.Errorf("expected identity %v, got %v", identity, got)
+	}
+}
@@ -0,0 +1,175 @@
+// Package providerdriver lets sso-auth authenticate users with identity providers it doesn't
+// support natively, without forking it. A provider driver implements the Driver interface, for
+// each step of the OAuth flow, and is either served over gRPC, as the ProviderDriver service of
+// providerdriver.proto, or built as a Go plugin.
+//
+// A driver served over gRPC can be written in any language. One written in Go registers itself
+// on a grpc.Server:
+//
+//	g := grpc.NewServer()
+//	providerdriver.Register(g, driver)
+//	g.Serve(ln)
+//
+// and sso-auth is pointed at its address with PROVIDER_*_DRIVER_ADDRESS. A Go plugin exports a
+// NewDriver function returning the driver:
+//
+//	func NewDriver() (providerdriver.Driver, error)
+//
+// and is built with go build -buildmode=plugin, with the same version of Go and of this module as
+// sso-auth, and loaded with PROVIDER_*_DRIVER_PLUGIN.
+//
+// Drivers report errors as gRPC status errors, which sso-auth maps to its own: InvalidArgument
+// for invalid requests, Unauthenticated for revoked tokens, ResourceExhausted when rate limited,
+// and Unavailable when the provider is unavailable.
+package providerdriver
+
+import (
+	"context"
+	"fmt"
+	"plugin"
+
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the name of the gRPC service provider drivers serve.
+const ServiceName = "sso.providerdriver.v1.ProviderDriver"
+
+// PluginSymbol is the name of the function Go plugins export to create their driver.
+const PluginSymbol = "NewDriver"
+
+// Driver drives an identity provider through the steps of the OAuth flow.
+type Driver interface {
+	// SignInURL returns the URL users are redirected to to sign in with the provider.
+	SignInURL(context.Context, *SignInURLRequest) (*SignInURLResponse, error)
+	// Redeem redeems an authorization code for tokens and the user's identity.
+	Redeem(context.Context, *RedeemRequest) (*RedeemResponse, error)
+	// Validate reports whether an access token is still valid.
+	Validate(context.Context, *ValidateRequest) (*ValidateResponse, error)
+	// Refresh redeems a refresh token for a new access token.
+	Refresh(context.Context, *RefreshRequest) (*RefreshResponse, error)
+	// Groups returns which of the allowed groups a user is a member of.
+	Groups(context.Context, *GroupsRequest) (*GroupsResponse, error)
+	// Revoke revokes a user's tokens.
+	Revoke(context.Context, *RevokeRequest) (*RevokeResponse, error)
+}
+
+// Register registers a driver as the ProviderDriver service of a grpc.Server.
+func Register(g *grpc.Server, d Driver) {
+	g.RegisterService(&grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*Driver)(nil),
+		Methods: []grpc.MethodDesc{
+			unaryMethod("SignInURL", func() interface{} { return &SignInURLRequest{} },
+				func(ctx context.Context, d Driver, in interface{}) (interface{}, error) {
+					return d.SignInURL(ctx, in.(*SignInURLRequest))
+				}),
+			unaryMethod("Redeem", func() interface{} { return &RedeemRequest{} },
+				func(ctx context.Context, d Driver, in interface{}) (interface{}, error) {
+					return d.Redeem(ctx, in.(*RedeemRequest))
+				}),
+			unaryMethod("Validate", func() interface{} { return &ValidateRequest{} },
+				func(ctx context.Context, d Driver, in interface{}) (interface{}, error) {
+					return d.Validate(ctx, in.(*ValidateRequest))
+				}),
+			unaryMethod("Refresh", func() interface{} { return &RefreshRequest{} },
+				func(ctx context.Context, d Driver, in interface{}) (interface{}, error) {
+					return d.Refresh(ctx, in.(*RefreshRequest))
+				}),
+			unaryMethod("Groups", func() interface{} { return &GroupsRequest{} },
+				func(ctx context.Context, d Driver, in interface{}) (interface{}, error) {
+					return d.Groups(ctx, in.(*GroupsRequest))
+				}),
+			unaryMethod("Revoke", func() interface{} { return &RevokeRequest{} },
+				func(ctx context.Context, d Driver, in interface{}) (interface{}, error) {
+					return d.Revoke(ctx, in.(*RevokeRequest))
+				}),
+		},
+		Streams:  []grpc.StreamDesc{},
+		Metadata: "providerdriver.proto",
+	}, d)
+}
+
+// unaryMethod returns the description of a unary method of the ProviderDriver service, which
+// decodes its request into the message returned by newIn and handles it with call.
+func unaryMethod(name string, newIn func() interface{}, call func(context.Context, Driver, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := newIn()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(ctx, srv.(Driver), in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: fmt.Sprintf("/%s/%s", ServiceName, name),
+			}
+			return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(ctx, srv.(Driver), req)
+			})
+		},
+	}
+}
+
+// client is a Driver calling a driver served over gRPC.
+type client struct {
+	conn *grpc.ClientConn
+}
+
+// NewClient returns a Driver calling the driver served on a gRPC connection.
+func NewClient(conn *grpc.ClientConn) Driver {
+	return &client{conn: conn}
+}
+
+func (c *client) invoke(ctx context.Context, method string, in, out interface{}) error {
+	return c.conn.Invoke(ctx, fmt.Sprintf("/%s/%s", ServiceName, method), in, out)
+}
+
+func (c *client) SignInURL(ctx context.Context, in *SignInURLRequest) (*SignInURLResponse, error) {
+	out := &SignInURLResponse{}
+	return out, c.invoke(ctx, "SignInURL", in, out)
+}
+
+func (c *client) Redeem(ctx context.Context, in *RedeemRequest) (*RedeemResponse, error) {
+	out := &RedeemResponse{}
+	return out, c.invoke(ctx, "Redeem", in, out)
+}
+
+func (c *client) Validate(ctx context.Context, in *ValidateRequest) (*ValidateResponse, error) {
+	out := &ValidateResponse{}
+	return out, c.invoke(ctx, "Validate", in, out)
+}
+
+func (c *client) Refresh(ctx context.Context, in *RefreshRequest) (*RefreshResponse, error) {
+	out := &RefreshResponse{}
+	return out, c.invoke(ctx, "Refresh", in, out)
+}
+
+func (c *client) Groups(ctx context.Context, in *GroupsRequest) (*GroupsResponse, error) {
+	out := &GroupsResponse{}
+	return out, c.invoke(ctx, "Groups", in, out)
+}
+
+func (c *client) Revoke(ctx context.Context, in *RevokeRequest) (*RevokeResponse, error) {
+	out := &RevokeResponse{}
+	return out, c.invoke(ctx, "Revoke", in, out)
+}
+
+// LoadPlugin loads the driver of the Go plugin at path, created by its NewDriver function.
+func LoadPlugin(path string) (Driver, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup(PluginSymbol)
+	if err != nil {
+		return nil, err
+	}
+	newDriver, ok := sym.(func() (Driver, error))
+	if !ok {
+		return nil, fmt.Errorf("%s of plugin %s is a %T, not a func() (providerdriver.Driver, error)", PluginSymbol, path, sym)
+	}
+	return newDriver()
+}
@@ -0,0 +1,120 @@
+package providerdriver
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testDriver redeems any code for a fixed user, and revokes no tokens.
+type testDriver struct{}
+
+func (testDriver) SignInURL(ctx context.Context, in *SignInURLRequest) (*SignInURLResponse, error) {
+	return &SignInURLResponse{URL: "https://idp.example.com/authorize?state=" + in.State}, nil
+}
+
+func (testDriver) Redeem(ctx context.Context, in *RedeemRequest) (*RedeemResponse, error) {
+	if in.Code != "code" {
+		return nil, status.Error(codes.InvalidArgument, "invalid code")
+	}
+	return &RedeemResponse{
+		AccessToken:  "access-token",
+		RefreshToken: "refresh-token",
+		ExpiresIn:    3600,
+		Email:        "user@example.com",
+		Scopes:       []string{"openid", "email"},
+		Claims:       map[string]string{"name": "User"},
+	}, nil
+}
+
+func (testDriver) Validate(ctx context.Context, in *ValidateRequest) (*ValidateResponse, error) {
+	return &ValidateResponse{Valid: in.AccessToken == "access-token"}, nil
+}
+
+func (testDriver) Refresh(ctx context.Context, in *RefreshRequest) (*RefreshResponse, error) {
+	return &RefreshResponse{AccessToken: "access-token", ExpiresIn: 3600}, nil
+}
+
+func (testDriver) Groups(ctx context.Context, in *GroupsRequest) (*GroupsResponse, error) {
+	return &GroupsResponse{Groups: in.AllowedGroups[:1]}, nil
+}
+
+func (testDriver) Revoke(ctx context.Context, in *RevokeRequest) (*RevokeResponse, error) {
+	return nil, status.Error(codes.Unauthenticated, "token revoked")
+}
+
+func testClient(t *testing.T) (Driver, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %s", err)
+	}
+	g := grpc.NewServer()
+	Register(g, testDriver{})
+	go g.Serve(ln)
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("unexpected error dialing: %s", err)
+	}
+	return NewClient(conn), func() {
+		conn.Close()
+		g.Stop()
+	}
+}
+
+func TestClientCallsRegisteredDriver(t *testing.T) {
+	client, close := testClient(t)
+	defer close()
+	ctx := context.Background()
+
+	signIn, err := client.SignInURL(ctx, &SignInURLRequest{State: "state"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if signIn.URL != "https://idp.example.com/authorize?state=state" {
+		t.Errorf("unexpected sign in url: %q", signIn.URL)
+	}
+
+	redeem, err := client.Redeem(ctx, &RedeemRequest{Code: "code"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := &RedeemResponse{
+		AccessToken:  "access-token",
+		RefreshToken: "refresh-token",
+		ExpiresIn:    3600,
+		Email:        "user@example.com",
+		Scopes:       []string{"openid", "email"},
+		Claims:       map[string]string{"name": "User"},
+	}
+	if !reflect.DeepEqual(expected, redeem) {
+		t.Errorf("expected %#v, got %#v", expected, redeem)
+	}
+
+	groups, err := client.Groups(ctx, &GroupsRequest{AllowedGroups: []string{"admins", "everyone"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual([]string{"admins"}, groups.Groups) {
+		t.Errorf("unexpected groups: %v", groups.Groups)
+	}
+}
+
+func TestClientReturnsDriverStatus(t *testing.T) {
+	client, close := testClient(t)
+	defer close()
+
+	_, err := client.Redeem(context.Background(), &RedeemRequest{Code: "invalid"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument, got %v", err)
+	}
+	_, err = client.Revoke(context.Background(), &RevokeRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package providerdriver
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// The types below are the messages of providerdriver.proto, written by hand like those of the
+// extauthz package rather than generated, so that drivers written in Go only depend on the
+// protobuf and grpc modules sso already requires.
+
+// SignInURLRequest is sso.providerdriver.v1.SignInURLRequest.
+type SignInURLRequest struct {
+	ClientID    string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	RedirectURI string `protobuf:"bytes,2,opt,name=redirect_uri,json=redirectUri,proto3" json:"redirect_uri,omitempty"`
+	State       string `protobuf:"bytes,3,opt,name=state,proto3" json:"state,omitempty"`
+	Scope       string `protobuf:"bytes,4,opt,name=scope,proto3" json:"scope,omitempty"`
+}
+
+func (m *SignInURLRequest) Reset()         { *m = SignInURLRequest{} }
+func (m *SignInURLRequest) String() string { return proto.CompactTextString(m) }
+func (*SignInURLRequest) ProtoMessage()    {}
+
+// SignInURLResponse is sso.providerdriver.v1.SignInURLResponse.
+type SignInURLResponse struct {
+	URL string `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+}
+
+func (m *SignInURLResponse) Reset()         { *m = SignInURLResponse{} }
+func (m *SignInURLResponse) String() string { return proto.CompactTextString(m) }
+func (*SignInURLResponse) ProtoMessage()    {}
+
+// RedeemRequest is sso.providerdriver.v1.RedeemRequest.
+type RedeemRequest struct {
+	ClientID     string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	ClientSecret string `protobuf:"bytes,2,opt,name=client_secret,json=clientSecret,proto3" json:"client_secret,omitempty"`
+	RedirectURI  string `protobuf:"bytes,3,opt,name=redirect_uri,json=redirectUri,proto3" json:"redirect_uri,omitempty"`
+	Code         string `protobuf:"bytes,4,opt,name=code,proto3" json:"code,omitempty"`
+	Scope        string `protobuf:"bytes,5,opt,name=scope,proto3" json:"scope,omitempty"`
+}
+
+func (m *RedeemRequest) Reset()         { *m = RedeemRequest{} }
+func (m *RedeemRequest) String() string { return proto.CompactTextString(m) }
+func (*RedeemRequest) ProtoMessage()    {}
+
+// RedeemResponse is sso.providerdriver.v1.RedeemResponse.
+type RedeemResponse struct {
+	AccessToken  string            `protobuf:"bytes,1,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	RefreshToken string            `protobuf:"bytes,2,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+	ExpiresIn    int64             `protobuf:"varint,3,opt,name=expires_in,json=expiresIn,proto3" json:"expires_in,omitempty"`
+	Email        string            `protobuf:"bytes,4,opt,name=email,proto3" json:"email,omitempty"`
+	Scopes       []string          `protobuf:"bytes,5,rep,name=scopes,proto3" json:"scopes,omitempty"`
+	Claims       map[string]string `protobuf:"bytes,6,rep,name=claims,proto3" json:"claims,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *RedeemResponse) Reset()         { *m = RedeemResponse{} }
+func (m *RedeemResponse) String() string { return proto.CompactTextString(m) }
+func (*RedeemResponse) ProtoMessage()    {}
+
+// ValidateRequest is sso.providerdriver.v1.ValidateRequest.
+type ValidateRequest struct {
+	ClientID     string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	ClientSecret string `protobuf:"bytes,2,opt,name=client_secret,json=clientSecret,proto3" json:"client_secret,omitempty"`
+	AccessToken  string `protobuf:"bytes,3,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	Email        string `protobuf:"bytes,4,opt,name=email,proto3" json:"email,omitempty"`
+}
+
+func (m *ValidateRequest) Reset()         { *m = ValidateRequest{} }
+func (m *ValidateRequest) String() string { return proto.CompactTextString(m) }
+func (*ValidateRequest) ProtoMessage()    {}
+
+// ValidateResponse is sso.providerdriver.v1.ValidateResponse.
+type ValidateResponse struct {
+	Valid bool `protobuf:"varint,1,opt,name=valid,proto3" json:"valid,omitempty"`
+}
+
+func (m *ValidateResponse) Reset()         { *m = ValidateResponse{} }
+func (m *ValidateResponse) String() string { return proto.CompactTextString(m) }
+func (*ValidateResponse) ProtoMessage()    {}
+
+// RefreshRequest is sso.providerdriver.v1.RefreshRequest.
+type RefreshRequest struct {
+	ClientID     string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	ClientSecret string `protobuf:"bytes,2,opt,name=client_secret,json=clientSecret,proto3" json:"client_secret,omitempty"`
+	RefreshToken string `protobuf:"bytes,3,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+}
+
+func (m *RefreshRequest) Reset()         { *m = RefreshRequest{} }
+func (m *RefreshRequest) String() string { return proto.CompactTextString(m) }
+func (*RefreshRequest) ProtoMessage()    {}
+
+// RefreshResponse is sso.providerdriver.v1.RefreshResponse.
+type RefreshResponse struct {
+	AccessToken string `protobuf:"bytes,1,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	ExpiresIn   int64  `protobuf:"varint,2,opt,name=expires_in,json=expiresIn,proto3" json:"expires_in,omitempty"`
+}
+
+func (m *RefreshResponse) Reset()         { *m = RefreshResponse{} }
+func (m *RefreshResponse) String() string { return proto.CompactTextString(m) }
+func (*RefreshResponse) ProtoMessage()    {}
+
+// GroupsRequest is sso.providerdriver.v1.GroupsRequest.
+type GroupsRequest struct {
+	ClientID      string   `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	ClientSecret  string   `protobuf:"bytes,2,opt,name=client_secret,json=clientSecret,proto3" json:"client_secret,omitempty"`
+	Email         string   `protobuf:"bytes,3,opt,name=email,proto3" json:"email,omitempty"`
+	AccessToken   string   `protobuf:"bytes,4,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	AllowedGroups []string `protobuf:"bytes,5,rep,name=allowed_groups,json=allowedGroups,proto3" json:"allowed_groups,omitempty"`
+}
+
+func (m *GroupsRequest) Reset()         { *m = GroupsRequest{} }
+func (m *GroupsRequest) String() string { return proto.CompactTextString(m) }
+func (*GroupsRequest) ProtoMessage()    {}
+
+// GroupsResponse is sso.providerdriver.v1.GroupsResponse.
+type GroupsResponse struct {
+	Groups []string `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty"`
+}
+
+func (m *GroupsResponse) Reset()         { *m = GroupsResponse{} }
+func (m *GroupsResponse) String() string { return proto.CompactTextString(m) }
+func (*GroupsResponse) ProtoMessage()    {}
+
+// RevokeRequest is sso.providerdriver.v1.RevokeRequest.
+type RevokeRequest struct {
+	ClientID     string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	ClientSecret string `protobuf:"bytes,2,opt,name=client_secret,json=clientSecret,proto3" json:"client_secret,omitempty"`
+	AccessToken  string `protobuf:"bytes,3,opt,name=access_token,json=accessToken,proto3" json:"access_token,omitempty"`
+	RefreshToken string `protobuf:"bytes,4,opt,name=refresh_token,json=refreshToken,proto3" json:"refresh_token,omitempty"`
+}
+
+func (m *RevokeRequest) Reset()         { *m = RevokeRequest{} }
+func (m *RevokeRequest) String() string { return proto.CompactTextString(m) }
+func (*RevokeRequest) ProtoMessage()    {}
+
+// RevokeResponse is sso.providerdriver.v1.RevokeResponse.
+type RevokeResponse struct{}
+
+func (m *RevokeResponse) Reset()         { *m = RevokeResponse{} }
+func (m *RevokeResponse) String() string { return proto.CompactTextString(m) }
+func (*RevokeResponse) ProtoMessage()    {}
@@ -0,0 +1,96 @@
+//go:build go1.18
+// +build go1.18
+
+package signature
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FuzzVerify verifies requests with arbitrary signature headers and bodies, which anyone able to
+// reach an upstream controls.
+func FuzzVerify(f *testing.F) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		f.Fatalf("unexpected error generating key: %s", err)
+	}
+	edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		f.Fatalf("unexpected error generating key: %s", err)
+	}
+	now := time.Unix(1500000000, 0)
+	v := newVerifier()
+	v.keys["rsa"] = &testKey.PublicKey
+	v.keys["ec"] = &ecKey.PublicKey
+	v.keys["ed"] = edPublicKey
+	v.now = func() time.Time { return now }
+
+	f.Add("rsa", AlgorithmRS256, "c2ln", "", "", "")
+	f.Add("ec", AlgorithmES256, "MAYCAQECAQE=", "1500000000", bodyDigest("body"), "body")
+	f.Add("ed", AlgorithmEdDSA, "c2ln", "1500000000", UnsignedPayload, "")
+	f.Add("ec", "", "MAYCAf8CAf8=", "-1", "", "body")
+	f.Fuzz(func(t *testing.T, kid, algorithm, sig, timestamp, digest, body string) {
+		req := httptest.NewRequest("POST", "https://foo.sso.example.com/path", strings.NewReader(body))
+		req.Header.Set(KeyIDHeader, kid)
+		req.Header.Set(AlgorithmHeader, algorithm)
+		req.Header.Set(SignatureHeader, sig)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(ContentSHA256Header, digest)
+		if err := v.Verify(req); err == nil {
+			t.Errorf("verified a request that wasn't signed: %v", req.Header)
+		}
+	})
+}
+
+// FuzzJWKPublicKey decodes arbitrary JSON Web Keys, which services fetch from sso_proxy.
+func FuzzJWKPublicKey(f *testing.F) {
+	jwk, err := NewJWK("test-kid", &testKey.PublicKey)
+	if err != nil {
+		f.Fatalf("unexpected error encoding key: %s", err)
+	}
+	encoded, err := json.Marshal(jwk)
+	if err != nil {
+		f.Fatalf("unexpected error marshaling key: %s", err)
+	}
+	f.Add(encoded)
+	f.Add([]byte(`{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}`))
+	f.Add([]byte(`{"kty":"OKP","crv":"Ed25519","x":"AQ"}`))
+	f.Add([]byte(`{"kty":"RSA","n":"AQ","e":"AA"}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		jwk := JWK{}
+		if err := json.Unmarshal(data, &jwk); err != nil {
+			return
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return
+		}
+		if _, err := NewJWK(jwk.KeyID, key); err != nil {
+			t.Errorf("decoded key %+v could not be encoded again: %v", jwk, err)
+		}
+	})
+}
+
+// FuzzParsePublicKey parses arbitrary PEM encoded keys.
+func FuzzParsePublicKey(f *testing.F) {
+	f.Add("-----BEGIN PUBLIC KEY-----\nMCowBQYDK2VwAyEAGb9ECWmEzf6FQbrBZ9w7lshQhqowtrbLDFw4rXAxZuE=\n-----END PUBLIC KEY-----\n")
+	f.Add("-----BEGIN RSA PUBLIC KEY-----\nMAA=\n-----END RSA PUBLIC KEY-----\n")
+	f.Add("not a key")
+	f.Fuzz(func(t *testing.T, keyPEM string) {
+		key, err := ParsePublicKey(keyPEM)
+		if err != nil {
+			return
+		}
+		if _, err := Algorithm(key); err != nil {
+			t.Errorf("parsed a key of an unsupported type %T", key)
+		}
+	})
+}
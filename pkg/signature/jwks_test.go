@@ -0,0 +1,84 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJWKRoundTrip(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %s", err)
+	}
+	edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %s", err)
+	}
+
+	testCases := []struct {
+		name        string
+		key         crypto.PublicKey
+		wantKeyType string
+		wantAlg     string
+	}{
+		{name: "RSA", key: &testKey.PublicKey, wantKeyType: "RSA", wantAlg: AlgorithmRS256},
+		{name: "ECDSA", key: &ecKey.PublicKey, wantKeyType: "EC", wantAlg: AlgorithmES256},
+		{name: "Ed25519", key: edPublicKey, wantKeyType: "OKP", wantAlg: AlgorithmEdDSA},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			jwk, err := NewJWK("test-kid", tc.key)
+			if err != nil {
+				t.Fatalf("unexpected error encoding key: %s", err)
+			}
+			if jwk.KeyType != tc.wantKeyType || jwk.Algorithm != tc.wantAlg || jwk.Use != "sig" {
+				t.Errorf("unexpected key parameters %+v", jwk)
+			}
+
+			encoded, err := json.Marshal(&JWKS{Keys: []JWK{jwk}})
+			if err != nil {
+				t.Fatalf("unexpected error marshaling key set: %s", err)
+			}
+			jwks := &JWKS{}
+			if err := json.Unmarshal(encoded, jwks); err != nil {
+				t.Fatalf("unexpected error unmarshaling key set: %s", err)
+			}
+
+			key, err := jwks.Keys[0].PublicKey()
+			if err != nil {
+				t.Fatalf("unexpected error decoding key: %s", err)
+			}
+			if !reflect.DeepEqual(tc.key, key) {
+				t.Errorf("want key %v, got %v", tc.key, key)
+			}
+		})
+	}
+}
+
+func TestJWKInvalidKey(t *testing.T) {
+	testCases := []struct {
+		name string
+		jwk  JWK
+	}{
+		{name: "unknown key type", jwk: JWK{KeyType: "oct"}},
+		{name: "unsupported curve", jwk: JWK{KeyType: "EC", Curve: "P-384", X: "AQ", Y: "AQ"}},
+		{name: "point not on the curve", jwk: JWK{KeyType: "EC", Curve: "P-256", X: "AQ", Y: "AQ"}},
+		{name: "short Ed25519 key", jwk: JWK{KeyType: "OKP", Curve: "Ed25519", X: "AQ"}},
+		{name: "missing RSA modulus", jwk: JWK{KeyType: "RSA", E: "AQAB"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.jwk.PublicKey(); err == nil {
+				t.Errorf("expected an error decoding %+v", tc.jwk)
+			}
+		})
+	}
+}
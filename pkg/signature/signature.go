@@ -0,0 +1,350 @@
+// Package signature verifies the Sso-Signature of requests proxied by sso_proxy, so that
+// upstream services can check that a request came from sso_proxy and wasn't tampered with.
+//
+// sso_proxy signs a representation of each request with its RSA, ECDSA P-256, or Ed25519 private
+// key, and publishes the public keys at /oauth2/v1/certs of any upstream it proxies, as a JSON
+// object of key IDs to PEM encoded keys, and as a JSON Web Key Set at /admin/jwks of its admin
+// port. A service fetches the keys once, builds a Verifier, and verifies each request:
+//
+//	verifier, err := signature.NewVerifier(keys)
+//	...
+//	if err := verifier.Verify(req); err != nil {
+//		http.Error(rw, "invalid signature", http.StatusUnauthorized)
+//		return
+//	}
+//
+// When sso_proxy sets REQUEST_SIGNATURE_DIGEST, signatures cover a timestamp and the SHA-256
+// digest of the body, rather than the body itself. The Verifier rejects signatures whose
+// timestamp is older than MaxAge, which bounds how long a captured request can be replayed for.
+// Services that must reject every replay should also remember the signatures they accept until
+// they expire, and reject any signature they've already seen.
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers set by sso_proxy on signed requests.
+const (
+	// SignatureHeader is the web safe base64 encoded signature of the request.
+	SignatureHeader = "Sso-Signature"
+	// KeyIDHeader is the ID of the key the request was signed with.
+	KeyIDHeader = "kid"
+	// AlgorithmHeader is the algorithm of the signature, which depends on the type of the key
+	// the request was signed with. It's one of AlgorithmRS256, AlgorithmES256, or AlgorithmEdDSA.
+	AlgorithmHeader = "Sso-Signature-Algorithm"
+	// TimestampHeader is the unix time, in seconds, at which the request was signed.
+	TimestampHeader = "Sso-Signature-Timestamp"
+	// ContentSHA256Header is the hex encoded SHA-256 digest of the request body, or
+	// UnsignedPayload if the body was too large to be hashed.
+	ContentSHA256Header = "Sso-Content-Sha256"
+)
+
+// Signature algorithms, named as in JSON Web Algorithms. The signed message is always the
+// SHA-256 hash of the request's representation.
+const (
+	// AlgorithmRS256 is an RSASSA-PKCS1-v1_5 signature of an RSA key.
+	AlgorithmRS256 = "RS256"
+	// AlgorithmES256 is an ASN.1 DER encoded ECDSA signature of a P-256 key.
+	AlgorithmES256 = "ES256"
+	// AlgorithmEdDSA is an Ed25519 signature of an Ed25519 key.
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// UnsignedPayload is the value of the ContentSHA256Header of requests whose body is larger
+// than sso_proxy's REQUEST_SIGNATURE_MAX_BODY_SIZE, which is sent without being hashed.
+const UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// SignedHeaders are the headers whose values are part of the signed representation of a
+// request, in order.
+var SignedHeaders = []string{
+	"Content-Length",
+	"Content-Md5",
+	"Content-Type",
+	"Date",
+	"Authorization",
+	"X-Forwarded-User",
+	"X-Forwarded-Email",
+	"X-Forwarded-Groups",
+	"X-Forwarded-Access-Token",
+	"Cookie",
+}
+
+// Errors returned by Verify.
+var (
+	ErrMissingSignature = errors.New("missing signature")
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrMissingTimestamp = errors.New("missing signature timestamp")
+	ErrExpired          = errors.New("signature timestamp is outside the allowed window")
+	ErrBodyDigest       = errors.New("body doesn't match its signed digest")
+	ErrUnsignedPayload  = errors.New("body isn't signed")
+)
+
+// DefaultMaxAge is the default MaxAge of a Verifier.
+const DefaultMaxAge = 5 * time.Minute
+
+// Verifier verifies the signatures of requests with the public keys of sso_proxy.
+type Verifier struct {
+	keys map[string]crypto.PublicKey
+
+	// MaxAge is how far the timestamp of a signature may be from the current time, in either
+	// direction to allow for clock skew.
+	MaxAge time.Duration
+	// RequireTimestamp rejects signatures without a timestamp and body digest, i.e. those of
+	// sso_proxy instances that don't set REQUEST_SIGNATURE_DIGEST.
+	RequireTimestamp bool
+	// RequireSignedPayload rejects requests whose body was too large for sso_proxy to hash.
+	RequireSignedPayload bool
+
+	now func() time.Time
+}
+
+// NewVerifier returns a Verifier of the keys published by sso_proxy at /oauth2/v1/certs, given
+// as a map of key IDs to PEM encoded public keys.
+func NewVerifier(keys map[string]string) (*Verifier, error) {
+	v := newVerifier()
+	for kid, keyPEM := range keys {
+		key, err := ParsePublicKey(keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %v", kid, err)
+		}
+		v.keys[kid] = key
+	}
+	return v, nil
+}
+
+// NewVerifierFromJWKS returns a Verifier of the keys published by sso_proxy at /admin/jwks.
+func NewVerifierFromJWKS(jwks *JWKS) (*Verifier, error) {
+	v := newVerifier()
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %v", jwk.KeyID, err)
+		}
+		v.keys[jwk.KeyID] = key
+	}
+	return v, nil
+}
+
+func newVerifier() *Verifier {
+	return &Verifier{
+		keys:   map[string]crypto.PublicKey{},
+		MaxAge: DefaultMaxAge,
+		now:    time.Now,
+	}
+}
+
+// ParsePublicKey parses a PEM encoded public key, as published by sso_proxy: a PKCS1 RSA public
+// key, or a PKIX ECDSA P-256 or Ed25519 public key.
+func ParsePublicKey(keyPEM string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("could not read PEM block from public key")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Algorithm(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Algorithm returns the signature algorithm of a public or private key, or an error if sso_proxy
+// doesn't support its type.
+func Algorithm(key crypto.PublicKey) (string, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return AlgorithmRS256, nil
+	case *ecdsa.PublicKey:
+		if key.Curve != elliptic.P256() {
+			return "", fmt.Errorf("unsupported ECDSA curve %s, only P-256 is supported", key.Curve.Params().Name)
+		}
+		return AlgorithmES256, nil
+	case *ecdsa.PrivateKey:
+		return Algorithm(&key.PublicKey)
+	case ed25519.PublicKey, ed25519.PrivateKey:
+		return AlgorithmEdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T, must be RSA, ECDSA P-256, or Ed25519", key)
+	}
+}
+
+// Verify returns nil if the request was signed by one of the Verifier's keys, and its timestamp
+// and body digest, if present, are valid. The body of the request is read and replaced, so that
+// it can still be read by the caller.
+func (v *Verifier) Verify(req *http.Request) error {
+	sig, err := base64.URLEncoding.DecodeString(req.Header.Get(SignatureHeader))
+	if err != nil || len(sig) == 0 {
+		return ErrMissingSignature
+	}
+	key, ok := v.keys[req.Header.Get(KeyIDHeader)]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	var repr string
+	if timestamp := req.Header.Get(TimestampHeader); timestamp != "" {
+		if err := v.verifyTimestamp(timestamp); err != nil {
+			return err
+		}
+		digest := req.Header.Get(ContentSHA256Header)
+		if err := v.verifyBody(req, digest); err != nil {
+			return err
+		}
+		repr = DigestRepresentation(req, timestamp, digest)
+	} else {
+		if v.RequireTimestamp {
+			return ErrMissingTimestamp
+		}
+		repr, err = Representation(req)
+		if err != nil {
+			return err
+		}
+	}
+
+	hash := sha256.Sum256([]byte(repr))
+	if !verifySignature(key, req.Header.Get(AlgorithmHeader), hash[:], sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// verifySignature returns true if sig is a valid signature of the hash by the key. The algorithm
+// of signatures made by sso_proxy versions that didn't send one is implied by the key's type, but
+// it must match the key's type if it's sent.
+func verifySignature(key crypto.PublicKey, algorithm string, hash, sig []byte) bool {
+	if want, err := Algorithm(key); err != nil || (algorithm != "" && algorithm != want) {
+		return false
+	}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash, sig) == nil
+	case *ecdsa.PublicKey:
+		var esig struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &esig); err != nil || len(rest) != 0 {
+			return false
+		}
+		return ecdsa.Verify(key, hash, esig.R, esig.S)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, hash, sig)
+	default:
+		return false
+	}
+}
+
+func (v *Verifier) verifyTimestamp(timestamp string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrMissingTimestamp
+	}
+	age := v.now().Sub(time.Unix(seconds, 0))
+	if age > v.MaxAge || age < -v.MaxAge {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (v *Verifier) verifyBody(req *http.Request, digest string) error {
+	if digest == UnsignedPayload {
+		if v.RequireSignedPayload {
+			return ErrUnsignedPayload
+		}
+		return nil
+	}
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	if digest != hex.EncodeToString(sum[:]) {
+		return ErrBodyDigest
+	}
+	return nil
+}
+
+// Representation returns the representation of a request signed by sso_proxy without a
+// timestamp: the newline separated values of its SignedHeaders, its URL, and its body.
+func Representation(req *http.Request) (string, error) {
+	entries := headersAndURL(req)
+	if req.Body != nil {
+		body, err := readBody(req)
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, string(body))
+	}
+	return strings.Join(entries, "\n"), nil
+}
+
+// DigestRepresentation returns the representation of a request signed by sso_proxy with a
+// timestamp: the newline separated values of its SignedHeaders, its URL, the timestamp, and the
+// digest of its body.
+func DigestRepresentation(req *http.Request, timestamp, digest string) string {
+	entries := append(headersAndURL(req), timestamp, digest)
+	return strings.Join(entries, "\n")
+}
+
+// headersAndURL returns the values of the request's SignedHeaders, each joined by commas and
+// skipping empty ones, followed by the path, query, and fragment of its URL.
+func headersAndURL(req *http.Request) []string {
+	entries := []string{}
+	for _, hdr := range SignedHeaders {
+		values := []string{}
+		for _, value := range req.Header[hdr] {
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+		if len(values) > 0 {
+			entries = append(entries, strings.Join(values, ","))
+		}
+	}
+
+	url := req.URL.Path
+	if len(req.URL.RawQuery) > 0 {
+		url += "?" + req.URL.RawQuery
+	}
+	if len(req.URL.Fragment) > 0 {
+		url += "#" + req.URL.Fragment
+	}
+	return append(entries, url)
+}
+
+// readBody reads the request's body, and replaces it so that it can be read again.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
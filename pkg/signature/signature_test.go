@@ -0,0 +1,282 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+func testVerifier(t *testing.T, now time.Time) *Verifier {
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(&testKey.PublicKey),
+	})
+	v, err := NewVerifier(map[string]string{"test-kid": string(keyPEM)})
+	if err != nil {
+		t.Fatalf("unexpected error creating verifier: %s", err)
+	}
+	v.now = func() time.Time { return now }
+	return v
+}
+
+// sign signs the request as sso_proxy does, with a timestamp and body digest if the timestamp
+// isn't zero.
+func sign(t *testing.T, req *http.Request, timestamp time.Time, digest string) {
+	var repr string
+	if timestamp.IsZero() {
+		var err error
+		repr, err = Representation(req)
+		if err != nil {
+			t.Fatalf("unexpected error computing representation: %s", err)
+		}
+	} else {
+		ts := strconv.FormatInt(timestamp.Unix(), 10)
+		req.Header.Set(TimestampHeader, ts)
+		req.Header.Set(ContentSHA256Header, digest)
+		repr = DigestRepresentation(req, ts, digest)
+	}
+	hash := sha256.Sum256([]byte(repr))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, testKey, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatalf("unexpected error signing: %s", err)
+	}
+	req.Header.Set(SignatureHeader, base64.URLEncoding.EncodeToString(sig))
+	req.Header.Set(KeyIDHeader, "test-kid")
+}
+
+func bodyDigest(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	body := "something\nor other"
+
+	testCases := []struct {
+		name      string
+		timestamp time.Time
+		digest    string
+		tamper    func(*http.Request)
+		configure func(*Verifier)
+		wantErr   error
+	}{
+		{
+			name: "signed body",
+		},
+		{
+			name:      "signed digest and timestamp",
+			timestamp: now.Add(-time.Minute),
+			digest:    bodyDigest(body),
+		},
+		{
+			name:      "unsigned payload",
+			timestamp: now,
+			digest:    UnsignedPayload,
+		},
+		{
+			name:      "unsigned payload required to be signed",
+			timestamp: now,
+			digest:    UnsignedPayload,
+			configure: func(v *Verifier) { v.RequireSignedPayload = true },
+			wantErr:   ErrUnsignedPayload,
+		},
+		{
+			name:      "missing timestamp when required",
+			configure: func(v *Verifier) { v.RequireTimestamp = true },
+			wantErr:   ErrMissingTimestamp,
+		},
+		{
+			name:      "expired timestamp",
+			timestamp: now.Add(-10 * time.Minute),
+			digest:    bodyDigest(body),
+			wantErr:   ErrExpired,
+		},
+		{
+			name:      "timestamp in the future",
+			timestamp: now.Add(10 * time.Minute),
+			digest:    bodyDigest(body),
+			wantErr:   ErrExpired,
+		},
+		{
+			name:      "tampered body",
+			timestamp: now,
+			digest:    bodyDigest(body),
+			tamper: func(req *http.Request) {
+				req.Body = ioutil.NopCloser(strings.NewReader("something else"))
+			},
+			wantErr: ErrBodyDigest,
+		},
+		{
+			name:      "tampered timestamp",
+			timestamp: now.Add(-time.Minute),
+			digest:    bodyDigest(body),
+			tamper: func(req *http.Request) {
+				req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:      "stripped timestamp",
+			timestamp: now,
+			digest:    bodyDigest(body),
+			tamper: func(req *http.Request) {
+				req.Header.Del(TimestampHeader)
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "tampered header",
+			tamper: func(req *http.Request) {
+				req.Header.Set("X-Forwarded-Email", "mallory@example.com")
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "unknown key",
+			tamper: func(req *http.Request) {
+				req.Header.Set(KeyIDHeader, "other-kid")
+			},
+			wantErr: ErrUnknownKey,
+		},
+		{
+			name: "missing signature",
+			tamper: func(req *http.Request) {
+				req.Header.Del(SignatureHeader)
+			},
+			wantErr: ErrMissingSignature,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "https://foo.sso.example.com/path?query", strings.NewReader(body))
+			req.Header.Set("X-Forwarded-Email", "octoboi@example.com")
+			req.Header.Add("X-Forwarded-Groups", "molluscs")
+			req.Header.Add("X-Forwarded-Groups", "security_applications")
+			sign(t, req, tc.timestamp, tc.digest)
+			if tc.tamper != nil {
+				tc.tamper(req)
+			}
+
+			v := testVerifier(t, now)
+			if tc.configure != nil {
+				tc.configure(v)
+			}
+			err := v.Verify(req)
+			if err != tc.wantErr {
+				t.Fatalf("want error %v, got %v", tc.wantErr, err)
+			}
+
+			// the body can still be read after it's verified
+			if err == nil {
+				read, _ := ioutil.ReadAll(req.Body)
+				if string(read) != body {
+					t.Errorf("want body %q, got %q", body, read)
+				}
+			}
+		})
+	}
+}
+
+func TestNewVerifierInvalidKey(t *testing.T) {
+	_, err := NewVerifier(map[string]string{"test-kid": "not a key"})
+	if err == nil {
+		t.Fatalf("expected an error for an invalid key")
+	}
+}
+
+func TestVerifyKeyTypes(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %s", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %s", err)
+	}
+
+	testCases := []struct {
+		name      string
+		key       crypto.Signer
+		opts      crypto.SignerOpts
+		algorithm string
+		wantErr   error
+	}{
+		{name: "RSA", key: testKey, opts: crypto.SHA256, algorithm: AlgorithmRS256},
+		{name: "RSA without an algorithm", key: testKey, opts: crypto.SHA256},
+		{name: "ECDSA", key: ecKey, opts: crypto.SHA256, algorithm: AlgorithmES256},
+		{name: "Ed25519", key: edKey, opts: crypto.Hash(0), algorithm: AlgorithmEdDSA},
+		{name: "mismatched algorithm", key: ecKey, opts: crypto.SHA256, algorithm: AlgorithmRS256, wantErr: ErrInvalidSignature},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			der, err := x509.MarshalPKIXPublicKey(tc.key.Public())
+			if err != nil {
+				t.Fatalf("unexpected error marshaling key: %s", err)
+			}
+			keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+			v, err := NewVerifier(map[string]string{"test-kid": string(keyPEM)})
+			if err != nil {
+				t.Fatalf("unexpected error creating verifier: %s", err)
+			}
+
+			req := httptest.NewRequest("GET", "https://foo.sso.example.com/path", nil)
+			req.Header.Set("X-Forwarded-Email", "octoboi@example.com")
+			repr, _ := Representation(req)
+			hash := sha256.Sum256([]byte(repr))
+			sig, err := tc.key.Sign(rand.Reader, hash[:], tc.opts)
+			if err != nil {
+				t.Fatalf("unexpected error signing: %s", err)
+			}
+			req.Header.Set(SignatureHeader, base64.URLEncoding.EncodeToString(sig))
+			req.Header.Set(KeyIDHeader, "test-kid")
+			if tc.algorithm != "" {
+				req.Header.Set(AlgorithmHeader, tc.algorithm)
+			}
+
+			if err := v.Verify(req); err != tc.wantErr {
+				t.Fatalf("want error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParsePublicKeyUnsupportedCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error generating key: %s", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("unexpected error marshaling key: %s", err)
+	}
+	_, err = ParsePublicKey(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	if err == nil {
+		t.Fatalf("expected an error for a P-384 key")
+	}
+}